@@ -0,0 +1,117 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+
+	"github.com/bketelsen/phukit/pkg"
+	"github.com/spf13/cobra"
+)
+
+var (
+	agentListen   string
+	agentCert     string
+	agentKey      string
+	agentClientCA string
+	agentMetrics  string
+)
+
+var agentCmd = &cobra.Command{
+	Use:   "agent",
+	Short: "Serve the gRPC management API for fleet controllers",
+	Long: `Run phukit as an agent that a central controller drives over gRPC.
+
+The API is defined in api/agent/v1/agent.proto:
+
+  Status    Installed image, digest and active slot
+  Update    Start an update and stream its progress until the result
+  Rollback  Make the other root the default boot entry
+  Watch     Stream the progress of every operation
+
+The agent only accepts clients that present a certificate signed by the
+--tls-client-ca authority (mutual TLS). Only one operation runs at a time; a
+second call fails with ABORTED. Failed calls carry the phukit error code in
+the phukit-error-code trailer.
+
+An update keeps running if the controller disconnects; reconnect with Watch
+to follow it. With --metrics, Prometheus metrics are served on /metrics of
+that address as in phukit daemon.
+
+Example:
+  phukit agent --listen :9443 \
+    --tls-cert /etc/phukit/agent/server.crt \
+    --tls-key /etc/phukit/agent/server.key \
+    --tls-client-ca /etc/phukit/agent/client-ca.crt`,
+	RunE: runAgent,
+}
+
+func init() {
+	rootCmd.AddCommand(agentCmd)
+
+	agentCmd.Flags().StringVar(&agentListen, "listen", ":9443", "Address to serve the gRPC API on")
+	agentCmd.Flags().StringVar(&agentCert, "tls-cert", "/etc/phukit/agent/server.crt", "Server certificate (PEM)")
+	agentCmd.Flags().StringVar(&agentKey, "tls-key", "/etc/phukit/agent/server.key", "Server private key (PEM)")
+	agentCmd.Flags().StringVar(&agentClientCA, "tls-client-ca", "/etc/phukit/agent/client-ca.crt", "CA that signs client certificates (PEM)")
+	agentCmd.Flags().StringVar(&agentMetrics, "metrics", "", "Serve Prometheus metrics on this address (e.g. :9100)")
+}
+
+func runAgent(cmd *cobra.Command, args []string) error {
+	if err := applyGlobalFlags(); err != nil {
+		return err
+	}
+	if progressSocket != nil {
+		defer func() { _ = progressSocket.Close() }()
+	}
+
+	tlsConfig, err := pkg.NewAgentTLSConfig(agentCert, agentKey, agentClientCA)
+	if err != nil {
+		return err
+	}
+
+	// Events go to the API clients, not the terminal
+	manager := pkg.NewManager()
+	pkg.SetProgressReporter(manager)
+	if progressSocket != nil {
+		defer manager.Subscribe(progressSocket)()
+	}
+
+	lis, err := net.Listen("tcp", agentListen)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", agentListen, err)
+	}
+
+	ctx := cmd.Context()
+	server := pkg.NewAgentGRPCServer(ctx, manager, tlsConfig)
+	serveErr := make(chan error, 1)
+	go func() { serveErr <- server.Serve(lis) }()
+	fmt.Printf("Serving the gRPC agent API on %s\n", lis.Addr())
+	pkg.Logger().Info("agent started", "address", lis.Addr().String())
+
+	var metricsServers []*http.Server
+	metricsErr := make(chan error, 1)
+	if agentMetrics != "" {
+		metricsServer, err := serveMetrics(agentMetrics, manager, metricsErr)
+		if err != nil {
+			server.Stop()
+			return err
+		}
+		metricsServers = append(metricsServers, metricsServer)
+	}
+
+	var runErr error
+	select {
+	case err := <-serveErr:
+		runErr = fmt.Errorf("gRPC server failed: %w", err)
+	case err := <-metricsErr:
+		runErr = fmt.Errorf("metrics server failed: %w", err)
+	case <-ctx.Done():
+	}
+	fmt.Println("Shutting down...")
+	// Running operations see the cancelled context and clean up; streams end
+	// with UNAVAILABLE
+	manager.Wait()
+	server.GracefulStop()
+	return errors.Join(runErr, shutdownHTTP(metricsServers))
+}
@@ -0,0 +1,155 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/bketelsen/phukit/pkg"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var (
+	backupRoot           string
+	backupPaths          []string
+	backupPassphraseFile string
+
+	restoreRoot           string
+	restorePassphraseFile string
+	restoreForce          bool
+)
+
+var backupCmd = &cobra.Command{
+	Use:   "backup DESTINATION",
+	Short: "Back up /etc and selected /var paths to an archive",
+	Long: `Write the configuration and state of this system that no image brings back
+to a zstd-compressed tar archive, for disaster recovery with 'phukit restore'
+on a fresh install:
+
+  - /etc as it is now (/var/etc.backup only copies it at every update)
+  - the directories of /var given with --path or the backup-paths key of
+    the configuration file, such as a database directory
+
+A manifest in the archive records the host, the image it was deployed from
+and the backed up paths. Filesystems mounted below the paths are left out.
+
+The archive holds /etc/shadow and is only readable by root. With
+--passphrase-file it is encrypted with AES-256-GCM under a key derived from
+the first line of the file; the same file is needed to restore it.
+
+Example:
+  phukit backup /media/usb/web01.tar.zst
+  phukit backup --path /var/lib/postgresql --path /var/www /srv/backup/web01.tar.zst
+  phukit backup --passphrase-file /root/backup.key /mnt/nfs/web01.tar.zst.enc`,
+	Args: cobra.ExactArgs(1),
+	RunE: runBackup,
+}
+
+var restoreCmd = &cobra.Command{
+	Use:   "restore SOURCE",
+	Short: "Restore /etc and /var paths from a backup",
+	Long: `Write the files of a 'phukit backup' archive back onto this system,
+typically a fresh install of the image the backup was taken on, replacing
+what is there. Reboot afterwards so every service reads the restored
+configuration, and stop the services using the restored /var paths first.
+
+Files describing the install rather than the host, such as /etc/fstab,
+/etc/crypttab and /etc/phukit/config.json, which name the filesystems of the
+old install, are kept from the new one. Files of /etc the backup lacks are
+kept as well.
+
+With --root, the files are restored into a mounted root; mount its /var
+below it first if the backup holds /var paths.
+
+Example:
+  phukit restore /media/usb/web01.tar.zst
+  phukit restore --passphrase-file /root/backup.key /mnt/nfs/web01.tar.zst.enc
+  phukit restore --dry-run /media/usb/web01.tar.zst  # Only check and count the files`,
+	Args: cobra.ExactArgs(1),
+	RunE: runRestore,
+}
+
+func init() {
+	rootCmd.AddCommand(backupCmd)
+	rootCmd.AddCommand(restoreCmd)
+
+	backupCmd.Flags().StringVar(&backupRoot, "root", "", "Mounted root to back up (default: the booted root)")
+	backupCmd.Flags().StringArrayVar(&backupPaths, "path", nil, "Directory below /var to back up along with /etc (can be specified multiple times)")
+	backupCmd.Flags().StringVar(&backupPassphraseFile, "passphrase-file", "", "Encrypt the archive with the passphrase in this file")
+
+	restoreCmd.Flags().StringVar(&restoreRoot, "root", "", "Mounted root to restore into (default: the booted root)")
+	restoreCmd.Flags().StringVar(&restorePassphraseFile, "passphrase-file", "", "Passphrase of an encrypted archive")
+	restoreCmd.Flags().BoolVarP(&restoreForce, "force", "f", false, "Skip the confirmation prompt")
+}
+
+// backupPassphrase reads the passphrase of the file given with the
+// --passphrase-file flag of cmd, or the backup-passphrase-file key
+func backupPassphrase(cmd *cobra.Command, path string) (string, error) {
+	if !cmd.Flags().Changed("passphrase-file") {
+		path = viper.GetString("backup-passphrase-file")
+	}
+	if path == "" {
+		return "", nil
+	}
+	return pkg.ReadPassphraseFile(path)
+}
+
+func runBackup(cmd *cobra.Command, args []string) error {
+	return runOperation(cmd, "backup", func(ctx context.Context) error {
+		paths := backupPaths
+		if !cmd.Flags().Changed("path") {
+			paths = viper.GetStringSlice("backup-paths")
+		}
+		passphrase, err := backupPassphrase(cmd, backupPassphraseFile)
+		if err != nil {
+			return err
+		}
+
+		result, err := pkg.BackupSystem(ctx, pkg.BackupOptions{
+			Root:        backupRoot,
+			Destination: args[0],
+			Paths:       paths,
+			Passphrase:  passphrase,
+			DryRun:      viper.GetBool("dry-run"),
+		})
+		if err != nil {
+			return err
+		}
+		if !viper.GetBool("dry-run") {
+			fmt.Printf("Backed up %d files to %s\n", result.Files, result.Destination)
+		}
+		return nil
+	})
+}
+
+func runRestore(cmd *cobra.Command, args []string) error {
+	return runOperation(cmd, "restore", func(ctx context.Context) error {
+		passphrase, err := backupPassphrase(cmd, restorePassphraseFile)
+		if err != nil {
+			return err
+		}
+
+		// An update in progress is writing /etc of the new root from this one
+		lock, err := lockOperation(ctx)
+		if err != nil {
+			return err
+		}
+		defer func() { _ = lock.Unlock() }()
+
+		result, err := pkg.RestoreBackup(ctx, pkg.RestoreOptions{
+			Root:       restoreRoot,
+			Source:     args[0],
+			Passphrase: passphrase,
+			Force:      restoreForce,
+			DryRun:     viper.GetBool("dry-run"),
+		})
+		if err != nil {
+			return err
+		}
+		if !viper.GetBool("dry-run") {
+			fmt.Println()
+			fmt.Printf("Restored %d files to %s. Reboot to apply the restored configuration.\n", result.Files, result.Root)
+		}
+		return nil
+	})
+}
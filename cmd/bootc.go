@@ -0,0 +1,169 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/bketelsen/phukit/pkg"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"go.yaml.in/yaml/v3"
+)
+
+var (
+	bootcStatusJSON    bool
+	bootcStatusFormat  string
+	bootcStatusBooted  bool
+	bootcUpgradeCheck  bool
+	bootcUpgradeApply  bool
+	bootcSwitchApply   bool
+	bootcRollbackApply bool
+)
+
+var bootcCmd = &cobra.Command{
+	Use:   "bootc",
+	Short: "bootc-compatible status, upgrade, switch and rollback",
+	Long: `Commands with the arguments and output of bootc, so tools written
+against bootc can manage a phukit system unchanged.
+
+The A/B roots map to bootc deployments: the running root is "booted"; the other
+root is "staged" while it holds an installed update that boots next, and the
+"rollback" otherwise.
+
+When phukit is run under the name "bootc" (for example through a symlink
+/usr/local/bin/bootc -> phukit) these are the top-level commands.
+
+Example:
+  phukit bootc status --json
+  phukit bootc upgrade --check
+  phukit bootc upgrade --apply
+  phukit bootc switch quay.io/example/myimage:v2
+  phukit bootc rollback`,
+}
+
+var bootcStatusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Show the booted, staged and rollback deployments",
+	RunE:  runBootcStatus,
+}
+
+var bootcUpgradeCmd = &cobra.Command{
+	Use:   "upgrade",
+	Short: "Download and stage an update of the booted image",
+	RunE:  runBootcUpgrade,
+}
+
+var bootcSwitchCmd = &cobra.Command{
+	Use:   "switch IMAGE",
+	Short: "Stage a different image to boot next",
+	Args:  cobra.ExactArgs(1),
+	RunE:  runBootcSwitch,
+}
+
+var bootcRollbackCmd = &cobra.Command{
+	Use:   "rollback",
+	Short: "Boot the rollback deployment next",
+	RunE:  runBootcRollback,
+}
+
+func init() {
+	rootCmd.AddCommand(bootcCmd)
+	bootcCmd.AddCommand(bootcStatusCmd, bootcUpgradeCmd, bootcSwitchCmd, bootcRollbackCmd)
+
+	bootcStatusCmd.Flags().BoolVar(&bootcStatusJSON, "json", false, "Output in JSON format (same as --format json)")
+	bootcStatusCmd.Flags().StringVar(&bootcStatusFormat, "format", "", "Output format: humanreadable, yaml or json (default humanreadable on a terminal, yaml otherwise)")
+	bootcStatusCmd.Flags().BoolVar(&bootcStatusBooted, "booted", false, "Only show the booted deployment")
+
+	bootcUpgradeCmd.Flags().BoolVar(&bootcUpgradeCheck, "check", false, "Only check whether an update is available")
+	bootcUpgradeCmd.Flags().BoolVar(&bootcUpgradeApply, "apply", false, "Reboot into the update once it is staged")
+
+	bootcSwitchCmd.Flags().BoolVar(&bootcSwitchApply, "apply", false, "Reboot into the new image once it is staged")
+
+	bootcRollbackCmd.Flags().BoolVar(&bootcRollbackApply, "apply", false, "Reboot into the rollback deployment")
+}
+
+func runBootcStatus(cmd *cobra.Command, args []string) error {
+	format := bootcStatusFormat
+	if bootcStatusJSON {
+		format = "json"
+	}
+	if format == "" {
+		format = "yaml"
+		if pkg.IsTerminal(os.Stdout) {
+			format = "humanreadable"
+		}
+	}
+
+	device, err := pkg.GetCurrentBootDevice()
+	if err != nil {
+		return fmt.Errorf("failed to find boot device: %w", err)
+	}
+	host, err := pkg.GetBootcHost(device)
+	if err != nil {
+		return err
+	}
+	if bootcStatusBooted {
+		host.Status.Staged, host.Status.Rollback = nil, nil
+	}
+
+	switch format {
+	case "json":
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(host)
+	case "yaml":
+		enc := yaml.NewEncoder(os.Stdout)
+		enc.SetIndent(2)
+		if err := enc.Encode(host); err != nil {
+			return err
+		}
+		return enc.Close()
+	case "humanreadable":
+		host.PrintHumanReadable(os.Stdout)
+		return nil
+	}
+	return fmt.Errorf("unsupported format: %s (supported: humanreadable, yaml, json)", format)
+}
+
+func runBootcUpgrade(cmd *cobra.Command, args []string) error {
+	return runOperation(cmd, "update", func(ctx context.Context) error {
+		opts := updateOptions{checkOnly: bootcUpgradeCheck, varLockTimeout: pkg.DefaultVarLockTimeout, assumeYes: true}
+		staged, err := updateSystem(ctx, opts)
+		if err != nil {
+			return err
+		}
+		if bootcUpgradeApply && staged {
+			return pkg.Reboot(ctx, viper.GetBool("dry-run"))
+		}
+		return nil
+	})
+}
+
+func runBootcSwitch(cmd *cobra.Command, args []string) error {
+	return runOperation(cmd, "update", func(ctx context.Context) error {
+		opts := updateOptions{image: args[0], varLockTimeout: pkg.DefaultVarLockTimeout, assumeYes: true}
+		staged, err := updateSystem(ctx, opts)
+		if err != nil {
+			return err
+		}
+		if bootcSwitchApply && staged {
+			return pkg.Reboot(ctx, viper.GetBool("dry-run"))
+		}
+		return nil
+	})
+}
+
+func runBootcRollback(cmd *cobra.Command, args []string) error {
+	return runOperation(cmd, "rollback", func(ctx context.Context) error {
+		// bootc rollback never touches /var
+		if err := rollbackSystem(ctx, "", true, true); err != nil {
+			return err
+		}
+		if bootcRollbackApply {
+			return pkg.Reboot(ctx, viper.GetBool("dry-run"))
+		}
+		return nil
+	})
+}
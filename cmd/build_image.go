@@ -0,0 +1,174 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/bketelsen/phukit/pkg"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var (
+	buildImageImage            string
+	buildImageOutput           string
+	buildImageFormat           string
+	buildImageSize             int64
+	buildImageSkipPull         bool
+	buildImageKernelArgs       []string
+	buildImageFilesystem       string
+	buildImageVerity           bool
+	buildImageComposefs        bool
+	buildImageWritableUsr      bool
+	buildImageCloud            string
+	buildImageUpload           string
+	buildImageFirstboot        bool
+	buildImageFirstbootScripts []string
+	buildImageGrow             bool
+	buildImageMountOptions     []string
+	buildImageMountBy          string
+	buildImageVarMount         string
+)
+
+var buildImageCmd = &cobra.Command{
+	Use:   "build-image",
+	Short: "Build a bootable disk image from a bootc container",
+	Long: `Build a bootable VM disk image from a bootc compatible container image.
+
+The image is installed exactly like 'phukit install', with the same partition
+layout and A/B roots, but to a sparse file attached to a loop device instead
+of a disk. The result is written as a raw, qcow2, vmdk, vhd or tar.gz image;
+qcow2, vmdk and vhd need qemu-img. The format defaults to the output file's
+extension.
+
+--cloud builds for a cloud (aws, azure or gce): it picks the format the cloud
+imports, adds its serial console kernel arguments, enables cloud-init and the
+cloud's guest agent if the image ships them, and grows /var to fill the disk
+on first boot. --upload then copies the result to the cloud's object storage
+with its CLI (aws, az or gcloud), ready to register as an image.
+
+--grow grows /var into the rest of the disk the image is written to, and the
+root and /var filesystems into their partitions, on boot; --cloud implies it.
+
+Nothing outside the loop device is modified. Requires root.
+
+Example:
+  phukit build-image --image quay.io/example/myimage:latest --output disk.raw
+  phukit build-image --image quay.io/example/myimage:latest --output disk.qcow2
+  phukit build-image --image localhost/myimage --output disk.vmdk --size 64 --karg console=ttyS0
+  phukit build-image --image quay.io/example/myimage:latest --output disk.vhd --cloud azure
+  phukit build-image --image quay.io/example/myimage:latest --output disk.tar.gz --cloud gce --upload gs://bucket/disk.tar.gz`,
+	Args: cobra.NoArgs,
+	RunE: runBuildImage,
+}
+
+func init() {
+	rootCmd.AddCommand(buildImageCmd)
+
+	buildImageCmd.Flags().StringVarP(&buildImageImage, "image", "i", "", "Container image reference (required)")
+	buildImageCmd.Flags().StringVarP(&buildImageOutput, "output", "o", "", "Disk image file to write (required)")
+	buildImageCmd.Flags().StringVar(&buildImageFormat, "format", "", "Disk image format: raw, qcow2, vmdk, vhd or tar.gz (default from the cloud or the output extension, else raw)")
+	buildImageCmd.Flags().Int64Var(&buildImageSize, "size", pkg.DefaultDiskImageSize>>30, "Virtual disk size in GiB")
+	buildImageCmd.Flags().BoolVar(&buildImageSkipPull, "skip-pull", false, "Skip pulling the image (use already pulled image)")
+	buildImageCmd.Flags().StringArrayVarP(&buildImageKernelArgs, "karg", "k", []string{}, "Kernel argument to pass (can be specified multiple times)")
+	buildImageCmd.Flags().StringVarP(&buildImageFilesystem, "filesystem", "f", "ext4", "Filesystem type for root and var partitions (ext4, btrfs)")
+	buildImageCmd.Flags().BoolVar(&buildImageVerity, "verity", false, "Install a read-only root protected by dm-verity (ext4 only)")
+	buildImageCmd.Flags().BoolVar(&buildImageComposefs, "composefs", false, "Store /usr as a composefs image backed by an object store on /var shared by both roots")
+	buildImageCmd.Flags().BoolVar(&buildImageWritableUsr, "writable-usr", false, "Do not mount /usr read-only on the installed system")
+	buildImageCmd.Flags().StringVar(&buildImageCloud, "cloud", "", "Build for a cloud: "+strings.Join(pkg.CloudProfileNames(), ", "))
+	buildImageCmd.Flags().StringVar(&buildImageUpload, "upload", "", "Upload the image to this cloud storage location (requires --cloud)")
+	buildImageCmd.Flags().BoolVar(&buildImageFirstboot, "firstboot", false, "Install a service that grows /var, commits the machine ID and generates SSH host keys on first boot")
+	buildImageCmd.Flags().StringArrayVar(&buildImageFirstbootScripts, "firstboot-script", []string{}, "Script to run once on first boot (implies --firstboot; can be specified multiple times)")
+	buildImageCmd.Flags().BoolVar(&buildImageGrow, "grow", false, "Grow /var into the rest of the disk and the root and /var filesystems into their partitions on boot")
+	buildImageCmd.Flags().StringArrayVar(&buildImageMountOptions, "mount-option", []string{}, "Mount options of the root or /var filesystem, as mountpoint=options (can be specified multiple times)")
+	buildImageCmd.Flags().StringVar(&buildImageMountBy, "mount-by", string(pkg.MountByUUID), "How generated mounts name filesystems: uuid or label")
+	buildImageCmd.Flags().StringVar(&buildImageVarMount, "var-mount", string(pkg.VarMountCmdline), "How /var is mounted: cmdline, fstab or systemd")
+	buildImageCmd.MarkFlagsMutuallyExclusive("verity", "composefs")
+	buildImageCmd.MarkFlagsMutuallyExclusive("verity", "cloud")
+	buildImageCmd.MarkFlagsMutuallyExclusive("verity", "grow")
+
+	_ = buildImageCmd.MarkFlagRequired("image")
+	_ = buildImageCmd.MarkFlagRequired("output")
+}
+
+func runBuildImage(cmd *cobra.Command, args []string) error {
+	return runOperation(cmd, "build-image", func(ctx context.Context) error {
+		if buildImageFilesystem != "ext4" && buildImageFilesystem != "btrfs" {
+			return fmt.Errorf("unsupported filesystem type: %s (supported: ext4, btrfs)", buildImageFilesystem)
+		}
+
+		var profile *pkg.CloudProfile
+		if buildImageCloud != "" {
+			var err error
+			if profile, err = pkg.GetCloudProfile(buildImageCloud); err != nil {
+				return err
+			}
+			if buildImageFormat != "" && buildImageFormat != profile.Format {
+				return fmt.Errorf("%s images must be %s, not %s", profile.Name, profile.Format, buildImageFormat)
+			}
+			if buildImageUpload != "" {
+				if err := profile.CheckUploadDestination(buildImageUpload); err != nil {
+					return err
+				}
+			}
+		} else if buildImageUpload != "" {
+			return fmt.Errorf("--upload requires --cloud")
+		}
+
+		format := buildImageFormat
+		if format == "" {
+			output := strings.ToLower(buildImageOutput)
+			switch {
+			case strings.HasSuffix(output, ".tar.gz"):
+				format = pkg.DiskImageTarGz
+			case filepath.Ext(output) == ".qcow2":
+				format = pkg.DiskImageQcow2
+			case filepath.Ext(output) == ".vmdk":
+				format = pkg.DiskImageVMDK
+			case filepath.Ext(output) == ".vhd":
+				format = pkg.DiskImageVHD
+			default:
+				format = pkg.DiskImageRaw
+			}
+		}
+
+		builder := pkg.NewDiskImageBuilder(buildImageImage, buildImageOutput)
+		builder.Format = format
+		builder.Size = buildImageSize << 30
+		builder.Verbose = viper.GetBool("verbose")
+		builder.DryRun = viper.GetBool("dry-run")
+		builder.KernelArgs = buildImageKernelArgs
+		builder.FilesystemType = buildImageFilesystem
+		builder.Verity = buildImageVerity
+		builder.Composefs = buildImageComposefs
+		builder.WritableUsr = buildImageWritableUsr
+		builder.GrowVar = buildImageGrow
+		mountOptions, err := parseMountOptions(buildImageMountOptions)
+		if err != nil {
+			return err
+		}
+		builder.MountOptions = mountOptions
+		builder.MountIdentifier, builder.VarMount, err = parseMountStyle(cmd, buildImageMountBy, buildImageVarMount)
+		if err != nil {
+			return err
+		}
+		if err := pkg.CheckFirstbootScripts(buildImageFirstbootScripts); err != nil {
+			return err
+		}
+		builder.Firstboot = buildImageFirstboot || len(buildImageFirstbootScripts) > 0
+		builder.FirstbootScripts = buildImageFirstbootScripts
+		if profile != nil {
+			profile.Apply(builder)
+		}
+
+		if err := builder.Build(ctx, buildImageSkipPull); err != nil {
+			return err
+		}
+		if buildImageUpload != "" {
+			return profile.Upload(ctx, buildImageOutput, buildImageUpload, builder.DryRun)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,77 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/bketelsen/phukit/pkg"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var (
+	buildISOImage      string
+	buildISOOutput     string
+	buildISODevice     string
+	buildISOKernelArgs []string
+	buildISOFilesystem string
+	buildISOSkipPull   bool
+	buildISOWorkDir    string
+)
+
+var buildISOCmd = &cobra.Command{
+	Use:   "build-iso",
+	Short: "Build a bootable installer ISO for a bootc container",
+	Long: `Build a live installer ISO for bare-metal installs.
+
+The live system is the image itself, with this phukit binary added and a
+service that runs 'phukit install' on the console at boot. By default it
+lists the disks and asks which one to install to; with --device it installs
+to that disk without asking and powers off. The image is pulled when the ISO
+installs it, so the machine needs network access.
+
+The image must include dracut, which builds the live initramfs. Building
+needs mksquashfs, xorriso, mtools and grub-mkrescue with GRUB's EFI and BIOS
+modules. The ISO boots with UEFI and BIOS, but is not signed for Secure Boot.
+
+Example:
+  phukit build-iso --image quay.io/example/myimage:latest --output installer.iso
+  phukit build-iso --image quay.io/example/myimage:latest --output installer.iso --device /dev/sda`,
+	Args: cobra.NoArgs,
+	RunE: runBuildISO,
+}
+
+func init() {
+	rootCmd.AddCommand(buildISOCmd)
+
+	buildISOCmd.Flags().StringVarP(&buildISOImage, "image", "i", "", "Container image reference (required)")
+	buildISOCmd.Flags().StringVarP(&buildISOOutput, "output", "o", "", "ISO file to write (required)")
+	buildISOCmd.Flags().StringVarP(&buildISODevice, "device", "d", "", "Install to this disk without asking (unattended)")
+	buildISOCmd.Flags().StringArrayVarP(&buildISOKernelArgs, "karg", "k", []string{}, "Kernel argument for the installed system (can be specified multiple times)")
+	buildISOCmd.Flags().StringVarP(&buildISOFilesystem, "filesystem", "f", "ext4", "Filesystem type for root and var partitions (ext4, btrfs)")
+	buildISOCmd.Flags().BoolVar(&buildISOSkipPull, "skip-pull", false, "Skip pulling the image (use already pulled image)")
+	buildISOCmd.Flags().StringVar(&buildISOWorkDir, "work-dir", "/var/tmp", "Directory for assembling the live root")
+
+	_ = buildISOCmd.MarkFlagRequired("image")
+	_ = buildISOCmd.MarkFlagRequired("output")
+}
+
+func runBuildISO(cmd *cobra.Command, args []string) error {
+	return runOperation(cmd, "build-iso", func(ctx context.Context) error {
+		if buildISOFilesystem != "ext4" && buildISOFilesystem != "btrfs" {
+			return fmt.Errorf("unsupported filesystem type: %s (supported: ext4, btrfs)", buildISOFilesystem)
+		}
+
+		builder := pkg.NewISOBuilder(buildISOImage, buildISOOutput)
+		builder.Device = buildISODevice
+		builder.WorkDir = buildISOWorkDir
+		builder.Verbose = viper.GetBool("verbose")
+		builder.DryRun = viper.GetBool("dry-run")
+		builder.InstallArgs = []string{"--filesystem", buildISOFilesystem}
+		for _, arg := range buildISOKernelArgs {
+			builder.InstallArgs = append(builder.InstallArgs, "--karg", arg)
+		}
+
+		return builder.Build(ctx, buildISOSkipPull)
+	})
+}
@@ -0,0 +1,121 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/bketelsen/phukit/pkg"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var (
+	channelJSON      bool
+	channelSkipCheck bool
+)
+
+var channelCmd = &cobra.Command{
+	Use:   "channel",
+	Short: "List and switch the update channel the system follows",
+	Long: `Update channels name the image streams a machine can follow, such as
+stable, testing and edge. Moving a few machines to a channel that receives new
+images first rolls updates out to a fleet in stages.
+
+Every system has the stable, testing and edge channels, following the tags of
+the same name of the installed image's repository. The "channels" map of
+/etc/phukit/config.json replaces or adds channels: each is a tag of that
+repository, or an image reference in which {repository} stands for it and
+{arch} for the machine's architecture:
+
+  "channels": {"stable": "42", "edge": "{repository}:nightly-{arch}"}
+
+After 'phukit channel switch', 'phukit update' without --image installs the
+channel's image, resolved again on every update. Updating to another image
+with --image leaves the channel.
+
+Example:
+  phukit channel list
+  phukit channel switch testing
+  phukit update`,
+}
+
+var channelListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the update channels",
+	Args:  cobra.NoArgs,
+	RunE:  runChannelList,
+}
+
+var channelSwitchCmd = &cobra.Command{
+	Use:   "switch CHANNEL",
+	Short: "Follow another update channel from the next update on",
+	Args:  cobra.ExactArgs(1),
+	RunE:  runChannelSwitch,
+}
+
+func init() {
+	rootCmd.AddCommand(channelCmd)
+	channelCmd.AddCommand(channelListCmd, channelSwitchCmd)
+
+	channelListCmd.Flags().BoolVar(&channelJSON, "json", false, "Output in JSON format")
+	channelSwitchCmd.Flags().BoolVar(&channelSkipCheck, "no-check", false, "Do not check that the channel's image exists in the registry")
+}
+
+func runChannelList(cmd *cobra.Command, args []string) error {
+	config, err := pkg.ReadSystemConfig()
+	if err != nil {
+		return err
+	}
+	channels := pkg.ListChannels(config)
+
+	if channelJSON {
+		data, err := json.MarshalIndent(channels, "", "  ")
+		if err != nil {
+			return fmt.Errorf("failed to marshal channels: %w", err)
+		}
+		fmt.Println(string(data))
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(w, "\tCHANNEL\tTARGET\tIMAGE")
+	for _, ch := range channels {
+		active, image := "", ch.ImageRef
+		if ch.Active {
+			active = "*"
+		}
+		if ch.Error != "" {
+			image = "invalid: " + ch.Error
+		}
+		_, _ = fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", active, ch.Name, ch.Target, image)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if config.Channel == "" {
+		fmt.Printf("\nNot following a channel; updates install %s.\n", config.ImageRef)
+	}
+	return nil
+}
+
+func runChannelSwitch(cmd *cobra.Command, args []string) error {
+	dryRun := viper.GetBool("dry-run")
+
+	lock, err := lockOperation(cmd.Context())
+	if err != nil {
+		return err
+	}
+	defer func() { _ = lock.Unlock() }()
+
+	ch, err := pkg.SwitchChannel(cmd.Context(), args[0], channelSkipCheck, dryRun)
+	if err != nil {
+		return err
+	}
+	if !dryRun {
+		fmt.Printf("Switched to channel %s (%s).\n", ch.Name, ch.ImageRef)
+		fmt.Println("Run 'phukit update' to install its image.")
+	}
+	return nil
+}
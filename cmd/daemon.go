@@ -0,0 +1,273 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/bketelsen/phukit/pkg"
+	"github.com/godbus/dbus/v5"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var (
+	daemonDBus          bool
+	daemonDBusAddress   string
+	daemonHTTP          string
+	daemonHTTPTokenFile string
+	daemonMetrics       string
+)
+
+var daemonCmd = &cobra.Command{
+	Use:   "daemon",
+	Short: "Serve a management interface for updates, rollback and status",
+	Long: `Run phukit as a long-running service that other programs can drive.
+Select the interfaces with the flags below; any combination works.
+
+With --dbus, phukit claims the name org.phukit.Manager on the system bus and
+exports /org/phukit/Manager with the methods:
+
+  Status() -> a{sv}                           Installed image, digest, active slot, ...
+  Update(s image, b force)                    Start an update ("" = installed image)
+  Rollback()                                  Make the other root the default boot entry
+  Install(s image, s device, as kernel_args)  Start an install to another disk
+
+and the signals:
+
+  Progress(s event)                                        Every progress event as JSON
+  Finished(s operation, s status, s error_code, s result)  Result document as JSON
+
+Update and Install return once the operation has started; follow it with the
+signals. Only one operation runs at a time; a second call fails with
+org.phukit.Manager.Error.operation_in_progress. The D-Bus policy shipped in
+data/dbus/org.phukit.Manager.conf allows only root to call the methods.
+
+With --http ADDRESS, phukit serves a REST API. Every request needs the header
+"Authorization: Bearer TOKEN" with the token from --http-token-file:
+
+  GET  /status    Installed image, digest, active slot, ... as JSON
+  POST /update    Start an update; optional body {"image_ref": "", "force": false}
+  POST /rollback  Roll back and return the result document
+  GET  /events    Server-sent events, one per progress event
+
+A busy daemon answers 409 Conflict. Errors are JSON objects with "error" and
+"error_code". The API is plain HTTP; listen on localhost or put it behind a
+TLS-terminating proxy.
+
+With --metrics ADDRESS, phukit serves Prometheus metrics on /metrics without
+authentication: installed image age, last update time, result, duration and
+download size, update and rollback counts, and whether the last boot came up
+on the expected root.
+
+With --check-interval, the daemon also checks the registry for a newer image
+at that interval and writes the login notice in /run/motd.d/phukit and
+/run/issue.d/phukit.issue, like phukit update --check does. The interval can
+also be set as update-check-interval in the configuration file.
+
+With --auto-update, each check also stages a newer image, but only inside the
+--maintenance-window spans (any time if none is given), such as
+"Sat 02:00-05:00", "Mon-Fri 01:00-03:00 UTC" or "daily 03:00-04:00 local".
+--reboot-policy decides when the system boots into a staged update: never
+(the default), immediate or within-window. Outside a window the update or
+reboot waits for the next check in one; the daemon also checks when a window
+opens. The configuration file keys are auto-update, maintenance-windows and
+reboot-policy.
+
+Example:
+  phukit daemon --dbus
+  busctl call org.phukit.Manager /org/phukit/Manager org.phukit.Manager Status
+
+  phukit daemon --http 127.0.0.1:8080 --http-token-file /etc/phukit/api-token
+  curl -H "Authorization: Bearer $(cat /etc/phukit/api-token)" http://127.0.0.1:8080/status
+
+  phukit daemon --check-interval 1h --auto-update \
+    --maintenance-window "Sat 02:00-05:00" --reboot-policy within-window`,
+	RunE: runDaemon,
+}
+
+func init() {
+	rootCmd.AddCommand(daemonCmd)
+
+	daemonCmd.Flags().BoolVar(&daemonDBus, "dbus", false, "Serve the org.phukit.Manager D-Bus interface")
+	daemonCmd.Flags().StringVar(&daemonDBusAddress, "dbus-address", "", "Connect to this D-Bus address instead of the system bus")
+	daemonCmd.Flags().StringVar(&daemonHTTP, "http", "", "Serve the REST API on this address (e.g. 127.0.0.1:8080)")
+	daemonCmd.Flags().StringVar(&daemonHTTPTokenFile, "http-token-file", "/etc/phukit/api-token", "File holding the bearer token of the REST API")
+	daemonCmd.Flags().StringVar(&daemonMetrics, "metrics", "", "Serve Prometheus metrics on this address (e.g. :9100)")
+	daemonCmd.Flags().Duration("check-interval", 0, "Check for a newer image at this interval and update the login notice (0 disables)")
+	_ = viper.BindPFlag("update-check-interval", daemonCmd.Flags().Lookup("check-interval"))
+	daemonCmd.Flags().Bool("auto-update", false, "Stage newer images found by the update checks inside the maintenance windows")
+	_ = viper.BindPFlag("auto-update", daemonCmd.Flags().Lookup("auto-update"))
+	addScheduleFlags(daemonCmd)
+}
+
+func runDaemon(cmd *cobra.Command, args []string) error {
+	checkInterval := viper.GetDuration("update-check-interval")
+	if !daemonDBus && daemonHTTP == "" && daemonMetrics == "" && checkInterval <= 0 {
+		return fmt.Errorf("no management interface selected (use --dbus, --http, --metrics or --check-interval)")
+	}
+	autoUpdate := viper.GetBool("auto-update")
+	if autoUpdate && checkInterval <= 0 {
+		return fmt.Errorf("--auto-update needs --check-interval")
+	}
+	schedule, err := scheduleFromFlags(cmd)
+	if err != nil {
+		return err
+	}
+	if err := applyGlobalFlags(); err != nil {
+		return err
+	}
+	if progressSocket != nil {
+		defer func() { _ = progressSocket.Close() }()
+	}
+
+	// Events go to the interface clients, not the terminal
+	manager := pkg.NewManager()
+	pkg.SetProgressReporter(manager)
+	if progressSocket != nil {
+		defer manager.Subscribe(progressSocket)()
+	}
+
+	ctx := cmd.Context()
+	var service *pkg.DBusService
+	if daemonDBus {
+		var conn *dbus.Conn
+		var err error
+		if daemonDBusAddress != "" {
+			conn, err = dbus.Connect(daemonDBusAddress)
+		} else {
+			conn, err = dbus.ConnectSystemBus()
+		}
+		if err != nil {
+			return fmt.Errorf("failed to connect to D-Bus: %w", err)
+		}
+		defer func() { _ = conn.Close() }()
+
+		service, err = pkg.ServeDBus(ctx, conn, manager)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Serving %s on D-Bus\n", pkg.DBusName)
+		pkg.Logger().Info("daemon started", "dbus_name", pkg.DBusName)
+	}
+
+	var servers []*http.Server
+	serveErr := make(chan error, 2)
+	if daemonHTTP != "" {
+		token, err := pkg.ReadAPIToken(daemonHTTPTokenFile)
+		if err != nil {
+			return err
+		}
+		lis, err := net.Listen("tcp", daemonHTTP)
+		if err != nil {
+			return fmt.Errorf("failed to listen on %s: %w", daemonHTTP, err)
+		}
+		servers = append(servers, serveHTTP(lis, pkg.NewHTTPHandler(ctx, manager, token), serveErr))
+		fmt.Printf("Serving the REST API on http://%s\n", lis.Addr())
+		pkg.Logger().Info("daemon started", "http_address", lis.Addr().String())
+	}
+	if daemonMetrics != "" {
+		server, err := serveMetrics(daemonMetrics, manager, serveErr)
+		if err != nil {
+			return err
+		}
+		servers = append(servers, server)
+	}
+
+	if checkInterval > 0 {
+		if autoUpdate {
+			go scheduledUpdates(ctx, manager, checkInterval, schedule)
+		} else {
+			go checkForUpdates(ctx, manager, checkInterval)
+		}
+	}
+
+	select {
+	case err = <-serveErr:
+		err = fmt.Errorf("HTTP server failed: %w", err)
+	case <-ctx.Done():
+	}
+	fmt.Println("Shutting down...")
+	// Running operations see the cancelled context and clean up
+	manager.Wait()
+	err = errors.Join(err, shutdownHTTP(servers))
+	if service != nil {
+		err = errors.Join(err, service.Close())
+	}
+	return err
+}
+
+// checkForUpdates refreshes the update notice every interval until ctx is done
+func checkForUpdates(ctx context.Context, manager *pkg.Manager, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := manager.CheckForUpdate(ctx); err != nil && ctx.Err() == nil {
+			pkg.Logger().Warn("update check failed", "error", err)
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// scheduledUpdates checks for updates every interval and whenever a
+// maintenance window opens, staging and rebooting into them as schedule
+// allows, until ctx is done
+func scheduledUpdates(ctx context.Context, manager *pkg.Manager, interval time.Duration, schedule pkg.UpdateSchedule) {
+	for {
+		if _, err := manager.ScheduledUpdate(ctx, schedule); err != nil && ctx.Err() == nil {
+			pkg.Logger().Warn("scheduled update failed", "error", err)
+		}
+		wait := interval
+		if next := schedule.Windows.NextStart(time.Now()); !next.IsZero() && time.Until(next) < wait {
+			wait = time.Until(next)
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		}
+	}
+}
+
+// serveHTTP serves handler on lis in the background; Serve's error goes to
+// serveErr
+func serveHTTP(lis net.Listener, handler http.Handler, serveErr chan<- error) *http.Server {
+	server := &http.Server{Handler: handler, ReadHeaderTimeout: 10 * time.Second}
+	go func() { serveErr <- server.Serve(lis) }()
+	return server
+}
+
+// serveMetrics serves Prometheus metrics on /metrics at address
+func serveMetrics(address string, manager *pkg.Manager, serveErr chan<- error) (*http.Server, error) {
+	lis, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on %s: %w", address, err)
+	}
+	mux := http.NewServeMux()
+	mux.Handle("GET /metrics", pkg.NewMetricsHandler(manager))
+	fmt.Printf("Serving metrics on http://%s/metrics\n", lis.Addr())
+	pkg.Logger().Info("metrics started", "metrics_address", lis.Addr().String())
+	return serveHTTP(lis, mux, serveErr), nil
+}
+
+// shutdownHTTP stops servers, waiting briefly for requests in flight
+func shutdownHTTP(servers []*http.Server) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	var err error
+	for _, server := range servers {
+		if serr := server.Shutdown(ctx); serr != nil && !errors.Is(serr, http.ErrServerClosed) {
+			err = errors.Join(err, fmt.Errorf("failed to stop HTTP server: %w", serr))
+		}
+	}
+	return err
+}
@@ -0,0 +1,119 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/bketelsen/phukit/pkg"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var (
+	devCreateDiskSize   string
+	devCreateDiskAttach bool
+)
+
+var devCmd = &cobra.Command{
+	Use:   "dev",
+	Short: "Manage loop-device test disks for development",
+	Long: `Create sparse disk images and attach them to loop devices, so the full
+install path can be exercised without a spare disk:
+
+  phukit dev create-disk --size 40G test.img
+  sudo phukit dev attach test.img         # prints /dev/loopN
+  sudo phukit install --image quay.io/example/myimage:latest --device /dev/loopN
+  sudo phukit dev detach test.img`,
+}
+
+var devCreateDiskCmd = &cobra.Command{
+	Use:   "create-disk <file>",
+	Short: "Create a sparse disk image",
+	Long: `Create a sparse disk image of the given size. Only the blocks written
+use space. An existing file is never overwritten.
+
+Example:
+  phukit dev create-disk --size 40G test.img
+  sudo phukit dev create-disk --size 64G --attach test.img`,
+	Args: cobra.ExactArgs(1),
+	RunE: runDevCreateDisk,
+}
+
+var devAttachCmd = &cobra.Command{
+	Use:   "attach <file>",
+	Short: "Attach a disk image to a loop device",
+	Long: `Attach a disk image to a free loop device with partition scanning, so
+its partitions appear as /dev/loopNpM, and print the device. Requires root.
+
+Example:
+  sudo phukit dev attach test.img`,
+	Args: cobra.ExactArgs(1),
+	RunE: runDevAttach,
+}
+
+var devDetachCmd = &cobra.Command{
+	Use:   "detach <device|file>",
+	Short: "Detach a loop device",
+	Long: `Detach a loop device, or every loop device a disk image is attached to.
+Requires root.
+
+Example:
+  sudo phukit dev detach /dev/loop0
+  sudo phukit dev detach test.img`,
+	Args: cobra.ExactArgs(1),
+	RunE: runDevDetach,
+}
+
+func init() {
+	rootCmd.AddCommand(devCmd)
+	devCmd.AddCommand(devCreateDiskCmd, devAttachCmd, devDetachCmd)
+
+	devCreateDiskCmd.Flags().StringVar(&devCreateDiskSize, "size", "40G", "Disk size, with an optional K, M, G or T suffix")
+	devCreateDiskCmd.Flags().BoolVar(&devCreateDiskAttach, "attach", false, "Attach the new disk to a loop device")
+}
+
+func runDevCreateDisk(cmd *cobra.Command, args []string) error {
+	size, err := pkg.ParseSize(devCreateDiskSize)
+	if err != nil {
+		return err
+	}
+	if viper.GetBool("dry-run") {
+		fmt.Printf("[DRY RUN] Would create %s disk image %s\n", pkg.FormatSize(uint64(size)), args[0])
+		return nil
+	}
+	if err := pkg.CreateDiskImage(args[0], size); err != nil {
+		return err
+	}
+	fmt.Printf("Created %s disk image %s\n", pkg.FormatSize(uint64(size)), args[0])
+	if devCreateDiskAttach {
+		return runDevAttach(cmd, args)
+	}
+	return nil
+}
+
+func runDevAttach(cmd *cobra.Command, args []string) error {
+	if viper.GetBool("dry-run") {
+		fmt.Printf("[DRY RUN] Would attach %s to a loop device\n", args[0])
+		return nil
+	}
+	device, err := pkg.AttachLoopDevice(cmd.Context(), args[0])
+	if err != nil {
+		return err
+	}
+	_, _ = fmt.Fprintln(pkg.Stdout(), device)
+	return nil
+}
+
+func runDevDetach(cmd *cobra.Command, args []string) error {
+	if viper.GetBool("dry-run") {
+		fmt.Printf("[DRY RUN] Would detach %s\n", args[0])
+		return nil
+	}
+	devices, err := pkg.DetachLoopDevice(cmd.Context(), args[0])
+	if err != nil {
+		return err
+	}
+	for _, device := range devices {
+		fmt.Printf("Detached %s\n", device)
+	}
+	return nil
+}
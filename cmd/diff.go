@@ -0,0 +1,126 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/bketelsen/phukit/pkg"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var (
+	diffJSON     bool
+	diffFiles    bool
+	diffChecksum bool
+)
+
+var diffCmd = &cobra.Command{
+	Use:   "diff [IMAGE]",
+	Short: "Show what an update to an image would change on this system",
+	Long: `Compare the booted system with a candidate image before staging an update:
+the packages added, removed or changed in version (through the rpm or dpkg
+database) and the files added, removed or changed in /usr.
+
+Without IMAGE the booted image reference is used, showing what the next
+'phukit update' would change. Files are compared by type, mode, owner, size
+and link target; --checksum also compares the content of files of equal size,
+which reads all of /usr. /etc and /var are left out, as updates merge or keep
+them.
+
+Example:
+  phukit diff
+  phukit diff --files quay.io/my-org/my-image:v2
+  phukit diff --json --checksum quay.io/my-org/my-image:v2`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: runDiff,
+}
+
+func init() {
+	rootCmd.AddCommand(diffCmd)
+	diffCmd.Flags().BoolVar(&diffJSON, "json", false, "Output in JSON format")
+	diffCmd.Flags().BoolVar(&diffFiles, "files", false, "List the changed files, not only their number")
+	diffCmd.Flags().BoolVar(&diffChecksum, "checksum", false, "Compare the content of files of equal size")
+}
+
+// changeSymbols are printed in front of each changed package or file
+var changeSymbols = map[string]string{
+	pkg.ChangeAdded:   "+",
+	pkg.ChangeRemoved: "-",
+	pkg.ChangeChanged: "~",
+}
+
+func runDiff(cmd *cobra.Command, args []string) error {
+	pkg.SetStrictMode(viper.GetBool("strict"))
+
+	imageRef := ""
+	if len(args) > 0 {
+		imageRef = args[0]
+	}
+	diff, err := pkg.DiffImage(cmd.Context(), imageRef, diffChecksum)
+	if err != nil {
+		return err
+	}
+
+	if diffJSON {
+		data, err := json.MarshalIndent(diff, "", "  ")
+		if err != nil {
+			return fmt.Errorf("failed to marshal diff: %w", err)
+		}
+		fmt.Println(string(data))
+		return nil
+	}
+
+	fmt.Printf("Booted: %s (%s)\n", diff.Booted.ImageRef, shortDigest(diff.Booted.ImageDigest))
+	fmt.Printf("Image:  %s (%s)\n", diff.ImageRef, shortDigest(diff.Digest))
+	if diff.Booted.ImageDigest == diff.Digest {
+		fmt.Println("\nThe image is the booted image.")
+	}
+
+	fmt.Println()
+	if diff.PackageManager == "" {
+		fmt.Println("Packages: not compared (no rpm or dpkg database)")
+	} else {
+		added, removed, changed := 0, 0, 0
+		for _, p := range diff.Packages {
+			switch p.Change {
+			case pkg.ChangeAdded:
+				added++
+			case pkg.ChangeRemoved:
+				removed++
+			default:
+				changed++
+			}
+		}
+		fmt.Printf("Packages (%s): %d changed, %d added, %d removed\n", diff.PackageManager, changed, added, removed)
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		for _, p := range diff.Packages {
+			name := p.Name
+			if p.Arch != "" {
+				name += "." + p.Arch
+			}
+			switch p.Change {
+			case pkg.ChangeChanged:
+				_, _ = fmt.Fprintf(w, "  %s %s\t%s -> %s\n", changeSymbols[p.Change], name, p.From, p.To)
+			default:
+				_, _ = fmt.Fprintf(w, "  %s %s\t%s\n", changeSymbols[p.Change], name, p.From+p.To)
+			}
+		}
+		if err := w.Flush(); err != nil {
+			return err
+		}
+	}
+
+	added, removed, changed := diff.ChangeCounts()
+	fmt.Printf("\nFiles in /usr: %d changed, %d added, %d removed\n", changed, added, removed)
+	if diffFiles {
+		for _, f := range diff.Files {
+			fmt.Printf("  %s %s\n", changeSymbols[f.Change], f.Path)
+		}
+	} else if len(diff.Files) > 0 {
+		fmt.Println("  (list them with --files)")
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/bketelsen/phukit/pkg"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var (
+	etcMergeTarget        string
+	etcMergeFrom          string
+	etcMergeFromPartition string
+	etcMergePersistence   bool
+)
+
+var etcCmd = &cobra.Command{
+	Use:   "etc",
+	Short: "Manage /etc configuration of an installed root",
+	Long:  `Manage the /etc configuration that phukit carries across A/B updates.`,
+}
+
+var etcMergeCmd = &cobra.Command{
+	Use:   "merge",
+	Short: "Merge /etc user modifications into a mounted root",
+	Long: `Re-run just the /etc merge against an arbitrary mounted root, without a
+full update. User-added files and known user-modified configuration files
+(passwd, group, shadow, hostname, ...) are copied from the source /etc into
+<target>/etc; system identity files such as os-release are kept from the target.
+
+By default the source is the running system's /etc. Use --from to merge from
+another directory, or --from-partition to mount a root partition read-only and
+merge from its /etc.
+
+Unless --persistence=false is given, /etc persistence is then set up on the
+target (verifies <target>/etc and backs it up to <target>/var/etc.backup).
+
+Example:
+  phukit etc merge --target /mnt
+  phukit etc merge --target /mnt --from /srv/backup/etc
+  phukit etc merge --target /mnt --from-partition /dev/sda2`,
+	RunE: runEtcMerge,
+}
+
+func init() {
+	rootCmd.AddCommand(etcCmd)
+	etcCmd.AddCommand(etcMergeCmd)
+
+	etcMergeCmd.Flags().StringVarP(&etcMergeTarget, "target", "t", "", "Mount point of the root to merge into (required)")
+	etcMergeCmd.Flags().StringVar(&etcMergeFrom, "from", "/etc", "Directory to merge /etc modifications from")
+	etcMergeCmd.Flags().StringVar(&etcMergeFromPartition, "from-partition", "", "Root partition to merge /etc modifications from (mounted read-only)")
+	etcMergeCmd.Flags().BoolVar(&etcMergePersistence, "persistence", true, "Set up /etc persistence on the target after merging")
+	etcMergeCmd.MarkFlagsMutuallyExclusive("from", "from-partition")
+	_ = etcMergeCmd.MarkFlagRequired("target")
+}
+
+func runEtcMerge(cmd *cobra.Command, args []string) error {
+	return runOperation(cmd, "etc merge", func(ctx context.Context) error {
+		dryRun := viper.GetBool("dry-run")
+
+		if info, err := os.Stat(etcMergeTarget); err != nil || !info.IsDir() {
+			return fmt.Errorf("target %s is not a directory", etcMergeTarget)
+		}
+
+		if etcMergeFromPartition != "" {
+			partition, err := pkg.GetDiskByPath(etcMergeFromPartition)
+			if err != nil {
+				return fmt.Errorf("invalid partition: %w", err)
+			}
+			if err := pkg.MergeEtcFromActive(etcMergeTarget, partition, dryRun); err != nil {
+				return err
+			}
+		} else {
+			fmt.Printf("Merging /etc from %s into %s...\n", etcMergeFrom, etcMergeTarget)
+			if err := pkg.MergeEtc(etcMergeTarget, etcMergeFrom, dryRun); err != nil {
+				return err
+			}
+		}
+
+		if etcMergePersistence {
+			if err := pkg.SetupEtcPersistence(etcMergeTarget, dryRun); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
@@ -0,0 +1,80 @@
+package cmd
+
+import (
+	"errors"
+
+	"github.com/bketelsen/phukit/pkg"
+)
+
+// Exit codes other than the generic failure code 1
+const (
+	ExitDeviceBusy              = 10
+	ExitDeviceNotFound          = 11
+	ExitInsufficientSpace       = 12
+	ExitPartitionSchemeMismatch = 13
+	ExitSlotPinned              = 14
+	ExitInvalidImageReference   = 20
+	ExitImageUnauthorized       = 21
+	ExitImageNotFound           = 22
+	ExitPinMismatch             = 23
+	ExitImageLintFailed         = 24
+	ExitPolicyViolation         = 25
+	ExitMissingTool             = 30
+	ExitVerificationFailed      = 40
+	ExitBootFailed              = 41
+	ExitOperationInProgress     = 50
+	ExitStrictWarning           = 51
+	ExitAborted                 = 52
+	ExitTimeout                 = 124 // --timeout expired, matching timeout(1)
+)
+
+// errorCodeExits maps pkg error codes to exit codes
+var errorCodeExits = map[string]int{
+	pkg.CodeDeviceBusy:              ExitDeviceBusy,
+	pkg.CodeDeviceNotFound:          ExitDeviceNotFound,
+	pkg.CodeInsufficientSpace:       ExitInsufficientSpace,
+	pkg.CodePartitionSchemeMismatch: ExitPartitionSchemeMismatch,
+	pkg.CodeSlotPinned:              ExitSlotPinned,
+	pkg.CodeInvalidImageReference:   ExitInvalidImageReference,
+	pkg.CodeImageUnauthorized:       ExitImageUnauthorized,
+	pkg.CodeImageNotFound:           ExitImageNotFound,
+	pkg.CodePinMismatch:             ExitPinMismatch,
+	pkg.CodeImageLintFailed:         ExitImageLintFailed,
+	pkg.CodePolicyViolation:         ExitPolicyViolation,
+	pkg.CodeMissingTool:             ExitMissingTool,
+	pkg.CodeVerificationFailed:      ExitVerificationFailed,
+	pkg.CodeBootFailed:              ExitBootFailed,
+	pkg.CodeOperationInProgress:     ExitOperationInProgress,
+	pkg.CodeStrictWarning:           ExitStrictWarning,
+	pkg.CodeAborted:                 ExitAborted,
+	pkg.CodeTimeout:                 ExitTimeout,
+}
+
+// ExitError is an error that should end the process with a specific exit code
+type ExitError struct {
+	Code int
+	Err  error
+}
+
+func (e *ExitError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *ExitError) Unwrap() error {
+	return e.Err
+}
+
+// ExitCode returns the process exit code for an error returned by Execute
+func ExitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+	var exitErr *ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.Code
+	}
+	if code, ok := errorCodeExits[pkg.ErrorCode(err)]; ok {
+		return code
+	}
+	return 1
+}
@@ -0,0 +1,64 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/bketelsen/phukit/pkg"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var exportRoot string
+
+var exportCmd = &cobra.Command{
+	Use:   "export DESTINATION",
+	Short: "Export the installed root as an OCI image",
+	Long: `Pack the booted root, or another root mounted with --root, into a
+single-layer OCI image, to capture a machine's state as a golden image,
+compare it with the image it was installed from, or bring a machine installed
+another way into the A/B update flow.
+
+The image holds the root with /usr and /etc. The contents of /var and of
+other filesystems mounted below the root (/boot, /proc, /run, ...) are left
+out, as are /etc/machine-id and phukit's deployment record. The image records
+the image the root was deployed from as its base.
+
+DESTINATION is one of:
+
+  oci:DIR[:TAG]           An OCI image layout directory; other images in it are kept
+  oci-archive:FILE[:TAG]  A single tar file of an OCI image layout
+  [docker://]REFERENCE    A registry
+
+TAG defaults to latest. The layer is built in /var/tmp, which needs room for
+the compressed root.
+
+Example:
+  phukit export quay.io/my-org/golden:2024-06-01
+  phukit export oci-archive:/media/usb/golden.tar
+  phukit export --root /mnt oci:/srv/images:old-laptop`,
+	Args: cobra.ExactArgs(1),
+	RunE: runExport,
+}
+
+func init() {
+	rootCmd.AddCommand(exportCmd)
+	exportCmd.Flags().StringVar(&exportRoot, "root", "", "Mounted root to export (default: the booted root)")
+}
+
+func runExport(cmd *cobra.Command, args []string) error {
+	return runOperation(cmd, "export", func(ctx context.Context) error {
+		result, err := pkg.ExportRoot(ctx, pkg.ExportOptions{
+			Root:        exportRoot,
+			Destination: args[0],
+			DryRun:      viper.GetBool("dry-run"),
+		})
+		if err != nil {
+			return err
+		}
+		if !viper.GetBool("dry-run") {
+			fmt.Printf("Exported %s (%s) to %s\n", result.Root, result.Digest, result.Destination)
+		}
+		return nil
+	})
+}
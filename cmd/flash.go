@@ -0,0 +1,82 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/bketelsen/phukit/pkg"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var (
+	flashImageArtifact string
+	flashDevice        string
+	flashNoVerify      bool
+	flashConfirmDevice bool
+)
+
+var flashCmd = &cobra.Command{
+	Use:   "flash",
+	Short: "Write a pre-built disk image to a device",
+	Long: `Write a pre-built disk image (for example an ARM SD card image) to a device.
+
+xz, zstd and gzip compressed images are detected automatically and
+decompressed while writing. After writing, the device is read back and
+compared against the image unless --no-verify is given.
+
+The same safety checks as install apply: the device must not have mounted
+partitions, must be large enough for uncompressed images, and you must
+confirm before anything is written.
+
+Example:
+  phukit flash --image-artifact os.img --device /dev/sdX
+  phukit flash --image-artifact os.img.xz --device /dev/mmcblk0`,
+	RunE: runFlash,
+}
+
+func init() {
+	rootCmd.AddCommand(flashCmd)
+
+	flashCmd.Flags().StringVar(&flashImageArtifact, "image-artifact", "", "Disk image file to write, optionally xz/zstd/gzip compressed (required)")
+	flashCmd.Flags().StringVarP(&flashDevice, "device", "d", "", "Target disk device (required)")
+	flashCmd.Flags().BoolVar(&flashNoVerify, "no-verify", false, "Skip reading the device back to verify the written image")
+	flashCmd.Flags().BoolVar(&flashConfirmDevice, "confirm-device", false, "Ask for the device path instead of 'yes' to confirm (always on for disks of 1 TiB or more)")
+
+	_ = flashCmd.MarkFlagRequired("image-artifact")
+	_ = flashCmd.MarkFlagRequired("device")
+}
+
+func runFlash(cmd *cobra.Command, args []string) error {
+	return runOperation(cmd, "flash", func(ctx context.Context) error {
+		verbose := viper.GetBool("verbose")
+		dryRun := viper.GetBool("dry-run")
+
+		// Resolve device path
+		device, err := pkg.GetDiskByPath(flashDevice)
+		if err != nil {
+			return fmt.Errorf("invalid device: %w", err)
+		}
+
+		if verbose {
+			fmt.Printf("Resolved device: %s\n", device)
+		}
+
+		flasher := pkg.NewImageFlasher(flashImageArtifact, device)
+		flasher.SetVerbose(verbose)
+		flasher.SetDryRun(dryRun)
+		flasher.SetVerify(!flashNoVerify)
+		flasher.SetConfirmDevice(flashConfirmDevice)
+
+		if err := flasher.FlashComplete(ctx); err != nil {
+			return err
+		}
+
+		if !dryRun {
+			fmt.Println()
+			fmt.Printf("Image written to %s. It is safe to remove the device.\n", device)
+		}
+
+		return nil
+	})
+}
@@ -0,0 +1,101 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/bketelsen/phukit/pkg"
+	"github.com/spf13/cobra"
+)
+
+var (
+	historyJSON  bool
+	historyLimit int
+)
+
+var historyCmd = &cobra.Command{
+	Use:   "history",
+	Short: "Show the install, update and rollback history",
+	Long: `Show every install, update and rollback of this system, oldest first,
+with the image versions (or digests) before and after, the outcome, the duration and who
+started it. The history is kept in /var/lib/phukit/history.json.
+
+Example:
+  phukit history
+  phukit history --limit 10
+  phukit history --json  # For audits`,
+	RunE: runHistory,
+}
+
+func init() {
+	rootCmd.AddCommand(historyCmd)
+	historyCmd.Flags().BoolVar(&historyJSON, "json", false, "Output in JSON format")
+	historyCmd.Flags().IntVar(&historyLimit, "limit", 0, "Only show the last N entries (0 shows all)")
+}
+
+func runHistory(cmd *cobra.Command, args []string) error {
+	entries, err := pkg.ReadHistory()
+	if err != nil {
+		return err
+	}
+	if historyLimit > 0 && len(entries) > historyLimit {
+		entries = entries[len(entries)-historyLimit:]
+	}
+
+	if historyJSON {
+		if entries == nil {
+			entries = []pkg.HistoryEntry{}
+		}
+		data, err := json.MarshalIndent(entries, "", "  ")
+		if err != nil {
+			return fmt.Errorf("failed to marshal history: %w", err)
+		}
+		fmt.Println(string(data))
+		return nil
+	}
+
+	if len(entries) == 0 {
+		fmt.Println("No operations recorded.")
+		return nil
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(w, "TIME\tOPERATION\tSTATUS\tFROM\tTO\tDURATION\tINITIATOR")
+	for _, e := range entries {
+		status := e.Status
+		if e.ErrorCode != "" {
+			status += " (" + e.ErrorCode + ")"
+		}
+		_, _ = fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			e.Time.Local().Format("2006-01-02 15:04:05"), e.Operation, status,
+			historyImage(e.FromVersion, e.FromDigest), historyImage(e.ToVersion, e.ToDigest),
+			(time.Duration(e.DurationSeconds) * time.Second).String(), historyField(e.Initiator))
+	}
+	return w.Flush()
+}
+
+// shortDigest shortens a digest for tables, like status does
+func shortDigest(digest string) string {
+	if len(digest) > 19 {
+		return digest[:19]
+	}
+	return historyField(digest)
+}
+
+// historyImage shows an image by version, or by digest if it has none
+func historyImage(version, digest string) string {
+	if version != "" {
+		return version
+	}
+	return shortDigest(digest)
+}
+
+// historyField shows unknown values as "-"
+func historyField(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
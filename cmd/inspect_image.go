@@ -0,0 +1,108 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/bketelsen/phukit/pkg"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var inspectImageJSON bool
+
+var inspectImageCmd = &cobra.Command{
+	Use:   "inspect-image IMAGE",
+	Short: "Show what a container image contains before installing it",
+	Long: `Show an image's digest, size, layers, labels and annotations, and what its
+filesystem holds for booting: the os-release, the kernels in /usr/lib/modules
+and the bootloader tools and EFI binaries.
+
+The image is read straight from the registry; nothing is written to disk and
+no disk is touched. The layers are downloaded to read the filesystem, so this
+takes about as long as a pull.
+
+Example:
+  phukit inspect-image quay.io/centos-bootc/centos-bootc:stream9
+  phukit inspect-image --json quay.io/my-org/my-image:latest`,
+	Args: cobra.ExactArgs(1),
+	RunE: runInspectImage,
+}
+
+func init() {
+	rootCmd.AddCommand(inspectImageCmd)
+	inspectImageCmd.Flags().BoolVar(&inspectImageJSON, "json", false, "Output in JSON format")
+}
+
+func runInspectImage(cmd *cobra.Command, args []string) error {
+	info, err := pkg.InspectImage(cmd.Context(), args[0])
+	if err != nil {
+		return err
+	}
+
+	if inspectImageJSON {
+		data, err := json.MarshalIndent(info, "", "  ")
+		if err != nil {
+			return fmt.Errorf("failed to marshal image info: %w", err)
+		}
+		fmt.Println(string(data))
+		return nil
+	}
+
+	fmt.Printf("Image:       %s\n", info.ImageRef)
+	fmt.Printf("Digest:      %s\n", info.Digest)
+	if info.Created != nil {
+		fmt.Printf("Created:     %s\n", info.Created.UTC().Format(time.RFC3339))
+	}
+	if info.Platform != "" {
+		fmt.Printf("Platform:    %s\n", info.Platform)
+	}
+	fmt.Printf("Size:        %s compressed in %d layers\n", pkg.FormatSize(uint64(info.Size)), len(info.Layers))
+
+	fmt.Println()
+	if name := info.OSRelease["PRETTY_NAME"]; name != "" {
+		fmt.Printf("OS:          %s\n", name)
+	} else if len(info.OSRelease) == 0 {
+		fmt.Println("OS:          (no os-release found)")
+	}
+	if len(info.Kernels) > 0 {
+		fmt.Printf("Kernel:      %s\n", strings.Join(info.Kernels, ", "))
+	} else {
+		fmt.Println("Kernel:      ⚠ none in /usr/lib/modules; the image cannot boot")
+	}
+	fmt.Printf("Bootloader:  %s\n", info.Bootloader)
+	if len(info.BootBinaries) > 0 {
+		fmt.Println("Boot binaries:")
+		for _, p := range info.BootBinaries {
+			fmt.Printf("  %s\n", p)
+		}
+	} else {
+		fmt.Println("Boot binaries: ⚠ none found")
+	}
+
+	printStringMap("Labels", info.Labels)
+	printStringMap("Annotations", info.Annotations)
+	if viper.GetBool("verbose") {
+		printStringMap("os-release", info.OSRelease)
+		fmt.Println("\nLayers:")
+		for i, layer := range info.Layers {
+			fmt.Printf("  %2d  %s  %s\n", i+1, layer.Digest, pkg.FormatSize(uint64(layer.Size)))
+		}
+	}
+	return nil
+}
+
+// printStringMap prints a heading and the sorted key=value pairs of m
+func printStringMap(heading string, m map[string]string) {
+	if len(m) == 0 {
+		return
+	}
+	fmt.Printf("\n%s:\n", heading)
+	for _, k := range slices.Sorted(maps.Keys(m)) {
+		fmt.Printf("  %s=%s\n", k, m[k])
+	}
+}
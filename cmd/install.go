@@ -1,7 +1,11 @@
 package cmd
 
 import (
+	"context"
 	"fmt"
+	"maps"
+	"slices"
+	"strings"
 
 	"github.com/bketelsen/phukit/pkg"
 	"github.com/spf13/cobra"
@@ -9,11 +13,42 @@ import (
 )
 
 var (
-	installImage      string
-	installDevice     string
-	installSkipPull   bool
-	installKernelArgs []string
-	installFilesystem string
+	installImage            string
+	installDevice           string
+	installSkipPull         bool
+	installKernelArgs       []string
+	installFilesystem       string
+	installForceUnmount     bool
+	installPlan             bool
+	installPin              bool
+	installPinPolicy        string
+	installVerity           bool
+	installComposefs        bool
+	installWritableUsr      bool
+	installKickstart        string
+	installIgnition         string
+	installRootPassword     string
+	installUsers            []string
+	installSSHKeys          []string
+	installFirstboot        bool
+	installFirstbootScripts []string
+	installSwap             string
+	installHibernate        bool
+	installPreserveData     bool
+	installReusePartitions  bool
+	installLVM              bool
+	installVolumeGroup      string
+	installPartitions       []string
+	installGrow             bool
+	installMountOptions     []string
+	installMountBy          string
+	installVarMount         string
+	installBootProfiles     []string
+	installConsole          string
+	installWipeMode         string
+	installConfirmDevice    bool
+	installYes              bool
+	installFormat           string
 )
 
 var installCmd = &cobra.Command{
@@ -32,70 +67,549 @@ This command will:
 
 The dual root partitions enable A/B updates for system resilience.
 
+With --kickstart the image, disk, kernel arguments, users, SSH keys, network
+and services can come from a kickstart file (a path or http(s) URL) instead,
+so existing provisioning content can be reused. The disk can be chosen by
+rules (ignoredisk --only-use, or the targetdisk extension matching size,
+model and serial). Flags given on the command line take precedence.
+
+With --ignition the users, SSH keys, files, directories, links, systemd units
+and kernel arguments of an Ignition config (spec 3.x, a path or http(s) URL)
+are applied to the new root, for CoreOS-style provisioning.
+
+--user, --ssh-authorized-key and --root-password-hash create accounts and add
+SSH keys on the installed system, so it is reachable without a console.
+--hostname, --timezone and --locale set up /etc/hostname, /etc/localtime and
+/etc/locale.conf; they can also be set as hostname, timezone and locale in
+the configuration file.
+
+Without --image, the image is default-image of the configuration file, and
+without --karg the kernel arguments are its kernel-args. Set in the
+system-wide /etc/phukit/phukit.yaml, they leave an install on a provisioning
+host needing only --device.
+
+--firstboot installs phukit-firstboot.service, which runs once on the first
+boot to grow /var, commit the machine ID, generate SSH host keys and run the
+scripts given with --firstboot-script, then disables itself.
+
+--swap adds a swap partition after the others. If it can hold all of RAM,
+the system can hibernate: resume= is added to the kernel command line and
+the initramfs is rebuilt with resume support. --hibernate sizes the swap
+partition to RAM.
+
+--grow configures the installed system to grow /var into the rest of the
+disk with systemd-repart on boot, and the root and /var filesystems into
+their partitions with systemd-growfs, for disks that are larger than the one
+installed to or were enlarged later. It cannot be combined with swap,
+dm-verity, LVM or existing partitions, which keep /var from being the last
+partition on the disk.
+
+--wipe-mode chooses how the disk is wiped before it is partitioned:
+signatures (the default) only removes filesystem signatures and partition
+tables; discard discards every block; zero writes zeroes over the whole disk;
+and secure-erase has an NVMe disk erase its user data, including remapped
+blocks. Use zero or secure-erase to make sure the old data is gone when
+reprovisioning or decommissioning a machine.
+
+--mount-option sets the mount options of the root or /var filesystem as
+mountpoint=options, such as /var=noatime,nodev or /=compress=zstd:3. They
+can also be set as a mount-options map in the configuration file. The
+options are used on every boot entry, including those of later updates.
+
+--mount-by label names /var, swap and the alternate root in /etc/fstab and
+on the kernel command line by filesystem label (LABEL=var) instead of UUID.
+--var-mount chooses how /var is mounted: from the kernel command line with
+systemd.mount-extra (cmdline, the default), by an /etc/fstab entry (fstab),
+or by a native var.mount unit (systemd); the boot entries only mount it in
+the first case. Both can also be set as mount-by and var-mount in the
+configuration file.
+
+--boot-profile adds a boot entry for the installed root with more kernel
+arguments, given as name=arguments, such as a recovery entry with
+debug="rd.break enforcing=0 console=ttyS0". The entries are listed after the
+main and previous ones and kept by updates. They can also be set as a
+boot-profiles map in the configuration file, which updates apply as well.
+
+--console sets up a serial console on the installed system: console=
+arguments that print to the screen and the serial port, and a GRUB serial
+terminal. By default (auto) the serial console the installer was booted with
+or the one the firmware describes in its ACPI SPCR table is used, unless
+--karg already has one. Give a port such as ttyS1,115200n8 to choose it, or
+none to use the screen only.
+
+--lock-bootloader keeps console users from editing the kernel command line in
+the boot menu, for kiosks and appliances: systemd-boot gets "editor no", and
+GRUB a superuser (--grub-superuser, root by default) who alone can edit
+entries, with the password hash given with --grub-password-hash (which
+implies --lock-bootloader; see 'grub2-mkpasswd-pbkdf2'). Without a hash
+nobody can edit GRUB entries. Every entry still boots without a password.
+All three can also be set in the configuration file.
+
+--lvm puts root1, root2, /var and swap on logical volumes of one LVM volume
+group (named with --volume-group, which implies --lvm) on a single partition,
+so they can be resized and snapshotted after the install. /var gets 90% of
+the space left; the rest stays free in the volume group.
+
+--preserve-data reinstalls a broken system without losing its state: the
+partition table of the existing phukit install is kept, the boot and root
+partitions are formatted again, and the /var partition (which also holds
+/home) and the swap partition are left untouched and attached to the new root.
+
+--reuse-partitions installs into the partitions of an earlier phukit install
+without wiping the disk or writing a new partition table. For dual-boot disks
+and partitioning done with other tools, --partition maps existing partitions
+to phukit's roles instead (boot, root1, root2 and var are required, swap is
+optional) and implies --reuse-partitions. Only the mapped partitions are
+formatted; an existing EFI system partition mapped as boot is shared, not
+formatted. Combine with --preserve-data to keep /var as well.
+
 Supported filesystems: ext4 (default), btrfs
 
 Example:
   phukit install --image quay.io/example/myimage:latest --device /dev/sda
+  phukit install --image quay.io/example/myimage:latest --device /dev/sda --yes
   phukit install --image localhost/myimage --device /dev/nvme0n1 --filesystem btrfs
-  phukit install --image localhost/myimage --device /dev/nvme0n1 --karg console=ttyS0`,
+  phukit install --image localhost/myimage --device /dev/nvme0n1 --karg console=ttyS0
+  phukit install --image localhost/myimage --device /dev/sda --verity
+  phukit install --image localhost/myimage --device /dev/sda --composefs
+  phukit install --image localhost/myimage --device /dev/sda --plan
+  phukit install --image localhost/myimage --device /dev/nvme0n1 --hibernate
+  phukit install --image localhost/myimage --device /dev/sda --lvm
+  phukit install --image localhost/myimage --device /dev/sda --grow
+  phukit install --image localhost/myimage --device /dev/nvme0n1 --wipe-mode secure-erase
+  phukit install --image localhost/myimage --device /dev/sda --filesystem btrfs --mount-option /=noatime,compress=zstd:3 --mount-option /var=noatime,nodev
+  phukit install --image localhost/myimage --device /dev/sda --preserve-data
+  phukit install --image localhost/myimage --device /dev/sda --partition boot=/dev/sda1 --partition root1=/dev/sda5 --partition root2=/dev/sda6 --partition var=/dev/sda7
+  phukit install --kickstart https://provision.example.com/web.ks
+  phukit install --image localhost/myimage --device /dev/sda --ignition config.ign
+  phukit install --image localhost/myimage --device /dev/sda --user admin:wheel --ssh-authorized-key admin:$HOME/.ssh/id_ed25519.pub`,
 	RunE: runInstall,
 }
 
 func init() {
 	rootCmd.AddCommand(installCmd)
 
-	installCmd.Flags().StringVarP(&installImage, "image", "i", "", "Container image reference (required)")
+	installCmd.Flags().StringVarP(&installImage, "image", "i", "", "Container image reference (required unless default-image is configured)")
 	installCmd.Flags().StringVarP(&installDevice, "device", "d", "", "Target disk device (required)")
 	installCmd.Flags().BoolVar(&installSkipPull, "skip-pull", false, "Skip pulling the image (use already pulled image)")
 	installCmd.Flags().StringArrayVarP(&installKernelArgs, "karg", "k", []string{}, "Kernel argument to pass (can be specified multiple times)")
 	installCmd.Flags().StringVarP(&installFilesystem, "filesystem", "f", "ext4", "Filesystem type for root and var partitions (ext4, btrfs)")
-
-	_ = installCmd.MarkFlagRequired("image")
-	_ = installCmd.MarkFlagRequired("device")
+	installCmd.Flags().BoolVar(&installForceUnmount, "force-unmount", false, "Unmount filesystems, disable swap and deactivate LVM/dm devices on the disk before wiping")
+	installCmd.Flags().BoolVar(&installPin, "pin", false, "Pin updates to the digest being installed (see 'phukit update --repin')")
+	installCmd.Flags().StringVar(&installPinPolicy, "pin-policy", pkg.PinPolicyWarn, "What update does when the tag moves away from the pin (warn, fail)")
+	installCmd.Flags().BoolVar(&installVerity, "verity", false, "Install a read-only root protected by dm-verity (ext4 only)")
+	installCmd.Flags().BoolVar(&installComposefs, "composefs", false, "Store /usr as a composefs image backed by an object store on /var shared by both roots")
+	installCmd.Flags().BoolVar(&installWritableUsr, "writable-usr", false, "Do not mount /usr read-only on the installed system")
+	installCmd.MarkFlagsMutuallyExclusive("verity", "composefs")
+	installCmd.Flags().BoolVar(&installPlan, "plan", false, "Print the partition layout and install steps without changing anything")
+	installCmd.Flags().StringVar(&installKickstart, "kickstart", "", "Kickstart file or URL describing the install")
+	installCmd.Flags().StringVar(&installIgnition, "ignition", "", "Ignition config file or URL to apply to the installed system")
+	installCmd.Flags().StringVar(&installRootPassword, "root-password-hash", "", "crypt(3) hash of the root password (see 'openssl passwd -6')")
+	installCmd.Flags().StringArrayVar(&installUsers, "user", []string{}, "User to create, as name or name:group,group (can be specified multiple times)")
+	installCmd.Flags().String("hostname", "", "Static hostname of the installed system")
+	installCmd.Flags().String("timezone", "", "Timezone of the installed system, such as Europe/Berlin")
+	installCmd.Flags().String("locale", "", "Locale of the installed system, such as en_US.UTF-8")
+	for _, key := range []string{"hostname", "timezone", "locale"} {
+		_ = viper.BindPFlag(key, installCmd.Flags().Lookup(key))
+	}
+	installCmd.Flags().BoolVar(&installFirstboot, "firstboot", false, "Install a service that grows /var, commits the machine ID and generates SSH host keys on first boot")
+	installCmd.Flags().StringArrayVar(&installFirstbootScripts, "firstboot-script", []string{}, "Script to run once on first boot (implies --firstboot; can be specified multiple times)")
+	installCmd.Flags().StringVar(&installSwap, "swap", "", "Size of a swap partition to add, such as 8G")
+	installCmd.Flags().BoolVar(&installHibernate, "hibernate", false, "Add a swap partition that holds all of RAM and set up hibernation")
+	installCmd.Flags().BoolVar(&installPreserveData, "preserve-data", false, "Reinstall into an existing phukit install, keeping its /var and swap partitions")
+	installCmd.Flags().BoolVar(&installLVM, "lvm", false, "Put the roots, /var and swap on logical volumes of an LVM volume group")
+	installCmd.Flags().StringVar(&installVolumeGroup, "volume-group", "", "Name of the LVM volume group (implies --lvm; default "+pkg.DefaultVolumeGroup+")")
+	installCmd.Flags().BoolVar(&installReusePartitions, "reuse-partitions", false, "Install into the partitions of an existing phukit install without wiping the disk")
+	installCmd.Flags().StringArrayVar(&installPartitions, "partition", []string{}, "Existing partition to install into, as role=partition (implies --reuse-partitions; can be specified multiple times)")
+	installCmd.Flags().BoolVar(&installGrow, "grow", false, "Grow /var into the rest of the disk and the root and /var filesystems into their partitions on boot")
+	installCmd.Flags().BoolVarP(&installYes, "yes", "y", false, "Skip the confirmation prompt, for unattended installs")
+	installCmd.Flags().BoolVar(&installYes, "force", false, "Skip the confirmation prompt (same as --yes)")
+	addFormatFlag(installCmd, &installFormat, "Output format: text, or json for only the result document on stdout (needs --yes)")
+	installCmd.Flags().BoolVar(&installConfirmDevice, "confirm-device", false, "Ask for the device path instead of 'yes' to confirm (always on for disks of 1 TiB or more)")
+	installCmd.Flags().StringVar(&installWipeMode, "wipe-mode", string(pkg.WipeSignatures), "How to wipe the disk before partitioning: signatures, discard, zero or secure-erase")
+	installCmd.Flags().StringArrayVar(&installMountOptions, "mount-option", []string{}, "Mount options of the root or /var filesystem, as mountpoint=options (can be specified multiple times)")
+	installCmd.Flags().StringVar(&installMountBy, "mount-by", string(pkg.MountByUUID), "How generated mounts name filesystems: uuid or label")
+	installCmd.Flags().StringVar(&installVarMount, "var-mount", string(pkg.VarMountCmdline), "How /var is mounted: cmdline, fstab or systemd")
+	installCmd.Flags().StringArrayVar(&installBootProfiles, "boot-profile", []string{}, "Extra boot entry with more kernel arguments, as name=arguments (can be specified multiple times)")
+	installCmd.Flags().StringVar(&installConsole, "console", "auto", "Serial console of the installed system: auto, none, or a port such as ttyS0,115200n8")
+	installCmd.Flags().Bool("lock-bootloader", false, "Keep console users from editing the kernel command line in the boot menu")
+	installCmd.Flags().String("grub-superuser", pkg.DefaultGRUBSuperuser, "GRUB user allowed to edit boot entries of a locked bootloader")
+	installCmd.Flags().String("grub-password-hash", "", "grub.pbkdf2.sha512 hash of the GRUB superuser's password (implies --lock-bootloader)")
+	for _, key := range []string{"lock-bootloader", "grub-superuser", "grub-password-hash"} {
+		_ = viper.BindPFlag(key, installCmd.Flags().Lookup(key))
+	}
+	installCmd.Flags().StringArrayVar(&installSSHKeys, "ssh-authorized-key", []string{}, "SSH public key or key file to authorize, as [user:]key (root by default; can be specified multiple times)")
+	installCmd.MarkFlagsMutuallyExclusive("preserve-data", "swap")
+	installCmd.MarkFlagsMutuallyExclusive("preserve-data", "hibernate")
+	installCmd.MarkFlagsMutuallyExclusive("preserve-data", "plan")
+	for _, flag := range []string{"swap", "hibernate", "plan"} {
+		installCmd.MarkFlagsMutuallyExclusive("reuse-partitions", flag)
+		installCmd.MarkFlagsMutuallyExclusive("partition", flag)
+	}
+	for _, flag := range []string{"verity", "plan", "preserve-data", "reuse-partitions", "partition"} {
+		installCmd.MarkFlagsMutuallyExclusive("lvm", flag)
+		installCmd.MarkFlagsMutuallyExclusive("volume-group", flag)
+	}
+	for _, flag := range []string{"verity", "swap", "hibernate", "lvm", "volume-group", "preserve-data", "reuse-partitions", "partition"} {
+		installCmd.MarkFlagsMutuallyExclusive("grow", flag)
+	}
+	for _, flag := range []string{"preserve-data", "reuse-partitions", "partition"} {
+		installCmd.MarkFlagsMutuallyExclusive("wipe-mode", flag)
+	}
+	installCmd.MarkFlagsMutuallyExclusive("yes", "confirm-device")
+	installCmd.MarkFlagsMutuallyExclusive("force", "confirm-device")
+	installCmd.MarkFlagsMutuallyExclusive("format", "plan")
 }
 
 func runInstall(cmd *cobra.Command, args []string) error {
-	verbose := viper.GetBool("verbose")
-	dryRun := viper.GetBool("dry-run")
+	return runOperation(cmd, "install", func(ctx context.Context) error {
+		verbose := viper.GetBool("verbose")
+		dryRun := viper.GetBool("dry-run")
 
-	// Validate filesystem type
-	if installFilesystem != "ext4" && installFilesystem != "btrfs" {
-		return fmt.Errorf("unsupported filesystem type: %s (supported: ext4, btrfs)", installFilesystem)
-	}
+		// JSON output has no room for the confirmation prompt
+		if outputFormat(cmd) == formatJSON && !installYes && !dryRun {
+			return fmt.Errorf("--format json cannot ask for confirmation; add --yes")
+		}
 
-	// Resolve device path
-	device, err := pkg.GetDiskByPath(installDevice)
-	if err != nil {
-		return fmt.Errorf("invalid device: %w", err)
-	}
+		// Validate filesystem type
+		if installFilesystem != "ext4" && installFilesystem != "btrfs" {
+			return fmt.Errorf("unsupported filesystem type: %s (supported: ext4, btrfs)", installFilesystem)
+		}
+
+		if installPinPolicy != pkg.PinPolicyWarn && installPinPolicy != pkg.PinPolicyFail {
+			return fmt.Errorf("unsupported pin policy: %s (supported: warn, fail)", installPinPolicy)
+		}
+
+		var ks *pkg.Kickstart
+		if installKickstart != "" {
+			var err error
+			if ks, err = pkg.LoadKickstart(ctx, installKickstart); err != nil {
+				return err
+			}
+			if len(ks.Ignored) > 0 {
+				fmt.Printf("Ignoring kickstart commands that do not apply: %s\n", strings.Join(ks.Ignored, ", "))
+			}
+		}
+
+		var ign *pkg.Ignition
+		if installIgnition != "" {
+			var err error
+			if ign, err = pkg.LoadIgnition(ctx, installIgnition); err != nil {
+				return err
+			}
+			if len(ign.Ignored) > 0 {
+				fmt.Printf("Ignoring Ignition sections that do not apply: %s\n", strings.Join(ign.Ignored, ", "))
+			}
+		}
+
+		image := installImage
+		if image == "" && ks != nil {
+			image = ks.ImageRef
+		}
+		if image == "" {
+			image = viper.GetString("default-image")
+		}
+		if image == "" {
+			return fmt.Errorf("--image is required")
+		}
+
+		// Resolve device path
+		device := installDevice
+		if device == "" && ks != nil && !ks.Disk.Empty() {
+			disks, err := pkg.ListDisks()
+			if err != nil {
+				return err
+			}
+			if device, err = ks.Disk.Select(disks); err != nil {
+				return fmt.Errorf("failed to select disk: %w", err)
+			}
+			fmt.Printf("Selected disk %s\n", device)
+		}
+		if device == "" {
+			return fmt.Errorf("--device is required")
+		}
+		device, err := pkg.GetDiskByPath(device)
+		if err != nil {
+			return fmt.Errorf("invalid device: %w", err)
+		}
 
-	if verbose {
-		fmt.Printf("Resolved device: %s\n", device)
+		if verbose {
+			fmt.Printf("Resolved device: %s\n", device)
+		}
+
+		// Create installer
+		installer := pkg.NewBootcInstaller(image, device)
+		installer.SetVerbose(verbose)
+		installer.SetDryRun(dryRun)
+		installer.SetExecutor(operationExecutor())
+		installer.SetFilesystemType(installFilesystem)
+		installer.SetForceUnmount(installForceUnmount)
+		installer.SetConfirmDevice(installConfirmDevice)
+		installer.SetAssumeYes(installYes)
+		wipeMode, err := pkg.ParseWipeMode(installWipeMode)
+		if err != nil {
+			return err
+		}
+		installer.SetWipeMode(wipeMode)
+		installer.SetPinImage(installPin, installPinPolicy)
+		installer.SetVerity(installVerity)
+		installer.SetComposefs(installComposefs)
+		installer.SetWritableUsr(installWritableUsr)
+
+		if vg := installVolumeGroup; vg != "" {
+			installer.SetVolumeGroup(vg)
+		} else if installLVM || (ks != nil && ks.LVM) {
+			installer.SetVolumeGroup(pkg.DefaultVolumeGroup)
+		}
+		installer.SetPreserveData(installPreserveData)
+		reusePartitions := installReusePartitions || len(installPartitions) > 0
+		installer.SetReusePartitions(reusePartitions, installPartitions)
+
+		swapSize, err := installSwapSize(ks)
+		if err != nil {
+			return err
+		}
+		if (installPreserveData || reusePartitions) && swapSize > 0 {
+			return fmt.Errorf("installing into existing partitions cannot add swap")
+		}
+		installer.SetSwapSize(swapSize)
+		installer.SetGrowVar(installGrow || (ks != nil && ks.GrowVar))
+		mountOptions, err := parseMountOptions(installMountOptions)
+		if err != nil {
+			return err
+		}
+		installer.SetMountOptions(mountOptions)
+		mountBy, varMount, err := parseMountStyle(cmd, installMountBy, installVarMount)
+		if err != nil {
+			return err
+		}
+		installer.SetMountStyle(mountBy, varMount)
+		bootProfiles, err := parseBootProfiles(installBootProfiles)
+		if err != nil {
+			return err
+		}
+		installer.SetBootProfiles(bootProfiles)
+
+		provisioning := &pkg.Provisioning{}
+		if ks != nil {
+			for _, arg := range ks.KernelArgs {
+				installer.AddKernelArg(arg)
+			}
+			for _, unit := range ks.EnableUnits {
+				installer.AddEnabledUnit(unit)
+			}
+			provisioning.Merge(&ks.Provisioning)
+		}
+		if ign != nil {
+			for _, arg := range ign.KernelArgs {
+				installer.AddKernelArg(arg)
+			}
+			provisioning.Merge(&ign.Provisioning)
+		}
+		for _, spec := range installUsers {
+			if err := provisioning.AddUserSpec(spec); err != nil {
+				return err
+			}
+		}
+		for _, spec := range installSSHKeys {
+			if err := provisioning.AddSSHKeySpec(spec); err != nil {
+				return err
+			}
+		}
+		if installRootPassword != "" {
+			if err := provisioning.SetPasswordHash("root", installRootPassword); err != nil {
+				return err
+			}
+		}
+		if hostname := viper.GetString("hostname"); hostname != "" {
+			if err := provisioning.SetHostname(hostname); err != nil {
+				return err
+			}
+		}
+		if tz := viper.GetString("timezone"); tz != "" {
+			if err := provisioning.SetTimezone(tz); err != nil {
+				return err
+			}
+		}
+		if locale := viper.GetString("locale"); locale != "" {
+			if err := provisioning.SetLocale(locale); err != nil {
+				return err
+			}
+		}
+		installer.SetProvisioning(provisioning)
+		if err := pkg.CheckFirstbootScripts(installFirstbootScripts); err != nil {
+			return err
+		}
+		installer.SetFirstboot(installFirstboot, installFirstbootScripts)
+
+		// Add kernel arguments; --karg replaces the configured ones
+		kernelArgs := installKernelArgs
+		if !cmd.Flags().Changed("karg") {
+			kernelArgs = viper.GetStringSlice("kernel-args")
+		}
+		for _, arg := range kernelArgs {
+			installer.AddKernelArg(arg)
+		}
+		console, err := installSerialConsole(installConsole, installer.KernelArgs)
+		if err != nil {
+			return err
+		}
+		if console != nil {
+			installer.SetSerialConsole(console)
+		}
+		if viper.GetBool("lock-bootloader") || viper.GetString("grub-password-hash") != "" {
+			lockdown, err := pkg.NewBootLockdown(viper.GetString("grub-superuser"), viper.GetString("grub-password-hash"))
+			if err != nil {
+				return err
+			}
+			installer.SetLockdown(lockdown)
+		}
+
+		if installPlan {
+			plan, err := installer.Plan(ctx)
+			if err != nil {
+				return err
+			}
+			plan.Print(pkg.Stdout())
+			return nil
+		}
+
+		lock, err := lockOperation(ctx)
+		if err != nil {
+			return err
+		}
+		defer func() { _ = lock.Unlock() }()
+
+		// Run installation
+		if err := installer.InstallComplete(ctx, installSkipPull); err != nil {
+			return err
+		}
+
+		if !dryRun {
+			fmt.Println()
+			fmt.Println("=================================================================")
+			fmt.Println("Installation complete! You can now boot from this disk.")
+			fmt.Println("Make sure to configure your system's boot order if needed.")
+			fmt.Println("=================================================================")
+		}
+
+		if ks != nil && ks.Reboot {
+			return pkg.Reboot(ctx, dryRun)
+		}
+		return nil
+	})
+}
+
+// installSerialConsole returns the serial console --console asks for: the
+// detected one for auto, unless kernelArgs already have one, or nil for none
+func installSerialConsole(spec string, kernelArgs []string) (*pkg.SerialConsole, error) {
+	switch spec {
+	case "none":
+		return nil, nil
+	case "auto":
+		if pkg.HasSerialConsoleArg(kernelArgs) {
+			return nil, nil
+		}
+		console, source := pkg.DetectSerialConsole()
+		if console != nil {
+			fmt.Printf("Detected serial console %s (%s)\n", console, source)
+		}
+		return console, nil
 	}
+	return pkg.ParseSerialConsole(spec)
+}
 
-	// Create installer
-	installer := pkg.NewBootcInstaller(installImage, device)
-	installer.SetVerbose(verbose)
-	installer.SetDryRun(dryRun)
-	installer.SetFilesystemType(installFilesystem)
+// installSwapSize returns the size of the swap partition requested with
+// --swap, --hibernate or the kickstart file, or 0 for none
+func installSwapSize(ks *pkg.Kickstart) (uint64, error) {
+	var size uint64
+	if installSwap != "" {
+		n, err := pkg.ParseSize(installSwap)
+		if err != nil {
+			return 0, fmt.Errorf("invalid --swap size: %w", err)
+		}
+		size = uint64(n)
+	} else if ks != nil {
+		size = ks.SwapSize
+	}
+	if !installHibernate && (ks == nil || !ks.Hibernate) {
+		return size, nil
+	}
 
-	// Add kernel arguments
-	for _, arg := range installKernelArgs {
-		installer.AddKernelArg(arg)
+	if size == 0 {
+		return pkg.HibernationSwapSize()
 	}
+	ram, err := pkg.MemoryTotal()
+	if err != nil {
+		return 0, err
+	}
+	if size < ram {
+		return 0, fmt.Errorf("swap size %s cannot hold all of RAM (%s) to hibernate", pkg.FormatSize(size), pkg.FormatSize(ram))
+	}
+	return size, nil
+}
 
-	// Run installation
-	if err := installer.InstallComplete(installSkipPull); err != nil {
-		return err
+// parseBootProfiles returns the boot profiles of the boot-profiles map in
+// the configuration file, overridden by those given as name=arguments on the
+// command line
+func parseBootProfiles(specs []string) ([]pkg.BootProfile, error) {
+	byName := map[string]pkg.BootProfile{}
+	for name, args := range viper.GetStringMapString("boot-profiles") {
+		profile, err := pkg.NewBootProfile(name, args)
+		if err != nil {
+			return nil, fmt.Errorf("invalid boot-profiles in the configuration file: %w", err)
+		}
+		byName[profile.Name] = profile
 	}
+	for _, spec := range specs {
+		profile, err := pkg.ParseBootProfile(spec)
+		if err != nil {
+			return nil, err
+		}
+		byName[profile.Name] = profile
+	}
+	profiles := slices.SortedFunc(maps.Values(byName), func(a, b pkg.BootProfile) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	return profiles, nil
+}
 
-	if !dryRun {
-		fmt.Println()
-		fmt.Println("=================================================================")
-		fmt.Println("Installation complete! You can now boot from this disk.")
-		fmt.Println("Make sure to configure your system's boot order if needed.")
-		fmt.Println("=================================================================")
+// parseMountStyle returns the mount identifier and /var mount of the
+// --mount-by and --var-mount flags, or else of mount-by and var-mount in the
+// configuration file
+func parseMountStyle(cmd *cobra.Command, mountBy, varMount string) (pkg.MountIdentifier, pkg.VarMount, error) {
+	if !cmd.Flags().Changed("mount-by") && viper.IsSet("mount-by") {
+		mountBy = viper.GetString("mount-by")
 	}
+	if !cmd.Flags().Changed("var-mount") && viper.IsSet("var-mount") {
+		varMount = viper.GetString("var-mount")
+	}
+	id, err := pkg.ParseMountIdentifier(mountBy)
+	if err != nil {
+		return "", "", err
+	}
+	vm, err := pkg.ParseVarMount(varMount)
+	if err != nil {
+		return "", "", err
+	}
+	return id, vm, nil
+}
 
-	return nil
+// parseMountOptions returns the mount options of the mount-options map
+// in the configuration file, overridden by those given as
+// mountpoint=options on the command line
+func parseMountOptions(specs []string) (pkg.MountOptions, error) {
+	options, err := pkg.ParseMountOptions(specs)
+	if err != nil {
+		return nil, err
+	}
+	for target, opts := range viper.GetStringMapString("mount-options") {
+		if _, ok := options[target]; ok {
+			continue
+		}
+		if err := options.Set(target, opts); err != nil {
+			return nil, fmt.Errorf("invalid mount-options in the configuration file: %w", err)
+		}
+	}
+	return options, nil
 }
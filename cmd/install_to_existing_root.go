@@ -0,0 +1,124 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+
+	"github.com/bketelsen/phukit/pkg"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var (
+	takeoverImage       string
+	takeoverRoot        string
+	takeoverVar         string
+	takeoverSkipPull    bool
+	takeoverKernelArgs  []string
+	takeoverPin         bool
+	takeoverPinPolicy   string
+	takeoverComposefs   bool
+	takeoverWritableUsr bool
+	takeoverForce       bool
+)
+
+var installToExistingRootCmd = &cobra.Command{
+	Use:   "to-existing-root",
+	Short: "Convert the running system in place",
+	Long: `Take over a conventionally installed machine without install media.
+
+The image is installed to a spare partition (--root), which becomes the first
+root, and /var moves to another spare partition (--var). Both are formatted.
+The running system's /etc is merged into the new root and its /var is copied,
+and the existing EFI system partition becomes the boot partition, with the
+bootloader switched to boot the new root.
+
+The running root becomes the second root: its contents are kept until the
+first update overwrites them. It must be ext4 or btrfs, and the new root is formatted
+with the same filesystem. Create the spare partitions beforehand, for example
+from free space or a shrunk partition, on the same disk as the running root.
+
+Example:
+  phukit install to-existing-root --image quay.io/example/myimage:latest --root /dev/sda3 --var /dev/sda4`,
+	Args: cobra.NoArgs,
+	RunE: runInstallToExistingRoot,
+}
+
+func init() {
+	installCmd.AddCommand(installToExistingRootCmd)
+
+	installToExistingRootCmd.Flags().StringVarP(&takeoverImage, "image", "i", "", "Container image reference (required)")
+	installToExistingRootCmd.Flags().StringVar(&takeoverRoot, "root", "", "Spare partition for the new root (required, will be formatted)")
+	installToExistingRootCmd.Flags().StringVar(&takeoverVar, "var", "", "Spare partition for /var (required, will be formatted)")
+	installToExistingRootCmd.Flags().BoolVar(&takeoverSkipPull, "skip-pull", false, "Skip pulling the image (use already pulled image)")
+	installToExistingRootCmd.Flags().StringArrayVarP(&takeoverKernelArgs, "karg", "k", []string{}, "Kernel argument to pass (can be specified multiple times)")
+	installToExistingRootCmd.Flags().BoolVar(&takeoverPin, "pin", false, "Pin updates to the digest being installed (see 'phukit update --repin')")
+	installToExistingRootCmd.Flags().StringVar(&takeoverPinPolicy, "pin-policy", pkg.PinPolicyWarn, "What update does when the tag moves away from the pin (warn, fail)")
+	installToExistingRootCmd.Flags().BoolVar(&takeoverComposefs, "composefs", false, "Store /usr as a composefs image backed by an object store on /var shared by both roots")
+	installToExistingRootCmd.Flags().BoolVar(&takeoverWritableUsr, "writable-usr", false, "Do not mount /usr read-only on the installed system")
+	installToExistingRootCmd.Flags().BoolVarP(&takeoverForce, "force", "f", false, "Skip the confirmation prompt")
+
+	_ = installToExistingRootCmd.MarkFlagRequired("image")
+	_ = installToExistingRootCmd.MarkFlagRequired("root")
+	_ = installToExistingRootCmd.MarkFlagRequired("var")
+}
+
+func runInstallToExistingRoot(cmd *cobra.Command, args []string) error {
+	return runOperation(cmd, "install", func(ctx context.Context) error {
+		verbose := viper.GetBool("verbose")
+		dryRun := viper.GetBool("dry-run")
+
+		if takeoverPinPolicy != pkg.PinPolicyWarn && takeoverPinPolicy != pkg.PinPolicyFail {
+			return fmt.Errorf("unsupported pin policy: %s (supported: warn, fail)", takeoverPinPolicy)
+		}
+
+		root, err := filepath.EvalSymlinks(takeoverRoot)
+		if err != nil {
+			return fmt.Errorf("invalid root partition: %w", err)
+		}
+		varPartition, err := filepath.EvalSymlinks(takeoverVar)
+		if err != nil {
+			return fmt.Errorf("invalid var partition: %w", err)
+		}
+		scheme, device, err := pkg.TakeoverPartitionScheme(root, varPartition)
+		if err != nil {
+			return err
+		}
+		if verbose {
+			fmt.Printf("Resolved device: %s\n", device)
+		}
+
+		installer := pkg.NewBootcInstaller(takeoverImage, device)
+		installer.SetVerbose(verbose)
+		installer.SetDryRun(dryRun)
+		installer.SetExecutor(operationExecutor())
+		installer.SetPinImage(takeoverPin, takeoverPinPolicy)
+		installer.SetComposefs(takeoverComposefs)
+		installer.SetWritableUsr(takeoverWritableUsr)
+		installer.SetAssumeYes(takeoverForce)
+		for _, arg := range takeoverKernelArgs {
+			installer.AddKernelArg(arg)
+		}
+
+		lock, err := lockOperation(ctx)
+		if err != nil {
+			return err
+		}
+		defer func() { _ = lock.Unlock() }()
+
+		if err := installer.InstallToExistingRoot(ctx, scheme, takeoverSkipPull); err != nil {
+			return err
+		}
+
+		if !dryRun {
+			fmt.Println()
+			fmt.Println("=================================================================")
+			fmt.Println("Takeover complete! Reboot to start the new system.")
+			fmt.Printf("The previous system on %s is kept until the first update\n", scheme.Root2Partition)
+			fmt.Println("replaces it.")
+			fmt.Println("=================================================================")
+		}
+		return nil
+	})
+}
@@ -0,0 +1,117 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+
+	"github.com/bketelsen/phukit/pkg"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var (
+	toFilesystemImage       string
+	toFilesystemRoot2       string
+	toFilesystemSkipPull    bool
+	toFilesystemKernelArgs  []string
+	toFilesystemPin         bool
+	toFilesystemPinPolicy   string
+	toFilesystemComposefs   bool
+	toFilesystemWritableUsr bool
+)
+
+var installToFilesystemCmd = &cobra.Command{
+	Use:   "to-filesystem <mount-point>",
+	Short: "Install a bootc container to partitions that are already mounted",
+	Long: `Install a bootc compatible container image into a target root that has
+already been partitioned, formatted and mounted, for custom layouts, other
+installers and image-build pipelines. Nothing is wiped, partitioned or
+formatted, and the target stays mounted afterwards.
+
+The target must be laid out like this:
+  <mount-point>        first root partition (ext4 or btrfs)
+  <mount-point>/boot   EFI system partition (vfat)
+  <mount-point>/var    var partition, shared by both roots
+
+--root2 names the partition for the second root, which updates are installed
+to. It must be on the same disk as the first root, formatted with the same
+filesystem, and not mounted. The partitions are recorded in the installed
+configuration, so updates work whatever their numbers are.
+
+Example:
+  phukit install to-filesystem --image quay.io/example/myimage:latest --root2 /dev/sda3 /mnt/target`,
+	Args: cobra.ExactArgs(1),
+	RunE: runInstallToFilesystem,
+}
+
+func init() {
+	installCmd.AddCommand(installToFilesystemCmd)
+
+	installToFilesystemCmd.Flags().StringVarP(&toFilesystemImage, "image", "i", "", "Container image reference (required)")
+	installToFilesystemCmd.Flags().StringVar(&toFilesystemRoot2, "root2", "", "Partition for the second root (required)")
+	installToFilesystemCmd.Flags().BoolVar(&toFilesystemSkipPull, "skip-pull", false, "Skip pulling the image (use already pulled image)")
+	installToFilesystemCmd.Flags().StringArrayVarP(&toFilesystemKernelArgs, "karg", "k", []string{}, "Kernel argument to pass (can be specified multiple times)")
+	installToFilesystemCmd.Flags().BoolVar(&toFilesystemPin, "pin", false, "Pin updates to the digest being installed (see 'phukit update --repin')")
+	installToFilesystemCmd.Flags().StringVar(&toFilesystemPinPolicy, "pin-policy", pkg.PinPolicyWarn, "What update does when the tag moves away from the pin (warn, fail)")
+	installToFilesystemCmd.Flags().BoolVar(&toFilesystemComposefs, "composefs", false, "Store /usr as a composefs image backed by an object store on /var shared by both roots")
+	installToFilesystemCmd.Flags().BoolVar(&toFilesystemWritableUsr, "writable-usr", false, "Do not mount /usr read-only on the installed system")
+
+	_ = installToFilesystemCmd.MarkFlagRequired("image")
+	_ = installToFilesystemCmd.MarkFlagRequired("root2")
+}
+
+func runInstallToFilesystem(cmd *cobra.Command, args []string) error {
+	return runOperation(cmd, "install", func(ctx context.Context) error {
+		verbose := viper.GetBool("verbose")
+		dryRun := viper.GetBool("dry-run")
+
+		if toFilesystemPinPolicy != pkg.PinPolicyWarn && toFilesystemPinPolicy != pkg.PinPolicyFail {
+			return fmt.Errorf("unsupported pin policy: %s (supported: warn, fail)", toFilesystemPinPolicy)
+		}
+
+		mountPoint, err := filepath.Abs(args[0])
+		if err != nil {
+			return fmt.Errorf("invalid mount point: %w", err)
+		}
+		root2, err := filepath.EvalSymlinks(toFilesystemRoot2)
+		if err != nil {
+			return fmt.Errorf("invalid second root partition: %w", err)
+		}
+		scheme, device, err := pkg.FilesystemPartitionScheme(mountPoint, root2)
+		if err != nil {
+			return err
+		}
+		if verbose {
+			fmt.Printf("Resolved device: %s\n", device)
+		}
+
+		installer := pkg.NewBootcInstaller(toFilesystemImage, device)
+		installer.SetVerbose(verbose)
+		installer.SetDryRun(dryRun)
+		installer.SetExecutor(operationExecutor())
+		installer.SetMountPoint(mountPoint)
+		installer.SetPinImage(toFilesystemPin, toFilesystemPinPolicy)
+		installer.SetComposefs(toFilesystemComposefs)
+		installer.SetWritableUsr(toFilesystemWritableUsr)
+		for _, arg := range toFilesystemKernelArgs {
+			installer.AddKernelArg(arg)
+		}
+
+		lock, err := lockOperation(ctx)
+		if err != nil {
+			return err
+		}
+		defer func() { _ = lock.Unlock() }()
+
+		if err := installer.InstallToFilesystem(ctx, scheme, toFilesystemSkipPull); err != nil {
+			return err
+		}
+
+		if !dryRun {
+			fmt.Println()
+			fmt.Printf("Installation complete. %s is still mounted; unmount it before booting.\n", mountPoint)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,150 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/bketelsen/phukit/pkg"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var (
+	kargDevice  string
+	kargJSON    bool
+	kargReplace bool
+)
+
+var kargCmd = &cobra.Command{
+	Use:   "karg",
+	Short: "Manage the persistent kernel arguments of the installed system",
+	Long: `List, add and remove the kernel arguments every boot entry gets.
+
+The arguments are recorded in /etc/phukit/config.json of both roots, so later
+updates keep them, and the boot entries of both roots are rewritten right
+away, keeping the default one. The change applies on the next boot, without an
+update. The arguments phukit sets itself, such as root= and the /var mount,
+are not listed and cannot be changed. The default arguments the image ships
+(see /usr/lib/phukit/kargs.d) are listed separately; an argument added here
+replaces an image argument with the same key.
+
+remove takes an argument (console=ttyS0) or a key without a value (console),
+which removes every value of it. To change a value, remove the key and add the
+new value in one go with 'karg add --replace'.
+
+Example:
+  phukit karg list
+  phukit karg add console=ttyS0,115200n8
+  phukit karg add --replace systemd.unified_cgroup_hierarchy=1
+  phukit karg remove quiet rhgb`,
+}
+
+var kargListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the persistent kernel arguments",
+	Args:  cobra.NoArgs,
+	RunE:  runKargList,
+}
+
+var kargAddCmd = &cobra.Command{
+	Use:   "add ARG...",
+	Short: "Add kernel arguments to both boot entries",
+	Args:  cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		edit := pkg.KernelArgEdit{Add: args}
+		if kargReplace {
+			for _, arg := range args {
+				key, _, _ := strings.Cut(arg, "=")
+				edit.Remove = append(edit.Remove, key)
+			}
+		}
+		return runKargEdit(cmd, edit)
+	},
+}
+
+var kargRemoveCmd = &cobra.Command{
+	Use:   "remove ARG...",
+	Short: "Remove kernel arguments from both boot entries",
+	Args:  cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runKargEdit(cmd, pkg.KernelArgEdit{Remove: args})
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(kargCmd)
+	kargCmd.AddCommand(kargListCmd, kargAddCmd, kargRemoveCmd)
+
+	kargCmd.PersistentFlags().StringVarP(&kargDevice, "device", "d", "", "Boot disk device (auto-detected if not specified)")
+	kargListCmd.Flags().BoolVar(&kargJSON, "json", false, "Output in JSON format")
+	kargAddCmd.Flags().BoolVar(&kargReplace, "replace", false, "Remove the other values of each argument's key first")
+}
+
+func runKargList(cmd *cobra.Command, args []string) error {
+	config, err := pkg.ReadSystemConfig()
+	if err != nil {
+		return err
+	}
+	// Older installs recorded the arguments phukit generates as well
+	kargs := pkg.UserKernelArgs(config.KernelArgs)
+
+	if kargJSON {
+		data, err := json.MarshalIndent(kargs, "", "  ")
+		if err != nil {
+			return fmt.Errorf("failed to marshal kernel arguments: %w", err)
+		}
+		fmt.Println(string(data))
+		return nil
+	}
+	if len(kargs) == 0 {
+		fmt.Println("No kernel arguments set.")
+	}
+	for _, arg := range kargs {
+		fmt.Println(arg)
+	}
+	if imageArgs := pkg.BootedImageKernelArgs(); len(imageArgs) > 0 {
+		fmt.Println()
+		fmt.Println("Image defaults:")
+		for _, arg := range imageArgs {
+			fmt.Printf("  %s\n", arg)
+		}
+	}
+	return nil
+}
+
+func runKargEdit(cmd *cobra.Command, edit pkg.KernelArgEdit) error {
+	if err := applyGlobalFlags(); err != nil {
+		return err
+	}
+	verbose := viper.GetBool("verbose")
+	dryRun := viper.GetBool("dry-run")
+
+	device, err := resolveBootDevice(kargDevice, verbose)
+	if err != nil {
+		return err
+	}
+
+	// Updates and rollbacks rewrite the same boot entries
+	lock, err := lockOperation(cmd.Context())
+	if err != nil {
+		return err
+	}
+	defer func() { _ = lock.Unlock() }()
+
+	updater := pkg.NewSystemUpdater(device, "")
+	updater.SetVerbose(verbose)
+	updater.SetDryRun(dryRun)
+	updater.SetExecutor(operationExecutor())
+	kargs, changed, err := updater.EditKernelArgs(cmd.Context(), edit)
+	if err != nil {
+		return err
+	}
+	if changed && !dryRun {
+		fmt.Println()
+		fmt.Printf("Kernel arguments: %s\n", strings.Join(kargs, " "))
+		fmt.Println("Reboot to boot with them.")
+	}
+	printDryRunTrace()
+	return nil
+}
@@ -0,0 +1,77 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/bketelsen/phukit/pkg"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var lintImageJSON bool
+
+var lintImageCmd = &cobra.Command{
+	Use:   "lint-image IMAGE",
+	Short: "Check that a container image can be installed and booted",
+	Long: `Check that an image is bootc-compatible before it is installed or published:
+
+  kernel         a vmlinuz in /usr/lib/modules/<version>
+  initramfs      an initramfs for every kernel, or dracut/mkinitcpio to build one
+  bootloader     grub-install, or bootctl and the systemd-boot EFI binary
+  os-release     /etc/os-release or /usr/lib/os-release with an ID
+  etc-accounts   /etc/passwd and /etc/group
+  machine-id     /etc/machine-id empty, so each system generates its own
+  ssh-host-keys  no SSH host keys baked into /etc/ssh (a warning)
+
+The image is read straight from the registry like 'phukit inspect-image'.
+The command exits with code 24 if a check fails, so CI pipelines building OS
+images can gate on it; with --strict warnings fail too.
+
+Example:
+  phukit lint-image quay.io/my-org/my-image:latest
+  phukit lint-image --strict --json quay.io/my-org/my-image:latest`,
+	Args: cobra.ExactArgs(1),
+	RunE: runLintImage,
+}
+
+func init() {
+	rootCmd.AddCommand(lintImageCmd)
+	lintImageCmd.Flags().BoolVar(&lintImageJSON, "json", false, "Output in JSON format")
+}
+
+// lintStatusSymbols are printed in front of each check
+var lintStatusSymbols = map[string]string{
+	pkg.LintPass: "✓",
+	pkg.LintWarn: "⚠",
+	pkg.LintFail: "✗",
+}
+
+func runLintImage(cmd *cobra.Command, args []string) error {
+	pkg.SetStrictMode(viper.GetBool("strict"))
+
+	info, err := pkg.InspectImage(cmd.Context(), args[0])
+	if err != nil {
+		return err
+	}
+	checks := info.Lint()
+
+	if lintImageJSON {
+		data, err := json.MarshalIndent(struct {
+			ImageRef string          `json:"image_ref"`
+			Digest   string          `json:"digest"`
+			Checks   []pkg.LintCheck `json:"checks"`
+		}{info.ImageRef, info.Digest, checks}, "", "  ")
+		if err != nil {
+			return fmt.Errorf("failed to marshal lint results: %w", err)
+		}
+		fmt.Println(string(data))
+	} else {
+		fmt.Printf("Image:  %s\n", info.ImageRef)
+		fmt.Printf("Digest: %s\n\n", info.Digest)
+		for _, c := range checks {
+			fmt.Printf("%s %-14s %s\n", lintStatusSymbols[c.Status], c.Name, c.Message)
+		}
+	}
+	return pkg.LintFailed(checks)
+}
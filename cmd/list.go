@@ -1,6 +1,7 @@
 package cmd
 
 import (
+	"encoding/json"
 	"fmt"
 
 	"github.com/bketelsen/phukit/pkg"
@@ -8,24 +9,98 @@ import (
 	"github.com/spf13/viper"
 )
 
+var (
+	listJSON      bool
+	listRemovable bool
+	listFixed     bool
+	listUnmounted bool
+	listMinSize   string
+	listMaxSize   string
+	listModel     string
+	listSerial    string
+	listTransport string
+)
+
 var listCmd = &cobra.Command{
-	Use:   "list",
-	Short: "List available disks",
-	Long:  `List all available physical disks on the system.`,
-	RunE:  runList,
+	Use:     "list",
+	Aliases: []string{"disks"},
+	Short:   "List available disks",
+	Long: `List all available physical disks on the system.
+
+The filters narrow the list down, so provisioning scripts can pick a target
+from the JSON output; every filter that is given must match.
+
+Example:
+  phukit list
+  phukit disks --json --fixed --unmounted --min-size 64G
+  phukit disks --json --transport usb`,
+	RunE: runList,
 }
 
 func init() {
 	rootCmd.AddCommand(listCmd)
+	listCmd.Flags().BoolVar(&listJSON, "json", false, "Output in JSON format")
+	listCmd.Flags().BoolVar(&listRemovable, "removable", false, "Only list removable disks")
+	listCmd.Flags().BoolVar(&listFixed, "fixed", false, "Only list non-removable disks")
+	listCmd.Flags().BoolVar(&listUnmounted, "unmounted", false, "Only list disks with no partition mounted")
+	listCmd.Flags().StringVar(&listMinSize, "min-size", "", "Only list disks of at least this size (e.g. 64G)")
+	listCmd.Flags().StringVar(&listMaxSize, "max-size", "", "Only list disks of at most this size (e.g. 2T)")
+	listCmd.Flags().StringVar(&listModel, "model", "", "Only list disks whose model matches this shell pattern")
+	listCmd.Flags().StringVar(&listSerial, "serial", "", "Only list disks whose serial number matches this shell pattern")
+	listCmd.Flags().StringVar(&listTransport, "transport", "", "Only list disks attached by this transport (nvme, sata, usb, virtio, scsi, ...)")
+	listCmd.MarkFlagsMutuallyExclusive("removable", "fixed")
+}
+
+// listFilter builds the disk filter from the list flags
+func listFilter() (pkg.DiskFilter, error) {
+	filter := pkg.DiskFilter{
+		DiskSelector: pkg.DiskSelector{Model: listModel, Serial: listSerial},
+		Transport:    listTransport,
+		Unmounted:    listUnmounted,
+	}
+	if listRemovable || listFixed {
+		filter.Removable = &listRemovable
+	}
+	for _, size := range []struct {
+		flag, value string
+		dst         *uint64
+	}{
+		{"--min-size", listMinSize, &filter.MinSize},
+		{"--max-size", listMaxSize, &filter.MaxSize},
+	} {
+		if size.value == "" {
+			continue
+		}
+		n, err := pkg.ParseSize(size.value)
+		if err != nil {
+			return filter, fmt.Errorf("invalid %s: %w", size.flag, err)
+		}
+		*size.dst = uint64(n)
+	}
+	return filter, nil
 }
 
 func runList(cmd *cobra.Command, args []string) error {
 	verbose := viper.GetBool("verbose")
 
+	filter, err := listFilter()
+	if err != nil {
+		return err
+	}
 	disks, err := pkg.ListDisks()
 	if err != nil {
 		return fmt.Errorf("failed to list disks: %w", err)
 	}
+	disks = filter.Filter(disks)
+
+	if listJSON {
+		data, err := json.MarshalIndent(disks, "", "  ")
+		if err != nil {
+			return fmt.Errorf("failed to marshal disks: %w", err)
+		}
+		fmt.Println(string(data))
+		return nil
+	}
 
 	if len(disks) == 0 {
 		fmt.Println("No disks found.")
@@ -41,7 +116,16 @@ func runList(cmd *cobra.Command, args []string) error {
 		if disk.Model != "" {
 			fmt.Printf("  Model:     %s\n", disk.Model)
 		}
+		if disk.Serial != "" && verbose {
+			fmt.Printf("  Serial:    %s\n", disk.Serial)
+		}
+		if disk.Transport != "" {
+			fmt.Printf("  Transport: %s\n", disk.Transport)
+		}
 		fmt.Printf("  Removable: %v\n", disk.IsRemovable)
+		if disk.Rotational {
+			fmt.Printf("  Rotational: true\n")
+		}
 
 		if len(disk.Partitions) > 0 {
 			fmt.Printf("  Partitions:\n")
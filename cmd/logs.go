@@ -0,0 +1,129 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+	"time"
+
+	"github.com/bketelsen/phukit/pkg"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var (
+	logsList   bool
+	logsFollow bool
+)
+
+var logsCmd = &cobra.Command{
+	Use:   "logs [ID]",
+	Short: "Show the detailed log of the last operation",
+	Long: `Show the detailed log of an install, update or rollback.
+
+Each operation keeps a debug level log, including the output of every command
+it ran, and its result document in a directory named after its start time
+next to the --log-file (/var/log/phukit/<timestamp>/ by default). The last
+10 operations are kept.
+
+Without an ID the newest log is shown.
+
+Example:
+  phukit logs                      # Log of the last operation
+  phukit logs -f                   # Follow a running update
+  phukit logs --list               # List the kept logs
+  phukit logs 20260301-120000      # Log of an earlier operation`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: runLogs,
+}
+
+func init() {
+	rootCmd.AddCommand(logsCmd)
+	logsCmd.Flags().BoolVar(&logsList, "list", false, "List the kept operation logs")
+	logsCmd.Flags().BoolVarP(&logsFollow, "follow", "f", false, "Keep printing the log until the operation finishes")
+}
+
+func runLogs(cmd *cobra.Command, args []string) error {
+	// Only reads the logs, so the log file is not opened like for operations
+	logFile := viper.GetString("log-file")
+	if logFile == "" {
+		return fmt.Errorf("operation logs are disabled (--log-file is empty)")
+	}
+	pkg.SetOperationLogDir(filepath.Dir(logFile))
+	dirs, err := pkg.OperationLogs()
+	if err != nil {
+		return err
+	}
+	if logsList {
+		return listOperationLogs(dirs)
+	}
+
+	var dir string
+	switch {
+	case len(args) == 1:
+		dir = filepath.Join(pkg.OperationLogDir(), args[0])
+		if _, err := os.Stat(filepath.Join(dir, pkg.OperationLogFile)); err != nil {
+			return fmt.Errorf("no operation log %s (see phukit logs --list)", args[0])
+		}
+	case len(dirs) == 0:
+		return fmt.Errorf("no operation logs in %s", pkg.OperationLogDir())
+	default:
+		dir = dirs[len(dirs)-1]
+	}
+	return printOperationLog(cmd.Context(), dir, logsFollow)
+}
+
+// listOperationLogs prints a table of the operation logs
+func listOperationLogs(dirs []string) error {
+	if len(dirs) == 0 {
+		fmt.Println("No operation logs.")
+		return nil
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(w, "ID\tTIME\tOPERATION\tSTATUS")
+	for _, dir := range dirs {
+		operation, status := "-", "incomplete"
+		r, err := pkg.ReadOperationLogResult(dir)
+		if err != nil {
+			status = "unreadable"
+		} else if r != nil {
+			operation, status = r.Operation, r.Status
+			if r.ErrorCode != "" {
+				status += " (" + r.ErrorCode + ")"
+			}
+		}
+		_, _ = fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", filepath.Base(dir),
+			pkg.OperationLogTime(dir).Local().Format("2006-01-02 15:04:05"), operation, status)
+	}
+	return w.Flush()
+}
+
+// printOperationLog copies the log in dir to stdout. With follow it keeps
+// copying until the result document appears or ctx is cancelled.
+func printOperationLog(ctx context.Context, dir string, follow bool) error {
+	f, err := os.Open(filepath.Join(dir, pkg.OperationLogFile))
+	if err != nil {
+		return fmt.Errorf("failed to open operation log: %w", err)
+	}
+	defer func() { _ = f.Close() }()
+
+	for {
+		// Check before copying so the last lines are printed after the
+		// operation finished
+		r, _ := pkg.ReadOperationLogResult(dir)
+		if _, err := io.Copy(os.Stdout, f); err != nil {
+			return fmt.Errorf("failed to read operation log: %w", err)
+		}
+		if !follow || r != nil {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(500 * time.Millisecond):
+		}
+	}
+}
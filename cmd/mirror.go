@@ -0,0 +1,63 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/bketelsen/phukit/pkg"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var mirrorPlatform string
+
+var mirrorCmd = &cobra.Command{
+	Use:   "mirror SOURCE DESTINATION",
+	Short: "Copy an image for air-gapped installs and updates",
+	Long: `Copy a container image from a registry to a place machines without
+registry access can install and update from:
+
+  oci:DIR[:TAG]           An OCI image layout directory; other images in it are kept
+  oci-archive:FILE[:TAG]  A single tar file of an OCI image layout, e.g. on a USB stick
+  [docker://]REFERENCE    Another registry, e.g. one inside the air-gapped network
+
+TAG names the image in the layout and defaults to the source's tag. Only the
+platform of this machine is copied unless --platform names another one, or
+"all" for every platform of a multi-platform image. Layers are copied as they
+are, so the digest stays the same and pins and signatures keep working.
+
+Install or update from a layout or archive with the skopeo container source,
+or from the other registry as usual.
+
+Example:
+  phukit mirror quay.io/my-org/os:42 oci-archive:/media/usb/os.tar
+  phukit update --container-source skopeo --image oci-archive:/media/usb/os.tar:42
+
+  phukit mirror --platform all quay.io/my-org/os:42 oci:/srv/images
+  phukit mirror quay.io/my-org/os:42 registry.internal:5000/my-org/os:42`,
+	Args: cobra.ExactArgs(2),
+	RunE: runMirror,
+}
+
+func init() {
+	rootCmd.AddCommand(mirrorCmd)
+	mirrorCmd.Flags().StringVar(&mirrorPlatform, "platform", "", "Platform to copy, as os/arch[/variant], or all (default: this machine's)")
+}
+
+func runMirror(cmd *cobra.Command, args []string) error {
+	return runOperation(cmd, "mirror", func(ctx context.Context) error {
+		result, err := pkg.MirrorImage(ctx, pkg.MirrorOptions{
+			Source:      args[0],
+			Destination: args[1],
+			Platform:    mirrorPlatform,
+			DryRun:      viper.GetBool("dry-run"),
+		})
+		if err != nil {
+			return err
+		}
+		if !viper.GetBool("dry-run") {
+			fmt.Printf("Copied %s (%s) to %s\n", result.Source, result.Digest, result.Destination)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,94 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/bketelsen/phukit/pkg"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var pinDevice string
+
+var pinCmd = &cobra.Command{
+	Use:   "pin",
+	Short: "Protect the rollback root from being overwritten by updates",
+	Long: `Pin the inactive root partition, which holds the previous (or a staged)
+deployment. Updates write to the inactive root, so while it is pinned every
+update fails with exit code 14 until 'phukit unpin' is run. This keeps a
+known-good recovery image on critical systems.
+
+The pin is kept in /var/lib/phukit/pinned-slot.json and follows the
+partition, so booting into the pinned root and back does not lose it.
+
+Example:
+  phukit pin
+  phukit unpin`,
+	Args: cobra.NoArgs,
+	RunE: runPin,
+}
+
+var unpinCmd = &cobra.Command{
+	Use:   "unpin",
+	Short: "Allow updates to overwrite the pinned root again",
+	Args:  cobra.NoArgs,
+	RunE:  runUnpin,
+}
+
+func init() {
+	rootCmd.AddCommand(pinCmd)
+	rootCmd.AddCommand(unpinCmd)
+
+	pinCmd.Flags().StringVarP(&pinDevice, "device", "d", "", "Boot disk device (auto-detected if not specified)")
+}
+
+func runPin(cmd *cobra.Command, args []string) error {
+	verbose := viper.GetBool("verbose")
+	dryRun := viper.GetBool("dry-run")
+
+	device, err := resolveBootDevice(pinDevice, verbose)
+	if err != nil {
+		return err
+	}
+
+	// An update in progress is writing the inactive root
+	lock, err := lockOperation(cmd.Context())
+	if err != nil {
+		return err
+	}
+	defer func() { _ = lock.Unlock() }()
+
+	pin, err := pkg.PinRollbackSlot(device, dryRun)
+	if err != nil {
+		return err
+	}
+	if dryRun {
+		return nil
+	}
+	fmt.Printf("Pinned %s [Slot %s]\n", pin.Partition, pkg.SlotLabel(pin.Slot))
+	if pin.ImageRef != "" {
+		fmt.Printf("  Image:  %s\n", pin.ImageRef)
+	}
+	if pin.ImageDigest != "" {
+		fmt.Printf("  Digest: %s\n", pin.ImageDigest)
+	}
+	fmt.Println("Updates will fail until it is unpinned with 'phukit unpin'.")
+	return nil
+}
+
+func runUnpin(cmd *cobra.Command, args []string) error {
+	dryRun := viper.GetBool("dry-run")
+
+	pin, err := pkg.UnpinSlot(dryRun)
+	if err != nil {
+		return err
+	}
+	if pin == nil {
+		fmt.Println("No root slot is pinned.")
+		return nil
+	}
+	if !dryRun {
+		fmt.Printf("Unpinned %s [Slot %s]; the next update may overwrite it.\n", pin.Partition, pkg.SlotLabel(pin.Slot))
+	}
+	return nil
+}
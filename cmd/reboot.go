@@ -0,0 +1,64 @@
+package cmd
+
+import (
+	"context"
+
+	"github.com/bketelsen/phukit/pkg"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var (
+	rebootDevice string
+	rebootKexec  bool
+)
+
+var rebootCmd = &cobra.Command{
+	Use:   "reboot",
+	Short: "Reboot into the default boot entry",
+	Long: `Reboot into the default boot entry, which is the new version after an update.
+
+With --kexec the kernel, initramfs and command line of the default entry are
+loaded with kexec and the system reboots straight into them, skipping the
+firmware and bootloader. This saves the firmware's POST, which is slow on
+many edge devices. Firmware and bootloader updates do not take effect on a
+kexec reboot, and with Secure Boot the kernel must be signed for the running
+kernel to load it.
+
+Reboot waits for a running update or rollback to finish.
+
+Example:
+  phukit reboot
+  phukit reboot --kexec               # Skip firmware initialization
+  phukit reboot --kexec -d /dev/sda   # Override auto-detection`,
+	Args: cobra.NoArgs,
+	RunE: runReboot,
+}
+
+func init() {
+	rootCmd.AddCommand(rebootCmd)
+
+	rebootCmd.Flags().StringVarP(&rebootDevice, "device", "d", "", "Boot disk device (auto-detected if not specified)")
+	rebootCmd.Flags().BoolVar(&rebootKexec, "kexec", false, "Load the default entry with kexec and skip firmware initialization")
+}
+
+func runReboot(cmd *cobra.Command, args []string) error {
+	return runOperation(cmd, "reboot", func(ctx context.Context) error {
+		dryRun := viper.GetBool("dry-run")
+
+		lock, err := lockOperation(ctx)
+		if err != nil {
+			return err
+		}
+		defer func() { _ = lock.Unlock() }()
+
+		if !rebootKexec {
+			return pkg.Reboot(ctx, dryRun)
+		}
+		device, err := resolveBootDevice(rebootDevice, viper.GetBool("verbose"))
+		if err != nil {
+			return err
+		}
+		return pkg.KexecReboot(ctx, device, dryRun)
+	})
+}
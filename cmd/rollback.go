@@ -0,0 +1,90 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/bketelsen/phukit/pkg"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var (
+	rollbackDevice  string
+	rollbackForce   bool
+	rollbackKeepVar bool
+)
+
+var rollbackCmd = &cobra.Command{
+	Use:   "rollback",
+	Short: "Boot the other root partition on the next boot",
+	Long: `Make the inactive root partition the default boot entry again.
+
+If an update has been installed but not booted yet, rollback discards it and
+the system keeps running the current version. Otherwise the system goes back
+to the version it ran before the last update.
+
+The change takes effect on the next boot; both versions stay in the boot menu.
+
+If the update being undone snapshotted /var (on LVM or btrfs), /var is
+restored to that snapshot on the next boot as well, so the older version
+doesn't run against state migrated by the newer one. Changes to /var since
+the update are lost; --keep-var keeps /var as it is. Discarding an update
+that was never booted drops its snapshot.
+
+Example:
+  phukit rollback
+  phukit rollback --force            # Don't ask for confirmation
+  phukit rollback --device /dev/sda  # Override auto-detection
+  phukit rollback --keep-var         # Don't restore the /var snapshot`,
+	RunE: runRollback,
+}
+
+func init() {
+	rootCmd.AddCommand(rollbackCmd)
+
+	rollbackCmd.Flags().StringVarP(&rollbackDevice, "device", "d", "", "Boot disk device (auto-detected if not specified)")
+	rollbackCmd.Flags().BoolVarP(&rollbackForce, "force", "f", false, "Skip the confirmation prompt")
+	rollbackCmd.Flags().BoolVar(&rollbackKeepVar, "keep-var", false, "Keep /var as it is instead of restoring the snapshot the update took")
+}
+
+func runRollback(cmd *cobra.Command, args []string) error {
+	return runOperation(cmd, "rollback", func(ctx context.Context) error {
+		return rollbackSystem(ctx, rollbackDevice, rollbackForce, rollbackKeepVar)
+	})
+}
+
+// rollbackSystem makes the other root on device (the boot disk if empty)
+// the default boot entry, restoring the /var snapshot unless keepVar
+func rollbackSystem(ctx context.Context, device string, force, keepVar bool) error {
+	verbose := viper.GetBool("verbose")
+	dryRun := viper.GetBool("dry-run")
+
+	device, err := resolveBootDevice(device, verbose)
+	if err != nil {
+		return err
+	}
+
+	updater := pkg.NewSystemUpdater(device, "")
+	updater.SetVerbose(verbose)
+	updater.SetDryRun(dryRun)
+	updater.SetExecutor(operationExecutor())
+	updater.SetForce(force)
+	updater.SetRestoreVar(!keepVar)
+
+	lock, err := lockOperation(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = lock.Unlock() }()
+
+	if err := updater.Rollback(ctx); err != nil {
+		return err
+	}
+
+	if !dryRun {
+		fmt.Println()
+		fmt.Println("Rollback complete. Reboot to activate it.")
+	}
+	return nil
+}
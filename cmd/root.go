@@ -2,21 +2,41 @@ package cmd
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io/fs"
+	"log/slog"
 	"os"
+	"path/filepath"
+	"strings"
 
+	"github.com/bketelsen/phukit/pkg"
 	"github.com/charmbracelet/fang"
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
 	"github.com/spf13/viper"
 )
 
+// systemConfigFile holds the system-wide defaults, which the user's config
+// file and the flags override
+var systemConfigFile = "/etc/phukit/phukit.yaml"
+
 var (
 	cfgFile string
 	rootCmd = &cobra.Command{
 		Use:   "phukit",
 		Short: "A bootc container installer for physical disks",
 		Long: `phukit is a tool for installing bootc compatible containers to physical disks.
-It automates the process of preparing disks and deploying bootable container images.`,
+It automates the process of preparing disks and deploying bootable container images.
+
+Every flag can also be set with an environment variable named PHUKIT_ and the
+flag name in upper case with - replaced by _, such as PHUKIT_IMAGE,
+PHUKIT_DEVICE, PHUKIT_DRY_RUN=1 or PHUKIT_KARG="console=ttyS0 quiet" (lists
+are separated by spaces). Flags on the command line override them.`,
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			return applyEnvironment(cmd)
+		},
 	}
 )
 
@@ -27,11 +47,18 @@ func SetVersion(version string) {
 
 // Execute runs the root command
 func Execute() error {
+	ctx, stop := signalContext(context.Background())
+	defer stop()
+
+	// Run as "bootc" through a symlink, phukit offers the bootc commands
+	if filepath.Base(os.Args[0]) == "bootc" {
+		rootCmd.SetArgs(append([]string{"bootc"}, os.Args[1:]...))
+	}
+
 	if err := fang.Execute(
-		context.Background(),
+		ctx,
 		rootCmd,
 		fang.WithVersion(rootCmd.Version),
-		fang.WithNotifySignal(os.Interrupt, os.Kill),
 	); err != nil {
 		return err
 	}
@@ -41,15 +68,388 @@ func Execute() error {
 func init() {
 	cobra.OnInitialize(initConfig)
 
-	rootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file (default is $HOME/.phukit.yaml)")
+	rootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file (default is $HOME/.phukit.yaml or $PHUKIT_CONFIG, over the system defaults in "+systemConfigFile+")")
 	rootCmd.PersistentFlags().BoolP("verbose", "v", false, "verbose output")
 	rootCmd.PersistentFlags().BoolP("dry-run", "n", false, "dry run mode (no actual changes)")
+	rootCmd.PersistentFlags().Bool("strict", false, "treat warnings as errors (fail and clean up instead of continuing)")
+	rootCmd.PersistentFlags().Bool("json-progress", false, "write progress events as JSON lines to stderr")
+	rootCmd.PersistentFlags().BoolP("quiet", "q", false, "only print warnings, errors and the final result")
+	rootCmd.PersistentFlags().Bool("no-color", false, "disable colored output (also honors NO_COLOR)")
+	rootCmd.PersistentFlags().String("audit-log", "", "append every executed external command as a JSON line to this file")
+	rootCmd.PersistentFlags().Bool("wait", false, "wait for a running install/update to finish instead of failing")
+	rootCmd.PersistentFlags().String("log-file", pkg.DefaultLogFile, "write a persistent log of install/update operations to this file (rotated at 10 MiB, empty to disable)")
+	rootCmd.PersistentFlags().String("log-level", "info", "minimum level written to the log file and journal (debug, info, warn, error)")
+	rootCmd.PersistentFlags().String("log-journal", pkg.JournalAuto, "also log to the systemd journal (auto = when run by a systemd unit, always, never)")
+	rootCmd.PersistentFlags().String("container-source", "builtin", "how images are pulled and extracted (builtin, auto, podman, docker, skopeo)")
+	rootCmd.PersistentFlags().String("progress-socket", "", "also stream JSON progress events to clients of this unix socket (e.g. "+pkg.DefaultProgressSocket+")")
+	rootCmd.PersistentFlags().String("result-file", "", "write a JSON summary of the install/update (image, digest, partitions, kernel, phase durations, status) to this file")
+	rootCmd.PersistentFlags().String("registry-auth-file", "", "registry credentials to pull images with, in containers-auth.json format (default ~/.docker/config.json or the podman auth file)")
+	rootCmd.PersistentFlags().String("registries-conf", "", "registry mirrors to pull through, in containers-registries.conf format (default "+pkg.DefaultRegistriesConf+" if present)")
+	rootCmd.PersistentFlags().Int("registry-retries", pkg.DefaultRegistryRetryPolicy.Attempts-1, "retry failed registry requests this many times, with exponential backoff (0 disables)")
+	rootCmd.PersistentFlags().Duration("registry-retry-backoff", pkg.DefaultRegistryRetryPolicy.InitialBackoff, "wait before the first retry of a registry request, doubled for each further retry")
+	rootCmd.PersistentFlags().Duration("registry-timeout", pkg.DefaultRegistryRetryPolicy.Timeout, "how long to wait for a registry to answer a request before retrying it (0 = no limit)")
+	rootCmd.PersistentFlags().Duration("timeout", 0, "cancel the whole operation if it runs longer than this (e.g. 30m, 0 = no limit)")
 
 	_ = viper.BindPFlag("verbose", rootCmd.PersistentFlags().Lookup("verbose"))
 	_ = viper.BindPFlag("dry-run", rootCmd.PersistentFlags().Lookup("dry-run"))
+	_ = viper.BindPFlag("strict", rootCmd.PersistentFlags().Lookup("strict"))
+	_ = viper.BindPFlag("json-progress", rootCmd.PersistentFlags().Lookup("json-progress"))
+	_ = viper.BindPFlag("quiet", rootCmd.PersistentFlags().Lookup("quiet"))
+	_ = viper.BindPFlag("no-color", rootCmd.PersistentFlags().Lookup("no-color"))
+	_ = viper.BindPFlag("audit-log", rootCmd.PersistentFlags().Lookup("audit-log"))
+	_ = viper.BindPFlag("timeout", rootCmd.PersistentFlags().Lookup("timeout"))
+	_ = viper.BindPFlag("progress-socket", rootCmd.PersistentFlags().Lookup("progress-socket"))
+	_ = viper.BindPFlag("result-file", rootCmd.PersistentFlags().Lookup("result-file"))
+	_ = viper.BindPFlag("wait", rootCmd.PersistentFlags().Lookup("wait"))
+	_ = viper.BindPFlag("container-source", rootCmd.PersistentFlags().Lookup("container-source"))
+	_ = viper.BindPFlag("registry-auth-file", rootCmd.PersistentFlags().Lookup("registry-auth-file"))
+	_ = viper.BindPFlag("registries-conf", rootCmd.PersistentFlags().Lookup("registries-conf"))
+	_ = viper.BindPFlag("registry-retries", rootCmd.PersistentFlags().Lookup("registry-retries"))
+	_ = viper.BindPFlag("registry-retry-backoff", rootCmd.PersistentFlags().Lookup("registry-retry-backoff"))
+	_ = viper.BindPFlag("registry-timeout", rootCmd.PersistentFlags().Lookup("registry-timeout"))
+	_ = viper.BindPFlag("log-file", rootCmd.PersistentFlags().Lookup("log-file"))
+	_ = viper.BindPFlag("log-level", rootCmd.PersistentFlags().Lookup("log-level"))
+	_ = viper.BindPFlag("log-journal", rootCmd.PersistentFlags().Lookup("log-journal"))
+}
+
+// progressSocket serves events for --progress-socket until the operation ends
+var progressSocket *pkg.SocketProgressReporter
+
+// applyGlobalFlags configures package-wide behavior from the global flags
+func applyGlobalFlags() error {
+	if viper.GetBool("quiet") && viper.GetBool("verbose") {
+		return fmt.Errorf("--quiet and --verbose cannot be used together")
+	}
+	pkg.SetStrictMode(viper.GetBool("strict"))
+	if err := pkg.SetContainerSource(viper.GetString("container-source")); err != nil {
+		return err
+	}
+	if err := pkg.SetRegistryAuthFile(viper.GetString("registry-auth-file")); err != nil {
+		return err
+	}
+	if err := pkg.SetRegistriesConf(viper.GetString("registries-conf")); err != nil {
+		return err
+	}
+	retry := pkg.DefaultRegistryRetryPolicy
+	retry.Attempts = viper.GetInt("registry-retries") + 1
+	retry.InitialBackoff = viper.GetDuration("registry-retry-backoff")
+	retry.Timeout = viper.GetDuration("registry-timeout")
+	if viper.IsSet("registry-retry-max-backoff") {
+		retry.MaxBackoff = viper.GetDuration("registry-retry-max-backoff")
+	}
+	if err := pkg.SetRegistryRetryPolicy(retry); err != nil {
+		return err
+	}
+	// Colors and in-place progress only on a terminal; piped output stays
+	// line-based
+	color := pkg.ColorAllowed(viper.GetBool("no-color"))
+	if !color {
+		// Also covers the error and help output styled by fang
+		_ = os.Setenv("NO_COLOR", "1")
+	}
+	interactive := pkg.IsTerminal(os.Stdout)
+	pkg.SetColor(color && interactive && pkg.IsTerminal(os.Stderr))
+	var reporter pkg.ProgressReporter
+	switch {
+	case viper.GetBool("json-progress"):
+		reporter = pkg.NewJSONProgressReporter(os.Stderr)
+	case interactive && !viper.GetBool("verbose") && !viper.GetBool("quiet"):
+		// Verbose mode shows the raw tool output instead
+		reporter = pkg.NewTerminalProgressReporter(os.Stdout, color)
+	}
+	if path := viper.GetString("progress-socket"); path != "" {
+		socket, err := pkg.ListenProgressSocket(path)
+		if err != nil {
+			return err
+		}
+		progressSocket = socket
+		reporter = pkg.NewMultiProgressReporter(reporter, socket)
+	}
+	pkg.SetProgressReporter(reporter)
+	if err := pkg.SetQuiet(viper.GetBool("quiet")); err != nil {
+		return err
+	}
+	var exec pkg.Executor = pkg.OSExecutor{}
+	if path := viper.GetString("audit-log"); path != "" {
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+		if err != nil {
+			return fmt.Errorf("failed to open audit log: %w", err)
+		}
+		exec = pkg.NewAuditExecutor(exec, f)
+	}
+
+	level, err := pkg.ParseLogLevel(viper.GetString("log-level"))
+	if err != nil {
+		return err
+	}
+	journal, err := pkg.ParseJournalMode(viper.GetString("log-journal"))
+	if err != nil {
+		return err
+	}
+
+	// The logs are a record for later, so being unable to write them must not
+	// stop an install
+	var handlers []slog.Handler
+	if path := viper.GetString("log-file"); path != "" {
+		f, err := pkg.OpenRotatingFile(path, pkg.DefaultLogMaxSize, pkg.DefaultLogBackups)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: logging disabled: %v\n", err)
+		} else {
+			handlers = append(handlers, pkg.NewFileHandler(f, level))
+			pkg.SetOperationLogDir(filepath.Dir(path))
+		}
+	}
+	if journal == pkg.JournalAlways || journal == pkg.JournalAuto && pkg.RunningUnderSystemd() {
+		if !pkg.JournalAvailable() {
+			if journal == pkg.JournalAlways {
+				fmt.Fprintf(os.Stderr, "Warning: journal logging disabled: %s not found\n", pkg.JournalSocket)
+			}
+		} else if h, err := pkg.NewJournalHandler(level); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: journal logging disabled: %v\n", err)
+		} else {
+			handlers = append(handlers, h)
+		}
+	}
+	if len(handlers) > 0 {
+		pkg.SetLogger(slog.New(pkg.NewMultiHandler(handlers...)).With("pid", os.Getpid()))
+		exec = pkg.LogExecutor{Next: exec}
+	}
+	pkg.SetExecutor(exec)
+	dryRunTrace = nil
+	if viper.GetBool("dry-run") {
+		dryRunTrace = &pkg.DryRunExecutor{}
+	}
+	return nil
+}
+
+// dryRunTrace records the commands installers and updaters would run in a
+// dry run (see operationExecutor)
+var dryRunTrace *pkg.DryRunExecutor
+
+// operationExecutor returns the executor installers and updaters run their
+// commands with: the dry-run trace with --dry-run, otherwise nil for the
+// executor of the global flags
+func operationExecutor() pkg.Executor {
+	if dryRunTrace == nil {
+		return nil
+	}
+	return dryRunTrace
+}
+
+// printDryRunTrace prints the commands a dry run would have run
+func printDryRunTrace() {
+	if dryRunTrace == nil || len(dryRunTrace.Commands) == 0 {
+		return
+	}
+	fmt.Println("\n[DRY RUN] Commands that would run:")
+	for _, c := range dryRunTrace.Commands {
+		fmt.Printf("  %s\n", c)
+	}
+}
+
+// Output formats of the commands with a --format flag
+const (
+	formatText = "text"
+	formatJSON = "json"
+)
+
+// outputFormatAnnotation marks a --format flag that selects the output format,
+// as opposed to e.g. the disk image format of build-image
+const outputFormatAnnotation = "phukit_output_format"
+
+// addFormatFlag adds the --format flag selecting the output format of cmd
+func addFormatFlag(cmd *cobra.Command, p *string, usage string) {
+	cmd.Flags().StringVar(p, "format", formatText, usage)
+	_ = cmd.Flags().SetAnnotation("format", outputFormatAnnotation, []string{"true"})
+}
+
+// outputFormat returns the output format cmd was asked for: the --format
+// flag, or the format of the configuration file if the flag is not given.
+// The configured format does not apply to --verbose and --plan runs, which
+// are meant to be read.
+func outputFormat(cmd *cobra.Command) string {
+	flag := cmd.Flags().Lookup("format")
+	if flag == nil || flag.Annotations[outputFormatAnnotation] == nil {
+		return formatText
+	}
+	if flag.Changed || viper.GetBool("verbose") {
+		return flag.Value.String()
+	}
+	if plan := cmd.Flags().Lookup("plan"); plan != nil && plan.Changed {
+		return flag.Value.String()
+	}
+	if format := viper.GetString("format"); format != "" {
+		return format
+	}
+	return flag.Value.String()
+}
+
+// jsonOutput reports whether cmd was asked for --format json. runOperation
+// then turns on quiet mode, which discards everything written to stdout, and
+// prints the result document to the real stdout; stderr is unchanged.
+func jsonOutput(cmd *cobra.Command) (bool, error) {
+	format := outputFormat(cmd)
+	switch format {
+	case formatText:
+		return false, nil
+	case formatJSON:
+		if viper.GetBool("verbose") {
+			return false, fmt.Errorf("--format json and --verbose cannot be used together")
+		}
+		return true, nil
+	}
+	return false, fmt.Errorf("unsupported format: %s (supported: %s, %s)", format, formatText, formatJSON)
+}
+
+// runOperation applies the global flags, runs fn under the --timeout deadline
+// and reports the outcome as a complete event and a result document
+func runOperation(cmd *cobra.Command, operation string, fn func(ctx context.Context) error) error {
+	jsonOut, err := jsonOutput(cmd)
+	if err != nil {
+		return err
+	}
+	if jsonOut {
+		viper.Set("quiet", true)
+	}
+	if err := applyGlobalFlags(); err != nil {
+		return err
+	}
+	if progressSocket != nil {
+		defer func() { _ = progressSocket.Close() }()
+	}
+
+	ctx := cmd.Context()
+	timeout := viper.GetDuration("timeout")
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	pkg.SetLogger(pkg.Logger().With("phase", operation))
+	oplog, lerr := pkg.StartOperationLog(operation)
+	if lerr != nil {
+		fmt.Fprintf(os.Stderr, "Warning: operation log disabled: %v\n", lerr)
+	}
+	pkg.Logger().Info(operation+" started", "args", os.Args[1:], "dry_run", viper.GetBool("dry-run"))
+	pkg.StartResult(operation)
+	pkg.SetResultInitiator(pkg.CLIInitiator())
+	err = fn(ctx)
+	status := pkg.CompletionStatus(ctx, err)
+	pkg.ReportComplete(operation, status, err)
+
+	result := pkg.FinishResult(status, err)
+	pkg.ReportResult(result)
+	if merr := pkg.RecordMetrics(result); merr != nil {
+		fmt.Fprintf(os.Stderr, "Warning: %v\n", merr)
+	}
+	if werr := pkg.NotifyWebhooks(result); werr != nil {
+		fmt.Fprintf(os.Stderr, "Warning: %v\n", werr)
+	}
+	if herr := pkg.RecordHistory(result); herr != nil {
+		fmt.Fprintf(os.Stderr, "Warning: %v\n", herr)
+	}
+	if lerr := oplog.Close(result); lerr != nil {
+		fmt.Fprintf(os.Stderr, "Warning: %v\n", lerr)
+	}
+	if path := viper.GetString("result-file"); path != "" {
+		if werr := result.WriteFile(path); werr != nil {
+			if err == nil {
+				return werr
+			}
+			fmt.Fprintf(os.Stderr, "Warning: %v\n", werr)
+		}
+	}
+	if jsonOut {
+		data, merr := json.MarshalIndent(result, "", "  ")
+		if merr != nil {
+			return fmt.Errorf("failed to marshal result: %w", merr)
+		}
+		_, _ = fmt.Fprintln(pkg.Stdout(), string(data))
+	} else if err == nil && pkg.Quiet() {
+		_, _ = fmt.Fprintf(pkg.Stdout(), "%s: %s\n", operation, status)
+	} else {
+		printDryRunTrace()
+	}
+
+	switch status {
+	case pkg.StatusTimeout:
+		return &ExitError{Code: ExitTimeout, Err: fmt.Errorf("%s timed out after %s: %w", operation, timeout, err)}
+	case pkg.StatusCancelled:
+		var sigErr *SignalError
+		if errors.As(context.Cause(ctx), &sigErr) {
+			return &ExitError{Code: sigErr.exitCode(), Err: fmt.Errorf("%s stopped after cleanup: %w", operation, err)}
+		}
+	}
+	return err
+}
+
+// lockOperation takes the global operation lock for commands that modify
+// partitions or bootloader files. Dry runs change nothing and skip the lock.
+func lockOperation(ctx context.Context) (*pkg.OperationLock, error) {
+	if viper.GetBool("dry-run") {
+		return nil, nil
+	}
+	return pkg.AcquireOperationLock(ctx, viper.GetBool("wait"))
+}
+
+// envPrefix starts the names of the environment variables that set flags
+// and configuration keys
+const envPrefix = "PHUKIT"
+
+// envName returns the environment variable that sets the flag or
+// configuration key name
+func envName(name string) string {
+	return envPrefix + "_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
+
+// applyEnvironment sets the flags of cmd that are not on the command line
+// from their environment variables, for initrds and container entrypoints
+// where passing flags is awkward. List flags take a space-separated list.
+func applyEnvironment(cmd *cobra.Command) error {
+	var err error
+	cmd.Flags().VisitAll(func(flag *pflag.Flag) {
+		if err != nil || flag.Changed || flag.Name == "help" || flag.Name == "config" {
+			return
+		}
+		value, ok := os.LookupEnv(envName(flag.Name))
+		if !ok {
+			return
+		}
+		values := []string{value}
+		if strings.HasSuffix(flag.Value.Type(), "Array") || strings.HasSuffix(flag.Value.Type(), "Slice") {
+			values = strings.Fields(value)
+		}
+		for _, v := range values {
+			if serr := cmd.Flags().Set(flag.Name, v); serr != nil {
+				err = fmt.Errorf("invalid %s: %w", envName(flag.Name), serr)
+				return
+			}
+		}
+	})
+	return err
 }
 
 func initConfig() {
+	if cfgFile == "" {
+		cfgFile = os.Getenv(envName("config"))
+	}
+
+	// The system-wide file only supplies defaults, so everything else still
+	// overrides it
+	system := viper.New()
+	system.SetConfigFile(systemConfigFile)
+	if err := system.ReadInConfig(); err == nil {
+		for key, value := range system.AllSettings() {
+			viper.SetDefault(key, value)
+		}
+		if viper.GetBool("verbose") {
+			fmt.Fprintln(os.Stderr, "Using system config file:", systemConfigFile)
+		}
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		fmt.Fprintf(os.Stderr, "Warning: ignoring %s: %v\n", systemConfigFile, err)
+	}
+
 	if cfgFile != "" {
 		viper.SetConfigFile(cfgFile)
 	} else {
@@ -64,6 +464,10 @@ func initConfig() {
 		viper.SetConfigName(".phukit")
 	}
 
+	// Configuration keys without a flag, such as default-image, are set
+	// with their PHUKIT_ variables here
+	viper.SetEnvPrefix(envPrefix)
+	viper.SetEnvKeyReplacer(strings.NewReplacer("-", "_"))
 	viper.AutomaticEnv()
 
 	if err := viper.ReadInConfig(); err == nil {
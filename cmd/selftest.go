@@ -0,0 +1,85 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/bketelsen/phukit/pkg"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var (
+	selftestLoop        bool
+	selftestImage       string
+	selftestNoBoot      bool
+	selftestBootTimeout time.Duration
+	selftestWorkDir     string
+)
+
+var selftestCmd = &cobra.Command{
+	Use:   "selftest",
+	Short: "Run an end-to-end install test on a loop device",
+	Long: `Validate phukit and the tools it depends on with a real install.
+
+This command will:
+  1. Create a sparse disk image and attach it to a loop device
+  2. Install a known-good bootc image to it, exactly like 'phukit install'
+  3. Boot the result under QEMU with UEFI firmware, if qemu-system-x86_64 and
+     OVMF are installed, and wait for a login prompt on the serial console
+  4. Print a pass/fail summary and exit non-zero on failure
+
+Nothing outside the loop device is modified. Requires root.
+
+Example:
+  phukit selftest --loop
+  phukit selftest --loop --image quay.io/fedora/fedora-bootc:42
+  phukit selftest --loop --no-boot`,
+	RunE: runSelftest,
+}
+
+func init() {
+	rootCmd.AddCommand(selftestCmd)
+
+	selftestCmd.Flags().BoolVar(&selftestLoop, "loop", false, "Install to a temporary loop device (required)")
+	selftestCmd.Flags().StringVarP(&selftestImage, "image", "i", pkg.DefaultSelfTestImage, "Container image to install")
+	selftestCmd.Flags().BoolVar(&selftestNoBoot, "no-boot", false, "Skip booting the installed disk under QEMU")
+	selftestCmd.Flags().DurationVar(&selftestBootTimeout, "boot-timeout", pkg.DefaultSelfTestBootTimeout, "How long to wait for the installed system to boot")
+	selftestCmd.Flags().StringVar(&selftestWorkDir, "work-dir", "/var/tmp", "Directory for the sparse loop device image")
+}
+
+func runSelftest(cmd *cobra.Command, args []string) error {
+	if !selftestLoop {
+		return fmt.Errorf("--loop is required: the self-test only runs against a loop device")
+	}
+
+	return runOperation(cmd, "selftest", func(ctx context.Context) error {
+		if viper.GetBool("dry-run") {
+			fmt.Printf("[DRY RUN] Would install %s to a loop device and boot it under QEMU\n", selftestImage)
+			return nil
+		}
+
+		test := pkg.NewSelfTest(selftestImage)
+		test.Verbose = viper.GetBool("verbose")
+		test.Boot = !selftestNoBoot
+		test.BootTimeout = selftestBootTimeout
+		test.WorkDir = selftestWorkDir
+
+		lock, err := lockOperation(ctx)
+		if err != nil {
+			return err
+		}
+		defer func() { _ = lock.Unlock() }()
+
+		result, err := test.Run(ctx)
+		if err != nil {
+			return err
+		}
+		result.Print(pkg.Stdout())
+		if !result.Passed() {
+			return fmt.Errorf("selftest failed")
+		}
+		return nil
+	})
+}
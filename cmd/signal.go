@@ -0,0 +1,61 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// SignalError is the cancellation cause when phukit receives SIGINT or SIGTERM
+type SignalError struct {
+	Signal os.Signal
+}
+
+func (e *SignalError) Error() string {
+	return fmt.Sprintf("interrupted by %s", e.Signal)
+}
+
+// Is makes a SignalError match context.Canceled
+func (e *SignalError) Is(target error) bool {
+	return target == context.Canceled
+}
+
+// exitCode follows the shell convention of 128 + signal number
+func (e *SignalError) exitCode() int {
+	if sig, ok := e.Signal.(syscall.Signal); ok {
+		return 128 + int(sig)
+	}
+	return 1
+}
+
+// signalContext returns a context that is cancelled on the first SIGINT or
+// SIGTERM, giving in-flight work the chance to stop and clean up. A second
+// signal exits immediately.
+func signalContext(parent context.Context) (context.Context, func()) {
+	ctx, cancel := context.WithCancelCause(parent)
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+
+	go func() {
+		sig, ok := <-signals
+		if !ok {
+			return
+		}
+		fmt.Fprintf(os.Stderr, "\nReceived %s, stopping and cleaning up (send again to exit immediately)...\n", sig)
+		cancel(&SignalError{Signal: sig})
+
+		sig, ok = <-signals
+		if !ok {
+			return
+		}
+		os.Exit((&SignalError{Signal: sig}).exitCode())
+	}()
+
+	return ctx, func() {
+		signal.Stop(signals)
+		close(signals)
+		cancel(nil)
+	}
+}
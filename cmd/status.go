@@ -13,7 +13,8 @@ var statusCmd = &cobra.Command{
 	Use:   "status",
 	Short: "Show current system status",
 	Long: `Display the current phukit system status including:
-  - Installed container image reference
+  - Installed container image reference and the update channel it follows
+  - Image version and revision, from the image labels or os-release
   - Image digest (SHA256)
   - Currently active root partition
   - Boot device
@@ -31,35 +32,14 @@ func init() {
 func runStatus(cmd *cobra.Command, args []string) error {
 	verbose := viper.GetBool("verbose")
 
-	// Read system configuration
-	config, err := pkg.ReadSystemConfig()
+	// Read system configuration and the active root slot
+	status, err := pkg.GetSystemStatus()
 	if err != nil {
 		return fmt.Errorf("failed to read system config: %w\n\nIs this system installed with phukit?", err)
 	}
-
-	// Get active root partition
-	activeRoot, err := pkg.GetActiveRootPartition()
-	if err != nil && verbose {
-		fmt.Printf("Warning: could not determine active root partition: %v\n", err)
-	}
-
-	// Determine which root slot is active (root1 or root2)
-	var activeSlot string
-	if activeRoot != "" {
-		// Try to detect the partition scheme to determine slot
-		device := config.Device
-		if device != "" {
-			scheme, schemeErr := pkg.DetectExistingPartitionScheme(device)
-			if schemeErr == nil {
-				if strings.HasSuffix(activeRoot, strings.TrimPrefix(scheme.Root1Partition, "/dev/")) ||
-					activeRoot == scheme.Root1Partition {
-					activeSlot = "A (root1)"
-				} else if strings.HasSuffix(activeRoot, strings.TrimPrefix(scheme.Root2Partition, "/dev/")) ||
-					activeRoot == scheme.Root2Partition {
-					activeSlot = "B (root2)"
-				}
-			}
-		}
+	config, activeRoot := status.Config, status.ActiveRoot
+	if activeRoot == "" && verbose {
+		fmt.Println("Warning: could not determine active root partition")
 	}
 
 	// Print status
@@ -68,6 +48,21 @@ func runStatus(cmd *cobra.Command, args []string) error {
 	fmt.Println()
 
 	fmt.Printf("Image:       %s\n", config.ImageRef)
+	if config.Channel != "" {
+		fmt.Printf("Channel:     %s\n", config.Channel)
+	}
+	if booted := status.Booted; booted != nil {
+		if version := booted.DisplayVersion(); version != "" {
+			fmt.Printf("Version:     %s\n", version)
+		}
+		if booted.Revision != "" {
+			if verbose {
+				fmt.Printf("Revision:    %s\n", booted.Revision)
+			} else {
+				fmt.Printf("Revision:    %s\n", booted.ShortRevision())
+			}
+		}
+	}
 	if config.ImageDigest != "" {
 		// Show shortened digest for cleaner output, full in verbose
 		if verbose {
@@ -82,13 +77,16 @@ func runStatus(cmd *cobra.Command, args []string) error {
 	} else {
 		fmt.Printf("Digest:      (not recorded)\n")
 	}
+	if config.PinnedDigest != "" {
+		fmt.Printf("Pinned:      %s (policy: %s)\n", config.PinnedDigest, config.PinPolicy)
+	}
 
 	fmt.Println()
 	fmt.Printf("Device:      %s\n", config.Device)
 	if activeRoot != "" {
 		fmt.Printf("Active Root: %s", activeRoot)
-		if activeSlot != "" {
-			fmt.Printf(" [Slot %s]", activeSlot)
+		if status.ActiveSlot != "" {
+			fmt.Printf(" [Slot %s]", pkg.SlotLabel(status.ActiveSlot))
 		}
 		fmt.Println()
 	}
@@ -98,12 +96,30 @@ func runStatus(cmd *cobra.Command, args []string) error {
 	} else {
 		fmt.Printf("Filesystem:  ext4 (default)\n")
 	}
+	switch {
+	case status.UsrOverlay:
+		fmt.Println("/usr:        writable overlay (changes are lost on reboot)")
+	case config.Composefs:
+		fmt.Println("/usr:        composefs, read-only")
+	case config.WritableUsr:
+		fmt.Println("/usr:        writable")
+	default:
+		fmt.Println("/usr:        read-only")
+	}
+
+	if pin := status.SlotPin; pin != nil {
+		fmt.Printf("Pinned Slot: %s [Slot %s]", pin.Partition, pkg.SlotLabel(pin.Slot))
+		if pin.ImageRef != "" {
+			fmt.Printf(" holding %s", pin.ImageRef)
+		}
+		fmt.Println()
+	}
 
 	if verbose {
 		fmt.Println()
 		fmt.Printf("Installed:   %s\n", config.InstallDate)
-		if len(config.KernelArgs) > 0 {
-			fmt.Printf("Kernel Args: %s\n", strings.Join(config.KernelArgs, " "))
+		if kargs := pkg.UserKernelArgs(config.KernelArgs); len(kargs) > 0 {
+			fmt.Printf("Kernel Args: %s\n", strings.Join(kargs, " "))
 		}
 	}
 
@@ -111,12 +127,21 @@ func runStatus(cmd *cobra.Command, args []string) error {
 	if verbose && config.ImageRef != "" {
 		fmt.Println()
 		fmt.Println("Checking for updates...")
-		remoteDigest, err := pkg.GetRemoteImageDigest(config.ImageRef)
+		imageRef, err := config.ChannelImageRef()
+		var remoteDigest string
+		if err == nil {
+			remoteDigest, err = pkg.GetRemoteImageDigest(cmd.Context(), imageRef)
+		}
 		if err != nil {
 			fmt.Printf("  Could not check for updates: %v\n", err)
 		} else if config.ImageDigest == "" {
 			fmt.Printf("  Remote digest: %s\n", remoteDigest)
 			fmt.Println("  Update status: unknown (no local digest recorded)")
+		} else if config.PinnedDigest != "" && config.PinnedDigest != remoteDigest {
+			fmt.Println("  ⚠ Tag has moved away from the pinned digest")
+			fmt.Printf("    Pinned:    %s\n", config.PinnedDigest)
+			fmt.Printf("    Available: %s\n", remoteDigest)
+			fmt.Println("    Run 'phukit update --repin' to advance the pin.")
 		} else if config.ImageDigest == remoteDigest {
 			fmt.Println("  ✓ System is up-to-date")
 		} else {
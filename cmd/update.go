@@ -1,7 +1,12 @@
 package cmd
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io/fs"
+	"os"
+	"time"
 
 	"github.com/bketelsen/phukit/pkg"
 	"github.com/spf13/cobra"
@@ -9,11 +14,18 @@ import (
 )
 
 var (
-	updateImage      string
-	updateDevice     string
-	updateSkipPull   bool
-	updateCheckOnly  bool
-	updateKernelArgs []string
+	updateImage          string
+	updateDevice         string
+	updateSkipPull       bool
+	updateCheckOnly      bool
+	updateKernelArgs     []string
+	updateVarLockTimeout time.Duration
+	updateNoVarSnapshot  bool
+	updateRepin          bool
+	updateApply          string
+	updateForce          bool
+	updateFormat         string
+	updateScheduled      bool
 )
 
 var updateCmd = &cobra.Command{
@@ -33,7 +45,10 @@ This command performs an A/B system update:
 Use --check to only check if an update is available without installing.
 
 After update, reboot to activate the new system. The previous system remains
-available in the boot menu for rollback if needed.
+available in the boot menu for rollback if needed. With --apply soft-reboot
+the new root is activated right away with systemd's soft-reboot, restarting
+userspace without a firmware reboot. The running kernel is kept, so the new
+image must ship modules for it.
 
 Example:
   phukit update
@@ -41,7 +56,20 @@ Example:
   phukit update --image quay.io/example/myimage:v2.0
   phukit update --skip-pull
   phukit update --device /dev/sda    # Override auto-detection
-  phukit update --force              # Reinstall even if up-to-date`,
+  phukit update --force              # Reinstall even if up-to-date
+  phukit update --repin              # Move a pinned image to the tag's current digest
+  phukit update --apply soft-reboot  # Switch to the new root without a full reboot
+  phukit update --scheduled --maintenance-window "Sat 02:00-05:00" --reboot-policy within-window
+
+If the image was pinned at install time (phukit install --pin), updates stay on
+the pinned digest. When the tag has moved, update warns (or fails with pin
+policy "fail") until --repin advances the pin.
+
+With --scheduled, as run by a systemd timer, the update only runs inside the
+--maintenance-window spans (or the maintenance-windows of the configuration
+file) and otherwise exits successfully without updating. Afterwards the
+system reboots into a staged update as --reboot-policy allows: never (the
+default), immediate, or within-window.`,
 	RunE: runUpdate,
 }
 
@@ -53,88 +81,242 @@ func init() {
 	updateCmd.Flags().BoolVar(&updateSkipPull, "skip-pull", false, "Skip pulling the image (use already pulled image)")
 	updateCmd.Flags().BoolVarP(&updateCheckOnly, "check", "c", false, "Only check if an update is available (don't install)")
 	updateCmd.Flags().StringArrayVarP(&updateKernelArgs, "karg", "k", []string{}, "Kernel argument to pass (can be specified multiple times)")
+	updateCmd.Flags().BoolVar(&updateRepin, "repin", false, "Advance the image pin to the digest the tag points at now (pins the image if not pinned)")
+	updateCmd.Flags().StringVar(&updateApply, "apply", "", "Activate the update right away instead of on the next boot (soft-reboot)")
+	updateCmd.Flags().BoolVarP(&updateForce, "force", "f", false, "Reinstall even if the system is up-to-date, without asking for confirmation")
+	addFormatFlag(updateCmd, &updateFormat, "Output format: text, or json for only the result document on stdout (no confirmation prompt)")
+	updateCmd.Flags().BoolVar(&updateScheduled, "scheduled", false, "Only update inside the maintenance windows, then reboot as the reboot policy allows")
+	addScheduleFlags(updateCmd)
+	updateCmd.Flags().BoolVar(&updateNoVarSnapshot, "no-var-snapshot", false, "Don't snapshot /var (on LVM or btrfs) for a rollback to restore")
+	updateCmd.Flags().DurationVar(&updateVarLockTimeout, "var-lock-timeout", pkg.DefaultVarLockTimeout, "How long to wait for other operations writing the shared /var (0 = fail if busy)")
 }
 
 func runUpdate(cmd *cobra.Command, args []string) error {
+	kernelArgs := updateKernelArgs
+	if !cmd.Flags().Changed("karg") {
+		kernelArgs = viper.GetStringSlice("kernel-args")
+	}
+	var schedule *pkg.UpdateSchedule
+	if updateScheduled {
+		s, err := scheduleFromFlags(cmd)
+		if err != nil {
+			return err
+		}
+		schedule = &s
+	}
+	return runOperation(cmd, "update", func(ctx context.Context) error {
+		_, err := updateSystem(ctx, updateOptions{
+			image:          updateImage,
+			device:         updateDevice,
+			skipPull:       updateSkipPull,
+			checkOnly:      updateCheckOnly,
+			kernelArgs:     kernelArgs,
+			varLockTimeout: updateVarLockTimeout,
+			noVarSnapshot:  updateNoVarSnapshot,
+			repin:          updateRepin,
+			apply:          updateApply,
+			force:          updateForce,
+			assumeYes:      outputFormat(cmd) == formatJSON || updateScheduled,
+			schedule:       schedule,
+		})
+		return err
+	})
+}
+
+// applySoftReboot activates a staged update with systemd soft-reboot
+const applySoftReboot = "soft-reboot"
+
+// updateOptions are the settings of one update run
+type updateOptions struct {
+	image          string
+	device         string
+	skipPull       bool
+	checkOnly      bool
+	kernelArgs     []string
+	varLockTimeout time.Duration
+	noVarSnapshot  bool // Don't snapshot /var before switching roots
+	repin          bool
+	apply          string              // How to activate the staged update: "" (next boot) or soft-reboot
+	force          bool                // Reinstall even if up-to-date
+	assumeYes      bool                // Don't ask for confirmation
+	schedule       *pkg.UpdateSchedule // Update only inside its windows and reboot as it allows (--scheduled)
+}
+
+// updateSystem runs an update with opts, or only checks for one. It reports
+// whether an update was staged for the next boot.
+func updateSystem(ctx context.Context, opts updateOptions) (bool, error) {
 	verbose := viper.GetBool("verbose")
 	dryRun := viper.GetBool("dry-run")
-	force := viper.GetBool("force")
+	force := opts.force
 
-	var device string
-	var err error
+	if opts.apply != "" && opts.apply != applySoftReboot {
+		return false, fmt.Errorf("unsupported apply mode: %s (supported: %s)", opts.apply, applySoftReboot)
+	}
 
-	// Resolve device path - auto-detect if not specified
-	if updateDevice != "" {
-		device, err = pkg.GetDiskByPath(updateDevice)
-		if err != nil {
-			return fmt.Errorf("invalid device: %w", err)
-		}
-		if verbose {
-			fmt.Printf("Using specified device: %s\n", device)
-		}
-	} else {
-		// Auto-detect boot device
-		device, err = pkg.GetCurrentBootDeviceInfo(verbose)
-		if err != nil {
-			return fmt.Errorf("failed to auto-detect boot device: %w (use --device to specify manually)", err)
-		}
-		if !verbose {
-			fmt.Printf("Auto-detected boot device: %s\n", device)
-		}
+	device, err := resolveBootDevice(opts.device, verbose)
+	if err != nil {
+		return false, err
 	}
 
 	// If image not specified, try to load from system config
-	imageRef := updateImage
+	imageRef := opts.image
 	if imageRef == "" {
 		config, err := pkg.ReadSystemConfig()
 		if err != nil {
-			return fmt.Errorf("no image specified and failed to read system config: %w", err)
+			return false, fmt.Errorf("no image specified and failed to read system config: %w", err)
+		}
+		if imageRef, err = config.ChannelImageRef(); err != nil {
+			return false, err
+		}
+		if config.Channel != "" {
+			fmt.Printf("Using image of channel %s: %s\n", config.Channel, imageRef)
+		} else {
+			fmt.Printf("Using image from system config: %s\n", imageRef)
 		}
-		imageRef = config.ImageRef
-		fmt.Printf("Using image from system config: %s\n", imageRef)
 	}
 
 	// Create updater
 	updater := pkg.NewSystemUpdater(device, imageRef)
 	updater.SetVerbose(verbose)
 	updater.SetDryRun(dryRun)
+	updater.SetExecutor(operationExecutor())
 	updater.SetForce(force)
+	updater.SetAssumeYes(opts.assumeYes)
+	updater.SetVarLockTimeout(opts.varLockTimeout)
+	updater.SetVarSnapshot(!opts.noVarSnapshot)
+	updater.SetRepin(opts.repin)
 
 	// If --check flag, only check if update is needed
-	if updateCheckOnly {
-		needed, digest, err := updater.IsUpdateNeeded()
+	if opts.checkOnly {
+		needed, digest, err := updater.IsUpdateNeeded(ctx)
 		if err != nil {
-			return fmt.Errorf("failed to check for updates: %w", err)
+			return false, fmt.Errorf("failed to check for updates: %w", err)
+		}
+		// Users without write access to /run can still check
+		if !dryRun {
+			if err := pkg.RefreshUpdateNotice(imageRef, digest, needed); err != nil && !errors.Is(err, fs.ErrPermission) {
+				fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+			}
 		}
 		if needed {
 			fmt.Println()
 			fmt.Printf("Update available: %s\n", digest)
 			fmt.Println("Run 'phukit update' to install the update.")
 			// Exit with code 0 (update available)
-			return nil
+			return false, nil
 		}
 		// System is up-to-date
-		return nil
+		return false, nil
 	}
 
 	// Add kernel arguments
-	for _, arg := range updateKernelArgs {
+	for _, arg := range opts.kernelArgs {
 		updater.AddKernelArg(arg)
 	}
+	// Configured boot profiles replace those recorded at install
+	if len(viper.GetStringMapString("boot-profiles")) > 0 {
+		profiles, err := parseBootProfiles(nil)
+		if err != nil {
+			return false, err
+		}
+		updater.SetBootProfiles(profiles)
+	}
+
+	if opts.schedule != nil && !opts.schedule.AllowsUpdate(time.Now()) {
+		fmt.Printf("Outside the maintenance windows; update deferred until %s\n", opts.schedule.DeferredUntil(time.Now()))
+		// An update staged earlier may still be due for its reboot
+		_, err := pkg.RebootIfScheduled(ctx, *opts.schedule, dryRun)
+		return false, err
+	}
+
+	lock, err := lockOperation(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer func() { _ = lock.Unlock() }()
 
 	// Run update
-	if err := updater.PerformUpdate(updateSkipPull); err != nil {
-		return err
+	if err := updater.PerformUpdate(ctx, opts.skipPull); err != nil {
+		return false, err
 	}
 
 	if !dryRun {
 		fmt.Println()
 		fmt.Println("=================================================================")
 		fmt.Println("System update complete!")
-		fmt.Println("Reboot your system to activate the new version.")
+		if opts.apply == applySoftReboot && updater.Staged {
+			fmt.Println("Switching to the new version with a soft reboot.")
+		} else {
+			fmt.Println("Reboot your system to activate the new version.")
+		}
 		fmt.Println("The previous version is available in the boot menu for rollback.")
 		fmt.Println("=================================================================")
 	}
 
-	return nil
+	if opts.apply == applySoftReboot && updater.Staged {
+		if err := updater.SoftReboot(ctx); err != nil {
+			return true, fmt.Errorf("update is staged for the next boot, but soft reboot failed: %w", err)
+		}
+	}
+
+	if opts.schedule != nil {
+		if _, err := pkg.RebootIfScheduled(ctx, *opts.schedule, dryRun); err != nil {
+			return updater.Staged, err
+		}
+	}
+
+	return updater.Staged, nil
+}
+
+// addScheduleFlags adds the maintenance window and reboot policy flags of
+// scheduled updates to cmd
+func addScheduleFlags(cmd *cobra.Command) {
+	cmd.Flags().StringArray("maintenance-window", nil, "Allowed update window, e.g. \"Sat 02:00-05:00\" or \"Mon-Fri 01:00-03:00 UTC\" (can be specified multiple times)")
+	cmd.Flags().String("reboot-policy", pkg.RebootNever, "When to reboot into a staged update: never, immediate or within-window")
+}
+
+// scheduleFromFlags returns the update schedule of cmd's flags, or of the
+// maintenance-windows and reboot-policy configuration keys
+func scheduleFromFlags(cmd *cobra.Command) (pkg.UpdateSchedule, error) {
+	specs, _ := cmd.Flags().GetStringArray("maintenance-window")
+	if !cmd.Flags().Changed("maintenance-window") {
+		specs = viper.GetStringSlice("maintenance-windows")
+	}
+	policy, _ := cmd.Flags().GetString("reboot-policy")
+	if !cmd.Flags().Changed("reboot-policy") && viper.IsSet("reboot-policy") {
+		policy = viper.GetString("reboot-policy")
+	}
+
+	windows, err := pkg.ParseMaintenanceWindows(specs)
+	if err != nil {
+		return pkg.UpdateSchedule{}, err
+	}
+	if policy, err = pkg.ParseRebootPolicy(policy); err != nil {
+		return pkg.UpdateSchedule{}, err
+	}
+	return pkg.UpdateSchedule{Windows: windows, RebootPolicy: policy}, nil
+}
+
+// resolveBootDevice returns the disk given with --device, or the disk the
+// running system booted from
+func resolveBootDevice(flag string, verbose bool) (string, error) {
+	if flag != "" {
+		device, err := pkg.GetDiskByPath(flag)
+		if err != nil {
+			return "", fmt.Errorf("invalid device: %w", err)
+		}
+		if verbose {
+			fmt.Printf("Using specified device: %s\n", device)
+		}
+		return device, nil
+	}
+
+	device, err := pkg.GetCurrentBootDeviceInfo(verbose)
+	if err != nil {
+		return "", fmt.Errorf("failed to auto-detect boot device: %w (use --device to specify manually)", err)
+	}
+	if !verbose {
+		fmt.Printf("Auto-detected boot device: %s\n", device)
+	}
+	return device, nil
 }
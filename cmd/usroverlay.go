@@ -0,0 +1,40 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/bketelsen/phukit/pkg"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var usrOverlayCmd = &cobra.Command{
+	Use:   "usroverlay",
+	Short: "Make /usr writable until the next reboot",
+	Long: `Mount a writable overlay over the read-only /usr, like 'ostree admin unlock'.
+Changes are kept in memory (under /run) and are gone after a reboot, so the
+installed root keeps matching its image. Use it to try a fix or add a
+debugging tool; make permanent changes in the image instead.
+
+Example:
+  phukit usroverlay`,
+	Args: cobra.NoArgs,
+	RunE: runUsrOverlay,
+}
+
+func init() {
+	rootCmd.AddCommand(usrOverlayCmd)
+}
+
+func runUsrOverlay(cmd *cobra.Command, args []string) error {
+	dryRun := viper.GetBool("dry-run")
+	if err := pkg.MountUsrOverlay(dryRun); err != nil {
+		return err
+	}
+	if dryRun {
+		return nil
+	}
+	fmt.Println("A writable overlay is now mounted on /usr.")
+	fmt.Println("Changes to /usr are lost on the next reboot.")
+	return nil
+}
@@ -0,0 +1,92 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/bketelsen/phukit/pkg"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var (
+	verifyInactive bool
+	verifyDevice   string
+	verifyJSON     bool
+)
+
+var verifyCmd = &cobra.Command{
+	Use:   "verify",
+	Short: "Check the installed root against the image it was installed from",
+	Long: `Compare every file in /usr of the booted root with the image recorded in
+its deployment, reporting files that were modified, are missing or were added.
+With --inactive the other root, holding the previous or a staged deployment,
+is mounted read-only and checked instead.
+
+The image is fetched by its recorded digest, so its layer digests vouch for
+what the files should be. This is a lightweight integrity audit for systems
+without dm-verity; it reads all of /usr and downloads the image. /etc and
+/var are left out, as they are expected to change.
+
+The command exits with code 40 if any file does not match.
+
+Example:
+  phukit verify
+  phukit verify --inactive --json`,
+	Args: cobra.NoArgs,
+	RunE: runVerify,
+}
+
+func init() {
+	rootCmd.AddCommand(verifyCmd)
+	verifyCmd.Flags().BoolVar(&verifyInactive, "inactive", false, "Verify the inactive root instead of the booted one")
+	verifyCmd.Flags().StringVarP(&verifyDevice, "device", "d", "", "Boot disk device for --inactive (auto-detected if not specified)")
+	verifyCmd.Flags().BoolVar(&verifyJSON, "json", false, "Output in JSON format")
+}
+
+func runVerify(cmd *cobra.Command, args []string) error {
+	device := ""
+	if verifyInactive {
+		var err error
+		if device, err = resolveBootDevice(verifyDevice, viper.GetBool("verbose")); err != nil {
+			return err
+		}
+		// An update in progress is writing the inactive root
+		lock, err := lockOperation(cmd.Context())
+		if err != nil {
+			return err
+		}
+		defer func() { _ = lock.Unlock() }()
+	}
+
+	v, err := pkg.VerifyRoot(cmd.Context(), device, verifyInactive)
+	if err != nil {
+		return err
+	}
+
+	if verifyJSON {
+		data, err := json.MarshalIndent(v, "", "  ")
+		if err != nil {
+			return fmt.Errorf("failed to marshal verification: %w", err)
+		}
+		fmt.Println(string(data))
+		return v.Err()
+	}
+
+	root := v.Root
+	if v.Slot != "" {
+		root = fmt.Sprintf("%s [Slot %s]", v.Root, pkg.SlotLabel(v.Slot))
+	}
+	fmt.Printf("Root:   %s\n", root)
+	fmt.Printf("Image:  %s\n", v.ImageRef)
+	fmt.Printf("Digest: %s\n\n", v.ImageDigest)
+	for _, issue := range v.Issues {
+		fmt.Printf("  %-8s  %s\n", issue.Problem, issue.Path)
+	}
+	if len(v.Issues) == 0 {
+		fmt.Printf("✓ All %d files in /usr match the image\n", v.Checked)
+	} else {
+		fmt.Printf("\n✗ %d files in /usr do not match the image (%d checked)\n", len(v.Issues), v.Checked)
+	}
+	return v.Err()
+}
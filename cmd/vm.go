@@ -0,0 +1,96 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/bketelsen/phukit/pkg"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var (
+	vmBootFormat  string
+	vmBootTimeout time.Duration
+	vmBootMemory  int
+	vmBootMarkers []string
+	vmBootJSON    bool
+)
+
+var vmCmd = &cobra.Command{
+	Use:   "vm",
+	Short: "Run installed disks in a virtual machine",
+}
+
+var vmBootCmd = &cobra.Command{
+	Use:   "boot <disk>",
+	Short: "Boot a disk image under QEMU and check that it reaches a login prompt",
+	Long: `Boot a disk image or block device headless under QEMU with UEFI firmware
+and watch the serial console until a login prompt or the multi-user target
+appears, or the timeout expires. Use it in CI to check images made with
+'phukit build-image' or installed to a loop device.
+
+The disk is opened with -snapshot and is never modified. The image must log
+to the serial console, for example with --karg console=ttyS0 at install time.
+Needs qemu-system-x86_64 and OVMF; KVM is used if /dev/kvm exists.
+
+The command exits with code 41 if the disk does not boot.
+
+Example:
+  phukit vm boot disk.qcow2
+  phukit vm boot /dev/loop0 --timeout 10m --json
+  phukit vm boot disk.raw --marker "Reached target graphical.target"`,
+	Args: cobra.ExactArgs(1),
+	RunE: runVMBoot,
+}
+
+func init() {
+	rootCmd.AddCommand(vmCmd)
+	vmCmd.AddCommand(vmBootCmd)
+
+	vmBootCmd.Flags().StringVar(&vmBootFormat, "format", "", "QEMU disk format (default from the extension: qcow2, vmdk, vhd, else raw)")
+	vmBootCmd.Flags().DurationVar(&vmBootTimeout, "timeout", pkg.DefaultVMBootTimeout, "How long the system may take to boot")
+	vmBootCmd.Flags().IntVar(&vmBootMemory, "memory", pkg.DefaultVMMemory, "VM memory in MiB")
+	vmBootCmd.Flags().StringArrayVar(&vmBootMarkers, "marker", []string{}, "Console output that shows the system booted (can be specified multiple times; default a login prompt or multi-user target)")
+	vmBootCmd.Flags().BoolVar(&vmBootJSON, "json", false, "Output in JSON format")
+}
+
+func runVMBoot(cmd *cobra.Command, args []string) error {
+	vm := pkg.NewVMBoot(args[0])
+	vm.Format = vmBootFormat
+	vm.Timeout = vmBootTimeout
+	vm.Memory = vmBootMemory
+	vm.Markers = vmBootMarkers
+	vm.Verbose = viper.GetBool("verbose") && !vmBootJSON
+	if vmBootJSON {
+		// Keep progress output out of the JSON document
+		if err := pkg.SetQuiet(true); err != nil {
+			return err
+		}
+	}
+
+	result, err := vm.Run(cmd.Context())
+	if err != nil {
+		return err
+	}
+
+	if vmBootJSON {
+		data, err := json.MarshalIndent(result, "", "  ")
+		if err != nil {
+			return fmt.Errorf("failed to marshal boot result: %w", err)
+		}
+		_, _ = fmt.Fprintln(pkg.Stdout(), string(data))
+		return result.Err()
+	}
+
+	if result.Booted {
+		fmt.Printf("✓ %s booted in %s (saw %q)\n", result.Disk, result.Duration.Round(time.Second), result.Marker)
+		return nil
+	}
+	fmt.Printf("✗ %s did not boot: %s\n", result.Disk, result.Detail)
+	if result.Console != "" {
+		fmt.Printf("Last console output: %s\n", result.Console)
+	}
+	return result.Err()
+}
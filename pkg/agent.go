@@ -0,0 +1,249 @@
+package pkg
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"time"
+
+	agentv1 "github.com/bketelsen/phukit/api/agent/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// AgentErrorCodeKey is the gRPC trailer that carries the machine-readable
+// error code of a failed call, see ErrorCode
+const AgentErrorCodeKey = "phukit-error-code"
+
+// AgentServer serves a Manager over the gRPC API in api/agent/v1, for fleet
+// controllers that orchestrate updates across many machines
+type AgentServer struct {
+	agentv1.UnimplementedAgentServer
+
+	ctx     context.Context
+	manager *Manager
+}
+
+// NewAgentServer creates an AgentServer for manager. ctx bounds the
+// operations started through the server and ends all streams when done.
+func NewAgentServer(ctx context.Context, manager *Manager) *AgentServer {
+	return &AgentServer{ctx: ctx, manager: manager}
+}
+
+// NewAgentGRPCServer creates a gRPC server that requires the client
+// certificates configured in tlsConfig and serves manager on it
+func NewAgentGRPCServer(ctx context.Context, manager *Manager, tlsConfig *tls.Config) *grpc.Server {
+	server := grpc.NewServer(grpc.Creds(credentials.NewTLS(tlsConfig)))
+	agentv1.RegisterAgentServer(server, NewAgentServer(ctx, manager))
+	return server
+}
+
+// NewAgentTLSConfig loads the server certificate and key and the CA that
+// client certificates must be signed by. Clients without a valid certificate
+// are refused during the handshake.
+func NewAgentTLSConfig(certFile, keyFile, clientCAFile string) (*tls.Config, error) {
+	if certFile == "" || keyFile == "" || clientCAFile == "" {
+		return nil, fmt.Errorf("mutual TLS needs a certificate, a key and a client CA")
+	}
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load server certificate: %w", err)
+	}
+	caPEM, err := os.ReadFile(clientCAFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read client CA: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(caPEM) {
+		return nil, fmt.Errorf("no certificates found in client CA %s", clientCAFile)
+	}
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    pool,
+		MinVersion:   tls.VersionTLS12,
+	}, nil
+}
+
+// Status returns the installed image and the active root
+func (a *AgentServer) Status(ctx context.Context, req *agentv1.StatusRequest) (*agentv1.StatusResponse, error) {
+	s, err := a.manager.Status()
+	if err != nil {
+		return nil, agentError(ctx, err)
+	}
+	return &agentv1.StatusResponse{
+		ImageRef:       s.Config.ImageRef,
+		ImageDigest:    s.Config.ImageDigest,
+		PinnedDigest:   s.Config.PinnedDigest,
+		Device:         s.Config.Device,
+		BootloaderType: s.Config.BootloaderType,
+		FilesystemType: s.Config.FilesystemType,
+		InstallDate:    s.Config.InstallDate,
+		ActiveRoot:     s.ActiveRoot,
+		ActiveSlot:     s.ActiveSlot,
+		Operation:      s.Operation,
+	}, nil
+}
+
+// Update starts an update and streams its events until the result event
+func (a *AgentServer) Update(req *agentv1.UpdateRequest, stream agentv1.Agent_UpdateServer) error {
+	events, unsubscribe := a.manager.watch()
+	defer unsubscribe()
+
+	ctx := WithInitiator(a.ctx, agentInitiator(stream.Context()))
+	err := a.manager.Update(ctx, UpdateRequest{ImageRef: req.GetImageRef(), Force: req.GetForce()})
+	if err != nil {
+		return agentError(stream.Context(), err)
+	}
+	return a.forward(stream.Context(), events, stream.Send, OperationUpdate)
+}
+
+// Rollback makes the other root the default boot entry and returns the
+// result document
+func (a *AgentServer) Rollback(ctx context.Context, req *agentv1.RollbackRequest) (*agentv1.RollbackResponse, error) {
+	events, unsubscribe := a.manager.watch()
+	defer unsubscribe()
+
+	if err := a.manager.Rollback(WithInitiator(a.ctx, agentInitiator(ctx))); err != nil {
+		return nil, agentError(ctx, err)
+	}
+	return &agentv1.RollbackResponse{Result: protoResult(events.result(OperationRollback))}, nil
+}
+
+// Watch streams the events of every operation until the client disconnects
+// or the server shuts down
+func (a *AgentServer) Watch(req *agentv1.WatchRequest, stream agentv1.Agent_WatchServer) error {
+	events, unsubscribe := a.manager.watch()
+	defer unsubscribe()
+	return a.forward(stream.Context(), events, stream.Send, "")
+}
+
+// forward sends events to a client until the result event of operation, or
+// forever if operation is empty
+func (a *AgentServer) forward(ctx context.Context, events *eventBuffer, send func(*agentv1.ProgressEvent) error, operation string) error {
+	for {
+		select {
+		case event := <-events.events:
+			if err := send(protoEvent(event)); err != nil {
+				return err
+			}
+			if operation != "" && event.Type == EventResult && event.Phase == operation {
+				return nil
+			}
+		case <-events.overflow:
+			return status.Error(codes.ResourceExhausted, "client is too slow to receive progress events")
+		case <-ctx.Done():
+			return status.FromContextError(ctx.Err()).Err()
+		case <-a.ctx.Done():
+			return status.Error(codes.Unavailable, "agent is shutting down")
+		}
+	}
+}
+
+// agentErrorCodes maps error codes to gRPC status codes; unlisted codes map
+// to Internal
+var agentErrorCodes = map[string]codes.Code{
+	CodeOperationInProgress:     codes.Aborted,
+	CodeDeviceBusy:              codes.Unavailable,
+	CodeDeviceNotFound:          codes.NotFound,
+	CodeImageNotFound:           codes.NotFound,
+	CodeInvalidImageReference:   codes.InvalidArgument,
+	CodeImageUnauthorized:       codes.PermissionDenied,
+	CodeInsufficientSpace:       codes.FailedPrecondition,
+	CodePartitionSchemeMismatch: codes.FailedPrecondition,
+	CodeSlotPinned:              codes.FailedPrecondition,
+	CodePinMismatch:             codes.FailedPrecondition,
+	CodeMissingTool:             codes.FailedPrecondition,
+	CodeTimeout:                 codes.DeadlineExceeded,
+	CodeCancelled:               codes.Canceled,
+}
+
+// agentError converts err to a gRPC status and sets the AgentErrorCodeKey
+// trailer to its error code
+func agentError(ctx context.Context, err error) error {
+	if err == nil {
+		return nil
+	}
+	code := ErrorCode(err)
+	_ = grpc.SetTrailer(ctx, metadata.Pairs(AgentErrorCodeKey, code))
+
+	grpcCode, ok := agentErrorCodes[code]
+	if !ok {
+		grpcCode = codes.Internal
+	}
+	return status.Error(grpcCode, err.Error())
+}
+
+// protoEvent converts a progress event to its gRPC form
+func protoEvent(e ProgressEvent) *agentv1.ProgressEvent {
+	return &agentv1.ProgressEvent{
+		Type:      e.Type,
+		Time:      protoTime(e.Time),
+		Phase:     e.Phase,
+		Current:   int64(e.Current),
+		Total:     int64(e.Total),
+		Message:   e.Message,
+		Status:    e.Status,
+		Error:     e.Error,
+		ErrorCode: e.ErrorCode,
+		Result:    protoResult(e.Result),
+	}
+}
+
+// protoResult converts a result document to its gRPC form
+func protoResult(r *OperationResult) *agentv1.OperationResult {
+	if r == nil {
+		return nil
+	}
+	result := &agentv1.OperationResult{
+		Operation:       r.Operation,
+		Status:          r.Status,
+		Error:           r.Error,
+		ErrorCode:       r.ErrorCode,
+		ImageRef:        r.ImageRef,
+		ImageDigest:     r.ImageDigest,
+		Device:          r.Device,
+		TargetSlot:      r.TargetSlot,
+		TargetPartition: r.TargetPartition,
+		KernelVersion:   r.KernelVersion,
+		StartTime:       protoTime(r.StartTime),
+		EndTime:         protoTime(r.EndTime),
+		DurationSeconds: r.DurationSeconds,
+	}
+	for _, p := range r.Phases {
+		result.Phases = append(result.Phases, &agentv1.PhaseResult{
+			Step:            p.Step,
+			Name:            p.Name,
+			DurationSeconds: p.DurationSeconds,
+		})
+	}
+	return result
+}
+
+// protoTime converts t, leaving zero times unset
+func protoTime(t time.Time) *timestamppb.Timestamp {
+	if t.IsZero() {
+		return nil
+	}
+	return timestamppb.New(t)
+}
+
+// agentInitiator names the client of a call by its certificate, e.g.
+// "grpc controller.example.com"
+func agentInitiator(ctx context.Context) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return "grpc"
+	}
+	if info, ok := p.AuthInfo.(credentials.TLSInfo); ok && len(info.State.PeerCertificates) > 0 {
+		return "grpc " + info.State.PeerCertificates[0].Subject.CommonName
+	}
+	return "grpc " + p.Addr.String()
+}
@@ -0,0 +1,242 @@
+package pkg
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	agentv1 "github.com/bketelsen/phukit/api/agent/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// testCert is a certificate and key signed by a test CA
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	der  []byte
+}
+
+// newTestCert creates a certificate signed by parent, or a self-signed CA if
+// parent is nil
+func newTestCert(t *testing.T, name string, parent *testCert, server bool) *testCert {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}
+	switch {
+	case parent == nil:
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		template.KeyUsage |= x509.KeyUsageCertSign
+	case server:
+		template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+		template.IPAddresses = []net.IP{net.ParseIP("127.0.0.1")}
+	default:
+		template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}
+	}
+
+	signer, signerKey := template, key
+	if parent != nil {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCert{cert: cert, key: key, der: der}
+}
+
+// writePEM writes the certificate and key of c to dir as name.crt and name.key
+func (c *testCert) writePEM(t *testing.T, dir, name string) (certFile, keyFile string) {
+	t.Helper()
+	keyDER, err := x509.MarshalECPrivateKey(c.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile = filepath.Join(dir, name+".crt")
+	keyFile = filepath.Join(dir, name+".key")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.der}), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile
+}
+
+// tlsCert converts c for use in a tls.Config
+func (c *testCert) tlsCert() tls.Certificate {
+	return tls.Certificate{Certificate: [][]byte{c.der}, PrivateKey: c.key}
+}
+
+// startTestAgent serves manager with mutual TLS on a local port and returns
+// the address and the CA that signed the server and client certificates
+func startTestAgent(t *testing.T, ctx context.Context, manager *Manager) (string, *testCert) {
+	t.Helper()
+	dir := t.TempDir()
+	ca := newTestCert(t, "test CA", nil, false)
+	caFile, _ := ca.writePEM(t, dir, "ca")
+	certFile, keyFile := newTestCert(t, "agent", ca, true).writePEM(t, dir, "agent")
+
+	tlsConfig, err := NewAgentTLSConfig(certFile, keyFile, caFile)
+	if err != nil {
+		t.Fatalf("NewAgentTLSConfig() error = %v", err)
+	}
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := NewAgentGRPCServer(ctx, manager, tlsConfig)
+	go func() { _ = server.Serve(lis) }()
+	t.Cleanup(server.Stop)
+	return lis.Addr().String(), ca
+}
+
+// dialTestAgent connects to address, presenting client if it is not nil
+func dialTestAgent(t *testing.T, address string, ca, client *testCert) agentv1.AgentClient {
+	t.Helper()
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	config := &tls.Config{RootCAs: roots, MinVersion: tls.VersionTLS12}
+	if client != nil {
+		config.Certificates = []tls.Certificate{client.tlsCert()}
+	}
+	conn, err := grpc.NewClient(address, grpc.WithTransportCredentials(credentials.NewTLS(config)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	return agentv1.NewAgentClient(conn)
+}
+
+func TestNewAgentTLSConfigRequiresAllFiles(t *testing.T) {
+	if _, err := NewAgentTLSConfig("server.crt", "server.key", ""); err == nil {
+		t.Error("NewAgentTLSConfig() without client CA succeeded, want error")
+	}
+}
+
+func TestAgentRequiresClientCertificate(t *testing.T) {
+	setupOperationLockPath(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	address, ca := startTestAgent(t, ctx, NewManager())
+
+	tests := []struct {
+		name   string
+		client *testCert
+	}{
+		{"no certificate", nil},
+		{"untrusted certificate", newTestCert(t, "client", newTestCert(t, "other CA", nil, false), false)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := dialTestAgent(t, address, ca, tt.client)
+			callCtx, callCancel := context.WithTimeout(ctx, 5*time.Second)
+			defer callCancel()
+			_, err := client.Status(callCtx, &agentv1.StatusRequest{})
+			if status.Code(err) != codes.Unavailable {
+				t.Errorf("Status() error = %v, want Unavailable", err)
+			}
+		})
+	}
+}
+
+func TestAgentWatchAndBusy(t *testing.T) {
+	setupOperationLockPath(t)
+	manager := NewManager()
+	SetProgressReporter(manager)
+	t.Cleanup(func() { SetProgressReporter(nil) })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	address, ca := startTestAgent(t, ctx, manager)
+	client := dialTestAgent(t, address, ca, newTestCert(t, "controller", ca, false))
+
+	callCtx, callCancel := context.WithTimeout(ctx, 10*time.Second)
+	defer callCancel()
+	stream, err := client.Watch(callCtx, &agentv1.WatchRequest{})
+	if err != nil {
+		t.Fatalf("Watch() error = %v", err)
+	}
+	// Wait until the server has subscribed the stream
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		manager.mu.Lock()
+		subscribed := len(manager.subs) > 0
+		manager.mu.Unlock()
+		if subscribed {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Watch stream was not subscribed")
+		}
+	}
+
+	release := make(chan struct{})
+	err = manager.Start(ctx, OperationUpdate, func(ctx context.Context) error {
+		reportPhaseProgress(PhaseBootloader, 1, 2, "writing")
+		<-release
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+
+	// A second operation is refused while the first runs
+	var trailer metadata.MD
+	_, err = client.Rollback(callCtx, &agentv1.RollbackRequest{}, grpc.Trailer(&trailer))
+	if status.Code(err) != codes.Aborted {
+		t.Errorf("Rollback() while busy error = %v, want Aborted", err)
+	}
+	if got := trailer.Get(AgentErrorCodeKey); len(got) != 1 || got[0] != CodeOperationInProgress {
+		t.Errorf("error code trailer = %v, want %s", got, CodeOperationInProgress)
+	}
+	close(release)
+
+	wantTypes := []string{EventPhaseProgress, EventComplete, EventResult}
+	for i, want := range wantTypes {
+		event, err := stream.Recv()
+		if err != nil {
+			t.Fatalf("Recv() event %d error = %v", i, err)
+		}
+		if event.GetType() != want {
+			t.Errorf("event %d type = %q, want %q", i, event.GetType(), want)
+		}
+		if want == EventResult {
+			result := event.GetResult()
+			if result.GetOperation() != OperationUpdate || result.GetStatus() != StatusSuccess {
+				t.Errorf("result = %v, want successful update", result)
+			}
+			if result.GetStartTime() == nil {
+				t.Error("result has no start time")
+			}
+		}
+	}
+	manager.Wait()
+}
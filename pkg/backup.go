@@ -0,0 +1,528 @@
+package pkg
+
+import (
+	"archive/tar"
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"golang.org/x/sys/unix"
+)
+
+// Backups
+//
+// BackupSystem writes the configuration and state of an installed system
+// that no image can bring back to a zstd-compressed tar, optionally
+// encrypted with a passphrase (see backup_crypt.go): the live /etc, which
+// /var/etc.backup only copies at every update, and the paths of /var the
+// administrator names, such as a database directory. A manifest recording
+// the host, the deployed image and the backed up paths comes first.
+//
+// RestoreBackup writes the files back onto a fresh install, which is
+// expected to run the same image. Files that describe the install itself
+// rather than the host's configuration, such as /etc/fstab with the UUIDs of
+// its filesystems, are kept from the install.
+
+// backupManifestName is the first entry of every backup
+const backupManifestName = "phukit-backup.json"
+
+// backupVersion is the archive layout of new backups
+const backupVersion = 1
+
+// restoreKept are the files of a backup, as archive names, that a restore
+// leaves as the install wrote them
+var restoreKept = map[string]bool{
+	"etc/fstab":      true,
+	"etc/crypttab":   true,
+	"etc/os-release": true,
+	strings.TrimPrefix(SystemConfigFile, "/"):                  true,
+	"etc/systemd/system/" + varMountUnit:                       true,
+	"etc/systemd/system/local-fs.target.wants/" + varMountUnit: true,
+}
+
+// BackupManifest describes a backup
+type BackupManifest struct {
+	Version     int       `json:"version"`
+	Created     time.Time `json:"created"`
+	Hostname    string    `json:"hostname,omitempty"`
+	ImageRef    string    `json:"image_ref,omitempty"`    // Image the backed up root was deployed from
+	ImageDigest string    `json:"image_digest,omitempty"` // Its digest
+	Paths       []string  `json:"paths"`                  // Backed up directories: /etc and paths of /var
+}
+
+// BackupOptions are the settings of a BackupSystem run
+type BackupOptions struct {
+	Root        string   // Mounted root to back up; "" for the booted root
+	Destination string   // Archive file to write
+	Paths       []string // Directories of /var to back up along with /etc
+	Passphrase  string   // Encrypts the archive if set
+	DryRun      bool
+}
+
+// BackupResult describes a written backup
+type BackupResult struct {
+	Root        string   `json:"root"`
+	Destination string   `json:"destination"`
+	Paths       []string `json:"paths"`
+	Files       int      `json:"files"`                  // Entries in the archive
+	Size        int64    `json:"size"`                   // Bytes of the regular files
+	ArchiveSize int64    `json:"archive_size,omitempty"` // Bytes of the archive
+	Encrypted   bool     `json:"encrypted"`
+}
+
+// RestoreOptions are the settings of a RestoreBackup run
+type RestoreOptions struct {
+	Root       string // Mounted root to restore into; "" for the booted root
+	Source     string // Archive file written by BackupSystem
+	Passphrase string // Passphrase of an encrypted archive
+	Force      bool   // Don't ask for confirmation
+	DryRun     bool
+}
+
+// RestoreResult describes a restored backup
+type RestoreResult struct {
+	Root     string          `json:"root"`
+	Source   string          `json:"source"`
+	Manifest *BackupManifest `json:"manifest"`
+	Files    int             `json:"files"`          // Entries written
+	Size     int64           `json:"size"`           // Bytes of the regular files written
+	Kept     []string        `json:"kept,omitempty"` // Files kept from the install
+}
+
+// BackupPaths checks and sorts the /var paths to back up. Each has to be a
+// directory below /var.
+func BackupPaths(paths []string) ([]string, error) {
+	var clean []string
+	for _, p := range paths {
+		if p != filepath.Clean(p) || !strings.HasPrefix(p, "/var/") {
+			return nil, fmt.Errorf("invalid backup path %q: must be a clean path below /var", p)
+		}
+		clean = append(clean, p)
+	}
+	slices.Sort(clean)
+	return slices.Compact(clean), nil
+}
+
+// BackupSystem writes /etc and opts.Paths of the root opts.Root to the
+// archive opts.Destination
+func BackupSystem(ctx context.Context, opts BackupOptions) (*BackupResult, error) {
+	root := cmp.Or(opts.Root, bootedRoot)
+	paths, err := BackupPaths(opts.Paths)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := os.Stat(filepath.Join(root, "etc")); err != nil {
+		return nil, fmt.Errorf("failed to read /etc: %w", err)
+	}
+
+	manifest := &BackupManifest{Version: backupVersion, Created: time.Now().UTC(), Paths: []string{"/etc"}}
+	if hostname, err := os.ReadFile(filepath.Join(root, "etc", "hostname")); err == nil {
+		manifest.Hostname = strings.TrimSpace(string(hostname))
+	}
+	if d, err := ReadDeployment(root); err == nil {
+		manifest.ImageRef, manifest.ImageDigest = d.ImageRef, d.ImageDigest
+	} else {
+		logger.Debug("backed up root has no deployment record", "root", root, "error", err)
+	}
+	for _, p := range paths {
+		if info, err := os.Stat(filepath.Join(root, p)); err != nil || !info.IsDir() {
+			if werr := warnf("%s is not a directory, not backing it up", p); werr != nil {
+				return nil, werr
+			}
+			continue
+		}
+		manifest.Paths = append(manifest.Paths, p)
+	}
+
+	result := &BackupResult{Root: root, Destination: opts.Destination, Paths: manifest.Paths, Encrypted: opts.Passphrase != ""}
+	fmt.Printf("Backing up %s of %s...\n", strings.Join(manifest.Paths, ", "), root)
+	if opts.DryRun {
+		err := walkBackup(ctx, root, manifest.Paths, func(_, _ string, info fs.FileInfo) error {
+			result.Files++
+			if info.Mode().IsRegular() {
+				result.Size += info.Size()
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		fmt.Printf("[DRY RUN] Would back up %d files (%s) to %s\n", result.Files, FormatSize(uint64(result.Size)), opts.Destination)
+		return result, nil
+	}
+
+	if err := writeBackup(ctx, root, manifest, opts, result); err != nil {
+		return nil, err
+	}
+	if info, err := os.Stat(opts.Destination); err == nil {
+		result.ArchiveSize = info.Size()
+	}
+	encrypted := ""
+	if result.Encrypted {
+		encrypted = ", encrypted"
+	}
+	fmt.Printf("  Size: %s in %d files, %s compressed%s\n", FormatSize(uint64(result.Size)), result.Files, FormatSize(uint64(result.ArchiveSize)), encrypted)
+	logger.Info("system backed up", "root", root, "destination", opts.Destination, "paths", manifest.Paths, "files", result.Files)
+	return result, nil
+}
+
+// walkBackup calls fn with the path, the archive name and the info of every
+// entry of the directories paths of root, starting with the directories
+// themselves. Filesystems mounted below them are passed without their
+// contents.
+func walkBackup(ctx context.Context, root string, paths []string, fn func(p, name string, info fs.FileInfo) error) error {
+	for _, p := range paths {
+		dir := filepath.Join(root, p)
+		name := strings.TrimPrefix(p, "/")
+		info, err := os.Lstat(dir)
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", dir, err)
+		}
+		if err := fn(dir, name, info); err != nil {
+			return err
+		}
+		skip, err := mountPointsBelow(dir)
+		if err != nil {
+			return err
+		}
+		err = walkTree(ctx, dir, nil, skip, func(p, rel string, info fs.FileInfo) error {
+			return fn(p, name+"/"+rel, info)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeBackup writes the archive of manifest to a temporary file next to
+// opts.Destination and renames it into place, counting its entries in
+// result. The archive holds /etc/shadow, so only root can read it.
+func writeBackup(ctx context.Context, root string, manifest *BackupManifest, opts BackupOptions, result *BackupResult) (err error) {
+	f, err := os.CreateTemp(filepath.Dir(opts.Destination), "."+filepath.Base(opts.Destination)+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("failed to create backup: %w", err)
+	}
+	defer func() {
+		_ = f.Close()
+		if err != nil {
+			_ = os.Remove(f.Name())
+		}
+	}()
+	if err := f.Chmod(0600); err != nil {
+		return fmt.Errorf("failed to create backup: %w", err)
+	}
+
+	var w io.Writer = f
+	var enc *backupEncrypter
+	if opts.Passphrase != "" {
+		if enc, err = newBackupEncrypter(f, opts.Passphrase); err != nil {
+			return fmt.Errorf("failed to encrypt backup: %w", err)
+		}
+		w = enc
+	}
+	zw, err := zstd.NewWriter(w)
+	if err != nil {
+		return fmt.Errorf("failed to compress backup: %w", err)
+	}
+	tw := tar.NewWriter(zw)
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal backup manifest: %w", err)
+	}
+	hdr := &tar.Header{Name: backupManifestName, Mode: 0600, Size: int64(len(data)), ModTime: manifest.Created, Format: tar.FormatPAX}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return fmt.Errorf("failed to write backup: %w", err)
+	}
+	if _, err := tw.Write(data); err != nil {
+		return fmt.Errorf("failed to write backup: %w", err)
+	}
+
+	links := map[inodeKey]string{}
+	err = walkBackup(ctx, root, manifest.Paths, func(p, name string, info fs.FileInfo) error {
+		if err := writeExportEntry(tw, p, name, info, links); err != nil {
+			return fmt.Errorf("%s: %w", p, err)
+		}
+		result.Files++
+		if info.Mode().IsRegular() {
+			result.Size += info.Size()
+		}
+		if result.Files%1000 == 0 {
+			reportPhaseProgress(PhaseBackup, result.Files, 0, "/"+name)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := tw.Close(); err != nil {
+		return fmt.Errorf("failed to write backup: %w", err)
+	}
+	if err := zw.Close(); err != nil {
+		return fmt.Errorf("failed to write backup: %w", err)
+	}
+	if enc != nil {
+		if err := enc.Close(); err != nil {
+			return fmt.Errorf("failed to write backup: %w", err)
+		}
+	}
+	if err := f.Sync(); err != nil {
+		return fmt.Errorf("failed to write backup: %w", err)
+	}
+	if err := os.Rename(f.Name(), opts.Destination); err != nil {
+		return fmt.Errorf("failed to write backup: %w", err)
+	}
+	return nil
+}
+
+// openBackup opens the archive of the backup at path and reads its manifest.
+// The returned function closes the backup.
+func openBackup(path, passphrase string) (*tar.Reader, *BackupManifest, func(), error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to open backup: %w", err)
+	}
+	stream, _, err := openBackupStream(f, passphrase)
+	if err != nil {
+		_ = f.Close()
+		return nil, nil, nil, err
+	}
+	zr, err := zstd.NewReader(stream)
+	if err != nil {
+		_ = f.Close()
+		return nil, nil, nil, fmt.Errorf("failed to open zstd stream: %w", err)
+	}
+	closeBackup := func() {
+		zr.Close()
+		_ = f.Close()
+	}
+
+	tr := tar.NewReader(zr)
+	hdr, err := tr.Next()
+	if err != nil || hdr.Name != backupManifestName {
+		closeBackup()
+		if err == nil || errors.Is(err, io.EOF) {
+			err = errors.New("no manifest")
+		}
+		return nil, nil, nil, fmt.Errorf("%s is not a phukit backup: %w", path, err)
+	}
+	var manifest BackupManifest
+	if err := json.NewDecoder(tr).Decode(&manifest); err != nil {
+		closeBackup()
+		return nil, nil, nil, fmt.Errorf("failed to parse backup manifest: %w", err)
+	}
+	if manifest.Version != backupVersion {
+		closeBackup()
+		return nil, nil, nil, fmt.Errorf("backup has unsupported version %d", manifest.Version)
+	}
+	return tr, &manifest, closeBackup, nil
+}
+
+// RestoreBackup writes the files of the backup opts.Source into the root
+// opts.Root
+func RestoreBackup(ctx context.Context, opts RestoreOptions) (*RestoreResult, error) {
+	root := cmp.Or(opts.Root, bootedRoot)
+	tr, manifest, closeBackup, err := openBackup(opts.Source, opts.Passphrase)
+	if err != nil {
+		return nil, err
+	}
+	defer closeBackup()
+	allowed, err := backupRestorePaths(manifest)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &RestoreResult{Root: root, Source: opts.Source, Manifest: manifest}
+	fmt.Printf("Backup of %s from %s\n", cmp.Or(manifest.Hostname, "unknown host"), manifest.Created.Local().Format(time.DateTime))
+	if manifest.ImageRef != "" {
+		fmt.Printf("  Image: %s\n", manifest.ImageRef)
+	}
+	fmt.Printf("  Paths: %s\n", strings.Join(manifest.Paths, ", "))
+	if d, err := ReadDeployment(root); err == nil && manifest.ImageRef != "" && d.ImageRef != manifest.ImageRef {
+		if werr := warnf("the backup was taken on %s, but %s is deployed from %s", manifest.ImageRef, root, d.ImageRef); werr != nil {
+			return nil, werr
+		}
+	}
+
+	if !opts.DryRun && !opts.Force && !confirm(
+		fmt.Sprintf("WARNING: This overwrites %s in %s with the files of the backup.", strings.Join(manifest.Paths, ", "), root),
+		"Stop the services using these paths first.",
+	) {
+		return nil, fmt.Errorf("restore %w", ErrAborted)
+	}
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("failed to read backup: %w", err)
+		}
+		name := strings.TrimSuffix(hdr.Name, "/")
+		if restoreKept[name] {
+			result.Kept = append(result.Kept, "/"+name)
+			continue
+		}
+		if opts.DryRun {
+			if _, err := restoreTarget(root, name, allowed); err != nil {
+				return nil, err
+			}
+		} else if err := restoreEntry(root, hdr, tr, allowed); err != nil {
+			return nil, err
+		}
+		result.Files++
+		if hdr.Typeflag == tar.TypeReg {
+			result.Size += hdr.Size
+		}
+		if result.Files%1000 == 0 {
+			reportPhaseProgress(PhaseRestore, result.Files, 0, "/"+name)
+		}
+	}
+
+	if len(result.Kept) > 0 {
+		fmt.Printf("  Kept from the install: %s\n", strings.Join(result.Kept, ", "))
+	}
+	if opts.DryRun {
+		fmt.Printf("[DRY RUN] Would restore %d files (%s) to %s\n", result.Files, FormatSize(uint64(result.Size)), root)
+		return result, nil
+	}
+	logger.Info("backup restored", "root", root, "source", opts.Source, "files", result.Files)
+	return result, nil
+}
+
+// backupRestorePaths returns the archive names of the directories of the
+// backup, checking the manifest
+func backupRestorePaths(manifest *BackupManifest) ([]string, error) {
+	var names []string
+	for _, p := range manifest.Paths {
+		if p != "/etc" {
+			if _, err := BackupPaths([]string{p}); err != nil {
+				return nil, err
+			}
+		}
+		names = append(names, strings.TrimPrefix(p, "/"))
+	}
+	return names, nil
+}
+
+// restoreTarget returns where the entry name of a backup is written below
+// root. Names outside the backed up directories are refused, as are those
+// that would be written through a symlink.
+func restoreTarget(root, name string, allowed []string) (string, error) {
+	inside := slices.ContainsFunc(allowed, func(dir string) bool {
+		return name == dir || strings.HasPrefix(name, dir+"/")
+	})
+	if !inside || path.Clean(name) != name {
+		return "", fmt.Errorf("backup entry %q is outside the backed up paths", name)
+	}
+	dir := root
+	parts := strings.Split(name, "/")
+	for _, part := range parts[:len(parts)-1] {
+		dir = filepath.Join(dir, part)
+		info, err := os.Lstat(dir)
+		if os.IsNotExist(err) {
+			break
+		} else if err != nil {
+			return "", err
+		}
+		if info.Mode()&fs.ModeSymlink != 0 {
+			return "", fmt.Errorf("cannot restore %s: %s is a symlink", name, dir)
+		}
+	}
+	return filepath.Join(root, name), nil
+}
+
+// restoreEntry writes the entry hdr of a backup below root, replacing what
+// is there, with its owner, mode and extended attributes
+func restoreEntry(root string, hdr *tar.Header, r io.Reader, allowed []string) error {
+	target, err := restoreTarget(root, strings.TrimSuffix(hdr.Name, "/"), allowed)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return fmt.Errorf("failed to create parent directory: %w", err)
+	}
+	// Whatever is in the way goes, unless it is the directory to restore
+	if info, err := os.Lstat(target); err == nil && (hdr.Typeflag != tar.TypeDir || !info.IsDir()) {
+		if err := os.RemoveAll(target); err != nil {
+			return fmt.Errorf("failed to replace %s: %w", target, err)
+		}
+	}
+
+	switch hdr.Typeflag {
+	case tar.TypeDir:
+		if err := os.MkdirAll(target, 0700); err != nil {
+			return fmt.Errorf("failed to create directory %s: %w", target, err)
+		}
+	case tar.TypeReg:
+		f, err := os.OpenFile(target, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+		if err != nil {
+			return fmt.Errorf("failed to create file %s: %w", target, err)
+		}
+		if _, err := io.Copy(f, r); err != nil {
+			_ = f.Close()
+			return fmt.Errorf("failed to write file %s: %w", target, err)
+		}
+		if err := f.Close(); err != nil {
+			return fmt.Errorf("failed to write file %s: %w", target, err)
+		}
+	case tar.TypeSymlink:
+		if err := os.Symlink(hdr.Linkname, target); err != nil {
+			return fmt.Errorf("failed to create symlink %s: %w", target, err)
+		}
+	case tar.TypeLink:
+		first, err := restoreTarget(root, hdr.Linkname, allowed)
+		if err != nil {
+			return err
+		}
+		// A hardlink shares its owner, mode and attributes with the first name
+		if err := os.Link(first, target); err != nil {
+			return fmt.Errorf("failed to create hard link %s: %w", target, err)
+		}
+		return nil
+	default:
+		// Devices and FIFOs are recreated by the services owning them
+		logger.Debug("not restoring special file", "path", target, "type", hdr.Typeflag)
+		return nil
+	}
+
+	if err := os.Lchown(target, hdr.Uid, hdr.Gid); err != nil && !errors.Is(err, syscall.EPERM) {
+		return fmt.Errorf("failed to set owner of %s: %w", target, err)
+	}
+	for key, value := range hdr.PAXRecords {
+		if name, ok := strings.CutPrefix(key, "SCHILY.xattr."); ok {
+			if err := unix.Lsetxattr(target, name, []byte(value), 0); err != nil {
+				logger.Debug("failed to restore extended attribute", "path", target, "name", name, "error", err)
+			}
+		}
+	}
+	if hdr.Typeflag == tar.TypeSymlink {
+		return nil
+	}
+	// The mode is set after the owner, which clears the setuid and setgid bits
+	mode := hdr.FileInfo().Mode() & (os.ModePerm | os.ModeSetuid | os.ModeSetgid | os.ModeSticky)
+	if err := os.Chmod(target, mode); err != nil {
+		return fmt.Errorf("failed to set mode of %s: %w", target, err)
+	}
+	if hdr.Typeflag == tar.TypeReg {
+		_ = os.Chtimes(target, hdr.ModTime, hdr.ModTime)
+	}
+	return nil
+}
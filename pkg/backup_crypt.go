@@ -0,0 +1,226 @@
+package pkg
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// Backup encryption
+//
+// An encrypted backup is the compressed archive sealed with AES-256-GCM
+// under a key derived from a passphrase with PBKDF2-SHA256. After a header
+// of backupCryptMagic, the salt and the PBKDF2 iterations, the archive
+// follows in chunks of up to backupCryptChunkSize bytes, each framed by a
+// flag byte and its sealed length. The nonce of a chunk is its number and
+// the flag, which marks the last chunk, so chunks cannot be reordered,
+// dropped or cut off without the restore noticing.
+
+// backupCryptMagic starts every encrypted backup
+const backupCryptMagic = "phukit-backup-aes256gcm\n"
+
+// backupCryptIterations is the PBKDF2 work factor of new backups
+const backupCryptIterations = 600000
+
+// backupCryptChunkSize is the size of every chunk but the last
+const backupCryptChunkSize = 64 * 1024
+
+// Flags of a chunk
+const (
+	backupChunkMore = 0
+	backupChunkLast = 1
+)
+
+// ReadPassphraseFile reads a backup passphrase from the first line of path
+func ReadPassphraseFile(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read passphrase: %w", err)
+	}
+	passphrase, _, _ := strings.Cut(string(data), "\n")
+	passphrase = strings.TrimSuffix(passphrase, "\r")
+	if passphrase == "" {
+		return "", fmt.Errorf("passphrase file %s is empty", path)
+	}
+	return passphrase, nil
+}
+
+// backupCipher returns the AEAD of passphrase and salt
+func backupCipher(passphrase string, salt []byte, iterations int) (cipher.AEAD, error) {
+	key, err := pbkdf2.Key(sha256.New, passphrase, salt, iterations, 32)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// backupNonce returns the nonce of chunk n with flag
+func backupNonce(aead cipher.AEAD, n uint64, flag byte) []byte {
+	nonce := make([]byte, aead.NonceSize())
+	binary.BigEndian.PutUint64(nonce[len(nonce)-9:], n)
+	nonce[len(nonce)-1] = flag
+	return nonce
+}
+
+// backupEncrypter seals what is written to it into w; Close writes the last
+// chunk
+type backupEncrypter struct {
+	w    io.Writer
+	aead cipher.AEAD
+	buf  []byte
+	n    uint64
+}
+
+// newBackupEncrypter writes the header of an encrypted backup to w
+func newBackupEncrypter(w io.Writer, passphrase string) (*backupEncrypter, error) {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	aead, err := backupCipher(passphrase, salt, backupCryptIterations)
+	if err != nil {
+		return nil, err
+	}
+	header := append([]byte(backupCryptMagic), salt...)
+	header = binary.BigEndian.AppendUint32(header, backupCryptIterations)
+	if _, err := w.Write(header); err != nil {
+		return nil, err
+	}
+	return &backupEncrypter{w: w, aead: aead, buf: make([]byte, 0, backupCryptChunkSize)}, nil
+}
+
+func (e *backupEncrypter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		// A full chunk is only sealed once more data follows, since the
+		// last chunk has to carry the flag
+		if len(e.buf) == backupCryptChunkSize {
+			if err := e.seal(backupChunkMore); err != nil {
+				return written, err
+			}
+		}
+		n := copy(e.buf[len(e.buf):cap(e.buf)], p)
+		e.buf = e.buf[:len(e.buf)+n]
+		p = p[n:]
+		written += n
+	}
+	return written, nil
+}
+
+// Close seals the last chunk
+func (e *backupEncrypter) Close() error {
+	return e.seal(backupChunkLast)
+}
+
+func (e *backupEncrypter) seal(flag byte) error {
+	sealed := e.aead.Seal(nil, backupNonce(e.aead, e.n, flag), e.buf, nil)
+	frame := binary.BigEndian.AppendUint32([]byte{flag}, uint32(len(sealed)))
+	if _, err := e.w.Write(append(frame, sealed...)); err != nil {
+		return err
+	}
+	e.n++
+	e.buf = e.buf[:0]
+	return nil
+}
+
+// backupDecrypter reads the archive of an encrypted backup
+type backupDecrypter struct {
+	r    io.Reader
+	aead cipher.AEAD
+	buf  []byte
+	n    uint64
+	last bool
+}
+
+// newBackupDecrypter reads the header of the encrypted backup r after its
+// magic
+func newBackupDecrypter(r io.Reader, passphrase string) (*backupDecrypter, error) {
+	header := make([]byte, 16+4)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, fmt.Errorf("failed to read backup header: %w", err)
+	}
+	iterations := binary.BigEndian.Uint32(header[16:])
+	if iterations == 0 || iterations > 100*backupCryptIterations {
+		return nil, fmt.Errorf("backup header has invalid PBKDF2 iterations %d", iterations)
+	}
+	aead, err := backupCipher(passphrase, header[:16], int(iterations))
+	if err != nil {
+		return nil, err
+	}
+	return &backupDecrypter{r: r, aead: aead}, nil
+}
+
+func (d *backupDecrypter) Read(p []byte) (int, error) {
+	for len(d.buf) == 0 {
+		if d.last {
+			return 0, io.EOF
+		}
+		if err := d.open(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, d.buf)
+	d.buf = d.buf[n:]
+	return n, nil
+}
+
+func (d *backupDecrypter) open() error {
+	frame := make([]byte, 5)
+	if _, err := io.ReadFull(d.r, frame); err != nil {
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return errors.New("backup is truncated")
+		}
+		return err
+	}
+	flag, size := frame[0], binary.BigEndian.Uint32(frame[1:])
+	if flag != backupChunkMore && flag != backupChunkLast || size > backupCryptChunkSize+uint32(d.aead.Overhead()) {
+		return errors.New("backup is damaged")
+	}
+	sealed := make([]byte, size)
+	if _, err := io.ReadFull(d.r, sealed); err != nil {
+		return errors.New("backup is truncated")
+	}
+	plain, err := d.aead.Open(sealed[:0], backupNonce(d.aead, d.n, flag), sealed, nil)
+	if err != nil {
+		if d.n == 0 {
+			return errors.New("wrong passphrase or damaged backup")
+		}
+		return errors.New("backup is damaged")
+	}
+	d.buf, d.last = plain, flag == backupChunkLast
+	d.n++
+	return nil
+}
+
+// openBackupStream returns the compressed archive of the backup r, decrypted
+// with passphrase if it is encrypted, and whether it was
+func openBackupStream(r io.Reader, passphrase string) (io.Reader, bool, error) {
+	br := bufio.NewReader(r)
+	magic, err := br.Peek(len(backupCryptMagic))
+	if err != nil || !bytes.Equal(magic, []byte(backupCryptMagic)) {
+		return br, false, nil
+	}
+	if passphrase == "" {
+		return nil, true, errors.New("backup is encrypted; give its passphrase with --passphrase-file")
+	}
+	_, _ = br.Discard(len(backupCryptMagic))
+	d, err := newBackupDecrypter(br, passphrase)
+	if err != nil {
+		return nil, true, err
+	}
+	return d, true, nil
+}
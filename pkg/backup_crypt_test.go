@@ -0,0 +1,103 @@
+package pkg
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// encryptBackup encrypts data as a backup with passphrase
+func encryptBackup(t *testing.T, data []byte, passphrase string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	enc, err := newBackupEncrypter(&buf, passphrase)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := enc.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := enc.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestBackupEncryption(t *testing.T) {
+	// Two full chunks and a partial one
+	data := make([]byte, 2*backupCryptChunkSize+100)
+	if _, err := rand.Read(data); err != nil {
+		t.Fatal(err)
+	}
+	sealed := encryptBackup(t, data, "correct horse")
+	if bytes.Contains(sealed, data[:64]) {
+		t.Fatal("encrypted backup holds the plain data")
+	}
+
+	read := func(sealed []byte, passphrase string) ([]byte, error) {
+		r, encrypted, err := openBackupStream(bytes.NewReader(sealed), passphrase)
+		if err != nil {
+			return nil, err
+		}
+		if !encrypted {
+			t.Error("openBackupStream() did not detect the encryption")
+		}
+		return io.ReadAll(r)
+	}
+
+	got, err := read(sealed, "correct horse")
+	if err != nil {
+		t.Fatalf("decrypting error = %v", err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Error("decrypted data differs")
+	}
+
+	if _, err := read(sealed, "wrong"); err == nil || !strings.Contains(err.Error(), "wrong passphrase") {
+		t.Errorf("wrong passphrase error = %v", err)
+	}
+	if _, err := read(sealed, ""); err == nil || !strings.Contains(err.Error(), "--passphrase-file") {
+		t.Errorf("missing passphrase error = %v", err)
+	}
+	// Cutting off the last chunk or swapping chunks is detected
+	chunk := 5 + backupCryptChunkSize + 16
+	header := len(backupCryptMagic) + 16 + 4
+	if _, err := read(sealed[:header+2*chunk], "correct horse"); err == nil || !strings.Contains(err.Error(), "truncated") {
+		t.Errorf("truncated backup error = %v", err)
+	}
+	swapped := slices.Concat(sealed[:header], sealed[header+chunk:header+2*chunk], sealed[header:header+chunk], sealed[header+2*chunk:])
+	if _, err := read(swapped, "correct horse"); err == nil || !strings.Contains(err.Error(), "damaged") {
+		t.Errorf("reordered backup error = %v", err)
+	}
+}
+
+func TestOpenBackupStreamPlain(t *testing.T) {
+	r, encrypted, err := openBackupStream(strings.NewReader("plain archive"), "")
+	if err != nil || encrypted {
+		t.Fatalf("openBackupStream() = %v, %v", encrypted, err)
+	}
+	if got, _ := io.ReadAll(r); string(got) != "plain archive" {
+		t.Errorf("openBackupStream() read %q", got)
+	}
+}
+
+func TestReadPassphraseFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "key")
+	writeFakeFile(t, path, "s3cret phrase\r\nignored\n")
+	if got, err := ReadPassphraseFile(path); err != nil || got != "s3cret phrase" {
+		t.Errorf("ReadPassphraseFile() = %q, %v", got, err)
+	}
+
+	writeFakeFile(t, path, "\n")
+	if _, err := ReadPassphraseFile(path); err == nil {
+		t.Error("ReadPassphraseFile() accepted an empty passphrase")
+	}
+	if _, err := ReadPassphraseFile(filepath.Join(dir, "missing")); err == nil {
+		t.Error("ReadPassphraseFile() of a missing file succeeded")
+	}
+}
@@ -0,0 +1,181 @@
+package pkg
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// backupTestRoot creates a root with /etc, a /var directory and a deployment
+// record, and fakes an empty mount table
+func backupTestRoot(t *testing.T, hostname string) string {
+	t.Helper()
+	root := t.TempDir()
+	for path, content := range map[string]string{
+		"etc/hostname":                hostname + "\n",
+		"etc/fstab":                   "UUID=" + hostname + " /var ext4 defaults 0 2\n",
+		"etc/ssh/sshd_config":         "PermitRootLogin no\n",
+		"var/lib/app/db/data":         "rows of " + hostname,
+		"var/lib/other/not-backed-up": "",
+		"usr/bin/bash":                "#!bash",
+	} {
+		writeFakeFile(t, filepath.Join(root, path), content)
+	}
+	if err := WriteDeployment(root, &Deployment{ImageRef: "quay.io/example/os:42", ImageDigest: "sha256:0123"}, false); err != nil {
+		t.Fatal(err)
+	}
+
+	oldMounts := procMountsPath
+	procMountsPath = filepath.Join(t.TempDir(), "mounts")
+	t.Cleanup(func() { procMountsPath = oldMounts })
+	writeFakeFile(t, procMountsPath, "")
+	return root
+}
+
+func TestBackupAndRestore(t *testing.T) {
+	for _, passphrase := range []string{"", "correct horse"} {
+		t.Run("passphrase "+passphrase, func(t *testing.T) {
+			old := backupTestRoot(t, "web01")
+			if err := os.Chmod(filepath.Join(old, "etc/ssh/sshd_config"), 0600); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.Link(filepath.Join(old, "etc/hostname"), filepath.Join(old, "etc/hostname.bak")); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.Symlink("ssh/sshd_config", filepath.Join(old, "etc/sshd")); err != nil {
+				t.Fatal(err)
+			}
+			archive := filepath.Join(t.TempDir(), "web01.tar.zst")
+
+			result, err := BackupSystem(t.Context(), BackupOptions{Root: old, Destination: archive, Paths: []string{"/var/lib/app"}, Passphrase: passphrase})
+			if err != nil {
+				t.Fatalf("BackupSystem() error = %v", err)
+			}
+			if !slices.Equal(result.Paths, []string{"/etc", "/var/lib/app"}) || result.Encrypted != (passphrase != "") {
+				t.Errorf("BackupSystem() = %+v", result)
+			}
+			if info, err := os.Stat(archive); err != nil || info.Mode().Perm() != 0600 {
+				t.Errorf("archive = %v, %v, want mode 0600", info, err)
+			}
+
+			// A fresh install of the same image on another disk
+			fresh := backupTestRoot(t, "localhost")
+			restored, err := RestoreBackup(t.Context(), RestoreOptions{Root: fresh, Source: archive, Passphrase: passphrase, Force: true})
+			if err != nil {
+				t.Fatalf("RestoreBackup() error = %v", err)
+			}
+			if restored.Manifest.Hostname != "web01" || restored.Manifest.ImageRef != "quay.io/example/os:42" {
+				t.Errorf("manifest = %+v", restored.Manifest)
+			}
+			if !slices.Equal(restored.Kept, []string{"/etc/fstab"}) {
+				t.Errorf("kept = %v, want /etc/fstab", restored.Kept)
+			}
+
+			for path, want := range map[string]string{
+				"etc/hostname":                "web01\n",
+				"etc/hostname.bak":            "web01\n",
+				"etc/fstab":                   "UUID=localhost /var ext4 defaults 0 2\n",
+				"var/lib/app/db/data":         "rows of web01",
+				"var/lib/other/not-backed-up": "",
+			} {
+				if got, err := os.ReadFile(filepath.Join(fresh, path)); err != nil || string(got) != want {
+					t.Errorf("%s = %q, %v, want %q", path, got, err, want)
+				}
+			}
+			if info, err := os.Stat(filepath.Join(fresh, "etc/ssh/sshd_config")); err != nil || info.Mode().Perm() != 0600 {
+				t.Errorf("sshd_config = %v, %v, want mode 0600", info, err)
+			}
+			if link, err := os.Readlink(filepath.Join(fresh, "etc/sshd")); err != nil || link != "ssh/sshd_config" {
+				t.Errorf("etc/sshd = %q, %v, want a symlink", link, err)
+			}
+		})
+	}
+}
+
+func TestBackupDryRun(t *testing.T) {
+	root := backupTestRoot(t, "web01")
+	archive := filepath.Join(t.TempDir(), "web01.tar.zst")
+
+	result, err := BackupSystem(t.Context(), BackupOptions{Root: root, Destination: archive, Paths: []string{"/var/lib/app", "/var/lib/missing"}, DryRun: true})
+	if err != nil {
+		t.Fatalf("BackupSystem() error = %v", err)
+	}
+	// etc, its files and ssh, var/lib/app, db and data
+	if result.Files != 8 || !slices.Equal(result.Paths, []string{"/etc", "/var/lib/app"}) {
+		t.Errorf("BackupSystem() = %+v", result)
+	}
+	if _, err := os.Stat(archive); !os.IsNotExist(err) {
+		t.Errorf("dry run wrote %s", archive)
+	}
+
+	if _, err := BackupSystem(t.Context(), BackupOptions{Root: root, Destination: archive, Paths: []string{"/srv"}}); err == nil {
+		t.Error("BackupSystem() accepted a path outside /var")
+	}
+}
+
+func TestRestoreBackupDryRun(t *testing.T) {
+	old := backupTestRoot(t, "web01")
+	archive := filepath.Join(t.TempDir(), "web01.tar.zst")
+	if _, err := BackupSystem(t.Context(), BackupOptions{Root: old, Destination: archive}); err != nil {
+		t.Fatal(err)
+	}
+
+	fresh := backupTestRoot(t, "localhost")
+	result, err := RestoreBackup(t.Context(), RestoreOptions{Root: fresh, Source: archive, DryRun: true})
+	if err != nil {
+		t.Fatalf("RestoreBackup() error = %v", err)
+	}
+	// etc, hostname, ssh and sshd_config; fstab is kept
+	if result.Files != 4 {
+		t.Errorf("RestoreBackup() files = %d, want 4", result.Files)
+	}
+	if got, _ := os.ReadFile(filepath.Join(fresh, "etc/hostname")); string(got) != "localhost\n" {
+		t.Errorf("dry run restored etc/hostname = %q", got)
+	}
+
+	notBackup := filepath.Join(t.TempDir(), "other.tar.zst")
+	writeFakeFile(t, notBackup, "not a backup")
+	if _, err := RestoreBackup(t.Context(), RestoreOptions{Root: fresh, Source: notBackup, Force: true}); err == nil {
+		t.Error("RestoreBackup() accepted a file that is no backup")
+	}
+}
+
+func TestBackupPaths(t *testing.T) {
+	got, err := BackupPaths([]string{"/var/www", "/var/lib/postgresql", "/var/www"})
+	if err != nil || !slices.Equal(got, []string{"/var/lib/postgresql", "/var/www"}) {
+		t.Errorf("BackupPaths() = %v, %v", got, err)
+	}
+	for _, p := range []string{"/var", "/var/", "/etc/ssh", "var/lib/x", "/var/lib/../../etc", "/var/lib/x/"} {
+		if _, err := BackupPaths([]string{p}); err == nil {
+			t.Errorf("BackupPaths(%q) succeeded", p)
+		}
+	}
+}
+
+func TestRestoreTarget(t *testing.T) {
+	root := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(root, "var/lib"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("/", filepath.Join(root, "var/lib/app")); err != nil {
+		t.Fatal(err)
+	}
+	allowed := []string{"etc", "var/lib/app"}
+
+	if got, err := restoreTarget(root, "etc/ssh/sshd_config", allowed); err != nil || got != filepath.Join(root, "etc/ssh/sshd_config") {
+		t.Errorf("restoreTarget() = %q, %v", got, err)
+	}
+	for _, name := range []string{"usr/bin/sh", "etc/../usr/bin/sh", "etcetera", "var/lib/app/etc/shadow"} {
+		if _, err := restoreTarget(root, name, allowed); err == nil {
+			t.Errorf("restoreTarget(%q) succeeded", name)
+		} else if name == "var/lib/app/etc/shadow" && !strings.Contains(err.Error(), "symlink") {
+			t.Errorf("restoreTarget(%q) error = %v, want a symlink error", name, err)
+		}
+	}
+	// The symlink itself can be replaced
+	if _, err := restoreTarget(root, "var/lib/app", allowed); err != nil {
+		t.Errorf("restoreTarget(var/lib/app) error = %v", err)
+	}
+}
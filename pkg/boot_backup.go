@@ -0,0 +1,108 @@
+package pkg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// cancelled returns an error if ctx is done, so multi-step workflows can stop
+// between steps and let their deferred cleanup run
+func cancelled(ctx context.Context) error {
+	if ctx.Err() == nil {
+		return nil
+	}
+	return fmt.Errorf("operation cancelled: %w", context.Cause(ctx))
+}
+
+// writeFileAtomic writes data to a temporary file next to path and renames it
+// into place, so an interrupted write never leaves a truncated file behind
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	tmpPath := tmp.Name()
+	defer func() { _ = os.Remove(tmpPath) }()
+
+	if _, err := tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(perm); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		return err
+	}
+
+	// Persist the rename itself; the ESP is usually vfat, which is easily
+	// left inconsistent by power loss
+	if dir, err := os.Open(filepath.Dir(path)); err == nil {
+		_ = dir.Sync()
+		_ = dir.Close()
+	}
+	return nil
+}
+
+// bootFileBackup holds the previous contents of boot configuration files so a
+// failed or interrupted bootloader update can be undone
+type bootFileBackup struct {
+	files map[string][]byte      // Original contents of files that existed
+	modes map[string]os.FileMode // Original permissions of files that existed
+	paths []string               // All backed up paths, including ones that did not exist
+}
+
+// backupBootFiles records the current contents of paths. Paths that do not
+// exist yet are removed again on restore.
+func backupBootFiles(paths ...string) (*bootFileBackup, error) {
+	b := &bootFileBackup{
+		files: map[string][]byte{},
+		modes: map[string]os.FileMode{},
+		paths: paths,
+	}
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to back up %s: %w", path, err)
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to back up %s: %w", path, err)
+		}
+		b.files[path] = data
+		b.modes[path] = info.Mode().Perm()
+	}
+	return b, nil
+}
+
+// restore puts every backed up file back as it was
+func (b *bootFileBackup) restore() error {
+	var errs []error
+	for _, path := range b.paths {
+		data, existed := b.files[path]
+		if !existed {
+			if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+				errs = append(errs, fmt.Errorf("failed to remove %s: %w", path, err))
+			}
+			continue
+		}
+		if err := writeFileAtomic(path, data, b.modes[path]); err != nil {
+			errs = append(errs, fmt.Errorf("failed to restore %s: %w", path, err))
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,75 @@
+package pkg
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriteFileAtomic(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "grub.cfg")
+	writeFakeFile(t, path, "old")
+
+	if err := writeFileAtomic(path, []byte("new"), 0600); err != nil {
+		t.Fatalf("writeFileAtomic() error = %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil || string(data) != "new" {
+		t.Errorf("contents = %q, %v; want %q", data, err, "new")
+	}
+	if info, _ := os.Stat(path); info.Mode().Perm() != 0600 {
+		t.Errorf("mode = %v, want 0600", info.Mode().Perm())
+	}
+
+	// No temporary files are left next to the target
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 1 {
+		t.Errorf("expected only grub.cfg in %s, found %d entries", dir, len(entries))
+	}
+}
+
+func TestBootFileBackupRestore(t *testing.T) {
+	dir := t.TempDir()
+	existing := filepath.Join(dir, "bootc.conf")
+	created := filepath.Join(dir, "bootc-previous.conf")
+	writeFakeFile(t, existing, "options root=UUID=old")
+
+	backup, err := backupBootFiles(existing, created)
+	if err != nil {
+		t.Fatalf("backupBootFiles() error = %v", err)
+	}
+
+	// Simulate an update that got halfway
+	writeFakeFile(t, existing, "options root=UUID=new")
+	writeFakeFile(t, created, "options root=UUID=old")
+
+	if err := backup.restore(); err != nil {
+		t.Fatalf("restore() error = %v", err)
+	}
+
+	if data, _ := os.ReadFile(existing); string(data) != "options root=UUID=old" {
+		t.Errorf("bootc.conf = %q, want original contents", data)
+	}
+	if _, err := os.Stat(created); !os.IsNotExist(err) {
+		t.Errorf("bootc-previous.conf should have been removed, stat error = %v", err)
+	}
+}
+
+func TestCancelled(t *testing.T) {
+	if err := cancelled(context.Background()); err != nil {
+		t.Errorf("cancelled() = %v for live context", err)
+	}
+
+	cause := errors.New("interrupted by terminated")
+	ctx, cancel := context.WithCancelCause(context.Background())
+	cancel(cause)
+
+	err := cancelled(ctx)
+	if !errors.Is(err, cause) {
+		t.Errorf("cancelled() = %v, want it to wrap the cancellation cause", err)
+	}
+}
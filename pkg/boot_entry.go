@@ -0,0 +1,110 @@
+package pkg
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// bootEntry is the kernel, initramfs and kernel command line of a boot
+// entry. Paths are relative to the boot partition.
+type bootEntry struct {
+	Kernel  string
+	Initrd  string
+	Options string
+}
+
+// rootUUID returns the root=UUID= or, for a verity-protected root,
+// systemd.verity_root_data=UUID= value of the entry's command line
+func (e *bootEntry) rootUUID() string {
+	for _, field := range strings.Fields(e.Options) {
+		if uuid, ok := strings.CutPrefix(field, "root=UUID="); ok {
+			return uuid
+		}
+		if uuid, ok := strings.CutPrefix(field, "systemd.verity_root_data=UUID="); ok {
+			return uuid
+		}
+	}
+	return ""
+}
+
+// readDefaultBootEntry reads the default entry written by UpdateBootloader in
+// the boot partition mounted at bootMount: the bootc.conf entry for
+// systemd-boot, or the first menu entry for GRUB
+func readDefaultBootEntry(bootMount string) (*bootEntry, error) {
+	candidates := []struct {
+		path  string
+		parse func(io.Reader) *bootEntry
+	}{
+		{filepath.Join(bootMount, "loader", "entries", "bootc.conf"), parseLoaderEntry},
+		{filepath.Join(bootMount, "grub", "grub.cfg"), parseFirstMenuEntry},
+		{filepath.Join(bootMount, "grub2", "grub.cfg"), parseFirstMenuEntry},
+	}
+	for _, c := range candidates {
+		f, err := os.Open(c.path)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return nil, fmt.Errorf("failed to read boot configuration: %w", err)
+		}
+		entry := c.parse(f)
+		_ = f.Close()
+		if entry != nil {
+			return entry, nil
+		}
+	}
+	return nil, fmt.Errorf("could not find the default entry in the boot configuration")
+}
+
+// parseLoaderEntry parses a systemd-boot entry file
+func parseLoaderEntry(r io.Reader) *bootEntry {
+	entry := &bootEntry{}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		key, value, _ := strings.Cut(strings.TrimSpace(scanner.Text()), " ")
+		value = strings.TrimSpace(value)
+		switch key {
+		case "linux":
+			entry.Kernel = value
+		case "initrd":
+			entry.Initrd = value
+		case "options":
+			entry.Options = value
+		}
+	}
+	if entry.Kernel == "" && entry.Options == "" {
+		return nil
+	}
+	return entry
+}
+
+// parseFirstMenuEntry parses the first menuentry of a GRUB configuration
+func parseFirstMenuEntry(r io.Reader) *bootEntry {
+	var entry *bootEntry
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if entry == nil {
+			if strings.HasPrefix(line, "menuentry ") {
+				entry = &bootEntry{}
+			}
+			continue
+		}
+		if line == "}" {
+			break
+		}
+		key, value, _ := strings.Cut(line, " ")
+		value = strings.TrimSpace(value)
+		switch key {
+		case "linux":
+			entry.Kernel, entry.Options, _ = strings.Cut(value, " ")
+			entry.Options = strings.TrimSpace(entry.Options)
+		case "initrd":
+			entry.Initrd = value
+		}
+	}
+	return entry
+}
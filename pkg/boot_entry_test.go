@@ -0,0 +1,46 @@
+package pkg
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestReadDefaultBootEntry(t *testing.T) {
+	tests := []struct {
+		name  string
+		files map[string]string
+		want  bootEntry
+	}{
+		{
+			name: "systemd-boot",
+			files: map[string]string{
+				"loader/entries/bootc.conf": "title   Fedora\nlinux   /vmlinuz-6.1\ninitrd  /initramfs-6.1.img\noptions root=UUID=aaaa rw quiet\n",
+			},
+			want: bootEntry{Kernel: "/vmlinuz-6.1", Initrd: "/initramfs-6.1.img", Options: "root=UUID=aaaa rw quiet"},
+		},
+		{
+			name: "grub first menu entry",
+			files: map[string]string{
+				"grub/grub.cfg": "set default=0\nmenuentry 'Fedora' {\n    linux /vmlinuz-6.2 root=UUID=cccc rw\n    initrd /initramfs-6.2.img\n}\n" +
+					"menuentry 'Fedora (Previous)' {\n    linux /vmlinuz-6.1 root=UUID=dddd rw\n    initrd /initramfs-6.1.img\n}\n",
+			},
+			want: bootEntry{Kernel: "/vmlinuz-6.2", Initrd: "/initramfs-6.2.img", Options: "root=UUID=cccc rw"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			for name, content := range tt.files {
+				writeFakeFile(t, filepath.Join(dir, name), content)
+			}
+
+			got, err := readDefaultBootEntry(dir)
+			if err != nil {
+				t.Fatalf("readDefaultBootEntry() error = %v", err)
+			}
+			if *got != tt.want {
+				t.Errorf("readDefaultBootEntry() = %+v, want %+v", *got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,78 @@
+package pkg
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Bootloader lockdown
+//
+// Kiosks and appliances are often left with a keyboard attached, and anyone
+// at the console can edit the kernel command line in the boot menu to get a
+// root shell (rd.break, init=/bin/sh). Lockdown turns the editor off: GRUB
+// gets a superuser, so that only it can edit entries or open the GRUB
+// command line while every entry still boots without a password, and
+// systemd-boot gets "editor no". Without a password hash the GRUB superuser
+// has no password and nobody can edit entries at all.
+
+// DefaultGRUBSuperuser is the GRUB superuser of a lockdown that names none
+const DefaultGRUBSuperuser = "root"
+
+// BootLockdown keeps console users from editing the kernel command line
+type BootLockdown struct {
+	GRUBSuperuser    string `json:"grub_superuser,omitempty"`     // User allowed to edit GRUB entries
+	GRUBPasswordHash string `json:"grub_password_hash,omitempty"` // grub.pbkdf2.sha512 hash of its password, empty for none
+}
+
+// grubUserName is the form of a GRUB user name, which is quoted in the
+// superusers variable
+var grubUserName = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9_.-]*$`)
+
+// grubPasswordHash is the form of the hash grub2-mkpasswd-pbkdf2 prints
+var grubPasswordHash = regexp.MustCompile(`^grub\.pbkdf2\.sha512\.[0-9]+\.[0-9A-Fa-f]+\.[0-9A-Fa-f]+$`)
+
+// NewBootLockdown returns a lockdown with the GRUB superuser user (root if
+// empty) and passwordHash, a hash printed by grub2-mkpasswd-pbkdf2
+func NewBootLockdown(user, passwordHash string) (*BootLockdown, error) {
+	if user == "" {
+		user = DefaultGRUBSuperuser
+	}
+	if !grubUserName.MatchString(user) {
+		return nil, fmt.Errorf("invalid GRUB superuser %q: use letters, digits, '.', '_' and '-'", user)
+	}
+	if passwordHash != "" && !grubPasswordHash.MatchString(passwordHash) {
+		return nil, fmt.Errorf("invalid GRUB password hash: expected grub.pbkdf2.sha512.... (see 'grub2-mkpasswd-pbkdf2')")
+	}
+	return &BootLockdown{GRUBSuperuser: user, GRUBPasswordHash: passwordHash}, nil
+}
+
+// grubMenuEntryStart matches the first line of a GRUB menu entry
+var grubMenuEntryStart = regexp.MustCompile(`(?m)^(menuentry .*) \{$`)
+
+// grubLockdownConfig returns the GRUB configuration cfg locked down by
+// lockdown: the superuser and its password first, and every menu entry
+// bootable without them. It returns cfg as is if lockdown is nil.
+func grubLockdownConfig(lockdown *BootLockdown, cfg string) string {
+	if lockdown == nil {
+		return cfg
+	}
+	user := lockdown.GRUBSuperuser
+	if user == "" {
+		user = DefaultGRUBSuperuser
+	}
+	var header strings.Builder
+	fmt.Fprintf(&header, "set superusers=\"%s\"\n", user)
+	if lockdown.GRUBPasswordHash != "" {
+		fmt.Fprintf(&header, "password_pbkdf2 %s %s\n", user, lockdown.GRUBPasswordHash)
+	}
+	return header.String() + grubMenuEntryStart.ReplaceAllString(cfg, "$1 --unrestricted {")
+}
+
+// loaderEditor returns the editor setting of loader.conf: no when locked down
+func loaderEditor(lockdown *BootLockdown) string {
+	if lockdown != nil {
+		return "no"
+	}
+	return "yes"
+}
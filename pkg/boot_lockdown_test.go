@@ -0,0 +1,85 @@
+package pkg
+
+import (
+	"strings"
+	"testing"
+)
+
+const testGRUBPasswordHash = "grub.pbkdf2.sha512.10000.0A1B2C.3D4E5F"
+
+func TestNewBootLockdown(t *testing.T) {
+	lockdown, err := NewBootLockdown("", testGRUBPasswordHash)
+	if err != nil {
+		t.Fatalf("NewBootLockdown() error = %v", err)
+	}
+	if lockdown.GRUBSuperuser != DefaultGRUBSuperuser || lockdown.GRUBPasswordHash != testGRUBPasswordHash {
+		t.Errorf("NewBootLockdown() = %+v, want root with the hash", lockdown)
+	}
+	if _, err := NewBootLockdown("admin", ""); err != nil {
+		t.Errorf("NewBootLockdown() without a hash error = %v", err)
+	}
+
+	for _, tt := range []struct{ user, hash string }{
+		{`ad"min`, ""},
+		{"admin root", ""},
+		{"root", "secret"},
+		{"root", "$6$salt$hash"},
+	} {
+		if _, err := NewBootLockdown(tt.user, tt.hash); err == nil {
+			t.Errorf("NewBootLockdown(%q, %q) should fail", tt.user, tt.hash)
+		}
+	}
+}
+
+func TestGRUBLockdownConfig(t *testing.T) {
+	cfg := `set timeout=5
+set default=0
+
+menuentry 'Fedora' {
+    linux /vmlinuz-6.8.0 root=UUID=aaaa rw
+    initrd /initramfs-6.8.0.img
+}
+
+menuentry 'Fedora (Previous)' {
+    linux /vmlinuz-6.8.0 root=UUID=bbbb rw
+    initrd /initramfs-6.8.0.img
+}
+`
+	if got := grubLockdownConfig(nil, cfg); got != cfg {
+		t.Errorf("grubLockdownConfig(nil) = %q, want it unchanged", got)
+	}
+
+	got := grubLockdownConfig(&BootLockdown{GRUBSuperuser: "admin", GRUBPasswordHash: testGRUBPasswordHash}, cfg)
+	want := `set superusers="admin"
+password_pbkdf2 admin ` + testGRUBPasswordHash + `
+set timeout=5
+set default=0
+
+menuentry 'Fedora' --unrestricted {
+    linux /vmlinuz-6.8.0 root=UUID=aaaa rw
+    initrd /initramfs-6.8.0.img
+}
+
+menuentry 'Fedora (Previous)' --unrestricted {
+    linux /vmlinuz-6.8.0 root=UUID=bbbb rw
+    initrd /initramfs-6.8.0.img
+}
+`
+	if got != want {
+		t.Errorf("grubLockdownConfig() = %q, want %q", got, want)
+	}
+
+	entry := parseFirstMenuEntry(strings.NewReader(got))
+	if entry == nil || entry.Options != "root=UUID=aaaa rw" {
+		t.Errorf("parseFirstMenuEntry() of a locked config = %+v", entry)
+	}
+}
+
+func TestLoaderEditor(t *testing.T) {
+	if got := loaderEditor(nil); got != "yes" {
+		t.Errorf("loaderEditor(nil) = %q, want yes", got)
+	}
+	if got := loaderEditor(&BootLockdown{}); got != "no" {
+		t.Errorf("loaderEditor() = %q, want no", got)
+	}
+}
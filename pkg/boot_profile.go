@@ -0,0 +1,123 @@
+package pkg
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// Boot profiles
+//
+// A boot profile is an extra boot entry for the default root whose command
+// line has more kernel arguments, such as a debug entry with "rd.break
+// enforcing=0 console=ttyS0" that gives field technicians a way into a system
+// that does not come up. Profiles are recorded in the system config and
+// generated after the main and previous entries on every install, update,
+// rollback and kernel argument change.
+
+// BootProfile is an extra boot entry for the default root
+type BootProfile struct {
+	Name       string   `json:"name"`        // Shown in the boot menu after the OS name
+	KernelArgs []string `json:"kernel_args"` // Added to the command line of the main entry
+}
+
+// bootProfileName is the form of a profile name, which is also part of the
+// name of its systemd-boot entry file
+var bootProfileName = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]*$`)
+
+// loaderProfileEntryPrefix starts the systemd-boot entry files of profiles
+const loaderProfileEntryPrefix = "bootc-profile-"
+
+// ParseBootProfile parses a name=arguments profile, such as
+// "debug=rd.break enforcing=0 console=ttyS0"
+func ParseBootProfile(spec string) (BootProfile, error) {
+	name, args, ok := strings.Cut(spec, "=")
+	if !ok {
+		return BootProfile{}, fmt.Errorf("invalid boot profile %q: expected name=arguments", spec)
+	}
+	return NewBootProfile(name, args)
+}
+
+// NewBootProfile returns the profile name with the space-separated kernel
+// arguments args
+func NewBootProfile(name, args string) (BootProfile, error) {
+	name = strings.TrimSpace(name)
+	if !bootProfileName.MatchString(name) || strings.EqualFold(name, "previous") {
+		return BootProfile{}, fmt.Errorf("invalid boot profile name %q: use letters, digits, '.', '_' and '-', other than \"previous\"", name)
+	}
+	profile := BootProfile{Name: name, KernelArgs: strings.Fields(args)}
+	if len(profile.KernelArgs) == 0 {
+		return BootProfile{}, fmt.Errorf("boot profile %s has no kernel arguments", name)
+	}
+	for _, arg := range profile.KernelArgs {
+		if err := checkKernelArg(arg); err != nil {
+			return BootProfile{}, fmt.Errorf("boot profile %s: %w", name, err)
+		}
+	}
+	return profile, nil
+}
+
+// title returns the boot menu title of the profile's entry
+func (p BootProfile) title(osName string) string {
+	return fmt.Sprintf("%s (%s)", osName, p.Name)
+}
+
+// cmdline returns the command line of the profile's entry for the main
+// entry's command line
+func (p BootProfile) cmdline(main []string) string {
+	return strings.Join(mergeKernelArgs(main, p.KernelArgs), " ")
+}
+
+// grubProfileEntries returns the GRUB menu entries of profiles
+func grubProfileEntries(profiles []BootProfile, osName, kernelVersion, initrd string, cmdline []string) string {
+	var entries strings.Builder
+	for _, p := range profiles {
+		fmt.Fprintf(&entries, `
+menuentry '%s' {
+    linux /vmlinuz-%s %s
+    initrd /%s
+}
+`, p.title(osName), kernelVersion, p.cmdline(cmdline), initrd)
+	}
+	return entries.String()
+}
+
+// loaderProfileEntries lists the profile entry files in the systemd-boot
+// entries directory
+func loaderProfileEntries(entriesDir string) []string {
+	files, _ := filepath.Glob(filepath.Join(entriesDir, loaderProfileEntryPrefix+"*.conf"))
+	return files
+}
+
+// loaderProfileEntryPath returns the path of the profile's systemd-boot entry
+func (p BootProfile) loaderProfileEntryPath(entriesDir string) string {
+	return filepath.Join(entriesDir, loaderProfileEntryPrefix+p.Name+".conf")
+}
+
+// writeLoaderProfileEntries writes the systemd-boot entries of profiles to
+// entriesDir and removes those of profiles that are gone
+func writeLoaderProfileEntries(entriesDir string, profiles []BootProfile, osName, kernelVersion, initrd string, cmdline []string) error {
+	keep := map[string]bool{}
+	for _, p := range profiles {
+		path := p.loaderProfileEntryPath(entriesDir)
+		keep[path] = true
+		entry := fmt.Sprintf(`title   %s
+linux   /vmlinuz-%s
+initrd  /%s
+options %s
+`, p.title(osName), kernelVersion, initrd, p.cmdline(cmdline))
+		if err := writeFileAtomic(path, []byte(entry), 0644); err != nil {
+			return fmt.Errorf("failed to write boot entry of profile %s: %w", p.Name, err)
+		}
+	}
+	for _, path := range loaderProfileEntries(entriesDir) {
+		if !keep[path] {
+			if err := os.Remove(path); err != nil {
+				return fmt.Errorf("failed to remove boot entry %s: %w", filepath.Base(path), err)
+			}
+		}
+	}
+	return nil
+}
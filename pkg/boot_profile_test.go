@@ -0,0 +1,72 @@
+package pkg
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseBootProfile(t *testing.T) {
+	profile, err := ParseBootProfile("debug=rd.break  enforcing=0 console=ttyS0")
+	if err != nil {
+		t.Fatalf("ParseBootProfile() error = %v", err)
+	}
+	want := BootProfile{Name: "debug", KernelArgs: []string{"rd.break", "enforcing=0", "console=ttyS0"}}
+	if !reflect.DeepEqual(profile, want) {
+		t.Errorf("ParseBootProfile() = %+v, want %+v", profile, want)
+	}
+
+	for _, spec := range []string{"debug", "=quiet", "debug=", "previous=quiet", "my profile=quiet", "../x=quiet", "debug=root=/dev/sda2"} {
+		if _, err := ParseBootProfile(spec); err == nil {
+			t.Errorf("ParseBootProfile(%q) should fail", spec)
+		}
+	}
+}
+
+func TestGRUBProfileEntries(t *testing.T) {
+	profiles := []BootProfile{{Name: "debug", KernelArgs: []string{"rd.break", "console=ttyS0"}}}
+	got := grubProfileEntries(profiles, "Fedora", "6.8.0", "initramfs-6.8.0.img", []string{"root=UUID=aaaa", "rw", "console=ttyS0"})
+	want := `
+menuentry 'Fedora (debug)' {
+    linux /vmlinuz-6.8.0 root=UUID=aaaa rw console=ttyS0 rd.break
+    initrd /initramfs-6.8.0.img
+}
+`
+	if got != want {
+		t.Errorf("grubProfileEntries() = %q, want %q", got, want)
+	}
+	if got := grubProfileEntries(nil, "Fedora", "6.8.0", "initrd", nil); got != "" {
+		t.Errorf("grubProfileEntries() without profiles = %q, want none", got)
+	}
+}
+
+func TestWriteLoaderProfileEntries(t *testing.T) {
+	dir := t.TempDir()
+	writeFakeFile(t, filepath.Join(dir, "bootc.conf"), "title main\n")
+	writeFakeFile(t, filepath.Join(dir, "bootc-profile-old.conf"), "title old\n")
+
+	profiles := []BootProfile{{Name: "debug", KernelArgs: []string{"rd.break"}}}
+	if err := writeLoaderProfileEntries(dir, profiles, "Fedora", "6.8.0", "initramfs-6.8.0.img", []string{"root=UUID=aaaa", "rw"}); err != nil {
+		t.Fatalf("writeLoaderProfileEntries() error = %v", err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, "bootc-profile-debug.conf"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry := parseLoaderEntry(strings.NewReader(string(data)))
+	if entry == nil || entry.Options != "root=UUID=aaaa rw rd.break" || entry.Kernel != "/vmlinuz-6.8.0" {
+		t.Errorf("profile entry = %+v", entry)
+	}
+	if !strings.Contains(string(data), "title   Fedora (debug)\n") {
+		t.Errorf("profile entry has no title:\n%s", data)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "bootc-profile-old.conf")); !os.IsNotExist(err) {
+		t.Error("entry of a removed profile was kept")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "bootc.conf")); err != nil {
+		t.Errorf("main entry was removed: %v", err)
+	}
+}
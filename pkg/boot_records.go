@@ -0,0 +1,51 @@
+package pkg
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Some boot entry arguments, such as a dm-verity root hash, cannot be read
+// back from the root they describe. Install and update record them per root
+// in a JSON file on the boot partition, keyed by root filesystem UUID, so
+// later updates and rollbacks can rebuild both boot entries.
+
+// readRootRecords returns the records in file, relative to the boot
+// partition mounted at bootMount, keyed by root filesystem UUID
+func readRootRecords[T any](bootMount, file string) (map[string]T, error) {
+	data, err := os.ReadFile(filepath.Join(bootMount, file))
+	if os.IsNotExist(err) {
+		return map[string]T{}, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", file, err)
+	}
+	records := map[string]T{}
+	if err := json.Unmarshal(data, &records); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", file, err)
+	}
+	return records, nil
+}
+
+// recordRootRecord records v for the root with filesystem UUID rootUUID in
+// file, relative to the boot partition mounted at bootMount
+func recordRootRecord[T any](bootMount, file, rootUUID string, v T) error {
+	records, err := readRootRecords[T](bootMount, file)
+	if err != nil {
+		return err
+	}
+	records[rootUUID] = v
+	path := filepath.Join(bootMount, file)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create %s: %w", filepath.Dir(path), err)
+	}
+	data, err := json.MarshalIndent(records, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal %s: %w", file, err)
+	}
+	if err := writeFileAtomic(path, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", file, err)
+	}
+	return nil
+}
@@ -0,0 +1,34 @@
+package pkg
+
+import "testing"
+
+func TestRootRecords(t *testing.T) {
+	boot := t.TempDir()
+
+	roots, err := readRootRecords[*VerityRoot](boot, VerityRootsFile)
+	if err != nil {
+		t.Fatalf("readRootRecords() error = %v", err)
+	}
+	if len(roots) != 0 {
+		t.Fatalf("readRootRecords() = %v, want no roots", roots)
+	}
+
+	if err := recordRootRecord(boot, VerityRootsFile, "aaaa", &VerityRoot{RootHash: "11", HashUUID: "h1"}); err != nil {
+		t.Fatalf("recordRootRecord() error = %v", err)
+	}
+	if err := recordRootRecord(boot, VerityRootsFile, "bbbb", &VerityRoot{RootHash: "22", HashUUID: "h2"}); err != nil {
+		t.Fatalf("recordRootRecord() error = %v", err)
+	}
+	// A new record for a root replaces the old one
+	if err := recordRootRecord(boot, VerityRootsFile, "aaaa", &VerityRoot{RootHash: "33", HashUUID: "h3"}); err != nil {
+		t.Fatalf("recordRootRecord() error = %v", err)
+	}
+
+	roots, err = readRootRecords[*VerityRoot](boot, VerityRootsFile)
+	if err != nil {
+		t.Fatalf("readRootRecords() error = %v", err)
+	}
+	if len(roots) != 2 || roots["aaaa"].RootHash != "33" || roots["bbbb"].HashUUID != "h2" {
+		t.Errorf("readRootRecords() = %+v", roots)
+	}
+}
@@ -1,26 +1,58 @@
 package pkg
 
 import (
+	"context"
 	"fmt"
 	"os"
-	"os/exec"
+	"path/filepath"
+	"slices"
 	"strings"
 	"time"
-
-	"github.com/google/go-containerregistry/pkg/authn"
-	"github.com/google/go-containerregistry/pkg/name"
-	"github.com/google/go-containerregistry/pkg/v1/remote"
 )
 
+// MinimumDiskSize is the smallest disk phukit installs to
+const MinimumDiskSize = uint64(10 * 1024 * 1024 * 1024) // 10 GB
+
 // BootcInstaller handles bootc container installation
 type BootcInstaller struct {
-	ImageRef       string
-	Device         string
-	Verbose        bool
-	DryRun         bool
-	KernelArgs     []string
-	MountPoint     string
-	FilesystemType string // ext4 or btrfs
+	ImageRef         string
+	Device           string
+	Verbose          bool
+	DryRun           bool
+	KernelArgs       []string
+	MountPoint       string
+	FilesystemType   string          // ext4 or btrfs
+	ForceUnmount     bool            // Unmount/deactivate anything using the disk before wiping
+	PinImage         bool            // Pin updates to the installed digest
+	PinPolicy        string          // Pin policy recorded with the pin (warn, fail)
+	AssumeYes        bool            // Skip the confirmation prompt
+	ConfirmDevice    bool            // Have the device path typed to confirm, instead of "yes"
+	Verity           bool            // Seal the root read-only with dm-verity
+	Composefs        bool            // Store /usr in the shared composefs object store
+	WritableUsr      bool            // Leave /usr writable instead of mounting it read-only
+	GrowVar          bool            // Grow /var into the rest of the disk on boot
+	MountOptions     MountOptions    // Mount options of the root and /var
+	MountIdentifier  MountIdentifier // How generated mounts name filesystems ("" for UUID)
+	VarMount         VarMount        // Where /var is mounted from ("" for the kernel command line)
+	BootProfiles     []BootProfile   // Extra boot entries with more kernel arguments
+	SerialConsole    *SerialConsole  // Serial port the kernel and bootloader print to, nil for none
+	Lockdown         *BootLockdown   // Keep console users from editing the kernel command line
+	WipeMode         WipeMode        // How the disk is wiped before partitioning ("" for signatures only)
+	SwapSize         uint64          // Size of the swap partition in bytes, 0 for none
+	VolumeGroup      string          // Put the roots, /var and swap on logical volumes of this volume group
+	PreserveData     bool            // Keep the partition table and the /var and swap partitions
+	ReusePartitions  bool            // Install into the existing partition table instead of wiping the disk
+	PartitionMap     []string        // Partitions to reuse as role=partition, instead of those phukit named
+	EnableUnits      []string        // systemd units of the image to enable, such as cloud agents
+	Provisioning     *Provisioning   // Users, network connections and hostname to set up
+	Firstboot        bool            // Install the first-boot provisioning service
+	FirstbootScripts []string        // Scripts for the first-boot service to run
+	Executor         Executor        // Runs the external commands of the install (see SetExecutor)
+
+	layout      *PartitionScheme // Caller-provided partitions, recorded for updates
+	keep        keptPartitions   // Existing partitions the install does not format
+	takeover    bool             // Carry /etc and /var over from the running system
+	verifiedRef string           // Image digest the image policy verified (set by checkImagePolicy)
 }
 
 // NewBootcInstaller creates a new BootcInstaller
@@ -49,6 +81,22 @@ func (b *BootcInstaller) AddKernelArg(arg string) {
 	b.KernelArgs = append(b.KernelArgs, arg)
 }
 
+// SetExecutor has e run the external commands of the install, such as mkfs
+// and grub-install. By default they run on the host, and in dry-run mode a
+// DryRunExecutor only records them.
+func (b *BootcInstaller) SetExecutor(e Executor) {
+	b.Executor = e
+}
+
+// runCommands has the executor of the install run the external commands
+// until the returned function is called
+func (b *BootcInstaller) runCommands() (restore func()) {
+	if b.Executor == nil && b.DryRun {
+		b.Executor = &DryRunExecutor{}
+	}
+	return useExecutor(b.Executor)
+}
+
 // SetMountPoint sets the temporary mount point for installation
 func (b *BootcInstaller) SetMountPoint(mountPoint string) {
 	b.MountPoint = mountPoint
@@ -59,21 +107,250 @@ func (b *BootcInstaller) SetFilesystemType(fsType string) {
 	b.FilesystemType = fsType
 }
 
+// SetForceUnmount enables releasing mounts, swap and device-mapper holders on the disk before wiping
+func (b *BootcInstaller) SetForceUnmount(force bool) {
+	b.ForceUnmount = force
+}
+
+// SetPinImage pins future updates to the digest being installed, with policy
+// deciding what update does when the tag moves
+func (b *BootcInstaller) SetPinImage(pin bool, policy string) {
+	b.PinImage = pin
+	b.PinPolicy = policy
+}
+
+// SetAssumeYes skips the confirmation prompt, for callers that cannot answer it
+func (b *BootcInstaller) SetAssumeYes(yes bool) {
+	b.AssumeYes = yes
+}
+
+// SetConfirmDevice has the confirmation prompt ask for the device path
+// instead of "yes", as it does for disks of TypedConfirmationSize or more
+func (b *BootcInstaller) SetConfirmDevice(typed bool) {
+	b.ConfirmDevice = typed
+}
+
+// SetVerity installs a read-only root protected by dm-verity, with its hash
+// tree on a companion partition and the root hash on the kernel command line
+func (b *BootcInstaller) SetVerity(verity bool) {
+	b.Verity = verity
+}
+
+// SetComposefs installs /usr as a composefs image backed by an object store
+// on the shared /var partition, deduplicating files between the root slots
+func (b *BootcInstaller) SetComposefs(composefs bool) {
+	b.Composefs = composefs
+}
+
+// SetWritableUsr leaves /usr writable on the installed system. By default it
+// is mounted read-only so the root keeps matching its image.
+func (b *BootcInstaller) SetWritableUsr(writable bool) {
+	b.WritableUsr = writable
+}
+
+// SetMountOptions sets the mount options of the root and /var filesystems
+// of the installed system
+func (b *BootcInstaller) SetMountOptions(options MountOptions) {
+	b.MountOptions = options
+}
+
+// SetMountStyle sets how generated mounts name filesystems and where /var
+// is mounted from
+func (b *BootcInstaller) SetMountStyle(id MountIdentifier, varMount VarMount) {
+	b.MountIdentifier = id
+	b.VarMount = varMount
+}
+
+// SetBootProfiles sets the extra boot entries of the installed root
+func (b *BootcInstaller) SetBootProfiles(profiles []BootProfile) {
+	b.BootProfiles = profiles
+}
+
+// SetSerialConsole has the kernel and bootloader of the installed system
+// print to the serial port console as well as the screen
+func (b *BootcInstaller) SetSerialConsole(console *SerialConsole) {
+	b.SerialConsole = console
+	b.KernelArgs = mergeKernelArgs(b.KernelArgs, console.KernelArgs())
+}
+
+// SetLockdown keeps console users from editing the kernel command line in
+// the boot menu of the installed system
+func (b *BootcInstaller) SetLockdown(lockdown *BootLockdown) {
+	b.Lockdown = lockdown
+}
+
+// SetWipeMode sets how the disk is wiped before it is partitioned
+func (b *BootcInstaller) SetWipeMode(mode WipeMode) {
+	b.WipeMode = mode
+}
+
+// SetGrowVar makes the installed system grow /var and its filesystem into
+// the rest of the disk on boot, for images written to larger disks
+func (b *BootcInstaller) SetGrowVar(grow bool) {
+	b.GrowVar = grow
+}
+
+// SetSwapSize adds a swap partition of size bytes, used to hibernate if it
+// can hold all of RAM
+func (b *BootcInstaller) SetSwapSize(size uint64) {
+	b.SwapSize = size
+}
+
+// SetVolumeGroup installs the roots, /var and swap as logical volumes of
+// the LVM volume group vg, which can be resized and snapshotted later. ""
+// keeps plain partitions.
+func (b *BootcInstaller) SetVolumeGroup(vg string) {
+	b.VolumeGroup = vg
+}
+
+// SetPreserveData reinstalls into the partitions of an existing install,
+// formatting only the boot and root partitions and keeping /var and swap
+func (b *BootcInstaller) SetPreserveData(preserve bool) {
+	b.PreserveData = preserve
+}
+
+// SetReusePartitions installs into the existing partition table without
+// wiping the disk: into the partitions phukit created before, or, if mapping
+// is given, into the partitions it maps to roles as role=partition
+func (b *BootcInstaller) SetReusePartitions(reuse bool, mapping []string) {
+	b.ReusePartitions = reuse
+	b.PartitionMap = mapping
+}
+
+// ownsDisk reports whether the install writes the whole disk, rather than
+// into existing partitions
+func (b *BootcInstaller) ownsDisk() bool {
+	return !b.PreserveData && !b.ReusePartitions
+}
+
+// discard reports whether the target disk supports discard, so the install
+// discards the blocks it formats and the installed system trims periodically
+func (b *BootcInstaller) discard() bool {
+	return supportsDiscard(b.Device)
+}
+
+// existingPartitions finds the existing partitions to install into and
+// checks that the new install can use them
+func (b *BootcInstaller) existingPartitions() (*PartitionScheme, error) {
+	var scheme *PartitionScheme
+	var swapSize uint64
+	var err error
+	if len(b.PartitionMap) > 0 {
+		if b.Verity {
+			return nil, fmt.Errorf("a dm-verity root needs the hash partitions phukit creates; it is not supported with mapped partitions")
+		}
+		if scheme, swapSize, err = MappedPartitionScheme(b.Device, b.PartitionMap); err != nil {
+			return nil, err
+		}
+	} else if scheme, swapSize, err = NamedPartitionScheme(b.Device, b.Verity); err != nil {
+		return nil, err
+	}
+
+	b.keep = keptPartitions{data: b.PreserveData}
+	if b.keep.data {
+		// /var is mounted with the filesystem type of the roots
+		fsType, err := readFilesystemType(scheme.VarPartition)
+		if err != nil {
+			return nil, fmt.Errorf("%w: the /var partition %s cannot be kept: %v", ErrPartitionSchemeMismatch, scheme.VarPartition, err)
+		}
+		if fsType != b.FilesystemType {
+			return nil, fmt.Errorf("the /var partition to keep is %s; reinstall with --filesystem %s", fsType, fsType)
+		}
+	}
+	if len(b.PartitionMap) > 0 {
+		// An EFI system partition from another system is shared, not formatted
+		if fsType, err := readFilesystemType(scheme.BootPartition); err == nil && fsType == "vfat" {
+			b.keep.boot = true
+		}
+		// Mapped partitions cannot be found by number, so updates read them
+		// from the system config
+		b.layout = scheme
+	}
+	b.SwapSize = swapSize
+	return scheme, nil
+}
+
+// AddEnabledUnit enables a systemd unit of the image, such as a cloud agent,
+// on the installed system
+func (b *BootcInstaller) AddEnabledUnit(unit string) {
+	b.EnableUnits = append(b.EnableUnits, unit)
+}
+
+// SetProvisioning sets up users, network connections and the hostname on the
+// installed system
+func (b *BootcInstaller) SetProvisioning(p *Provisioning) {
+	b.Provisioning = p
+}
+
+// SetFirstboot installs phukit-firstboot.service, which runs once on the
+// first boot to grow /var, commit the machine ID, generate SSH host keys and
+// run scripts, which are copied to /etc/phukit/firstboot.d
+func (b *BootcInstaller) SetFirstboot(enable bool, scripts []string) {
+	b.Firstboot = enable || len(scripts) > 0
+	b.FirstbootScripts = scripts
+}
+
+// checkRootOptions checks that the requested dm-verity or composefs root can
+// be installed
+func (b *BootcInstaller) checkRootOptions() error {
+	if b.Verity && b.Composefs {
+		return fmt.Errorf("a root cannot use both dm-verity and composefs")
+	}
+	if b.Composefs && !b.VarMount.fromCmdline() {
+		return fmt.Errorf("a composefs root needs /var in the initramfs and cannot mount it from %s", b.VarMount)
+	}
+	if b.Verity && b.GrowVar {
+		return fmt.Errorf("/var cannot grow with a dm-verity root, as the hash partitions follow it")
+	}
+	if b.SwapSize > 0 && b.GrowVar {
+		return fmt.Errorf("/var cannot grow with a swap partition, as the swap partition follows it")
+	}
+	if b.GrowVar && !b.ownsDisk() {
+		return fmt.Errorf("/var cannot grow into the rest of the disk when installing into existing partitions")
+	}
+	if b.VolumeGroup != "" {
+		if err := checkVolumeGroupName(b.VolumeGroup); err != nil {
+			return err
+		}
+		if b.Verity {
+			return fmt.Errorf("a dm-verity root is not supported with the LVM layout")
+		}
+		if b.GrowVar {
+			return fmt.Errorf("/var cannot grow into the rest of the disk with the LVM layout; use lvextend")
+		}
+		if !b.ownsDisk() {
+			return fmt.Errorf("the LVM layout needs a new partition table and cannot reuse existing partitions")
+		}
+		if err := CheckLVMTools(); err != nil {
+			return fmt.Errorf("missing required tools: %w", err)
+		}
+	}
+	if b.Verity {
+		if b.FilesystemType != "ext4" {
+			return fmt.Errorf("a dm-verity root requires ext4, not %s", b.FilesystemType)
+		}
+		if err := CheckVerityTools(); err != nil {
+			return fmt.Errorf("missing required tools: %w", err)
+		}
+	}
+	if b.Composefs {
+		if err := CheckComposefsTools(); err != nil {
+			return fmt.Errorf("missing required tools: %w", err)
+		}
+	}
+	return nil
+}
+
 // CheckRequiredTools checks if required tools are available
 func CheckRequiredTools() error {
 	tools := []string{
-		"sgdisk",
 		"mkfs.vfat",
 		"mkfs.ext4",
-		"mount",
-		"umount",
-		"blkid",
-		"partprobe",
 	}
 
 	for _, tool := range tools {
-		if _, err := exec.LookPath(tool); err != nil {
-			return fmt.Errorf("%s not found: %w", tool, err)
+		if _, err := executor.LookPath(tool); err != nil {
+			return fmt.Errorf("%w: %s: %w", ErrMissingTool, tool, err)
 		}
 	}
 
@@ -81,71 +358,104 @@ func CheckRequiredTools() error {
 }
 
 // PullImage validates the image reference and checks if it's accessible
-// The actual image pull happens during Extract() to avoid duplicate work
-func (b *BootcInstaller) PullImage() error {
+// With the builtin source the actual image pull happens during Extract() to avoid duplicate work
+func (b *BootcInstaller) PullImage(ctx context.Context) error {
 	if b.DryRun {
 		fmt.Printf("[DRY RUN] Would pull image: %s\n", b.ImageRef)
 		return nil
 	}
 
 	fmt.Printf("Validating image reference: %s\n", b.ImageRef)
+	return pullImage(ctx, b.imageSource(), b.Verbose)
+}
 
-	// Parse and validate the image reference
-	ref, err := name.ParseReference(b.ImageRef)
+// checkImagePolicy evaluates the image policy before the disk is touched and
+// holds the install to the digest whose signatures it verified; extraction
+// checks again
+func (b *BootcInstaller) checkImagePolicy(ctx context.Context) error {
+	imageRef, err := CheckImagePolicy(ctx, b.ImageRef)
 	if err != nil {
-		return fmt.Errorf("invalid image reference: %w", err)
-	}
-
-	if b.Verbose {
-		fmt.Printf("  Image: %s\n", ref.String())
+		return err
 	}
+	b.verifiedRef = imageRef
+	return nil
+}
 
-	// Try to get image descriptor to verify it exists and is accessible
-	// This is a lightweight check that doesn't download layers
-	_, err = remote.Head(ref, remote.WithAuthFromKeychain(authn.DefaultKeychain))
-	if err != nil {
-		return fmt.Errorf("failed to access image: %w (check credentials if private registry)", err)
+// imageSource returns the reference to pull and extract: the digest the image
+// policy verified, or ImageRef
+func (b *BootcInstaller) imageSource() string {
+	if b.verifiedRef != "" {
+		return b.verifiedRef
 	}
-
-	fmt.Println("  Image reference is valid and accessible")
-	return nil
+	return b.ImageRef
 }
 
 // Install performs the bootc installation to the target disk
-func (b *BootcInstaller) Install() error {
+func (b *BootcInstaller) Install(ctx context.Context) (err error) {
+	defer b.runCommands()()
 	if b.DryRun {
 		fmt.Printf("[DRY RUN] Would install %s to %s\n", b.ImageRef, b.Device)
 		if len(b.KernelArgs) > 0 {
 			fmt.Printf("[DRY RUN] With kernel arguments: %s\n", strings.Join(b.KernelArgs, " "))
 		}
+		if tracing() {
+			return b.traceInstall(ctx)
+		}
 		return nil
 	}
 
+	defer withLogAttrs("image", b.ImageRef, "target", b.Device)()
+	recordResult(func(r *OperationResult) {
+		r.ImageRef, r.Device = b.ImageRef, b.Device
+	})
+
 	fmt.Printf("Installing bootc image to disk...\n")
 	fmt.Printf("  Image:      %s\n", b.ImageRef)
 	fmt.Printf("  Device:     %s\n", b.Device)
 	fmt.Printf("  Filesystem: %s\n", b.FilesystemType)
+	if b.Verity {
+		fmt.Println("  Root:       read-only, dm-verity")
+	}
+	if b.Composefs {
+		fmt.Println("  /usr:       composefs")
+	}
 	fmt.Println()
 
-	// Step 1: Create partitions
-	fmt.Println("Step 1/6: Creating partitions...")
-	scheme, err := CreatePartitions(b.Device, b.DryRun)
-	if err != nil {
-		return fmt.Errorf("failed to create partitions: %w", err)
+	// Step 1: Create partitions, or find the existing ones
+	var scheme *PartitionScheme
+	if !b.ownsDisk() {
+		printStep("Step 1/6: Finding existing partitions...")
+		if scheme, err = b.existingPartitions(); err != nil {
+			return err
+		}
+	} else if b.VolumeGroup != "" {
+		printStep("Step 1/6: Creating partitions and logical volumes...")
+		if scheme, err = CreateLVMPartitions(ctx, b.Device, b.VolumeGroup, b.SwapSize, b.DryRun); err != nil {
+			return fmt.Errorf("failed to create partitions: %w", err)
+		}
+	} else {
+		printStep("Step 1/6: Creating partitions...")
+		if scheme, err = CreatePartitions(ctx, b.Device, b.Verity, b.SwapSize, b.DryRun); err != nil {
+			return fmt.Errorf("failed to create partitions: %w", err)
+		}
 	}
 
 	// Set filesystem type on partition scheme
 	scheme.FilesystemType = b.FilesystemType
+	recordResult(func(r *OperationResult) {
+		r.Partitions = scheme
+		r.TargetSlot, r.TargetPartition = "root1", scheme.Root1Partition
+	})
 
 	// Step 2: Format partitions
-	fmt.Println("\nStep 2/6: Formatting partitions...")
-	if err := FormatPartitions(scheme, b.DryRun); err != nil {
+	printStep("\nStep 2/6: Formatting partitions...")
+	if err := formatPartitions(ctx, scheme, b.keep, b.discard(), b.DryRun); err != nil {
 		return fmt.Errorf("failed to format partitions: %w", err)
 	}
 
-	// Step 3: Mount partitions
-	fmt.Println("\nStep 3/6: Mounting partitions...")
-	if err := MountPartitions(scheme, b.MountPoint, b.DryRun); err != nil {
+	// Step 3: Mount partitions; a kept /var is mounted after extraction
+	printStep("\nStep 3/6: Mounting partitions...")
+	if err := mountPartitions(scheme, b.MountPoint, !b.keep.data); err != nil {
 		return fmt.Errorf("failed to mount partitions: %w", err)
 	}
 
@@ -153,32 +463,112 @@ func (b *BootcInstaller) Install() error {
 	defer func() {
 		if !b.DryRun {
 			fmt.Println("\nCleaning up...")
-			_ = UnmountPartitions(b.MountPoint, b.DryRun)
+			if uerr := UnmountPartitions(b.MountPoint, b.DryRun); uerr != nil && err == nil {
+				err = fmt.Errorf("failed to unmount partitions: %w", uerr)
+			}
 			_ = os.RemoveAll(b.MountPoint)
+
+			// In strict mode, or when interrupted, never leave a half-installed,
+			// possibly bootable disk behind, unless it holds partitions of others
+			if err != nil && b.ownsDisk() && (StrictMode() || ctx.Err() != nil) {
+				fmt.Printf("Wiping partition table on %s after incomplete installation\n", b.Device)
+				if scheme.VolumeGroup != "" {
+					if verr := deactivateVolumeGroup(context.WithoutCancel(ctx), scheme.VolumeGroup); verr != nil {
+						fmt.Fprintf(os.Stderr, "Error: %v\n", verr)
+					}
+				}
+				if zerr := zapGPT(b.Device); zerr != nil {
+					fmt.Fprintf(os.Stderr, "Error: failed to wipe %s: %v\n", b.Device, zerr)
+				}
+			}
 		}
 	}()
 
-	// Step 4: Extract container filesystem
-	fmt.Println("\nStep 4/6: Extracting container filesystem...")
-	extractor := NewContainerExtractor(b.ImageRef, b.MountPoint)
+	if err := cancelled(ctx); err != nil {
+		return err
+	}
+
+	return b.installRoot(ctx, scheme, 4, 6)
+}
+
+// installRoot extracts the image into the partitions of scheme mounted at
+// b.MountPoint, configures it and installs the bootloader, numbering its
+// three steps from step of total
+func (b *BootcInstaller) installRoot(ctx context.Context, scheme *PartitionScheme, step, total int) (err error) {
+	// Extract container filesystem
+	printStep(fmt.Sprintf("\nStep %d/%d: Extracting container filesystem...", step, total))
+	extractor := NewContainerExtractor(b.imageSource(), b.MountPoint)
 	extractor.SetVerbose(b.Verbose)
-	if err := extractor.Extract(); err != nil {
+	if err := extractor.Extract(ctx); err != nil {
 		return fmt.Errorf("failed to extract container: %w", err)
 	}
+	recordResult(func(r *OperationResult) { r.Extract = extractor.Stats })
+	recordKernelVersion(b.MountPoint)
+
+	// A kept /var is mounted only now, so the image's /var does not
+	// overwrite the data on it
+	if b.keep.data {
+		if err := mountDevice(scheme.VarPartition, filepath.Join(b.MountPoint, "var"), false); err != nil {
+			return fmt.Errorf("failed to mount var partition: %w", err)
+		}
+	}
 
-	// Step 5: Configure system
-	fmt.Println("\nStep 5/6: Configuring system...")
+	if err := cancelled(ctx); err != nil {
+		return err
+	}
+
+	// Configure system
+	printStep(fmt.Sprintf("\nStep %d/%d: Configuring system...", step+1, total))
+
+	// The old fstab is replaced below, as it mounts the old root
+	if b.takeover {
+		if err := migrateRunningSystem(b.MountPoint); err != nil {
+			return err
+		}
+	}
 
 	// Create fstab
-	if err := CreateFstab(b.MountPoint, scheme); err != nil {
+	if err := CreateFstab(b.MountPoint, scheme, b.MountOptions, b.MountIdentifier, b.VarMount); err != nil {
 		return fmt.Errorf("failed to create fstab: %w", err)
 	}
+	luksVolumes := SchemeLUKSVolumes(scheme)
+	if len(luksVolumes) > 0 {
+		if err := writeCrypttab(b.MountPoint, luksVolumes, b.discard()); err != nil {
+			return err
+		}
+	}
 
 	// Setup system directories
 	if err := SetupSystemDirectories(b.MountPoint); err != nil {
 		return fmt.Errorf("failed to setup directories: %w", err)
 	}
 
+	// Run depmod, systemd-sysusers and systemd-tmpfiles in the new root
+	if err := ConfigureTarget(ctx, b.MountPoint, b.Verbose, b.DryRun); err != nil {
+		return fmt.Errorf("failed to configure target: %w", err)
+	}
+	if err := enableUnits(ctx, b.MountPoint, b.EnableUnits); err != nil {
+		return err
+	}
+	if b.discard() {
+		if err := enableFstrimTimer(ctx, b.MountPoint); err != nil {
+			return err
+		}
+	}
+	if b.GrowVar {
+		if err := writeGrowVarConfig(b.MountPoint); err != nil {
+			return err
+		}
+	}
+	if err := b.Provisioning.apply(ctx, b.MountPoint, b.DryRun); err != nil {
+		return err
+	}
+	if b.Firstboot {
+		if err := writeFirstbootService(b.MountPoint, b.FirstbootScripts); err != nil {
+			return err
+		}
+	}
+
 	// Setup /etc persistence (verifies /etc and creates backup in /var/etc.backup)
 	// Note: /etc stays on the root filesystem for reliable boot
 	if err := InstallEtcMountUnit(b.MountPoint, b.DryRun); err != nil {
@@ -190,43 +580,184 @@ func (b *BootcInstaller) Install() error {
 		return fmt.Errorf("failed to save pristine /etc: %w", err)
 	}
 
+	// A swap partition that holds all of RAM is used to hibernate
+	var resumeUUID string
+	if scheme.SwapPartition != "" {
+		hibernate, err := swapCanHibernate(b.SwapSize)
+		if err != nil {
+			return err
+		}
+		if hibernate {
+			if resumeUUID, err = GetPartitionUUID(scheme.SwapPartition); err != nil {
+				return fmt.Errorf("failed to get swap UUID: %w", err)
+			}
+			fmt.Printf("  Hibernation enabled, resuming from %s\n", scheme.SwapPartition)
+			b.KernelArgs = append(b.KernelArgs, resumeKernelArgs(resumeUUID)...)
+		}
+	}
+
+	// Logical volumes are activated by the initramfs
+	if scheme.VolumeGroup != "" {
+		b.KernelArgs = append(b.KernelArgs, lvmKernelArgs(scheme.VolumeGroup)...)
+	}
+
+	// LUKS containers are opened by the initramfs
+	if len(luksVolumes) > 0 {
+		fmt.Printf("  Encrypted partitions detected, opening %d LUKS container(s) on boot\n", len(luksVolumes))
+		b.KernelArgs = append(b.KernelArgs, luksKernelArgs(luksVolumes)...)
+	}
+
+	// Network block devices (iSCSI, NVMe-oF) need SAN login details on the kernel
+	// command line and the host's initiator identity inside the initramfs
+	netrootArgs, err := NetworkRootKernelArgs(b.Device)
+	if err != nil {
+		return fmt.Errorf("failed to determine network root configuration: %w", err)
+	}
+	if len(netrootArgs) > 0 {
+		fmt.Printf("  Network root detected, adding kernel arguments: %s\n", strings.Join(netrootArgs, " "))
+		b.KernelArgs = append(b.KernelArgs, netrootArgs...)
+		if err := CopyNetrootHostConfig(b.MountPoint, b.DryRun); err != nil {
+			return fmt.Errorf("failed to copy network root host configuration: %w", err)
+		}
+	}
+
+	// A multipath root disk is assembled by the initramfs with the host's
+	// multipath configuration
+	storageFeatures := DetectStorageFeatures(b.Device)
+	if slices.Contains(storageFeatures, StorageMultipath) {
+		if err := CopyMultipathHostConfig(b.MountPoint, b.DryRun); err != nil {
+			return fmt.Errorf("failed to copy multipath configuration: %w", err)
+		}
+	}
+
+	// Generate an initramfs for kernels the image ships without one
+	if err := GenerateMissingInitramfs(ctx, b.MountPoint, b.Device, b.Verbose, b.DryRun); err != nil {
+		return fmt.Errorf("failed to generate initramfs: %w", err)
+	}
+
+	// Rebuild initramfs if the target disk needs storage drivers the generic initrd
+	// lacks, resume support to hibernate, or to open encrypted partitions
+	if resumeUUID != "" {
+		storageFeatures = append(storageFeatures, StorageResume)
+	}
+	if scheme.VolumeGroup != "" {
+		storageFeatures = append(storageFeatures, StorageLVM)
+	}
+	if len(luksVolumes) > 0 {
+		storageFeatures = append(storageFeatures, StorageCrypt)
+	}
+	if len(storageFeatures) > 0 {
+		if err := RebuildInitramfsForStorage(ctx, b.MountPoint, storageFeatures, b.Verbose, b.DryRun); err != nil {
+			return fmt.Errorf("failed to rebuild initramfs: %w", err)
+		}
+	}
+
 	// Get image digest for tracking updates
-	imageDigest, err := GetRemoteImageDigest(b.ImageRef)
+	imageDigest, err := GetRemoteImageDigest(ctx, b.ImageRef)
 	if err != nil {
-		fmt.Printf("  Warning: could not get image digest: %v\n", err)
+		if werr := warnf("  could not get image digest: %v", err); werr != nil {
+			return werr
+		}
 		imageDigest = "" // Continue without digest
 	} else if b.Verbose {
 		fmt.Printf("  Image digest: %s\n", imageDigest)
 	}
+	recordResult(func(r *OperationResult) { r.ImageDigest = imageDigest })
 
 	// Write system configuration
 	config := &SystemConfig{
-		ImageRef:       b.ImageRef,
-		ImageDigest:    imageDigest,
-		Device:         b.Device,
-		InstallDate:    time.Now().Format(time.RFC3339),
-		KernelArgs:     b.KernelArgs,
-		BootloaderType: string(DetectBootloader(b.MountPoint)),
-		FilesystemType: b.FilesystemType,
+		ImageRef:        b.ImageRef,
+		ImageDigest:     imageDigest,
+		Device:          b.Device,
+		InstallDate:     time.Now().Format(time.RFC3339),
+		KernelArgs:      UserKernelArgs(b.KernelArgs),
+		BootloaderType:  string(DetectBootloader(b.MountPoint)),
+		FilesystemType:  b.FilesystemType,
+		Verity:          b.Verity,
+		Composefs:       b.Composefs,
+		WritableUsr:     b.WritableUsr,
+		ResumeUUID:      resumeUUID,
+		MountOptions:    b.MountOptions,
+		MountIdentifier: b.MountIdentifier,
+		VarMount:        b.VarMount,
+		BootProfiles:    b.BootProfiles,
+		SerialConsole:   b.SerialConsole,
+		Lockdown:        b.Lockdown,
+		Partitions:      b.layout,
+	}
+	if b.PinImage {
+		if imageDigest == "" {
+			if werr := warnf("  cannot pin %s: image digest unknown", b.ImageRef); werr != nil {
+				return werr
+			}
+		} else {
+			config.PinnedDigest = imageDigest
+			config.PinPolicy = b.PinPolicy
+			if config.PinPolicy == "" {
+				config.PinPolicy = PinPolicyWarn
+			}
+			fmt.Printf("  Pinned %s to %s\n", b.ImageRef, imageDigest)
+		}
 	}
 	if err := WriteSystemConfigToTarget(b.MountPoint, config, b.DryRun); err != nil {
 		return fmt.Errorf("failed to write system config: %w", err)
 	}
+	labels := imageLabels(ctx, b.ImageRef, b.Verbose)
+	deployment := newDeployment(b.MountPoint, b.ImageRef, imageDigest, labels, config.PinnedDigest != "")
+	if deployment.KernelArgs, err = imageKernelArgs(b.MountPoint, labels); err != nil {
+		return err
+	}
+	if err := WriteDeployment(b.MountPoint, deployment, b.DryRun); err != nil {
+		return err
+	}
+	recordResult(func(r *OperationResult) { r.ImageVersion = deployment.DisplayVersion() })
 
-	// Step 6: Install bootloader
-	fmt.Println("\nStep 6/6: Installing bootloader...")
+	if err := cancelled(ctx); err != nil {
+		return err
+	}
+
+	// The root is complete; from here on only the boot and var partitions
+	// are written
+	var verity *VerityRoot
+	if b.Verity {
+		if verity, err = sealRoot(ctx, b.MountPoint, scheme.Root1Partition, scheme.Root1HashPartition); err != nil {
+			return err
+		}
+	}
+	var composefsDigest string
+	if b.Composefs {
+		objects := filepath.Join(b.MountPoint, ComposefsObjectsPath)
+		if composefsDigest, err = buildComposefs(ctx, b.MountPoint, objects); err != nil {
+			return err
+		}
+	}
+
+	// Install bootloader
+	printStep(fmt.Sprintf("\nStep %d/%d: Installing bootloader...", step+2, total))
 
 	// Parse OS information from the extracted container
-	osName := ParseOSRelease(b.MountPoint)
+	osName := bootEntryName(b.MountPoint)
 	if b.Verbose {
 		fmt.Printf("  Detected OS: %s\n", osName)
 	}
 
 	bootloader := NewBootloaderInstaller(b.MountPoint, b.Device, scheme, osName)
 	bootloader.SetVerbose(b.Verbose)
-
-	// Add kernel arguments
-	for _, arg := range b.KernelArgs {
+	bootloader.Verity = verity
+	bootloader.ComposefsDigest = composefsDigest
+	bootloader.WritableUsr = b.WritableUsr
+	bootloader.MountOptions = b.MountOptions
+	bootloader.MountIdentifier = b.MountIdentifier
+	bootloader.VarMount = b.VarMount
+	bootloader.BootProfiles = b.BootProfiles
+	bootloader.SerialConsole = b.SerialConsole
+	bootloader.Lockdown = b.Lockdown
+
+	// Add kernel arguments, after the image's defaults
+	if len(deployment.KernelArgs) > 0 {
+		fmt.Printf("  Image kernel arguments: %s\n", strings.Join(deployment.KernelArgs, " "))
+	}
+	for _, arg := range mergeImageKernelArgs(deployment.KernelArgs, b.KernelArgs) {
 		bootloader.AddKernelArg(arg)
 	}
 
@@ -234,10 +765,23 @@ func (b *BootcInstaller) Install() error {
 	bootloaderType := DetectBootloader(b.MountPoint)
 	bootloader.SetType(bootloaderType)
 
-	if err := bootloader.Install(); err != nil {
+	if err := bootloader.Install(ctx); err != nil {
 		return fmt.Errorf("failed to install bootloader: %w", err)
 	}
 
+	// The kernel has been copied out of /usr, which now lives in the store
+	if b.Composefs {
+		if err := dropComposefsTree(b.MountPoint); err != nil {
+			return err
+		}
+	}
+
+	if err := recordInstallHistory(b.MountPoint); err != nil {
+		if werr := warnf("  could not record install history: %v", err); werr != nil {
+			return werr
+		}
+	}
+
 	fmt.Println("\n" + strings.Repeat("=", 60))
 	fmt.Println("Installation completed successfully!")
 	fmt.Println(strings.Repeat("=", 60))
@@ -254,14 +798,13 @@ func (b *BootcInstaller) Verify() error {
 	fmt.Println("Verifying installation...")
 
 	// Check if the device has partitions now
-	deviceName := strings.TrimPrefix(b.Device, "/dev/")
-	diskInfo, err := getDiskInfo(deviceName)
+	diskInfo, err := getDiskInfo(blockDeviceName(b.Device))
 	if err != nil {
 		return fmt.Errorf("failed to verify: %w", err)
 	}
 
 	if len(diskInfo.Partitions) == 0 {
-		return fmt.Errorf("no partitions found on device after installation")
+		return fmt.Errorf("%w: no partitions found on device after installation", ErrVerificationFailed)
 	}
 
 	fmt.Printf("Found %d partition(s) on %s\n", len(diskInfo.Partitions), b.Device)
@@ -272,57 +815,172 @@ func (b *BootcInstaller) Verify() error {
 	return nil
 }
 
+// traceInstall partitions and formats in dry-run mode, so that the commands
+// they would run are recorded, and describes the rest of the install
+func (b *BootcInstaller) traceInstall(ctx context.Context) (err error) {
+	var scheme *PartitionScheme
+	switch {
+	case !b.ownsDisk():
+		scheme, err = b.existingPartitions()
+	case b.VolumeGroup != "":
+		scheme, err = CreateLVMPartitions(ctx, b.Device, b.VolumeGroup, b.SwapSize, true)
+	default:
+		scheme, err = CreatePartitions(ctx, b.Device, b.Verity, b.SwapSize, true)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to create partitions: %w", err)
+	}
+	scheme.FilesystemType = b.FilesystemType
+	if err := formatPartitions(ctx, scheme, b.keep, b.discard(), true); err != nil {
+		return fmt.Errorf("failed to format partitions: %w", err)
+	}
+	fmt.Printf("[DRY RUN] Would mount the partitions at %s, extract the image and install the bootloader\n", b.MountPoint)
+	return nil
+}
+
 // InstallComplete performs the complete installation workflow
-func (b *BootcInstaller) InstallComplete(skipPull bool) error {
+func (b *BootcInstaller) InstallComplete(ctx context.Context, skipPull bool) error {
 	// Check prerequisites
 	fmt.Println("Checking prerequisites...")
 	if err := CheckRequiredTools(); err != nil {
 		return fmt.Errorf("missing required tools: %w", err)
 	}
+	if err := b.checkRootOptions(); err != nil {
+		return err
+	}
 
-	// Validate disk
+	// Validate disk; with existing partitions only the ones to format must
+	// be unused
 	fmt.Printf("Validating disk %s...\n", b.Device)
-	minSize := uint64(10 * 1024 * 1024 * 1024) // 10 GB minimum
-	if err := ValidateDisk(b.Device, minSize); err != nil {
+	if err := validateDisk(b.Device, MinimumDiskSize, !b.ForceUnmount && b.ownsDisk()); err != nil {
+		return err
+	}
+	if b.ForceUnmount && b.ownsDisk() {
+		if err := ensureNotSystemDisk(b.Device); err != nil {
+			return err
+		}
+	}
+	if _, erase := eraseCommand(b.Device, b.WipeMode); erase {
+		if !b.ownsDisk() {
+			return fmt.Errorf("--wipe-mode %s erases the whole disk and cannot be used with existing partitions", b.WipeMode)
+		}
+		if err := checkWipeMode(ctx, b.Device, b.WipeMode); err != nil {
+			return err
+		}
+	}
+	if b.VolumeGroup != "" {
+		if err := checkVolumeGroupAvailable(ctx, b.VolumeGroup, b.Device); err != nil {
+			return err
+		}
+	}
+	var formatted []string
+	rootSlot, rootSlotSize := "root1", defaultGPTLayout()[1].Size
+	if !b.ownsDisk() {
+		scheme, err := b.existingPartitions()
+		if err != nil {
+			return err
+		}
+		formatted = scheme.formattedPartitions(b.keep)
+		rootSlot = scheme.Root1Partition
+		if rootSlotSize, _, _, err = diskGeometry(rootSlot); err != nil {
+			return err
+		}
+		if !b.ForceUnmount {
+			for _, part := range formatted {
+				if err := ensureDeviceNotInUse(part); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	if err := checkImageFits(ctx, b.ImageRef, rootSlot, rootSlotSize, nil); err != nil {
+		return err
+	}
+	if err := b.checkImagePolicy(ctx); err != nil {
 		return err
 	}
 
 	// Pull image if not skipped
 	if !skipPull {
-		if err := b.PullImage(); err != nil {
+		if err := b.PullImage(ctx); err != nil {
 			return err
 		}
 	}
 
 	// Confirm before wiping
-	if !b.DryRun {
-		fmt.Printf("\n%s\n", strings.Repeat("=", 60))
-		fmt.Printf("WARNING: This will DESTROY ALL DATA on %s!\n", b.Device)
-		fmt.Printf("%s\n", strings.Repeat("=", 60))
-		fmt.Print("Type 'yes' to continue: ")
-		var response string
-		_, _ = fmt.Scanln(&response)
-		if response != "yes" {
-			return fmt.Errorf("installation cancelled by user")
+	warning := fmt.Sprintf("WARNING: This will DESTROY ALL DATA on %s!", b.Device)
+	if !b.ownsDisk() {
+		warning = fmt.Sprintf("WARNING: This will ERASE %s; other partitions on %s are kept.", strings.Join(formatted, ", "), b.Device)
+	}
+	if !b.DryRun && !b.AssumeYes && !confirmWipe(b.Device, b.ConfirmDevice, append([]string{warning}, diskContents(ctx, b.Device)...)...) {
+		return fmt.Errorf("installation %w", ErrAborted)
+	}
+
+	defer b.runCommands()()
+	if !b.ownsDisk() {
+		return b.installToPartitions(ctx, formatted)
+	}
+	return b.wipeAndInstall(ctx)
+}
+
+// installToPartitions releases the partitions to format, installs into the
+// existing partitions and verifies
+func (b *BootcInstaller) installToPartitions(ctx context.Context, formatted []string) error {
+	if b.ForceUnmount {
+		for _, part := range formatted {
+			if err := ReleaseDevice(ctx, part, b.DryRun); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := b.Install(ctx); err != nil {
+		return err
+	}
+	if err := b.Verify(); err != nil {
+		// Never wipe a disk with partitions phukit does not own
+		if werr := warnf("verification failed: %v", err); werr != nil {
+			return werr
+		}
+	}
+	return nil
+}
+
+// wipeAndInstall releases and wipes the disk, installs and verifies
+func (b *BootcInstaller) wipeAndInstall(ctx context.Context) error {
+	// Release anything still using the disk
+	if b.ForceUnmount {
+		if err := ReleaseDevice(ctx, b.Device, b.DryRun); err != nil {
+			return err
 		}
-		fmt.Println()
 	}
 
 	// Wipe disk
 	fmt.Printf("Wiping disk %s...\n", b.Device)
-	if err := WipeDisk(b.Device, b.DryRun); err != nil {
+	if err := WipeDisk(ctx, b.Device, b.WipeMode, b.DryRun); err != nil {
 		return err
 	}
 	fmt.Println()
 
 	// Install
-	if err := b.Install(); err != nil {
+	if err := b.Install(ctx); err != nil {
 		return err
 	}
 
 	// Verify
 	if err := b.Verify(); err != nil {
-		fmt.Fprintf(os.Stderr, "Warning: verification failed: %v\n", err)
+		if werr := warnf("verification failed: %v", err); werr != nil {
+			// The install itself finished, so clear the partition table
+			// rather than leave an unverified disk behind
+			if !b.DryRun {
+				fmt.Printf("Strict mode: wiping partition table on %s after failed verification\n", b.Device)
+				if zerr := zapGPT(b.Device); zerr != nil {
+					fmt.Fprintf(os.Stderr, "Error: failed to wipe %s: %v\n", b.Device, zerr)
+				}
+			}
+			return werr
+		}
 	}
 
 	return nil
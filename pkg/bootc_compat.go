@@ -0,0 +1,237 @@
+package pkg
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// BootcAPIVersion and BootcHostKind identify the bootc status document
+const (
+	BootcAPIVersion = "org.containers.bootc/v1"
+	BootcHostKind   = "BootcHost"
+)
+
+// Boot orders of a BootcHost spec
+const (
+	BootOrderDefault  = "default"
+	BootOrderRollback = "rollback"
+)
+
+// BootcHost mirrors the document printed by bootc status --json, so tools
+// written against bootc can read a phukit system. The A/B roots map to bootc
+// deployments: the running root is booted, the other root is staged when it
+// holds a newer image that boots next, and the rollback otherwise.
+type BootcHost struct {
+	APIVersion string          `json:"apiVersion" yaml:"apiVersion"`
+	Kind       string          `json:"kind" yaml:"kind"`
+	Metadata   BootcMetadata   `json:"metadata" yaml:"metadata"`
+	Spec       BootcHostSpec   `json:"spec" yaml:"spec"`
+	Status     BootcHostStatus `json:"status" yaml:"status"`
+}
+
+// BootcMetadata is the object metadata of a BootcHost
+type BootcMetadata struct {
+	Name string `json:"name" yaml:"name"`
+}
+
+// BootcHostSpec is the desired state: the image to track and which root
+// boots next
+type BootcHostSpec struct {
+	Image     *BootcImageReference `json:"image" yaml:"image"`
+	BootOrder string               `json:"bootOrder" yaml:"bootOrder"`
+}
+
+// BootcImageReference is an image and how it is fetched
+type BootcImageReference struct {
+	Image     string `json:"image" yaml:"image"`
+	Transport string `json:"transport" yaml:"transport"`
+}
+
+// BootcHostStatus lists the deployments
+type BootcHostStatus struct {
+	Staged         *BootcBootEntry `json:"staged" yaml:"staged"`
+	Booted         *BootcBootEntry `json:"booted" yaml:"booted"`
+	Rollback       *BootcBootEntry `json:"rollback" yaml:"rollback"`
+	RollbackQueued bool            `json:"rollbackQueued" yaml:"rollbackQueued"`
+	Type           string          `json:"type" yaml:"type"`
+}
+
+// BootcBootEntry is one deployment
+type BootcBootEntry struct {
+	Image        *BootcImageStatus `json:"image" yaml:"image"`
+	CachedUpdate *BootcImageStatus `json:"cachedUpdate" yaml:"cachedUpdate"`
+	Incompatible bool              `json:"incompatible" yaml:"incompatible"`
+	Pinned       bool              `json:"pinned" yaml:"pinned"`
+}
+
+// BootcImageStatus is the image of a deployment
+type BootcImageStatus struct {
+	Image        BootcImageReference `json:"image" yaml:"image"`
+	Version      string              `json:"version,omitempty" yaml:"version,omitempty"`
+	Timestamp    *time.Time          `json:"timestamp" yaml:"timestamp"`
+	ImageDigest  string              `json:"imageDigest" yaml:"imageDigest"`
+	Architecture string              `json:"architecture" yaml:"architecture"`
+}
+
+// GetBootcHost inspects both roots on device and the boot configuration. It
+// mounts the inactive root and the boot partition read-only, so it needs root.
+func GetBootcHost(device string) (*BootcHost, error) {
+	scheme, err := DetectExistingPartitionScheme(device)
+	if err != nil {
+		return nil, fmt.Errorf("failed to detect partition scheme: %w", err)
+	}
+	active, err := GetActiveRootPartition()
+	if err != nil {
+		return nil, err
+	}
+	other := scheme.Root2Partition
+	switch rootSlot(scheme, active) {
+	case "root1":
+	case "root2":
+		other = scheme.Root1Partition
+	default:
+		return nil, fmt.Errorf("booted root %s is not on %s", active, device)
+	}
+
+	booted, err := ReadDeployment(bootedRoot)
+	if err != nil {
+		return nil, err
+	}
+
+	// An empty or unreadable root has no deployment
+	var otherDeployment *Deployment
+	_ = withReadOnlyMount(other, func(root string) error {
+		otherDeployment, err = ReadDeployment(root)
+		return err
+	})
+
+	var defaultUUID string
+	if err := withReadOnlyMount(scheme.BootPartition, func(boot string) error {
+		defaultUUID, err = readDefaultRootUUID(boot)
+		return err
+	}); err != nil {
+		return nil, err
+	}
+	otherUUID, err := GetPartitionUUID(other)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get UUID of %s: %w", other, err)
+	}
+
+	return newBootcHost(booted, otherDeployment, defaultUUID == otherUUID), nil
+}
+
+// withReadOnlyMount mounts device read-only on a temporary directory for fn
+func withReadOnlyMount(device string, fn func(dir string) error) error {
+	dir, err := os.MkdirTemp("", "phukit-inspect-")
+	if err != nil {
+		return fmt.Errorf("failed to create mount point: %w", err)
+	}
+	defer func() { _ = os.Remove(dir) }()
+
+	if err := mountDevice(device, dir, true); err != nil {
+		return fmt.Errorf("failed to mount %s: %w", device, err)
+	}
+	defer func() { _ = unmount(dir) }()
+	return fn(dir)
+}
+
+// newBootcHost maps the booted root and the other root to bootc deployments.
+// other is nil if the other root holds no deployment; otherIsDefault is true
+// if the other root boots next.
+func newBootcHost(booted, other *Deployment, otherIsDefault bool) *BootcHost {
+	host := &BootcHost{
+		APIVersion: BootcAPIVersion,
+		Kind:       BootcHostKind,
+		Metadata:   BootcMetadata{Name: "host"},
+		Spec:       BootcHostSpec{BootOrder: BootOrderDefault},
+		Status: BootcHostStatus{
+			Booted: bootcBootEntry(booted),
+			Type:   "bootcHost",
+		},
+	}
+	spec := booted
+
+	switch {
+	case other == nil:
+	case otherIsDefault && !other.Timestamp.Before(booted.Timestamp):
+		// An update waiting for the next boot
+		host.Status.Staged = bootcBootEntry(other)
+		spec = other
+	case otherIsDefault:
+		// phukit rollback switched to the older root
+		host.Status.Rollback = bootcBootEntry(other)
+		host.Status.RollbackQueued = true
+		host.Spec.BootOrder = BootOrderRollback
+	default:
+		host.Status.Rollback = bootcBootEntry(other)
+	}
+
+	if spec.ImageRef != "" {
+		host.Spec.Image = &BootcImageReference{Image: spec.ImageRef, Transport: "registry"}
+	}
+	return host
+}
+
+// bootcBootEntry converts a deployment to a bootc boot entry
+func bootcBootEntry(d *Deployment) *BootcBootEntry {
+	image := &BootcImageStatus{
+		Image:        BootcImageReference{Image: d.ImageRef, Transport: "registry"},
+		Version:      d.DisplayVersion(),
+		ImageDigest:  d.ImageDigest,
+		Architecture: runtime.GOARCH,
+	}
+	if !d.Timestamp.IsZero() {
+		image.Timestamp = &d.Timestamp
+	}
+	return &BootcBootEntry{Image: image, Pinned: d.Pinned}
+}
+
+// PrintHumanReadable writes the deployments the way bootc status does
+func (h *BootcHost) PrintHumanReadable(w io.Writer) {
+	entries := []struct {
+		label string
+		entry *BootcBootEntry
+	}{
+		{"Staged image", h.Status.Staged},
+		{"Booted image", h.Status.Booted},
+		{"Rollback image", h.Status.Rollback},
+	}
+
+	first := true
+	for _, e := range entries {
+		if e.entry == nil {
+			continue
+		}
+		if !first {
+			_, _ = fmt.Fprintln(w)
+		}
+		first = false
+
+		marker := " "
+		if e.entry == h.Status.Booted {
+			marker = "●"
+		}
+		image := e.entry.Image
+		_, _ = fmt.Fprintf(w, "%s %s: %s\n", marker, e.label, image.Image.Image)
+		if image.ImageDigest != "" {
+			_, _ = fmt.Fprintf(w, "        Digest: %s (%s)\n", image.ImageDigest, image.Architecture)
+		}
+		if image.Version != "" || image.Timestamp != nil {
+			version := image.Version
+			if image.Timestamp != nil {
+				version = fmt.Sprintf("%s (%s)", version, image.Timestamp.UTC().Format(time.RFC3339))
+			}
+			_, _ = fmt.Fprintf(w, "       Version: %s\n", strings.TrimSpace(version))
+		}
+		if e.entry.Pinned {
+			_, _ = fmt.Fprintln(w, "        Pinned: yes")
+		}
+	}
+	if h.Status.RollbackQueued {
+		_, _ = fmt.Fprintln(w, "\nRollback queued: the rollback image boots next")
+	}
+}
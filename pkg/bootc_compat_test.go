@@ -0,0 +1,94 @@
+package pkg
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewBootcHost(t *testing.T) {
+	older := &Deployment{ImageRef: "quay.io/example/os:v1", ImageDigest: "sha256:old", Timestamp: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)}
+	newer := &Deployment{ImageRef: "quay.io/example/os:v2", ImageDigest: "sha256:new", Timestamp: time.Date(2025, 2, 1, 0, 0, 0, 0, time.UTC)}
+
+	tests := []struct {
+		name           string
+		booted, other  *Deployment
+		otherIsDefault bool
+		wantStaged     string // Digest, "" for none
+		wantRollback   string
+		wantQueued     bool
+		wantSpec       string
+	}{
+		{name: "fresh install", booted: older, wantSpec: older.ImageRef},
+		{name: "update staged", booted: older, other: newer, otherIsDefault: true, wantStaged: "sha256:new", wantSpec: newer.ImageRef},
+		{name: "update booted", booted: newer, other: older, wantRollback: "sha256:old", wantSpec: newer.ImageRef},
+		{name: "rollback queued", booted: newer, other: older, otherIsDefault: true, wantRollback: "sha256:old", wantQueued: true, wantSpec: newer.ImageRef},
+		{name: "rollback booted", booted: older, other: newer, wantRollback: "sha256:new", wantSpec: older.ImageRef},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			host := newBootcHost(tt.booted, tt.other, tt.otherIsDefault)
+
+			digest := func(e *BootcBootEntry) string {
+				if e == nil {
+					return ""
+				}
+				return e.Image.ImageDigest
+			}
+			if got := digest(host.Status.Booted); got != tt.booted.ImageDigest {
+				t.Errorf("booted = %q, want %q", got, tt.booted.ImageDigest)
+			}
+			if got := digest(host.Status.Staged); got != tt.wantStaged {
+				t.Errorf("staged = %q, want %q", got, tt.wantStaged)
+			}
+			if got := digest(host.Status.Rollback); got != tt.wantRollback {
+				t.Errorf("rollback = %q, want %q", got, tt.wantRollback)
+			}
+			if host.Status.RollbackQueued != tt.wantQueued {
+				t.Errorf("rollbackQueued = %v, want %v", host.Status.RollbackQueued, tt.wantQueued)
+			}
+			wantOrder := BootOrderDefault
+			if tt.wantQueued {
+				wantOrder = BootOrderRollback
+			}
+			if host.Spec.BootOrder != wantOrder {
+				t.Errorf("bootOrder = %q, want %q", host.Spec.BootOrder, wantOrder)
+			}
+			if host.Spec.Image == nil || host.Spec.Image.Image != tt.wantSpec {
+				t.Errorf("spec.image = %+v, want %q", host.Spec.Image, tt.wantSpec)
+			}
+		})
+	}
+}
+
+func TestBootcHostJSON(t *testing.T) {
+	booted := &Deployment{ImageRef: "quay.io/example/os:v1", ImageDigest: "sha256:abc"}
+	data, err := json.Marshal(newBootcHost(booted, nil, false))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Field names and nulls as bootc prints them
+	for _, want := range []string{
+		`"apiVersion":"org.containers.bootc/v1"`,
+		`"kind":"BootcHost"`,
+		`"spec":{"image":{"image":"quay.io/example/os:v1","transport":"registry"},"bootOrder":"default"}`,
+		`"staged":null`,
+		`"rollback":null`,
+		`"imageDigest":"sha256:abc"`,
+		`"timestamp":null`,
+		`"type":"bootcHost"`,
+	} {
+		if !strings.Contains(string(data), want) {
+			t.Errorf("JSON does not contain %s:\n%s", want, data)
+		}
+	}
+
+	var buf bytes.Buffer
+	newBootcHost(booted, nil, false).PrintHumanReadable(&buf)
+	if !strings.HasPrefix(buf.String(), "● Booted image: quay.io/example/os:v1\n") {
+		t.Errorf("PrintHumanReadable() = %q", buf.String())
+	}
+}
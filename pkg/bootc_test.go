@@ -1,6 +1,7 @@
 package pkg
 
 import (
+	"context"
 	"encoding/json"
 	"os"
 	"path/filepath"
@@ -11,7 +12,7 @@ import (
 
 func TestBootcInstaller_Install(t *testing.T) {
 	testutil.RequireRoot(t)
-	testutil.RequireTools(t, "losetup", "sgdisk", "mkfs.vfat", "mkfs.ext4", "podman", "mount", "umount")
+	testutil.RequireTools(t, "losetup", "mkfs.vfat", "mkfs.ext4", "podman", "mount", "umount")
 
 	// Create test disk
 	disk, err := testutil.CreateTestDisk(t, 50)
@@ -37,7 +38,7 @@ func TestBootcInstaller_Install(t *testing.T) {
 
 	// Perform installation
 	t.Log("Starting installation test")
-	if err := installer.Install(); err != nil {
+	if err := installer.Install(context.Background()); err != nil {
 		t.Fatalf("Install failed: %v", err)
 	}
 
@@ -177,7 +178,7 @@ func TestBootcInstaller_DryRun(t *testing.T) {
 
 	// Perform dry-run installation
 	t.Log("Testing dry-run mode")
-	if err := installer.Install(); err != nil {
+	if err := installer.Install(context.Background()); err != nil {
 		t.Fatalf("Dry-run install failed: %v", err)
 	}
 
@@ -197,7 +198,7 @@ func TestBootcInstaller_DryRun(t *testing.T) {
 
 func TestBootcInstaller_WithKernelArgs(t *testing.T) {
 	testutil.RequireRoot(t)
-	testutil.RequireTools(t, "losetup", "sgdisk", "mkfs.vfat", "mkfs.ext4", "podman", "mount", "umount")
+	testutil.RequireTools(t, "losetup", "mkfs.vfat", "mkfs.ext4", "podman", "mount", "umount")
 
 	// Create test disk
 	disk, err := testutil.CreateTestDisk(t, 50)
@@ -224,7 +225,7 @@ func TestBootcInstaller_WithKernelArgs(t *testing.T) {
 
 	// Perform installation
 	t.Log("Testing installation with kernel arguments")
-	if err := installer.Install(); err != nil {
+	if err := installer.Install(context.Background()); err != nil {
 		t.Fatalf("Install failed: %v", err)
 	}
 
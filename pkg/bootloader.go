@@ -1,11 +1,12 @@
 package pkg
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"os"
-	"os/exec"
 	"path/filepath"
+	"slices"
 	"strings"
 )
 
@@ -26,6 +27,20 @@ type BootloaderInstaller struct {
 	KernelArgs []string
 	OSName     string
 	Verbose    bool
+	Verity     *VerityRoot // Set if the root is sealed with dm-verity
+	// Image digest of the composefs /usr, if the root uses composefs
+	ComposefsDigest string
+	WritableUsr     bool            // Do not mount /usr read-only
+	MountOptions    MountOptions    // Mount options of the root and /var
+	MountIdentifier MountIdentifier // How /var is named on the kernel command line
+	VarMount        VarMount        // Where /var is mounted from
+
+	// Extra boot entries of the root with more kernel arguments
+	BootProfiles []BootProfile
+	// Serial port to add as a terminal, nil for the screen only
+	SerialConsole *SerialConsole
+	// Keeps console users from editing the kernel command line, nil for none
+	Lockdown *BootLockdown
 }
 
 // NewBootloaderInstaller creates a new BootloaderInstaller
@@ -114,24 +129,14 @@ func (b *BootloaderInstaller) copyKernelFromModules() error {
 		fmt.Printf("  Copied kernel to boot partition: %s\n", kernelName)
 
 		// Look for initramfs in /usr/lib/modules/$KERNEL_VERSION/
-		initrdPatterns := []string{
-			filepath.Join(kernelModuleDir, "initramfs.img"),
-			filepath.Join(kernelModuleDir, "initrd.img"),
-			filepath.Join(kernelModuleDir, "initramfs-"+kernelVersion+".img"),
-			filepath.Join(kernelModuleDir, "initrd.img-"+kernelVersion),
-		}
-
-		for _, pattern := range initrdPatterns {
-			if srcInitrd, err := os.Stat(pattern); err == nil && !srcInitrd.IsDir() {
-				initrdName := "initramfs-" + kernelVersion + ".img"
-				destInitrd := filepath.Join(bootDir, initrdName)
+		if srcInitrd := findModulesInitramfs(kernelModuleDir, kernelVersion); srcInitrd != "" {
+			initrdName := "initramfs-" + kernelVersion + ".img"
+			destInitrd := filepath.Join(bootDir, initrdName)
 
-				if err := copyFile(pattern, destInitrd); err != nil {
-					return fmt.Errorf("failed to copy initramfs %s: %w", initrdName, err)
-				}
-				fmt.Printf("  Copied initramfs to boot partition: %s\n", initrdName)
-				break // Only copy the first matching initramfs
+			if err := copyFile(srcInitrd, destInitrd); err != nil {
+				return fmt.Errorf("failed to copy initramfs %s: %w", initrdName, err)
 			}
+			fmt.Printf("  Copied initramfs to boot partition: %s\n", initrdName)
 		}
 	}
 
@@ -139,7 +144,7 @@ func (b *BootloaderInstaller) copyKernelFromModules() error {
 }
 
 // Install installs the bootloader
-func (b *BootloaderInstaller) Install() error {
+func (b *BootloaderInstaller) Install(ctx context.Context) error {
 	fmt.Printf("Installing %s bootloader...\n", b.Type)
 
 	// Copy kernel and initramfs from /usr/lib/modules to /boot
@@ -147,9 +152,28 @@ func (b *BootloaderInstaller) Install() error {
 		return fmt.Errorf("failed to copy kernel from modules: %w", err)
 	}
 
+	// Updates rebuild the boot entries of the root from what is recorded here
+	if b.Verity != nil || b.ComposefsDigest != "" {
+		rootUUID, err := GetPartitionUUID(b.Scheme.Root1Partition)
+		if err != nil {
+			return fmt.Errorf("failed to get root UUID: %w", err)
+		}
+		bootDir := filepath.Join(b.TargetDir, "boot")
+		if b.Verity != nil {
+			if err := recordRootRecord(bootDir, VerityRootsFile, rootUUID, b.Verity); err != nil {
+				return err
+			}
+		}
+		if b.ComposefsDigest != "" {
+			if err := recordRootRecord(bootDir, ComposefsImagesFile, rootUUID, b.ComposefsDigest); err != nil {
+				return err
+			}
+		}
+	}
+
 	switch b.Type {
 	case BootloaderGRUB2:
-		return b.installGRUB2()
+		return b.installGRUB2(ctx)
 	case BootloaderSystemdBoot:
 		return b.installSystemdBoot()
 	default:
@@ -158,12 +182,12 @@ func (b *BootloaderInstaller) Install() error {
 }
 
 // installGRUB2 installs GRUB2 bootloader
-func (b *BootloaderInstaller) installGRUB2() error {
+func (b *BootloaderInstaller) installGRUB2(ctx context.Context) error {
 	fmt.Println("  Installing GRUB2...")
 
 	// Check if grub-install is available
 	grubInstallCmd := "grub-install"
-	if _, err := exec.LookPath("grub2-install"); err == nil {
+	if _, err := executor.LookPath("grub2-install"); err == nil {
 		grubInstallCmd = "grub2-install"
 	}
 
@@ -183,11 +207,8 @@ func (b *BootloaderInstaller) installGRUB2() error {
 		args = append(args, "--verbose")
 	}
 
-	cmd := exec.Command(grubInstallCmd, args...)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-
-	if err := cmd.Run(); err != nil {
+	cmd := Command{Name: grubInstallCmd, Args: args}
+	if _, err := runWithToolProgress(ctx, cmd, PhaseBootloader, &grubProgressParser{}, os.Stdout); err != nil {
 		return fmt.Errorf("failed to install GRUB: %w", err)
 	}
 
@@ -261,9 +282,9 @@ func (b *BootloaderInstaller) generateGRUBConfig() error {
 	}
 
 	// Get /var UUID for kernel command line mount
-	varUUID, err := GetPartitionUUID(b.Scheme.VarPartition)
+	varSource, err := varKernelSource(b.Scheme.VarPartition, b.MountIdentifier, b.VarMount, b.ComposefsDigest != "")
 	if err != nil {
-		return fmt.Errorf("failed to get var UUID: %w", err)
+		return err
 	}
 
 	// Get filesystem type (default to ext4 for backward compatibility)
@@ -273,17 +294,19 @@ func (b *BootloaderInstaller) generateGRUBConfig() error {
 	}
 
 	// Build kernel command line
-	kernelCmdline := []string{
-		"root=UUID=" + rootUUID,
-		"ro",
-		"console=tty0",
-		// Mount /var via kernel command line (systemd.mount-extra)
-		"systemd.mount-extra=UUID=" + varUUID + ":/var:" + fsType + ":defaults",
+	kernelCmdline := []string{"root=UUID=" + rootUUID, "ro"}
+	if b.Verity != nil {
+		kernelCmdline = b.Verity.kernelArgs(rootUUID)
+	}
+	if !slices.Contains(b.KernelArgs, "console=tty0") {
+		kernelCmdline = append(kernelCmdline, "console=tty0")
 	}
+	// Mount /var via kernel command line (systemd.mount-extra)
+	kernelCmdline = append(kernelCmdline, mountKernelArgs(varSource, fsType, b.ComposefsDigest, b.WritableUsr, b.MountOptions)...)
 	kernelCmdline = append(kernelCmdline, b.KernelArgs...)
 
 	// Create GRUB config
-	grubCfg := fmt.Sprintf(`set timeout=5
+	grubCfg := grubSerialConfig(b.SerialConsole) + fmt.Sprintf(`set timeout=5
 set default=0
 
 menuentry '%s' {
@@ -291,6 +314,8 @@ menuentry '%s' {
     initrd /%s
 }
 `, b.OSName, kernelVersion, strings.Join(kernelCmdline, " "), initrd)
+	grubCfg += grubProfileEntries(b.BootProfiles, b.OSName, kernelVersion, initrd, kernelCmdline)
+	grubCfg = grubLockdownConfig(b.Lockdown, grubCfg)
 
 	// Write GRUB config
 	grubDir := filepath.Join(b.TargetDir, "boot", "grub")
@@ -303,7 +328,7 @@ menuentry '%s' {
 	}
 
 	grubCfgPath := filepath.Join(grubDir, "grub.cfg")
-	if err := os.WriteFile(grubCfgPath, []byte(grubCfg), 0644); err != nil {
+	if err := writeFileAtomic(grubCfgPath, []byte(grubCfg), 0644); err != nil {
 		return fmt.Errorf("failed to write grub.cfg: %w", err)
 	}
 
@@ -407,9 +432,9 @@ func (b *BootloaderInstaller) generateSystemdBootConfig() error {
 	}
 
 	// Get /var UUID for kernel command line mount
-	varUUID, err := GetPartitionUUID(b.Scheme.VarPartition)
+	varSource, err := varKernelSource(b.Scheme.VarPartition, b.MountIdentifier, b.VarMount, b.ComposefsDigest != "")
 	if err != nil {
-		return fmt.Errorf("failed to get var UUID: %w", err)
+		return err
 	}
 
 	// Find kernel on boot partition (combined EFI/boot partition)
@@ -442,12 +467,9 @@ func (b *BootloaderInstaller) generateSystemdBootConfig() error {
 	}
 
 	// Build kernel command line
-	kernelCmdline := []string{
-		"root=UUID=" + rootUUID,
-		"rw",
-		// Mount /var via kernel command line (systemd.mount-extra)
-		"systemd.mount-extra=UUID=" + varUUID + ":/var:" + fsType + ":defaults",
-	}
+	kernelCmdline := rootKernelArgs(rootUUID, b.Verity)
+	// Mount /var via kernel command line (systemd.mount-extra)
+	kernelCmdline = append(kernelCmdline, mountKernelArgs(varSource, fsType, b.ComposefsDigest, b.WritableUsr, b.MountOptions)...)
 	kernelCmdline = append(kernelCmdline, b.KernelArgs...)
 
 	// Create loader configuration (in /boot/loader since /boot is the ESP)
@@ -456,13 +478,18 @@ func (b *BootloaderInstaller) generateSystemdBootConfig() error {
 		return fmt.Errorf("failed to create loader directory: %w", err)
 	}
 
-	loaderConf := `default bootc
+	// Changing the console mode breaks the firmware's serial redirection
+	consoleMode := "max"
+	if b.SerialConsole != nil {
+		consoleMode = "keep"
+	}
+	loaderConf := fmt.Sprintf(`default bootc
 timeout 5
-console-mode max
-editor yes
-`
+console-mode %s
+editor %s
+`, consoleMode, loaderEditor(b.Lockdown))
 	loaderConfPath := filepath.Join(loaderDir, "loader.conf")
-	if err := os.WriteFile(loaderConfPath, []byte(loaderConf), 0644); err != nil {
+	if err := writeFileAtomic(loaderConfPath, []byte(loaderConf), 0644); err != nil {
 		return fmt.Errorf("failed to write loader.conf: %w", err)
 	}
 
@@ -484,9 +511,12 @@ options %s
 `, b.OSName, kernelVersion, initrd, strings.Join(kernelCmdline, " "))
 
 	entryPath := filepath.Join(entriesDir, "bootc.conf")
-	if err := os.WriteFile(entryPath, []byte(entry), 0644); err != nil {
+	if err := writeFileAtomic(entryPath, []byte(entry), 0644); err != nil {
 		return fmt.Errorf("failed to write boot entry: %w", err)
 	}
+	if err := writeLoaderProfileEntries(entriesDir, b.BootProfiles, b.OSName, kernelVersion, initrd, kernelCmdline); err != nil {
+		return err
+	}
 
 	fmt.Printf("  Created boot entry: %s\n", b.OSName)
 	return nil
@@ -581,7 +611,9 @@ func (b *BootloaderInstaller) setupSecureBootChain(bootloaderEFI string) (bool,
 		mokDest := filepath.Join(efiBootDir, "mmx64.efi")
 		if err := copyEFIFile(mokPath, mokDest); err != nil {
 			// MOK manager is optional, just warn
-			fmt.Printf("  Warning: failed to copy MOK manager: %v\n", err)
+			if werr := warnf("  failed to copy MOK manager: %v", err); werr != nil {
+				return false, werr
+			}
 		} else {
 			fmt.Println("  Installed MOK manager (mmx64.efi)")
 		}
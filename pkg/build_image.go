@@ -0,0 +1,202 @@
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Disk image formats written by build-image
+const (
+	DiskImageRaw   = "raw"
+	DiskImageQcow2 = "qcow2"
+	DiskImageVMDK  = "vmdk"
+	DiskImageVHD   = "vhd"    // Fixed-size VHD, as Azure requires
+	DiskImageTarGz = "tar.gz" // disk.raw in a gzipped tarball, as GCE requires
+)
+
+// DefaultDiskImageSize is the virtual size of a built disk image. The image
+// is sparse, so it only takes the space the installed files need.
+const DefaultDiskImageSize = 32 * 1024 * 1024 * 1024
+
+// DiskImageBuilder installs a bootc container to a loop-attached sparse file
+// and writes it as a disk image
+type DiskImageBuilder struct {
+	ImageRef         string
+	Output           string
+	Format           string // raw, qcow2, vmdk, vhd or tar.gz
+	Size             int64  // Virtual disk size in bytes
+	Verbose          bool
+	DryRun           bool
+	KernelArgs       []string
+	FilesystemType   string
+	Verity           bool
+	Composefs        bool
+	WritableUsr      bool
+	GrowVar          bool            // Grow /var into the rest of the disk on boot
+	MountOptions     MountOptions    // Mount options of the root and /var
+	MountIdentifier  MountIdentifier // How generated mounts name filesystems
+	VarMount         VarMount        // Where /var is mounted from
+	EnableUnits      []string        // systemd units of the image to enable
+	Firstboot        bool            // Install the first-boot provisioning service
+	FirstbootScripts []string        // Scripts for the first-boot service to run
+}
+
+// NewDiskImageBuilder creates a DiskImageBuilder writing a raw image of the
+// default size to output
+func NewDiskImageBuilder(imageRef, output string) *DiskImageBuilder {
+	return &DiskImageBuilder{
+		ImageRef:       imageRef,
+		Output:         output,
+		Format:         DiskImageRaw,
+		Size:           DefaultDiskImageSize,
+		FilesystemType: "ext4",
+	}
+}
+
+// checkFormat checks that the output format is supported and its tools are
+// installed
+func (d *DiskImageBuilder) checkFormat() error {
+	switch d.Format {
+	case DiskImageRaw:
+		return nil
+	case DiskImageTarGz:
+		if _, err := executor.LookPath("tar"); err != nil {
+			return fmt.Errorf("%w: tar", ErrMissingTool)
+		}
+		return nil
+	case DiskImageQcow2, DiskImageVMDK, DiskImageVHD:
+		if _, err := executor.LookPath("qemu-img"); err != nil {
+			return fmt.Errorf("%w: qemu-img - install the qemu-img package", ErrMissingTool)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unsupported image format: %s (supported: raw, qcow2, vmdk, vhd, tar.gz)", d.Format)
+	}
+}
+
+// Build installs the image to a new disk image at d.Output
+func (d *DiskImageBuilder) Build(ctx context.Context, skipPull bool) (err error) {
+	if err := d.checkFormat(); err != nil {
+		return err
+	}
+	if err := CheckRequiredTools(); err != nil {
+		return fmt.Errorf("missing required tools: %w", err)
+	}
+	if int64(MinimumDiskSize) > d.Size {
+		return fmt.Errorf("%w: disk image size %s is below the minimum of %s", ErrInsufficientSpace, FormatSize(uint64(d.Size)), FormatSize(MinimumDiskSize))
+	}
+
+	if d.DryRun {
+		fmt.Printf("[DRY RUN] Would build a %s %s disk image of %s at %s\n", FormatSize(uint64(d.Size)), d.Format, d.ImageRef, d.Output)
+		return nil
+	}
+
+	// Install into a temporary file next to the output, so a failed build
+	// leaves no partial image behind
+	tmp, err := os.CreateTemp(filepath.Dir(d.Output), ".phukit-build-*.raw")
+	if err != nil {
+		return fmt.Errorf("failed to create disk image: %w", err)
+	}
+	raw := tmp.Name()
+	defer func() { _ = os.Remove(raw) }()
+	err = tmp.Truncate(d.Size)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to size disk image: %w", err)
+	}
+
+	device, err := AttachLoopDevice(ctx, raw)
+	if err != nil {
+		return err
+	}
+	detached := false
+	detach := func() {
+		if !detached {
+			_, _ = runCommand(context.WithoutCancel(ctx), "losetup", "-d", device)
+			detached = true
+		}
+	}
+	defer detach()
+	fmt.Printf("Created %s disk image on loop device %s\n", FormatSize(uint64(d.Size)), device)
+
+	installer := NewBootcInstaller(d.ImageRef, device)
+	installer.SetVerbose(d.Verbose)
+	installer.SetMountPoint(filepath.Join(os.TempDir(), "phukit-build-image"))
+	installer.SetFilesystemType(d.FilesystemType)
+	installer.SetVerity(d.Verity)
+	installer.SetComposefs(d.Composefs)
+	installer.SetWritableUsr(d.WritableUsr)
+	installer.SetGrowVar(d.GrowVar)
+	installer.SetMountOptions(d.MountOptions)
+	installer.SetMountStyle(d.MountIdentifier, d.VarMount)
+	installer.SetFirstboot(d.Firstboot, d.FirstbootScripts)
+	for _, unit := range d.EnableUnits {
+		installer.AddEnabledUnit(unit)
+	}
+	for _, arg := range d.KernelArgs {
+		installer.AddKernelArg(arg)
+	}
+	if err := installer.checkRootOptions(); err != nil {
+		return err
+	}
+	if !skipPull {
+		if err := installer.PullImage(ctx); err != nil {
+			return err
+		}
+	}
+	if err := installer.Install(ctx); err != nil {
+		return err
+	}
+	detach()
+
+	return d.writeOutput(ctx, raw)
+}
+
+// writeOutput moves or converts the installed raw image to d.Output
+func (d *DiskImageBuilder) writeOutput(ctx context.Context, raw string) error {
+	if d.Format == DiskImageRaw {
+		if err := os.Rename(raw, d.Output); err != nil {
+			return fmt.Errorf("failed to write %s: %w", d.Output, err)
+		}
+		fmt.Printf("Wrote raw disk image to %s\n", d.Output)
+		return nil
+	}
+
+	fmt.Printf("Converting disk image to %s...\n", d.Format)
+	var out []byte
+	var err error
+	switch d.Format {
+	case DiskImageTarGz:
+		out, err = writeDiskTarball(ctx, raw, d.Output)
+	case DiskImageVHD:
+		// Azure only accepts fixed-size VHDs of a whole number of MiB
+		out, err = runCommand(ctx, "qemu-img", "convert", "-f", "raw", "-O", "vpc", "-o", "subformat=fixed,force_size", raw, d.Output)
+	default:
+		out, err = runCommand(ctx, "qemu-img", "convert", "-f", "raw", "-O", d.Format, raw, d.Output)
+	}
+	if err != nil {
+		_ = os.Remove(d.Output)
+		return fmt.Errorf("failed to convert disk image to %s: %w\nOutput: %s", d.Format, err, strings.TrimSpace(string(out)))
+	}
+	fmt.Printf("Wrote %s disk image to %s\n", d.Format, d.Output)
+	return nil
+}
+
+// writeDiskTarball packs the raw image raw as disk.raw into the gzipped
+// tarball output, keeping it sparse
+func writeDiskTarball(ctx context.Context, raw, output string) ([]byte, error) {
+	dir, err := os.MkdirTemp(filepath.Dir(output), ".phukit-build-")
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+	if err := os.Rename(raw, filepath.Join(dir, "disk.raw")); err != nil {
+		return nil, err
+	}
+	return runCommand(ctx, "tar", "--format=oldgnu", "-Sczf", output, "-C", dir, "disk.raw")
+}
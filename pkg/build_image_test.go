@@ -0,0 +1,52 @@
+package pkg
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDiskImageBuilderCheckFormat(t *testing.T) {
+	setupDryRunExecutor(t)
+
+	for _, format := range []string{DiskImageRaw, DiskImageQcow2, DiskImageVMDK, DiskImageVHD, DiskImageTarGz} {
+		d := &DiskImageBuilder{Format: format}
+		if err := d.checkFormat(); err != nil {
+			t.Errorf("checkFormat(%s) error = %v", format, err)
+		}
+	}
+	d := &DiskImageBuilder{Format: "vdi"}
+	if err := d.checkFormat(); err == nil {
+		t.Error("checkFormat(vdi) expected error")
+	}
+}
+
+func TestDiskImageBuilderWriteOutput(t *testing.T) {
+	t.Run("raw", func(t *testing.T) {
+		dir := t.TempDir()
+		raw := filepath.Join(dir, ".phukit-build-1.raw")
+		writeFakeFile(t, raw, "disk")
+
+		d := &DiskImageBuilder{Format: DiskImageRaw, Output: filepath.Join(dir, "disk.img")}
+		if err := d.writeOutput(context.Background(), raw); err != nil {
+			t.Fatalf("writeOutput() error = %v", err)
+		}
+		if data, err := os.ReadFile(d.Output); err != nil || string(data) != "disk" {
+			t.Errorf("output = %q, %v", data, err)
+		}
+	})
+
+	t.Run("qcow2", func(t *testing.T) {
+		fake, _ := setupDryRunExecutor(t)
+
+		d := &DiskImageBuilder{Format: DiskImageQcow2, Output: "/out/disk.qcow2"}
+		if err := d.writeOutput(context.Background(), "/out/disk.raw"); err != nil {
+			t.Fatalf("writeOutput() error = %v", err)
+		}
+		want := "qemu-img convert -f raw -O qcow2 /out/disk.raw /out/disk.qcow2"
+		if len(fake.Commands) != 1 || fake.Commands[0].String() != want {
+			t.Errorf("commands = %v, want %q", fake.Commands, want)
+		}
+	})
+}
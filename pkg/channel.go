@@ -0,0 +1,155 @@
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"sort"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/name"
+)
+
+// Update channels
+//
+// A channel names the image stream a machine follows, such as stable,
+// testing or edge. Fleets roll out in stages by moving a few machines to a
+// channel that gets new images first. Channels are defined in the system
+// config, next to the built-in stable, testing and edge channels:
+//
+//	"channels": {
+//	  "stable": "42",
+//	  "lts": "quay.io/my-org/os-lts:latest",
+//	  "edge": "{repository}:nightly-{arch}"
+//	},
+//	"channel": "stable"
+//
+// A channel is a tag of the repository of the installed image, or an image
+// reference, in which {repository} is that repository and {arch} the
+// architecture of the machine (amd64, arm64, ...). The channel is resolved
+// again on every update, so editing its definition moves the machine along.
+// An update to an explicitly given image leaves the channel.
+
+// DefaultChannels are the channels every machine has, as tags of the
+// installed image's repository
+var DefaultChannels = map[string]string{
+	"stable":  "stable",
+	"testing": "testing",
+	"edge":    "edge",
+}
+
+// Channel is an update channel of the system config
+type Channel struct {
+	Name     string `json:"name"`
+	Target   string `json:"target"`              // Tag or reference template, as configured
+	ImageRef string `json:"image_ref,omitempty"` // Target resolved against the installed image
+	Active   bool   `json:"active,omitempty"`
+	Error    string `json:"error,omitempty"` // Why the target does not resolve
+}
+
+// channelTargets returns the channels of config: the defaults, replaced and
+// extended by the configured ones
+func (c *SystemConfig) channelTargets() map[string]string {
+	targets := make(map[string]string, len(DefaultChannels)+len(c.Channels))
+	for name, target := range DefaultChannels {
+		targets[name] = target
+	}
+	for name, target := range c.Channels {
+		targets[name] = target
+	}
+	return targets
+}
+
+// resolveChannelTarget returns the image reference target means for a
+// machine with imageRef installed
+func resolveChannelTarget(imageRef, target string) (string, error) {
+	if target == "" {
+		return "", fmt.Errorf("%w: empty channel target", ErrInvalidImageReference)
+	}
+	ref, err := name.ParseReference(imageRef)
+	if err != nil {
+		return "", fmt.Errorf("%w: %w", ErrInvalidImageReference, err)
+	}
+	repo := repositoryName(ref)
+
+	var resolved string
+	if strings.ContainsAny(target, "/:@{") {
+		resolved = strings.NewReplacer("{repository}", repo, "{arch}", runtime.GOARCH).Replace(target)
+	} else {
+		resolved = repo + ":" + target
+	}
+	if _, err := name.ParseReference(resolved); err != nil {
+		return "", fmt.Errorf("%w: channel target %q: %w", ErrInvalidImageReference, target, err)
+	}
+	return resolved, nil
+}
+
+// ListChannels returns the channels of config sorted by name
+func ListChannels(config *SystemConfig) []Channel {
+	var channels []Channel
+	for name, target := range config.channelTargets() {
+		ch := Channel{Name: name, Target: target, Active: name == config.Channel}
+		if ref, err := resolveChannelTarget(config.ImageRef, target); err != nil {
+			ch.Error = err.Error()
+		} else {
+			ch.ImageRef = ref
+		}
+		channels = append(channels, ch)
+	}
+	sort.Slice(channels, func(i, j int) bool { return channels[i].Name < channels[j].Name })
+	return channels
+}
+
+// ChannelImageRef returns the image updates of config install: the image of
+// the active channel, or the installed image if it follows none
+func (c *SystemConfig) ChannelImageRef() (string, error) {
+	if c.Channel == "" {
+		return c.ImageRef, nil
+	}
+	target, ok := c.channelTargets()[c.Channel]
+	if !ok {
+		return "", fmt.Errorf("%w: unknown channel %q", ErrInvalidImageReference, c.Channel)
+	}
+	return resolveChannelTarget(c.ImageRef, target)
+}
+
+// sameImageRef reports whether a and b name the same image, such as fedora
+// and docker.io/library/fedora:latest
+func sameImageRef(a, b string) bool {
+	refA, errA := name.ParseReference(a)
+	refB, errB := name.ParseReference(b)
+	if errA != nil || errB != nil {
+		return a == b
+	}
+	return refA.Name() == refB.Name()
+}
+
+// SwitchChannel makes the machine follow the channel called channel from the
+// next update on, after checking that its image exists unless skipCheck is
+// set. It returns the channel.
+func SwitchChannel(ctx context.Context, channel string, skipCheck, dryRun bool) (*Channel, error) {
+	config, err := ReadSystemConfig()
+	if err != nil {
+		return nil, err
+	}
+	target, ok := config.channelTargets()[channel]
+	if !ok {
+		return nil, fmt.Errorf("%w: unknown channel %q (see 'phukit channel list')", ErrInvalidImageReference, channel)
+	}
+	imageRef, err := resolveChannelTarget(config.ImageRef, target)
+	if err != nil {
+		return nil, err
+	}
+	if !skipCheck {
+		if _, err := GetRemoteImageDigest(ctx, imageRef); err != nil {
+			return nil, fmt.Errorf("channel %s: %w", channel, err)
+		}
+	}
+	if config.PinnedDigest != "" && !sameImageRef(imageRef, config.ImageRef) {
+		fmt.Printf("  Dropping the pin of %s to %s\n", config.ImageRef, config.PinnedDigest)
+	}
+	if err := SetSystemConfigChannel(channel, imageRef, dryRun); err != nil {
+		return nil, err
+	}
+	return &Channel{Name: channel, Target: target, ImageRef: imageRef, Active: true}, nil
+}
@@ -0,0 +1,90 @@
+package pkg
+
+import (
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestResolveChannelTarget(t *testing.T) {
+	for _, tt := range []struct {
+		imageRef, target, want string
+	}{
+		{"quay.io/example/os:latest", "stable", "quay.io/example/os:stable"},
+		{"quay.io/example/os@sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef", "42", "quay.io/example/os:42"},
+		{"fedora", "testing", "docker.io/library/fedora:testing"},
+		{"quay.io/example/os:latest", "quay.io/example/os-lts:latest", "quay.io/example/os-lts:latest"},
+		{"quay.io/example/os:latest", "{repository}:nightly-{arch}", "quay.io/example/os:nightly-" + runtime.GOARCH},
+	} {
+		got, err := resolveChannelTarget(tt.imageRef, tt.target)
+		if err != nil || got != tt.want {
+			t.Errorf("resolveChannelTarget(%s, %s) = %s, %v, want %s", tt.imageRef, tt.target, got, err, tt.want)
+		}
+	}
+	for _, target := range []string{"", "not a tag", "{repository}:bad tag"} {
+		if _, err := resolveChannelTarget("quay.io/example/os:latest", target); ErrorCode(err) != CodeInvalidImageReference {
+			t.Errorf("resolveChannelTarget(%q) error = %v, want %s", target, err, CodeInvalidImageReference)
+		}
+	}
+}
+
+func TestChannelImageRef(t *testing.T) {
+	config := &SystemConfig{ImageRef: "quay.io/example/os:stable"}
+	if got, err := config.ChannelImageRef(); err != nil || got != config.ImageRef {
+		t.Errorf("ChannelImageRef() without a channel = %s, %v, want %s", got, err, config.ImageRef)
+	}
+
+	config.Channel = "edge"
+	if got, err := config.ChannelImageRef(); err != nil || got != "quay.io/example/os:edge" {
+		t.Errorf("ChannelImageRef() = %s, %v, want the edge tag", got, err)
+	}
+
+	// Configured channels replace the defaults
+	config.Channels = map[string]string{"edge": "{repository}:nightly", "lts": "quay.io/example/os-lts:10"}
+	if got, _ := config.ChannelImageRef(); got != "quay.io/example/os:nightly" {
+		t.Errorf("ChannelImageRef() of a configured channel = %s", got)
+	}
+
+	config.Channel = "beta"
+	if _, err := config.ChannelImageRef(); err == nil {
+		t.Error("ChannelImageRef() of an unknown channel succeeded")
+	}
+}
+
+func TestListChannels(t *testing.T) {
+	config := &SystemConfig{
+		ImageRef: "quay.io/example/os:testing",
+		Channel:  "testing",
+		Channels: map[string]string{"lts": "quay.io/example/os-lts:10", "broken": "bad tag"},
+	}
+	var names []string
+	for _, ch := range ListChannels(config) {
+		names = append(names, ch.Name)
+		switch ch.Name {
+		case "testing":
+			if !ch.Active || ch.ImageRef != "quay.io/example/os:testing" {
+				t.Errorf("channel testing = %+v, want active", ch)
+			}
+		case "broken":
+			if ch.Error == "" || ch.ImageRef != "" {
+				t.Errorf("channel broken = %+v, want an error", ch)
+			}
+		default:
+			if ch.Active {
+				t.Errorf("channel %s is active", ch.Name)
+			}
+		}
+	}
+	if want := "broken edge lts stable testing"; strings.Join(names, " ") != want {
+		t.Errorf("ListChannels() = %v, want %s", names, want)
+	}
+}
+
+func TestSameImageRef(t *testing.T) {
+	if !sameImageRef("fedora", "docker.io/library/fedora:latest") {
+		t.Error("sameImageRef() of the same image in two spellings = false")
+	}
+	if sameImageRef("quay.io/example/os:stable", "quay.io/example/os:edge") {
+		t.Error("sameImageRef() of two tags = true")
+	}
+}
@@ -0,0 +1,125 @@
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// CloudProfile holds the disk image settings a cloud expects
+type CloudProfile struct {
+	Name         string
+	Format       string   // Disk image format the cloud imports
+	KernelArgs   []string // Serial console and storage settings
+	Units        []string // Agents to enable if the image ships them
+	UploadPrefix string   // Upload destinations start with this
+	NextStep     string   // How to register an uploaded image, with %s for its location
+}
+
+// cloudInitUnits start cloud-init, which every profile enables
+var cloudInitUnits = []string{"cloud-init-local.service", "cloud-init.service", "cloud-config.service", "cloud-final.service"}
+
+// cloudProfiles are the supported clouds by name
+var cloudProfiles = map[string]CloudProfile{
+	"aws": {
+		Name:         "aws",
+		Format:       DiskImageRaw,
+		KernelArgs:   []string{"console=tty0", "console=ttyS0,115200n8", "nvme_core.io_timeout=4294967295"},
+		Units:        append(append([]string{}, cloudInitUnits...), "amazon-ssm-agent.service"),
+		UploadPrefix: "s3://",
+		NextStep:     "aws ec2 import-snapshot --disk-container Format=raw,Url=%s",
+	},
+	"azure": {
+		Name:         "azure",
+		Format:       DiskImageVHD,
+		KernelArgs:   []string{"console=tty1", "console=ttyS0,115200n8", "earlyprintk=ttyS0", "rootdelay=300"},
+		Units:        append(append([]string{}, cloudInitUnits...), "waagent.service"),
+		UploadPrefix: "https://",
+		NextStep:     "az image create --os-type Linux --hyper-v-generation V2 --source %s --name <name> --resource-group <group>",
+	},
+	"gce": {
+		Name:         "gce",
+		Format:       DiskImageTarGz,
+		KernelArgs:   []string{"console=ttyS0,38400n8"},
+		Units:        append(append([]string{}, cloudInitUnits...), "google-guest-agent.service"),
+		UploadPrefix: "gs://",
+		NextStep:     "gcloud compute images create <name> --guest-os-features=UEFI_COMPATIBLE --source-uri %s",
+	},
+}
+
+// CloudProfileNames returns the names of the supported clouds
+func CloudProfileNames() []string {
+	names := make([]string, 0, len(cloudProfiles))
+	for name := range cloudProfiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// GetCloudProfile returns the profile of the named cloud
+func GetCloudProfile(name string) (*CloudProfile, error) {
+	p, ok := cloudProfiles[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown cloud %q (supported: %s)", name, strings.Join(CloudProfileNames(), ", "))
+	}
+	return &p, nil
+}
+
+// Apply sets the profile's format, kernel arguments and agents on d, and
+// makes /var grow into the disk size chosen when an instance is created
+func (p *CloudProfile) Apply(d *DiskImageBuilder) {
+	d.Format = p.Format
+	d.KernelArgs = append(append([]string{}, p.KernelArgs...), d.KernelArgs...)
+	d.EnableUnits = append(d.EnableUnits, p.Units...)
+	d.GrowVar = true
+}
+
+// CheckUploadDestination checks that dest is in the cloud's object storage,
+// so a bad destination fails before the image is built
+func (p *CloudProfile) CheckUploadDestination(dest string) error {
+	_, err := p.uploadCommand("", dest)
+	return err
+}
+
+// uploadCommand returns the command that copies path to dest in the cloud's
+// object storage
+func (p *CloudProfile) uploadCommand(path, dest string) (Command, error) {
+	if !strings.HasPrefix(dest, p.UploadPrefix) {
+		return Command{}, fmt.Errorf("%s images are uploaded to a %s... location, not %s", p.Name, p.UploadPrefix, dest)
+	}
+	switch p.Name {
+	case "aws":
+		return Command{Name: "aws", Args: []string{"s3", "cp", path, dest}}, nil
+	case "azure":
+		// Azure imports VHDs from page blobs
+		return Command{Name: "az", Args: []string{"storage", "blob", "upload", "--file", path, "--blob-url", dest, "--type", "page", "--overwrite"}}, nil
+	case "gce":
+		return Command{Name: "gcloud", Args: []string{"storage", "cp", path, dest}}, nil
+	}
+	return Command{}, fmt.Errorf("uploading to %s is not supported", p.Name)
+}
+
+// Upload copies the disk image at path to dest in the cloud's object storage
+// with the cloud's CLI, which must be installed and logged in
+func (p *CloudProfile) Upload(ctx context.Context, path, dest string, dryRun bool) error {
+	cmd, err := p.uploadCommand(path, dest)
+	if err != nil {
+		return err
+	}
+	if _, err := executor.LookPath(cmd.Name); err != nil {
+		return fmt.Errorf("%w: %s - install and log in to the %s CLI", ErrMissingTool, cmd.Name, p.Name)
+	}
+	if dryRun {
+		fmt.Printf("[DRY RUN] Would run: %s\n", cmd)
+		return nil
+	}
+
+	fmt.Printf("Uploading %s to %s...\n", path, dest)
+	if out, err := runCommand(ctx, cmd.Name, cmd.Args...); err != nil {
+		return fmt.Errorf("failed to upload disk image: %w\nOutput: %s", err, strings.TrimSpace(string(out)))
+	}
+	fmt.Printf("Uploaded. Register the image with:\n  %s\n", fmt.Sprintf(p.NextStep, dest))
+	return nil
+}
@@ -0,0 +1,52 @@
+package pkg
+
+import (
+	"context"
+	"testing"
+)
+
+func TestCloudProfileApply(t *testing.T) {
+	if _, err := GetCloudProfile("openstack"); err == nil {
+		t.Error("GetCloudProfile(openstack) expected error")
+	}
+
+	p, err := GetCloudProfile("azure")
+	if err != nil {
+		t.Fatalf("GetCloudProfile(azure) error = %v", err)
+	}
+	d := NewDiskImageBuilder("localhost/test", "disk.vhd")
+	d.KernelArgs = []string{"quiet"}
+	p.Apply(d)
+
+	if d.Format != DiskImageVHD {
+		t.Errorf("Format = %q, want %q", d.Format, DiskImageVHD)
+	}
+	if !d.GrowVar {
+		t.Error("GrowVar not set")
+	}
+	if d.KernelArgs[0] != "console=tty1" || d.KernelArgs[len(d.KernelArgs)-1] != "quiet" {
+		t.Errorf("KernelArgs = %v, want profile arguments before the user's", d.KernelArgs)
+	}
+	if d.EnableUnits[len(d.EnableUnits)-1] != "waagent.service" {
+		t.Errorf("EnableUnits = %v, want the Azure agent", d.EnableUnits)
+	}
+}
+
+func TestCloudProfileUpload(t *testing.T) {
+	fake, _ := setupDryRunExecutor(t)
+
+	p, err := GetCloudProfile("gce")
+	if err != nil {
+		t.Fatalf("GetCloudProfile(gce) error = %v", err)
+	}
+	if err := p.CheckUploadDestination("s3://bucket/disk.tar.gz"); err == nil {
+		t.Error("CheckUploadDestination() expected error for an S3 destination")
+	}
+	if err := p.Upload(context.Background(), "disk.tar.gz", "gs://bucket/disk.tar.gz", false); err != nil {
+		t.Fatalf("Upload() error = %v", err)
+	}
+	want := "gcloud storage cp disk.tar.gz gs://bucket/disk.tar.gz"
+	if len(fake.Commands) != 1 || fake.Commands[0].String() != want {
+		t.Errorf("commands = %v, want %q", fake.Commands, want)
+	}
+}
@@ -0,0 +1,150 @@
+package pkg
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// composefs roots
+//
+// With composefs the root partition keeps /etc and the top-level directories,
+// but not the contents of /usr. Every file in /usr is stored once, named by
+// its fs-verity digest, in an object store on the shared /var partition, so
+// files that do not change between the images in the A and B slots take no
+// extra space. The root partition holds an EROFS image with the metadata of
+// /usr that points into the store, and the initramfs mounts it over /usr with
+// its digest from the kernel command line, so a changed image or object fails
+// to mount or read.
+
+// ComposefsObjectsPath is the object store shared by both roots
+const ComposefsObjectsPath = "/var/lib/phukit/composefs/objects"
+
+// ComposefsImagesFile records the image digest of each composefs root,
+// relative to the boot partition
+const ComposefsImagesFile = "phukit/composefs.json"
+
+// composefsImagePath is the composefs image of /usr, relative to the root
+const composefsImagePath = "composefs/usr.cfs"
+
+// CheckComposefsTools checks that the composefs tools are installed
+func CheckComposefsTools() error {
+	for _, tool := range []string{"mkcomposefs", "composefs-info"} {
+		if _, err := executor.LookPath(tool); err != nil {
+			return fmt.Errorf("%w: %s - install the composefs package", ErrMissingTool, tool)
+		}
+	}
+	return nil
+}
+
+// buildComposefs adds the files in /usr of the root at root to the object
+// store at objectsDir and writes the composefs image of /usr to the root. It
+// returns the image digest. /usr is left in place for the bootloader to copy
+// the kernel from; dropComposefsTree removes it.
+func buildComposefs(ctx context.Context, root, objectsDir string) (string, error) {
+	fmt.Println("  Building composefs image of /usr...")
+	if err := os.MkdirAll(objectsDir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create composefs object store: %w", err)
+	}
+	image := filepath.Join(root, composefsImagePath)
+	if err := os.MkdirAll(filepath.Dir(image), 0755); err != nil {
+		return "", fmt.Errorf("failed to create %s: %w", filepath.Dir(image), err)
+	}
+
+	var out, stderr bytes.Buffer
+	err := executor.Run(ctx, Command{
+		Name:   "mkcomposefs",
+		Args:   []string{"--digest-store=" + objectsDir, "--print-digest", filepath.Join(root, "usr"), image},
+		Stdout: &out,
+		Stderr: &stderr,
+	})
+	if err != nil {
+		return "", fmt.Errorf("mkcomposefs failed: %w\nOutput: %s", err, stderr.String())
+	}
+	digest := strings.TrimSpace(out.String())
+	if digest == "" {
+		return "", fmt.Errorf("mkcomposefs printed no image digest")
+	}
+	fmt.Printf("  Image digest: %s\n", digest)
+	return digest, nil
+}
+
+// dropComposefsTree removes the contents of /usr from the root at root,
+// leaving the empty directory the composefs image is mounted on
+func dropComposefsTree(root string) error {
+	usr := filepath.Join(root, "usr")
+	entries, err := os.ReadDir(usr)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", usr, err)
+	}
+	for _, entry := range entries {
+		if err := os.RemoveAll(filepath.Join(usr, entry.Name())); err != nil {
+			return fmt.Errorf("failed to remove %s from the root: %w", entry.Name(), err)
+		}
+	}
+	return nil
+}
+
+// composefsObjects returns the objects the composefs image at image refers
+// to, as paths relative to the object store
+func composefsObjects(ctx context.Context, image string) (map[string]bool, error) {
+	var out, stderr bytes.Buffer
+	err := executor.Run(ctx, Command{Name: "composefs-info", Args: []string{"objects", image}, Stdout: &out, Stderr: &stderr})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list objects of %s: %w\nOutput: %s", image, err, stderr.String())
+	}
+	objects := map[string]bool{}
+	scanner := bufio.NewScanner(&out)
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" {
+			objects[filepath.Clean(line)] = true
+		}
+	}
+	return objects, nil
+}
+
+// pruneComposefsObjects removes the objects in objectsDir that none of the
+// given composefs images refer to. It returns the number of objects removed.
+func pruneComposefsObjects(ctx context.Context, objectsDir string, images ...string) (int, error) {
+	keep := map[string]bool{}
+	for _, image := range images {
+		objects, err := composefsObjects(ctx, image)
+		if err != nil {
+			return 0, err
+		}
+		for object := range objects {
+			keep[object] = true
+		}
+	}
+
+	removed := 0
+	err := filepath.WalkDir(objectsDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(objectsDir, path)
+		if err != nil {
+			return err
+		}
+		if keep[rel] {
+			return nil
+		}
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+		removed++
+		return nil
+	})
+	if err != nil {
+		return removed, fmt.Errorf("failed to prune composefs objects: %w", err)
+	}
+	return removed, nil
+}
@@ -0,0 +1,72 @@
+package pkg
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestBuildComposefs(t *testing.T) {
+	fake := setupScriptedExecutor(t)
+	fake.answer("mkcomposefs", "a1b2c3\n")
+
+	root := t.TempDir()
+	writeFakeFile(t, filepath.Join(root, "usr", "bin", "sh"), "#!/bin/sh\n")
+	objects := filepath.Join(root, "var", "lib", "phukit", "composefs", "objects")
+
+	digest, err := buildComposefs(context.Background(), root, objects)
+	if err != nil {
+		t.Fatalf("buildComposefs() error = %v", err)
+	}
+	if digest != "a1b2c3" {
+		t.Errorf("digest = %q, want a1b2c3", digest)
+	}
+	want := "mkcomposefs --digest-store=" + objects + " --print-digest " + filepath.Join(root, "usr") + " " + filepath.Join(root, "composefs", "usr.cfs")
+	if len(fake.Commands) != 1 || fake.Commands[0].String() != want {
+		t.Errorf("commands = %v, want %q", fake.Commands, want)
+	}
+	if _, err := os.Stat(objects); err != nil {
+		t.Errorf("object store not created: %v", err)
+	}
+
+	if err := dropComposefsTree(root); err != nil {
+		t.Fatalf("dropComposefsTree() error = %v", err)
+	}
+	entries, err := os.ReadDir(filepath.Join(root, "usr"))
+	if err != nil {
+		t.Fatalf("/usr removed: %v", err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("/usr still has %d entries", len(entries))
+	}
+}
+
+func TestPruneComposefsObjects(t *testing.T) {
+	fake := setupScriptedExecutor(t)
+	fake.answer("composefs-info objects /a.cfs", "00/shared\n11/only-a\n")
+	fake.answer("composefs-info objects /b.cfs", "00/shared\n22/only-b\n")
+
+	objects := t.TempDir()
+	for _, name := range []string{"00/shared", "11/only-a", "22/only-b", "33/unused", "44/unused"} {
+		writeFakeFile(t, filepath.Join(objects, name), name)
+	}
+
+	removed, err := pruneComposefsObjects(context.Background(), objects, "/a.cfs", "/b.cfs")
+	if err != nil {
+		t.Fatalf("pruneComposefsObjects() error = %v", err)
+	}
+	if removed != 2 {
+		t.Errorf("removed = %d, want 2", removed)
+	}
+	for _, name := range []string{"00/shared", "11/only-a", "22/only-b"} {
+		if _, err := os.Stat(filepath.Join(objects, name)); err != nil {
+			t.Errorf("%s removed: %v", name, err)
+		}
+	}
+	for _, name := range []string{"33/unused", "44/unused"} {
+		if _, err := os.Stat(filepath.Join(objects, name)); !os.IsNotExist(err) {
+			t.Errorf("%s not removed", name)
+		}
+	}
+}
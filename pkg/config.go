@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 )
 
 const (
@@ -24,15 +25,58 @@ const (
 
 // SystemConfig represents the system configuration stored in /etc/phukit/
 type SystemConfig struct {
-	ImageRef       string   `json:"image_ref"`       // Container image reference
-	ImageDigest    string   `json:"image_digest"`    // Container image digest (sha256:...)
-	Device         string   `json:"device"`          // Installation device
-	InstallDate    string   `json:"install_date"`    // Installation timestamp
-	KernelArgs     []string `json:"kernel_args"`     // Custom kernel arguments
-	BootloaderType string   `json:"bootloader_type"` // Bootloader type (grub2, systemd-boot)
-	FilesystemType string   `json:"filesystem_type"` // Filesystem type (ext4, btrfs)
+	ImageRef       string   `json:"image_ref"`              // Container image reference
+	ImageDigest    string   `json:"image_digest"`           // Container image digest (sha256:...)
+	Device         string   `json:"device"`                 // Installation device
+	InstallDate    string   `json:"install_date"`           // Installation timestamp
+	KernelArgs     []string `json:"kernel_args"`            // Custom kernel arguments
+	BootloaderType string   `json:"bootloader_type"`        // Bootloader type (grub2, systemd-boot)
+	FilesystemType string   `json:"filesystem_type"`        // Filesystem type (ext4, btrfs)
+	Verity         bool     `json:"verity,omitempty"`       // Roots are read-only and protected by dm-verity
+	Composefs      bool     `json:"composefs,omitempty"`    // /usr is a composefs image over the shared object store
+	WritableUsr    bool     `json:"writable_usr,omitempty"` // /usr is not mounted read-only
+	ResumeUUID     string   `json:"resume_uuid,omitempty"`  // Swap partition the system resumes from after hibernation
+
+	// Mount options of the root and /var filesystems, by mount point
+	MountOptions MountOptions `json:"mount_options,omitempty"`
+
+	// How generated mounts name filesystems (uuid, label) and where /var
+	// is mounted from (cmdline, fstab, systemd); empty for the defaults
+	MountIdentifier MountIdentifier `json:"mount_identifier,omitempty"`
+	VarMount        VarMount        `json:"var_mount,omitempty"`
+
+	// Extra boot entries of the default root
+	BootProfiles []BootProfile `json:"boot_profiles,omitempty"`
+
+	// Serial port GRUB uses as a terminal next to the screen
+	SerialConsole *SerialConsole `json:"serial_console,omitempty"`
+
+	// Bootloader lockdown against editing the kernel command line
+	Lockdown *BootLockdown `json:"lockdown,omitempty"`
+
+	// Partitions of a root installed with 'install to-filesystem', whose
+	// layout cannot be detected from partition numbers
+	Partitions *PartitionScheme `json:"partitions,omitempty"`
+
+	// Pinning holds updates to a digest even if ImageRef's tag moves
+	PinnedDigest string `json:"pinned_digest,omitempty"` // Empty if not pinned
+	PinPolicy    string `json:"pin_policy,omitempty"`    // What update does when the tag moves off the pin
+
+	// Webhooks are notified when an update or rollback finishes
+	Webhooks []WebhookConfig `json:"webhooks,omitempty"`
+
+	// Update channels (tags or reference templates) by name, and the channel
+	// updates follow; empty to update ImageRef itself
+	Channels map[string]string `json:"channels,omitempty"`
+	Channel  string            `json:"channel,omitempty"`
 }
 
+// Pin policies: what update does when the image tag no longer points at the pinned digest
+const (
+	PinPolicyWarn = "warn" // Warn and stay on the pinned digest (default)
+	PinPolicyFail = "fail" // Fail the update
+)
+
 // WriteSystemConfig writes system configuration to /etc/phukit/config.json
 func WriteSystemConfig(config *SystemConfig, dryRun bool) error {
 	if dryRun {
@@ -62,10 +106,16 @@ func WriteSystemConfig(config *SystemConfig, dryRun bool) error {
 
 // ReadSystemConfig reads system configuration from /etc/phukit/config.json
 func ReadSystemConfig() (*SystemConfig, error) {
-	data, err := os.ReadFile(SystemConfigFile)
+	return readSystemConfigFile(SystemConfigFile)
+}
+
+// readSystemConfigFile reads the system configuration at path, such as the
+// config.json of another root
+func readSystemConfigFile(path string) (*SystemConfig, error) {
+	data, err := os.ReadFile(path)
 	if err != nil {
 		if os.IsNotExist(err) {
-			return nil, fmt.Errorf("system configuration not found at %s (system may not be installed with phukit)", SystemConfigFile)
+			return nil, fmt.Errorf("system configuration not found at %s (system may not be installed with phukit)", path)
 		}
 		return nil, fmt.Errorf("failed to read config file: %w", err)
 	}
@@ -141,3 +191,98 @@ func UpdateSystemConfigImageRef(imageRef, imageDigest string, dryRun bool) error
 	}
 	return nil
 }
+
+// SetSystemConfigPin records the pinned digest and pin policy in the system
+// config. An empty digest removes the pin.
+func SetSystemConfigPin(digest, policy string, dryRun bool) error {
+	if dryRun {
+		fmt.Printf("[DRY RUN] Would pin image to %s\n", digest)
+		return nil
+	}
+
+	config, err := ReadSystemConfig()
+	if err != nil {
+		return err
+	}
+
+	config.PinnedDigest = digest
+	config.PinPolicy = policy
+	if digest == "" {
+		config.PinPolicy = ""
+	}
+
+	data, err := json.MarshalIndent(config, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal config: %w", err)
+	}
+
+	if err := os.WriteFile(SystemConfigFile, data, 0644); err != nil {
+		return fmt.Errorf("failed to write config file: %w", err)
+	}
+
+	if digest != "" {
+		fmt.Printf("  Pinned %s to %s\n", config.ImageRef, digest)
+	}
+	return nil
+}
+
+// SetSystemConfigChannel records the channel updates follow and its image.
+// Switching to another image drops the pin, which only holds its tag.
+func SetSystemConfigChannel(channel, imageRef string, dryRun bool) error {
+	if dryRun {
+		fmt.Printf("[DRY RUN] Would follow channel %s (%s)\n", channel, imageRef)
+		return nil
+	}
+
+	config, err := ReadSystemConfig()
+	if err != nil {
+		return err
+	}
+
+	if !sameImageRef(imageRef, config.ImageRef) {
+		config.PinnedDigest = ""
+		config.PinPolicy = ""
+	}
+	config.Channel = channel
+	config.ImageRef = imageRef
+
+	data, err := json.MarshalIndent(config, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal config: %w", err)
+	}
+
+	if err := os.WriteFile(SystemConfigFile, data, 0644); err != nil {
+		return fmt.Errorf("failed to write config file: %w", err)
+	}
+
+	fmt.Printf("  Following channel %s: %s\n", channel, imageRef)
+	return nil
+}
+
+// SetSystemConfigKernelArgs records the persistent kernel arguments in the
+// system config
+func SetSystemConfigKernelArgs(args []string, dryRun bool) error {
+	if dryRun {
+		fmt.Printf("[DRY RUN] Would record kernel arguments: %s\n", strings.Join(args, " "))
+		return nil
+	}
+
+	config, err := ReadSystemConfig()
+	if err != nil {
+		return err
+	}
+
+	config.KernelArgs = args
+
+	data, err := json.MarshalIndent(config, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal config: %w", err)
+	}
+
+	if err := os.WriteFile(SystemConfigFile, data, 0644); err != nil {
+		return fmt.Errorf("failed to write config file: %w", err)
+	}
+
+	fmt.Printf("  Recorded kernel arguments in %s\n", SystemConfigFile)
+	return nil
+}
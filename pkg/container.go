@@ -2,16 +2,12 @@ package pkg
 
 import (
 	"archive/tar"
+	"context"
 	"fmt"
 	"io"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"strings"
-
-	"github.com/google/go-containerregistry/pkg/authn"
-	"github.com/google/go-containerregistry/pkg/name"
-	"github.com/google/go-containerregistry/pkg/v1/remote"
 )
 
 // ContainerExtractor handles extracting container images to disk
@@ -19,6 +15,7 @@ type ContainerExtractor struct {
 	ImageRef  string
 	TargetDir string
 	Verbose   bool
+	Stats     *ExtractStats // Statistics from the last Extract() call
 }
 
 // NewContainerExtractor creates a new ContainerExtractor
@@ -34,59 +31,45 @@ func (c *ContainerExtractor) SetVerbose(verbose bool) {
 	c.Verbose = verbose
 }
 
-// Extract extracts the container filesystem to the target directory using go-containerregistry
-func (c *ContainerExtractor) Extract() error {
-	fmt.Printf("Extracting container image %s...\n", c.ImageRef)
-
-	// Parse image reference
-	ref, err := name.ParseReference(c.ImageRef)
+// Extract extracts the container filesystem to the target directory using the
+// container source selected with SetContainerSource
+// Cancelling ctx aborts the pull and stops extraction before the next layer.
+// Images the image policy rejects are not extracted; of a signed image, the
+// digest whose signatures were verified is extracted.
+func (c *ContainerExtractor) Extract(ctx context.Context) error {
+	imageRef, err := CheckImagePolicy(ctx, c.ImageRef)
 	if err != nil {
-		return fmt.Errorf("failed to parse image reference: %w", err)
+		return err
 	}
+	fmt.Printf("Extracting container image %s...\n", imageRef)
 
-	// Pull image
-	fmt.Println("  Pulling image...")
-	img, err := remote.Image(ref, remote.WithAuthFromKeychain(authn.DefaultKeychain))
+	source, err := resolveContainerSource()
 	if err != nil {
-		return fmt.Errorf("failed to pull image: %w", err)
+		return err
 	}
-
-	// Get image layers
-	fmt.Println("  Extracting layers...")
-	layers, err := img.Layers()
-	if err != nil {
-		return fmt.Errorf("failed to get image layers: %w", err)
+	if source.Name() != ContainerSourceBuiltin {
+		fmt.Printf("  Using container source: %s\n", source.Name())
 	}
 
-	// Extract each layer
-	for i, layer := range layers {
-		if c.Verbose {
-			digest, _ := layer.Digest()
-			fmt.Printf("  Extracting layer %d/%d (%s)...\n", i+1, len(layers), digest)
-		}
-
-		// Get layer contents as tar stream
-		rc, err := layer.Uncompressed()
-		if err != nil {
-			return fmt.Errorf("failed to decompress layer %d: %w", i, err)
-		}
-
-		// Extract tar contents to target directory
-		if err := extractTar(rc, c.TargetDir); err != nil {
-			_ = rc.Close()
-			return fmt.Errorf("failed to extract layer %d: %w", i, err)
-		}
-		if err := rc.Close(); err != nil {
-			return fmt.Errorf("failed to close layer %d: %w", i, err)
-		}
+	stats := NewExtractStats()
+	if err := source.Extract(ctx, imageRef, c.TargetDir, stats, c.Verbose); err != nil {
+		return err
 	}
 
+	c.Stats = stats
+	stats.Print(c.Verbose)
 	fmt.Println("Container filesystem extracted successfully")
 	return nil
 }
 
 // extractTar extracts a tar stream to a target directory
 func extractTar(r io.Reader, targetDir string) error {
+	return extractTarWithStats(r, targetDir, NewExtractStats())
+}
+
+// extractTarWithStats extracts a tar stream to a target directory, accumulating
+// file counts and sizes into stats
+func extractTarWithStats(r io.Reader, targetDir string, stats *ExtractStats) error {
 	tr := tar.NewReader(r)
 
 	for {
@@ -131,6 +114,7 @@ func extractTar(r io.Reader, targetDir string) error {
 			if err := os.MkdirAll(targetDir, 0755); err != nil {
 				return fmt.Errorf("failed to recreate directory after opaque whiteout %s: %w", targetDir, err)
 			}
+			stats.Whiteouts++
 			continue
 		}
 
@@ -145,9 +129,12 @@ func extractTar(r io.Reader, targetDir string) error {
 			if err := os.RemoveAll(whiteoutTarget); err != nil && !os.IsNotExist(err) {
 				return fmt.Errorf("failed to remove whiteout target %s: %w", whiteoutTarget, err)
 			}
+			stats.Whiteouts++
 			continue
 		}
 
+		stats.record(header)
+
 		switch header.Typeflag {
 		case tar.TypeDir:
 			if err := os.MkdirAll(target, 0755); err != nil {
@@ -278,32 +265,74 @@ func extractTar(r io.Reader, targetDir string) error {
 	return nil
 }
 
-// CreateFstab creates an /etc/fstab file with the proper mount points
-func CreateFstab(targetDir string, scheme *PartitionScheme) error {
+// CreateFstab creates an /etc/fstab file with the proper mount points and
+// the root's mount options. Filesystems are named as id says, and /var is
+// mounted as varMount says: by an fstab entry, or by a var.mount unit that
+// is written next to it.
+func CreateFstab(targetDir string, scheme *PartitionScheme, options MountOptions, id MountIdentifier, varMount VarMount) error {
 	fmt.Println("Creating /etc/fstab...")
 
-	// Only need root2 UUID for the commented-out alternate root entry
-	root2UUID, err := GetPartitionUUID(scheme.Root2Partition)
+	// Only need root2 for the commented-out alternate root entry
+	root2Source, err := mountSource(scheme.Root2Partition, id)
 	if err != nil {
-		return fmt.Errorf("failed to get root2 UUID: %w", err)
+		return fmt.Errorf("failed to get root2 source: %w", err)
+	}
+
+	fsType := scheme.FilesystemType
+	if fsType == "" {
+		fsType = "ext4"
+	}
+
+	varComment := "mounted via kernel cmdline systemd.mount-extra parameter"
+	switch varMount {
+	case VarMountFstab:
+		varComment = "mounted by the entry below"
+	case VarMountUnit:
+		varComment = "mounted by /etc/systemd/system/" + varMountUnit
 	}
 
 	// Create fstab content
 	// Note: /boot is auto-mounted by systemd-gpt-auto-generator (ESP partition type)
-	// Note: /var is mounted via kernel command line (systemd.mount-extra)
+	// Note: /var is mounted via kernel command line (systemd.mount-extra) unless varMount says otherwise
 	fstabContent := fmt.Sprintf(`# /etc/fstab
 # Created by phukit
 #
 # Most mounts are handled automatically:
-# - Root: specified via kernel cmdline root=UUID parameter
+# - Root: specified via kernel cmdline root=UUID parameter (options: rootflags)
 # - /boot: auto-mounted by systemd (ESP partition type, labeled UEFI)
-# - /var: mounted via kernel cmdline systemd.mount-extra parameter
+# - /var: %s (options: %s)
 #
 # This file is kept minimal and can be empty on systems with discoverable partitions.
 
 # Second root filesystem (root2 - inactive/alternate)
-# UUID=%s	/		ext4	defaults	0 1
-`, root2UUID)
+# %s	/		%s	%s	0 1
+`, varComment, options.get("/var"), root2Source, fsType, options.get("/"))
+
+	if !varMount.fromCmdline() {
+		varSource, err := mountSource(scheme.VarPartition, id)
+		if err != nil {
+			return fmt.Errorf("failed to get /var source: %w", err)
+		}
+		if varMount == VarMountFstab {
+			fstabContent += fmt.Sprintf(`
+# Variable data
+%s	/var		%s	%s	0 2
+`, varSource, fsType, options.get("/var"))
+		} else if err := writeVarMountUnit(targetDir, varSource, fsType, options.get("/var")); err != nil {
+			return err
+		}
+	}
+
+	if scheme.SwapPartition != "" {
+		swapSource, err := mountSource(scheme.SwapPartition, id)
+		if err != nil {
+			return fmt.Errorf("failed to get swap source: %w", err)
+		}
+		fstabContent += fmt.Sprintf(`
+# Swap partition
+%s	none		swap	defaults	0 0
+`, swapSource)
+	}
 
 	// Write fstab
 	fstabPath := filepath.Join(targetDir, "etc", "fstab")
@@ -344,63 +373,86 @@ func SetupSystemDirectories(targetDir string) error {
 }
 
 // ChrootCommand runs a command in a chroot environment
-func ChrootCommand(targetDir string, command string, args ...string) error {
-	// Mount necessary filesystems for chroot
-	mounts := [][]string{
-		{"mount", "--bind", "/dev", filepath.Join(targetDir, "dev")},
-		{"mount", "--bind", "/proc", filepath.Join(targetDir, "proc")},
-		{"mount", "--bind", "/sys", filepath.Join(targetDir, "sys")},
-		{"mount", "--bind", "/run", filepath.Join(targetDir, "run")},
-	}
+func ChrootCommand(ctx context.Context, targetDir string, command string, args ...string) error {
+	return chrootCommandWithProgress(ctx, targetDir, "", nil, command, args...)
+}
 
-	for _, mount := range mounts {
-		if err := exec.Command(mount[0], mount[1:]...).Run(); err != nil {
-			// Continue even if mount fails (might already be mounted)
-			continue
-		}
+// chrootCommandWithProgress runs a command in a chroot like ChrootCommand.
+// If parser is non-nil the command's output is also mapped into
+// phase_progress events for phase.
+func chrootCommandWithProgress(ctx context.Context, targetDir, phase string, parser toolProgressParser, command string, args ...string) error {
+	// Mount necessary filesystems for chroot
+	apiMounts := []string{"/dev", "/proc", "/sys", "/run"}
+	for _, dir := range apiMounts {
+		// Continue even if mount fails (might already be mounted)
+		_ = bindMount(dir, filepath.Join(targetDir, dir))
 	}
 
-	// Cleanup function to unmount
+	// Cleanup function to unmount in reverse order
 	defer func() {
-		_ = exec.Command("umount", filepath.Join(targetDir, "run")).Run()
-		_ = exec.Command("umount", filepath.Join(targetDir, "sys")).Run()
-		_ = exec.Command("umount", filepath.Join(targetDir, "proc")).Run()
-		_ = exec.Command("umount", filepath.Join(targetDir, "dev")).Run()
+		for i := len(apiMounts) - 1; i >= 0; i-- {
+			_ = unmount(filepath.Join(targetDir, apiMounts[i]))
+		}
 	}()
 
 	// Build chroot command
 	chrootArgs := []string{targetDir, command}
 	chrootArgs = append(chrootArgs, args...)
 
-	cmd := exec.Command("chroot", chrootArgs...)
+	cmd := Command{Name: "chroot", Args: chrootArgs, Stdin: os.Stdin}
+	if parser != nil {
+		_, err := runWithToolProgress(ctx, cmd, phase, parser, os.Stdout)
+		return err
+	}
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
-	cmd.Stdin = os.Stdin
 
-	return cmd.Run()
+	return executor.Run(ctx, cmd)
 }
 
 // ParseOSRelease reads and parses /etc/os-release from the target directory
 // Returns PRETTY_NAME if available, otherwise NAME, otherwise ID, or "Linux" as fallback
 func ParseOSRelease(targetDir string) string {
-	osReleasePath := filepath.Join(targetDir, "etc", "os-release")
+	values := readOSRelease(targetDir)
 
+	// Return in priority order: PRETTY_NAME > NAME > ID > "Linux"
+	if prettyName, ok := values["PRETTY_NAME"]; ok && prettyName != "" {
+		return prettyName
+	}
+	if name, ok := values["NAME"]; ok && name != "" {
+		return name
+	}
+	if id, ok := values["ID"]; ok && id != "" {
+		return id
+	}
+
+	return "Linux"
+}
+
+// osReleaseValue returns one field of the os-release file in targetDir, or ""
+func osReleaseValue(targetDir, key string) string {
+	return readOSRelease(targetDir)[key]
+}
+
+// readOSRelease parses /etc/os-release, or /usr/lib/os-release as fallback,
+// in targetDir. Returns an empty map if neither can be read.
+func readOSRelease(targetDir string) map[string]string {
 	// Try /etc/os-release first, then /usr/lib/os-release as fallback
-	data, err := os.ReadFile(osReleasePath)
+	data, err := os.ReadFile(filepath.Join(targetDir, "etc", "os-release"))
 	if err != nil {
-		osReleasePath = filepath.Join(targetDir, "usr", "lib", "os-release")
-		data, err = os.ReadFile(osReleasePath)
+		data, err = os.ReadFile(filepath.Join(targetDir, "usr", "lib", "os-release"))
 		if err != nil {
 			// File doesn't exist or can't be read
-			return "Linux"
+			return make(map[string]string)
 		}
 	}
+	return parseOSRelease(data)
+}
 
-	// Parse the file for PRETTY_NAME, NAME, or ID
-	lines := strings.Split(string(data), "\n")
+// parseOSRelease parses the KEY=value lines of an os-release file
+func parseOSRelease(data []byte) map[string]string {
 	values := make(map[string]string)
-
-	for _, line := range lines {
+	for _, line := range strings.Split(string(data), "\n") {
 		line = strings.TrimSpace(line)
 		if line == "" || strings.HasPrefix(line, "#") {
 			continue
@@ -420,17 +472,5 @@ func ParseOSRelease(targetDir string) string {
 
 		values[key] = value
 	}
-
-	// Return in priority order: PRETTY_NAME > NAME > ID > "Linux"
-	if prettyName, ok := values["PRETTY_NAME"]; ok && prettyName != "" {
-		return prettyName
-	}
-	if name, ok := values["NAME"]; ok && name != "" {
-		return name
-	}
-	if id, ok := values["ID"]; ok && id != "" {
-		return id
-	}
-
-	return "Linux"
+	return values
 }
@@ -0,0 +1,367 @@
+package pkg
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/name"
+)
+
+// Container sources that can be selected with SetContainerSource
+const (
+	ContainerSourceBuiltin = "builtin" // Pure-Go registry client (default)
+	ContainerSourceAuto    = "auto"    // First available of podman, docker, skopeo+umoci
+	ContainerSourcePodman  = "podman"
+	ContainerSourceDocker  = "docker"
+	ContainerSourceSkopeo  = "skopeo" // skopeo to fetch, umoci to unpack
+)
+
+// ContainerSource pulls container images and unpacks their root filesystem
+type ContainerSource interface {
+	// Name identifies the source in messages
+	Name() string
+	// Pull makes sure the image is available, fetching it if needed
+	Pull(ctx context.Context, imageRef string, verbose bool) error
+	// Extract unpacks the image filesystem into targetDir, recording into stats
+	Extract(ctx context.Context, imageRef, targetDir string, stats *ExtractStats, verbose bool) error
+}
+
+// containerSourceName is the source used for pulls and extraction
+var containerSourceName = ContainerSourceBuiltin
+
+// SetContainerSource selects how images are pulled and extracted. An empty
+// name selects the builtin registry client.
+func SetContainerSource(name string) error {
+	switch name {
+	case "":
+		name = ContainerSourceBuiltin
+	case ContainerSourceBuiltin, ContainerSourceAuto, ContainerSourcePodman, ContainerSourceDocker, ContainerSourceSkopeo:
+	default:
+		return fmt.Errorf("unknown container source %q (supported: builtin, auto, podman, docker, skopeo)", name)
+	}
+	containerSourceName = name
+	return nil
+}
+
+// runtimeSources lists the external runtimes in the order auto detection tries them
+func runtimeSources() []ContainerSource {
+	return []ContainerSource{
+		runtimeSource{tool: "podman"},
+		runtimeSource{tool: "docker"},
+		skopeoSource{},
+	}
+}
+
+// sourceAvailable reports whether every tool a source needs is installed
+func sourceAvailable(s ContainerSource) bool {
+	var tools []string
+	switch s := s.(type) {
+	case runtimeSource:
+		tools = []string{s.tool}
+	case skopeoSource:
+		tools = []string{"skopeo", "umoci"}
+	}
+	for _, tool := range tools {
+		if _, err := executor.LookPath(tool); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// DetectContainerSource returns the first installed container runtime
+func DetectContainerSource() (ContainerSource, error) {
+	for _, s := range runtimeSources() {
+		if sourceAvailable(s) {
+			return s, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: no container runtime found (install podman, docker, or skopeo and umoci)", ErrMissingTool)
+}
+
+// resolveContainerSource returns the source selected with SetContainerSource
+func resolveContainerSource() (ContainerSource, error) {
+	var source ContainerSource
+	switch containerSourceName {
+	case ContainerSourceAuto:
+		return DetectContainerSource()
+	case ContainerSourcePodman, ContainerSourceDocker:
+		source = runtimeSource{tool: containerSourceName}
+	case ContainerSourceSkopeo:
+		source = skopeoSource{}
+	default:
+		return builtinSource{}, nil
+	}
+	if !sourceAvailable(source) {
+		return nil, fmt.Errorf("%w: container source %s is not available", ErrMissingTool, source.Name())
+	}
+	return source, nil
+}
+
+// pullImage validates and pulls imageRef with the selected container source
+func pullImage(ctx context.Context, imageRef string, verbose bool) error {
+	recordPhase("", "Pulling image")
+	source, err := resolveContainerSource()
+	if err != nil {
+		return err
+	}
+	if verbose && source.Name() != ContainerSourceBuiltin {
+		fmt.Printf("  Using container source: %s\n", source.Name())
+	}
+	return source.Pull(ctx, imageRef, verbose)
+}
+
+// builtinSource pulls directly from the registry with go-containerregistry
+type builtinSource struct{}
+
+// Name implements ContainerSource
+func (builtinSource) Name() string { return ContainerSourceBuiltin }
+
+// Pull only checks that the image is accessible; layers are fetched during Extract
+func (builtinSource) Pull(ctx context.Context, imageRef string, verbose bool) error {
+	ref, err := name.ParseReference(imageRef)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidImageReference, err)
+	}
+
+	if verbose {
+		fmt.Printf("  Image: %s\n", ref.String())
+	}
+
+	// Try to get image descriptor to verify it exists and is accessible
+	// This is a lightweight check that doesn't download layers
+	_, err = remoteHead(ctx, ref)
+	if err != nil {
+		return fmt.Errorf("failed to access image: %w (check credentials if private registry)", registryError(err))
+	}
+
+	fmt.Println("  Image reference is valid and accessible")
+	return nil
+}
+
+// Extract implements ContainerSource by applying each layer in order
+func (builtinSource) Extract(ctx context.Context, imageRef, targetDir string, stats *ExtractStats, verbose bool) error {
+	ref, err := name.ParseReference(imageRef)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidImageReference, err)
+	}
+
+	fmt.Println("  Pulling image...")
+	img, err := remoteImage(ctx, ref)
+	if err != nil {
+		return fmt.Errorf("failed to pull image: %w", registryError(err))
+	}
+
+	fmt.Println("  Extracting layers...")
+	layers, err := img.Layers()
+	if err != nil {
+		return fmt.Errorf("failed to get image layers: %w", err)
+	}
+
+	for i, layer := range layers {
+		if err := ctx.Err(); err != nil {
+			return fmt.Errorf("extraction cancelled: %w", err)
+		}
+		if verbose {
+			digest, _ := layer.Digest()
+			fmt.Printf("  Extracting layer %d/%d (%s)...\n", i+1, len(layers), digest)
+		}
+
+		// Get layer contents as tar stream
+		rc, err := layer.Uncompressed()
+		if err != nil {
+			return fmt.Errorf("failed to decompress layer %d: %w", i, registryError(err))
+		}
+
+		if err := extractTarWithStats(rc, targetDir, stats); err != nil {
+			_ = rc.Close()
+			return fmt.Errorf("failed to extract layer %d: %w", i, err)
+		}
+		if err := rc.Close(); err != nil {
+			return fmt.Errorf("failed to close layer %d: %w", i, err)
+		}
+		stats.Layers++
+		if size, err := layer.Size(); err == nil {
+			stats.DownloadedBytes += size
+		}
+	}
+	return nil
+}
+
+// runtimeSource uses a docker-compatible CLI (podman or docker), which also
+// makes images that only exist in local container storage installable
+type runtimeSource struct {
+	tool string
+}
+
+// Name implements ContainerSource
+func (r runtimeSource) Name() string { return r.tool }
+
+// Pull pulls the image, falling back to a local copy if the pull fails
+func (r runtimeSource) Pull(ctx context.Context, imageRef string, verbose bool) error {
+	out, err := runCommand(ctx, r.tool, "pull", imageRef)
+	if err == nil {
+		fmt.Printf("  Pulled %s with %s\n", imageRef, r.tool)
+		return nil
+	}
+	if ctx.Err() != nil {
+		return cancelled(ctx)
+	}
+	if _, inspectErr := runCommand(ctx, r.tool, "image", "inspect", imageRef); inspectErr == nil {
+		fmt.Printf("  Could not pull %s, using the local copy\n", imageRef)
+		if verbose {
+			fmt.Printf("  %s pull: %s\n", r.tool, strings.TrimSpace(string(out)))
+		}
+		return nil
+	}
+	return fmt.Errorf("failed to pull image with %s: %w\nOutput: %s", r.tool, err, out)
+}
+
+// Extract exports the flattened filesystem of a temporary container
+func (r runtimeSource) Extract(ctx context.Context, imageRef, targetDir string, stats *ExtractStats, verbose bool) error {
+	// Images without a CMD can't be created without a command; it never runs
+	var id, stderr bytes.Buffer
+	if err := executor.Run(ctx, Command{Name: r.tool, Args: []string{"create", imageRef, "true"}, Stdout: &id, Stderr: &stderr}); err != nil {
+		return fmt.Errorf("failed to create container from %s: %w\nOutput: %s", imageRef, err, stderr.String())
+	}
+	container := strings.TrimSpace(id.String())
+	defer func() {
+		// Still remove the container when the operation was cancelled
+		_, _ = runCommand(context.WithoutCancel(ctx), r.tool, "rm", "-f", container)
+	}()
+
+	fmt.Printf("  Exporting filesystem with %s...\n", r.tool)
+	if err := extractCommandOutput(ctx, Command{Name: r.tool, Args: []string{"export", container}}, targetDir, stats); err != nil {
+		return err
+	}
+	stats.Layers = 1
+	return nil
+}
+
+// extractCommandOutput runs cmd and extracts the tar stream it writes to stdout
+func extractCommandOutput(ctx context.Context, cmd Command, targetDir string, stats *ExtractStats) error {
+	// Kill the command if extraction fails so it doesn't block writing
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	pr, pw := io.Pipe()
+	var stderr bytes.Buffer
+	cmd.Stdout = pw
+	cmd.Stderr = &stderr
+
+	done := make(chan error, 1)
+	go func() {
+		err := executor.Run(ctx, cmd)
+		_ = pw.CloseWithError(err)
+		done <- err
+	}()
+
+	extractErr := extractTarWithStats(pr, targetDir, stats)
+	if extractErr != nil {
+		cancel()
+		_ = pr.CloseWithError(extractErr)
+	} else {
+		// Drain trailing padding so the command can exit
+		_, _ = io.Copy(io.Discard, pr)
+	}
+	runErr := <-done
+
+	if extractErr != nil {
+		return fmt.Errorf("failed to extract output of %s: %w", cmd.Name, extractErr)
+	}
+	if runErr != nil {
+		return fmt.Errorf("%s failed: %w\nOutput: %s", cmd, runErr, stderr.String())
+	}
+	return nil
+}
+
+// skopeoSource fetches the image with skopeo into an OCI layout and unpacks
+// it with umoci
+type skopeoSource struct{}
+
+// Name implements ContainerSource
+func (skopeoSource) Name() string { return ContainerSourceSkopeo }
+
+// skopeoTransportRef adds the docker:// transport unless imageRef names one
+func skopeoTransportRef(imageRef string) string {
+	for _, transport := range []string{"docker://", "containers-storage:", "oci:", "oci-archive:", "docker-archive:", "dir:"} {
+		if strings.HasPrefix(imageRef, transport) {
+			return imageRef
+		}
+	}
+	return "docker://" + imageRef
+}
+
+// Pull checks that the image is accessible; it is copied during Extract
+func (skopeoSource) Pull(ctx context.Context, imageRef string, verbose bool) error {
+	if out, err := runCommand(ctx, "skopeo", "inspect", "--raw", skopeoTransportRef(imageRef)); err != nil {
+		return fmt.Errorf("failed to access image with skopeo: %w\nOutput: %s", err, out)
+	}
+	fmt.Println("  Image reference is valid and accessible")
+	return nil
+}
+
+// Extract copies the image to a staging directory on the target, unpacks it
+// there and copies the result into targetDir. Staging on the target keeps
+// large images out of a RAM-backed /tmp in live environments.
+func (skopeoSource) Extract(ctx context.Context, imageRef, targetDir string, stats *ExtractStats, verbose bool) error {
+	staging, err := os.MkdirTemp(targetDir, ".phukit-oci-")
+	if err != nil {
+		return fmt.Errorf("failed to create staging directory: %w", err)
+	}
+	defer func() { _ = os.RemoveAll(staging) }()
+
+	layout := filepath.Join(staging, "image") + ":phukit"
+	rootfs := filepath.Join(staging, "rootfs")
+
+	fmt.Println("  Copying image with skopeo...")
+	if out, err := runCommand(ctx, "skopeo", "copy", skopeoTransportRef(imageRef), "oci:"+layout); err != nil {
+		return fmt.Errorf("failed to copy image with skopeo: %w\nOutput: %s", err, out)
+	}
+
+	fmt.Println("  Unpacking image with umoci...")
+	if out, err := runCommand(ctx, "umoci", "raw", "unpack", "--image", layout, rootfs); err != nil {
+		return fmt.Errorf("failed to unpack image with umoci: %w\nOutput: %s", err, out)
+	}
+
+	if err := recordTreeStats(rootfs, stats); err != nil {
+		return fmt.Errorf("failed to scan unpacked image: %w", err)
+	}
+	if err := copyTree(rootfs, targetDir, false); err != nil {
+		return fmt.Errorf("failed to copy unpacked image: %w", err)
+	}
+	stats.Layers = 1
+	return nil
+}
+
+// recordTreeStats accounts for every entry below root as if it had been
+// extracted from a tar stream
+func recordTreeStats(root string, stats *ExtractStats) error {
+	return filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || path == root {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		link := ""
+		if info.Mode()&os.ModeSymlink != 0 {
+			link, _ = os.Readlink(path)
+		}
+		header, err := tar.FileInfoHeader(info, link)
+		if err != nil {
+			return nil // Sockets and other entries tar can't represent
+		}
+		header.Name, _ = filepath.Rel(root, path)
+		stats.record(header)
+		return nil
+	})
+}
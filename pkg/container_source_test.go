@@ -0,0 +1,154 @@
+package pkg
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// setupFakeRuntime pretends that only the listed tools are installed, with
+// podman and docker answering "create" like the real tools
+func setupFakeRuntime(t *testing.T, tools ...string) *scriptedExecutor {
+	t.Helper()
+	fake := setupScriptedExecutor(t)
+	fake.tools = append([]string{}, tools...)
+	fake.answer("podman create", "abc123\n")
+	fake.answer("docker create", "abc123\n")
+	t.Cleanup(func() { _ = SetContainerSource("") })
+	return fake
+}
+
+func TestSetContainerSource(t *testing.T) {
+	t.Cleanup(func() { _ = SetContainerSource("") })
+
+	for _, name := range []string{"", "builtin", "auto", "podman", "docker", "skopeo"} {
+		if err := SetContainerSource(name); err != nil {
+			t.Errorf("SetContainerSource(%q) failed: %v", name, err)
+		}
+	}
+	if err := SetContainerSource("rkt"); err == nil {
+		t.Error("expected an error for an unknown source")
+	}
+}
+
+func TestDetectContainerSource(t *testing.T) {
+	tests := []struct {
+		name  string
+		tools []string
+		want  string
+	}{
+		{"podman preferred", []string{"podman", "docker"}, "podman"},
+		{"docker", []string{"docker", "skopeo", "umoci"}, "docker"},
+		{"skopeo and umoci", []string{"skopeo", "umoci"}, "skopeo"},
+		{"skopeo without umoci", []string{"skopeo"}, ""},
+		{"nothing", nil, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupFakeRuntime(t, tt.tools...)
+			source, err := DetectContainerSource()
+			if tt.want == "" {
+				if err == nil {
+					t.Errorf("expected no source, got %s", source.Name())
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("DetectContainerSource failed: %v", err)
+			}
+			if source.Name() != tt.want {
+				t.Errorf("source = %s, want %s", source.Name(), tt.want)
+			}
+		})
+	}
+}
+
+func TestResolveContainerSourceUnavailable(t *testing.T) {
+	setupFakeRuntime(t, "podman")
+	if err := SetContainerSource(ContainerSourceDocker); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := resolveContainerSource(); err == nil {
+		t.Error("expected an error when docker is not installed")
+	}
+}
+
+func TestRuntimeSourceExtract(t *testing.T) {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	_ = tw.WriteHeader(&tar.Header{Name: "usr/", Typeflag: tar.TypeDir, Mode: 0755})
+	_ = tw.WriteHeader(&tar.Header{Name: "usr/hello", Typeflag: tar.TypeReg, Mode: 0644, Size: 5})
+	_, _ = tw.Write([]byte("hello"))
+	_ = tw.Close()
+
+	fake := setupFakeRuntime(t, "podman")
+	fake.answer("podman export", buf.String())
+	if err := SetContainerSource(ContainerSourceAuto); err != nil {
+		t.Fatal(err)
+	}
+
+	target := t.TempDir()
+	extractor := NewContainerExtractor("localhost/test", target)
+	if err := extractor.Extract(context.Background()); err != nil {
+		t.Fatalf("Extract failed: %v", err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(target, "usr", "hello"))
+	if err != nil || string(data) != "hello" {
+		t.Errorf("extracted file = %q, %v", data, err)
+	}
+	if extractor.Stats.Files != 1 || extractor.Stats.TotalBytes != 5 {
+		t.Errorf("stats = %+v", extractor.Stats)
+	}
+
+	want := []string{
+		"podman create localhost/test true",
+		"podman export abc123",
+		"podman rm -f abc123",
+	}
+	if len(fake.Commands) != len(want) {
+		t.Fatalf("ran %d commands, want %d", len(fake.Commands), len(want))
+	}
+	for i, w := range want {
+		if got := fake.Commands[i].String(); got != w {
+			t.Errorf("command %d = %q, want %q", i, got, w)
+		}
+	}
+}
+
+func TestSkopeoTransportRef(t *testing.T) {
+	tests := map[string]string{
+		"quay.io/fedora/fedora-bootc:42":     "docker://quay.io/fedora/fedora-bootc:42",
+		"docker://quay.io/example/image":     "docker://quay.io/example/image",
+		"containers-storage:localhost/image": "containers-storage:localhost/image",
+		"oci-archive:/tmp/image.tar":         "oci-archive:/tmp/image.tar",
+	}
+	for in, want := range tests {
+		if got := skopeoTransportRef(in); got != want {
+			t.Errorf("skopeoTransportRef(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestRecordTreeStats(t *testing.T) {
+	root := t.TempDir()
+	writeFakeFile(t, filepath.Join(root, "usr", "lib", "a"), "1234")
+	if err := os.Symlink("a", filepath.Join(root, "usr", "lib", "b")); err != nil {
+		t.Fatal(err)
+	}
+
+	stats := NewExtractStats()
+	if err := recordTreeStats(root, stats); err != nil {
+		t.Fatalf("recordTreeStats failed: %v", err)
+	}
+	if stats.Files != 1 || stats.Symlinks != 1 || stats.Directories != 2 || stats.TotalBytes != 4 {
+		t.Errorf("stats = %+v", stats)
+	}
+	if stats.DirBytes["/usr/lib"] != 4 {
+		t.Errorf("DirBytes = %v", stats.DirBytes)
+	}
+}
@@ -0,0 +1,309 @@
+package pkg
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// treeCopier recursively copies a directory tree preserving owners, modes,
+// timestamps, xattrs, symlinks, hardlinks and special files (like rsync -aHX)
+type treeCopier struct {
+	// links maps a source inode to the first destination path it was copied to
+	links map[inodeKey]string
+}
+
+// inodeKey identifies a file across hardlinks
+type inodeKey struct {
+	dev uint64
+	ino uint64
+}
+
+// copyTree copies the contents of src into dst. If deleteExtra is set, entries
+// in dst that are not in src are removed (like rsync --delete).
+func copyTree(src, dst string, deleteExtra bool) error {
+	srcInfo, err := os.Lstat(src)
+	if err != nil {
+		return err
+	}
+	if !srcInfo.IsDir() {
+		return fmt.Errorf("%s is not a directory", src)
+	}
+
+	if err := os.MkdirAll(dst, 0755); err != nil {
+		return err
+	}
+
+	c := &treeCopier{links: map[inodeKey]string{}}
+	if err := filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		return c.copyEntry(path, filepath.Join(dst, rel), info)
+	}); err != nil {
+		return err
+	}
+
+	if deleteExtra {
+		if err := deleteExtraEntries(src, dst); err != nil {
+			return err
+		}
+	}
+
+	// Directory timestamps change as entries are written, so fix them last
+	return filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || !d.IsDir() {
+			return err
+		}
+		rel, _ := filepath.Rel(src, path)
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		return setTimes(filepath.Join(dst, rel), info)
+	})
+}
+
+// copyPreserving copies a single file, symlink or special file from src to dst
+// with its metadata, replacing any existing dst
+func copyPreserving(src, dst string) error {
+	info, err := os.Lstat(src)
+	if err != nil {
+		return err
+	}
+	c := &treeCopier{links: map[inodeKey]string{}}
+	if err := c.copyEntry(src, dst, info); err != nil {
+		return err
+	}
+	if info.IsDir() {
+		return setTimes(dst, info)
+	}
+	return nil
+}
+
+// copyEntry copies one directory entry and its metadata
+func (c *treeCopier) copyEntry(src, dst string, info os.FileInfo) error {
+	mode := info.Mode()
+
+	if mode.IsDir() {
+		if existing, err := os.Lstat(dst); err == nil && !existing.IsDir() {
+			if err := os.Remove(dst); err != nil {
+				return err
+			}
+		}
+		if err := os.MkdirAll(dst, 0700); err != nil {
+			return err
+		}
+		return copyMetadata(src, dst, info)
+	}
+
+	// Replace whatever is at the destination
+	if existing, err := os.Lstat(dst); err == nil {
+		if existing.IsDir() {
+			err = os.RemoveAll(dst)
+		} else {
+			err = os.Remove(dst)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	// Recreate hardlinks between files copied in this run
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if ok && st.Nlink > 1 {
+		key := inodeKey{dev: uint64(st.Dev), ino: st.Ino}
+		if first, seen := c.links[key]; seen {
+			return os.Link(first, dst)
+		}
+		c.links[key] = dst
+	}
+
+	switch {
+	case mode&os.ModeSymlink != 0:
+		target, err := os.Readlink(src)
+		if err != nil {
+			return err
+		}
+		if err := os.Symlink(target, dst); err != nil {
+			return err
+		}
+	case mode.IsRegular():
+		if err := copyFileContents(src, dst); err != nil {
+			return err
+		}
+	case mode&(os.ModeDevice|os.ModeNamedPipe|os.ModeSocket) != 0:
+		if !ok {
+			return fmt.Errorf("cannot read device number of %s", src)
+		}
+		if err := unix.Mknod(dst, st.Mode, int(st.Rdev)); err != nil {
+			return fmt.Errorf("mknod %s: %w", dst, err)
+		}
+	default:
+		return fmt.Errorf("unsupported file type %s for %s", mode.Type(), src)
+	}
+
+	if err := copyMetadata(src, dst, info); err != nil {
+		return err
+	}
+	return setTimes(dst, info)
+}
+
+// copyFileContents copies the data of a regular file
+func copyFileContents(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = in.Close() }()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		_ = out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// copyMetadata applies the owner, mode and xattrs of src to dst
+// Timestamps are applied separately by setTimes
+func copyMetadata(src, dst string, info os.FileInfo) error {
+	isLink := info.Mode()&os.ModeSymlink != 0
+
+	if st, ok := info.Sys().(*syscall.Stat_t); ok {
+		// Like rsync, silently keep our own ownership when not privileged
+		if err := os.Lchown(dst, int(st.Uid), int(st.Gid)); err != nil && !errors.Is(err, fs.ErrPermission) {
+			return fmt.Errorf("chown %s: %w", dst, err)
+		}
+	}
+
+	// Symlink permissions are not meaningful on Linux; chmod must come after
+	// chown since chown clears setuid/setgid bits
+	if !isLink {
+		if err := os.Chmod(dst, info.Mode()&(os.ModePerm|os.ModeSetuid|os.ModeSetgid|os.ModeSticky)); err != nil {
+			return fmt.Errorf("chmod %s: %w", dst, err)
+		}
+	}
+
+	return copyXattrs(src, dst)
+}
+
+// copyXattrs copies all extended attributes from src to dst without following symlinks
+func copyXattrs(src, dst string) error {
+	size, err := unix.Llistxattr(src, nil)
+	if err != nil {
+		if errors.Is(err, unix.ENOTSUP) {
+			return nil
+		}
+		return fmt.Errorf("listxattr %s: %w", src, err)
+	}
+	if size == 0 {
+		return nil
+	}
+
+	buf := make([]byte, size)
+	size, err = unix.Llistxattr(src, buf)
+	if err != nil {
+		return fmt.Errorf("listxattr %s: %w", src, err)
+	}
+
+	for _, name := range splitXattrNames(buf[:size]) {
+		vsize, err := unix.Lgetxattr(src, name, nil)
+		if err != nil {
+			return fmt.Errorf("getxattr %s %s: %w", src, name, err)
+		}
+		value := make([]byte, vsize)
+		if vsize > 0 {
+			if vsize, err = unix.Lgetxattr(src, name, value); err != nil {
+				return fmt.Errorf("getxattr %s %s: %w", src, name, err)
+			}
+		}
+		if err := unix.Lsetxattr(dst, name, value[:vsize], 0); err != nil {
+			// Destination filesystem or privileges may not allow this namespace
+			if errors.Is(err, unix.ENOTSUP) || errors.Is(err, unix.EPERM) {
+				continue
+			}
+			return fmt.Errorf("setxattr %s %s: %w", dst, name, err)
+		}
+	}
+	return nil
+}
+
+// splitXattrNames splits a NUL-separated xattr name list
+func splitXattrNames(buf []byte) []string {
+	var names []string
+	start := 0
+	for i, b := range buf {
+		if b == 0 {
+			if i > start {
+				names = append(names, string(buf[start:i]))
+			}
+			start = i + 1
+		}
+	}
+	return names
+}
+
+// setTimes applies the access and modification times of info to path
+func setTimes(path string, info os.FileInfo) error {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return nil
+	}
+	times := []unix.Timespec{
+		unix.NsecToTimespec(syscall.TimespecToNsec(st.Atim)),
+		unix.NsecToTimespec(syscall.TimespecToNsec(st.Mtim)),
+	}
+	if err := unix.UtimesNanoAt(unix.AT_FDCWD, path, times, unix.AT_SYMLINK_NOFOLLOW); err != nil {
+		return fmt.Errorf("set times on %s: %w", path, err)
+	}
+	return nil
+}
+
+// deleteExtraEntries removes entries below dst that do not exist below src
+func deleteExtraEntries(src, dst string) error {
+	var extra []string
+	err := filepath.WalkDir(dst, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dst, path)
+		if err != nil {
+			return err
+		}
+		if _, err := os.Lstat(filepath.Join(src, rel)); os.IsNotExist(err) {
+			extra = append(extra, path)
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, path := range extra {
+		if err := os.RemoveAll(path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,118 @@
+package pkg
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestCopyTree(t *testing.T) {
+	src := t.TempDir()
+	dst := filepath.Join(t.TempDir(), "copy")
+
+	writeFakeFile(t, filepath.Join(src, "passwd"), "root:x:0:0::/root:/bin/sh\n")
+	writeFakeFile(t, filepath.Join(src, "ssh", "sshd_config"), "PermitRootLogin no\n")
+	if err := os.Chmod(filepath.Join(src, "ssh", "sshd_config"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("../usr/share/zoneinfo/UTC", filepath.Join(src, "localtime")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Link(filepath.Join(src, "passwd"), filepath.Join(src, "passwd-link")); err != nil {
+		t.Fatal(err)
+	}
+	mtime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	if err := os.Chtimes(filepath.Join(src, "passwd"), mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+	xattrSupported := unix.Lsetxattr(filepath.Join(src, "passwd"), "user.phukit", []byte("test"), 0) == nil
+
+	if err := copyTree(src, dst, false); err != nil {
+		t.Fatalf("copyTree() error = %v", err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(dst, "ssh", "sshd_config"))
+	if err != nil || string(data) != "PermitRootLogin no\n" {
+		t.Errorf("sshd_config = %q, %v", data, err)
+	}
+	if info, err := os.Stat(filepath.Join(dst, "ssh", "sshd_config")); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("sshd_config mode = %v, %v, want 0600", info.Mode().Perm(), err)
+	}
+	if target, err := os.Readlink(filepath.Join(dst, "localtime")); err != nil || target != "../usr/share/zoneinfo/UTC" {
+		t.Errorf("localtime symlink = %q, %v", target, err)
+	}
+
+	info, err := os.Stat(filepath.Join(dst, "passwd"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !info.ModTime().Equal(mtime) {
+		t.Errorf("passwd mtime = %v, want %v", info.ModTime(), mtime)
+	}
+	linkInfo, err := os.Stat(filepath.Join(dst, "passwd-link"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Sys().(*syscall.Stat_t).Ino != linkInfo.Sys().(*syscall.Stat_t).Ino {
+		t.Error("hardlink was not preserved")
+	}
+
+	if xattrSupported {
+		buf := make([]byte, 16)
+		n, err := unix.Lgetxattr(filepath.Join(dst, "passwd"), "user.phukit", buf)
+		if err != nil || string(buf[:n]) != "test" {
+			t.Errorf("xattr = %q, %v, want %q", buf[:n], err, "test")
+		}
+	}
+}
+
+func TestCopyTreeDelete(t *testing.T) {
+	src := t.TempDir()
+	dst := t.TempDir()
+
+	writeFakeFile(t, filepath.Join(src, "keep"), "new")
+	writeFakeFile(t, filepath.Join(dst, "keep"), "old")
+	writeFakeFile(t, filepath.Join(dst, "stale"), "x")
+	writeFakeFile(t, filepath.Join(dst, "stale-dir", "file"), "x")
+
+	if err := copyTree(src, dst, false); err != nil {
+		t.Fatalf("copyTree() error = %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dst, "stale")); err != nil {
+		t.Error("copyTree() without delete removed an extra file")
+	}
+
+	if err := copyTree(src, dst, true); err != nil {
+		t.Fatalf("copyTree() error = %v", err)
+	}
+	for _, name := range []string{"stale", "stale-dir"} {
+		if _, err := os.Lstat(filepath.Join(dst, name)); !os.IsNotExist(err) {
+			t.Errorf("%s still exists after copy with delete", name)
+		}
+	}
+	if data, _ := os.ReadFile(filepath.Join(dst, "keep")); string(data) != "new" {
+		t.Errorf("keep = %q, want %q", data, "new")
+	}
+}
+
+func TestCopyPreservingReplacesExisting(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src")
+	dst := filepath.Join(dir, "dst")
+
+	if err := os.Symlink("target", src); err != nil {
+		t.Fatal(err)
+	}
+	writeFakeFile(t, dst, "regular file")
+
+	if err := copyPreserving(src, dst); err != nil {
+		t.Fatalf("copyPreserving() error = %v", err)
+	}
+	if target, err := os.Readlink(dst); err != nil || target != "target" {
+		t.Errorf("dst symlink = %q, %v", target, err)
+	}
+}
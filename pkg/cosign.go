@@ -0,0 +1,333 @@
+package pkg
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/name"
+)
+
+// Cosign signatures
+//
+// cosign stores the signatures of an image as another image in the same
+// repository, tagged sha256-<digest>.sig. Each layer is a signed payload
+// naming the image digest, with the signature and, for keyless signing, the
+// signer's certificate in its annotations.
+
+// Annotations of a cosign signature layer
+const (
+	cosignSignatureAnnotation   = "dev.cosignproject.cosign/signature"
+	cosignCertificateAnnotation = "dev.sigstore.cosign/certificate"
+	cosignChainAnnotation       = "dev.sigstore.cosign/chain"
+)
+
+// Fulcio certificate extensions holding the OIDC issuer of the signer
+var (
+	oidFulcioIssuer   = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 57264, 1, 1} // Raw string, deprecated
+	oidFulcioIssuerV2 = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 57264, 1, 8} // DER UTF8String
+)
+
+// cosignSignature is one signature of an image
+type cosignSignature struct {
+	Payload     []byte
+	Signature   []byte
+	Certificate string // PEM, for keyless signatures
+	Chain       string // PEM intermediates, for keyless signatures
+	Bundle      string // Rekor bundle (JSON), if the signature was logged
+}
+
+// cosignPayload is the signed document of a cosign signature
+type cosignPayload struct {
+	Critical struct {
+		Identity struct {
+			DockerReference string `json:"docker-reference"`
+		} `json:"identity"`
+		Image struct {
+			DockerManifestDigest string `json:"docker-manifest-digest"`
+		} `json:"image"`
+		Type string `json:"type"`
+	} `json:"critical"`
+}
+
+// cosignSignatureTag returns the tag cosign stores the signatures of digest under
+func cosignSignatureTag(digest string) string {
+	return strings.Replace(digest, ":", "-", 1) + ".sig"
+}
+
+// imageDigest returns the manifest digest of ref, the digest signatures sign
+func imageDigest(ctx context.Context, ref name.Reference) (string, error) {
+	if d, ok := ref.(name.Digest); ok {
+		return d.DigestStr(), nil
+	}
+	desc, err := remoteHead(ctx, ref)
+	if err != nil {
+		return "", fmt.Errorf("failed to get image digest: %w", registryError(err))
+	}
+	return desc.Digest.String(), nil
+}
+
+// fetchCosignSignatures returns the signatures of digest in ref's repository
+func fetchCosignSignatures(ctx context.Context, ref name.Reference, digest string) ([]cosignSignature, error) {
+	sigImage, err := remoteImage(ctx, ref.Context().Tag(cosignSignatureTag(digest)))
+	if err != nil {
+		if errors.Is(registryError(err), ErrImageNotFound) {
+			return nil, fmt.Errorf("image %s is not signed", digest)
+		}
+		return nil, fmt.Errorf("failed to fetch signatures: %w", registryError(err))
+	}
+	manifest, err := sigImage.Manifest()
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch signatures: %w", registryError(err))
+	}
+	layers, err := sigImage.Layers()
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch signatures: %w", registryError(err))
+	}
+
+	var signatures []cosignSignature
+	for i, layer := range layers {
+		annotations := manifest.Layers[i].Annotations
+		sig, err := base64.StdEncoding.DecodeString(annotations[cosignSignatureAnnotation])
+		if err != nil || len(sig) == 0 {
+			continue
+		}
+		rc, err := layer.Compressed()
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch signature payload: %w", registryError(err))
+		}
+		payload, err := io.ReadAll(io.LimitReader(rc, 1<<20))
+		_ = rc.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read signature payload: %w", err)
+		}
+		signatures = append(signatures, cosignSignature{
+			Payload:     payload,
+			Signature:   sig,
+			Certificate: annotations[cosignCertificateAnnotation],
+			Chain:       annotations[cosignChainAnnotation],
+			Bundle:      annotations[cosignBundleAnnotation],
+		})
+	}
+	if len(signatures) == 0 {
+		return nil, fmt.Errorf("image %s is not signed", digest)
+	}
+	return signatures, nil
+}
+
+// verify checks that one of signatures satisfies req for ref with digest and
+// returns what was verified
+func (req SignatureRequirement) verify(ctx context.Context, ref name.Reference, digest string, signatures []cosignSignature) (*SignatureVerification, error) {
+	var key crypto.PublicKey
+	if req.KeyPath != "" {
+		var err error
+		if key, err = readPublicKey(req.KeyPath); err != nil {
+			return nil, err
+		}
+	}
+
+	var errs []error
+	for _, sig := range signatures {
+		tlog, err := req.verifyCosignSignature(ctx, ref, digest, key, sig)
+		if err == nil {
+			return &SignatureVerification{Scope: req.Scope, Type: SignatureTypeCosign, Signer: req.signer(), TransparencyLog: tlog}, nil
+		}
+		errs = append(errs, err)
+	}
+	return nil, fmt.Errorf("no valid signature by %s: %w", req.signer(), errors.Join(errs...))
+}
+
+// verifyCosignSignature checks one signature of ref with digest, made with
+// key or keyless if key is nil, and returns its transparency log entry if
+// req requires one
+func (req SignatureRequirement) verifyCosignSignature(ctx context.Context, ref name.Reference, digest string, key crypto.PublicKey, sig cosignSignature) (*TransparencyLogEntry, error) {
+	if err := checkCosignPayload(sig.Payload, ref, digest); err != nil {
+		return nil, err
+	}
+	var cert *x509.Certificate
+	if key == nil {
+		if sig.Certificate == "" {
+			return nil, fmt.Errorf("signature has no certificate")
+		}
+		var err error
+		if cert, err = parseCertificate(sig.Certificate); err != nil {
+			return nil, err
+		}
+		key = cert.PublicKey
+	}
+	if err := verifySignature(key, sig.Payload, sig.Signature); err != nil {
+		return nil, err
+	}
+
+	var tlog *TransparencyLogEntry
+	if req.RekorPublicKey != "" {
+		var err error
+		if tlog, err = req.verifyTransparencyLog(ctx, sig, key); err != nil {
+			return nil, err
+		}
+	}
+	if cert != nil {
+		// Signing certificates live for minutes; the signature was made while
+		// it was valid, when the log integrated it if it is logged
+		signed := cert.NotBefore
+		if tlog != nil {
+			signed = tlog.IntegratedTime
+		}
+		if err := req.verifyCertificate(cert, sig.Chain, signed); err != nil {
+			return nil, err
+		}
+	}
+	return tlog, nil
+}
+
+// signer describes the signer req requires
+func (req SignatureRequirement) signer() string {
+	if req.KeyPath != "" {
+		return req.KeyPath
+	}
+	return req.CertificateIdentity + " (" + req.CertificateOIDCIssuer + ")"
+}
+
+// checkCosignPayload checks that payload signs digest of ref's repository
+func checkCosignPayload(payload []byte, ref name.Reference, digest string) error {
+	var p cosignPayload
+	if err := json.Unmarshal(payload, &p); err != nil {
+		return fmt.Errorf("invalid signature payload: %w", err)
+	}
+	if p.Critical.Image.DockerManifestDigest != digest {
+		return fmt.Errorf("signature is for %s, not %s", p.Critical.Image.DockerManifestDigest, digest)
+	}
+	signed, err := name.NewRepository(p.Critical.Identity.DockerReference)
+	if err != nil {
+		// A reference with tag or digest names its repository as well
+		signedRef, rerr := name.ParseReference(p.Critical.Identity.DockerReference)
+		if rerr != nil {
+			return fmt.Errorf("invalid signed reference %q: %w", p.Critical.Identity.DockerReference, err)
+		}
+		signed = signedRef.Context()
+	}
+	if signed.Name() != ref.Context().Name() {
+		return fmt.Errorf("signature is for %s, not %s", signed.Name(), ref.Context().Name())
+	}
+	return nil
+}
+
+// readPublicKey reads a PEM public key
+func readPublicKey(path string) (crypto.PublicKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read public key: %w", err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM public key in %s", path)
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("invalid public key %s: %w", path, err)
+	}
+	return key, nil
+}
+
+// verifySignature checks sig of payload with key, the way cosign signs: a
+// SHA-256 digest for ECDSA and RSA, the payload itself for Ed25519
+func verifySignature(key crypto.PublicKey, payload, sig []byte) error {
+	hash := sha256.Sum256(payload)
+	switch key := key.(type) {
+	case *ecdsa.PublicKey:
+		if ecdsa.VerifyASN1(key, hash[:], sig) {
+			return nil
+		}
+	case *rsa.PublicKey:
+		if rsa.VerifyPKCS1v15(key, crypto.SHA256, hash[:], sig) == nil {
+			return nil
+		}
+	case ed25519.PublicKey:
+		if ed25519.Verify(key, payload, sig) {
+			return nil
+		}
+	default:
+		return fmt.Errorf("unsupported public key type %T", key)
+	}
+	return fmt.Errorf("signature does not verify")
+}
+
+// verifyCertificate checks that cert, with the intermediates of chain, was
+// issued by a CA of req.CertificateRoots and valid at signed for
+// req.CertificateIdentity, vouched for by req.CertificateOIDCIssuer
+func (req SignatureRequirement) verifyCertificate(cert *x509.Certificate, chain string, signed time.Time) error {
+	roots := x509.NewCertPool()
+	data, err := os.ReadFile(req.CertificateRoots)
+	if err != nil {
+		return fmt.Errorf("failed to read certificate roots: %w", err)
+	}
+	if !roots.AppendCertsFromPEM(data) {
+		return fmt.Errorf("no certificates in %s", req.CertificateRoots)
+	}
+	intermediates := x509.NewCertPool()
+	intermediates.AppendCertsFromPEM([]byte(chain))
+	if _, err := cert.Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		CurrentTime:   signed,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning},
+	}); err != nil {
+		return fmt.Errorf("untrusted certificate: %w", err)
+	}
+
+	identities := slices.Clone(cert.EmailAddresses)
+	for _, uri := range cert.URIs {
+		identities = append(identities, uri.String())
+	}
+	if !slices.Contains(identities, req.CertificateIdentity) {
+		return fmt.Errorf("certificate is for %s, not %s", strings.Join(identities, ", "), req.CertificateIdentity)
+	}
+	if issuer := certificateIssuer(cert); issuer != req.CertificateOIDCIssuer {
+		return fmt.Errorf("certificate identity is vouched for by %q, not %s", issuer, req.CertificateOIDCIssuer)
+	}
+	return nil
+}
+
+// parseCertificate parses the first certificate of a PEM bundle
+func parseCertificate(data string) (*x509.Certificate, error) {
+	block, _ := pem.Decode([]byte(data))
+	if block == nil {
+		return nil, fmt.Errorf("invalid signature certificate")
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("invalid signature certificate: %w", err)
+	}
+	return cert, nil
+}
+
+// certificateIssuer returns the OIDC issuer recorded in a Fulcio certificate
+func certificateIssuer(cert *x509.Certificate) string {
+	for _, ext := range cert.Extensions {
+		switch {
+		case ext.Id.Equal(oidFulcioIssuerV2):
+			var issuer string
+			if _, err := asn1.Unmarshal(ext.Value, &issuer); err == nil {
+				return issuer
+			}
+		case ext.Id.Equal(oidFulcioIssuer):
+			return string(ext.Value)
+		}
+	}
+	return ""
+}
@@ -0,0 +1,227 @@
+package pkg
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/static"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+)
+
+// testSigner signs cosign payloads with an ECDSA key, and with a
+// certificate of a test CA for keyless signatures
+type testSigner struct {
+	key      *ecdsa.PrivateKey
+	cert     string // PEM signing certificate, empty for key signatures
+	rootPath string // PEM bundle of the test CA
+}
+
+// writePublicKey writes the signer's public key to dir and returns its path
+func (s *testSigner) writePublicKey(t *testing.T, dir string) string {
+	t.Helper()
+	der, err := x509.MarshalPKIXPublicKey(&s.key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "cosign.pub")
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func newTestSigner(t *testing.T) *testSigner {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testSigner{key: key}
+}
+
+// newKeylessTestSigner returns a signer with a certificate for identity from
+// issuer, issued by a CA written to dir
+func newKeylessTestSigner(t *testing.T, dir, identity, issuer string) *testSigner {
+	t.Helper()
+	s := newTestSigner(t)
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Expired, like every Fulcio certificate shortly after signing
+	notBefore := time.Now().Add(-time.Hour)
+	ca := &x509.Certificate{
+		SerialNumber: big.NewInt(1), Subject: pkix.Name{CommonName: "test fulcio"},
+		NotBefore: notBefore, NotAfter: notBefore.Add(24 * time.Hour),
+		IsCA: true, BasicConstraintsValid: true, KeyUsage: x509.KeyUsageCertSign,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, ca, ca, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ca, err = x509.ParseCertificate(caDER); err != nil {
+		t.Fatal(err)
+	}
+	issuerExt, err := asn1.Marshal(issuer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf := &x509.Certificate{
+		SerialNumber: big.NewInt(2), NotBefore: notBefore, NotAfter: notBefore.Add(10 * time.Minute),
+		EmailAddresses:  []string{identity},
+		KeyUsage:        x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:     []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning},
+		ExtraExtensions: []pkix.Extension{{Id: oidFulcioIssuerV2, Value: issuerExt}},
+	}
+	leafDER, err := x509.CreateCertificate(rand.Reader, leaf, ca, &s.key.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.cert = string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: leafDER}))
+	s.rootPath = filepath.Join(dir, "fulcio.pem")
+	if err := os.WriteFile(s.rootPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caDER}), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// sign returns the cosign signature of digest in repository repo
+func (s *testSigner) sign(t *testing.T, repo, digest string) cosignSignature {
+	t.Helper()
+	payload := fmt.Sprintf(`{"critical":{"identity":{"docker-reference":%q},"image":{"docker-manifest-digest":%q},"type":"cosign container image signature"},"optional":null}`, repo, digest)
+	hash := sha256.Sum256([]byte(payload))
+	sig, err := s.key.Sign(rand.Reader, hash[:], crypto.SHA256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cosignSignature{Payload: []byte(payload), Signature: sig, Certificate: s.cert}
+}
+
+// pushTestSignatures stores signatures of imageRef the way cosign does
+func pushTestSignatures(t *testing.T, imageRef string, signatures ...cosignSignature) {
+	t.Helper()
+	ref, err := name.ParseReference(imageRef)
+	if err != nil {
+		t.Fatal(err)
+	}
+	desc, err := remote.Head(ref)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var img v1.Image = empty.Image
+	for _, sig := range signatures {
+		annotations := map[string]string{cosignSignatureAnnotation: base64.StdEncoding.EncodeToString(sig.Signature)}
+		if sig.Certificate != "" {
+			annotations[cosignCertificateAnnotation] = sig.Certificate
+		}
+		if sig.Bundle != "" {
+			annotations[cosignBundleAnnotation] = sig.Bundle
+		}
+		layer := static.NewLayer(sig.Payload, types.MediaType("application/vnd.dev.cosign.simplesigning.v1+json"))
+		if img, err = mutate.Append(img, mutate.Addendum{Layer: layer, Annotations: annotations}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := remote.Write(ref.Context().Tag(cosignSignatureTag(desc.Digest.String())), img); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// pushSignableImage serves a small image and returns its reference,
+// repository and digest
+func pushSignableImage(t *testing.T) (imageRef, repo, digest string) {
+	t.Helper()
+	img, err := mutate.AppendLayers(empty.Image, testLayer(t, "usr/lib/os-release", "ID=example\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	imageRef = pushTestImage(t, img)
+	d, err := img.Digest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return imageRef, strings.TrimSuffix(imageRef, ":latest"), d.String()
+}
+
+func TestCosignKeySignature(t *testing.T) {
+	imageRef, repo, digest := pushSignableImage(t)
+	ref, _ := name.ParseReference(imageRef)
+	signer := newTestSigner(t)
+	req := SignatureRequirement{Scope: repo, KeyPath: signer.writePublicKey(t, t.TempDir())}
+
+	if _, err := fetchCosignSignatures(t.Context(), ref, digest); err == nil || !strings.Contains(err.Error(), "not signed") {
+		t.Errorf("fetchCosignSignatures() of an unsigned image error = %v, want not signed", err)
+	}
+
+	other := newTestSigner(t)
+	pushTestSignatures(t, imageRef, other.sign(t, repo, digest))
+	if gotDigest, err := imageDigest(t.Context(), ref); err != nil || gotDigest != digest {
+		t.Fatalf("imageDigest() = %s, %v, want %s", gotDigest, err, digest)
+	}
+	signatures, err := fetchCosignSignatures(t.Context(), ref, digest)
+	if err != nil || len(signatures) != 1 {
+		t.Fatalf("fetchCosignSignatures() = %d signatures, %v", len(signatures), err)
+	}
+	if _, err := req.verify(t.Context(), ref, digest, signatures); err == nil {
+		t.Error("verify() of a signature by another key succeeded")
+	}
+
+	// A signature of another image or repository does not count
+	pushTestSignatures(t, imageRef, signer.sign(t, repo, "sha256:"+strings.Repeat("0", 64)), signer.sign(t, "quay.io/other/os", digest))
+	signatures, _ = fetchCosignSignatures(t.Context(), ref, digest)
+	if _, err := req.verify(t.Context(), ref, digest, signatures); err == nil {
+		t.Error("verify() of signatures for other images succeeded")
+	}
+
+	pushTestSignatures(t, imageRef, other.sign(t, repo, digest), signer.sign(t, repo, digest))
+	signatures, _ = fetchCosignSignatures(t.Context(), ref, digest)
+	if _, err := req.verify(t.Context(), ref, digest, signatures); err != nil {
+		t.Errorf("verify() error = %v", err)
+	}
+}
+
+func TestCosignKeylessSignature(t *testing.T) {
+	imageRef, repo, digest := pushSignableImage(t)
+	ref, _ := name.ParseReference(imageRef)
+	signer := newKeylessTestSigner(t, t.TempDir(), "builder@example.com", "https://accounts.example.com")
+	pushTestSignatures(t, imageRef, signer.sign(t, repo, digest))
+	signatures, err := fetchCosignSignatures(t.Context(), ref, digest)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := SignatureRequirement{Scope: repo, CertificateIdentity: "builder@example.com", CertificateOIDCIssuer: "https://accounts.example.com", CertificateRoots: signer.rootPath}
+	if _, err := req.verify(t.Context(), ref, digest, signatures); err != nil {
+		t.Errorf("verify() error = %v", err)
+	}
+
+	wrongIdentity, wrongIssuer, wrongRoots := req, req, req
+	wrongIdentity.CertificateIdentity = "mallory@example.com"
+	wrongIssuer.CertificateOIDCIssuer = "https://evil.example.com"
+	wrongRoots.CertificateRoots = newKeylessTestSigner(t, t.TempDir(), "builder@example.com", "https://accounts.example.com").rootPath
+	for name, r := range map[string]SignatureRequirement{"identity": wrongIdentity, "issuer": wrongIssuer, "roots": wrongRoots} {
+		if _, err := r.verify(t.Context(), ref, digest, signatures); err == nil {
+			t.Errorf("verify() with the wrong %s succeeded", name)
+		}
+	}
+}
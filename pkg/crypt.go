@@ -0,0 +1,154 @@
+package pkg
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Encrypted partitions
+//
+// The roots, /var and swap can be LUKS containers that the caller formatted
+// and opened before 'install to-filesystem' or an install into existing
+// partitions; the partition scheme then names the /dev/mapper devices. The
+// installed system has to open them again on every boot, or systemd waits
+// for the root and /var until it gives up and drops to an emergency shell.
+// phukit finds the containers below the target's filesystems in sysfs and:
+//
+//   - writes /etc/crypttab, so the real root opens /var, swap and the other
+//     root (which updates write to)
+//   - adds rd.luks.uuid= for every container to the kernel command line, so
+//     the initramfs opens the root, and /var and swap for a composefs /usr
+//     or resume; systemd caches the passphrase, so one passphrase shared by
+//     all containers is asked for only once
+//   - rebuilds the initramfs with the crypt dracut module
+//
+// The mapping names are luks-<UUID>, as the initramfs names the containers
+// of rd.luks.uuid=, so crypttab does not open them a second time.
+
+// dmCryptLUKSPrefix starts the device-mapper uuid of an open LUKS container,
+// CRYPT-LUKS2-<uuid without dashes>-<name> (or LUKS1)
+const dmCryptLUKSPrefix = "CRYPT-LUKS"
+
+// LUKSVolume is an open LUKS container holding a filesystem of the target
+type LUKSVolume struct {
+	UUID string // UUID of the LUKS header
+	Role string // What the container holds: root1, root2, var or swap
+}
+
+// mappingName returns the device-mapper name the installed system opens
+// the container as
+func (v LUKSVolume) mappingName() string {
+	return "luks-" + v.UUID
+}
+
+// luksUUID returns the UUID of the LUKS container the block device node is
+// the open mapping of, or "" if it is not one
+func luksUUID(node string) string {
+	dmUUID := readSysfsValue(filepath.Join(sysClassBlockPath, node, "dm", "uuid"))
+	rest, ok := strings.CutPrefix(dmUUID, dmCryptLUKSPrefix)
+	if !ok {
+		return ""
+	}
+	// Skip the LUKS version: 1-, 2-
+	_, rest, ok = strings.Cut(rest, "-")
+	if !ok {
+		return ""
+	}
+	hex, _, _ := strings.Cut(rest, "-")
+	if len(hex) != 32 {
+		return ""
+	}
+	return hex[0:8] + "-" + hex[8:12] + "-" + hex[12:16] + "-" + hex[16:20] + "-" + hex[20:32]
+}
+
+// deviceLUKSUUID returns the UUID of the LUKS container device is stored in,
+// directly or below other device-mapper devices such as an LVM logical
+// volume, or "" if it is not encrypted
+func deviceLUKSUUID(device string) string {
+	var uuid string
+	walkBlockDevices(blockDeviceName(device), func(name string) {
+		if uuid == "" {
+			uuid = luksUUID(name)
+		}
+	})
+	return uuid
+}
+
+// luksBackingDevice returns the device holding the LUKS container whose open
+// mapping is device, or "" if device is not one
+func luksBackingDevice(device string) string {
+	node := blockDeviceName(device)
+	if luksUUID(node) == "" {
+		return ""
+	}
+	slaves, err := os.ReadDir(filepath.Join(sysClassBlockPath, node, "slaves"))
+	if err != nil || len(slaves) != 1 {
+		return ""
+	}
+	backing := slaves[0].Name()
+	if dmName(backing) != "" {
+		return canonicalDevicePath(filepath.Join(devPath, backing))
+	}
+	return filepath.Join(devPath, backing)
+}
+
+// SchemeLUKSVolumes returns the LUKS containers holding the roots, /var and
+// swap of scheme, each once
+func SchemeLUKSVolumes(scheme *PartitionScheme) []LUKSVolume {
+	var volumes []LUKSVolume
+	seen := map[string]bool{}
+	for _, part := range []struct{ role, device string }{
+		{"root1", scheme.Root1Partition},
+		{"root2", scheme.Root2Partition},
+		{"var", scheme.VarPartition},
+		{"swap", scheme.SwapPartition},
+	} {
+		if part.device == "" {
+			continue
+		}
+		uuid := deviceLUKSUUID(part.device)
+		if uuid == "" || seen[uuid] {
+			continue
+		}
+		seen[uuid] = true
+		volumes = append(volumes, LUKSVolume{UUID: uuid, Role: part.role})
+	}
+	return volumes
+}
+
+// luksKernelArgs returns the kernel arguments that have the initramfs open
+// volumes
+func luksKernelArgs(volumes []LUKSVolume) []string {
+	var args []string
+	for _, v := range volumes {
+		args = append(args, "rd.luks.uuid="+v.UUID)
+	}
+	return args
+}
+
+// crypttabContent returns the /etc/crypttab that opens volumes
+func crypttabContent(volumes []LUKSVolume, discard bool) string {
+	options := "luks"
+	if discard {
+		options += ",discard"
+	}
+	var b strings.Builder
+	b.WriteString("# /etc/crypttab\n# Created by phukit: the LUKS containers of the roots, /var and swap\n")
+	for _, v := range volumes {
+		fmt.Fprintf(&b, "\n# %s\n%s\tUUID=%s\tnone\t%s\n", v.Role, v.mappingName(), v.UUID, options)
+	}
+	return b.String()
+}
+
+// writeCrypttab writes the /etc/crypttab of the root at root that opens
+// volumes, passing discards through if discard is set
+func writeCrypttab(root string, volumes []LUKSVolume, discard bool) error {
+	path := filepath.Join(root, "etc", "crypttab")
+	if err := os.WriteFile(path, []byte(crypttabContent(volumes, discard)), 0600); err != nil {
+		return fmt.Errorf("failed to write crypttab: %w", err)
+	}
+	fmt.Println("  Created /etc/crypttab")
+	return nil
+}
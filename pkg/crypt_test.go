@@ -0,0 +1,98 @@
+package pkg
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// setupFakeLUKS fakes sysfs for LUKS containers on sda3 (dm-0, holding
+// root1), sda4 (dm-1, root2) and sda5 (dm-2, holding the LVM volume dm-3
+// for /var); sda2 is not encrypted
+func setupFakeLUKS(t *testing.T) {
+	t.Helper()
+	root := t.TempDir()
+	oldClass, oldBlock, oldDev := sysClassBlockPath, sysBlockPath, devPath
+	sysClassBlockPath = filepath.Join(root, "class")
+	sysBlockPath = sysClassBlockPath
+	devPath = "/dev"
+	t.Cleanup(func() { sysClassBlockPath, sysBlockPath, devPath = oldClass, oldBlock, oldDev })
+
+	for node, c := range map[string]struct{ uuid, slave string }{
+		"dm-0": {"CRYPT-LUKS2-11111111222233334444555555555555-luks-root1", "sda3"},
+		"dm-1": {"CRYPT-LUKS2-66666666777788889999aaaaaaaaaaaa-luks-root2", "sda4"},
+		"dm-2": {"CRYPT-LUKS1-bbbbbbbbccccddddeeeeffffffffffff-cryptvar", "sda5"},
+		"dm-3": {"LVM-abcdef", "dm-2"},
+	} {
+		writeFakeFile(t, filepath.Join(sysClassBlockPath, node, "dm", "name"), node+"\n")
+		writeFakeFile(t, filepath.Join(sysClassBlockPath, node, "dm", "uuid"), c.uuid+"\n")
+		writeFakeFile(t, filepath.Join(sysClassBlockPath, node, "slaves", c.slave), "")
+	}
+}
+
+func TestSchemeLUKSVolumes(t *testing.T) {
+	setupFakeLUKS(t)
+
+	scheme := &PartitionScheme{
+		Root1Partition: "/dev/dm-0",
+		Root2Partition: "/dev/dm-1",
+		VarPartition:   "/dev/dm-3",
+		SwapPartition:  "/dev/sda2",
+	}
+	want := []LUKSVolume{
+		{UUID: "11111111-2222-3333-4444-555555555555", Role: "root1"},
+		{UUID: "66666666-7777-8888-9999-aaaaaaaaaaaa", Role: "root2"},
+		{UUID: "bbbbbbbb-cccc-dddd-eeee-ffffffffffff", Role: "var"},
+	}
+	if got := SchemeLUKSVolumes(scheme); !reflect.DeepEqual(got, want) {
+		t.Errorf("SchemeLUKSVolumes() = %+v, want %+v", got, want)
+	}
+
+	wantArgs := []string{
+		"rd.luks.uuid=11111111-2222-3333-4444-555555555555",
+		"rd.luks.uuid=66666666-7777-8888-9999-aaaaaaaaaaaa",
+		"rd.luks.uuid=bbbbbbbb-cccc-dddd-eeee-ffffffffffff",
+	}
+	if got := luksKernelArgs(want); !reflect.DeepEqual(got, wantArgs) {
+		t.Errorf("luksKernelArgs() = %v, want %v", got, wantArgs)
+	}
+
+	if got := SchemeLUKSVolumes(&PartitionScheme{Root1Partition: "/dev/sda2"}); len(got) != 0 {
+		t.Errorf("SchemeLUKSVolumes() of plain partitions = %+v, want none", got)
+	}
+}
+
+func TestLUKSBackingDevice(t *testing.T) {
+	setupFakeLUKS(t)
+
+	if got := luksBackingDevice("/dev/dm-0"); got != "/dev/sda3" {
+		t.Errorf("luksBackingDevice() = %q, want /dev/sda3", got)
+	}
+	if got := luksBackingDevice("/dev/dm-3"); got != "" {
+		t.Errorf("luksBackingDevice() of an LVM volume = %q, want none", got)
+	}
+	if got, err := GetBootDeviceFromPartition("/dev/dm-1"); err != nil || got != "/dev/sda" {
+		t.Errorf("GetBootDeviceFromPartition() = %q, %v, want /dev/sda", got, err)
+	}
+}
+
+func TestWriteCrypttab(t *testing.T) {
+	root := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(root, "etc"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	volumes := []LUKSVolume{{UUID: "11111111-2222-3333-4444-555555555555", Role: "root1"}}
+	if err := writeCrypttab(root, volumes, true); err != nil {
+		t.Fatalf("writeCrypttab() error = %v", err)
+	}
+	data, err := os.ReadFile(filepath.Join(root, "etc", "crypttab"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "luks-11111111-2222-3333-4444-555555555555\tUUID=11111111-2222-3333-4444-555555555555\tnone\tluks,discard\n"
+	if !strings.Contains(string(data), want) {
+		t.Errorf("crypttab = %q, want it to contain %q", data, want)
+	}
+}
@@ -0,0 +1,171 @@
+package pkg
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/godbus/dbus/v5"
+	"github.com/godbus/dbus/v5/introspect"
+)
+
+// D-Bus names of the management interface served by phukit daemon --dbus
+const (
+	DBusName      = "org.phukit.Manager"
+	DBusInterface = "org.phukit.Manager"
+	DBusPath      = dbus.ObjectPath("/org/phukit/Manager")
+)
+
+// dbusErrorPrefix prefixes the error code in D-Bus error names, e.g.
+// org.phukit.Manager.Error.operation_in_progress
+const dbusErrorPrefix = DBusInterface + ".Error."
+
+// dbusIntrospection describes the interface for D-Bus clients and tools
+// such as busctl
+const dbusIntrospection = `<node>
+	<interface name="` + DBusInterface + `">
+		<method name="Status">
+			<arg name="status" direction="out" type="a{sv}"/>
+		</method>
+		<method name="Update">
+			<arg name="image" direction="in" type="s"/>
+			<arg name="force" direction="in" type="b"/>
+		</method>
+		<method name="Rollback"/>
+		<method name="Install">
+			<arg name="image" direction="in" type="s"/>
+			<arg name="device" direction="in" type="s"/>
+			<arg name="kernel_args" direction="in" type="as"/>
+		</method>
+		<signal name="Progress">
+			<arg name="event" type="s"/>
+		</signal>
+		<signal name="Finished">
+			<arg name="operation" type="s"/>
+			<arg name="status" type="s"/>
+			<arg name="error_code" type="s"/>
+			<arg name="result" type="s"/>
+		</signal>
+	</interface>` + introspect.IntrospectDataString + `</node>`
+
+// DBusService serves a Manager on a D-Bus connection. Update and Install
+// return once the operation has started; clients follow it with the Progress
+// signal, which carries every event as JSON (see docs/EVENTS.md), and the
+// Finished signal, which carries the result document.
+type DBusService struct {
+	ctx         context.Context
+	conn        *dbus.Conn
+	manager     *Manager
+	unsubscribe func()
+}
+
+// ServeDBus exports manager on conn and claims DBusName. ctx bounds the
+// operations started through the service.
+func ServeDBus(ctx context.Context, conn *dbus.Conn, manager *Manager) (*DBusService, error) {
+	s := &DBusService{ctx: ctx, conn: conn, manager: manager}
+	if err := conn.Export(dbusManager{s}, DBusPath, DBusInterface); err != nil {
+		return nil, fmt.Errorf("failed to export D-Bus interface: %w", err)
+	}
+	if err := conn.Export(introspect.Introspectable(dbusIntrospection), DBusPath, "org.freedesktop.DBus.Introspectable"); err != nil {
+		return nil, fmt.Errorf("failed to export D-Bus introspection: %w", err)
+	}
+
+	reply, err := conn.RequestName(DBusName, dbus.NameFlagDoNotQueue)
+	if err != nil {
+		return nil, fmt.Errorf("failed to request D-Bus name %s: %w", DBusName, err)
+	}
+	if reply != dbus.RequestNameReplyPrimaryOwner {
+		return nil, fmt.Errorf("D-Bus name %s is already taken", DBusName)
+	}
+
+	s.unsubscribe = manager.Subscribe(s)
+	return s, nil
+}
+
+// Report implements ProgressReporter by emitting the D-Bus signals
+func (s *DBusService) Report(event ProgressEvent) {
+	event.SchemaVersion = EventSchemaVersion
+	data, err := json.Marshal(event)
+	if err != nil {
+		return
+	}
+	_ = s.conn.Emit(DBusPath, DBusInterface+".Progress", string(data))
+
+	if event.Type != EventResult || event.Result == nil {
+		return
+	}
+	result, err := json.Marshal(event.Result)
+	if err != nil {
+		return
+	}
+	_ = s.conn.Emit(DBusPath, DBusInterface+".Finished",
+		event.Result.Operation, event.Result.Status, event.Result.ErrorCode, string(result))
+}
+
+// Close stops sending signals and releases DBusName. Running operations are
+// not stopped; use Manager.Wait.
+func (s *DBusService) Close() error {
+	s.unsubscribe()
+	if _, err := s.conn.ReleaseName(DBusName); err != nil {
+		return fmt.Errorf("failed to release D-Bus name %s: %w", DBusName, err)
+	}
+	return nil
+}
+
+// dbusError converts err to a D-Bus error named after its error code
+func dbusError(err error) *dbus.Error {
+	if err == nil {
+		return nil
+	}
+	return dbus.NewError(dbusErrorPrefix+ErrorCode(err), []any{err.Error()})
+}
+
+// dbusManager holds the exported D-Bus methods, so DBusService's own methods
+// are not exported on the bus
+type dbusManager struct {
+	s *DBusService
+}
+
+// Status returns the installed system as a dictionary of strings
+func (d dbusManager) Status() (map[string]dbus.Variant, *dbus.Error) {
+	status, err := d.s.manager.Status()
+	if err != nil {
+		return nil, dbusError(err)
+	}
+	return map[string]dbus.Variant{
+		"image_ref":       dbus.MakeVariant(status.Config.ImageRef),
+		"image_digest":    dbus.MakeVariant(status.Config.ImageDigest),
+		"pinned_digest":   dbus.MakeVariant(status.Config.PinnedDigest),
+		"device":          dbus.MakeVariant(status.Config.Device),
+		"bootloader_type": dbus.MakeVariant(status.Config.BootloaderType),
+		"filesystem_type": dbus.MakeVariant(status.Config.FilesystemType),
+		"install_date":    dbus.MakeVariant(status.Config.InstallDate),
+		"active_root":     dbus.MakeVariant(status.ActiveRoot),
+		"active_slot":     dbus.MakeVariant(status.ActiveSlot),
+		"operation":       dbus.MakeVariant(status.Operation),
+		"pinned_slot":     dbus.MakeVariant(pinnedSlot(status.SlotPin)),
+	}, nil
+}
+
+// Update starts an update; an empty image keeps the installed one
+func (d dbusManager) Update(image string, force bool) *dbus.Error {
+	return dbusError(d.s.manager.Update(WithInitiator(d.s.ctx, "dbus"), UpdateRequest{ImageRef: image, Force: force}))
+}
+
+// Rollback makes the other root the default boot entry
+func (d dbusManager) Rollback() *dbus.Error {
+	return dbusError(d.s.manager.Rollback(WithInitiator(d.s.ctx, "dbus")))
+}
+
+// Install starts an install of image to device
+func (d dbusManager) Install(image, device string, kernelArgs []string) *dbus.Error {
+	return dbusError(d.s.manager.Install(WithInitiator(d.s.ctx, "dbus"), InstallRequest{ImageRef: image, Device: device, KernelArgs: kernelArgs}))
+}
+
+// pinnedSlot returns the slot of pin, or "" if no slot is pinned
+func pinnedSlot(pin *SlotPin) string {
+	if pin == nil {
+		return ""
+	}
+	return pin.Slot
+}
@@ -0,0 +1,147 @@
+package pkg
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+)
+
+// startTestBus runs a private dbus-daemon and returns its address, skipping
+// the test if dbus-daemon is not installed
+func startTestBus(t *testing.T) string {
+	t.Helper()
+	daemon, err := exec.LookPath("dbus-daemon")
+	if err != nil {
+		t.Skip("dbus-daemon not installed")
+	}
+
+	socket := filepath.Join(t.TempDir(), "bus")
+	cmd := exec.Command(daemon, "--session", "--address=unix:path="+socket, "--nofork", "--print-address")
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.Start(); err != nil {
+		t.Skipf("dbus-daemon failed to start: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = cmd.Process.Kill()
+		_ = cmd.Wait()
+	})
+
+	address, err := bufio.NewReader(stdout).ReadString('\n')
+	if err != nil {
+		t.Skipf("dbus-daemon did not print its address: %v", err)
+	}
+	return strings.TrimSpace(address)
+}
+
+func connectTestBus(t *testing.T, address string) *dbus.Conn {
+	t.Helper()
+	conn, err := dbus.Connect(address)
+	if err != nil {
+		t.Fatalf("failed to connect to test bus: %v", err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	return conn
+}
+
+func TestDBusService(t *testing.T) {
+	setupOperationLockPath(t)
+	address := startTestBus(t)
+
+	m := NewManager()
+	SetProgressReporter(m)
+	t.Cleanup(func() { SetProgressReporter(nil) })
+
+	service, err := ServeDBus(context.Background(), connectTestBus(t, address), m)
+	if err != nil {
+		t.Fatalf("ServeDBus() error = %v", err)
+	}
+	t.Cleanup(func() { _ = service.Close() })
+
+	// The name can only be served once
+	if _, err := ServeDBus(context.Background(), connectTestBus(t, address), NewManager()); err == nil {
+		t.Error("second ServeDBus() succeeded, want name taken")
+	}
+
+	client := connectTestBus(t, address)
+	if err := client.AddMatchSignal(dbus.WithMatchObjectPath(DBusPath), dbus.WithMatchInterface(DBusInterface)); err != nil {
+		t.Fatal(err)
+	}
+	signals := make(chan *dbus.Signal, 16)
+	client.Signal(signals)
+	obj := client.Object(DBusName, DBusPath)
+
+	// Calls fail with the error code while an operation runs
+	release := make(chan struct{})
+	if err := m.Start(context.Background(), OperationUpdate, func(ctx context.Context) error {
+		reportPhaseProgress(PhaseBootloader, 1, 1, "writing")
+		<-release
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	err = obj.Call(DBusInterface+".Rollback", 0).Err
+	var dbusErr dbus.Error
+	if !errors.As(err, &dbusErr) || dbusErr.Name != dbusErrorPrefix+CodeOperationInProgress {
+		t.Errorf("Rollback() while busy error = %v, want %s", err, dbusErrorPrefix+CodeOperationInProgress)
+	}
+	close(release)
+	m.Wait()
+
+	// Progress carries each event, Finished the result document
+	var progress []ProgressEvent
+	timeout := time.After(5 * time.Second)
+	for {
+		var sig *dbus.Signal
+		select {
+		case sig = <-signals:
+		case <-timeout:
+			t.Fatalf("timed out waiting for Finished, got progress %+v", progress)
+		}
+		switch sig.Name {
+		case DBusInterface + ".Progress":
+			var event ProgressEvent
+			if err := json.Unmarshal([]byte(sig.Body[0].(string)), &event); err != nil {
+				t.Fatalf("invalid Progress event: %v", err)
+			}
+			if event.SchemaVersion != EventSchemaVersion {
+				t.Errorf("schema_version = %d, want %d", event.SchemaVersion, EventSchemaVersion)
+			}
+			progress = append(progress, event)
+			continue
+		case DBusInterface + ".Finished":
+		default:
+			continue
+		}
+
+		if len(sig.Body) != 4 || sig.Body[0] != OperationUpdate || sig.Body[1] != StatusSuccess {
+			t.Errorf("Finished%v, want update success", sig.Body)
+		}
+		var result OperationResult
+		if err := json.Unmarshal([]byte(sig.Body[3].(string)), &result); err != nil || result.Operation != OperationUpdate {
+			t.Errorf("Finished result = %s, %v", sig.Body[3], err)
+		}
+		break
+	}
+	if len(progress) != 3 || progress[0].Type != EventPhaseProgress || progress[1].Type != EventComplete || progress[2].Type != EventResult {
+		t.Errorf("Progress events = %+v, want phase_progress, complete, result", progress)
+	}
+
+	var xml string
+	if err := obj.Call("org.freedesktop.DBus.Introspectable.Introspect", 0).Store(&xml); err != nil {
+		t.Fatalf("Introspect() error = %v", err)
+	}
+	if !strings.Contains(xml, `<signal name="Finished">`) {
+		t.Errorf("introspection data does not describe the interface:\n%s", xml)
+	}
+}
@@ -0,0 +1,99 @@
+package pkg
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// DeploymentFile records, inside each root, the image the root was deployed
+// from. It lives in /usr so the /etc merge of the next update never carries it
+// over to another root.
+const DeploymentFile = "usr/lib/phukit/deployment.json"
+
+// bootedRoot is where the running root is mounted, a variable for tests
+var bootedRoot = "/"
+
+// Deployment describes the image installed on one root partition
+type Deployment struct {
+	ImageRef     string    `json:"image_ref"`
+	ImageDigest  string    `json:"image_digest,omitempty"`
+	Version      string    `json:"version,omitempty"`       // VERSION_ID from os-release
+	ImageVersion string    `json:"image_version,omitempty"` // org.opencontainers.image.version label
+	Revision     string    `json:"revision,omitempty"`      // org.opencontainers.image.revision label
+	KernelArgs   []string  `json:"kernel_args,omitempty"`   // Default kernel arguments of the image
+	Timestamp    time.Time `json:"timestamp"`               // When the root was written
+	Pinned       bool      `json:"pinned,omitempty"`
+}
+
+// WriteDeployment records d in the root mounted at root
+func WriteDeployment(root string, d *Deployment, dryRun bool) error {
+	path := filepath.Join(root, DeploymentFile)
+	if dryRun {
+		fmt.Printf("[DRY RUN] Would write deployment record to %s\n", path)
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create deployment record directory: %w", err)
+	}
+	data, err := json.MarshalIndent(d, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal deployment record: %w", err)
+	}
+	if err := writeFileAtomic(path, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to write deployment record: %w", err)
+	}
+	return nil
+}
+
+// ReadDeployment reads the deployment record of the root mounted at root.
+// Roots written before deployment records existed fall back to the system
+// config in their /etc, which has no timestamp.
+func ReadDeployment(root string) (*Deployment, error) {
+	data, err := os.ReadFile(filepath.Join(root, DeploymentFile))
+	if os.IsNotExist(err) {
+		return readLegacyDeployment(root)
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read deployment record: %w", err)
+	}
+
+	var d Deployment
+	if err := json.Unmarshal(data, &d); err != nil {
+		return nil, fmt.Errorf("failed to parse deployment record: %w", err)
+	}
+	return &d, nil
+}
+
+// readLegacyDeployment builds a Deployment from the system config of root
+func readLegacyDeployment(root string) (*Deployment, error) {
+	data, err := os.ReadFile(filepath.Join(root, SystemConfigFile))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read deployment record: %w", err)
+	}
+	var config SystemConfig
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("failed to parse system config: %w", err)
+	}
+	return &Deployment{
+		ImageRef:    config.ImageRef,
+		ImageDigest: config.ImageDigest,
+		Version:     osReleaseValue(root, "VERSION_ID"),
+		Pinned:      config.PinnedDigest != "",
+	}, nil
+}
+
+// newDeployment describes the image with labels being written to the root
+// at root
+func newDeployment(root, imageRef, imageDigest string, labels map[string]string, pinned bool) *Deployment {
+	return &Deployment{
+		ImageRef:     imageRef,
+		ImageDigest:  imageDigest,
+		Version:      osReleaseValue(root, "VERSION_ID"),
+		ImageVersion: labels[LabelImageVersion],
+		Revision:     labels[LabelImageRevision],
+		Timestamp:    time.Now().UTC(),
+		Pinned:       pinned,
+	}
+}
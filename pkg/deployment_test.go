@@ -0,0 +1,61 @@
+package pkg
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestDeploymentRoundTrip(t *testing.T) {
+	root := t.TempDir()
+	want := &Deployment{
+		ImageRef:    "quay.io/example/os:latest",
+		ImageDigest: "sha256:abc",
+		Version:     "42",
+		KernelArgs:  []string{"quiet"},
+		Timestamp:   time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC),
+		Pinned:      true,
+	}
+	if err := WriteDeployment(root, want, false); err != nil {
+		t.Fatalf("WriteDeployment() error = %v", err)
+	}
+	got, err := ReadDeployment(root)
+	if err != nil {
+		t.Fatalf("ReadDeployment() error = %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ReadDeployment() = %+v, want %+v", got, want)
+	}
+}
+
+func TestReadDeploymentLegacy(t *testing.T) {
+	root := t.TempDir()
+	files := map[string]string{
+		SystemConfigFile:     `{"image_ref": "quay.io/example/os:v1", "image_digest": "sha256:def", "pinned_digest": "sha256:def"}`,
+		"usr/lib/os-release": "NAME=Example\nVERSION_ID=\"41\"\n",
+	}
+	for name, content := range files {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got, err := ReadDeployment(root)
+	if err != nil {
+		t.Fatalf("ReadDeployment() error = %v", err)
+	}
+	want := Deployment{ImageRef: "quay.io/example/os:v1", ImageDigest: "sha256:def", Version: "41", Pinned: true}
+	if !reflect.DeepEqual(*got, want) {
+		t.Errorf("ReadDeployment() = %+v, want %+v", got, want)
+	}
+
+	if _, err := ReadDeployment(t.TempDir()); err == nil {
+		t.Error("ReadDeployment() of an empty root succeeded, want error")
+	}
+}
@@ -10,6 +10,16 @@ import (
 // GetBootDeviceFromPartition extracts the parent disk device from a partition path
 // Example: /dev/sda3 -> /dev/sda, /dev/nvme0n1p3 -> /dev/nvme0n1
 func GetBootDeviceFromPartition(partition string) (string, error) {
+	// The partitions of multipath and other device-mapper disks are mappings
+	// whose names cannot be parsed
+	if disk := dmPartitionDisk(partition); disk != "" {
+		return disk, nil
+	}
+	// An open LUKS container is on the disk of the partition holding it
+	if backing := luksBackingDevice(partition); backing != "" {
+		return GetBootDeviceFromPartition(backing)
+	}
+
 	// Remove /dev/ prefix if present
 	partition = strings.TrimPrefix(partition, "/dev/")
 
@@ -49,6 +59,11 @@ func GetCurrentBootDevice() (string, error) {
 		return "", fmt.Errorf("failed to determine active root partition: %w", err)
 	}
 
+	// The root of an LVM install is a logical volume on a partition
+	if pv := logicalVolumePartition(rootPartition); pv != "" {
+		rootPartition = pv
+	}
+
 	// Extract the parent device
 	device, err := GetBootDeviceFromPartition(rootPartition)
 	if err != nil {
@@ -57,7 +72,7 @@ func GetCurrentBootDevice() (string, error) {
 
 	// Verify the device exists
 	if _, err := os.Stat(device); os.IsNotExist(err) {
-		return "", fmt.Errorf("device %s does not exist", device)
+		return "", fmt.Errorf("%w: %s does not exist", ErrDeviceNotFound, device)
 	}
 
 	return device, nil
@@ -0,0 +1,432 @@
+package pkg
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// procMountsPath lists mounted filesystems, overridable in tests
+var procMountsPath = "/proc/mounts"
+
+// procSwapsPath lists active swap areas, overridable in tests
+var procSwapsPath = "/proc/swaps"
+
+// ErrDeviceBusy is returned when a disk or one of its partitions is in use
+var ErrDeviceBusy = errors.New("device is in use")
+
+// Kinds of device usage found by FindDeviceUsage
+const (
+	UsageMount = "mount" // Filesystem is mounted
+	UsageSwap  = "swap"  // Active swap area
+	UsageLVM   = "lvm"   // Physical volume with active LVM logical volumes
+	UsageCrypt = "crypt" // Open dm-crypt/LUKS mapping
+	UsageRAID  = "raid"  // Member of an active md RAID array
+	UsageDM    = "dm"    // Other device-mapper holder (multipath, ...)
+)
+
+// systemMountPoints are the mount points of the running system; a disk
+// holding one of them is never wiped, even with --force-unmount
+var systemMountPoints = []string{"/", "/sysroot", "/usr", "/var", "/boot", "/boot/efi", "/efi"}
+
+// DeviceUsage describes one way a disk or partition is in use
+type DeviceUsage struct {
+	Device string // Partition or mapped device that is in use
+	Kind   string
+	Target string // Mount point for mounts, mapping name for holders
+}
+
+// String returns a human-readable description of the usage
+func (u DeviceUsage) String() string {
+	switch u.Kind {
+	case UsageMount:
+		return fmt.Sprintf("%s is mounted at %s", u.Device, u.Target)
+	case UsageSwap:
+		return fmt.Sprintf("%s is active swap", u.Device)
+	case UsageLVM:
+		return fmt.Sprintf("%s is held by LVM volume %s", u.Device, u.Target)
+	case UsageCrypt:
+		return fmt.Sprintf("%s is held by encrypted mapping %s", u.Device, u.Target)
+	case UsageRAID:
+		return fmt.Sprintf("%s is a member of RAID array %s", u.Device, u.Target)
+	default:
+		return fmt.Sprintf("%s is held by device-mapper device %s", u.Device, u.Target)
+	}
+}
+
+// DeviceInUseError lists everything keeping a device busy
+type DeviceInUseError struct {
+	Device string
+	Usages []DeviceUsage
+}
+
+func (e *DeviceInUseError) Error() string {
+	lines := make([]string, 0, len(e.Usages))
+	for _, u := range e.Usages {
+		lines = append(lines, "  - "+u.String())
+	}
+	return fmt.Sprintf("%s is in use (unmount/deactivate first or use --force-unmount):\n%s",
+		e.Device, strings.Join(lines, "\n"))
+}
+
+// Is reports whether target is ErrDeviceBusy
+func (e *DeviceInUseError) Is(target error) bool {
+	return target == ErrDeviceBusy
+}
+
+// dmHolder is a device-mapper device or md array stacked on top of a disk or
+// partition
+type dmHolder struct {
+	node string // Kernel name, e.g. dm-0 or md0
+	name string // Mapping name, e.g. vg-root
+	uuid string // DM uuid, prefixed by the subsystem (LVM-, CRYPT-, mpath-, ...)
+	raid bool   // md RAID array rather than a device-mapper device
+}
+
+// FindDeviceUsage enumerates mounts, swap, md RAID arrays and device-mapper
+// holders (LVM, dm-crypt, multipath) on a disk and all of its partitions
+func FindDeviceUsage(device string) ([]DeviceUsage, error) {
+	if resolved, err := filepath.EvalSymlinks(device); err == nil {
+		device = resolved
+	}
+	name := filepath.Base(device)
+
+	nodes, err := diskNodes(name)
+	if err != nil {
+		return nil, err
+	}
+
+	// Map every device path that may appear in /proc/mounts or /proc/swaps
+	// back to a node, including device-mapper devices stacked on top
+	paths := map[string]string{}
+	// A holder spanning several partitions is only reported once
+	seen := map[string]bool{}
+	var usages []DeviceUsage
+	for _, node := range nodes {
+		// Device-mapper disks and partitions are known by their /dev/mapper name
+		nodePath := filepath.Join(devPath, node)
+		if dmName(node) != "" {
+			nodePath = dmDevicePath(node)
+		}
+		paths[filepath.Join(devPath, node)] = nodePath
+		paths[nodePath] = nodePath
+		for _, h := range collectHolders(node, seen) {
+			mapped := filepath.Join(devPath, h.node)
+			paths[mapped] = mapped
+			if h.name != "" {
+				paths[filepath.Join(devPath, "mapper", h.name)] = mapped
+			}
+			kind := holderKind(h.uuid)
+			if h.raid {
+				kind = UsageRAID
+			}
+			usages = append(usages, DeviceUsage{
+				Device: nodePath,
+				Kind:   kind,
+				Target: holderLabel(h),
+			})
+		}
+	}
+
+	mounts, err := readMounts()
+	if err != nil {
+		return nil, err
+	}
+	for _, m := range mounts {
+		if dev, ok := paths[m.source]; ok {
+			usages = append(usages, DeviceUsage{Device: dev, Kind: UsageMount, Target: m.target})
+		}
+	}
+
+	swaps, err := readSwaps()
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range swaps {
+		if dev, ok := paths[s]; ok {
+			usages = append(usages, DeviceUsage{Device: dev, Kind: UsageSwap})
+		}
+	}
+
+	return usages, nil
+}
+
+// diskNodes returns the kernel name of a disk followed by its partitions,
+// including the partition mappings of a device-mapper disk
+func diskNodes(name string) ([]string, error) {
+	diskDir := filepath.Join(sysClassBlockPath, name)
+	if _, err := os.Stat(diskDir); err != nil {
+		return nil, fmt.Errorf("failed to find %s in sysfs: %w", name, err)
+	}
+
+	nodes := []string{name}
+	entries, err := os.ReadDir(diskDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list partitions of %s: %w", name, err)
+	}
+	for _, entry := range entries {
+		if !strings.HasPrefix(entry.Name(), name) {
+			continue
+		}
+		if _, err := os.Stat(filepath.Join(diskDir, entry.Name(), "partition")); err == nil {
+			nodes = append(nodes, entry.Name())
+		}
+	}
+	return append(nodes, dmPartitionNodes(name)...), nil
+}
+
+// collectHolders returns the holders of node, outermost last
+func collectHolders(node string, seen map[string]bool) []dmHolder {
+	entries, err := os.ReadDir(filepath.Join(sysClassBlockPath, node, "holders"))
+	if err != nil {
+		return nil
+	}
+
+	var holders []dmHolder
+	for _, entry := range entries {
+		h := entry.Name()
+		if seen[h] {
+			continue
+		}
+		// Partition mappings are partitions of the disk, not users of it
+		if partitionMapNumber(readSysfsValue(filepath.Join(sysClassBlockPath, h, "dm", "uuid"))) > 0 {
+			continue
+		}
+		seen[h] = true
+
+		holder := dmHolder{node: h}
+		if _, err := os.Stat(filepath.Join(sysClassBlockPath, h, "md")); err == nil {
+			holder.raid = true
+		}
+		if data, err := os.ReadFile(filepath.Join(sysClassBlockPath, h, "dm", "name")); err == nil {
+			holder.name = strings.TrimSpace(string(data))
+		}
+		if data, err := os.ReadFile(filepath.Join(sysClassBlockPath, h, "dm", "uuid")); err == nil {
+			holder.uuid = strings.TrimSpace(string(data))
+		}
+		holders = append(holders, holder)
+		holders = append(holders, collectHolders(h, seen)...)
+	}
+	return holders
+}
+
+// holderKind classifies a device-mapper holder by its uuid prefix
+func holderKind(uuid string) string {
+	switch {
+	case strings.HasPrefix(uuid, "LVM-"):
+		return UsageLVM
+	case strings.HasPrefix(uuid, "CRYPT-"):
+		return UsageCrypt
+	default:
+		return UsageDM
+	}
+}
+
+// holderLabel returns the mapping name of a holder, falling back to its kernel name
+func holderLabel(h dmHolder) string {
+	if h.name != "" {
+		return h.name
+	}
+	return h.node
+}
+
+// mountEntry is a line from /proc/mounts
+type mountEntry struct {
+	source string
+	target string
+	fsType string
+}
+
+// readMounts parses procMountsPath
+func readMounts() ([]mountEntry, error) {
+	f, err := os.Open(procMountsPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read mounts: %w", err)
+	}
+	defer func() { _ = f.Close() }()
+
+	var mounts []mountEntry
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 3 {
+			continue
+		}
+		mounts = append(mounts, mountEntry{
+			source: unescapeMountField(fields[0]),
+			target: unescapeMountField(fields[1]),
+			fsType: fields[2],
+		})
+	}
+	return mounts, scanner.Err()
+}
+
+// readSwaps returns the active swap areas listed in procSwapsPath
+func readSwaps() ([]string, error) {
+	f, err := os.Open(procSwapsPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read swaps: %w", err)
+	}
+	defer func() { _ = f.Close() }()
+
+	var swaps []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || fields[0] == "Filename" {
+			continue
+		}
+		swaps = append(swaps, unescapeMountField(fields[0]))
+	}
+	return swaps, scanner.Err()
+}
+
+// unescapeMountField decodes the octal escapes (\040 etc.) used in /proc/mounts
+func unescapeMountField(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+3 < len(s) {
+			if v, err := strconv.ParseUint(s[i+1:i+4], 8, 8); err == nil {
+				b.WriteByte(byte(v))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// ensureDeviceNotInUse returns a DeviceInUseError if anything is using device
+func ensureDeviceNotInUse(device string) error {
+	usages, err := FindDeviceUsage(device)
+	if err != nil {
+		return fmt.Errorf("failed to check whether %s is in use: %w", device, err)
+	}
+	if len(usages) > 0 {
+		return &DeviceInUseError{Device: device, Usages: usages}
+	}
+	return nil
+}
+
+// ensureNotSystemDisk refuses device if the running system was booted from it
+// or has its root, /usr, /var or boot filesystems on it
+func ensureNotSystemDisk(device string) error {
+	usages, err := FindDeviceUsage(device)
+	if err != nil {
+		return fmt.Errorf("failed to check whether %s is in use: %w", device, err)
+	}
+	return checkNotSystemDisk(device, usages)
+}
+
+// checkNotSystemDisk is ensureNotSystemDisk with the usages of device
+// already found
+func checkNotSystemDisk(device string, usages []DeviceUsage) error {
+	for _, u := range usages {
+		if u.Kind == UsageMount && slices.Contains(systemMountPoints, u.Target) {
+			return fmt.Errorf("%w: %s holds the running system (%s is mounted at %s); boot from other media to install to it",
+				ErrDeviceBusy, device, u.Device, u.Target)
+		}
+	}
+	if boot, err := GetCurrentBootDevice(); err == nil && sameDevice(boot, device) {
+		return fmt.Errorf("%w: %s is the disk the running system booted from; boot from other media to install to it",
+			ErrDeviceBusy, device)
+	}
+	return nil
+}
+
+// sameDevice reports whether two device paths name the same device
+func sameDevice(a, b string) bool {
+	if resolved, err := filepath.EvalSymlinks(a); err == nil {
+		a = resolved
+	}
+	if resolved, err := filepath.EvalSymlinks(b); err == nil {
+		b = resolved
+	}
+	return a == b
+}
+
+// ReleaseDevice unmounts filesystems, disables swap, stops md RAID arrays
+// and removes device-mapper holders on a disk so it can be wiped; the disk
+// of the running system is refused
+func ReleaseDevice(ctx context.Context, device string, dryRun bool) error {
+	usages, err := FindDeviceUsage(device)
+	if err != nil {
+		return fmt.Errorf("failed to check whether %s is in use: %w", device, err)
+	}
+	if err := checkNotSystemDisk(device, usages); err != nil {
+		return err
+	}
+	if len(usages) == 0 {
+		return nil
+	}
+
+	var mounts, swaps, holders []DeviceUsage
+	for _, u := range usages {
+		switch u.Kind {
+		case UsageMount:
+			mounts = append(mounts, u)
+		case UsageSwap:
+			swaps = append(swaps, u)
+		default:
+			holders = append(holders, u)
+		}
+	}
+
+	// Unmount nested mount points before their parents
+	sort.SliceStable(mounts, func(i, j int) bool {
+		return len(mounts[i].Target) > len(mounts[j].Target)
+	})
+
+	if dryRun {
+		for _, u := range usages {
+			fmt.Printf("[DRY RUN] Would release %s\n", u)
+		}
+		return nil
+	}
+
+	fmt.Printf("Releasing %s...\n", device)
+	for _, u := range mounts {
+		fmt.Printf("  Unmounting %s\n", u.Target)
+		if err := unmount(u.Target); err != nil {
+			return fmt.Errorf("failed to release %s: %w", device, err)
+		}
+	}
+	for _, u := range swaps {
+		fmt.Printf("  Disabling swap on %s\n", u.Device)
+		if output, err := runCommand(ctx, "swapoff", u.Device); err != nil {
+			return fmt.Errorf("failed to disable swap on %s: %w\nOutput: %s", u.Device, err, string(output))
+		}
+	}
+	// Holders are collected innermost first, so remove stacked devices in reverse
+	for i := len(holders) - 1; i >= 0; i-- {
+		u := holders[i]
+		if u.Kind == UsageRAID {
+			fmt.Printf("  Stopping RAID array %s\n", u.Target)
+			array := filepath.Join(devPath, u.Target)
+			if output, err := runCommand(ctx, "mdadm", "--stop", array); err != nil {
+				return fmt.Errorf("failed to stop %s: %w\nOutput: %s", array, err, string(output))
+			}
+			continue
+		}
+		fmt.Printf("  Removing device-mapper device %s\n", u.Target)
+		if output, err := runCommand(ctx, "dmsetup", "remove", u.Target); err != nil {
+			return fmt.Errorf("failed to remove %s: %w\nOutput: %s", u.Target, err, string(output))
+		}
+	}
+
+	return ensureDeviceNotInUse(device)
+}
@@ -0,0 +1,178 @@
+package pkg
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// setupFakeBlockDevices builds a fake sysfs and /proc for vdb with two
+// partitions: vdb1 mounted at /mnt/my data, vdb2 an LVM PV whose volume
+// is mounted at /srv and sits under a dm-crypt mapping, plus swap on vdb3
+func setupFakeBlockDevices(t *testing.T) {
+	t.Helper()
+	root := t.TempDir()
+	oldClass, oldDev, oldMounts, oldSwaps := sysClassBlockPath, devPath, procMountsPath, procSwapsPath
+	sysClassBlockPath = filepath.Join(root, "class")
+	devPath = "/dev"
+	procMountsPath = filepath.Join(root, "mounts")
+	procSwapsPath = filepath.Join(root, "swaps")
+	t.Cleanup(func() {
+		sysClassBlockPath, devPath, procMountsPath, procSwapsPath = oldClass, oldDev, oldMounts, oldSwaps
+	})
+
+	for _, part := range []string{"vdb1", "vdb2", "vdb3"} {
+		writeFakeFile(t, filepath.Join(sysClassBlockPath, "vdb", part, "partition"), "1")
+		if err := os.MkdirAll(filepath.Join(sysClassBlockPath, part, "holders"), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeFakeFile(t, filepath.Join(sysClassBlockPath, "vdb2", "holders", "dm-0"), "")
+	writeFakeFile(t, filepath.Join(sysClassBlockPath, "dm-0", "dm", "name"), "vg-data\n")
+	writeFakeFile(t, filepath.Join(sysClassBlockPath, "dm-0", "dm", "uuid"), "LVM-abc\n")
+	writeFakeFile(t, filepath.Join(sysClassBlockPath, "dm-0", "holders", "dm-1"), "")
+	writeFakeFile(t, filepath.Join(sysClassBlockPath, "dm-1", "dm", "name"), "secret\n")
+	writeFakeFile(t, filepath.Join(sysClassBlockPath, "dm-1", "dm", "uuid"), "CRYPT-LUKS2-abc-secret\n")
+
+	writeFakeFile(t, procMountsPath, strings.Join([]string{
+		"/dev/vda1 / ext4 rw 0 0",
+		`/dev/vdb1 /mnt/my\040data ext4 rw 0 0`,
+		"/dev/mapper/secret /srv xfs rw 0 0",
+		"/dev/vdb1 /mnt/my\\040data/nested ext4 rw 0 0",
+	}, "\n")+"\n")
+	writeFakeFile(t, procSwapsPath, "Filename\tType\tSize\tUsed\tPriority\n/dev/vdb3 partition 1024 0 -2\n")
+}
+
+func TestFindDeviceUsage(t *testing.T) {
+	setupFakeBlockDevices(t)
+
+	usages, err := FindDeviceUsage("/dev/vdb")
+	if err != nil {
+		t.Fatalf("FindDeviceUsage failed: %v", err)
+	}
+
+	want := []DeviceUsage{
+		{Device: "/dev/vdb2", Kind: UsageLVM, Target: "vg-data"},
+		{Device: "/dev/vdb2", Kind: UsageCrypt, Target: "secret"},
+		{Device: "/dev/vdb1", Kind: UsageMount, Target: "/mnt/my data"},
+		{Device: "/dev/dm-1", Kind: UsageMount, Target: "/srv"},
+		{Device: "/dev/vdb1", Kind: UsageMount, Target: "/mnt/my data/nested"},
+		{Device: "/dev/vdb3", Kind: UsageSwap},
+	}
+	if len(usages) != len(want) {
+		t.Fatalf("got %d usages, want %d: %v", len(usages), len(want), usages)
+	}
+	for i := range want {
+		if usages[i] != want[i] {
+			t.Errorf("usage %d = %+v, want %+v", i, usages[i], want[i])
+		}
+	}
+}
+
+func TestEnsureDeviceNotInUse(t *testing.T) {
+	setupFakeBlockDevices(t)
+
+	err := ensureDeviceNotInUse("/dev/vdb")
+	if !errors.Is(err, ErrDeviceBusy) {
+		t.Fatalf("expected ErrDeviceBusy, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "/dev/vdb3 is active swap") || !strings.Contains(err.Error(), "--force-unmount") {
+		t.Errorf("error does not describe usages: %v", err)
+	}
+
+	// A disk with nothing on it is free
+	if err := os.MkdirAll(filepath.Join(sysClassBlockPath, "vdc"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ensureDeviceNotInUse("/dev/vdc"); err != nil {
+		t.Errorf("unused disk reported in use: %v", err)
+	}
+}
+
+func TestReleaseDevice(t *testing.T) {
+	setupFakeBlockDevices(t)
+	fm := setupFakeMounter(t, "ext4")
+	fake, _ := setupDryRunExecutor(t)
+
+	// The fakes don't change the fake /proc files, so the final check still fails
+	if err := ReleaseDevice(context.Background(), "/dev/vdb", false); !errors.Is(err, ErrDeviceBusy) {
+		t.Fatalf("expected ErrDeviceBusy after fake release, got %v", err)
+	}
+
+	wantUnmounts := []string{"/mnt/my data/nested", "/mnt/my data", "/srv"}
+	if len(fm.unmounts) != len(wantUnmounts) {
+		t.Fatalf("unmounts = %v, want %v", fm.unmounts, wantUnmounts)
+	}
+	for i, w := range wantUnmounts {
+		if fm.unmounts[i] != w {
+			t.Errorf("unmount %d = %q, want %q", i, fm.unmounts[i], w)
+		}
+	}
+
+	// Stacked devices are removed outermost first
+	wantCommands := []string{"swapoff /dev/vdb3", "dmsetup remove secret", "dmsetup remove vg-data"}
+	if len(fake.Commands) != len(wantCommands) {
+		t.Fatalf("commands = %v, want %v", fake.Commands, wantCommands)
+	}
+	for i, w := range wantCommands {
+		if got := fake.Commands[i].String(); got != w {
+			t.Errorf("command %d = %q, want %q", i, got, w)
+		}
+	}
+}
+
+func TestFindDeviceUsageRAID(t *testing.T) {
+	setupFakeBlockDevices(t)
+	for _, part := range []string{"vdc1"} {
+		writeFakeFile(t, filepath.Join(sysClassBlockPath, "vdc", part, "partition"), "1")
+	}
+	writeFakeFile(t, filepath.Join(sysClassBlockPath, "vdc1", "holders", "md0"), "")
+	writeFakeFile(t, filepath.Join(sysClassBlockPath, "md0", "md", "level"), "raid1\n")
+
+	usages, err := FindDeviceUsage("/dev/vdc")
+	if err != nil {
+		t.Fatalf("FindDeviceUsage failed: %v", err)
+	}
+	want := DeviceUsage{Device: "/dev/vdc1", Kind: UsageRAID, Target: "md0"}
+	if len(usages) != 1 || usages[0] != want {
+		t.Fatalf("usages = %v, want %v", usages, want)
+	}
+	if got := usages[0].String(); got != "/dev/vdc1 is a member of RAID array md0" {
+		t.Errorf("String() = %q", got)
+	}
+
+	fake, _ := setupDryRunExecutor(t)
+	if err := ReleaseDevice(context.Background(), "/dev/vdc", false); !errors.Is(err, ErrDeviceBusy) {
+		t.Fatalf("expected ErrDeviceBusy after fake release, got %v", err)
+	}
+	if len(fake.Commands) != 1 || fake.Commands[0].String() != "mdadm --stop /dev/md0" {
+		t.Errorf("commands = %v, want mdadm --stop /dev/md0", fake.Commands)
+	}
+}
+
+func TestEnsureNotSystemDisk(t *testing.T) {
+	setupFakeBlockDevices(t)
+	writeFakeFile(t, filepath.Join(sysClassBlockPath, "vda", "vda1", "partition"), "1")
+
+	// vdb is busy, but not with the running system
+	if err := ensureNotSystemDisk("/dev/vdb"); err != nil {
+		t.Errorf("ensureNotSystemDisk(vdb) = %v", err)
+	}
+
+	err := ensureNotSystemDisk("/dev/vda")
+	if !errors.Is(err, ErrDeviceBusy) || !strings.Contains(err.Error(), "holds the running system") {
+		t.Fatalf("expected the root disk to be refused, got %v", err)
+	}
+
+	// --force-unmount must not release the running system either
+	fake, _ := setupDryRunExecutor(t)
+	if err := ReleaseDevice(context.Background(), "/dev/vda", false); !errors.Is(err, ErrDeviceBusy) {
+		t.Fatalf("expected ReleaseDevice to refuse the root disk, got %v", err)
+	}
+	if len(fake.Commands) != 0 {
+		t.Errorf("root disk release ran commands: %v", fake.Commands)
+	}
+}
@@ -0,0 +1,443 @@
+package pkg
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"cmp"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"syscall"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+)
+
+// Kinds of change reported by DiffImage
+const (
+	ChangeAdded   = "added"
+	ChangeRemoved = "removed"
+	ChangeChanged = "changed"
+)
+
+// SystemDiff is what installing an image would change on the booted system
+type SystemDiff struct {
+	Booted         *Deployment     `json:"booted,omitempty"`
+	ImageRef       string          `json:"image_ref"`
+	Digest         string          `json:"digest"`
+	PackageManager string          `json:"package_manager,omitempty"` // rpm or dpkg; "" if packages were not compared
+	Packages       []PackageChange `json:"packages"`
+	Files          []FileChange    `json:"files"`
+}
+
+// PackageChange is a package added, removed or changed in version
+type PackageChange struct {
+	Name   string `json:"name"`
+	Arch   string `json:"arch,omitempty"`
+	Change string `json:"change"`
+	From   string `json:"from,omitempty"` // Installed version, "" if added
+	To     string `json:"to,omitempty"`   // Version in the image, "" if removed
+}
+
+// FileChange is a file in /usr added, removed or changed by the image
+type FileChange struct {
+	Path     string `json:"path"`
+	Change   string `json:"change"`
+	FromSize int64  `json:"from_size,omitempty"`
+	ToSize   int64  `json:"to_size,omitempty"`
+}
+
+// ChangeCounts returns the number of added, removed and changed files
+func (d *SystemDiff) ChangeCounts() (added, removed, changed int) {
+	for _, f := range d.Files {
+		switch f.Change {
+		case ChangeAdded:
+			added++
+		case ChangeRemoved:
+			removed++
+		case ChangeChanged:
+			changed++
+		}
+	}
+	return added, removed, changed
+}
+
+// rpmDBPaths are where rpm keeps its database, relative to the root; bootc
+// images use the first, /var/lib/rpm is often a symlink to it
+var rpmDBPaths = []string{"usr/lib/sysimage/rpm", "var/lib/rpm"}
+
+// dpkgStatusPath is the dpkg database, relative to the root
+const dpkgStatusPath = "var/lib/dpkg/status"
+
+// diffFile is what is compared of a file in /usr
+type diffFile struct {
+	typ      byte // tar type flag
+	mode     int64
+	uid, gid int
+	size     int64
+	link     string
+}
+
+// DiffImage compares the booted system with imageRef, or with the latest image
+// of the booted deployment's reference if imageRef is empty. Packages are
+// compared through the rpm or dpkg database and files in /usr by type, mode,
+// owner, size and link target; with checksum, files of equal size are also
+// compared by content. The image is read from the registry, so this takes as
+// long as a pull.
+func DiffImage(ctx context.Context, imageRef string, checksum bool) (*SystemDiff, error) {
+	booted, err := ReadDeployment(bootedRoot)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read booted deployment: %w", err)
+	}
+	if imageRef == "" {
+		imageRef = booted.ImageRef
+	}
+
+	ref, err := name.ParseReference(imageRef)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidImageReference, err)
+	}
+	img, err := remoteImage(ctx, ref)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch image: %w", registryError(err))
+	}
+	digest, err := img.Digest()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get image digest: %w", err)
+	}
+	diff := &SystemDiff{Booted: booted, ImageRef: imageRef, Digest: digest.String(), Packages: []PackageChange{}, Files: []FileChange{}}
+
+	local, err := localFiles(bootedRoot)
+	if err != nil {
+		return nil, err
+	}
+
+	// The rpm database of the image is extracted here to query it
+	rpmDir, err := os.MkdirTemp("", "phukit-diff-rpmdb-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temporary directory: %w", err)
+	}
+	defer func() { _ = os.RemoveAll(rpmDir) }()
+
+	rc := mutate.Extract(img)
+	defer func() { _ = rc.Close() }()
+	scan, err := diffFilesystem(ctx, tar.NewReader(rc), bootedRoot, local, rpmDir, checksum)
+	if err != nil {
+		return nil, err
+	}
+	diff.Files = scan.files
+
+	if err := diff.diffPackages(ctx, scan, rpmDir); err != nil {
+		return nil, err
+	}
+	return diff, nil
+}
+
+// localFiles returns the files in /usr of the root mounted at root, keyed by
+// their path relative to the root
+func localFiles(root string) (map[string]diffFile, error) {
+	files := map[string]diffFile{}
+	err := filepath.WalkDir(filepath.Join(root, "usr"), func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		} else if rel == DeploymentFile {
+			return nil // Written by phukit, never in an image
+		}
+		f := diffFile{mode: int64(info.Mode().Perm())}
+		if info.Mode()&fs.ModeSetuid != 0 {
+			f.mode |= 04000
+		}
+		if info.Mode()&fs.ModeSetgid != 0 {
+			f.mode |= 02000
+		}
+		if info.Mode()&fs.ModeSticky != 0 {
+			f.mode |= 01000
+		}
+		if st, ok := info.Sys().(*syscall.Stat_t); ok {
+			f.uid, f.gid = int(st.Uid), int(st.Gid)
+		}
+		switch {
+		case info.Mode().IsRegular():
+			f.typ, f.size = tar.TypeReg, info.Size()
+		case info.IsDir():
+			f.typ = tar.TypeDir
+		case info.Mode()&fs.ModeSymlink != 0:
+			f.typ = tar.TypeSymlink
+			if f.link, err = os.Readlink(p); err != nil {
+				return err
+			}
+		default:
+			f.typ = tar.TypeChar // Devices, fifos and sockets are only compared by presence
+		}
+		files[filepath.ToSlash(rel)] = f
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", filepath.Join(root, "usr"), err)
+	}
+	return files, nil
+}
+
+// diffScan is what diffFilesystem found in the image
+type diffScan struct {
+	files      []FileChange
+	checked    int    // Entries in /usr compared
+	rpmDB      bool   // The rpm database was extracted
+	dpkgStatus []byte // nil if the image has no dpkg database
+}
+
+// diffFilesystem compares /usr of the flattened image filesystem in tr with
+// local, the files of the root mounted at root. The rpm database is
+// extracted to rpmDir on the way, unless it is "".
+func diffFilesystem(ctx context.Context, tr *tar.Reader, root string, local map[string]diffFile, rpmDir string, checksum bool) (*diffScan, error) {
+	scan := &diffScan{}
+	seen := map[string]diffFile{}
+	rpmDB := ""
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("failed to read image filesystem: %w", registryError(err))
+		}
+		p := path.Clean(strings.TrimPrefix(hdr.Name, "/"))
+
+		if p == dpkgStatusPath && hdr.Typeflag == tar.TypeReg {
+			if scan.dpkgStatus, err = io.ReadAll(tr); err != nil {
+				return nil, fmt.Errorf("failed to read %s: %w", p, err)
+			}
+			continue
+		}
+		consumed := false // The content was read, so it cannot be checksummed
+		if dir, ok := rpmDBDir(p); ok && rpmDir != "" && hdr.Typeflag == tar.TypeReg && (rpmDB == "" || rpmDB == dir) {
+			rpmDB = dir
+			if err := extractRPMDBFile(tr, filepath.Join(rpmDir, path.Base(p))); err != nil {
+				return nil, err
+			}
+			scan.rpmDB, consumed = true, true
+		}
+		if p != "usr" && !strings.HasPrefix(p, "usr/") {
+			continue
+		}
+
+		f := diffFile{typ: hdr.Typeflag, mode: hdr.Mode & 07777, uid: hdr.Uid, gid: hdr.Gid, size: hdr.Size, link: hdr.Linkname}
+		switch hdr.Typeflag {
+		case tar.TypeReg, tar.TypeRegA:
+			f.typ = tar.TypeReg
+		case tar.TypeLink:
+			// A hard link is a regular file on disk
+			target := seen[path.Clean(strings.TrimPrefix(hdr.Linkname, "/"))]
+			f.typ, f.size, f.link = tar.TypeReg, target.size, ""
+		case tar.TypeDir, tar.TypeSymlink:
+		default:
+			f = diffFile{typ: tar.TypeChar}
+		}
+		seen[p] = f
+		scan.checked++
+
+		l, ok := local[p]
+		if !ok {
+			scan.files = append(scan.files, FileChange{Path: "/" + p, Change: ChangeAdded, ToSize: f.size})
+			continue
+		}
+		delete(local, p)
+		changed := l.typ != f.typ || l.size != f.size || l.link != f.link ||
+			(f.typ != tar.TypeChar && (l.mode != f.mode || l.uid != f.uid || l.gid != f.gid))
+		if !changed && checksum && !consumed && f.typ == tar.TypeReg && hdr.Typeflag != tar.TypeLink {
+			if changed, err = contentDiffers(tr, filepath.Join(root, p)); err != nil {
+				return nil, err
+			}
+		}
+		if changed {
+			scan.files = append(scan.files, FileChange{Path: "/" + p, Change: ChangeChanged, FromSize: l.size, ToSize: f.size})
+		}
+	}
+	for p, l := range local {
+		scan.files = append(scan.files, FileChange{Path: "/" + p, Change: ChangeRemoved, FromSize: l.size})
+	}
+	slices.SortFunc(scan.files, func(a, b FileChange) int { return strings.Compare(a.Path, b.Path) })
+	return scan, nil
+}
+
+// rpmDBDir returns the rpm database directory p is directly in
+func rpmDBDir(p string) (string, bool) {
+	dir := path.Dir(p)
+	return dir, slices.Contains(rpmDBPaths, dir)
+}
+
+// extractRPMDBFile writes the current tar entry to dst
+func extractRPMDBFile(r io.Reader, dst string) error {
+	f, err := os.Create(dst)
+	if err != nil {
+		return fmt.Errorf("failed to extract rpm database: %w", err)
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		_ = f.Close()
+		return fmt.Errorf("failed to extract rpm database: %w", err)
+	}
+	return f.Close()
+}
+
+// contentDiffers reports whether r holds other content than the file at p
+func contentDiffers(r io.Reader, p string) (bool, error) {
+	want := sha256.New()
+	if _, err := io.Copy(want, r); err != nil {
+		return false, fmt.Errorf("failed to read image file %s: %w", p, err)
+	}
+	f, err := os.Open(p)
+	if err != nil {
+		return false, fmt.Errorf("failed to read %s: %w", p, err)
+	}
+	defer func() { _ = f.Close() }()
+	got := sha256.New()
+	if _, err := io.Copy(got, f); err != nil {
+		return false, fmt.Errorf("failed to read %s: %w", p, err)
+	}
+	return !bytes.Equal(want.Sum(nil), got.Sum(nil)), nil
+}
+
+// diffPackages compares the package databases of the booted system and the
+// image. A missing rpm tool is a warning, as the file changes still stand.
+func (d *SystemDiff) diffPackages(ctx context.Context, scan *diffScan, rpmDir string) error {
+	var from, to map[string]PackageChange
+	switch {
+	case scan.rpmDB:
+		localDB := ""
+		for _, p := range rpmDBPaths {
+			if _, err := os.Stat(filepath.Join(bootedRoot, p)); err == nil {
+				localDB = filepath.Join(bootedRoot, p)
+				break
+			}
+		}
+		if localDB == "" {
+			return warnf("the booted system has no rpm database; packages not compared")
+		}
+		if _, err := executor.LookPath("rpm"); err != nil {
+			return warnf("rpm is not installed; packages not compared")
+		}
+		var err error
+		if from, err = rpmPackages(ctx, localDB); err != nil {
+			return err
+		}
+		if to, err = rpmPackages(ctx, rpmDir); err != nil {
+			return err
+		}
+		d.PackageManager = "rpm"
+	case scan.dpkgStatus != nil:
+		data, err := os.ReadFile(filepath.Join(bootedRoot, dpkgStatusPath))
+		if err != nil {
+			return warnf("failed to read the booted dpkg database; packages not compared: %v", err)
+		}
+		from, to = dpkgPackages(data), dpkgPackages(scan.dpkgStatus)
+		d.PackageManager = "dpkg"
+	default:
+		return nil
+	}
+	d.Packages = packageChanges(from, to)
+	return nil
+}
+
+// rpmPackages lists the packages in the rpm database in dbPath by name.arch
+func rpmPackages(ctx context.Context, dbPath string) (map[string]PackageChange, error) {
+	var out bytes.Buffer
+	err := executor.Run(ctx, Command{
+		Name:   "rpm",
+		Args:   []string{"--dbpath", dbPath, "-qa", "--qf", `%{NAME}\t%{ARCH}\t%{EPOCHNUM}:%{VERSION}-%{RELEASE}\n`},
+		Stdout: &out,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to query rpm database %s: %w", dbPath, err)
+	}
+	pkgs := map[string]PackageChange{}
+	for line := range strings.Lines(out.String()) {
+		fields := strings.Split(strings.TrimSpace(line), "\t")
+		if len(fields) != 3 {
+			continue
+		}
+		pkg := PackageChange{Name: fields[0], Arch: fields[1], From: strings.TrimPrefix(fields[2], "0:")}
+		pkgs[pkg.Name+"."+pkg.Arch] = pkg
+	}
+	return pkgs, nil
+}
+
+// dpkgPackages lists the installed packages of a dpkg status file by name:arch
+func dpkgPackages(status []byte) map[string]PackageChange {
+	pkgs := map[string]PackageChange{}
+	var pkg PackageChange
+	installed := false
+	add := func() {
+		if pkg.Name != "" && installed {
+			pkgs[pkg.Name+":"+pkg.Arch] = pkg
+		}
+		pkg, installed = PackageChange{}, false
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(status))
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			add()
+			continue
+		}
+		key, value, ok := strings.Cut(line, ":")
+		if !ok || strings.HasPrefix(line, " ") {
+			continue
+		}
+		value = strings.TrimSpace(value)
+		switch key {
+		case "Package":
+			pkg.Name = value
+		case "Architecture":
+			pkg.Arch = value
+		case "Version":
+			pkg.From = value
+		case "Status":
+			installed = strings.HasSuffix(value, " installed")
+		}
+	}
+	add()
+	return pkgs
+}
+
+// packageChanges compares two package lists with the version in From
+func packageChanges(from, to map[string]PackageChange) []PackageChange {
+	changes := []PackageChange{}
+	for key, f := range from {
+		t, ok := to[key]
+		switch {
+		case !ok:
+			changes = append(changes, PackageChange{Name: f.Name, Arch: f.Arch, Change: ChangeRemoved, From: f.From})
+		case t.From != f.From:
+			changes = append(changes, PackageChange{Name: f.Name, Arch: f.Arch, Change: ChangeChanged, From: f.From, To: t.From})
+		}
+	}
+	for key, t := range to {
+		if _, ok := from[key]; !ok {
+			changes = append(changes, PackageChange{Name: t.Name, Arch: t.Arch, Change: ChangeAdded, To: t.From})
+		}
+	}
+	slices.SortFunc(changes, func(a, b PackageChange) int {
+		return cmp.Or(strings.Compare(a.Name, b.Name), strings.Compare(a.Arch, b.Arch))
+	})
+	return changes
+}
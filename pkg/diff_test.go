@@ -0,0 +1,180 @@
+package pkg
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+)
+
+// setupBootedRoot creates a booted root from path/content pairs like
+// testLayer, with the deployment record d
+func setupBootedRoot(t *testing.T, d *Deployment, files ...string) {
+	t.Helper()
+	oldRoot := bootedRoot
+	bootedRoot = t.TempDir()
+	t.Cleanup(func() { bootedRoot = oldRoot })
+
+	for i := 0; i < len(files); i += 2 {
+		p := filepath.Join(bootedRoot, files[i])
+		if strings.HasSuffix(files[i], "/") {
+			if err := os.MkdirAll(p, 0755); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.Chmod(p, 0755); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		if err := os.WriteFile(p, []byte(files[i+1]), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chmod(p, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := WriteDeployment(bootedRoot, d, false); err != nil {
+		t.Fatal(err)
+	}
+}
+
+const dpkgStatusBooted = `Package: bash
+Status: install ok installed
+Architecture: amd64
+Version: 5.1-2
+Description: GNU Bourne Again SHell
+ This is a continuation line: not a field
+
+Package: coreutils
+Status: install ok installed
+Architecture: amd64
+Version: 8.32-4
+
+Package: purged
+Status: deinstall ok config-files
+Architecture: all
+Version: 1.0
+`
+
+const dpkgStatusImage = `Package: bash
+Status: install ok installed
+Architecture: amd64
+Version: 5.2-1
+
+Package: coreutils
+Status: install ok installed
+Architecture: amd64
+Version: 8.32-4
+
+Package: curl
+Status: install ok installed
+Architecture: amd64
+Version: 7.88.1-10
+`
+
+func TestDiffImage(t *testing.T) {
+	layer := testLayer(t,
+		"usr/", "",
+		"usr/bin/", "",
+		"usr/bin/same", "same",
+		"usr/bin/edited", "bbbb",
+		"usr/bin/new", "new",
+		"usr/lib/", "",
+		"usr/lib/phukit/", "",
+		"var/lib/dpkg/status", dpkgStatusImage,
+	)
+	img, err := mutate.AppendLayers(empty.Image, layer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	imageRef := pushTestImage(t, img)
+	setupBootedRoot(t, &Deployment{ImageRef: imageRef},
+		"usr/", "",
+		"usr/bin/", "",
+		"usr/bin/same", "same",
+		"usr/bin/edited", "aaaa",
+		"usr/bin/old", "old",
+		"usr/lib/", "",
+		"usr/lib/phukit/", "",
+		"var/", "",
+		"var/lib/", "",
+		"var/lib/dpkg/", "",
+		"var/lib/dpkg/status", dpkgStatusBooted,
+	)
+
+	tests := []struct {
+		name     string
+		checksum bool
+		want     []FileChange
+	}{
+		{"metadata", false, []FileChange{
+			{Path: "/usr/bin/new", Change: ChangeAdded, ToSize: 3},
+			{Path: "/usr/bin/old", Change: ChangeRemoved, FromSize: 3},
+		}},
+		{"checksum", true, []FileChange{
+			{Path: "/usr/bin/edited", Change: ChangeChanged, FromSize: 4, ToSize: 4},
+			{Path: "/usr/bin/new", Change: ChangeAdded, ToSize: 3},
+			{Path: "/usr/bin/old", Change: ChangeRemoved, FromSize: 3},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// The booted image's reference is the default
+			diff, err := DiffImage(t.Context(), "", tt.checksum)
+			if err != nil {
+				t.Fatalf("DiffImage() error = %v", err)
+			}
+			if diff.ImageRef != imageRef || diff.Booted == nil || diff.Booted.ImageRef != imageRef {
+				t.Errorf("ImageRef = %s, Booted = %+v", diff.ImageRef, diff.Booted)
+			}
+			if !slices.Equal(diff.Files, tt.want) {
+				t.Errorf("Files = %+v, want %+v", diff.Files, tt.want)
+			}
+			if added, removed, changed := diff.ChangeCounts(); added != 1 || removed != 1 || changed != len(tt.want)-2 {
+				t.Errorf("ChangeCounts() = %d, %d, %d", added, removed, changed)
+			}
+
+			wantPackages := []PackageChange{
+				{Name: "bash", Arch: "amd64", Change: ChangeChanged, From: "5.1-2", To: "5.2-1"},
+				{Name: "curl", Arch: "amd64", Change: ChangeAdded, To: "7.88.1-10"},
+			}
+			if diff.PackageManager != "dpkg" || !slices.Equal(diff.Packages, wantPackages) {
+				t.Errorf("PackageManager = %q, Packages = %+v, want %+v", diff.PackageManager, diff.Packages, wantPackages)
+			}
+		})
+	}
+}
+
+func TestRPMPackages(t *testing.T) {
+	fake := setupScriptedExecutor(t)
+	for dbPath, packages := range map[string]string{
+		"/booted": "kernel\tx86_64\t0:6.1.0-1.fc42\nglibc\ti686\t0:2.41-1.fc42\nshadow-utils\tx86_64\t2:4.17-1.fc42\n",
+		"/image":  "kernel\tx86_64\t0:6.2.0-1.fc42\nglibc\tx86_64\t0:2.41-1.fc42\nshadow-utils\tx86_64\t2:4.17-1.fc42\n",
+	} {
+		fake.answer("rpm --dbpath "+dbPath, packages)
+	}
+
+	from, err := rpmPackages(t.Context(), "/booted")
+	if err != nil {
+		t.Fatal(err)
+	}
+	to, err := rpmPackages(t.Context(), "/image")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if from["shadow-utils.x86_64"].From != "2:4.17-1.fc42" {
+		t.Errorf("epoch dropped: %+v", from["shadow-utils.x86_64"])
+	}
+	want := []PackageChange{
+		{Name: "glibc", Arch: "i686", Change: ChangeRemoved, From: "2.41-1.fc42"},
+		{Name: "glibc", Arch: "x86_64", Change: ChangeAdded, To: "2.41-1.fc42"},
+		{Name: "kernel", Arch: "x86_64", Change: ChangeChanged, From: "6.1.0-1.fc42", To: "6.2.0-1.fc42"},
+	}
+	if got := packageChanges(from, to); !slices.Equal(got, want) {
+		t.Errorf("packageChanges() = %+v, want %+v", got, want)
+	}
+}
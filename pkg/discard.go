@@ -0,0 +1,41 @@
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// Discard
+//
+// Flash storage slows down as it fills up unless it is told which blocks
+// are free. When the target disk supports discard (TRIM on SATA SSDs,
+// deallocate on NVMe, unmap on thin-provisioned disks), the root and /var
+// filesystems are created with all their blocks discarded, and the installed
+// system gets fstrim.timer enabled, which discards the blocks freed since
+// once a week. Online discard is left off, as it slows down deletes on many
+// devices; it can still be turned on with --mount-option.
+
+// fstrimTimer is the systemd timer that runs fstrim periodically
+const fstrimTimer = "fstrim.timer"
+
+// supportsDiscard reports whether the disk device supports discard
+func supportsDiscard(device string) bool {
+	value := readSysfsValue(filepath.Join(sysClassBlockPath, blockDeviceName(device), "queue", "discard_max_bytes"))
+	n, err := strconv.ParseUint(value, 10, 64)
+	return err == nil && n > 0
+}
+
+// enableFstrimTimer enables the periodic fstrim timer in the root at root,
+// if the image ships it
+func enableFstrimTimer(ctx context.Context, root string) error {
+	for _, dir := range []string{"usr/lib/systemd/system", "lib/systemd/system"} {
+		if _, err := os.Stat(filepath.Join(root, dir, fstrimTimer)); err == nil {
+			return systemctlInRoot(ctx, root, "enable", fstrimTimer)
+		}
+	}
+	fmt.Printf("  %s is not in the image, not enabling periodic discard\n", fstrimTimer)
+	return nil
+}
@@ -0,0 +1,68 @@
+package pkg
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+)
+
+func TestSupportsDiscard(t *testing.T) {
+	oldClass := sysClassBlockPath
+	sysClassBlockPath = t.TempDir()
+	t.Cleanup(func() { sysClassBlockPath = oldClass })
+
+	writeFakeFile(t, filepath.Join(sysClassBlockPath, "nvme0n1", "queue", "discard_max_bytes"), "2199023255040\n")
+	writeFakeFile(t, filepath.Join(sysClassBlockPath, "sda", "queue", "discard_max_bytes"), "0\n")
+
+	if !supportsDiscard("/dev/nvme0n1") {
+		t.Error("supportsDiscard(/dev/nvme0n1) = false, want true")
+	}
+	for _, device := range []string{"/dev/sda", "/dev/vdb"} {
+		if supportsDiscard(device) {
+			t.Errorf("supportsDiscard(%s) = true, want false", device)
+		}
+	}
+}
+
+func TestFormatCommandDiscard(t *testing.T) {
+	tests := []struct {
+		fsType  string
+		discard bool
+		want    string
+	}{
+		{"ext4", true, "mkfs.ext4 -F -L var -E discard /dev/sda4"},
+		{"ext4", false, "mkfs.ext4 -F -L var /dev/sda4"},
+		{"btrfs", true, "mkfs.btrfs -f -L var /dev/sda4"},
+	}
+	for _, tt := range tests {
+		cmd, err := formatCommand("/dev/sda4", tt.fsType, "var", tt.discard)
+		if err != nil {
+			t.Fatalf("formatCommand(%s, %v) error = %v", tt.fsType, tt.discard, err)
+		}
+		if got := cmd.String(); got != tt.want {
+			t.Errorf("formatCommand(%s, %v) = %q, want %q", tt.fsType, tt.discard, got, tt.want)
+		}
+	}
+}
+
+func TestEnableFstrimTimer(t *testing.T) {
+	fake, _ := setupDryRunExecutor(t)
+	root := t.TempDir()
+
+	// Images without the timer are left alone
+	if err := enableFstrimTimer(context.Background(), root); err != nil {
+		t.Fatalf("enableFstrimTimer() error = %v", err)
+	}
+	if len(fake.Commands) != 0 {
+		t.Errorf("commands = %v, want none", fake.Commands)
+	}
+
+	writeFakeFile(t, filepath.Join(root, "usr", "lib", "systemd", "system", fstrimTimer), "[Timer]\n")
+	if err := enableFstrimTimer(context.Background(), root); err != nil {
+		t.Fatalf("enableFstrimTimer() error = %v", err)
+	}
+	want := "systemctl --root=" + root + " enable fstrim.timer"
+	if len(fake.Commands) != 1 || fake.Commands[0].String() != want {
+		t.Errorf("commands = %v, want [%s]", fake.Commands, want)
+	}
+}
@@ -2,9 +2,9 @@ package pkg
 
 import (
 	"bufio"
+	"context"
 	"fmt"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"regexp"
 	"strconv"
@@ -13,19 +13,23 @@ import (
 
 // DiskInfo represents information about a physical disk
 type DiskInfo struct {
-	Device      string
-	Size        uint64
-	Model       string
-	IsRemovable bool
-	Partitions  []PartitionInfo
+	Device      string          `json:"device"`
+	Size        uint64          `json:"size"`
+	Model       string          `json:"model,omitempty"`
+	Serial      string          `json:"serial,omitempty"`
+	Transport   string          `json:"transport,omitempty"` // nvme, sata, usb, virtio, scsi, ...
+	IsRemovable bool            `json:"removable"`
+	Rotational  bool            `json:"rotational"`
+	Mounted     bool            `json:"mounted"` // A partition is mounted
+	Partitions  []PartitionInfo `json:"partitions"`
 }
 
 // PartitionInfo represents information about a disk partition
 type PartitionInfo struct {
-	Device     string
-	Size       uint64
-	MountPoint string
-	FileSystem string
+	Device     string `json:"device"`
+	Size       uint64 `json:"size"`
+	MountPoint string `json:"mount_point,omitempty"`
+	FileSystem string `json:"filesystem,omitempty"`
 }
 
 // ListDisks returns a list of available physical disks
@@ -92,15 +96,60 @@ func getDiskInfo(device string) (DiskInfo, error) {
 		info.Model = strings.TrimSpace(string(modelData))
 	}
 
+	// Get serial number (NVMe and virtio; SCSI disks expose it via udev only)
+	for _, name := range []string{"serial", "device/serial"} {
+		if serialData, err := os.ReadFile(filepath.Join("/sys/block", device, name)); err == nil {
+			info.Serial = strings.TrimSpace(string(serialData))
+			break
+		}
+	}
+
+	if data, err := os.ReadFile(filepath.Join("/sys/block", device, "queue", "rotational")); err == nil {
+		info.Rotational = strings.TrimSpace(string(data)) == "1"
+	}
+	if path, err := filepath.EvalSymlinks(filepath.Join("/sys/block", device)); err == nil {
+		info.Transport = diskTransport(device, path)
+	}
+
 	// Get partitions
+	info.Partitions = []PartitionInfo{}
 	partitions, err := getPartitions(device)
 	if err == nil {
 		info.Partitions = partitions
 	}
+	for _, part := range info.Partitions {
+		if part.MountPoint != "" {
+			info.Mounted = true
+		}
+	}
 
 	return info, nil
 }
 
+// diskTransport returns how the disk named name is attached, judged by its
+// name and the device path sysPath its /sys/block entry resolves to
+func diskTransport(name, sysPath string) string {
+	switch {
+	case strings.HasPrefix(name, "nvme"):
+		return "nvme"
+	case strings.HasPrefix(name, "vd"):
+		return "virtio"
+	case strings.Contains(sysPath, "/usb"):
+		return "usb"
+	case strings.Contains(sysPath, "/ata"):
+		return "sata"
+	case strings.Contains(sysPath, "/session"):
+		return "iscsi"
+	case strings.Contains(sysPath, "/virtio"):
+		return "virtio"
+	case strings.Contains(sysPath, "/mmc"):
+		return "mmc"
+	case strings.HasPrefix(name, "sd"):
+		return "scsi"
+	}
+	return ""
+}
+
 // getPartitions returns partition information for a disk
 func getPartitions(device string) ([]PartitionInfo, error) {
 	partitions := []PartitionInfo{}
@@ -111,6 +160,17 @@ func getPartitions(device string) ([]PartitionInfo, error) {
 	if err != nil {
 		return nil, err
 	}
+	paths := map[string]string{}
+	for _, partDir := range partDirs {
+		paths[partDir] = "/dev/" + filepath.Base(partDir)
+	}
+
+	// The partitions of a device-mapper disk are mappings held on it
+	for _, part := range dmPartitionNodes(device) {
+		partDir := filepath.Join("/sys/block", part)
+		partDirs = append(partDirs, partDir)
+		paths[partDir] = dmDevicePath(part)
+	}
 
 	for _, partDir := range partDirs {
 		partName := filepath.Base(partDir)
@@ -119,7 +179,7 @@ func getPartitions(device string) ([]PartitionInfo, error) {
 		}
 
 		partInfo := PartitionInfo{
-			Device: "/dev/" + partName,
+			Device: paths[partDir],
 		}
 
 		// Get partition size
@@ -141,6 +201,10 @@ func getPartitions(device string) ([]PartitionInfo, error) {
 				}
 			}
 		}
+		// Unmounted filesystems are identified by their superblock
+		if partInfo.FileSystem == "" {
+			partInfo.FileSystem, _ = readFilesystemType(partInfo.Device)
+		}
 
 		partitions = append(partitions, partInfo)
 	}
@@ -150,21 +214,34 @@ func getPartitions(device string) ([]PartitionInfo, error) {
 
 // ValidateDisk checks if a disk is suitable for installation
 func ValidateDisk(device string, minSize uint64) error {
+	return validateDisk(device, minSize, true)
+}
+
+// validateDisk checks the disk size and, if checkInUse is set, that nothing is using it
+func validateDisk(device string, minSize uint64, checkInUse bool) error {
 	// Check if device exists
 	if _, err := os.Stat(device); os.IsNotExist(err) {
-		return fmt.Errorf("device %s does not exist", device)
+		return fmt.Errorf("%w: %s does not exist", ErrDeviceNotFound, device)
 	}
 
 	// Get disk info
-	deviceName := strings.TrimPrefix(device, "/dev/")
-	diskInfo, err := getDiskInfo(deviceName)
+	diskInfo, err := getDiskInfo(blockDeviceName(device))
 	if err != nil {
 		return fmt.Errorf("failed to get disk info: %w", err)
 	}
 
 	// Check minimum size
 	if diskInfo.Size < minSize {
-		return fmt.Errorf("disk is too small: %d bytes (minimum: %d bytes)", diskInfo.Size, minSize)
+		return &InsufficientSpaceError{Device: device, Size: diskInfo.Size, Required: minSize}
+	}
+
+	if !checkInUse {
+		return nil
+	}
+
+	// The disk of the running system cannot be released for the install
+	if err := ensureNotSystemDisk(device); err != nil {
+		return err
 	}
 
 	// Check if any partitions are mounted
@@ -174,26 +251,61 @@ func ValidateDisk(device string, minSize uint64) error {
 		}
 	}
 
-	return nil
+	// Check for swap, LVM, dm-crypt and other holders
+	return ensureDeviceNotInUse(device)
 }
 
-// WipeDisk securely wipes a disk's partition table
-func WipeDisk(device string, dryRun bool) error {
+// WipeDisk wipes a disk's partition table, erasing its data first as mode
+// requires
+func WipeDisk(ctx context.Context, device string, mode WipeMode, dryRun bool) error {
 	if dryRun {
-		fmt.Printf("[DRY RUN] Would wipe disk: %s\n", device)
-		return nil
+		cmd, erase := eraseCommand(device, mode)
+		if erase {
+			fmt.Printf("[DRY RUN] Would erase (%s) and wipe disk: %s\n", mode, device)
+		} else {
+			fmt.Printf("[DRY RUN] Would wipe disk: %s\n", device)
+		}
+		if !tracing() {
+			return nil
+		}
+		// Record the commands of the wipe; the GPT is cleared without one
+		if erase {
+			if _, err := runCommand(ctx, cmd.Name, cmd.Args...); err != nil {
+				return err
+			}
+		}
+		_, err := runCommand(ctx, "wipefs", "--all", device)
+		return err
+	}
+
+	// Refuse to wipe a disk the kernel is still using, otherwise the wipe
+	// races the kernel and can leave the disk half-wiped
+	if err := ensureDeviceNotInUse(device); err != nil {
+		return err
+	}
+
+	// The partition mappings of a device-mapper disk outlive its table
+	if isDeviceMapper(device) {
+		if err := removeDMPartitions(ctx, device); err != nil {
+			return err
+		}
+	}
+
+	if cmd, ok := eraseCommand(device, mode); ok {
+		fmt.Printf("  Erasing %s (%s), this can take a while...\n", device, mode)
+		if output, err := runCommand(ctx, cmd.Name, cmd.Args...); err != nil {
+			return fmt.Errorf("failed to erase disk: %w\nOutput: %s", err, string(output))
+		}
 	}
 
 	// Use wipefs to remove filesystem signatures
-	cmd := exec.Command("wipefs", "--all", device)
-	if output, err := cmd.CombinedOutput(); err != nil {
+	if output, err := runCommand(ctx, "wipefs", "--all", device); err != nil {
 		return fmt.Errorf("failed to wipe disk: %w\nOutput: %s", err, string(output))
 	}
 
-	// Use sgdisk to zap GPT structures
-	cmd = exec.Command("sgdisk", "--zap-all", device)
-	if output, err := cmd.CombinedOutput(); err != nil {
-		return fmt.Errorf("failed to zap GPT: %w\nOutput: %s", err, string(output))
+	// Zap primary and backup GPT structures
+	if err := zapGPT(device); err != nil {
+		return fmt.Errorf("failed to zap GPT: %w", err)
 	}
 
 	return nil
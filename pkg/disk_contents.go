@@ -0,0 +1,111 @@
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// Disk contents
+//
+// Before a disk is wiped, the confirmation prompt shows what is on it: its
+// model and size, the existing partitions with their size, filesystem, GPT
+// name and mount point, and the operating systems os-prober finds on them,
+// so the wrong drive is recognised before it is too late. Operating systems
+// are only detected when os-prober is installed.
+
+// detectedOS is an operating system os-prober found on a partition
+type detectedOS struct {
+	partition string
+	name      string
+}
+
+// parseOSProber parses the output of os-prober, one
+// partition[@bootloader]:long name:short name:type line per system
+func parseOSProber(output string) []detectedOS {
+	var systems []detectedOS
+	for line := range strings.Lines(output) {
+		fields := strings.Split(strings.TrimSpace(line), ":")
+		if len(fields) < 3 {
+			continue
+		}
+		partition, _, _ := strings.Cut(fields[0], "@")
+		name := fields[1]
+		if name == "" {
+			name = fields[2]
+		}
+		systems = append(systems, detectedOS{partition: partition, name: name})
+	}
+	return systems
+}
+
+// detectOperatingSystems returns the operating systems os-prober finds on
+// partitions, or none if os-prober is not installed
+func detectOperatingSystems(ctx context.Context, partitions []string) []detectedOS {
+	if _, err := executor.LookPath("os-prober"); err != nil {
+		return nil
+	}
+	output, err := runCommand(ctx, "os-prober")
+	if err != nil {
+		return nil
+	}
+	var systems []detectedOS
+	for _, system := range parseOSProber(string(output)) {
+		for _, part := range partitions {
+			if sameDevice(system.partition, part) {
+				systems = append(systems, system)
+				break
+			}
+		}
+	}
+	return systems
+}
+
+// diskContents describes what is on device for the confirmation prompt
+func diskContents(ctx context.Context, device string) []string {
+	info, err := getDiskInfo(blockDeviceName(device))
+	if err != nil {
+		return nil
+	}
+
+	header := fmt.Sprintf("Current contents of %s (%s", device, FormatSize(info.Size))
+	if info.Model != "" {
+		header += ", " + info.Model
+	}
+	lines := []string{"", header + "):"}
+	if len(info.Partitions) == 0 {
+		return append(lines, "  no partitions")
+	}
+
+	partitions := make([]string, 0, len(info.Partitions))
+	for _, part := range info.Partitions {
+		partitions = append(partitions, part.Device)
+		lines = append(lines, "  "+describePartition(part))
+	}
+
+	if systems := detectOperatingSystems(ctx, partitions); len(systems) > 0 {
+		lines = append(lines, "", "Operating systems found:")
+		for _, system := range systems {
+			lines = append(lines, fmt.Sprintf("  %s on %s", system.name, system.partition))
+		}
+	}
+	return lines
+}
+
+// describePartition formats one partition line of the disk contents
+func describePartition(part PartitionInfo) string {
+	fsType := part.FileSystem
+	if fsType == "" {
+		fsType = "-"
+	}
+
+	line := fmt.Sprintf("%-16s %10s  %-6s", part.Device, FormatSize(part.Size), fsType)
+	if label := ueventValue(filepath.Join(sysClassBlockPath, blockDeviceName(part.Device), "uevent"), "PARTNAME"); label != "" {
+		line += fmt.Sprintf(" %q", label)
+	}
+	if part.MountPoint != "" {
+		line += " mounted at " + part.MountPoint
+	}
+	return strings.TrimRight(line, " ")
+}
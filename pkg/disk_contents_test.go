@@ -0,0 +1,48 @@
+package pkg
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestParseOSProber(t *testing.T) {
+	output := "/dev/sda1@/efi/Microsoft/Boot/bootmgfw.efi:Windows Boot Manager:Windows:efi\n" +
+		"/dev/sda3:Ubuntu 24.04 LTS (24.04):Ubuntu:linux\n" +
+		"/dev/sdb2::Debian:linux\n" +
+		"garbage\n"
+
+	want := []detectedOS{
+		{partition: "/dev/sda1", name: "Windows Boot Manager"},
+		{partition: "/dev/sda3", name: "Ubuntu 24.04 LTS (24.04)"},
+		{partition: "/dev/sdb2", name: "Debian"},
+	}
+	got := parseOSProber(output)
+	if len(got) != len(want) {
+		t.Fatalf("parseOSProber() = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("system %d = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestDescribePartition(t *testing.T) {
+	old := sysClassBlockPath
+	sysClassBlockPath = t.TempDir()
+	t.Cleanup(func() { sysClassBlockPath = old })
+	writeFakeFile(t, filepath.Join(sysClassBlockPath, "vdb1", "uevent"), "MAJOR=252\nPARTN=1\nPARTNAME=EFI System\n")
+
+	got := describePartition(PartitionInfo{Device: "/dev/vdb1", Size: 512 * 1024 * 1024, FileSystem: "vfat", MountPoint: "/boot/efi"})
+	want := `/dev/vdb1          512.0 MB  vfat   "EFI System" mounted at /boot/efi`
+	if got != want {
+		t.Errorf("describePartition() = %q, want %q", got, want)
+	}
+
+	// An unreadable partition has no known filesystem
+	got = describePartition(PartitionInfo{Device: "/dev/vdb2", Size: 1024 * 1024 * 1024})
+	want = "/dev/vdb2            1.0 GB  -"
+	if got != want {
+		t.Errorf("describePartition() = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,101 @@
+package pkg
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// DiskSelector picks the install disk by rules instead of by name, so one
+// answer file fits machines whose disks enumerate differently. Every rule
+// that is set must match.
+type DiskSelector struct {
+	Names   []string // Device names or /dev/disk/by-* links, any of which may match
+	MinSize uint64
+	MaxSize uint64
+	Model   string // Shell pattern, case-insensitive
+	Serial  string // Shell pattern, case-insensitive
+}
+
+// Empty reports whether no rule is set
+func (s DiskSelector) Empty() bool {
+	return len(s.Names) == 0 && s.MinSize == 0 && s.MaxSize == 0 && s.Model == "" && s.Serial == ""
+}
+
+// globMatch reports whether value matches the shell pattern, ignoring case
+func globMatch(pattern, value string) bool {
+	ok, err := path.Match(strings.ToLower(pattern), strings.ToLower(value))
+	return err == nil && ok
+}
+
+// matchesName reports whether disk is one of the selector's names
+func (s DiskSelector) matchesName(disk DiskInfo) bool {
+	if len(s.Names) == 0 {
+		return true
+	}
+	for _, name := range s.Names {
+		device := name
+		if !strings.HasPrefix(device, "/dev/") {
+			device = "/dev/" + device
+		}
+		if resolved, err := filepath.EvalSymlinks(device); err == nil {
+			device = resolved
+		}
+		if device == disk.Device {
+			return true
+		}
+	}
+	return false
+}
+
+// Matches reports whether disk matches every rule
+func (s DiskSelector) Matches(disk DiskInfo) bool {
+	return s.matchesName(disk) &&
+		(s.MinSize == 0 || disk.Size >= s.MinSize) &&
+		(s.MaxSize == 0 || disk.Size <= s.MaxSize) &&
+		(s.Model == "" || globMatch(s.Model, disk.Model)) &&
+		(s.Serial == "" || globMatch(s.Serial, disk.Serial))
+}
+
+// Select returns the one disk among disks matching every rule. Removable
+// disks are never selected, and more than one match is an error rather than
+// a guess, as the disk is wiped.
+func (s DiskSelector) Select(disks []DiskInfo) (string, error) {
+	var matches []string
+	for _, disk := range disks {
+		if !disk.IsRemovable && s.Matches(disk) {
+			matches = append(matches, disk.Device)
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return "", fmt.Errorf("%w: no disk matches the selection rules", ErrDeviceNotFound)
+	case 1:
+		return matches[0], nil
+	}
+	return "", fmt.Errorf("%d disks match the selection rules (%s); add rules to pick one", len(matches), strings.Join(matches, ", "))
+}
+
+// DiskFilter narrows down a disk listing. Every rule that is set must match;
+// unlike DiskSelector, removable disks are listed too.
+type DiskFilter struct {
+	DiskSelector
+	Removable *bool  // Only removable (true) or only fixed (false) disks
+	Transport string // e.g. nvme, sata, usb
+	Unmounted bool   // Only disks with no partition mounted
+}
+
+// Filter returns the disks matching every rule
+func (f DiskFilter) Filter(disks []DiskInfo) []DiskInfo {
+	matches := []DiskInfo{}
+	for _, disk := range disks {
+		if f.Matches(disk) &&
+			(f.Removable == nil || disk.IsRemovable == *f.Removable) &&
+			(f.Transport == "" || strings.EqualFold(f.Transport, disk.Transport)) &&
+			(!f.Unmounted || !disk.Mounted) {
+			matches = append(matches, disk)
+		}
+	}
+	return matches
+}
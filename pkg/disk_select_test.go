@@ -0,0 +1,84 @@
+package pkg
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestDiskSelectorSelect(t *testing.T) {
+	disks := []DiskInfo{
+		{Device: "/dev/sda", Size: 64 << 30, Model: "QEMU HARDDISK"},
+		{Device: "/dev/nvme0n1", Size: 512 << 30, Model: "Samsung SSD 980", Serial: "S64ANS0T123"},
+		{Device: "/dev/nvme1n1", Size: 2 << 40, Model: "Samsung SSD 990", Serial: "S7KHNJ0W456"},
+		{Device: "/dev/sdb", Size: 1 << 40, Model: "USB Flash", IsRemovable: true},
+	}
+
+	tests := []struct {
+		name string
+		sel  DiskSelector
+		want string
+	}{
+		{"by name", DiskSelector{Names: []string{"sda"}}, "/dev/sda"},
+		{"by size range", DiskSelector{MinSize: 100 << 30, MaxSize: 1 << 40}, "/dev/nvme0n1"},
+		{"by model pattern", DiskSelector{Model: "samsung*990"}, "/dev/nvme1n1"},
+		{"by serial", DiskSelector{Serial: "S64ANS0T123"}, "/dev/nvme0n1"},
+		{"removable never selected", DiskSelector{Names: []string{"sdb"}}, ""},
+		{"ambiguous", DiskSelector{Model: "Samsung*"}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.sel.Select(disks)
+			if tt.want == "" {
+				if err == nil {
+					t.Errorf("Select() = %q, want error", got)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Errorf("Select() = %q, %v, want %q", got, err, tt.want)
+			}
+		})
+	}
+
+	if _, err := (DiskSelector{MinSize: 4 << 40}).Select(disks); !errors.Is(err, ErrDeviceNotFound) {
+		t.Errorf("Select() error = %v, want ErrDeviceNotFound", err)
+	}
+}
+
+func TestDiskFilter(t *testing.T) {
+	disks := []DiskInfo{
+		{Device: "/dev/sda", Size: 64 << 30, Transport: "sata", Mounted: true},
+		{Device: "/dev/nvme0n1", Size: 512 << 30, Transport: "nvme"},
+		{Device: "/dev/sdb", Size: 32 << 30, Transport: "usb", IsRemovable: true},
+	}
+	removable, fixed := true, false
+
+	tests := []struct {
+		name   string
+		filter DiskFilter
+		want   []string
+	}{
+		{"no rules", DiskFilter{}, []string{"/dev/sda", "/dev/nvme0n1", "/dev/sdb"}},
+		{"removable", DiskFilter{Removable: &removable}, []string{"/dev/sdb"}},
+		{"fixed", DiskFilter{Removable: &fixed}, []string{"/dev/sda", "/dev/nvme0n1"}},
+		{"transport", DiskFilter{Transport: "NVMe"}, []string{"/dev/nvme0n1"}},
+		{"unmounted and large", DiskFilter{DiskSelector: DiskSelector{MinSize: 40 << 30}, Unmounted: true}, []string{"/dev/nvme0n1"}},
+		{"nothing", DiskFilter{DiskSelector: DiskSelector{MinSize: 1 << 40}}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.filter.Filter(disks)
+			if got == nil {
+				t.Fatal("Filter() returned nil, want an empty list for JSON")
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("Filter() = %v, want %v", got, tt.want)
+			}
+			for i, disk := range got {
+				if disk.Device != tt.want[i] {
+					t.Errorf("disk %d = %s, want %s", i, disk.Device, tt.want[i])
+				}
+			}
+		})
+	}
+}
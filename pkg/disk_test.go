@@ -143,3 +143,23 @@ func TestGetDiskByPath(t *testing.T) {
 		})
 	}
 }
+
+func TestDiskTransport(t *testing.T) {
+	tests := []struct {
+		name, path, want string
+	}{
+		{"nvme0n1", "/sys/devices/pci0000:00/0000:00:1d.0/0000:3d:00.0/nvme/nvme0/nvme0n1", "nvme"},
+		{"vda", "/sys/devices/pci0000:00/0000:00:04.0/virtio1/block/vda", "virtio"},
+		{"sda", "/sys/devices/pci0000:00/0000:00:17.0/ata1/host0/target0:0:0/0:0:0:0/block/sda", "sata"},
+		{"sdb", "/sys/devices/pci0000:00/0000:00:14.0/usb2/2-1/2-1:1.0/host4/target4:0:0/4:0:0:0/block/sdb", "usb"},
+		{"sdc", "/sys/devices/platform/host5/session1/target5:0:0/5:0:0:1/block/sdc", "iscsi"},
+		{"sdd", "/sys/devices/pci0000:00/0000:00:05.0/virtio2/host2/target2:0:0/2:0:0:0/block/sdd", "virtio"},
+		{"sde", "/sys/devices/pci0000:00/0000:00:1f.0/host6/target6:0:0/6:0:0:0/block/sde", "scsi"},
+		{"mmcblk0", "/sys/devices/platform/soc/mmc_host/mmc0/mmc0:0001/block/mmcblk0", "mmc"},
+	}
+	for _, tt := range tests {
+		if got := diskTransport(tt.name, tt.path); got != tt.want {
+			t.Errorf("diskTransport(%s) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
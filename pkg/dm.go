@@ -0,0 +1,199 @@
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Device-mapper disks
+//
+// A multipath disk is a device-mapper device, /dev/mapper/mpatha or dm-N in
+// sysfs. Its partitions are not kernel partitions but further device-mapper
+// devices that kpartx maps, named mpatha1, mpatha-part1 or mpathap1
+// depending on the distribution, so names say nothing reliable about which
+// disk a partition belongs to. Instead the partitions of such a disk are its
+// sysfs holders whose device-mapper uuid is part<N>-<uuid of the disk>, and
+// the disk of a partition is the slave of its mapping.
+
+// hostMultipathFiles configure multipath on the host; the installed system
+// needs the same configuration and WWID bindings to assemble its root disk
+var hostMultipathFiles = []string{
+	"etc/multipath.conf",
+	"etc/multipath/wwids",
+	"etc/multipath/bindings",
+}
+
+// blockDeviceName returns the kernel name of device, following symlinks
+// such as /dev/mapper/mpatha to /dev/dm-3
+func blockDeviceName(device string) string {
+	if resolved, err := filepath.EvalSymlinks(device); err == nil {
+		device = resolved
+	}
+	return filepath.Base(device)
+}
+
+// dmName returns the device-mapper name of the block device node, or ""
+// if it is not a device-mapper device
+func dmName(node string) string {
+	return readSysfsValue(filepath.Join(sysClassBlockPath, node, "dm", "name"))
+}
+
+// dmDevicePath returns the /dev/mapper path of the device-mapper device node
+func dmDevicePath(node string) string {
+	return filepath.Join(devPath, "mapper", dmName(node))
+}
+
+// isDeviceMapper reports whether device is a device-mapper device
+func isDeviceMapper(device string) bool {
+	return dmName(blockDeviceName(device)) != ""
+}
+
+// partitionMapNumber returns the partition number of a kpartx partition
+// mapping with device-mapper uuid, or 0 if uuid is not one
+func partitionMapNumber(uuid string) int {
+	rest, ok := strings.CutPrefix(uuid, "part")
+	if !ok {
+		return 0
+	}
+	num, _, ok := strings.Cut(rest, "-")
+	if !ok {
+		return 0
+	}
+	n, err := strconv.Atoi(num)
+	if err != nil || n <= 0 {
+		return 0
+	}
+	return n
+}
+
+// canonicalDevicePath returns the path a partition scheme uses for device:
+// /dev/<vg>/<lv> for an LVM logical volume, /dev/mapper/<name> for other
+// device-mapper devices such as multipath partitions, and device otherwise
+func canonicalDevicePath(device string) string {
+	if lv := logicalVolumePath(device); lv != device {
+		return lv
+	}
+	if node := blockDeviceName(device); dmName(node) != "" {
+		return dmDevicePath(node)
+	}
+	return device
+}
+
+// dmPartitions returns the kpartx partition mappings of the device-mapper
+// disk node by partition number
+func dmPartitions(node string) map[int]string {
+	entries, err := os.ReadDir(filepath.Join(sysClassBlockPath, node, "holders"))
+	if err != nil {
+		return nil
+	}
+	parts := map[int]string{}
+	for _, entry := range entries {
+		uuid := readSysfsValue(filepath.Join(sysClassBlockPath, entry.Name(), "dm", "uuid"))
+		if n := partitionMapNumber(uuid); n > 0 {
+			parts[n] = entry.Name()
+		}
+	}
+	return parts
+}
+
+// dmPartitionNodes returns the kpartx partition mappings of the
+// device-mapper disk node in partition order
+func dmPartitionNodes(node string) []string {
+	parts := dmPartitions(node)
+	var nodes []string
+	for _, n := range slices.Sorted(maps.Keys(parts)) {
+		nodes = append(nodes, parts[n])
+	}
+	return nodes
+}
+
+// dmPartitionPath returns the device path of partition n of the
+// device-mapper disk device, or "" if device is not a device-mapper device
+// or has no such partition mapped
+func dmPartitionPath(device string, n int) string {
+	node := blockDeviceName(device)
+	if dmName(node) == "" {
+		return ""
+	}
+	part, ok := dmPartitions(node)[n]
+	if !ok {
+		return ""
+	}
+	return dmDevicePath(part)
+}
+
+// dmPartitionDisk returns the disk of the kpartx partition mapping
+// partition, or "" if partition is not one
+func dmPartitionDisk(partition string) string {
+	node := blockDeviceName(partition)
+	if partitionMapNumber(readSysfsValue(filepath.Join(sysClassBlockPath, node, "dm", "uuid"))) == 0 {
+		return ""
+	}
+	slaves, err := os.ReadDir(filepath.Join(sysClassBlockPath, node, "slaves"))
+	if err != nil || len(slaves) != 1 {
+		return ""
+	}
+	disk := slaves[0].Name()
+	if dmName(disk) != "" {
+		return dmDevicePath(disk)
+	}
+	return filepath.Join(devPath, disk)
+}
+
+// removeDMPartitions removes the kpartx partition mappings of the
+// device-mapper disk device before its partition table is wiped, as kpartx
+// cannot tell which mappings belonged to a table that is gone
+func removeDMPartitions(ctx context.Context, device string) error {
+	for _, part := range dmPartitionNodes(blockDeviceName(device)) {
+		name := dmName(part)
+		if output, err := runCommand(ctx, "dmsetup", "remove", name); err != nil {
+			return fmt.Errorf("failed to remove partition mapping %s: %w\nOutput: %s", name, err, string(output))
+		}
+	}
+	return nil
+}
+
+// mapDMPartitions has kpartx map the partitions of the device-mapper disk
+// device after its partition table was written; the kernel does not
+// partition device-mapper devices itself
+func mapDMPartitions(ctx context.Context, device string) error {
+	if _, err := executor.LookPath("kpartx"); err != nil {
+		return fmt.Errorf("%w: kpartx - install the multipath-tools or kpartx package", ErrMissingTool)
+	}
+	if output, err := runCommand(ctx, "kpartx", "-a", "-s", device); err != nil {
+		return fmt.Errorf("failed to map partitions of %s: %w\nOutput: %s", device, err, string(output))
+	}
+	return nil
+}
+
+// CopyMultipathHostConfig copies the host's multipath configuration and
+// WWID bindings into the target, so the installed system assembles its
+// multipath root disk under the same name
+func CopyMultipathHostConfig(targetDir string, dryRun bool) error {
+	if dryRun {
+		fmt.Println("[DRY RUN] Would copy multipath configuration to target")
+		return nil
+	}
+
+	for _, rel := range hostMultipathFiles {
+		src := filepath.Join("/", rel)
+		if _, err := os.Stat(src); err != nil {
+			continue
+		}
+		dst := filepath.Join(targetDir, rel)
+		if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+			return fmt.Errorf("failed to create directory for %s: %w", rel, err)
+		}
+		if err := copyFile(src, dst); err != nil {
+			return fmt.Errorf("failed to copy %s: %w", rel, err)
+		}
+		fmt.Printf("  Copied host %s to target\n", "/"+rel)
+	}
+	return nil
+}
@@ -0,0 +1,107 @@
+package pkg
+
+import (
+	"context"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// setupFakeMultipath fakes sysfs for the multipath disk mpatha (dm-3) over
+// sdc and sdd, with kpartx partition mappings mpatha1 (dm-4) and mpatha2
+// (dm-5); mpatha2 is mounted at /srv and also holds an LVM volume
+func setupFakeMultipath(t *testing.T) {
+	t.Helper()
+	root := t.TempDir()
+	oldClass, oldDev, oldMounts, oldSwaps := sysClassBlockPath, devPath, procMountsPath, procSwapsPath
+	sysClassBlockPath = filepath.Join(root, "class")
+	devPath = "/dev"
+	procMountsPath = filepath.Join(root, "mounts")
+	procSwapsPath = filepath.Join(root, "swaps")
+	t.Cleanup(func() {
+		sysClassBlockPath, devPath, procMountsPath, procSwapsPath = oldClass, oldDev, oldMounts, oldSwaps
+	})
+
+	writeFakeFile(t, filepath.Join(sysClassBlockPath, "dm-3", "dm", "name"), "mpatha\n")
+	writeFakeFile(t, filepath.Join(sysClassBlockPath, "dm-3", "dm", "uuid"), "mpath-3600a0b8\n")
+	writeFakeFile(t, filepath.Join(sysClassBlockPath, "dm-3", "slaves", "sdc"), "")
+	writeFakeFile(t, filepath.Join(sysClassBlockPath, "dm-3", "slaves", "sdd"), "")
+	for n, node := range map[string]string{"1": "dm-4", "2": "dm-5"} {
+		writeFakeFile(t, filepath.Join(sysClassBlockPath, "dm-3", "holders", node), "")
+		writeFakeFile(t, filepath.Join(sysClassBlockPath, node, "dm", "name"), "mpatha"+n+"\n")
+		writeFakeFile(t, filepath.Join(sysClassBlockPath, node, "dm", "uuid"), "part"+n+"-mpath-3600a0b8\n")
+		writeFakeFile(t, filepath.Join(sysClassBlockPath, node, "slaves", "dm-3"), "")
+	}
+	writeFakeFile(t, filepath.Join(sysClassBlockPath, "dm-5", "holders", "dm-6"), "")
+	writeFakeFile(t, filepath.Join(sysClassBlockPath, "dm-6", "dm", "name"), "vg-data\n")
+	writeFakeFile(t, filepath.Join(sysClassBlockPath, "dm-6", "dm", "uuid"), "LVM-abc\n")
+
+	writeFakeFile(t, procMountsPath, "/dev/mapper/mpatha2 /srv ext4 rw 0 0\n")
+	writeFakeFile(t, procSwapsPath, "Filename\tType\tSize\tUsed\tPriority\n")
+}
+
+func TestPartitionMapNumber(t *testing.T) {
+	tests := map[string]int{
+		"part1-mpath-3600a0b8":  1,
+		"part12-mpath-3600a0b8": 12,
+		"mpath-3600a0b8":        0,
+		"LVM-abc":               0,
+		"part-mpath":            0,
+		"partx":                 0,
+	}
+	for uuid, want := range tests {
+		if got := partitionMapNumber(uuid); got != want {
+			t.Errorf("partitionMapNumber(%q) = %d, want %d", uuid, got, want)
+		}
+	}
+}
+
+func TestMultipathPartitions(t *testing.T) {
+	setupFakeMultipath(t)
+
+	if got := partitionDevicePath("/dev/dm-3", 2); got != "/dev/mapper/mpatha2" {
+		t.Errorf("partitionDevicePath(/dev/dm-3, 2) = %q, want /dev/mapper/mpatha2", got)
+	}
+	// Partitions that are not mapped yet fall back to the kpartx name
+	if got := partitionDevicePath("/dev/mapper/mpatha", 3); got != "/dev/mapper/mpatha3" {
+		t.Errorf("partitionDevicePath(/dev/mapper/mpatha, 3) = %q, want /dev/mapper/mpatha3", got)
+	}
+
+	disk, err := GetBootDeviceFromPartition("/dev/dm-4")
+	if err != nil || disk != "/dev/mapper/mpatha" {
+		t.Errorf("GetBootDeviceFromPartition(/dev/dm-4) = %q, %v, want /dev/mapper/mpatha", disk, err)
+	}
+	if got := canonicalDevicePath("/dev/dm-5"); got != "/dev/mapper/mpatha2" {
+		t.Errorf("canonicalDevicePath(/dev/dm-5) = %q, want /dev/mapper/mpatha2", got)
+	}
+
+	// The partition mappings are partitions of the disk, not users of it
+	usages, err := FindDeviceUsage("/dev/dm-3")
+	if err != nil {
+		t.Fatalf("FindDeviceUsage() error = %v", err)
+	}
+	want := []DeviceUsage{
+		{Device: "/dev/mapper/mpatha2", Kind: UsageLVM, Target: "vg-data"},
+		{Device: "/dev/mapper/mpatha2", Kind: UsageMount, Target: "/srv"},
+	}
+	if !reflect.DeepEqual(usages, want) {
+		t.Errorf("FindDeviceUsage() = %+v, want %+v", usages, want)
+	}
+}
+
+func TestRemoveDMPartitions(t *testing.T) {
+	setupFakeMultipath(t)
+	fake, _ := setupDryRunExecutor(t)
+
+	if err := removeDMPartitions(context.Background(), "/dev/dm-3"); err != nil {
+		t.Fatalf("removeDMPartitions() error = %v", err)
+	}
+	var got []string
+	for _, c := range fake.Commands {
+		got = append(got, c.String())
+	}
+	want := []string{"dmsetup remove mpatha1", "dmsetup remove mpatha2"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("commands = %v, want %v", got, want)
+	}
+}
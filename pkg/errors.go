@@ -0,0 +1,152 @@
+package pkg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"syscall"
+
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+)
+
+// Errors that callers can test for with errors.Is. Each maps to a stable
+// machine-readable code, see ErrorCode.
+var (
+	// ErrDeviceNotFound is returned when the target device does not exist
+	ErrDeviceNotFound = errors.New("device not found")
+	// ErrInsufficientSpace is returned when a disk or filesystem is too small
+	ErrInsufficientSpace = errors.New("insufficient space")
+	// ErrPartitionSchemeMismatch is returned when a disk does not have the
+	// partition layout phukit installs
+	ErrPartitionSchemeMismatch = errors.New("partition scheme mismatch")
+	// ErrInvalidImageReference is returned for unparseable image references
+	ErrInvalidImageReference = errors.New("invalid image reference")
+	// ErrImageUnauthorized is returned when the registry refuses access to an image
+	ErrImageUnauthorized = errors.New("not authorized to access image")
+	// ErrImageNotFound is returned when the registry does not have the image
+	ErrImageNotFound = errors.New("image not found")
+	// ErrMissingTool is returned when a required external tool is not installed
+	ErrMissingTool = errors.New("required tool not found")
+	// ErrVerificationFailed is returned when a written disk does not check out
+	ErrVerificationFailed = errors.New("verification failed")
+	// ErrBootFailed is returned when a disk does not boot to a login prompt
+	ErrBootFailed = errors.New("boot failed")
+	// ErrPinMismatch is returned when the image tag moved away from the pinned
+	// digest and the pin policy is "fail"
+	ErrPinMismatch = errors.New("image tag does not match pinned digest")
+	// ErrPolicyViolation is returned when the image policy rejects an image
+	ErrPolicyViolation = errors.New("image rejected by policy")
+	// ErrImageLintFailed is returned when an image fails a lint check
+	ErrImageLintFailed = errors.New("image failed lint checks")
+	// ErrSlotPinned is returned when an update would overwrite the pinned
+	// root slot
+	ErrSlotPinned = errors.New("root slot is pinned")
+	// ErrAborted is returned when the user declines a confirmation prompt
+	ErrAborted = errors.New("cancelled by user")
+)
+
+// Machine-readable error codes reported by ErrorCode
+const (
+	CodeDeviceBusy              = "device_busy"
+	CodeDeviceNotFound          = "device_not_found"
+	CodeInsufficientSpace       = "insufficient_space"
+	CodePartitionSchemeMismatch = "partition_scheme_mismatch"
+	CodeSlotPinned              = "slot_pinned"
+	CodeInvalidImageReference   = "invalid_image_reference"
+	CodeImageUnauthorized       = "image_unauthorized"
+	CodeImageNotFound           = "image_not_found"
+	CodePinMismatch             = "pin_mismatch"
+	CodeImageLintFailed         = "image_lint_failed"
+	CodePolicyViolation         = "policy_violation"
+	CodeMissingTool             = "missing_tool"
+	CodeVerificationFailed      = "verification_failed"
+	CodeBootFailed              = "boot_failed"
+	CodeOperationInProgress     = "operation_in_progress"
+	CodeStrictWarning           = "strict_warning"
+	CodeAborted                 = "aborted"
+	CodeTimeout                 = "timeout"
+	CodeCancelled               = "cancelled"
+	CodeUnknown                 = "unknown"
+)
+
+// errorCodes maps sentinel errors to codes, checked in order
+var errorCodes = []struct {
+	err  error
+	code string
+}{
+	{ErrDeviceBusy, CodeDeviceBusy},
+	{ErrDeviceNotFound, CodeDeviceNotFound},
+	{ErrInsufficientSpace, CodeInsufficientSpace},
+	{syscall.ENOSPC, CodeInsufficientSpace},
+	{ErrPartitionSchemeMismatch, CodePartitionSchemeMismatch},
+	{ErrSlotPinned, CodeSlotPinned},
+	{ErrInvalidImageReference, CodeInvalidImageReference},
+	{ErrImageUnauthorized, CodeImageUnauthorized},
+	{ErrImageNotFound, CodeImageNotFound},
+	{ErrPinMismatch, CodePinMismatch},
+	{ErrImageLintFailed, CodeImageLintFailed},
+	{ErrPolicyViolation, CodePolicyViolation},
+	{ErrMissingTool, CodeMissingTool},
+	{ErrVerificationFailed, CodeVerificationFailed},
+	{ErrBootFailed, CodeBootFailed},
+	{ErrOperationInProgress, CodeOperationInProgress},
+	{ErrStrict, CodeStrictWarning},
+	{ErrAborted, CodeAborted},
+	{context.DeadlineExceeded, CodeTimeout},
+	{context.Canceled, CodeCancelled},
+}
+
+// ErrorCode returns the machine-readable code for err, CodeUnknown if err
+// matches none of the package errors, or "" if err is nil
+func ErrorCode(err error) string {
+	if err == nil {
+		return ""
+	}
+	for _, c := range errorCodes {
+		if errors.Is(err, c.err) {
+			return c.code
+		}
+	}
+	return CodeUnknown
+}
+
+// InsufficientSpaceError reports a disk smaller than an operation needs
+type InsufficientSpaceError struct {
+	Device   string
+	Size     uint64
+	Required uint64
+}
+
+func (e *InsufficientSpaceError) Error() string {
+	return fmt.Sprintf("disk %s is too small: %d bytes (minimum: %d bytes)", e.Device, e.Size, e.Required)
+}
+
+// Is reports whether target is ErrInsufficientSpace
+func (e *InsufficientSpaceError) Is(target error) bool {
+	return target == ErrInsufficientSpace
+}
+
+// registryError marks authorization and not-found failures from a registry
+// with ErrImageUnauthorized or ErrImageNotFound
+func registryError(err error) error {
+	var terr *transport.Error
+	if !errors.As(err, &terr) {
+		return err
+	}
+	switch terr.StatusCode {
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return fmt.Errorf("%w: %w", ErrImageUnauthorized, err)
+	case http.StatusNotFound:
+		return fmt.Errorf("%w: %w", ErrImageNotFound, err)
+	}
+	for _, diag := range terr.Errors {
+		switch diag.Code {
+		case transport.UnauthorizedErrorCode, transport.DeniedErrorCode:
+			return fmt.Errorf("%w: %w", ErrImageUnauthorized, err)
+		case transport.ManifestUnknownErrorCode, transport.NameUnknownErrorCode:
+			return fmt.Errorf("%w: %w", ErrImageNotFound, err)
+		}
+	}
+	return err
+}
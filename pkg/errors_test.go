@@ -0,0 +1,74 @@
+package pkg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"syscall"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+)
+
+func TestErrorCode(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want string
+	}{
+		{"nil", nil, ""},
+		{"plain", errors.New("boom"), CodeUnknown},
+		{"device busy", &DeviceInUseError{Device: "/dev/sda"}, CodeDeviceBusy},
+		{"wrapped not found", fmt.Errorf("invalid device: %w", fmt.Errorf("%w: /dev/sdz does not exist", ErrDeviceNotFound)), CodeDeviceNotFound},
+		{"too small", &InsufficientSpaceError{Device: "/dev/sda", Size: 1, Required: 2}, CodeInsufficientSpace},
+		{"ENOSPC", fmt.Errorf("failed to extract: %w", &os.PathError{Op: "write", Path: "/x", Err: syscall.ENOSPC}), CodeInsufficientSpace},
+		{"lock", fmt.Errorf("%w: pid 1", ErrOperationInProgress), CodeOperationInProgress},
+		{"strict", fmt.Errorf("%w: uuid", ErrStrict), CodeStrictWarning},
+		{"aborted", fmt.Errorf("installation %w", ErrAborted), CodeAborted},
+		{"timeout", fmt.Errorf("mkfs: %w", context.DeadlineExceeded), CodeTimeout},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ErrorCode(tt.err); got != tt.want {
+				t.Errorf("ErrorCode(%v) = %q, want %q", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRegistryError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want error
+	}{
+		{"401", &transport.Error{StatusCode: http.StatusUnauthorized}, ErrImageUnauthorized},
+		{"403", &transport.Error{StatusCode: http.StatusForbidden}, ErrImageUnauthorized},
+		{"404", &transport.Error{StatusCode: http.StatusNotFound}, ErrImageNotFound},
+		{"denied diagnostic", &transport.Error{StatusCode: http.StatusBadRequest, Errors: []transport.Diagnostic{{Code: transport.DeniedErrorCode}}}, ErrImageUnauthorized},
+		{"manifest unknown", &transport.Error{StatusCode: http.StatusBadRequest, Errors: []transport.Diagnostic{{Code: transport.ManifestUnknownErrorCode}}}, ErrImageNotFound},
+		{"server error", &transport.Error{StatusCode: http.StatusInternalServerError}, nil},
+		{"not a registry error", errors.New("dial tcp: timeout"), nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := registryError(tt.err)
+			if !errors.Is(got, tt.err) {
+				t.Errorf("registryError dropped the original error: %v", got)
+			}
+			if tt.want == nil {
+				if got != tt.err {
+					t.Errorf("registryError(%v) = %v, want it unchanged", tt.err, got)
+				}
+				return
+			}
+			if !errors.Is(got, tt.want) {
+				t.Errorf("registryError(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
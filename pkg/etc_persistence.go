@@ -3,15 +3,14 @@ package pkg
 import (
 	"fmt"
 	"os"
-	"os/exec"
 	"path/filepath"
 )
 
 const (
 	// PristineEtcPath is where we store the pristine /etc from installation
 	PristineEtcPath = "/var/lib/phukit/etc.pristine"
-	// VarEtcPath is DEPRECATED - we no longer use /var/etc for boot-time bind mount
-	// Kept for documentation purposes
+	// VarEtcPath is the disaster-recovery backup of /etc on the shared /var
+	// It is NOT used for boot-time bind mounting
 	VarEtcPath = "/var/etc.backup"
 )
 
@@ -36,8 +35,24 @@ func SetupEtcPersistence(targetDir string, dryRun bool) error {
 
 	fmt.Println("  Setting up /etc persistence...")
 
-	// Verify /etc exists and has content
 	etcSource := filepath.Join(targetDir, "etc")
+	if err := verifyEtc(etcSource); err != nil {
+		return err
+	}
+
+	// Create backup of /etc in /var/etc for disaster recovery
+	// This is NOT used for boot-time mounting, only as a backup
+	if err := backupEtc(etcSource, filepath.Join(targetDir, "var", "etc.backup")); err != nil {
+		return err
+	}
+
+	fmt.Println("  /etc persistence setup complete (/etc stays on root filesystem)")
+	return nil
+}
+
+// verifyEtc checks that etcSource exists, is not empty and has the critical files
+func verifyEtc(etcSource string) error {
+	// Verify /etc exists and has content
 	if _, err := os.Stat(etcSource); os.IsNotExist(err) {
 		return fmt.Errorf("/etc does not exist at %s", etcSource)
 	}
@@ -57,29 +72,32 @@ func SetupEtcPersistence(targetDir string, dryRun bool) error {
 	for _, f := range criticalFiles {
 		path := filepath.Join(etcSource, f)
 		if _, err := os.Stat(path); os.IsNotExist(err) {
-			fmt.Printf("  Warning: critical file %s not found in /etc\n", f)
+			if err := warnf("  critical file %s not found in /etc", f); err != nil {
+				return err
+			}
 		} else {
 			fmt.Printf("  ✓ Found %s in /etc\n", f)
 		}
 	}
+	return nil
+}
 
-	// Create backup of /etc in /var/etc for disaster recovery
-	// This is NOT used for boot-time mounting, only as a backup
-	varEtcDir := filepath.Join(targetDir, "var", "etc.backup")
+// backupEtc copies etcSource to varEtcDir (normally /var/etc.backup)
+// A failed copy is only a warning since the backup is not needed for boot
+func backupEtc(etcSource, varEtcDir string) error {
 	if err := os.MkdirAll(varEtcDir, 0755); err != nil {
 		return fmt.Errorf("failed to create /var/etc.backup directory: %w", err)
 	}
 
 	// Backup /etc contents to /var/etc.backup
-	cmd := exec.Command("rsync", "-al", etcSource+"/", varEtcDir+"/")
-	if output, err := cmd.CombinedOutput(); err != nil {
-		fmt.Printf("  Warning: failed to backup /etc to /var/etc.backup: %v\nOutput: %s\n", err, string(output))
+	if err := copyTree(etcSource, varEtcDir, false); err != nil {
 		// Don't fail on backup error - it's not critical for boot
+		if werr := warnf("  failed to backup /etc to /var/etc.backup: %v", err); werr != nil {
+			return werr
+		}
 	} else {
 		fmt.Println("  Created /etc backup in /var/etc.backup")
 	}
-
-	fmt.Println("  /etc persistence setup complete (/etc stays on root filesystem)")
 	return nil
 }
 
@@ -117,10 +135,9 @@ func SavePristineEtc(targetDir string, dryRun bool) error {
 		return fmt.Errorf("failed to create pristine etc directory: %w", err)
 	}
 
-	// Use rsync to copy /etc
-	cmd := exec.Command("rsync", "-a", "--delete", etcSource+"/", pristineDest+"/")
-	if output, err := cmd.CombinedOutput(); err != nil {
-		return fmt.Errorf("failed to save pristine /etc: %w\nOutput: %s", err, string(output))
+	// Mirror /etc, removing anything left from an older snapshot
+	if err := copyTree(etcSource, pristineDest, true); err != nil {
+		return fmt.Errorf("failed to save pristine /etc: %w", err)
 	}
 
 	fmt.Printf("  Saved pristine /etc snapshot\n")
@@ -144,6 +161,10 @@ func SavePristineEtc(targetDir string, dryRun bool) error {
 //   - targetDir: mount point of the NEW root partition (e.g., /tmp/phukit-update)
 //   - activeRootPartition: the CURRENT root partition device (contains user's /etc)
 //   - dryRun: if true, don't make changes
+//
+// The merged /etc is not backed up to /var/etc.backup here: /var is shared with
+// the running system during an update, so the backup is written separately
+// under the shared /var lock (see BackupEtcToSharedVar).
 func MergeEtcFromActive(targetDir string, activeRootPartition string, dryRun bool) error {
 	if dryRun {
 		fmt.Printf("[DRY RUN] Would merge /etc from active system\n")
@@ -171,25 +192,39 @@ func MergeEtcFromActive(targetDir string, activeRootPartition string, dryRun boo
 		}
 		defer func() { _ = os.RemoveAll(activeMountPoint) }()
 
-		mountCmd := exec.Command("mount", "-o", "ro", activeRootPartition, activeMountPoint)
-		if err := mountCmd.Run(); err != nil {
+		if err := mountDevice(activeRootPartition, activeMountPoint, true); err != nil {
 			return fmt.Errorf("failed to mount active root partition %s: %w", activeRootPartition, err)
 		}
 		activeEtc = filepath.Join(activeMountPoint, "etc")
 		needsUnmount = true
 		defer func() {
 			if needsUnmount {
-				_ = exec.Command("umount", activeMountPoint).Run()
+				_ = unmount(activeMountPoint)
 			}
 		}()
 	}
 
+	return MergeEtc(targetDir, activeEtc, dryRun)
+}
+
+// MergeEtc merges user modifications from the /etc directory activeEtc into
+// targetDir/etc, using the same rules as MergeEtcFromActive. It works against any
+// mounted root, so recovery and provisioning tools can re-run just the merge.
+func MergeEtc(targetDir, activeEtc string, dryRun bool) error {
+	if dryRun {
+		fmt.Printf("[DRY RUN] Would merge /etc from %s into %s\n", activeEtc, targetDir)
+		return nil
+	}
+
 	newEtc := filepath.Join(targetDir, "etc")
+	if _, err := os.Stat(newEtc); err != nil {
+		return fmt.Errorf("target /etc not found: %w", err)
+	}
 
 	// Check if active /etc exists
 	if _, err := os.Stat(activeEtc); os.IsNotExist(err) {
 		fmt.Println("  No /etc found on active root, using container defaults")
-		return SetupEtcPersistence(targetDir, dryRun)
+		return verifyEtc(newEtc)
 	}
 
 	// Files that should always come from the NEW container (system identity files)
@@ -234,7 +269,7 @@ func MergeEtcFromActive(targetDir string, activeRootPartition string, dryRun boo
 		if linfo.IsDir() {
 			// Create directory if it doesn't exist in new /etc
 			if !fileExistsInNew {
-				_ = os.MkdirAll(destPath, linfo.Mode())
+				_ = copyPreserving(path, destPath)
 				fmt.Printf("    + Added directory: %s\n", relPath)
 			}
 			return nil
@@ -245,14 +280,18 @@ func MergeEtcFromActive(targetDir string, activeRootPartition string, dryRun boo
 			// File doesn't exist in new container - this is a user-added file, preserve it
 			_ = os.MkdirAll(filepath.Dir(destPath), 0755)
 			if isSymlink {
-				if err := copySymlink(path, destPath); err != nil {
-					fmt.Printf("    Warning: failed to copy user symlink %s: %v\n", relPath, err)
+				if err := copyPreserving(path, destPath); err != nil {
+					if werr := warnf("    failed to copy user symlink %s: %v", relPath, err); werr != nil {
+						return werr
+					}
 				} else {
 					fmt.Printf("    + Preserved user symlink: %s\n", relPath)
 				}
 			} else {
-				if err := copyFile(path, destPath); err != nil {
-					fmt.Printf("    Warning: failed to copy user file %s: %v\n", relPath, err)
+				if err := copyPreserving(path, destPath); err != nil {
+					if werr := warnf("    failed to copy user file %s: %v", relPath, err); werr != nil {
+						return werr
+					}
 				} else {
 					fmt.Printf("    + Preserved user file: %s\n", relPath)
 				}
@@ -270,11 +309,7 @@ func MergeEtcFromActive(targetDir string, activeRootPartition string, dryRun boo
 			}
 			for _, preserve := range preserveUserModifications {
 				if filepath.Base(relPath) == preserve {
-					if isSymlink {
-						_ = copySymlink(path, destPath)
-					} else {
-						_ = copyFile(path, destPath)
-					}
+					_ = copyPreserving(path, destPath)
 					fmt.Printf("    = Preserved user config: %s\n", relPath)
 					break
 				}
@@ -288,9 +323,8 @@ func MergeEtcFromActive(targetDir string, activeRootPartition string, dryRun boo
 		return fmt.Errorf("failed to merge /etc: %w", err)
 	}
 
-	// Setup persistence (creates backup in /var/etc.backup)
-	if err := SetupEtcPersistence(targetDir, dryRun); err != nil {
-		return fmt.Errorf("failed to setup etc persistence: %w", err)
+	if err := verifyEtc(newEtc); err != nil {
+		return fmt.Errorf("failed to verify merged /etc: %w", err)
 	}
 
 	fmt.Println("  /etc configuration merged successfully")
@@ -322,16 +356,3 @@ func copyFile(src, dst string) error {
 
 	return nil
 }
-
-// copySymlink copies a symlink preserving its target
-func copySymlink(src, dst string) error {
-	target, err := os.Readlink(src)
-	if err != nil {
-		return err
-	}
-
-	// Remove existing file/symlink if present
-	_ = os.Remove(dst)
-
-	return os.Symlink(target, dst)
-}
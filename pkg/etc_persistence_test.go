@@ -0,0 +1,56 @@
+package pkg
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMergeEtc(t *testing.T) {
+	activeEtc := filepath.Join(t.TempDir(), "etc")
+	targetDir := t.TempDir()
+	newEtc := filepath.Join(targetDir, "etc")
+
+	// Active system: user-added file, modified hostname, old os-release
+	writeFakeFile(t, filepath.Join(activeEtc, "myapp", "config.toml"), "user=true\n")
+	writeFakeFile(t, filepath.Join(activeEtc, "hostname"), "myhost\n")
+	writeFakeFile(t, filepath.Join(activeEtc, "os-release"), "VERSION_ID=1\n")
+	writeFakeFile(t, filepath.Join(activeEtc, "motd"), "old motd\n")
+
+	// New image
+	writeFakeFile(t, filepath.Join(newEtc, "hostname"), "localhost\n")
+	writeFakeFile(t, filepath.Join(newEtc, "os-release"), "VERSION_ID=2\n")
+	writeFakeFile(t, filepath.Join(newEtc, "motd"), "new motd\n")
+	writeFakeFile(t, filepath.Join(newEtc, "passwd"), "root:x:0:0::/root:/bin/sh\n")
+	writeFakeFile(t, filepath.Join(newEtc, "group"), "root:x:0:\n")
+
+	if err := MergeEtc(targetDir, activeEtc, false); err != nil {
+		t.Fatalf("MergeEtc() error = %v", err)
+	}
+
+	tests := []struct {
+		file string
+		want string
+	}{
+		{"myapp/config.toml", "user=true\n"}, // user-added, preserved
+		{"hostname", "myhost\n"},             // user-modified config, preserved
+		{"os-release", "VERSION_ID=2\n"},     // identity, from new image
+		{"motd", "new motd\n"},               // not a preserved config, from new image
+	}
+	for _, tt := range tests {
+		data, err := os.ReadFile(filepath.Join(newEtc, tt.file))
+		if err != nil {
+			t.Errorf("reading %s: %v", tt.file, err)
+			continue
+		}
+		if string(data) != tt.want {
+			t.Errorf("%s = %q, want %q", tt.file, data, tt.want)
+		}
+	}
+}
+
+func TestMergeEtcMissingTarget(t *testing.T) {
+	if err := MergeEtc(t.TempDir(), t.TempDir(), false); err == nil {
+		t.Error("MergeEtc() expected error when target has no /etc")
+	}
+}
@@ -0,0 +1,185 @@
+package pkg
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Command describes an external command to run
+type Command struct {
+	Name   string
+	Args   []string
+	Stdin  io.Reader
+	Stdout io.Writer
+	Stderr io.Writer
+}
+
+// String returns the command line with arguments quoted where needed
+func (c Command) String() string {
+	parts := make([]string, 0, len(c.Args)+1)
+	for _, s := range append([]string{c.Name}, c.Args...) {
+		if s == "" || strings.ContainsAny(s, " \t\n'\"\\$`") {
+			s = "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+		}
+		parts = append(parts, s)
+	}
+	return strings.Join(parts, " ")
+}
+
+// Executor runs external commands. Every command phukit runs (mkfs, wipefs,
+// grub-install, chroot, ...) goes through the package executor, see SetExecutor.
+type Executor interface {
+	// Run runs cmd and waits for it to finish, killing it if ctx is cancelled
+	Run(ctx context.Context, cmd Command) error
+	// LookPath searches for an executable like exec.LookPath
+	LookPath(name string) (string, error)
+}
+
+// executor runs all external commands
+var executor Executor = OSExecutor{}
+
+// SetExecutor sets the executor used for all external commands
+// Pass nil to restore the default OSExecutor
+func SetExecutor(e Executor) {
+	if e == nil {
+		e = OSExecutor{}
+	}
+	executor = e
+}
+
+// useExecutor has e run all external commands until the returned function
+// restores the previous executor; a nil e keeps the current one. Installers
+// and updaters use it to run an operation with their own executor.
+func useExecutor(e Executor) (restore func()) {
+	if e == nil {
+		return func() {}
+	}
+	previous := executor
+	executor = e
+	return func() { executor = previous }
+}
+
+// tracing reports whether commands are only recorded by a DryRunExecutor, so
+// a dry run can go through the commands it would run instead of describing
+// them
+func tracing() bool {
+	_, ok := executor.(*DryRunExecutor)
+	return ok
+}
+
+// runCommand runs name with args through the executor and returns its combined output
+func runCommand(ctx context.Context, name string, args ...string) ([]byte, error) {
+	var out bytes.Buffer
+	err := executor.Run(ctx, Command{Name: name, Args: args, Stdout: &out, Stderr: &out})
+	return out.Bytes(), err
+}
+
+// OSExecutor runs commands on the host with os/exec
+type OSExecutor struct{}
+
+// Run implements Executor
+func (OSExecutor) Run(ctx context.Context, c Command) error {
+	cmd := exec.CommandContext(ctx, c.Name, c.Args...)
+	cmd.Stdin = c.Stdin
+	cmd.Stdout = c.Stdout
+	cmd.Stderr = c.Stderr
+	return cmd.Run()
+}
+
+// LookPath implements Executor
+func (OSExecutor) LookPath(name string) (string, error) {
+	return exec.LookPath(name)
+}
+
+// DryRunExecutor records commands instead of running them, producing a trace
+// of exactly which commands would run. Installers and updaters in dry-run mode
+// use one unless given another executor. It is also useful as a fake in tests.
+type DryRunExecutor struct {
+	Out      io.Writer // If set, each command is printed as "[DRY RUN] Would run: ..."
+	Commands []Command // Commands in the order they were requested
+
+	mu sync.Mutex
+}
+
+// Run implements Executor
+func (d *DryRunExecutor) Run(ctx context.Context, c Command) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.Commands = append(d.Commands, c)
+	if d.Out != nil {
+		_, _ = fmt.Fprintf(d.Out, "[DRY RUN] Would run: %s\n", c)
+	}
+	return nil
+}
+
+// LookPath implements Executor, assuming every tool is available
+func (d *DryRunExecutor) LookPath(name string) (string, error) {
+	return name, nil
+}
+
+// AuditRecord is one executed command in the audit log
+type AuditRecord struct {
+	Time       time.Time `json:"time"`
+	Command    string    `json:"command"`
+	Args       []string  `json:"args"`
+	DurationMS int64     `json:"duration_ms"`
+	ExitCode   int       `json:"exit_code"`
+	Error      string    `json:"error,omitempty"`
+}
+
+// AuditExecutor wraps another executor and writes an AuditRecord as a line of
+// JSON for every command it runs
+type AuditExecutor struct {
+	Next Executor
+	Log  io.Writer
+
+	mu sync.Mutex
+}
+
+// NewAuditExecutor creates an AuditExecutor logging commands run by next to log
+func NewAuditExecutor(next Executor, log io.Writer) *AuditExecutor {
+	return &AuditExecutor{Next: next, Log: log}
+}
+
+// Run implements Executor
+func (a *AuditExecutor) Run(ctx context.Context, c Command) error {
+	start := time.Now()
+	err := a.Next.Run(ctx, c)
+
+	record := AuditRecord{
+		Time:       start,
+		Command:    c.Name,
+		Args:       c.Args,
+		DurationMS: time.Since(start).Milliseconds(),
+	}
+	if err != nil {
+		record.Error = err.Error()
+		record.ExitCode = -1
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			record.ExitCode = exitErr.ExitCode()
+		}
+	}
+
+	a.mu.Lock()
+	_ = json.NewEncoder(a.Log).Encode(record)
+	a.mu.Unlock()
+
+	return err
+}
+
+// LookPath implements Executor
+func (a *AuditExecutor) LookPath(name string) (string, error) {
+	return a.Next.LookPath(name)
+}
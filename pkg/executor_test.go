@@ -0,0 +1,269 @@
+package pkg
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"os/exec"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestCommandString(t *testing.T) {
+	tests := []struct {
+		name string
+		cmd  Command
+		want string
+	}{
+		{"plain", Command{Name: "mkfs.ext4", Args: []string{"-F", "-L", "root1", "/dev/sda3"}}, "mkfs.ext4 -F -L root1 /dev/sda3"},
+		{"space", Command{Name: "echo", Args: []string{"hello world"}}, "echo 'hello world'"},
+		{"quote", Command{Name: "echo", Args: []string{"it's"}}, `echo 'it'\''s'`},
+		{"empty", Command{Name: "echo", Args: []string{""}}, "echo ''"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.cmd.String(); got != tt.want {
+				t.Errorf("String() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// setupDryRunExecutor installs a DryRunExecutor for the duration of the test
+func setupDryRunExecutor(t *testing.T) (*DryRunExecutor, *bytes.Buffer) {
+	t.Helper()
+	var out bytes.Buffer
+	fake := &DryRunExecutor{Out: &out}
+	SetExecutor(fake)
+	t.Cleanup(func() { SetExecutor(nil) })
+	return fake, &out
+}
+
+// scriptedReply is what a scriptedExecutor answers a command with
+type scriptedReply struct {
+	stdout string
+	err    error
+}
+
+// scriptedExecutor records commands like a DryRunExecutor and answers them
+// with the reply of the longest command line prefix in replies, e.g.
+// "losetup" or "podman image inspect". With tools set, LookPath only finds
+// those tools.
+type scriptedExecutor struct {
+	DryRunExecutor
+	replies map[string]scriptedReply
+	tools   []string
+}
+
+// setupScriptedExecutor installs a scriptedExecutor for the duration of the
+// test
+func setupScriptedExecutor(t *testing.T) *scriptedExecutor {
+	t.Helper()
+	fake := &scriptedExecutor{replies: map[string]scriptedReply{}}
+	SetExecutor(fake)
+	t.Cleanup(func() { SetExecutor(nil) })
+	return fake
+}
+
+// answer has commands starting with prefix print stdout
+func (f *scriptedExecutor) answer(prefix, stdout string) {
+	f.replies[prefix] = scriptedReply{stdout: stdout}
+}
+
+// fail has commands starting with prefix fail with err
+func (f *scriptedExecutor) fail(prefix string, err error) {
+	f.replies[prefix] = scriptedReply{err: err}
+}
+
+func (f *scriptedExecutor) LookPath(name string) (string, error) {
+	if f.tools != nil && !slices.Contains(f.tools, name) {
+		return "", exec.ErrNotFound
+	}
+	return f.DryRunExecutor.LookPath(name)
+}
+
+func (f *scriptedExecutor) Run(ctx context.Context, c Command) error {
+	if err := f.DryRunExecutor.Run(ctx, c); err != nil {
+		return err
+	}
+	line, match := c.String(), ""
+	for prefix := range f.replies {
+		if (line == prefix || strings.HasPrefix(line, prefix+" ")) && len(prefix) > len(match) {
+			match = prefix
+		}
+	}
+	reply, ok := f.replies[match]
+	if !ok {
+		return nil
+	}
+	if reply.err != nil {
+		return reply.err
+	}
+	if c.Stdout != nil {
+		_, err := io.WriteString(c.Stdout, reply.stdout)
+		return err
+	}
+	return nil
+}
+
+func TestScriptedExecutor(t *testing.T) {
+	fake := setupScriptedExecutor(t)
+	fake.answer("podman", "podman\n")
+	fake.answer("podman image inspect", "4096\n")
+	fake.fail("lvs", errors.New("exit status 5"))
+
+	for line, want := range map[string]string{
+		"podman version":                  "podman\n",
+		"podman image inspect localhost/x": "4096\n",
+		"podmanx":                         "",
+	} {
+		args := strings.Fields(line)
+		if out, err := runCommand(t.Context(), args[0], args[1:]...); err != nil || string(out) != want {
+			t.Errorf("%s = %q, %v, want %q", line, out, err, want)
+		}
+	}
+	if _, err := runCommand(t.Context(), "lvs", "vg/snap"); err == nil {
+		t.Error("lvs succeeded, want the scripted error")
+	}
+	if len(fake.Commands) != 4 {
+		t.Errorf("recorded %d commands, want 4", len(fake.Commands))
+	}
+
+	fake.tools = []string{"podman"}
+	if _, err := executor.LookPath("docker"); err == nil {
+		t.Error("LookPath(docker) found a tool that is not installed")
+	}
+	if _, err := executor.LookPath("podman"); err != nil {
+		t.Errorf("LookPath(podman) error = %v", err)
+	}
+}
+
+func TestDryRunExecutorTracesCommands(t *testing.T) {
+	fake, out := setupDryRunExecutor(t)
+
+	if err := formatPartition(context.Background(), "/dev/sda3", "btrfs", "root1", false); err != nil {
+		t.Fatalf("formatPartition failed: %v", err)
+	}
+	if err := formatPartition(context.Background(), "/dev/sda4", "ext4", "root2", false); err != nil {
+		t.Fatalf("formatPartition failed: %v", err)
+	}
+
+	want := []string{
+		"mkfs.btrfs -f -L root1 /dev/sda3",
+		"mkfs.ext4 -F -L root2 /dev/sda4",
+	}
+	if len(fake.Commands) != len(want) {
+		t.Fatalf("recorded %d commands, want %d", len(fake.Commands), len(want))
+	}
+	for i, w := range want {
+		if got := fake.Commands[i].String(); got != w {
+			t.Errorf("command %d = %q, want %q", i, got, w)
+		}
+		if !strings.Contains(out.String(), "[DRY RUN] Would run: "+w) {
+			t.Errorf("trace missing %q:\n%s", w, out.String())
+		}
+	}
+}
+
+func TestOSExecutorCancelled(t *testing.T) {
+	if _, err := exec.LookPath("sleep"); err != nil {
+		t.Skip("sleep not available")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if err := (OSExecutor{}).Run(ctx, Command{Name: "sleep", Args: []string{"10"}}); err == nil {
+		t.Fatal("expected cancelled command to fail")
+	}
+	if _, err := runCommand(ctx, "sleep", "10"); err == nil {
+		t.Fatal("expected cancelled runCommand to fail")
+	}
+}
+
+func TestAuditExecutor(t *testing.T) {
+	if _, err := exec.LookPath("true"); err != nil {
+		t.Skip("true not available")
+	}
+	if _, err := exec.LookPath("false"); err != nil {
+		t.Skip("false not available")
+	}
+
+	var log bytes.Buffer
+	audit := NewAuditExecutor(OSExecutor{}, &log)
+
+	if err := audit.Run(context.Background(), Command{Name: "true", Args: []string{"a b"}}); err != nil {
+		t.Fatalf("true failed: %v", err)
+	}
+	if err := audit.Run(context.Background(), Command{Name: "false"}); err == nil {
+		t.Fatal("expected false to fail")
+	}
+
+	lines := strings.Split(strings.TrimSpace(log.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("got %d audit records, want 2:\n%s", len(lines), log.String())
+	}
+
+	var ok, failed AuditRecord
+	if err := json.Unmarshal([]byte(lines[0]), &ok); err != nil {
+		t.Fatalf("invalid audit record: %v", err)
+	}
+	if err := json.Unmarshal([]byte(lines[1]), &failed); err != nil {
+		t.Fatalf("invalid audit record: %v", err)
+	}
+
+	if ok.Command != "true" || len(ok.Args) != 1 || ok.Args[0] != "a b" || ok.ExitCode != 0 || ok.Error != "" {
+		t.Errorf("unexpected record for true: %+v", ok)
+	}
+	if failed.Command != "false" || failed.ExitCode != 1 || failed.Error == "" {
+		t.Errorf("unexpected record for false: %+v", failed)
+	}
+}
+
+func TestInstallerDryRunTracesCommands(t *testing.T) {
+	previous, _ := setupDryRunExecutor(t)
+
+	trace := &DryRunExecutor{}
+	installer := NewBootcInstaller("quay.io/example/os:latest", "/dev/phukit-test")
+	installer.SetDryRun(true)
+	installer.SetExecutor(trace)
+	if err := installer.Install(context.Background()); err != nil {
+		t.Fatalf("Install failed: %v", err)
+	}
+
+	if executor != Executor(previous) {
+		t.Error("Install did not restore the previous executor")
+	}
+	if len(previous.Commands) != 0 {
+		t.Errorf("Install ran %v outside of its executor", previous.Commands)
+	}
+	want := []string{
+		"mkfs.vfat -F 32 -n UEFI /dev/phukit-test1",
+		"mkfs.ext4 -F -L root1 /dev/phukit-test2",
+		"mkfs.ext4 -F -L root2 /dev/phukit-test3",
+		"mkfs.ext4 -F -L var /dev/phukit-test4",
+	}
+	if len(trace.Commands) != len(want) {
+		t.Fatalf("recorded %v, want %v", trace.Commands, want)
+	}
+	for i, w := range want {
+		if got := trace.Commands[i].String(); got != w {
+			t.Errorf("command %d = %q, want %q", i, got, w)
+		}
+	}
+}
+
+func TestWipeDiskDryRunTracesCommands(t *testing.T) {
+	fake, _ := setupDryRunExecutor(t)
+
+	if err := WipeDisk(context.Background(), "/dev/phukit-test", WipeSignatures, true); err != nil {
+		t.Fatalf("WipeDisk failed: %v", err)
+	}
+	if len(fake.Commands) != 1 || fake.Commands[0].String() != "wipefs --all /dev/phukit-test" {
+		t.Errorf("recorded %v, want wipefs --all /dev/phukit-test", fake.Commands)
+	}
+}
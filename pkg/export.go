@@ -0,0 +1,411 @@
+package pkg
+
+import (
+	"archive/tar"
+	"cmp"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"syscall"
+	"time"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+	"golang.org/x/sys/unix"
+)
+
+// Root export
+//
+// ExportRoot packs an installed root back into a single-layer OCI image, to
+// capture a machine's state as a golden image, compare it with the image it
+// was installed from (phukit diff), or bring a machine installed another way
+// into the A/B flow by installing the export. The image holds the root with
+// its /etc, but not the contents of /var or of filesystems mounted below the
+// root, other than /usr. The mount points are kept as empty directories.
+// Destinations are those of MirrorImage.
+
+// exportTempDir holds the layer while it is built; /var, since the root and
+// /tmp are usually too small. A variable for tests.
+var exportTempDir = "/var/tmp"
+
+// exportSkipped are paths, relative to the root, that are never exported:
+// phukit's record of the deployment, the composefs image of /usr (/usr is
+// exported instead) and the machine ID, which every machine installed from
+// the export would share otherwise
+var exportSkipped = map[string]bool{
+	DeploymentFile:                   true,
+	filepath.Dir(composefsImagePath): true,
+	"etc/machine-id":                 true,
+}
+
+// exportBootcLabel marks the exported image as bootable, as bootc images are
+const exportBootcLabel = "containers.bootc"
+
+// ExportOptions are the settings of an ExportRoot run
+type ExportOptions struct {
+	Root        string // Mounted root to export; "" for the booted root
+	Destination string // oci:DIR[:TAG], oci-archive:FILE[:TAG] or [docker://]REFERENCE
+	DryRun      bool
+}
+
+// ExportResult describes an exported root
+type ExportResult struct {
+	Root        string `json:"root"`
+	Destination string `json:"destination"`
+	Digest      string `json:"digest,omitempty"`
+	BaseImage   string `json:"base_image,omitempty"`  // Image the root was deployed from
+	BaseDigest  string `json:"base_digest,omitempty"` // Its digest
+	Files       int    `json:"files"`                 // Entries in the layer
+	Size        int64  `json:"size"`                  // Bytes of the regular files
+	LayerSize   int64  `json:"layer_size,omitempty"`  // Bytes of the compressed layer
+}
+
+// ExportRoot writes the root opts.Root as an OCI image to opts.Destination
+func ExportRoot(ctx context.Context, opts ExportOptions) (*ExportResult, error) {
+	root := cmp.Or(opts.Root, bootedRoot)
+	dst, err := parseMirrorDestination(opts.Destination, "latest")
+	if err != nil {
+		return nil, err
+	}
+	if info, err := os.Stat(root); err != nil {
+		return nil, fmt.Errorf("failed to read root: %w", err)
+	} else if !info.IsDir() {
+		return nil, fmt.Errorf("root %s is not a directory", root)
+	}
+	skip, err := exportMountPoints(root)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &ExportResult{Root: root, Destination: opts.Destination}
+	// Roots installed without phukit have no deployment record
+	base, err := ReadDeployment(root)
+	if err == nil {
+		result.BaseImage, result.BaseDigest = base.ImageRef, base.ImageDigest
+	} else {
+		logger.Debug("exported root has no deployment record", "root", root, "error", err)
+		base = nil
+	}
+
+	fmt.Printf("Exporting %s...\n", root)
+	if result.BaseImage != "" {
+		fmt.Printf("  Deployed from: %s\n", result.BaseImage)
+	}
+	if opts.DryRun {
+		err := walkTree(ctx, root, exportSkipped, skip, func(_ string, _ string, info fs.FileInfo) error {
+			result.Files++
+			if info.Mode().IsRegular() {
+				result.Size += info.Size()
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		fmt.Printf("[DRY RUN] Would export %d files (%s) to %s\n", result.Files, FormatSize(uint64(result.Size)), opts.Destination)
+		return result, nil
+	}
+
+	tmp, err := os.MkdirTemp(exportTempDir, "phukit-export-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temporary directory: %w", err)
+	}
+	defer func() { _ = os.RemoveAll(tmp) }()
+	layerPath := filepath.Join(tmp, "layer.tar.gz")
+	if err := writeExportLayer(ctx, root, skip, layerPath, result); err != nil {
+		return nil, err
+	}
+
+	img, err := exportImage(layerPath, base)
+	if err != nil {
+		return nil, err
+	}
+	content := mirrorContent{image: img}
+	blobs, err := content.blobs()
+	if err != nil {
+		return nil, err
+	}
+	if result.Digest, err = content.digest(); err != nil {
+		return nil, err
+	}
+	if info, err := os.Stat(layerPath); err == nil {
+		result.LayerSize = info.Size()
+	}
+	fmt.Printf("  Digest: %s\n", result.Digest)
+	fmt.Printf("  Size:   %s in %d files, %s compressed\n", FormatSize(uint64(result.Size)), result.Files, FormatSize(uint64(result.LayerSize)))
+
+	fmt.Printf("Writing to %s...\n", opts.Destination)
+	if err := content.write(ctx, dst, blobs, PhaseExport); err != nil {
+		return nil, err
+	}
+	logger.Info("root exported", "root", root, "destination", result.Destination, "digest", result.Digest, "size", result.Size)
+	return result, nil
+}
+
+// exportMountPoints returns the filesystems mounted below root, relative to
+// it, whose contents are not exported: all but /usr, which holds the image
+// also when it is a composefs or overlay mount
+func exportMountPoints(root string) (map[string]bool, error) {
+	skip, err := mountPointsBelow(root)
+	if err != nil {
+		return nil, err
+	}
+	delete(skip, "usr")
+	skip["var"] = true
+	return skip, nil
+}
+
+// mountPointsBelow returns the filesystems mounted below dir, relative to it
+func mountPointsBelow(dir string) (map[string]bool, error) {
+	mounts, err := readMounts()
+	if err != nil {
+		return nil, err
+	}
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	below := map[string]bool{}
+	for _, m := range mounts {
+		rel, err := filepath.Rel(abs, m.target)
+		if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, "../") {
+			continue
+		}
+		below[rel] = true
+	}
+	return below, nil
+}
+
+// walkTree calls fn with the path, the path relative to root and the info of
+// every entry below root but those in skipped. Mount points in skip are
+// passed without their contents.
+func walkTree(ctx context.Context, root string, skipped, skip map[string]bool, fn func(path, rel string, info fs.FileInfo) error) error {
+	err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		// Files of a running system come and go while it is read
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if rel == "." {
+			return nil
+		}
+		if skipped[rel] {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		info, err := d.Info()
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		// Sockets belong to running services and cannot be archived
+		if info.Mode()&fs.ModeSocket != 0 {
+			return nil
+		}
+		if err := fn(p, rel, info); err != nil {
+			return err
+		}
+		if d.IsDir() && skip[rel] {
+			return filepath.SkipDir
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", root, err)
+	}
+	return nil
+}
+
+// writeExportLayer writes the exported entries of root as a gzipped tar to
+// path, counting them in result
+func writeExportLayer(ctx context.Context, root string, skip map[string]bool, path string, result *ExportResult) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to create layer: %w", err)
+	}
+	defer func() { _ = f.Close() }()
+	gz := gzip.NewWriter(f)
+	tw := tar.NewWriter(gz)
+
+	links := map[inodeKey]string{}
+	err = walkTree(ctx, root, exportSkipped, skip, func(p, rel string, info fs.FileInfo) error {
+		if err := writeExportEntry(tw, p, rel, info, links); err != nil {
+			return fmt.Errorf("%s: %w", p, err)
+		}
+		result.Files++
+		if info.Mode().IsRegular() {
+			result.Size += info.Size()
+		}
+		if result.Files%1000 == 0 {
+			reportPhaseProgress(PhaseExport, result.Files, 0, "/"+rel)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if err := tw.Close(); err != nil {
+		return fmt.Errorf("failed to write layer: %w", err)
+	}
+	if err := gz.Close(); err != nil {
+		return fmt.Errorf("failed to write layer: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("failed to write layer: %w", err)
+	}
+	return nil
+}
+
+// writeExportEntry writes the entry at p to tw as rel, with its owner, mode,
+// extended attributes and hardlinks to entries written before
+func writeExportEntry(tw *tar.Writer, p, rel string, info fs.FileInfo, links map[inodeKey]string) error {
+	var link string
+	if info.Mode()&fs.ModeSymlink != 0 {
+		var err error
+		if link, err = os.Readlink(p); err != nil {
+			return err
+		}
+	}
+	hdr, err := tar.FileInfoHeader(info, link)
+	if err != nil {
+		return err
+	}
+	hdr.Name = rel
+	if info.IsDir() {
+		hdr.Name += "/"
+	}
+	hdr.Uname, hdr.Gname = "", ""
+	hdr.Format = tar.FormatPAX
+	hdr.AccessTime, hdr.ChangeTime = time.Time{}, time.Time{}
+
+	if st, ok := info.Sys().(*syscall.Stat_t); ok && info.Mode().IsRegular() && st.Nlink > 1 {
+		key := inodeKey{dev: uint64(st.Dev), ino: st.Ino}
+		if first, seen := links[key]; seen {
+			hdr.Typeflag, hdr.Linkname, hdr.Size = tar.TypeLink, first, 0
+		} else {
+			links[key] = rel
+		}
+	}
+	xattrs, err := exportXattrs(p)
+	if err != nil {
+		return err
+	}
+	for name, value := range xattrs {
+		if hdr.PAXRecords == nil {
+			hdr.PAXRecords = map[string]string{}
+		}
+		hdr.PAXRecords["SCHILY.xattr."+name] = value
+	}
+
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	if hdr.Typeflag != tar.TypeReg {
+		return nil
+	}
+	src, err := os.Open(p)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = src.Close() }()
+	_, err = io.CopyN(tw, src, hdr.Size)
+	return err
+}
+
+// exportXattrs returns the extended attributes of p worth keeping in an
+// image, such as file capabilities. SELinux labels are left to the policy
+// of the machine the image is installed on.
+func exportXattrs(p string) (map[string]string, error) {
+	size, err := unix.Llistxattr(p, nil)
+	if err != nil {
+		if errors.Is(err, unix.ENOTSUP) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("listxattr: %w", err)
+	}
+	if size == 0 {
+		return nil, nil
+	}
+	buf := make([]byte, size)
+	if size, err = unix.Llistxattr(p, buf); err != nil {
+		return nil, fmt.Errorf("listxattr: %w", err)
+	}
+	xattrs := map[string]string{}
+	for _, name := range splitXattrNames(buf[:size]) {
+		if name == "security.selinux" {
+			continue
+		}
+		vsize, err := unix.Lgetxattr(p, name, nil)
+		if err != nil {
+			return nil, fmt.Errorf("getxattr %s: %w", name, err)
+		}
+		value := make([]byte, vsize)
+		if vsize > 0 {
+			if vsize, err = unix.Lgetxattr(p, name, value); err != nil {
+				return nil, fmt.Errorf("getxattr %s: %w", name, err)
+			}
+		}
+		xattrs[name] = string(value[:vsize])
+	}
+	return xattrs, nil
+}
+
+// exportImage builds the image of the layer at layerPath for the running
+// machine's platform, naming the image the root was deployed from as its base
+func exportImage(layerPath string, base *Deployment) (v1.Image, error) {
+	layer, err := tarball.LayerFromFile(layerPath, tarball.WithMediaType(types.OCILayer))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read layer: %w", err)
+	}
+	img := mutate.MediaType(empty.Image, types.OCIManifestSchema1)
+	img = mutate.ConfigMediaType(img, types.OCIConfigJSON)
+	if img, err = mutate.AppendLayers(img, layer); err != nil {
+		return nil, fmt.Errorf("failed to build image: %w", err)
+	}
+
+	created := time.Now().UTC().Truncate(time.Second)
+	cfg, err := img.ConfigFile()
+	if err != nil {
+		return nil, fmt.Errorf("failed to build image: %w", err)
+	}
+	cfg = cfg.DeepCopy()
+	cfg.OS, cfg.Architecture = "linux", runtime.GOARCH
+	cfg.Created = v1.Time{Time: created}
+	cfg.Config.Labels = map[string]string{exportBootcLabel: "1"}
+	cfg.Config.Cmd = []string{"/sbin/init"}
+	cfg.History = []v1.History{{Created: v1.Time{Time: created}, CreatedBy: "phukit export"}}
+	if img, err = mutate.ConfigFile(img, cfg); err != nil {
+		return nil, fmt.Errorf("failed to build image: %w", err)
+	}
+
+	annotations := map[string]string{"org.opencontainers.image.created": created.Format(time.RFC3339)}
+	if base != nil && base.ImageRef != "" {
+		annotations["org.opencontainers.image.base.name"] = base.ImageRef
+		if base.ImageDigest != "" {
+			annotations["org.opencontainers.image.base.digest"] = base.ImageDigest
+		}
+	}
+	return mutate.Annotations(img, annotations).(v1.Image), nil
+}
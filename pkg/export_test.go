@@ -0,0 +1,151 @@
+package pkg
+
+import (
+	"archive/tar"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/v1/layout"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+)
+
+// exportTestRoot creates a root with a deployment record, a hardlink, a
+// symlink, /var contents and a filesystem mounted on /boot
+func exportTestRoot(t *testing.T) string {
+	t.Helper()
+	root := t.TempDir()
+	for path, content := range map[string]string{
+		"usr/bin/bash":       "#!bash",
+		"usr/lib/os-release": "ID=example\n",
+		"etc/hostname":       "golden\n",
+		"etc/machine-id":     "0123456789abcdef\n",
+		"var/lib/data":       "state",
+		"boot/vmlinuz":       "kernel",
+	} {
+		writeFakeFile(t, filepath.Join(root, path), content)
+	}
+	if err := os.Link(filepath.Join(root, "usr/bin/bash"), filepath.Join(root, "usr/bin/sh")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("../usr/lib/os-release", filepath.Join(root, "etc/os-release")); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteDeployment(root, &Deployment{ImageRef: "quay.io/example/os:42", ImageDigest: "sha256:0123"}, false); err != nil {
+		t.Fatal(err)
+	}
+
+	oldMounts, oldTemp := procMountsPath, exportTempDir
+	t.Cleanup(func() { procMountsPath, exportTempDir = oldMounts, oldTemp })
+	procMountsPath = filepath.Join(t.TempDir(), "mounts")
+	exportTempDir = t.TempDir()
+	writeFakeFile(t, procMountsPath, "/dev/sda3 "+root+" ext4 rw 0 0\n/dev/sda1 "+filepath.Join(root, "boot")+" vfat rw 0 0\ncomposefs "+filepath.Join(root, "usr")+" overlay ro 0 0\n")
+	return root
+}
+
+func TestExportRoot(t *testing.T) {
+	root := exportTestRoot(t)
+	dir := filepath.Join(t.TempDir(), "images")
+
+	result, err := ExportRoot(t.Context(), ExportOptions{Root: root, Destination: "oci:" + dir + ":golden"})
+	if err != nil {
+		t.Fatalf("ExportRoot() error = %v", err)
+	}
+	if result.BaseImage != "quay.io/example/os:42" || result.Digest == "" {
+		t.Errorf("ExportRoot() = %+v", result)
+	}
+
+	p, err := layout.FromPath(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	idx, err := p.ImageIndex()
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifest, err := idx.IndexManifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(manifest.Manifests) != 1 || manifest.Manifests[0].Digest.String() != result.Digest {
+		t.Fatalf("index.json = %+v, want the exported image", manifest.Manifests)
+	}
+	img, err := idx.Image(manifest.Manifests[0].Digest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := img.Manifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Annotations["org.opencontainers.image.base.name"] != "quay.io/example/os:42" {
+		t.Errorf("annotations = %v, want the base image", m.Annotations)
+	}
+	cfg, err := img.ConfigFile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Config.Labels[exportBootcLabel] != "1" || cfg.OS != "linux" {
+		t.Errorf("config = %+v", cfg.Config)
+	}
+
+	entries := map[string]*tar.Header{}
+	rc := mutate.Extract(img)
+	defer func() { _ = rc.Close() }()
+	tr := tar.NewReader(rc)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		entries[hdr.Name] = hdr
+	}
+	var names []string
+	for name := range entries {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	want := []string{
+		"boot", "etc", "etc/hostname", "etc/os-release",
+		"usr", "usr/bin", "usr/bin/bash", "usr/bin/sh", "usr/lib", "usr/lib/os-release", "usr/lib/phukit",
+		"var",
+	}
+	if !slices.Equal(names, want) {
+		t.Errorf("exported entries = %v, want %v", names, want)
+	}
+	if hdr := entries["usr/bin/sh"]; hdr == nil || hdr.Typeflag != tar.TypeLink || hdr.Linkname != "usr/bin/bash" {
+		t.Errorf("usr/bin/sh = %+v, want a hardlink to usr/bin/bash", hdr)
+	}
+	if hdr := entries["etc/os-release"]; hdr == nil || hdr.Typeflag != tar.TypeSymlink || hdr.Linkname != "../usr/lib/os-release" {
+		t.Errorf("etc/os-release = %+v, want a symlink", hdr)
+	}
+
+	// Nothing is left behind in the temporary directory
+	if left, _ := os.ReadDir(exportTempDir); len(left) != 0 {
+		t.Errorf("temporary directory holds %d entries", len(left))
+	}
+}
+
+func TestExportRootDryRun(t *testing.T) {
+	root := exportTestRoot(t)
+	path := filepath.Join(t.TempDir(), "golden.tar")
+
+	result, err := ExportRoot(t.Context(), ExportOptions{Root: root, Destination: "oci-archive:" + path, DryRun: true})
+	if err != nil {
+		t.Fatalf("ExportRoot() error = %v", err)
+	}
+	if result.Files != 12 || result.Size != int64(len("#!bash")*2+len("ID=example\n")+len("golden\n")) {
+		t.Errorf("ExportRoot() = %+v", result)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("dry run wrote %s", path)
+	}
+
+	if _, err := ExportRoot(t.Context(), ExportOptions{Root: filepath.Join(root, "missing"), Destination: "oci-archive:" + path}); err == nil {
+		t.Error("ExportRoot() of a missing root succeeded")
+	}
+}
@@ -0,0 +1,107 @@
+package pkg
+
+import (
+	"archive/tar"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+)
+
+// extractStatsDirDepth is how many path components are used to group bytes by directory
+// (e.g. "usr/lib" for depth 2)
+const extractStatsDirDepth = 2
+
+// ExtractStats holds statistics about a container filesystem extraction
+type ExtractStats struct {
+	Layers      int   `json:"layers"`
+	Files       int   `json:"files"`       // Regular files and hard links written
+	Directories int   `json:"directories"` // Directories created
+	Symlinks    int   `json:"symlinks"`    // Symbolic links created
+	Whiteouts   int   `json:"whiteouts"`   // Whiteout entries processed
+	TotalBytes  int64 `json:"total_bytes"` // Bytes of regular file content written
+	// DownloadedBytes is the compressed size of the layers fetched from the
+	// registry, 0 if a container runtime fetched the image
+	DownloadedBytes int64            `json:"downloaded_bytes,omitempty"`
+	DirBytes        map[string]int64 `json:"-"` // Bytes per top-level directory group
+}
+
+// DirSize is the total size of a directory group in the extracted filesystem
+type DirSize struct {
+	Path  string `json:"path"`
+	Bytes int64  `json:"bytes"`
+}
+
+// NewExtractStats creates an empty ExtractStats
+func NewExtractStats() *ExtractStats {
+	return &ExtractStats{
+		DirBytes: make(map[string]int64),
+	}
+}
+
+// record accounts for a single tar entry that was extracted
+func (s *ExtractStats) record(header *tar.Header) {
+	switch header.Typeflag {
+	case tar.TypeDir:
+		s.Directories++
+	case tar.TypeReg, tar.TypeLink:
+		s.Files++
+		if header.Typeflag == tar.TypeLink {
+			return
+		}
+		s.TotalBytes += header.Size
+		s.DirBytes[statsDirGroup(header.Name)] += header.Size
+	case tar.TypeSymlink:
+		s.Symlinks++
+	}
+}
+
+// statsDirGroup returns the directory group a file is accounted under
+func statsDirGroup(name string) string {
+	dir := path.Dir(path.Clean("/" + name))
+	parts := strings.Split(strings.TrimPrefix(dir, "/"), "/")
+	if len(parts) > extractStatsDirDepth {
+		parts = parts[:extractStatsDirDepth]
+	}
+	return "/" + strings.Join(parts, "/")
+}
+
+// LargestDirectories returns the n directory groups with the most file content, largest first
+func (s *ExtractStats) LargestDirectories(n int) []DirSize {
+	dirs := make([]DirSize, 0, len(s.DirBytes))
+	for p, b := range s.DirBytes {
+		dirs = append(dirs, DirSize{Path: p, Bytes: b})
+	}
+	sort.Slice(dirs, func(i, j int) bool {
+		if dirs[i].Bytes != dirs[j].Bytes {
+			return dirs[i].Bytes > dirs[j].Bytes
+		}
+		return dirs[i].Path < dirs[j].Path
+	})
+	if n > 0 && len(dirs) > n {
+		dirs = dirs[:n]
+	}
+	return dirs
+}
+
+// Print writes a summary of the extraction statistics
+// The largest directories are only listed in verbose mode
+func (s *ExtractStats) Print(verbose bool) {
+	fmt.Printf("  Extracted %d files, %d directories, %d symlinks (%s) from %d layer(s)\n",
+		s.Files, s.Directories, s.Symlinks, FormatSize(uint64(s.TotalBytes)), s.Layers)
+
+	if !verbose {
+		return
+	}
+
+	if s.Whiteouts > 0 {
+		fmt.Printf("  Processed %d whiteout(s)\n", s.Whiteouts)
+	}
+	largest := s.LargestDirectories(10)
+	if len(largest) > 0 {
+		fmt.Println("  Largest directories:")
+		for _, dir := range largest {
+			fmt.Printf("    %-30s %s\n", dir.Path, FormatSize(uint64(dir.Bytes)))
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package pkg
+
+import (
+	"archive/tar"
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestExtractTarWithStats(t *testing.T) {
+	targetDir := t.TempDir()
+
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+
+	entries := []struct {
+		header  tar.Header
+		content string
+	}{
+		{tar.Header{Name: "usr/", Typeflag: tar.TypeDir, Mode: 0755}, ""},
+		{tar.Header{Name: "usr/lib/", Typeflag: tar.TypeDir, Mode: 0755}, ""},
+		{tar.Header{Name: "usr/lib/modules/6.1/vmlinuz", Typeflag: tar.TypeReg, Mode: 0644}, "kernel-image-bytes"},
+		{tar.Header{Name: "usr/bin/bash", Typeflag: tar.TypeReg, Mode: 0755}, "bash"},
+		{tar.Header{Name: "etc/os-release", Typeflag: tar.TypeReg, Mode: 0644}, "ID=test\n"},
+		{tar.Header{Name: "bin", Typeflag: tar.TypeSymlink, Linkname: "usr/bin"}, ""},
+		{tar.Header{Name: "etc/.wh.removed", Typeflag: tar.TypeReg, Mode: 0644}, ""},
+	}
+
+	for _, e := range entries {
+		hdr := e.header
+		hdr.Size = int64(len(e.content))
+		if err := tw.WriteHeader(&hdr); err != nil {
+			t.Fatalf("Failed to write tar header: %v", err)
+		}
+		if e.content != "" {
+			if _, err := tw.Write([]byte(e.content)); err != nil {
+				t.Fatalf("Failed to write tar content: %v", err)
+			}
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatalf("Failed to close tar writer: %v", err)
+	}
+
+	stats := NewExtractStats()
+	if err := extractTarWithStats(&buf, targetDir, stats); err != nil {
+		t.Fatalf("extractTarWithStats failed: %v", err)
+	}
+
+	if stats.Files != 3 {
+		t.Errorf("Files = %d, want 3", stats.Files)
+	}
+	if stats.Directories != 2 {
+		t.Errorf("Directories = %d, want 2", stats.Directories)
+	}
+	if stats.Symlinks != 1 {
+		t.Errorf("Symlinks = %d, want 1", stats.Symlinks)
+	}
+	if stats.Whiteouts != 1 {
+		t.Errorf("Whiteouts = %d, want 1", stats.Whiteouts)
+	}
+	if want := int64(len("kernel-image-bytes") + len("bash") + len("ID=test\n")); stats.TotalBytes != want {
+		t.Errorf("TotalBytes = %d, want %d", stats.TotalBytes, want)
+	}
+
+	want := []DirSize{
+		{Path: "/usr/lib", Bytes: int64(len("kernel-image-bytes"))},
+		{Path: "/etc", Bytes: int64(len("ID=test\n"))},
+	}
+	if got := stats.LargestDirectories(2); !reflect.DeepEqual(got, want) {
+		t.Errorf("LargestDirectories(2) = %v, want %v", got, want)
+	}
+}
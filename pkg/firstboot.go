@@ -0,0 +1,146 @@
+package pkg
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// First-boot provisioning
+//
+// phukit-firstboot.service runs once, on the first boot of an installed
+// system, for tasks that must happen on the machine itself rather than at
+// install time: growing /var into its partition, committing a machine ID,
+// generating SSH host keys and running the scripts in
+// /etc/phukit/firstboot.d. It then records that it ran on /var and disables
+// itself. The unit and scripts are in /etc, which updates carry over, but
+// the record on the shared /var keeps it from running again.
+
+// FirstbootScriptsDir holds executable scripts run by phukit-firstboot, in
+// name order
+const FirstbootScriptsDir = "/etc/phukit/firstboot.d"
+
+// FirstbootDonePath records that first-boot provisioning ran
+const FirstbootDonePath = "/var/lib/phukit/firstboot.done"
+
+// firstbootScriptPath is the script the first-boot service runs
+const firstbootScriptPath = "/etc/phukit/firstboot"
+
+// firstbootService is the first-boot unit name
+const firstbootService = "phukit-firstboot.service"
+
+// firstbootUnit is the first-boot unit. It runs before sshd so the host
+// keys exist when it starts. The script is run through sh as files in /etc
+// may not be executable by init under SELinux.
+const firstbootUnit = `[Unit]
+Description=phukit first-boot provisioning
+Documentation=https://github.com/bketelsen/phukit
+After=local-fs.target network-online.target
+Wants=network-online.target
+Before=sshd.service systemd-user-sessions.service
+ConditionPathExists=!` + FirstbootDonePath + `
+
+[Service]
+Type=oneshot
+RemainAfterExit=yes
+ExecStart=/bin/sh ` + firstbootScriptPath + `
+
+[Install]
+WantedBy=multi-user.target
+`
+
+// firstbootScript runs the first-boot tasks. A failed task fails the unit
+// but does not stop the others, and the service is disabled either way, so
+// a failure is seen in 'systemctl --failed' rather than retried every boot.
+const firstbootScript = `#!/bin/sh
+# Installed by phukit: runs once on the first boot, then disables itself.
+# Remove ` + FirstbootDonePath + ` and start ` + firstbootService + ` to run it again.
+set -u
+status=0
+log() { echo "phukit-firstboot: $*"; }
+
+# Grow the root and /var into their partitions, which may have been enlarged
+# since install
+if [ -x /usr/lib/systemd/systemd-growfs ]; then
+	log "growing / and /var"
+	/usr/lib/systemd/systemd-growfs / || status=1
+	/usr/lib/systemd/systemd-growfs /var || status=1
+fi
+
+# Commit the machine ID systemd generated for this machine
+if mountpoint -q /etc/machine-id 2>/dev/null; then
+	log "committing machine ID"
+	systemd-machine-id-setup --commit || status=1
+elif [ ! -s /etc/machine-id ] || grep -qx uninitialized /etc/machine-id; then
+	log "generating machine ID"
+	systemd-machine-id-setup || status=1
+fi
+
+# Generate missing SSH host keys
+if command -v ssh-keygen >/dev/null 2>&1; then
+	log "generating SSH host keys"
+	ssh-keygen -A || status=1
+fi
+
+for script in ` + FirstbootScriptsDir + `/*; do
+	[ -f "$script" ] && [ -x "$script" ] || continue
+	log "running $script"
+	"$script" || { rc=$?; log "$script failed with status $rc"; status=1; }
+done
+
+mkdir -p "$(dirname ` + FirstbootDonePath + `)" && touch ` + FirstbootDonePath + `
+systemctl disable ` + firstbootService + `
+exit $status
+`
+
+// CheckFirstbootScripts checks that scripts are regular files, before an
+// install wipes anything
+func CheckFirstbootScripts(scripts []string) error {
+	for _, script := range scripts {
+		info, err := os.Stat(script)
+		if err != nil {
+			return fmt.Errorf("first-boot script: %w", err)
+		}
+		if !info.Mode().IsRegular() {
+			return fmt.Errorf("first-boot script %s is not a regular file", script)
+		}
+	}
+	return nil
+}
+
+// writeFirstbootService installs and enables the first-boot service in the
+// root at root, and copies scripts into its scripts directory
+func writeFirstbootService(root string, scripts []string) error {
+	fmt.Println("  Installing first-boot provisioning service...")
+	scriptsDir := filepath.Join(root, FirstbootScriptsDir)
+	if err := os.MkdirAll(scriptsDir, 0755); err != nil {
+		return fmt.Errorf("failed to create %s: %w", FirstbootScriptsDir, err)
+	}
+	if err := os.WriteFile(filepath.Join(root, firstbootScriptPath), []byte(firstbootScript), 0755); err != nil {
+		return fmt.Errorf("failed to write first-boot script: %w", err)
+	}
+	for _, script := range scripts {
+		dest := filepath.Join(scriptsDir, filepath.Base(script))
+		if err := copyFile(script, dest); err != nil {
+			return fmt.Errorf("failed to copy first-boot script %s: %w", script, err)
+		}
+		if err := os.Chmod(dest, 0755); err != nil {
+			return fmt.Errorf("failed to copy first-boot script %s: %w", script, err)
+		}
+	}
+
+	unitDir := filepath.Join(root, "etc", "systemd", "system")
+	wants := filepath.Join(unitDir, "multi-user.target.wants")
+	if err := os.MkdirAll(wants, 0755); err != nil {
+		return fmt.Errorf("failed to enable %s: %w", firstbootService, err)
+	}
+	if err := os.WriteFile(filepath.Join(unitDir, firstbootService), []byte(firstbootUnit), 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", firstbootService, err)
+	}
+	link := filepath.Join(wants, firstbootService)
+	_ = os.Remove(link)
+	if err := os.Symlink(filepath.Join("..", firstbootService), link); err != nil {
+		return fmt.Errorf("failed to enable %s: %w", firstbootService, err)
+	}
+	return nil
+}
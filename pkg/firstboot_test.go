@@ -0,0 +1,66 @@
+package pkg
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestWriteFirstbootService(t *testing.T) {
+	root := t.TempDir()
+	script := filepath.Join(t.TempDir(), "50-register.sh")
+	writeFakeFile(t, script, "#!/bin/sh\necho registered\n")
+
+	if err := writeFirstbootService(root, []string{script}); err != nil {
+		t.Fatalf("writeFirstbootService() error = %v", err)
+	}
+
+	unit, err := os.ReadFile(filepath.Join(root, "etc", "systemd", "system", firstbootService))
+	if err != nil {
+		t.Fatalf("unit not written: %v", err)
+	}
+	if !strings.Contains(string(unit), "ConditionPathExists=!"+FirstbootDonePath) {
+		t.Errorf("unit does not run once:\n%s", unit)
+	}
+	link, err := os.Readlink(filepath.Join(root, "etc", "systemd", "system", "multi-user.target.wants", firstbootService))
+	if err != nil || link != "../"+firstbootService {
+		t.Errorf("unit not enabled: %q, %v", link, err)
+	}
+
+	main, err := os.ReadFile(filepath.Join(root, firstbootScriptPath))
+	if err != nil {
+		t.Fatalf("script not written: %v", err)
+	}
+	for _, want := range []string{"systemd-growfs / ", "systemd-growfs /var", "systemd-machine-id-setup", "ssh-keygen -A", FirstbootScriptsDir, "systemctl disable " + firstbootService} {
+		if !strings.Contains(string(main), want) {
+			t.Errorf("script missing %q", want)
+		}
+	}
+	info, err := os.Stat(filepath.Join(root, FirstbootScriptsDir, "50-register.sh"))
+	if err != nil {
+		t.Fatalf("user script not copied: %v", err)
+	}
+	if info.Mode().Perm() != 0755 {
+		t.Errorf("user script mode = %v, want 0755", info.Mode().Perm())
+	}
+
+	// Installing again replaces the link instead of failing
+	if err := writeFirstbootService(root, nil); err != nil {
+		t.Errorf("writeFirstbootService() again error = %v", err)
+	}
+}
+
+func TestCheckFirstbootScripts(t *testing.T) {
+	dir := t.TempDir()
+	script := filepath.Join(dir, "a.sh")
+	writeFakeFile(t, script, "#!/bin/sh\n")
+	if err := CheckFirstbootScripts([]string{script}); err != nil {
+		t.Errorf("CheckFirstbootScripts() error = %v", err)
+	}
+	for _, bad := range []string{dir, filepath.Join(dir, "missing.sh")} {
+		if err := CheckFirstbootScripts([]string{bad}); err == nil {
+			t.Errorf("CheckFirstbootScripts(%q) expected error", bad)
+		}
+	}
+}
@@ -0,0 +1,351 @@
+package pkg
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+	"golang.org/x/sys/unix"
+)
+
+// Compression formats detected for image artifacts
+const (
+	CompressionNone = "none"
+	CompressionXZ   = "xz"
+	CompressionZstd = "zstd"
+	CompressionGzip = "gzip"
+)
+
+// flashBlockSize is the write size used when flashing images
+const flashBlockSize = 4 * 1024 * 1024
+
+// Magic numbers at the start of compressed streams
+var (
+	xzMagic   = []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+	gzipMagic = []byte{0x1f, 0x8b}
+)
+
+// ImageFlasher writes a pre-built disk image (e.g. an ARM SD card image) to a device
+type ImageFlasher struct {
+	ImagePath     string
+	Device        string
+	Verbose       bool
+	DryRun        bool
+	Verify        bool // Read the device back and compare checksums after writing
+	ConfirmDevice bool // Have the device path typed to confirm, instead of "yes"
+}
+
+// NewImageFlasher creates a new ImageFlasher
+func NewImageFlasher(imagePath, device string) *ImageFlasher {
+	return &ImageFlasher{
+		ImagePath: imagePath,
+		Device:    device,
+		Verify:    true,
+	}
+}
+
+// SetVerbose enables verbose output
+func (f *ImageFlasher) SetVerbose(verbose bool) {
+	f.Verbose = verbose
+}
+
+// SetDryRun enables dry run mode
+func (f *ImageFlasher) SetDryRun(dryRun bool) {
+	f.DryRun = dryRun
+}
+
+// SetVerify enables or disables read-back verification
+func (f *ImageFlasher) SetVerify(verify bool) {
+	f.Verify = verify
+}
+
+// SetConfirmDevice has the confirmation prompt ask for the device path
+// instead of "yes", as it does for disks of TypedConfirmationSize or more
+func (f *ImageFlasher) SetConfirmDevice(typed bool) {
+	f.ConfirmDevice = typed
+}
+
+// detectCompression returns the compression format of a stream from its first bytes
+func detectCompression(header []byte) string {
+	switch {
+	case bytes.HasPrefix(header, xzMagic):
+		return CompressionXZ
+	case bytes.HasPrefix(header, zstdMagic):
+		return CompressionZstd
+	case bytes.HasPrefix(header, gzipMagic):
+		return CompressionGzip
+	default:
+		return CompressionNone
+	}
+}
+
+// decompressReader wraps r with a decompressor for the given format
+func decompressReader(r io.Reader, compression string) (io.Reader, func(), error) {
+	switch compression {
+	case CompressionXZ:
+		xr, err := xz.NewReader(r)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to open xz stream: %w", err)
+		}
+		return xr, func() {}, nil
+	case CompressionZstd:
+		zr, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to open zstd stream: %w", err)
+		}
+		return zr, zr.Close, nil
+	case CompressionGzip:
+		gr, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to open gzip stream: %w", err)
+		}
+		return gr, func() { _ = gr.Close() }, nil
+	default:
+		return r, func() {}, nil
+	}
+}
+
+// countingReader counts bytes read from the underlying reader
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// imageSource is an opened, possibly compressed image artifact
+type imageSource struct {
+	file        *os.File
+	raw         *countingReader // Bytes read from the file, used for progress
+	reader      io.Reader       // Decompressed image data
+	closeReader func()
+	size        int64 // Size of the artifact file
+	compression string
+}
+
+// openImageArtifact opens an image file and sets up decompression based on its contents
+func openImageArtifact(path string) (*imageSource, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open image: %w", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return nil, fmt.Errorf("failed to stat image: %w", err)
+	}
+	if !info.Mode().IsRegular() {
+		_ = file.Close()
+		return nil, fmt.Errorf("image %s is not a regular file", path)
+	}
+
+	raw := &countingReader{r: file}
+	buffered := bufio.NewReaderSize(raw, flashBlockSize)
+	header, _ := buffered.Peek(len(xzMagic))
+	compression := detectCompression(header)
+
+	reader, closeReader, err := decompressReader(buffered, compression)
+	if err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+
+	return &imageSource{
+		file:        file,
+		raw:         raw,
+		reader:      reader,
+		closeReader: closeReader,
+		size:        info.Size(),
+		compression: compression,
+	}, nil
+}
+
+// Close releases the decompressor and the underlying file
+func (s *imageSource) Close() {
+	s.closeReader()
+	_ = s.file.Close()
+}
+
+// percent returns how much of the artifact file has been consumed
+func (s *imageSource) percent() int {
+	if s.size == 0 {
+		return 100
+	}
+	return int(s.raw.n * 100 / s.size)
+}
+
+// Flash writes the image to the device and, if enabled, verifies it
+func (f *ImageFlasher) Flash(ctx context.Context) error {
+	if f.DryRun {
+		fmt.Printf("[DRY RUN] Would write %s to %s\n", f.ImagePath, f.Device)
+		if f.Verify {
+			fmt.Printf("[DRY RUN] Would verify %s by reading it back\n", f.Device)
+		}
+		return nil
+	}
+
+	src, err := openImageArtifact(f.ImagePath)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	if src.compression != CompressionNone {
+		fmt.Printf("  Decompressing %s image on the fly\n", src.compression)
+	}
+
+	// O_EXCL makes the open fail if the block device is mounted or otherwise in use
+	dst, err := os.OpenFile(f.Device, os.O_WRONLY|os.O_EXCL, 0)
+	if err != nil {
+		return fmt.Errorf("failed to open %s for writing: %w", f.Device, err)
+	}
+
+	fmt.Printf("Writing %s to %s...\n", f.ImagePath, f.Device)
+	sum := sha256.New()
+	written, err := writeImage(ctx, dst, src, sum)
+	if err != nil {
+		_ = dst.Close()
+		return err
+	}
+
+	fmt.Println("  Flushing writes to disk...")
+	if err := dst.Sync(); err != nil {
+		_ = dst.Close()
+		return fmt.Errorf("failed to sync %s: %w", f.Device, err)
+	}
+	// Drop cached pages so verification reads what actually reached the device
+	_ = unix.Fadvise(int(dst.Fd()), 0, 0, unix.FADV_DONTNEED)
+	if err := dst.Close(); err != nil {
+		return fmt.Errorf("failed to close %s: %w", f.Device, err)
+	}
+	fmt.Printf("  Wrote %s\n", FormatSize(uint64(written)))
+
+	if !f.Verify {
+		return nil
+	}
+
+	fmt.Printf("Verifying %s...\n", f.Device)
+	if err := verifyImage(ctx, f.Device, written, sum.Sum(nil)); err != nil {
+		return err
+	}
+	fmt.Println("  Verification successful")
+
+	return nil
+}
+
+// writeImage copies the decompressed image to dst, hashing what is written
+func writeImage(ctx context.Context, dst io.Writer, src *imageSource, sum hash.Hash) (int64, error) {
+	buf := make([]byte, flashBlockSize)
+	var written int64
+	lastPercent := -1
+	defer reportPhaseEnd(PhaseFlash)
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return written, fmt.Errorf("flash cancelled after %s: %w", FormatSize(uint64(written)), err)
+		}
+		n, rerr := io.ReadFull(src.reader, buf)
+		if n > 0 {
+			if _, err := dst.Write(buf[:n]); err != nil {
+				return written, fmt.Errorf("failed to write at offset %d: %w", written, err)
+			}
+			sum.Write(buf[:n])
+			written += int64(n)
+
+			if p := src.percent(); p != lastPercent {
+				if p/10 != lastPercent/10 && !terminalProgress() {
+					fmt.Printf("  %d%% (%s written)\n", p, FormatSize(uint64(written)))
+				}
+				lastPercent = p
+				reportPhaseProgress(PhaseFlash, p, 100, fmt.Sprintf("%s written", FormatSize(uint64(written))))
+			}
+		}
+		if rerr == io.EOF || rerr == io.ErrUnexpectedEOF {
+			return written, nil
+		}
+		if rerr != nil {
+			return written, fmt.Errorf("failed to read image: %w", rerr)
+		}
+	}
+}
+
+// verifyImage reads size bytes back from device and compares their checksum to want
+func verifyImage(ctx context.Context, device string, size int64, want []byte) error {
+	file, err := os.Open(device)
+	if err != nil {
+		return fmt.Errorf("failed to open %s for verification: %w", device, err)
+	}
+	defer func() { _ = file.Close() }()
+
+	defer reportPhaseEnd(PhaseVerify)
+
+	sum := sha256.New()
+	buf := make([]byte, flashBlockSize)
+	var read int64
+	for read < size {
+		if err := ctx.Err(); err != nil {
+			return fmt.Errorf("verification cancelled: %w", err)
+		}
+		chunk := buf
+		if remaining := size - read; remaining < int64(len(chunk)) {
+			chunk = chunk[:remaining]
+		}
+		n, err := io.ReadFull(file, chunk)
+		sum.Write(chunk[:n])
+		read += int64(n)
+		if err != nil {
+			return fmt.Errorf("failed to read back %s at offset %d: %w", device, read, err)
+		}
+		if size > 0 {
+			reportPhaseProgress(PhaseVerify, int(read*100/size), 100, "")
+		}
+	}
+
+	if got := sum.Sum(nil); !bytes.Equal(got, want) {
+		return fmt.Errorf("%w: %s does not match the image (sha256 %x, expected %x)", ErrVerificationFailed, device, got, want)
+	}
+	return nil
+}
+
+// FlashComplete validates the device, asks for confirmation and flashes the image
+func (f *ImageFlasher) FlashComplete(ctx context.Context) error {
+	info, err := os.Stat(f.ImagePath)
+	if err != nil {
+		return fmt.Errorf("failed to read image: %w", err)
+	}
+
+	// The decompressed size is unknown up front, so only raw images are checked
+	// against the disk size here; oversized compressed images fail while writing
+	minSize := uint64(0)
+	if src, err := openImageArtifact(f.ImagePath); err == nil {
+		if src.compression == CompressionNone {
+			minSize = uint64(info.Size())
+		}
+		src.Close()
+	}
+
+	fmt.Printf("Validating disk %s...\n", f.Device)
+	if err := ValidateDisk(f.Device, minSize); err != nil {
+		return err
+	}
+
+	warning := fmt.Sprintf("WARNING: This will DESTROY ALL DATA on %s!", f.Device)
+	if !f.DryRun && !confirmWipe(f.Device, f.ConfirmDevice, append([]string{warning}, diskContents(ctx, f.Device)...)...) {
+		return fmt.Errorf("flash %w", ErrAborted)
+	}
+
+	return f.Flash(ctx)
+}
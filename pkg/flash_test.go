@@ -0,0 +1,136 @@
+package pkg
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+func TestDetectCompression(t *testing.T) {
+	tests := []struct {
+		name   string
+		header []byte
+		want   string
+	}{
+		{"xz", []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}, CompressionXZ},
+		{"zstd", []byte{0x28, 0xb5, 0x2f, 0xfd, 0x00, 0x00}, CompressionZstd},
+		{"gzip", []byte{0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00}, CompressionGzip},
+		{"raw", []byte{0xeb, 0x3c, 0x90, 'm', 'k', 'f'}, CompressionNone},
+		{"short", []byte{0x1f}, CompressionNone},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := detectCompression(tt.header); got != tt.want {
+				t.Errorf("detectCompression() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// compressImage compresses data with the given format
+func compressImage(t *testing.T, data []byte, compression string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	var w io.WriteCloser
+	var err error
+	switch compression {
+	case CompressionXZ:
+		w, err = xz.NewWriter(&buf)
+	case CompressionZstd:
+		w, err = zstd.NewWriter(&buf)
+	case CompressionGzip:
+		w = gzip.NewWriter(&buf)
+	default:
+		return data
+	}
+	if err != nil {
+		t.Fatalf("failed to create %s writer: %v", compression, err)
+	}
+	if _, err := w.Write(data); err != nil {
+		t.Fatalf("failed to compress: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("failed to compress: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestImageFlasherFlash(t *testing.T) {
+	// Larger than one block so the write loop runs more than once
+	data := make([]byte, flashBlockSize+12345)
+	rand.New(rand.NewSource(1)).Read(data)
+
+	for _, compression := range []string{CompressionNone, CompressionXZ, CompressionZstd, CompressionGzip} {
+		t.Run(compression, func(t *testing.T) {
+			tmpDir := t.TempDir()
+			imagePath := filepath.Join(tmpDir, "os.img")
+			devicePath := filepath.Join(tmpDir, "device")
+
+			if err := os.WriteFile(imagePath, compressImage(t, data, compression), 0644); err != nil {
+				t.Fatalf("failed to write image: %v", err)
+			}
+			// Stand-in for a block device, larger than the image
+			if err := os.WriteFile(devicePath, bytes.Repeat([]byte{0xff}, len(data)+4096), 0644); err != nil {
+				t.Fatalf("failed to write device: %v", err)
+			}
+
+			flasher := NewImageFlasher(imagePath, devicePath)
+			if err := flasher.Flash(context.Background()); err != nil {
+				t.Fatalf("Flash failed: %v", err)
+			}
+
+			got, err := os.ReadFile(devicePath)
+			if err != nil {
+				t.Fatalf("failed to read device: %v", err)
+			}
+			if !bytes.Equal(got[:len(data)], data) {
+				t.Error("device contents do not match image")
+			}
+			if !bytes.Equal(got[len(data):], bytes.Repeat([]byte{0xff}, 4096)) {
+				t.Error("data past the end of the image was modified")
+			}
+		})
+	}
+}
+
+func TestVerifyImageMismatch(t *testing.T) {
+	devicePath := filepath.Join(t.TempDir(), "device")
+	if err := os.WriteFile(devicePath, []byte("corrupted data"), 0644); err != nil {
+		t.Fatalf("failed to write device: %v", err)
+	}
+
+	want := sha256.Sum256([]byte("expected image"))
+	err := verifyImage(context.Background(), devicePath, int64(len("expected image")), want[:])
+	if err == nil || !strings.Contains(err.Error(), "verification failed") {
+		t.Errorf("expected verification failure, got %v", err)
+	}
+}
+
+func TestImageFlasherDryRun(t *testing.T) {
+	devicePath := filepath.Join(t.TempDir(), "device")
+	if err := os.WriteFile(devicePath, []byte("untouched"), 0644); err != nil {
+		t.Fatalf("failed to write device: %v", err)
+	}
+
+	flasher := NewImageFlasher("/nonexistent/os.img", devicePath)
+	flasher.SetDryRun(true)
+	if err := flasher.Flash(context.Background()); err != nil {
+		t.Fatalf("dry run Flash failed: %v", err)
+	}
+
+	got, _ := os.ReadFile(devicePath)
+	if string(got) != "untouched" {
+		t.Errorf("dry run modified the device: %q", got)
+	}
+}
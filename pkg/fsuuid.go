@@ -0,0 +1,218 @@
+package pkg
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var (
+	// ErrUnknownFilesystem is returned when no supported superblock is found
+	ErrUnknownFilesystem = errors.New("unknown filesystem")
+	// ErrUUIDNotFound is returned when no block device has the requested UUID
+	ErrUUIDNotFound = errors.New("no device with UUID found")
+)
+
+// devDiskByUUIDPath holds udev's by-uuid symlinks, overridable in tests
+var devDiskByUUIDPath = "/dev/disk/by-uuid"
+
+// sysClassBlockPath lists all block devices including partitions, overridable in tests
+var sysClassBlockPath = "/sys/class/block"
+
+// devPath is where block device nodes live, overridable in tests
+var devPath = "/dev"
+
+// UUIDError describes a failure to read a filesystem UUID
+type UUIDError struct {
+	Device string
+	Err    error
+}
+
+func (e *UUIDError) Error() string {
+	return fmt.Sprintf("failed to read filesystem UUID of %s: %v", e.Device, e.Err)
+}
+
+func (e *UUIDError) Unwrap() error {
+	return e.Err
+}
+
+// readFilesystemUUID reads the filesystem UUID from the superblock of device
+// Supports ext2/3/4, btrfs, xfs, FAT (vfat) and swap, formatted the way blkid does
+func readFilesystemUUID(device string) (string, error) {
+	f, err := os.Open(device)
+	if err != nil {
+		return "", &UUIDError{Device: device, Err: err}
+	}
+	defer func() { _ = f.Close() }()
+
+	uuid, err := probeFilesystemUUID(f)
+	if err != nil {
+		return "", &UUIDError{Device: device, Err: err}
+	}
+	return uuid, nil
+}
+
+// probeFilesystemUUID checks each supported superblock layout in r
+func probeFilesystemUUID(r io.ReaderAt) (string, error) {
+	_, uuid, err := probeFilesystem(r)
+	return uuid, err
+}
+
+// readFilesystemType returns the type of the filesystem on device, named
+// like blkid does (ext4, btrfs, xfs, vfat, swap)
+func readFilesystemType(device string) (string, error) {
+	f, err := os.Open(device)
+	if err != nil {
+		return "", fmt.Errorf("failed to read filesystem type of %s: %w", device, err)
+	}
+	defer func() { _ = f.Close() }()
+
+	fsType, _, err := probeFilesystem(f)
+	if err != nil {
+		return "", fmt.Errorf("failed to read filesystem type of %s: %w", device, err)
+	}
+	return fsType, nil
+}
+
+// probeFilesystem returns the filesystem type and UUID of the superblock in r
+func probeFilesystem(r io.ReaderAt) (string, string, error) {
+	// ext2/3/4: superblock at 1024, magic 0xEF53 at +0x38, UUID at +0x68
+	if sb, ok := readAt(r, 1024, 0x78); ok && binary.LittleEndian.Uint16(sb[0x38:]) == 0xEF53 {
+		return "ext4", formatUUID(sb[0x68:0x78]), nil
+	}
+
+	// btrfs: superblock at 64KiB, magic at +0x40, fsid at +0x20
+	if sb, ok := readAt(r, 0x10000, 0x48); ok && string(sb[0x40:0x48]) == "_BHRfS_M" {
+		return "btrfs", formatUUID(sb[0x20:0x30]), nil
+	}
+
+	// xfs: superblock at 0, magic "XFSB", UUID at +0x20
+	if sb, ok := readAt(r, 0, 0x30); ok && string(sb[0:4]) == "XFSB" {
+		return "xfs", formatUUID(sb[0x20:0x30]), nil
+	}
+
+	// FAT: boot sector signature 0x55AA, volume ID location depends on FAT type
+	if bs, ok := readAt(r, 0, 512); ok && bs[510] == 0x55 && bs[511] == 0xAA {
+		switch {
+		case bytes.HasPrefix(bs[0x52:], []byte("FAT32")):
+			return "vfat", formatFATVolumeID(bs[0x43:0x47]), nil
+		case bytes.HasPrefix(bs[0x36:], []byte("FAT")):
+			return "vfat", formatFATVolumeID(bs[0x27:0x2B]), nil
+		}
+	}
+
+	// swap: signature at the end of the first page, UUID at +0x40C; the page
+	// size of the system that ran mkswap is not recorded, so try each
+	for _, pageSize := range []int64{4096, 8192, 16384, 65536} {
+		if sig, ok := readAt(r, pageSize-10, 10); ok && string(sig) == "SWAPSPACE2" {
+			if hdr, ok := readAt(r, 0x40C, 16); ok {
+				return "swap", formatUUID(hdr), nil
+			}
+		}
+	}
+
+	return "", "", ErrUnknownFilesystem
+}
+
+// readFilesystemLabel returns the label of the filesystem on device, or ""
+// if it has none. Supports the filesystems readFilesystemUUID does.
+func readFilesystemLabel(device string) (string, error) {
+	f, err := os.Open(device)
+	if err != nil {
+		return "", fmt.Errorf("failed to read filesystem label of %s: %w", device, err)
+	}
+	defer func() { _ = f.Close() }()
+
+	label, err := probeFilesystemLabel(f)
+	if err != nil {
+		return "", fmt.Errorf("failed to read filesystem label of %s: %w", device, err)
+	}
+	return label, nil
+}
+
+// probeFilesystemLabel returns the label of the superblock in r, at the
+// place of each layout probeFilesystem knows
+func probeFilesystemLabel(r io.ReaderAt) (string, error) {
+	fsType, _, err := probeFilesystem(r)
+	if err != nil {
+		return "", err
+	}
+	var label []byte
+	switch fsType {
+	case "ext4":
+		label, _ = readAt(r, 1024+0x78, 16)
+	case "btrfs":
+		label, _ = readAt(r, 0x10000+0x12B, 256)
+	case "xfs":
+		label, _ = readAt(r, 0x6C, 12)
+	case "vfat":
+		// Volume label of the extended boot record, padded with spaces
+		if bs, ok := readAt(r, 0, 512); ok {
+			if bytes.HasPrefix(bs[0x52:], []byte("FAT32")) {
+				label = bytes.TrimRight(bs[0x47:0x52], " ")
+			} else {
+				label = bytes.TrimRight(bs[0x2B:0x36], " ")
+			}
+			if string(label) == "NO NAME" {
+				label = nil
+			}
+		}
+	case "swap":
+		label, _ = readAt(r, 0x41C, 16)
+	}
+	if i := bytes.IndexByte(label, 0); i >= 0 {
+		label = label[:i]
+	}
+	return string(label), nil
+}
+
+// readAt reads n bytes at off, reporting false on short reads
+func readAt(r io.ReaderAt, off int64, n int) ([]byte, bool) {
+	buf := make([]byte, n)
+	if _, err := r.ReadAt(buf, off); err != nil {
+		return nil, false
+	}
+	return buf, true
+}
+
+// formatUUID formats 16 bytes as a lowercase RFC 4122 UUID string
+func formatUUID(b []byte) string {
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
+
+// formatFATVolumeID formats a little-endian FAT volume ID as XXXX-XXXX
+func formatFATVolumeID(b []byte) string {
+	id := binary.LittleEndian.Uint32(b)
+	return fmt.Sprintf("%04X-%04X", id>>16, id&0xFFFF)
+}
+
+// lookupDeviceByUUID finds the block device holding the filesystem with uuid.
+// udev's /dev/disk/by-uuid links are used when present; otherwise every block
+// device is probed, so this also works in minimal environments without udev.
+func lookupDeviceByUUID(uuid string) (string, error) {
+	if resolved, err := filepath.EvalSymlinks(filepath.Join(devDiskByUUIDPath, uuid)); err == nil {
+		return resolved, nil
+	}
+
+	entries, err := os.ReadDir(sysClassBlockPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to list block devices: %w", err)
+	}
+	for _, entry := range entries {
+		device := filepath.Join(devPath, entry.Name())
+		found, err := readFilesystemUUID(device)
+		if err != nil {
+			continue
+		}
+		if strings.EqualFold(found, uuid) {
+			return device, nil
+		}
+	}
+
+	return "", fmt.Errorf("%w: %s", ErrUUIDNotFound, uuid)
+}
@@ -0,0 +1,263 @@
+package pkg
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// fakeSuperblock builds a zeroed image of size bytes with fn applied
+func fakeSuperblock(size int, fn func(b []byte)) *bytes.Reader {
+	b := make([]byte, size)
+	fn(b)
+	return bytes.NewReader(b)
+}
+
+var testUUIDBytes = []byte{
+	0x12, 0x34, 0x56, 0x78, 0x9a, 0xbc, 0xde, 0xf0,
+	0x01, 0x23, 0x45, 0x67, 0x89, 0xab, 0xcd, 0xef,
+}
+
+const testUUID = "12345678-9abc-def0-0123-456789abcdef"
+
+func TestProbeFilesystemUUID(t *testing.T) {
+	tests := []struct {
+		name    string
+		image   *bytes.Reader
+		want    string
+		fsType  string
+		wantErr error
+	}{
+		{
+			name: "ext4",
+			image: fakeSuperblock(4096, func(b []byte) {
+				binary.LittleEndian.PutUint16(b[1024+0x38:], 0xEF53)
+				copy(b[1024+0x68:], testUUIDBytes)
+			}),
+			want:   testUUID,
+			fsType: "ext4",
+		},
+		{
+			name: "btrfs",
+			image: fakeSuperblock(0x11000, func(b []byte) {
+				copy(b[0x10000+0x40:], "_BHRfS_M")
+				copy(b[0x10000+0x20:], testUUIDBytes)
+			}),
+			want:   testUUID,
+			fsType: "btrfs",
+		},
+		{
+			name: "xfs",
+			image: fakeSuperblock(4096, func(b []byte) {
+				copy(b, "XFSB")
+				copy(b[0x20:], testUUIDBytes)
+			}),
+			want:   testUUID,
+			fsType: "xfs",
+		},
+		{
+			name: "vfat FAT32",
+			image: fakeSuperblock(4096, func(b []byte) {
+				copy(b[0x52:], "FAT32   ")
+				binary.LittleEndian.PutUint32(b[0x43:], 0x1A2B3C4D)
+				b[510], b[511] = 0x55, 0xAA
+			}),
+			want:   "1A2B-3C4D",
+			fsType: "vfat",
+		},
+		{
+			name: "vfat FAT16",
+			image: fakeSuperblock(4096, func(b []byte) {
+				copy(b[0x36:], "FAT16   ")
+				binary.LittleEndian.PutUint32(b[0x27:], 0x00C0FFEE)
+				b[510], b[511] = 0x55, 0xAA
+			}),
+			want:   "00C0-FFEE",
+			fsType: "vfat",
+		},
+		{
+			name: "swap",
+			image: fakeSuperblock(0x11000, func(b []byte) {
+				copy(b[16384-10:], "SWAPSPACE2")
+				copy(b[0x40C:], testUUIDBytes)
+			}),
+			want:   testUUID,
+			fsType: "swap",
+		},
+		{
+			name:    "unknown",
+			image:   fakeSuperblock(0x11000, func(b []byte) {}),
+			wantErr: ErrUnknownFilesystem,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fsType, got, err := probeFilesystem(tt.image)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Errorf("probeFilesystem() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("probeFilesystem() error = %v", err)
+			}
+			if got != tt.want || fsType != tt.fsType {
+				t.Errorf("probeFilesystem() = %q, %q, want %q, %q", fsType, got, tt.fsType, tt.want)
+			}
+		})
+	}
+}
+
+func TestProbeFilesystemLabel(t *testing.T) {
+	tests := []struct {
+		name  string
+		image *bytes.Reader
+		want  string
+	}{
+		{
+			name: "ext4",
+			image: fakeSuperblock(4096, func(b []byte) {
+				binary.LittleEndian.PutUint16(b[1024+0x38:], 0xEF53)
+				copy(b[1024+0x78:], "var")
+			}),
+			want: "var",
+		},
+		{
+			name: "btrfs",
+			image: fakeSuperblock(0x11000, func(b []byte) {
+				copy(b[0x10000+0x40:], "_BHRfS_M")
+				copy(b[0x10000+0x12B:], "root2")
+			}),
+			want: "root2",
+		},
+		{
+			name: "vfat FAT32",
+			image: fakeSuperblock(4096, func(b []byte) {
+				copy(b[0x52:], "FAT32   ")
+				copy(b[0x47:], "UEFI       ")
+				b[510], b[511] = 0x55, 0xAA
+			}),
+			want: "UEFI",
+		},
+		{
+			name: "swap",
+			image: fakeSuperblock(0x11000, func(b []byte) {
+				copy(b[4096-10:], "SWAPSPACE2")
+				copy(b[0x41C:], "swap")
+			}),
+			want: "swap",
+		},
+		{
+			name: "ext4 without label",
+			image: fakeSuperblock(4096, func(b []byte) {
+				binary.LittleEndian.PutUint16(b[1024+0x38:], 0xEF53)
+			}),
+			want: "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := probeFilesystemLabel(tt.image)
+			if err != nil {
+				t.Fatalf("probeFilesystemLabel() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("probeFilesystemLabel() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadFilesystemUUIDMatchesBlkid(t *testing.T) {
+	for _, tool := range []string{"mkfs.ext4", "blkid"} {
+		if _, err := exec.LookPath(tool); err != nil {
+			t.Skipf("%s not available", tool)
+		}
+	}
+
+	image := filepath.Join(t.TempDir(), "ext4.img")
+	if err := os.WriteFile(image, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Truncate(image, 8*1024*1024); err != nil {
+		t.Fatal(err)
+	}
+	if out, err := exec.Command("mkfs.ext4", "-q", "-F", image).CombinedOutput(); err != nil {
+		t.Fatalf("mkfs.ext4 failed: %v\n%s", err, out)
+	}
+	out, err := exec.Command("blkid", "-s", "UUID", "-o", "value", image).Output()
+	if err != nil {
+		t.Skipf("blkid cannot probe image files here: %v", err)
+	}
+
+	got, err := readFilesystemUUID(image)
+	if err != nil {
+		t.Fatalf("readFilesystemUUID() error = %v", err)
+	}
+	if want := strings.TrimSpace(string(out)); got != want {
+		t.Errorf("readFilesystemUUID() = %q, blkid says %q", got, want)
+	}
+}
+
+func TestReadFilesystemUUIDTypedError(t *testing.T) {
+	_, err := readFilesystemUUID(filepath.Join(t.TempDir(), "missing"))
+	var uuidErr *UUIDError
+	if !errors.As(err, &uuidErr) {
+		t.Fatalf("readFilesystemUUID() error = %v, want *UUIDError", err)
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("readFilesystemUUID() error %v does not wrap os.ErrNotExist", err)
+	}
+}
+
+func TestLookupDeviceByUUID(t *testing.T) {
+	root := t.TempDir()
+	oldByUUID, oldClass, oldDev := devDiskByUUIDPath, sysClassBlockPath, devPath
+	devDiskByUUIDPath = filepath.Join(root, "by-uuid")
+	sysClassBlockPath = filepath.Join(root, "class")
+	devPath = filepath.Join(root, "dev")
+	t.Cleanup(func() { devDiskByUUIDPath, sysClassBlockPath, devPath = oldByUUID, oldClass, oldDev })
+
+	// A fake "device" with an ext4 superblock and no by-uuid link
+	b := make([]byte, 4096)
+	binary.LittleEndian.PutUint16(b[1024+0x38:], 0xEF53)
+	copy(b[1024+0x68:], testUUIDBytes)
+	writeFakeFile(t, filepath.Join(devPath, "vdb2"), string(b))
+	writeFakeFile(t, filepath.Join(devPath, "vdb1"), "not a filesystem")
+	for _, name := range []string{"vdb1", "vdb2"} {
+		if err := os.MkdirAll(filepath.Join(sysClassBlockPath, name), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got, err := lookupDeviceByUUID(strings.ToUpper(testUUID))
+	if err != nil {
+		t.Fatalf("lookupDeviceByUUID() error = %v", err)
+	}
+	if want := filepath.Join(devPath, "vdb2"); got != want {
+		t.Errorf("lookupDeviceByUUID() = %q, want %q", got, want)
+	}
+
+	if _, err := lookupDeviceByUUID("00000000-0000-0000-0000-000000000000"); !errors.Is(err, ErrUUIDNotFound) {
+		t.Errorf("lookupDeviceByUUID() error = %v, want ErrUUIDNotFound", err)
+	}
+
+	// by-uuid symlinks take precedence
+	if err := os.MkdirAll(devDiskByUUIDPath, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(filepath.Join(devPath, "vdb1"), filepath.Join(devDiskByUUIDPath, "abcd-1234")); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := lookupDeviceByUUID("abcd-1234"); err != nil || got != filepath.Join(devPath, "vdb1") {
+		t.Errorf("lookupDeviceByUUID(by-uuid) = %q, %v", got, err)
+	}
+}
@@ -0,0 +1,289 @@
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"unsafe"
+
+	diskfs "github.com/diskfs/go-diskfs"
+	"github.com/diskfs/go-diskfs/partition/gpt"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// gptAlignment is the partition alignment in bytes (1 MiB, matching sgdisk defaults)
+	gptAlignment = 1024 * 1024
+	// gptEntriesBytes is the size of the partition entry array (128 entries of 128 bytes)
+	gptEntriesBytes = 128 * 128
+	// blkpg is the BLKPG ioctl request number (not exported by x/sys/unix)
+	blkpg = 0x1269
+)
+
+// GPTPartitionSpec describes a partition to create in a new GPT partition table
+type GPTPartitionSpec struct {
+	Name string
+	Type gpt.Type
+	Size uint64 // Size in bytes, 0 means use the space left by the other partitions
+}
+
+// defaultGPTLayout returns the phukit partition layout
+// Partition 1: Boot/EFI System Partition (2GB, FAT32) - holds EFI binaries + kernel/initramfs
+// Partition 2: First root filesystem (12GB)
+// Partition 3: Second root filesystem (12GB)
+// Partition 4: /var partition (remaining space)
+func defaultGPTLayout() []GPTPartitionSpec {
+	const gib = 1024 * 1024 * 1024
+	return []GPTPartitionSpec{
+		// Single partition serves as both ESP and boot - holds EFI binaries + kernel/initramfs
+		{Name: "boot", Type: gpt.EFISystemPartition, Size: 2 * gib},
+		// NOT using discoverable root partition type - root specified via kernel cmdline
+		{Name: "root1", Type: gpt.LinuxFilesystem, Size: 12 * gib},
+		// NOT using discoverable root partition type - allows A/B updates with explicit control
+		{Name: "root2", Type: gpt.LinuxFilesystem, Size: 12 * gib},
+		// NOT using auto-discoverable var type (4d21b016...) - would require machine-id binding
+		{Name: "var", Type: gpt.LinuxFilesystem, Size: 0},
+	}
+}
+
+// verityGPTLayout returns the phukit partition layout with a dm-verity hash
+// tree partition for each root after /var
+// Partition 5: Hash tree of root1
+// Partition 6: Hash tree of root2
+func verityGPTLayout() []GPTPartitionSpec {
+	// NOT using the discoverable root-verity types (arch-specific) - the hash
+	// device is named on the kernel cmdline like the root
+	return append(defaultGPTLayout(),
+		GPTPartitionSpec{Name: "root1-verity", Type: gpt.LinuxFilesystem, Size: VerityHashPartitionSize},
+		GPTPartitionSpec{Name: "root2-verity", Type: gpt.LinuxFilesystem, Size: VerityHashPartitionSize},
+	)
+}
+
+// installGPTLayout returns the partition layout of an install, with a swap
+// partition of swapSize bytes after the others if swapSize is not 0, so the
+// root and /var partitions keep their numbers
+func installGPTLayout(verity bool, swapSize uint64) []GPTPartitionSpec {
+	layout := defaultGPTLayout()
+	if verity {
+		layout = verityGPTLayout()
+	}
+	if swapSize > 0 {
+		layout = append(layout, GPTPartitionSpec{Name: "swap", Type: gpt.LinuxSwap, Size: swapSize})
+	}
+	return layout
+}
+
+// buildGPTTable lays out the given partitions on a disk of diskSize bytes,
+// aligning each partition start to 1 MiB
+func buildGPTTable(diskSize int64, logicalSectorSize, physicalSectorSize int, specs []GPTPartitionSpec) (*gpt.Table, error) {
+	if logicalSectorSize <= 0 {
+		logicalSectorSize = 512
+	}
+	if physicalSectorSize <= 0 {
+		physicalSectorSize = logicalSectorSize
+	}
+
+	sector := uint64(logicalSectorSize)
+	totalSectors := uint64(diskSize) / sector
+	entrySectors := uint64(gptEntriesBytes) / sector
+	alignSectors := uint64(gptAlignment) / sector
+
+	// Last usable sector leaves room for the backup entry array and header
+	if totalSectors < 2*(entrySectors+2) {
+		return nil, fmt.Errorf("%w: disk too small for GPT: %d bytes", ErrInsufficientSpace, diskSize)
+	}
+	lastUsable := totalSectors - entrySectors - 2
+
+	table := &gpt.Table{
+		LogicalSectorSize:  logicalSectorSize,
+		PhysicalSectorSize: physicalSectorSize,
+		ProtectiveMBR:      true,
+	}
+
+	start := alignSectors
+	for i, spec := range specs {
+		if start > lastUsable {
+			return nil, fmt.Errorf("%w: not enough space for partition %d (%s)", ErrInsufficientSpace, i+1, spec.Name)
+		}
+
+		var end uint64
+		if spec.Size == 0 {
+			// Leave room for the aligned partitions that follow
+			var reserved uint64
+			for _, later := range specs[i+1:] {
+				reserved += (later.Size/sector + alignSectors - 1) / alignSectors * alignSectors
+			}
+			if reserved >= lastUsable+1-start {
+				return nil, fmt.Errorf("%w: not enough space for partition %d (%s)", ErrInsufficientSpace, i+1, spec.Name)
+			}
+			end = lastUsable
+			if reserved > 0 {
+				end = (lastUsable+1-reserved)/alignSectors*alignSectors - 1
+			}
+			if end < start {
+				return nil, fmt.Errorf("%w: not enough space for partition %d (%s)", ErrInsufficientSpace, i+1, spec.Name)
+			}
+		} else {
+			end = start + spec.Size/sector - 1
+			if end > lastUsable {
+				return nil, fmt.Errorf("%w: not enough space for partition %d (%s): need %s", ErrInsufficientSpace, i+1, spec.Name, FormatSize(spec.Size))
+			}
+		}
+
+		table.Partitions = append(table.Partitions, &gpt.Partition{
+			Start: start,
+			End:   end,
+			Type:  spec.Type,
+			Name:  spec.Name,
+		})
+
+		// Next partition starts at the following alignment boundary
+		start = ((end + 1 + alignSectors - 1) / alignSectors) * alignSectors
+	}
+
+	return table, nil
+}
+
+// writeGPTPartitionTable writes a new GPT partition table to device and asks the
+// kernel to re-read it, without relying on sgdisk or partprobe. The
+// partitions of a device-mapper disk are mapped with kpartx instead.
+func writeGPTPartitionTable(ctx context.Context, device string, specs []GPTPartitionSpec) (*gpt.Table, error) {
+	disk, err := diskfs.Open(device, diskfs.WithOpenMode(diskfs.ReadWriteExclusive))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", device, err)
+	}
+	defer func() { _ = disk.Close() }()
+
+	table, err := buildGPTTable(disk.Size, int(disk.LogicalBlocksize), int(disk.PhysicalBlocksize), specs)
+	if err != nil {
+		return nil, err
+	}
+
+	// Partition writes the table and re-reads it with BLKRRPART for block devices
+	dm := isDeviceMapper(device)
+	if err := disk.Partition(table); err != nil {
+		if !strings.Contains(err.Error(), "re-read the partition table") {
+			return nil, fmt.Errorf("failed to write partition table: %w", err)
+		}
+		if dm {
+			return table, mapDMPartitions(ctx, device)
+		}
+
+		// BLKRRPART fails when the disk is busy; add partitions individually instead
+		if werr := warnf("%v, falling back to BLKPG", err); werr != nil {
+			return nil, werr
+		}
+		if err := addPartitionsBLKPG(device, table); err != nil {
+			return nil, fmt.Errorf("failed to inform kernel of new partitions: %w", err)
+		}
+	}
+	if dm {
+		return table, mapDMPartitions(ctx, device)
+	}
+
+	return table, nil
+}
+
+// readGPTPartitionTable reads the GPT partition table of device
+func readGPTPartitionTable(device string) (*gpt.Table, error) {
+	disk, err := diskfs.Open(device, diskfs.WithOpenMode(diskfs.ReadOnly))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", device, err)
+	}
+	defer func() { _ = disk.Close() }()
+
+	pt, err := disk.GetPartitionTable()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read partition table of %s: %w", device, err)
+	}
+	table, ok := pt.(*gpt.Table)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s has no GPT partition table", ErrPartitionSchemeMismatch, device)
+	}
+	return table, nil
+}
+
+// addPartitionsBLKPG registers each partition of table with the kernel using BLKPG ioctls
+func addPartitionsBLKPG(device string, table *gpt.Table) error {
+	f, err := os.OpenFile(device, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = f.Close() }()
+
+	sector := int64(table.LogicalSectorSize)
+	for i, p := range table.Partitions {
+		part := unix.BlkpgPartition{
+			Start:  int64(p.Start) * sector,
+			Length: int64(p.End-p.Start+1) * sector,
+			Pno:    int32(i + 1),
+		}
+
+		// Remove any stale kernel partition with the same number first
+		del := part
+		_ = blkpgIoctl(f.Fd(), unix.BLKPG_DEL_PARTITION, &del)
+
+		if err := blkpgIoctl(f.Fd(), unix.BLKPG_ADD_PARTITION, &part); err != nil {
+			return fmt.Errorf("BLKPG add partition %d: %w", i+1, err)
+		}
+	}
+	return nil
+}
+
+// blkpgIoctl issues a BLKPG ioctl for a single partition
+func blkpgIoctl(fd uintptr, op int32, part *unix.BlkpgPartition) error {
+	arg := unix.BlkpgIoctlArg{
+		Op:      op,
+		Datalen: int32(unsafe.Sizeof(*part)),
+		Data:    (*byte)(unsafe.Pointer(part)),
+	}
+	_, _, errno := unix.Syscall(unix.SYS_IOCTL, fd, blkpg, uintptr(unsafe.Pointer(&arg)))
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// zapGPT destroys the primary and backup GPT structures and the protective MBR
+// by zeroing the first and last MiB of the device
+func zapGPT(device string) error {
+	f, err := os.OpenFile(device, os.O_RDWR, 0)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", device, err)
+	}
+	defer func() { _ = f.Close() }()
+
+	size, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return fmt.Errorf("failed to determine size of %s: %w", device, err)
+	}
+
+	zeros := make([]byte, gptAlignment)
+	if size < int64(len(zeros)) {
+		zeros = zeros[:size]
+	}
+	if _, err := f.WriteAt(zeros, 0); err != nil {
+		return fmt.Errorf("failed to clear primary GPT: %w", err)
+	}
+	if _, err := f.WriteAt(zeros, size-int64(len(zeros))); err != nil {
+		return fmt.Errorf("failed to clear backup GPT: %w", err)
+	}
+	return f.Sync()
+}
+
+// partitionDevicePath returns the device node for partition number n of device
+// nvme, mmcblk, and loop devices use "p" prefix for partitions; the partitions
+// of a device-mapper disk are looked up in sysfs
+func partitionDevicePath(device string, n int) string {
+	if part := dmPartitionPath(device, n); part != "" {
+		return part
+	}
+	deviceBase := filepath.Base(device)
+	if strings.HasPrefix(deviceBase, "nvme") || strings.HasPrefix(deviceBase, "mmcblk") || strings.HasPrefix(deviceBase, "loop") {
+		return fmt.Sprintf("%sp%d", device, n)
+	}
+	return fmt.Sprintf("%s%d", device, n)
+}
@@ -0,0 +1,142 @@
+package pkg
+
+import (
+	"testing"
+
+	"github.com/diskfs/go-diskfs/partition/gpt"
+)
+
+func TestBuildGPTTable(t *testing.T) {
+	const gib = 1024 * 1024 * 1024
+
+	t.Run("default layout is 1MiB aligned", func(t *testing.T) {
+		table, err := buildGPTTable(64*gib, 512, 512, defaultGPTLayout())
+		if err != nil {
+			t.Fatalf("buildGPTTable() error = %v", err)
+		}
+		if len(table.Partitions) != 4 {
+			t.Fatalf("got %d partitions, want 4", len(table.Partitions))
+		}
+
+		alignSectors := uint64(gptAlignment / 512)
+		for i, p := range table.Partitions {
+			if p.Start%alignSectors != 0 {
+				t.Errorf("partition %d start %d is not 1MiB aligned", i+1, p.Start)
+			}
+			if i > 0 && p.Start <= table.Partitions[i-1].End {
+				t.Errorf("partition %d overlaps partition %d", i+1, i)
+			}
+		}
+
+		if got := table.Partitions[0].Type; got != gpt.EFISystemPartition {
+			t.Errorf("boot partition type = %s, want %s", got, gpt.EFISystemPartition)
+		}
+		if got := (table.Partitions[0].End - table.Partitions[0].Start + 1) * 512; got != 2*gib {
+			t.Errorf("boot partition size = %d, want %d", got, uint64(2*gib))
+		}
+		if got := (table.Partitions[1].End - table.Partitions[1].Start + 1) * 512; got != 12*gib {
+			t.Errorf("root1 partition size = %d, want %d", got, uint64(12*gib))
+		}
+
+		totalSectors := uint64(64 * gib / 512)
+		lastUsable := totalSectors - gptEntriesBytes/512 - 2
+		if got := table.Partitions[3].End; got != lastUsable {
+			t.Errorf("var partition end = %d, want %d", got, lastUsable)
+		}
+	})
+
+	t.Run("4K sectors", func(t *testing.T) {
+		table, err := buildGPTTable(64*gib, 4096, 4096, defaultGPTLayout())
+		if err != nil {
+			t.Fatalf("buildGPTTable() error = %v", err)
+		}
+		if got := (table.Partitions[0].End - table.Partitions[0].Start + 1) * 4096; got != 2*gib {
+			t.Errorf("boot partition size = %d, want %d", got, uint64(2*gib))
+		}
+	})
+
+	t.Run("verity layout puts hash partitions after var", func(t *testing.T) {
+		table, err := buildGPTTable(64*gib, 512, 512, verityGPTLayout())
+		if err != nil {
+			t.Fatalf("buildGPTTable() error = %v", err)
+		}
+		if len(table.Partitions) != 6 {
+			t.Fatalf("got %d partitions, want 6", len(table.Partitions))
+		}
+
+		alignSectors := uint64(gptAlignment / 512)
+		for i, p := range table.Partitions {
+			if p.Start%alignSectors != 0 {
+				t.Errorf("partition %d start %d is not 1MiB aligned", i+1, p.Start)
+			}
+			if i > 0 && p.Start <= table.Partitions[i-1].End {
+				t.Errorf("partition %d overlaps partition %d", i+1, i)
+			}
+		}
+		for _, p := range table.Partitions[4:] {
+			if got := (p.End - p.Start + 1) * 512; got != VerityHashPartitionSize {
+				t.Errorf("%s size = %d, want %d", p.Name, got, VerityHashPartitionSize)
+			}
+		}
+
+		totalSectors := uint64(64 * gib / 512)
+		lastUsable := totalSectors - gptEntriesBytes/512 - 2
+		if got := table.Partitions[5].End; got > lastUsable {
+			t.Errorf("root2-verity end = %d, past last usable sector %d", got, lastUsable)
+		}
+	})
+
+	t.Run("swap partition follows the others", func(t *testing.T) {
+		for _, verity := range []bool{false, true} {
+			table, err := buildGPTTable(64*gib, 512, 512, installGPTLayout(verity, 8*gib))
+			if err != nil {
+				t.Fatalf("buildGPTTable() error = %v", err)
+			}
+			want := 5
+			if verity {
+				want = 7
+			}
+			if len(table.Partitions) != want {
+				t.Fatalf("verity=%v: got %d partitions, want %d", verity, len(table.Partitions), want)
+			}
+			swap := table.Partitions[want-1]
+			if swap.Name != "swap" || swap.Type != gpt.LinuxSwap {
+				t.Errorf("verity=%v: last partition = %s (%s), want swap", verity, swap.Name, swap.Type)
+			}
+			if got := (swap.End - swap.Start + 1) * 512; got != 8*gib {
+				t.Errorf("verity=%v: swap size = %d, want %d", verity, got, uint64(8*gib))
+			}
+			if table.Partitions[3].Name != "var" {
+				t.Errorf("verity=%v: partition 4 = %s, want var", verity, table.Partitions[3].Name)
+			}
+		}
+	})
+
+	t.Run("disk too small", func(t *testing.T) {
+		if _, err := buildGPTTable(20*gib, 512, 512, defaultGPTLayout()); err == nil {
+			t.Error("buildGPTTable() expected error for 20GiB disk")
+		}
+	})
+}
+
+func TestPartitionDevicePath(t *testing.T) {
+	tests := []struct {
+		device string
+		n      int
+		want   string
+	}{
+		{"/dev/sda", 1, "/dev/sda1"},
+		{"/dev/vdb", 4, "/dev/vdb4"},
+		{"/dev/nvme0n1", 2, "/dev/nvme0n1p2"},
+		{"/dev/mmcblk0", 3, "/dev/mmcblk0p3"},
+		{"/dev/loop7", 1, "/dev/loop7p1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.want, func(t *testing.T) {
+			if got := partitionDevicePath(tt.device, tt.n); got != tt.want {
+				t.Errorf("partitionDevicePath(%q, %d) = %q, want %q", tt.device, tt.n, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,60 @@
+package pkg
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Growing /var
+//
+// A disk image is smaller than most disks it is written to. With GrowVar
+// the installed root carries systemd-repart definitions for the existing
+// partitions, so on boot repart grows /var, the last partition, into the
+// rest of the disk, and systemd-growfs then grows its filesystem. The root
+// filesystems are grown into their partitions the same way, for partitions
+// that were enlarged after the image was written; the A/B roots themselves
+// keep their size. All of it does nothing once the filesystems fill their
+// partitions. The files are in /etc, which updates carry over.
+
+// growVarRepartDefinitions match the partitions of the default layout in
+// order. Existing partitions are never reformatted; only a partition with
+// free space after it is grown.
+var growVarRepartDefinitions = []struct{ name, content string }{
+	{"10-phukit-boot.conf", "[Partition]\nType=esp\n"},
+	{"20-phukit-root1.conf", "[Partition]\nType=linux-generic\n"},
+	{"30-phukit-root2.conf", "[Partition]\nType=linux-generic\n"},
+	{"40-phukit-var.conf", "[Partition]\nType=linux-generic\n"},
+}
+
+// growfsUnits are the systemd-growfs instances that grow the root and /var
+// filesystems into their partitions on boot
+var growfsUnits = []string{"systemd-growfs@-.service", "systemd-growfs@var.service"}
+
+// writeGrowVarConfig configures the root at root to grow /var into the rest
+// of the disk on boot
+func writeGrowVarConfig(root string) error {
+	fmt.Println("  Configuring /var to grow into the rest of the disk on boot...")
+	repartDir := filepath.Join(root, "etc", "repart.d")
+	if err := os.MkdirAll(repartDir, 0755); err != nil {
+		return fmt.Errorf("failed to create /etc/repart.d: %w", err)
+	}
+	for _, def := range growVarRepartDefinitions {
+		if err := os.WriteFile(filepath.Join(repartDir, def.name), []byte(def.content), 0644); err != nil {
+			return fmt.Errorf("failed to write %s: %w", def.name, err)
+		}
+	}
+
+	wants := filepath.Join(root, "etc", "systemd", "system", "local-fs.target.wants")
+	if err := os.MkdirAll(wants, 0755); err != nil {
+		return fmt.Errorf("failed to enable systemd-growfs: %w", err)
+	}
+	for _, unit := range growfsUnits {
+		link := filepath.Join(wants, unit)
+		_ = os.Remove(link)
+		if err := os.Symlink("/usr/lib/systemd/system/systemd-growfs@.service", link); err != nil {
+			return fmt.Errorf("failed to enable %s: %w", unit, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,34 @@
+package pkg
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriteGrowVarConfig(t *testing.T) {
+	root := t.TempDir()
+	if err := writeGrowVarConfig(root); err != nil {
+		t.Fatalf("writeGrowVarConfig() error = %v", err)
+	}
+
+	for _, def := range growVarRepartDefinitions {
+		data, err := os.ReadFile(filepath.Join(root, "etc", "repart.d", def.name))
+		if err != nil || string(data) != def.content {
+			t.Errorf("repart definition %s = %q, %v, want %q", def.name, data, err, def.content)
+		}
+	}
+
+	wants := filepath.Join(root, "etc", "systemd", "system", "local-fs.target.wants")
+	for _, unit := range []string{"systemd-growfs@-.service", "systemd-growfs@var.service"} {
+		target, err := os.Readlink(filepath.Join(wants, unit))
+		if err != nil || target != "/usr/lib/systemd/system/systemd-growfs@.service" {
+			t.Errorf("%s links to %q, %v", unit, target, err)
+		}
+	}
+
+	// Writing the configuration again replaces the links
+	if err := writeGrowVarConfig(root); err != nil {
+		t.Errorf("writeGrowVarConfig() again error = %v", err)
+	}
+}
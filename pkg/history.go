@@ -0,0 +1,156 @@
+package pkg
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"time"
+)
+
+// HistoryPath records every install, update and rollback of this machine. It
+// lives in /var so both roots share it.
+var HistoryPath = "/var/lib/phukit/history.json"
+
+// HistoryEntry is one operation in the update history
+type HistoryEntry struct {
+	Time            time.Time               `json:"time"` // When the operation started
+	Operation       string                  `json:"operation"`
+	Status          string                  `json:"status"`
+	ImageRef        string                  `json:"image_ref,omitempty"`
+	FromDigest      string                  `json:"from_digest,omitempty"` // Image that booted by default before
+	ToDigest        string                  `json:"to_digest,omitempty"`   // Image that boots by default after
+	FromVersion     string                  `json:"from_version,omitempty"`
+	ToVersion       string                  `json:"to_version,omitempty"`
+	TargetSlot      string                  `json:"target_slot,omitempty"`
+	DurationSeconds float64                 `json:"duration_seconds"`
+	Initiator       string                  `json:"initiator,omitempty"` // e.g. "cli (alice)", "dbus"
+	Error           string                  `json:"error,omitempty"`
+	ErrorCode       string                  `json:"error_code,omitempty"`
+	Signatures      []SignatureVerification `json:"signatures,omitempty"` // Verified signatures of the image
+}
+
+// RecordHistory appends the outcome of an update or rollback to the history.
+// Like RecordMetrics it ignores dry runs, other operations and declined
+// confirmations.
+func RecordHistory(r *OperationResult) error {
+	if r == nil || !r.changesSystem || r.ErrorCode == CodeAborted {
+		return nil
+	}
+	if r.Operation != OperationUpdate && r.Operation != OperationRollback {
+		return nil
+	}
+	return appendHistory(HistoryPath, historyEntry(r))
+}
+
+// ReadHistory returns the history, oldest first. It is empty before the
+// first operation.
+func ReadHistory() ([]HistoryEntry, error) {
+	return readHistory(HistoryPath)
+}
+
+// readHistory reads the history file at path
+func readHistory(path string) ([]HistoryEntry, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read history: %w", err)
+	}
+	var entries []HistoryEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("failed to parse history %s: %w", path, err)
+	}
+	return entries, nil
+}
+
+// appendHistory adds e to the history file at path
+func appendHistory(path string, e HistoryEntry) error {
+	entries, err := readHistory(path)
+	if err != nil {
+		return err
+	}
+	entries = append(entries, e)
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create history directory: %w", err)
+	}
+	data, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal history: %w", err)
+	}
+	if err := writeFileAtomic(path, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to write history: %w", err)
+	}
+	return nil
+}
+
+// historyEntry converts a result document to a history entry
+func historyEntry(r *OperationResult) HistoryEntry {
+	return HistoryEntry{
+		Time:            r.StartTime,
+		Operation:       r.Operation,
+		Status:          r.Status,
+		ImageRef:        r.ImageRef,
+		FromDigest:      r.PreviousImageDigest,
+		ToDigest:        r.ImageDigest,
+		FromVersion:     r.PreviousImageVersion,
+		ToVersion:       r.ImageVersion,
+		TargetSlot:      r.TargetSlot,
+		DurationSeconds: r.DurationSeconds,
+		Initiator:       r.Initiator,
+		Error:           r.Error,
+		ErrorCode:       r.ErrorCode,
+		Signatures:      r.Signatures,
+	}
+}
+
+// recordInstallHistory starts the history of the system installed at root
+// with the running install, which has finished writing the disk
+func recordInstallHistory(root string) error {
+	r := currentResult
+	if r == nil {
+		r = &OperationResult{Operation: OperationInstall, StartTime: time.Now()}
+	}
+	e := historyEntry(r)
+	e.Status = StatusSuccess
+	e.DurationSeconds = time.Since(r.StartTime).Seconds()
+	return appendHistory(filepath.Join(root, HistoryPath), e)
+}
+
+// initiatorKey is the context key of the initiator
+type initiatorKey struct{}
+
+// WithInitiator records who asked for the operations run with ctx, for the
+// history and the result document
+func WithInitiator(ctx context.Context, initiator string) context.Context {
+	return context.WithValue(ctx, initiatorKey{}, initiator)
+}
+
+// initiatorFrom returns the initiator set with WithInitiator, or ""
+func initiatorFrom(ctx context.Context) string {
+	initiator, _ := ctx.Value(initiatorKey{}).(string)
+	return initiator
+}
+
+// SetResultInitiator records who asked for the running operation
+func SetResultInitiator(initiator string) {
+	recordResult(func(r *OperationResult) { r.Initiator = initiator })
+}
+
+// CLIInitiator describes a command line user, e.g. "cli (alice)". With sudo
+// it names the user who ran sudo.
+func CLIInitiator() string {
+	name := os.Getenv("SUDO_USER")
+	if name == "" {
+		if u, err := user.Current(); err == nil {
+			name = u.Username
+		}
+	}
+	if name == "" {
+		return "cli"
+	}
+	return "cli (" + name + ")"
+}
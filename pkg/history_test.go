@@ -0,0 +1,120 @@
+package pkg
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func setupHistoryPath(t *testing.T) {
+	t.Helper()
+	old := HistoryPath
+	HistoryPath = filepath.Join(t.TempDir(), "var", "lib", "phukit", "history.json")
+	t.Cleanup(func() { HistoryPath = old })
+}
+
+func TestRecordHistory(t *testing.T) {
+	setupHistoryPath(t)
+	start := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	results := []*OperationResult{
+		// Not recorded: dry runs, declined confirmations and other operations
+		{Operation: OperationUpdate, Status: StatusSuccess, StartTime: start},
+		{Operation: OperationUpdate, Status: StatusFailed, ErrorCode: CodeAborted, StartTime: start, changesSystem: true},
+		{Operation: OperationInstall, Status: StatusSuccess, StartTime: start, changesSystem: true},
+		{
+			Operation: OperationUpdate, Status: StatusSuccess, StartTime: start,
+			ImageRef: "quay.io/example/os:latest", PreviousImageDigest: "sha256:old", ImageDigest: "sha256:new",
+			PreviousImageVersion: "42.0", ImageVersion: "42.1",
+			TargetSlot: "root2", DurationSeconds: 90, Initiator: "cli (alice)", changesSystem: true,
+		},
+		{
+			Operation: OperationRollback, Status: StatusFailed, StartTime: start.Add(time.Hour),
+			Error: "boom", ErrorCode: CodeUnknown, Initiator: "dbus", changesSystem: true,
+		},
+	}
+	for _, r := range results {
+		if err := RecordHistory(r); err != nil {
+			t.Fatalf("RecordHistory() error = %v", err)
+		}
+	}
+
+	entries, err := ReadHistory()
+	if err != nil {
+		t.Fatalf("ReadHistory() error = %v", err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("ReadHistory() returned %d entries, want 2: %+v", len(entries), entries)
+	}
+	update := entries[0]
+	if update.Operation != OperationUpdate || update.FromDigest != "sha256:old" || update.ToDigest != "sha256:new" ||
+		update.FromVersion != "42.0" || update.ToVersion != "42.1" ||
+		update.DurationSeconds != 90 || update.Initiator != "cli (alice)" || !update.Time.Equal(start) {
+		t.Errorf("update entry = %+v", update)
+	}
+	rollback := entries[1]
+	if rollback.Operation != OperationRollback || rollback.Status != StatusFailed || rollback.ErrorCode != CodeUnknown || rollback.Error != "boom" {
+		t.Errorf("rollback entry = %+v", rollback)
+	}
+}
+
+func TestReadHistory(t *testing.T) {
+	setupHistoryPath(t)
+
+	entries, err := ReadHistory()
+	if err != nil || entries != nil {
+		t.Errorf("ReadHistory() without history = %v, %v; want nil, nil", entries, err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(HistoryPath), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(HistoryPath, []byte("{not json"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadHistory(); err == nil {
+		t.Error("ReadHistory() of a corrupt history succeeded, want error")
+	}
+	if err := RecordHistory(&OperationResult{Operation: OperationRollback, changesSystem: true}); err == nil {
+		t.Error("RecordHistory() over a corrupt history succeeded, want error")
+	}
+}
+
+func TestRecordInstallHistory(t *testing.T) {
+	root := t.TempDir()
+	StartResult(OperationInstall)
+	t.Cleanup(func() { FinishResult(StatusSuccess, nil) })
+	SetResultInitiator("cli (root)")
+	recordResult(func(r *OperationResult) { r.ImageRef, r.ImageDigest = "quay.io/example/os:latest", "sha256:abc" })
+
+	if err := recordInstallHistory(root); err != nil {
+		t.Fatalf("recordInstallHistory() error = %v", err)
+	}
+	entries, err := readHistory(filepath.Join(root, HistoryPath))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("got %d entries, want 1", len(entries))
+	}
+	e := entries[0]
+	if e.Operation != OperationInstall || e.Status != StatusSuccess || e.ToDigest != "sha256:abc" || e.Initiator != "cli (root)" {
+		t.Errorf("install entry = %+v", e)
+	}
+}
+
+func TestInitiator(t *testing.T) {
+	ctx := t.Context()
+	if got := initiatorFrom(ctx); got != "" {
+		t.Errorf("initiatorFrom() without initiator = %q, want empty", got)
+	}
+	if got := initiatorFrom(WithInitiator(ctx, "dbus")); got != "dbus" {
+		t.Errorf("initiatorFrom() = %q, want dbus", got)
+	}
+
+	t.Setenv("SUDO_USER", "alice")
+	if got := CLIInitiator(); got != "cli (alice)" {
+		t.Errorf("CLIInitiator() with sudo = %q, want cli (alice)", got)
+	}
+}
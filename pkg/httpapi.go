@@ -0,0 +1,192 @@
+package pkg
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// httpCodeInvalidRequest is the error code of malformed HTTP API requests
+const httpCodeInvalidRequest = "invalid_request"
+
+// httpKeepAlive is how often the event stream sends a comment so proxies do
+// not close an idle connection
+const httpKeepAlive = 15 * time.Second
+
+// httpErrorStatus maps error codes to HTTP status codes; unlisted codes map
+// to 500
+var httpErrorStatus = map[string]int{
+	CodeOperationInProgress:   http.StatusConflict,
+	CodeDeviceBusy:            http.StatusConflict,
+	CodeSlotPinned:            http.StatusConflict,
+	CodeDeviceNotFound:        http.StatusNotFound,
+	CodeImageNotFound:         http.StatusNotFound,
+	CodeInvalidImageReference: http.StatusBadRequest,
+	CodeTimeout:               http.StatusGatewayTimeout,
+}
+
+// HTTPUpdateRequest is the body of POST /update
+type HTTPUpdateRequest struct {
+	ImageRef string `json:"image_ref,omitempty"` // Empty to update the installed image
+	Force    bool   `json:"force,omitempty"`
+}
+
+// HTTPError is the body of failed HTTP API requests
+type HTTPError struct {
+	Error     string `json:"error"`
+	ErrorCode string `json:"error_code"`
+}
+
+// NewHTTPHandler serves manager as a REST API. Every request must carry
+// "Authorization: Bearer <token>". ctx bounds the operations started through
+// the API and ends event streams when done.
+//
+//	GET  /status    SystemStatus as JSON
+//	POST /update    Start an update (HTTPUpdateRequest body, optional); 202
+//	POST /rollback  Roll back and return the result document
+//	GET  /events    Server-sent events, one per progress event
+func NewHTTPHandler(ctx context.Context, manager *Manager, token string) http.Handler {
+	api := &httpAPI{ctx: ctx, manager: manager}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /status", api.status)
+	mux.HandleFunc("POST /update", api.update)
+	mux.HandleFunc("POST /rollback", api.rollback)
+	mux.HandleFunc("GET /events", api.events)
+	return requireBearerToken(token, mux)
+}
+
+// ReadAPIToken reads the bearer token of the HTTP API from path
+func ReadAPIToken(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read API token: %w", err)
+	}
+	token := strings.TrimSpace(string(data))
+	if token == "" {
+		return "", fmt.Errorf("API token file %s is empty", path)
+	}
+	return token, nil
+}
+
+// requireBearerToken refuses requests that do not carry token
+func requireBearerToken(token string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="phukit"`)
+			writeJSON(w, http.StatusUnauthorized, HTTPError{Error: "missing or invalid bearer token", ErrorCode: "unauthorized"})
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// httpAPI holds the handlers of the HTTP API
+type httpAPI struct {
+	ctx     context.Context
+	manager *Manager
+}
+
+func (a *httpAPI) status(w http.ResponseWriter, r *http.Request) {
+	status, err := a.manager.Status()
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, status)
+}
+
+func (a *httpAPI) update(w http.ResponseWriter, r *http.Request) {
+	var req HTTPUpdateRequest
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		writeJSON(w, http.StatusBadRequest, HTTPError{Error: "invalid request body: " + err.Error(), ErrorCode: httpCodeInvalidRequest})
+		return
+	}
+
+	if err := a.manager.Update(WithInitiator(a.ctx, "http "+r.RemoteAddr), UpdateRequest{ImageRef: req.ImageRef, Force: req.Force}); err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusAccepted, map[string]string{"operation": OperationUpdate})
+}
+
+func (a *httpAPI) rollback(w http.ResponseWriter, r *http.Request) {
+	events, unsubscribe := a.manager.watch()
+	defer unsubscribe()
+
+	if err := a.manager.Rollback(WithInitiator(a.ctx, "http "+r.RemoteAddr)); err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, events.result(OperationRollback))
+}
+
+// events streams progress events as server-sent events named after the event
+// type, with the JSON event as data
+func (a *httpAPI) events(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeJSON(w, http.StatusInternalServerError, HTTPError{Error: "streaming not supported", ErrorCode: CodeUnknown})
+		return
+	}
+	events, unsubscribe := a.manager.watch()
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(httpKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case event := <-events.events:
+			event.SchemaVersion = EventSchemaVersion
+			data, err := json.Marshal(event)
+			if err != nil {
+				continue
+			}
+			if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Type, data); err != nil {
+				return
+			}
+		case <-keepAlive.C:
+			if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+		case <-events.overflow:
+			return
+		case <-r.Context().Done():
+			return
+		case <-a.ctx.Done():
+			return
+		}
+		flusher.Flush()
+	}
+}
+
+// writeHTTPError writes err with the HTTP status of its error code
+func writeHTTPError(w http.ResponseWriter, err error) {
+	code := ErrorCode(err)
+	status, ok := httpErrorStatus[code]
+	if !ok {
+		status = http.StatusInternalServerError
+	}
+	writeJSON(w, status, HTTPError{Error: err.Error(), ErrorCode: code})
+}
+
+// writeJSON writes v as the JSON response body
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
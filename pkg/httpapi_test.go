@@ -0,0 +1,183 @@
+package pkg
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testAPIToken = "s3cret"
+
+func newTestHTTPServer(t *testing.T, ctx context.Context, manager *Manager) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(NewHTTPHandler(ctx, manager, testAPIToken))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func doHTTP(t *testing.T, method, url, token, body string) *http.Response {
+	t.Helper()
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = resp.Body.Close() })
+	return resp
+}
+
+func TestHTTPAPIRequiresToken(t *testing.T) {
+	server := newTestHTTPServer(t, context.Background(), NewManager())
+
+	tests := []struct {
+		name  string
+		token string
+	}{
+		{"no token", ""},
+		{"wrong token", "guess"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := doHTTP(t, http.MethodPost, server.URL+"/rollback", tt.token, "")
+			if resp.StatusCode != http.StatusUnauthorized {
+				t.Errorf("status = %d, want %d", resp.StatusCode, http.StatusUnauthorized)
+			}
+			if resp.Header.Get("WWW-Authenticate") == "" {
+				t.Error("WWW-Authenticate header not set")
+			}
+		})
+	}
+}
+
+func TestHTTPAPIUpdateRequests(t *testing.T) {
+	setupOperationLockPath(t)
+	manager := NewManager()
+	server := newTestHTTPServer(t, context.Background(), manager)
+
+	resp := doHTTP(t, http.MethodPost, server.URL+"/update", testAPIToken, `{"image":"typo"}`)
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("unknown field status = %d, want %d", resp.StatusCode, http.StatusBadRequest)
+	}
+
+	release := make(chan struct{})
+	if err := manager.Start(context.Background(), OperationUpdate, func(ctx context.Context) error {
+		<-release
+		return nil
+	}); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	defer manager.Wait()
+	defer close(release)
+
+	resp = doHTTP(t, http.MethodPost, server.URL+"/update", testAPIToken, "")
+	if resp.StatusCode != http.StatusConflict {
+		t.Errorf("busy status = %d, want %d", resp.StatusCode, http.StatusConflict)
+	}
+	var body HTTPError
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatalf("failed to decode error: %v", err)
+	}
+	if body.ErrorCode != CodeOperationInProgress {
+		t.Errorf("error_code = %q, want %q", body.ErrorCode, CodeOperationInProgress)
+	}
+}
+
+func TestHTTPAPIEvents(t *testing.T) {
+	setupOperationLockPath(t)
+	manager := NewManager()
+	SetProgressReporter(manager)
+	t.Cleanup(func() { SetProgressReporter(nil) })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	server := newTestHTTPServer(t, ctx, manager)
+
+	resp := doHTTP(t, http.MethodGet, server.URL+"/events", testAPIToken, "")
+	if got := resp.Header.Get("Content-Type"); got != "text/event-stream" {
+		t.Fatalf("Content-Type = %q, want text/event-stream", got)
+	}
+
+	// The headers are flushed after the stream subscribed
+	err := manager.Run(ctx, OperationRollback, func(ctx context.Context) error {
+		reportPhaseProgress(PhaseBootloader, 1, 1, "writing")
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+
+	done := make(chan struct{})
+	var names []string
+	var result ProgressEvent
+	go func() {
+		defer close(done)
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			line := scanner.Text()
+			if name, ok := strings.CutPrefix(line, "event: "); ok {
+				names = append(names, name)
+			}
+			if data, ok := strings.CutPrefix(line, "data: "); ok && names[len(names)-1] == EventResult {
+				_ = json.Unmarshal([]byte(data), &result)
+				return
+			}
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the result event")
+	}
+
+	want := []string{EventPhaseProgress, EventComplete, EventResult}
+	if strings.Join(names, ",") != strings.Join(want, ",") {
+		t.Errorf("events = %v, want %v", names, want)
+	}
+	if result.SchemaVersion != EventSchemaVersion || result.Result == nil || result.Result.Status != StatusSuccess {
+		t.Errorf("result event = %+v, want successful result", result)
+	}
+}
+
+func TestReadAPIToken(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		name    string
+		content string
+		want    string
+		wantErr bool
+	}{
+		{"trims newline", "abc123\n", "abc123", false},
+		{"empty", " \n", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(dir, strings.ReplaceAll(tt.name, " ", "-"))
+			if err := os.WriteFile(path, []byte(tt.content), 0600); err != nil {
+				t.Fatal(err)
+			}
+			got, err := ReadAPIToken(path)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ReadAPIToken() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ReadAPIToken() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+	if _, err := ReadAPIToken(filepath.Join(dir, "missing")); err == nil {
+		t.Error("ReadAPIToken() of a missing file succeeded, want error")
+	}
+}
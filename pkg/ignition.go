@@ -0,0 +1,322 @@
+package pkg
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Ignition is an install customization read from an Ignition config (spec
+// 3.x), so CoreOS-style provisioning flows work with phukit. Users, files,
+// directories, links, systemd units and kernel arguments are applied to the
+// new root at install time; sections that partition or format disks do not
+// apply to phukit's fixed layout and are ignored.
+type Ignition struct {
+	Provisioning Provisioning
+	KernelArgs   []string
+	Ignored      []string // Sections that have no effect
+}
+
+// ignitionConfig is the part of the Ignition 3.x schema phukit reads
+type ignitionConfig struct {
+	Ignition struct {
+		Version string `json:"version"`
+		Config  struct {
+			Merge   []json.RawMessage `json:"merge"`
+			Replace json.RawMessage   `json:"replace"`
+		} `json:"config"`
+	} `json:"ignition"`
+	Passwd struct {
+		Users []struct {
+			Name              string   `json:"name"`
+			PasswordHash      *string  `json:"passwordHash"`
+			SSHAuthorizedKeys []string `json:"sshAuthorizedKeys"`
+			Groups            []string `json:"groups"`
+		} `json:"users"`
+		Groups json.RawMessage `json:"groups"`
+	} `json:"passwd"`
+	Storage struct {
+		Files []struct {
+			ignitionNode
+			Contents ignitionResource   `json:"contents"`
+			Append   []ignitionResource `json:"append"`
+			Mode     *int               `json:"mode"`
+		} `json:"files"`
+		Directories []struct {
+			ignitionNode
+			Mode *int `json:"mode"`
+		} `json:"directories"`
+		Links []struct {
+			ignitionNode
+			Target string `json:"target"`
+			Hard   bool   `json:"hard"`
+		} `json:"links"`
+		Disks       json.RawMessage `json:"disks"`
+		Raid        json.RawMessage `json:"raid"`
+		Filesystems json.RawMessage `json:"filesystems"`
+		Luks        json.RawMessage `json:"luks"`
+	} `json:"storage"`
+	Systemd struct {
+		Units []struct {
+			Name     string  `json:"name"`
+			Enabled  *bool   `json:"enabled"`
+			Mask     bool    `json:"mask"`
+			Contents *string `json:"contents"`
+			Dropins  []struct {
+				Name     string  `json:"name"`
+				Contents *string `json:"contents"`
+			} `json:"dropins"`
+		} `json:"units"`
+	} `json:"systemd"`
+	KernelArguments struct {
+		ShouldExist    []string `json:"shouldExist"`
+		ShouldNotExist []string `json:"shouldNotExist"`
+	} `json:"kernelArguments"`
+}
+
+// ignitionNode is the part shared by files, directories and links
+type ignitionNode struct {
+	Path      string `json:"path"`
+	Overwrite *bool  `json:"overwrite"`
+	User      struct {
+		ID   *int    `json:"id"`
+		Name *string `json:"name"`
+	} `json:"user"`
+	Group struct {
+		ID   *int    `json:"id"`
+		Name *string `json:"name"`
+	} `json:"group"`
+}
+
+// ignitionResource is the contents of a file
+type ignitionResource struct {
+	Source       *string `json:"source"`
+	Compression  *string `json:"compression"`
+	Verification struct {
+		Hash *string `json:"hash"`
+	} `json:"verification"`
+}
+
+// file returns the File for the node, with its owner and group
+func (n ignitionNode) file() File {
+	f := File{Path: n.Path, Overwrite: n.Overwrite != nil && *n.Overwrite}
+	switch {
+	case n.User.ID != nil:
+		f.Owner = strconv.Itoa(*n.User.ID)
+	case n.User.Name != nil:
+		f.Owner = *n.User.Name
+	}
+	switch {
+	case n.Group.ID != nil:
+		f.Group = strconv.Itoa(*n.Group.ID)
+	case n.Group.Name != nil:
+		f.Group = *n.Group.Name
+	}
+	return f
+}
+
+// LoadIgnition reads and parses the Ignition config at src, a path or an
+// http(s) URL
+func LoadIgnition(ctx context.Context, src string) (*Ignition, error) {
+	data, err := readConfigSource(ctx, src)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read Ignition config: %w", err)
+	}
+	ign, err := ParseIgnition(ctx, data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Ignition config %s: %w", src, err)
+	}
+	return ign, nil
+}
+
+// ParseIgnition parses an Ignition config. Remote file contents are fetched.
+func ParseIgnition(ctx context.Context, data []byte) (*Ignition, error) {
+	var cfg ignitionConfig
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(cfg.Ignition.Version, "3.") {
+		return nil, fmt.Errorf("unsupported Ignition version %q (supported: 3.x; translate older configs with ignition-validate or butane)", cfg.Ignition.Version)
+	}
+	if len(cfg.Ignition.Config.Merge) > 0 || len(cfg.Ignition.Config.Replace) > 0 {
+		return nil, fmt.Errorf("merged and replaced configs are not supported")
+	}
+
+	ign := &Ignition{}
+	for name, section := range map[string]json.RawMessage{
+		"passwd.groups":       cfg.Passwd.Groups,
+		"storage.disks":       cfg.Storage.Disks,
+		"storage.raid":        cfg.Storage.Raid,
+		"storage.filesystems": cfg.Storage.Filesystems,
+		"storage.luks":        cfg.Storage.Luks,
+	} {
+		if len(section) > 0 && string(section) != "null" && string(section) != "[]" {
+			ign.Ignored = append(ign.Ignored, name)
+		}
+	}
+	sort.Strings(ign.Ignored)
+
+	p := &ign.Provisioning
+	for _, cu := range cfg.Passwd.Users {
+		if cu.Name == "" {
+			return nil, fmt.Errorf("user without a name")
+		}
+		u := p.User(cu.Name)
+		u.Groups = append(u.Groups, cu.Groups...)
+		u.SSHKeys = append(u.SSHKeys, cu.SSHAuthorizedKeys...)
+		if cu.PasswordHash != nil {
+			u.PasswordHash = *cu.PasswordHash
+		}
+	}
+
+	for _, cf := range cfg.Storage.Files {
+		if len(cf.Append) > 0 {
+			return nil, fmt.Errorf("%s: appending to files is not supported", cf.Path)
+		}
+		f := cf.file()
+		if cf.Mode != nil {
+			f.Mode = os.FileMode(*cf.Mode)
+		}
+		contents, err := ignitionContents(ctx, cf.Contents)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", cf.Path, err)
+		}
+		f.Contents = contents
+		p.Files = append(p.Files, f)
+	}
+	for _, cd := range cfg.Storage.Directories {
+		f := cd.file()
+		f.Dir = true
+		if cd.Mode != nil {
+			f.Mode = os.FileMode(*cd.Mode)
+		}
+		p.Files = append(p.Files, f)
+	}
+	for _, cl := range cfg.Storage.Links {
+		if cl.Hard {
+			return nil, fmt.Errorf("%s: hard links are not supported", cl.Path)
+		}
+		if cl.Target == "" {
+			return nil, fmt.Errorf("%s: link without a target", cl.Path)
+		}
+		f := cl.file()
+		f.Target = cl.Target
+		p.Files = append(p.Files, f)
+	}
+
+	for _, cu := range cfg.Systemd.Units {
+		if cu.Name == "" {
+			return nil, fmt.Errorf("unit without a name")
+		}
+		u := Unit{Name: cu.Name, Enabled: cu.Enabled, Mask: cu.Mask}
+		if cu.Contents != nil {
+			u.Contents = *cu.Contents
+		}
+		for _, d := range cu.Dropins {
+			if d.Contents != nil {
+				u.Dropins = append(u.Dropins, UnitDropin{Name: d.Name, Contents: *d.Contents})
+			}
+		}
+		p.Units = append(p.Units, u)
+	}
+
+	ign.KernelArgs = cfg.KernelArguments.ShouldExist
+	if len(cfg.KernelArguments.ShouldNotExist) > 0 {
+		ign.Ignored = append(ign.Ignored, "kernelArguments.shouldNotExist")
+	}
+	return ign, nil
+}
+
+// ignitionContents returns the contents of a file resource: a data URL or
+// an http(s) URL, optionally gzip-compressed and checked against a hash
+func ignitionContents(ctx context.Context, r ignitionResource) ([]byte, error) {
+	if r.Source == nil {
+		return nil, nil
+	}
+	var data []byte
+	var err error
+	switch {
+	case strings.HasPrefix(*r.Source, "data:"):
+		data, err = decodeDataURL(*r.Source)
+	case strings.HasPrefix(*r.Source, "http://"), strings.HasPrefix(*r.Source, "https://"):
+		data, err = readConfigSource(ctx, *r.Source)
+	default:
+		err = fmt.Errorf("unsupported source %q (supported: data, http, https)", *r.Source)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if r.Verification.Hash != nil {
+		if err := verifyIgnitionHash(data, *r.Verification.Hash); err != nil {
+			return nil, err
+		}
+	}
+
+	if r.Compression != nil && *r.Compression != "" {
+		if *r.Compression != "gzip" {
+			return nil, fmt.Errorf("unsupported compression %q (supported: gzip)", *r.Compression)
+		}
+		zr, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("failed to decompress contents: %w", err)
+		}
+		if data, err = io.ReadAll(zr); err != nil {
+			return nil, fmt.Errorf("failed to decompress contents: %w", err)
+		}
+	}
+	return data, nil
+}
+
+// verifyIgnitionHash checks data against a "sha512-<hex>" or "sha256-<hex>"
+// hash, which Ignition computes over the resource before decompression
+func verifyIgnitionHash(data []byte, want string) error {
+	algo, sum, _ := strings.Cut(want, "-")
+	var h hash.Hash
+	switch algo {
+	case "sha512":
+		h = sha512.New()
+	case "sha256":
+		h = sha256.New()
+	default:
+		return fmt.Errorf("unsupported hash %q (supported: sha512, sha256)", algo)
+	}
+	h.Write(data)
+	if got := hex.EncodeToString(h.Sum(nil)); got != strings.ToLower(sum) {
+		return fmt.Errorf("%w: contents hash %s-%s, want %s", ErrVerificationFailed, algo, got, want)
+	}
+	return nil
+}
+
+// decodeDataURL decodes an RFC 2397 data URL
+func decodeDataURL(s string) ([]byte, error) {
+	meta, payload, ok := strings.Cut(strings.TrimPrefix(s, "data:"), ",")
+	if !ok {
+		return nil, fmt.Errorf("invalid data URL: no comma")
+	}
+	if strings.HasSuffix(meta, ";base64") {
+		data, err := base64.StdEncoding.DecodeString(payload)
+		if err != nil {
+			return nil, fmt.Errorf("invalid data URL: %w", err)
+		}
+		return data, nil
+	}
+	data, err := url.PathUnescape(payload)
+	if err != nil {
+		return nil, fmt.Errorf("invalid data URL: %w", err)
+	}
+	return []byte(data), nil
+}
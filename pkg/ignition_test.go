@@ -0,0 +1,101 @@
+package pkg
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestParseIgnition(t *testing.T) {
+	var gz bytes.Buffer
+	zw := gzip.NewWriter(&gz)
+	_, _ = zw.Write([]byte("compressed\n"))
+	_ = zw.Close()
+	sum := sha512.Sum512(gz.Bytes())
+
+	config := fmt.Sprintf(`{
+  "ignition": {"version": "3.4.0"},
+  "passwd": {"users": [
+    {"name": "core", "groups": ["wheel"], "sshAuthorizedKeys": ["ssh-ed25519 AAAA core@example"]},
+    {"name": "root", "passwordHash": "$6$root$hash"}
+  ]},
+  "storage": {
+    "disks": [{"device": "/dev/sda", "wipeTable": true}],
+    "files": [
+      {"path": "/etc/hostname", "mode": 420, "overwrite": true, "contents": {"source": "data:,web1%%0A"}},
+      {"path": "/etc/motd.d/10-ignition", "user": {"name": "core"}, "group": {"id": 10}, "contents": {"source": "data:;base64,%s", "compression": "gzip", "verification": {"hash": "sha512-%s"}}}
+    ],
+    "directories": [{"path": "/var/lib/app", "mode": 448}],
+    "links": [{"path": "/etc/localtime", "target": "../usr/share/zoneinfo/UTC", "overwrite": true}]
+  },
+  "systemd": {"units": [
+    {"name": "app.service", "enabled": true, "contents": "[Service]\nExecStart=/usr/bin/app\n"},
+    {"name": "sshd.service", "dropins": [{"name": "10-port.conf", "contents": "[Service]\n"}]},
+    {"name": "cups.service", "mask": true}
+  ]},
+  "kernelArguments": {"shouldExist": ["quiet"]}
+}`, base64.StdEncoding.EncodeToString(gz.Bytes()), hex.EncodeToString(sum[:]))
+
+	ign, err := ParseIgnition(context.Background(), []byte(config))
+	if err != nil {
+		t.Fatalf("ParseIgnition() error = %v", err)
+	}
+
+	p := ign.Provisioning
+	wantUsers := []User{
+		{Name: "core", Groups: []string{"wheel"}, SSHKeys: []string{"ssh-ed25519 AAAA core@example"}},
+		{Name: "root", PasswordHash: "$6$root$hash"},
+	}
+	if !reflect.DeepEqual(p.Users, wantUsers) {
+		t.Errorf("Users = %+v, want %+v", p.Users, wantUsers)
+	}
+	enabled := true
+	wantFiles := []File{
+		{Path: "/etc/hostname", Mode: 0644, Overwrite: true, Contents: []byte("web1\n")},
+		{Path: "/etc/motd.d/10-ignition", Owner: "core", Group: "10", Contents: []byte("compressed\n")},
+		{Path: "/var/lib/app", Mode: 0700, Dir: true},
+		{Path: "/etc/localtime", Target: "../usr/share/zoneinfo/UTC", Overwrite: true},
+	}
+	if !reflect.DeepEqual(p.Files, wantFiles) {
+		t.Errorf("Files = %+v, want %+v", p.Files, wantFiles)
+	}
+	wantUnits := []Unit{
+		{Name: "app.service", Enabled: &enabled, Contents: "[Service]\nExecStart=/usr/bin/app\n"},
+		{Name: "sshd.service", Dropins: []UnitDropin{{Name: "10-port.conf", Contents: "[Service]\n"}}},
+		{Name: "cups.service", Mask: true},
+	}
+	if !reflect.DeepEqual(p.Units, wantUnits) {
+		t.Errorf("Units = %+v, want %+v", p.Units, wantUnits)
+	}
+	if !reflect.DeepEqual(ign.KernelArgs, []string{"quiet"}) {
+		t.Errorf("KernelArgs = %v", ign.KernelArgs)
+	}
+	if !reflect.DeepEqual(ign.Ignored, []string{"storage.disks"}) {
+		t.Errorf("Ignored = %v", ign.Ignored)
+	}
+}
+
+func TestParseIgnitionErrors(t *testing.T) {
+	tests := map[string]string{
+		"spec 2":        `{"ignition": {"version": "2.3.0"}}`,
+		"merge":         `{"ignition": {"version": "3.4.0", "config": {"merge": [{"source": "https://example.com/a.ign"}]}}}`,
+		"append":        `{"ignition": {"version": "3.4.0"}, "storage": {"files": [{"path": "/a", "append": [{"source": "data:,x"}]}]}}`,
+		"hard link":     `{"ignition": {"version": "3.4.0"}, "storage": {"links": [{"path": "/a", "target": "/b", "hard": true}]}}`,
+		"source scheme": `{"ignition": {"version": "3.4.0"}, "storage": {"files": [{"path": "/a", "contents": {"source": "s3://bucket/a"}}]}}`,
+		"bad hash":      `{"ignition": {"version": "3.4.0"}, "storage": {"files": [{"path": "/a", "contents": {"source": "data:,x", "verification": {"hash": "sha512-00"}}}]}}`,
+		"not json":      `variant: fcos`,
+	}
+	for name, config := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, err := ParseIgnition(context.Background(), []byte(config)); err == nil {
+				t.Errorf("ParseIgnition() expected error for %s", config)
+			}
+		})
+	}
+}
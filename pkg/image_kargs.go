@@ -0,0 +1,117 @@
+package pkg
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+
+	"github.com/pelletier/go-toml/v2"
+)
+
+// Image kernel arguments
+//
+// OS builders can ship the kernel arguments their image needs with the
+// image, in the phukit.kargs label (space-separated) or in TOML drop-ins in
+// /usr/lib/phukit/kargs.d, in the format bootc uses; bootc images' own
+// /usr/lib/bootc/kargs.d is read as well:
+//
+//	kargs = ["console=ttyS0,115200n8", "mitigations=auto"]
+//	match-architectures = ["x86_64"] # optional
+//
+// The arguments follow the image: they are recorded in the deployment record
+// of each root rather than in the system config, so an update to an image
+// that drops an argument drops it from the boot entry as well. Arguments
+// given with --karg or 'phukit karg' come after them, and replace an image
+// argument with the same key, such as console=.
+
+// LabelKernelArgs is the image label holding default kernel arguments
+const LabelKernelArgs = "phukit.kargs"
+
+// imageKargsDirs are the drop-in directories of image kernel arguments, in
+// the order they are applied
+var imageKargsDirs = []string{"usr/lib/bootc/kargs.d", "usr/lib/phukit/kargs.d"}
+
+// kargsDropIn is a file in an image kernel arguments directory
+type kargsDropIn struct {
+	Kargs              []string `toml:"kargs"`
+	MatchArchitectures []string `toml:"match-architectures"`
+}
+
+// kernelArch returns the kernel's name for the architecture phukit runs on,
+// as used by match-architectures
+func kernelArch() string {
+	switch runtime.GOARCH {
+	case "amd64":
+		return "x86_64"
+	case "arm64":
+		return "aarch64"
+	case "ppc64le":
+		return "powerpc64le"
+	}
+	return runtime.GOARCH
+}
+
+// imageKernelArgs returns the default kernel arguments of the image with
+// labels extracted to root: the label's, then those of the drop-ins by file
+// name
+func imageKernelArgs(root string, labels map[string]string) ([]string, error) {
+	args := strings.Fields(labels[LabelKernelArgs])
+	for _, dir := range imageKargsDirs {
+		files, err := filepath.Glob(filepath.Join(root, dir, "*.toml"))
+		if err != nil {
+			return nil, err
+		}
+		for _, file := range files {
+			data, err := os.ReadFile(file)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read image kernel arguments: %w", err)
+			}
+			var dropIn kargsDropIn
+			if err := toml.Unmarshal(data, &dropIn); err != nil {
+				return nil, fmt.Errorf("failed to parse image kernel arguments /%s: %w", filepath.Join(dir, filepath.Base(file)), err)
+			}
+			if len(dropIn.MatchArchitectures) > 0 && !slices.Contains(dropIn.MatchArchitectures, kernelArch()) {
+				continue
+			}
+			args = append(args, dropIn.Kargs...)
+		}
+	}
+	for _, arg := range args {
+		if err := checkKernelArg(arg); err != nil {
+			return nil, fmt.Errorf("image kernel arguments: %w", err)
+		}
+	}
+	return mergeKernelArgs(nil, args), nil
+}
+
+// mergeImageKernelArgs returns the image's kernel arguments without those
+// whose key args sets, followed by args
+func mergeImageKernelArgs(image, args []string) []string {
+	var merged []string
+	for _, arg := range image {
+		key, _, _ := strings.Cut(arg, "=")
+		if !slices.ContainsFunc(args, func(a string) bool { return kernelArgMatches(a, key) }) {
+			merged = append(merged, arg)
+		}
+	}
+	return mergeKernelArgs(merged, args)
+}
+
+// deploymentKernelArgs returns the image kernel arguments recorded in the
+// deployment of the root mounted at root, or none
+func deploymentKernelArgs(root string) []string {
+	d, err := ReadDeployment(root)
+	if err != nil {
+		return nil
+	}
+	return d.KernelArgs
+}
+
+// BootedImageKernelArgs returns the default kernel arguments of the image the
+// running root was deployed from
+func BootedImageKernelArgs() []string {
+	return deploymentKernelArgs(bootedRoot)
+}
@@ -0,0 +1,52 @@
+package pkg
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestImageKernelArgs(t *testing.T) {
+	root := t.TempDir()
+	writeFakeFile(t, filepath.Join(root, "usr/lib/bootc/kargs.d/10-console.toml"), "kargs = [\"console=ttyS0,115200n8\"]\n")
+	writeFakeFile(t, filepath.Join(root, "usr/lib/phukit/kargs.d/20-quiet.toml"), "kargs = [\"quiet\", \"mitigations=auto\"]\n")
+	writeFakeFile(t, filepath.Join(root, "usr/lib/phukit/kargs.d/30-other-arch.toml"), "kargs = [\"other\"]\nmatch-architectures = [\"s390x-none\"]\n")
+	writeFakeFile(t, filepath.Join(root, "usr/lib/phukit/kargs.d/40-this-arch.toml"), "kargs = [\"this\"]\nmatch-architectures = [\""+kernelArch()+"\"]\n")
+	writeFakeFile(t, filepath.Join(root, "usr/lib/phukit/kargs.d/README"), "not a drop-in")
+
+	labels := map[string]string{LabelKernelArgs: "quiet rd.driver.pre=vfio-pci"}
+	got, err := imageKernelArgs(root, labels)
+	if err != nil {
+		t.Fatalf("imageKernelArgs() error = %v", err)
+	}
+	want := []string{"quiet", "rd.driver.pre=vfio-pci", "console=ttyS0,115200n8", "mitigations=auto", "this"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("imageKernelArgs() = %v, want %v", got, want)
+	}
+
+	if got, err := imageKernelArgs(t.TempDir(), nil); err != nil || len(got) != 0 {
+		t.Errorf("imageKernelArgs() of a plain image = %v, %v, want none", got, err)
+	}
+
+	bad := t.TempDir()
+	writeFakeFile(t, filepath.Join(bad, "usr/lib/phukit/kargs.d/root.toml"), "kargs = [\"root=/dev/sda1\"]\n")
+	if _, err := imageKernelArgs(bad, nil); err == nil {
+		t.Error("imageKernelArgs() with root= should fail")
+	}
+	writeFakeFile(t, filepath.Join(bad, "usr/lib/phukit/kargs.d/root.toml"), "kargs = \"quiet\n")
+	if _, err := imageKernelArgs(bad, nil); err == nil {
+		t.Error("imageKernelArgs() with invalid TOML should fail")
+	}
+}
+
+func TestMergeImageKernelArgs(t *testing.T) {
+	image := []string{"quiet", "console=tty0", "console=ttyS0"}
+	got := mergeImageKernelArgs(image, []string{"console=ttyS1,115200", "quiet"})
+	want := []string{"console=ttyS1,115200", "quiet"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("mergeImageKernelArgs() = %v, want %v", got, want)
+	}
+	if got := mergeImageKernelArgs(image, nil); !reflect.DeepEqual(got, image) {
+		t.Errorf("mergeImageKernelArgs() without arguments = %v, want %v", got, image)
+	}
+}
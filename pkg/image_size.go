@@ -0,0 +1,184 @@
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"golang.org/x/sys/unix"
+)
+
+// Image size preflight
+//
+// Running out of space halfway through extracting an image leaves a root
+// that does not boot, so install and update check first that the image fits.
+// The compressed size comes from the image manifest. Registries do not record
+// the uncompressed size, so it is estimated from the compressed size, unless
+// the image is already in the storage of podman or docker, which know it
+// exactly. The root slot needs room for the uncompressed image, and with
+// skopeo also for a copy of the image while it is unpacked. podman and docker
+// unpack the image into their storage under /var first, and a composefs
+// update adds the files of the new /usr to the object store on /var.
+
+// imageCompressionRatio is the estimated ratio of the uncompressed to the
+// compressed size of an image's layers
+const imageCompressionRatio = 3
+
+// filesystemOverheadPercent is the share of a partition taken up by
+// filesystem metadata and reserved blocks
+const filesystemOverheadPercent = 10
+
+// runtimeStoragePaths is where the container runtimes store images
+var runtimeStoragePaths = map[string]string{
+	"podman": "/var/lib/containers/storage",
+	"docker": "/var/lib/docker",
+}
+
+// imageSize is the size of an image
+type imageSize struct {
+	compressed   uint64 // Size of the layers as downloaded, 0 if unknown
+	uncompressed uint64 // Size of the unpacked filesystem
+	estimated    bool   // uncompressed is estimated from compressed
+	local        bool   // Already in the container runtime's storage
+}
+
+// String describes the size for the preflight output
+func (s imageSize) String() string {
+	if s.estimated {
+		return fmt.Sprintf("%s compressed, about %s uncompressed (estimated)", FormatSize(s.compressed), FormatSize(s.uncompressed))
+	}
+	if s.compressed == 0 {
+		return fmt.Sprintf("%s uncompressed", FormatSize(s.uncompressed))
+	}
+	return fmt.Sprintf("%s compressed, %s uncompressed", FormatSize(s.compressed), FormatSize(s.uncompressed))
+}
+
+// queryImageSize returns the size of imageRef, exactly if source has a copy
+// of it and otherwise estimated from the image manifest
+func queryImageSize(ctx context.Context, imageRef string, source ContainerSource) (imageSize, error) {
+	if runtime, ok := source.(runtimeSource); ok {
+		out, err := runCommand(ctx, runtime.tool, "image", "inspect", "--format", "{{.Size}}", imageRef)
+		if n, perr := strconv.ParseUint(strings.TrimSpace(string(out)), 10, 64); err == nil && perr == nil && n > 0 {
+			return imageSize{uncompressed: n, local: true}, nil
+		}
+	}
+
+	ref, err := name.ParseReference(imageRef)
+	if err != nil {
+		return imageSize{}, fmt.Errorf("%w: %w", ErrInvalidImageReference, err)
+	}
+	img, err := remoteImage(ctx, ref)
+	if err != nil {
+		return imageSize{}, fmt.Errorf("failed to fetch image: %w", registryError(err))
+	}
+	manifest, err := img.Manifest()
+	if err != nil {
+		return imageSize{}, fmt.Errorf("failed to get image manifest: %w", registryError(err))
+	}
+	var size imageSize
+	for _, layer := range manifest.Layers {
+		size.compressed += uint64(layer.Size)
+	}
+	size.uncompressed = size.compressed * imageCompressionRatio
+	size.estimated = true
+	return size, nil
+}
+
+// spaceNeed is room an image needs somewhere
+type spaceNeed struct {
+	what      string // What needs the room, e.g. "the root slot /dev/sda2"
+	need      uint64
+	available uint64
+}
+
+// imageSpaceNeeds returns the room size needs in a root slot of rootSlot
+// bytes and in the storage of source
+func imageSpaceNeeds(size imageSize, source ContainerSource, rootSlot string, rootSlotSize uint64) ([]spaceNeed, error) {
+	root := spaceNeed{
+		what:      "the root slot " + rootSlot,
+		need:      size.uncompressed,
+		available: rootSlotSize / 100 * (100 - filesystemOverheadPercent),
+	}
+	// skopeo stages the image and its unpacked tree on the target
+	if _, ok := source.(skopeoSource); ok {
+		root.need = size.compressed + 2*size.uncompressed
+	}
+	needs := []spaceNeed{root}
+
+	if runtime, ok := source.(runtimeSource); ok && !size.local {
+		path := runtimeStoragePaths[runtime.tool]
+		free, err := freeSpace(path)
+		if err != nil {
+			return nil, err
+		}
+		needs = append(needs, spaceNeed{what: runtime.tool + " storage in " + path, need: size.uncompressed, available: free})
+	}
+	return needs, nil
+}
+
+// checkSpaceNeeds returns ErrInsufficientSpace for the first need that does
+// not fit
+func checkSpaceNeeds(size imageSize, needs []spaceNeed) error {
+	for _, n := range needs {
+		if n.need <= n.available {
+			continue
+		}
+		estimate := ""
+		if size.estimated {
+			estimate = " (estimated from its compressed size)"
+		}
+		return fmt.Errorf("%w: the image needs about %s in %s%s, but only %s is available",
+			ErrInsufficientSpace, FormatSize(n.need), n.what, estimate, FormatSize(n.available))
+	}
+	return nil
+}
+
+// freeSpace returns the space available to root in the filesystem holding
+// path, or the closest existing parent of path
+func freeSpace(path string) (uint64, error) {
+	for {
+		if _, err := os.Stat(path); err == nil || filepath.Dir(path) == path {
+			break
+		}
+		path = filepath.Dir(path)
+	}
+	var st unix.Statfs_t
+	if err := unix.Statfs(path, &st); err != nil {
+		return 0, fmt.Errorf("failed to get free space of %s: %w", path, err)
+	}
+	return st.Bfree * uint64(st.Bsize), nil
+}
+
+// checkImageFits checks that imageRef fits in the root slot rootSlot of
+// rootSlotSize bytes and in the places it is unpacked on the way, plus any
+// extra needs. The check is skipped with a warning if the image size cannot
+// be determined.
+func checkImageFits(ctx context.Context, imageRef, rootSlot string, rootSlotSize uint64, extra func(imageSize) ([]spaceNeed, error)) error {
+	fmt.Println("Checking image size...")
+	source, err := resolveContainerSource()
+	if err != nil {
+		return err
+	}
+	size, err := queryImageSize(ctx, imageRef, source)
+	if err != nil {
+		return warnf("could not determine the size of %s, skipping the space check: %v", imageRef, err)
+	}
+	fmt.Printf("  Image size: %s\n", size)
+
+	needs, err := imageSpaceNeeds(size, source, rootSlot, rootSlotSize)
+	if err != nil {
+		return err
+	}
+	if extra != nil {
+		more, err := extra(size)
+		if err != nil {
+			return err
+		}
+		needs = append(needs, more...)
+	}
+	return checkSpaceNeeds(size, needs)
+}
@@ -0,0 +1,82 @@
+package pkg
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestQueryImageSizeLocal(t *testing.T) {
+	fake := setupScriptedExecutor(t)
+	fake.answer("podman image inspect", "4294967296\n")
+
+	size, err := queryImageSize(context.Background(), "localhost/my-image", runtimeSource{tool: "podman"})
+	if err != nil {
+		t.Fatalf("queryImageSize() error = %v", err)
+	}
+	want := imageSize{uncompressed: 4 << 30, local: true}
+	if size != want {
+		t.Errorf("queryImageSize() = %+v, want %+v", size, want)
+	}
+	if got := fake.Commands[0].String(); got != "podman image inspect --format {{.Size}} localhost/my-image" {
+		t.Errorf("command = %q", got)
+	}
+}
+
+func TestImageSpaceNeeds(t *testing.T) {
+	const gib = 1 << 30
+	size := imageSize{compressed: 2 * gib, uncompressed: 6 * gib, estimated: true}
+
+	needs, err := imageSpaceNeeds(size, builtinSource{}, "root1", 12*gib)
+	if err != nil {
+		t.Fatalf("imageSpaceNeeds() error = %v", err)
+	}
+	if len(needs) != 1 || needs[0].need != 6*gib || needs[0].available != 12*gib/100*90 {
+		t.Errorf("builtin needs = %+v", needs)
+	}
+	if err := checkSpaceNeeds(size, needs); err != nil {
+		t.Errorf("6 GiB image does not fit in 12 GiB: %v", err)
+	}
+
+	// skopeo unpacks a copy of the image on the target
+	needs, err = imageSpaceNeeds(size, skopeoSource{}, "root1", 12*gib)
+	if err != nil {
+		t.Fatalf("imageSpaceNeeds() error = %v", err)
+	}
+	if needs[0].need != 14*gib {
+		t.Errorf("skopeo root need = %d, want %d", needs[0].need, 14*gib)
+	}
+	err = checkSpaceNeeds(size, needs)
+	if !errors.Is(err, ErrInsufficientSpace) {
+		t.Fatalf("expected ErrInsufficientSpace, got %v", err)
+	}
+	for _, want := range []string{"the root slot root1", "estimated", "14.0 GB"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q does not mention %q", err, want)
+		}
+	}
+
+	// A runtime unpacks the image into its storage unless it has it already
+	runtimeStoragePaths["fake"] = t.TempDir()
+	t.Cleanup(func() { delete(runtimeStoragePaths, "fake") })
+	needs, err = imageSpaceNeeds(size, runtimeSource{tool: "fake"}, "root1", 12*gib)
+	if err != nil {
+		t.Fatalf("imageSpaceNeeds() error = %v", err)
+	}
+	if len(needs) != 2 || !strings.HasPrefix(needs[1].what, "fake storage") || needs[1].available == 0 {
+		t.Errorf("runtime needs = %+v", needs)
+	}
+	size.local = true
+	if needs, _ := imageSpaceNeeds(size, runtimeSource{tool: "fake"}, "root1", 12*gib); len(needs) != 1 {
+		t.Errorf("local image needs = %+v", needs)
+	}
+}
+
+func TestFreeSpaceMissingPath(t *testing.T) {
+	dir := t.TempDir()
+	free, err := freeSpace(dir + "/not/created/yet")
+	if err != nil || free == 0 {
+		t.Errorf("freeSpace() = %d, %v", free, err)
+	}
+}
@@ -0,0 +1,111 @@
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/name"
+)
+
+// Image versions
+//
+// Boot menus, status and the history name deployments by version rather
+// than by partition or digest alone. The version and revision come from the
+// standard OCI labels of the image (or the manifest annotations of the same
+// name), and the version falls back to VERSION_ID of the image's os-release.
+// Both are recorded in the deployment record of each root, so the boot
+// entries of later updates and rollbacks read them without the registry.
+
+// OCI image labels and annotations carrying the version metadata
+const (
+	LabelImageVersion  = "org.opencontainers.image.version"
+	LabelImageRevision = "org.opencontainers.image.revision"
+)
+
+// GetRemoteImageLabels returns the labels of imageRef from its config, with
+// the manifest annotations the labels do not set, without downloading layers
+func GetRemoteImageLabels(ctx context.Context, imageRef string) (map[string]string, error) {
+	ref, err := name.ParseReference(imageRef)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidImageReference, err)
+	}
+	img, err := remoteImage(ctx, ref)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch image: %w", registryError(err))
+	}
+	manifest, err := img.Manifest()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get image manifest: %w", registryError(err))
+	}
+	config, err := img.ConfigFile()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get image config: %w", registryError(err))
+	}
+
+	labels := map[string]string{}
+	for k, v := range manifest.Annotations {
+		labels[k] = v
+	}
+	for k, v := range config.Config.Labels {
+		labels[k] = v
+	}
+	return labels, nil
+}
+
+// imageLabels returns the labels of imageRef for its deployment record, or
+// none if the registry cannot be reached; the version then comes from
+// os-release
+func imageLabels(ctx context.Context, imageRef string, verbose bool) map[string]string {
+	labels, err := GetRemoteImageLabels(ctx, imageRef)
+	if err != nil {
+		if verbose {
+			fmt.Printf("  Could not read image labels: %v\n", err)
+		}
+		return nil
+	}
+	return labels
+}
+
+// DisplayVersion returns the version of the deployed image: its version
+// label, or VERSION_ID of its os-release
+func (d *Deployment) DisplayVersion() string {
+	if d.ImageVersion != "" {
+		return d.ImageVersion
+	}
+	return d.Version
+}
+
+// ShortRevision returns the revision of the deployed image, shortened like
+// a git commit
+func (d *Deployment) ShortRevision() string {
+	if len(d.Revision) > 12 {
+		return d.Revision[:12]
+	}
+	return d.Revision
+}
+
+// deploymentVersion returns the version of the root mounted at root, or ""
+func deploymentVersion(root string) string {
+	d, err := ReadDeployment(root)
+	if err != nil {
+		return ""
+	}
+	return d.DisplayVersion()
+}
+
+// bootEntryName returns the boot menu title of the root mounted at root,
+// such as "MyOS 42.1": NAME of its os-release with the image version, or
+// PRETTY_NAME with VERSION_ID if it lacks one
+func bootEntryName(root string) string {
+	if d, err := ReadDeployment(root); err == nil && d.ImageVersion != "" {
+		if name := osReleaseValue(root, "NAME"); name != "" {
+			return name + " " + d.ImageVersion
+		}
+	}
+	osName := ParseOSRelease(root)
+	if version := osReleaseValue(root, "VERSION_ID"); version != "" && !strings.Contains(osName, version) {
+		return osName + " " + version
+	}
+	return osName
+}
@@ -0,0 +1,77 @@
+package pkg
+
+import (
+	"path/filepath"
+	"testing"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+)
+
+func TestGetRemoteImageLabels(t *testing.T) {
+	img, err := mutate.AppendLayers(empty.Image, testLayer(t, "usr/lib/os-release", "ID=example\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := img.ConfigFile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg = cfg.DeepCopy()
+	cfg.Config.Labels = map[string]string{LabelImageVersion: "42.1"}
+	if img, err = mutate.ConfigFile(img, cfg); err != nil {
+		t.Fatal(err)
+	}
+	// A label wins over the annotation of the same name
+	img = mutate.Annotations(img, map[string]string{LabelImageVersion: "ignored", LabelImageRevision: "0123456789abcdef"}).(v1.Image)
+	imageRef := pushTestImage(t, img)
+
+	labels, err := GetRemoteImageLabels(t.Context(), imageRef)
+	if err != nil {
+		t.Fatalf("GetRemoteImageLabels() error = %v", err)
+	}
+	if labels[LabelImageVersion] != "42.1" || labels[LabelImageRevision] != "0123456789abcdef" {
+		t.Errorf("GetRemoteImageLabels() = %v", labels)
+	}
+
+	if _, err := GetRemoteImageLabels(t.Context(), "Invalid Reference"); ErrorCode(err) != CodeInvalidImageReference {
+		t.Errorf("GetRemoteImageLabels(invalid) error = %v, want %s", err, CodeInvalidImageReference)
+	}
+}
+
+func TestDeploymentVersion(t *testing.T) {
+	root := t.TempDir()
+	writeFakeFile(t, filepath.Join(root, "etc", "os-release"), "NAME=\"MyOS\"\nPRETTY_NAME=\"MyOS Server\"\nVERSION_ID=42\n")
+
+	labels := map[string]string{LabelImageVersion: "42.1", LabelImageRevision: "0123456789abcdef0123"}
+	d := newDeployment(root, "quay.io/example/os:latest", "sha256:abc", labels, false)
+	if d.Version != "42" || d.DisplayVersion() != "42.1" || d.ShortRevision() != "0123456789ab" {
+		t.Errorf("newDeployment() = %+v, DisplayVersion() = %q, ShortRevision() = %q", d, d.DisplayVersion(), d.ShortRevision())
+	}
+
+	// Without labels the boot entry falls back to os-release
+	if got := bootEntryName(root); got != "MyOS Server 42" {
+		t.Errorf("bootEntryName() without deployment = %q, want MyOS Server 42", got)
+	}
+	if got := deploymentVersion(root); got != "" {
+		t.Errorf("deploymentVersion() without deployment = %q, want none", got)
+	}
+
+	if err := WriteDeployment(root, d, false); err != nil {
+		t.Fatal(err)
+	}
+	if got := bootEntryName(root); got != "MyOS 42.1" {
+		t.Errorf("bootEntryName() = %q, want MyOS 42.1", got)
+	}
+	if got := deploymentVersion(root); got != "42.1" {
+		t.Errorf("deploymentVersion() = %q, want 42.1", got)
+	}
+
+	// A PRETTY_NAME carrying the version is not repeated
+	other := t.TempDir()
+	writeFakeFile(t, filepath.Join(other, "etc", "os-release"), "PRETTY_NAME=\"Fedora Linux 42 (Adams)\"\nVERSION_ID=42\n")
+	if got := bootEntryName(other); got != "Fedora Linux 42 (Adams)" {
+		t.Errorf("bootEntryName() = %q, want Fedora Linux 42 (Adams)", got)
+	}
+}
@@ -0,0 +1,393 @@
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// StorageFeature represents a storage stack that needs extra initramfs support
+// to locate the root device on first boot
+type StorageFeature string
+
+const (
+	StorageRAID      StorageFeature = "mdraid"
+	StorageMultipath StorageFeature = "multipath"
+	StorageISCSI     StorageFeature = "iscsi"
+	StorageNVMeoF    StorageFeature = "nvmf"
+	StorageResume    StorageFeature = "resume" // Resume from hibernation on the swap partition
+	StorageLVM       StorageFeature = "lvm"    // Roots and /var on LVM logical volumes
+	StorageCrypt     StorageFeature = "crypt"  // Roots, /var or swap in LUKS containers
+)
+
+// sysBlockPath is the sysfs directory used for storage detection (overridable in tests)
+var sysBlockPath = "/sys/block"
+
+// sysClassNVMePath is the sysfs directory holding NVMe controllers (overridable in tests)
+var sysClassNVMePath = "/sys/class/nvme"
+
+// DetectStorageFeatures inspects sysfs for the target device and the devices
+// stacked below it, and returns the storage stacks (RAID, multipath, iSCSI, NVMe-oF)
+// it depends on. Returns an empty slice for plain local disks.
+func DetectStorageFeatures(device string) []StorageFeature {
+	var features []StorageFeature
+	seen := make(map[StorageFeature]bool)
+
+	walkBlockDevices(blockDeviceName(device), func(name string) {
+		for _, f := range deviceStorageFeatures(name) {
+			if !seen[f] {
+				seen[f] = true
+				features = append(features, f)
+			}
+		}
+	})
+	return features
+}
+
+// walkBlockDevices calls fn for a block device and, recursively, for every device
+// listed under its sysfs slaves/ directory (the backing devices of md and dm maps)
+func walkBlockDevices(name string, fn func(name string)) {
+	visited := make(map[string]bool)
+	var walk func(name string)
+	walk = func(name string) {
+		if visited[name] {
+			return
+		}
+		visited[name] = true
+		fn(name)
+
+		if slaves, err := os.ReadDir(filepath.Join(sysBlockPath, name, "slaves")); err == nil {
+			for _, slave := range slaves {
+				walk(slave.Name())
+			}
+		}
+	}
+	walk(name)
+}
+
+// deviceStorageFeatures returns the storage stacks a single block device belongs to,
+// without looking at the devices stacked below it
+func deviceStorageFeatures(name string) []StorageFeature {
+	var features []StorageFeature
+	devDir := filepath.Join(sysBlockPath, name)
+
+	// Software RAID (md) devices expose an md/ directory
+	if strings.HasPrefix(name, "md") {
+		features = append(features, StorageRAID)
+	} else if _, err := os.Stat(filepath.Join(devDir, "md")); err == nil {
+		features = append(features, StorageRAID)
+	}
+
+	// Device-mapper multipath maps have a dm/uuid starting with mpath-
+	if data, err := os.ReadFile(filepath.Join(devDir, "dm", "uuid")); err == nil {
+		if strings.HasPrefix(strings.TrimSpace(string(data)), "mpath-") {
+			features = append(features, StorageMultipath)
+		}
+	}
+
+	// iSCSI disks live below a session directory in the sysfs device path
+	if resolved, err := filepath.EvalSymlinks(devDir); err == nil {
+		for _, component := range strings.Split(resolved, string(filepath.Separator)) {
+			if strings.HasPrefix(component, "session") {
+				features = append(features, StorageISCSI)
+				break
+			}
+		}
+	}
+
+	// NVMe namespaces reached over a fabric report a non-pcie transport
+	if strings.HasPrefix(name, "nvme") {
+		if idx := strings.Index(name[len("nvme"):], "n"); idx > 0 {
+			controller := name[:len("nvme")+idx]
+			transportPath := filepath.Join(sysClassNVMePath, controller, "transport")
+			if data, err := os.ReadFile(transportPath); err == nil {
+				transport := strings.TrimSpace(string(data))
+				if transport != "" && transport != "pcie" {
+					features = append(features, StorageNVMeoF)
+				}
+			}
+		}
+	}
+
+	return features
+}
+
+// DracutModulesForFeatures returns the dracut modules needed for the given storage features
+func DracutModulesForFeatures(features []StorageFeature) []string {
+	modules := []string{}
+	for _, f := range features {
+		switch f {
+		case StorageRAID:
+			modules = append(modules, "mdraid")
+		case StorageMultipath:
+			modules = append(modules, "multipath")
+		case StorageISCSI:
+			modules = append(modules, "iscsi", "network")
+		case StorageNVMeoF:
+			modules = append(modules, "nvmf", "network")
+		case StorageResume:
+			modules = append(modules, "resume")
+		case StorageLVM:
+			modules = append(modules, "lvm")
+		case StorageCrypt:
+			modules = append(modules, "crypt")
+		}
+	}
+	return uniqueStrings(modules)
+}
+
+// uniqueStrings returns the input with duplicates removed, preserving order
+func uniqueStrings(in []string) []string {
+	seen := make(map[string]bool)
+	out := []string{}
+	for _, s := range in {
+		if !seen[s] {
+			seen[s] = true
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+// initramfsPatterns returns the candidate initramfs paths inside /usr/lib/modules/$KERNEL_VERSION
+func initramfsPatterns(kernelModuleDir, kernelVersion string) []string {
+	return []string{
+		filepath.Join(kernelModuleDir, "initramfs.img"),
+		filepath.Join(kernelModuleDir, "initrd.img"),
+		filepath.Join(kernelModuleDir, "initramfs-"+kernelVersion+".img"),
+		filepath.Join(kernelModuleDir, "initrd.img-"+kernelVersion),
+	}
+}
+
+// findModulesInitramfs returns the initramfs shipped in a kernel module directory,
+// or an empty string if the image does not provide one
+func findModulesInitramfs(kernelModuleDir, kernelVersion string) string {
+	for _, pattern := range initramfsPatterns(kernelModuleDir, kernelVersion) {
+		if info, err := os.Stat(pattern); err == nil && !info.IsDir() {
+			return pattern
+		}
+	}
+	return ""
+}
+
+// listKernelVersions returns the kernel versions found in /usr/lib/modules of the target
+func listKernelVersions(targetDir string) ([]string, error) {
+	modulesDir := filepath.Join(targetDir, "usr", "lib", "modules")
+	entries, err := os.ReadDir(modulesDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", modulesDir, err)
+	}
+
+	versions := []string{}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			versions = append(versions, entry.Name())
+		}
+	}
+	return versions, nil
+}
+
+// KernelsMissingInitramfs returns the kernel versions in the target that ship a
+// kernel image but no initramfs
+func KernelsMissingInitramfs(targetDir string) ([]string, error) {
+	versions, err := listKernelVersions(targetDir)
+	if err != nil {
+		return nil, err
+	}
+
+	missing := []string{}
+	for _, kernelVersion := range versions {
+		kernelModuleDir := filepath.Join(targetDir, "usr", "lib", "modules", kernelVersion)
+
+		hasKernel := false
+		for _, kernel := range []string{"vmlinuz", "vmlinuz-" + kernelVersion} {
+			if _, err := os.Stat(filepath.Join(kernelModuleDir, kernel)); err == nil {
+				hasKernel = true
+				break
+			}
+		}
+		if !hasKernel {
+			continue
+		}
+
+		if findModulesInitramfs(kernelModuleDir, kernelVersion) == "" {
+			missing = append(missing, kernelVersion)
+		}
+	}
+	return missing, nil
+}
+
+// InitramfsGenerator identifies the tool used to build an initramfs inside the target
+type InitramfsGenerator string
+
+const (
+	InitramfsDracut     InitramfsGenerator = "dracut"
+	InitramfsMkinitcpio InitramfsGenerator = "mkinitcpio"
+)
+
+// DetectInitramfsGenerator returns the initramfs generator available in the target image
+func DetectInitramfsGenerator(targetDir string) (InitramfsGenerator, error) {
+	for _, gen := range []InitramfsGenerator{InitramfsDracut, InitramfsMkinitcpio} {
+		if targetHasCommand(targetDir, string(gen)) {
+			return gen, nil
+		}
+	}
+	return "", fmt.Errorf("no initramfs generator (dracut or mkinitcpio) found in target image")
+}
+
+// DetectStorageDrivers returns the kernel modules driving the target device and its
+// parent controllers (e.g. nvme, ahci, virtio_blk), so they can be forced into the initramfs.
+// For multipath and other stacked devices these are the drivers of the disks below.
+func DetectStorageDrivers(device string) []string {
+	drivers := []string{}
+	walkBlockDevices(blockDeviceName(device), func(name string) {
+		devicePath, err := filepath.EvalSymlinks(filepath.Join(sysBlockPath, name, "device"))
+		if err != nil {
+			return
+		}
+		for dir := devicePath; dir != "/" && dir != "." && strings.Contains(dir, "devices"); dir = filepath.Dir(dir) {
+			if module, err := filepath.EvalSymlinks(filepath.Join(dir, "driver", "module")); err == nil {
+				drivers = append(drivers, filepath.Base(module))
+			}
+		}
+	})
+	return uniqueStrings(drivers)
+}
+
+// mkinitcpioHooksForFeatures returns the mkinitcpio hooks needed for the given storage features
+func mkinitcpioHooksForFeatures(features []StorageFeature) ([]string, error) {
+	hooks := []string{}
+	for _, f := range features {
+		switch f {
+		case StorageRAID:
+			hooks = append(hooks, "mdadm_udev")
+		case StorageResume:
+			hooks = append(hooks, "resume")
+		case StorageLVM:
+			hooks = append(hooks, "lvm2")
+		case StorageCrypt:
+			// Opens the containers of rd.luks.uuid=, like dracut
+			hooks = append(hooks, "sd-encrypt")
+		default:
+			if err := warnf("  mkinitcpio has no standard hook for %s storage", f); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return hooks, nil
+}
+
+// buildInitramfs runs the initramfs generator in a chroot of the target for one kernel,
+// writing the result to /usr/lib/modules/$KERNEL_VERSION/initramfs.img
+func buildInitramfs(ctx context.Context, targetDir string, gen InitramfsGenerator, kernelVersion string, features []StorageFeature, drivers []string, verbose bool) error {
+	initrdPath := filepath.Join("/usr/lib/modules", kernelVersion, "initramfs.img")
+
+	var args []string
+	switch gen {
+	case InitramfsDracut:
+		args = []string{"--force", "--no-hostonly-cmdline"}
+		if modules := DracutModulesForFeatures(features); len(modules) > 0 {
+			args = append(args, "--add", strings.Join(modules, " "))
+		}
+		if len(drivers) > 0 {
+			args = append(args, "--add-drivers", strings.Join(drivers, " "))
+		}
+		if verbose {
+			args = append(args, "--verbose")
+		}
+		args = append(args, initrdPath, kernelVersion)
+	case InitramfsMkinitcpio:
+		args = []string{"-k", kernelVersion, "-g", initrdPath}
+		hooks, err := mkinitcpioHooksForFeatures(features)
+		if err != nil {
+			return err
+		}
+		for _, hook := range hooks {
+			args = append(args, "-A", hook)
+		}
+		if verbose {
+			args = append(args, "-v")
+		}
+	default:
+		return fmt.Errorf("unsupported initramfs generator: %s", gen)
+	}
+
+	return chrootCommandWithProgress(ctx, targetDir, PhaseInitramfs, &initramfsProgressParser{}, string(gen), args...)
+}
+
+// RebuildInitramfsForStorage rebuilds the initramfs for every kernel in the target
+// with the modules required by the detected storage features.
+// The generic image initrd does not include RAID/multipath/iSCSI/NVMe-oF support,
+// so without this the root device cannot be found on first boot.
+func RebuildInitramfsForStorage(ctx context.Context, targetDir string, features []StorageFeature, verbose, dryRun bool) error {
+	if len(features) == 0 {
+		return nil
+	}
+
+	modules := DracutModulesForFeatures(features)
+	if dryRun {
+		fmt.Printf("[DRY RUN] Would rebuild initramfs with dracut modules: %s\n", strings.Join(modules, " "))
+		return nil
+	}
+
+	fmt.Printf("  Rebuilding initramfs for storage: %s\n", strings.Join(modules, " "))
+
+	gen, err := DetectInitramfsGenerator(targetDir)
+	if err != nil {
+		return fmt.Errorf("cannot add storage drivers to initramfs: %w", err)
+	}
+
+	versions, err := listKernelVersions(targetDir)
+	if err != nil {
+		return err
+	}
+
+	for _, kernelVersion := range versions {
+		if err := buildInitramfs(ctx, targetDir, gen, kernelVersion, features, nil, verbose); err != nil {
+			return fmt.Errorf("failed to rebuild initramfs for kernel %s: %w", kernelVersion, err)
+		}
+		fmt.Printf("  Rebuilt initramfs for kernel %s\n", kernelVersion)
+	}
+
+	return nil
+}
+
+// GenerateMissingInitramfs builds an initramfs for each kernel in the target that
+// ships without one, including the drivers for the target device's storage controller.
+// Without this the system would attempt to boot with no initrd at all.
+func GenerateMissingInitramfs(ctx context.Context, targetDir, device string, verbose, dryRun bool) error {
+	missing, err := KernelsMissingInitramfs(targetDir)
+	if err != nil {
+		return fmt.Errorf("failed to find kernels without initramfs: %w", err)
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+
+	if dryRun {
+		fmt.Printf("[DRY RUN] Would generate initramfs for kernels: %s\n", strings.Join(missing, " "))
+		return nil
+	}
+
+	gen, err := DetectInitramfsGenerator(targetDir)
+	if err != nil {
+		return fmt.Errorf("image has no initramfs for kernel(s) %s: %w", strings.Join(missing, ", "), err)
+	}
+
+	features := DetectStorageFeatures(device)
+	drivers := DetectStorageDrivers(device)
+	if verbose && len(drivers) > 0 {
+		fmt.Printf("  Host storage drivers: %s\n", strings.Join(drivers, " "))
+	}
+
+	for _, kernelVersion := range missing {
+		fmt.Printf("  Generating initramfs for kernel %s with %s...\n", kernelVersion, gen)
+		if err := buildInitramfs(ctx, targetDir, gen, kernelVersion, features, drivers, verbose); err != nil {
+			return fmt.Errorf("failed to generate initramfs for kernel %s: %w", kernelVersion, err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,170 @@
+package pkg
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// setupFakeSysfs points the sysfs lookups at a temporary directory for the duration of a test
+func setupFakeSysfs(t *testing.T) string {
+	t.Helper()
+
+	root := t.TempDir()
+	oldBlock, oldNVMe := sysBlockPath, sysClassNVMePath
+	sysBlockPath = filepath.Join(root, "block")
+	sysClassNVMePath = filepath.Join(root, "class", "nvme")
+	t.Cleanup(func() {
+		sysBlockPath, sysClassNVMePath = oldBlock, oldNVMe
+	})
+
+	if err := os.MkdirAll(sysBlockPath, 0755); err != nil {
+		t.Fatalf("Failed to create fake sysfs: %v", err)
+	}
+	return root
+}
+
+func writeFakeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatalf("Failed to create directory: %v", err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write %s: %v", path, err)
+	}
+}
+
+func TestDetectStorageFeatures(t *testing.T) {
+	t.Run("plain disk", func(t *testing.T) {
+		setupFakeSysfs(t)
+		if err := os.MkdirAll(filepath.Join(sysBlockPath, "sda"), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if got := DetectStorageFeatures("/dev/sda"); len(got) != 0 {
+			t.Errorf("DetectStorageFeatures() = %v, want none", got)
+		}
+	})
+
+	t.Run("md raid", func(t *testing.T) {
+		setupFakeSysfs(t)
+		if err := os.MkdirAll(filepath.Join(sysBlockPath, "md0", "md"), 0755); err != nil {
+			t.Fatal(err)
+		}
+		want := []StorageFeature{StorageRAID}
+		if got := DetectStorageFeatures("/dev/md0"); !reflect.DeepEqual(got, want) {
+			t.Errorf("DetectStorageFeatures() = %v, want %v", got, want)
+		}
+	})
+
+	t.Run("multipath over iscsi", func(t *testing.T) {
+		root := setupFakeSysfs(t)
+		writeFakeFile(t, filepath.Join(sysBlockPath, "dm-0", "dm", "uuid"), "mpath-3600a0b80\n")
+
+		// iSCSI disk: sysfs entry is a symlink into a session directory
+		sessionDev := filepath.Join(root, "devices", "platform", "host2", "session1", "target2:0:0", "block", "sdb")
+		if err := os.MkdirAll(sessionDev, 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Symlink(sessionDev, filepath.Join(sysBlockPath, "sdb")); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.MkdirAll(filepath.Join(sysBlockPath, "dm-0", "slaves", "sdb"), 0755); err != nil {
+			t.Fatal(err)
+		}
+
+		want := []StorageFeature{StorageMultipath, StorageISCSI}
+		if got := DetectStorageFeatures("/dev/dm-0"); !reflect.DeepEqual(got, want) {
+			t.Errorf("DetectStorageFeatures() = %v, want %v", got, want)
+		}
+	})
+
+	t.Run("nvme over fabrics", func(t *testing.T) {
+		setupFakeSysfs(t)
+		if err := os.MkdirAll(filepath.Join(sysBlockPath, "nvme1n1"), 0755); err != nil {
+			t.Fatal(err)
+		}
+		writeFakeFile(t, filepath.Join(sysClassNVMePath, "nvme1", "transport"), "tcp\n")
+
+		want := []StorageFeature{StorageNVMeoF}
+		if got := DetectStorageFeatures("/dev/nvme1n1"); !reflect.DeepEqual(got, want) {
+			t.Errorf("DetectStorageFeatures() = %v, want %v", got, want)
+		}
+	})
+
+	t.Run("local nvme", func(t *testing.T) {
+		setupFakeSysfs(t)
+		if err := os.MkdirAll(filepath.Join(sysBlockPath, "nvme0n1"), 0755); err != nil {
+			t.Fatal(err)
+		}
+		writeFakeFile(t, filepath.Join(sysClassNVMePath, "nvme0", "transport"), "pcie\n")
+
+		if got := DetectStorageFeatures("/dev/nvme0n1"); len(got) != 0 {
+			t.Errorf("DetectStorageFeatures() = %v, want none", got)
+		}
+	})
+}
+
+func TestDracutModulesForFeatures(t *testing.T) {
+	got := DracutModulesForFeatures([]StorageFeature{StorageISCSI, StorageNVMeoF, StorageRAID, StorageResume, StorageLVM, StorageCrypt})
+	want := []string{"iscsi", "network", "nvmf", "mdraid", "resume", "lvm", "crypt"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("DracutModulesForFeatures() = %v, want %v", got, want)
+	}
+}
+
+func TestKernelsMissingInitramfs(t *testing.T) {
+	targetDir := t.TempDir()
+	modulesDir := filepath.Join(targetDir, "usr", "lib", "modules")
+
+	// Kernel with an initramfs
+	writeFakeFile(t, filepath.Join(modulesDir, "6.1.0", "vmlinuz"), "kernel")
+	writeFakeFile(t, filepath.Join(modulesDir, "6.1.0", "initramfs.img"), "initrd")
+	// Kernel without an initramfs
+	writeFakeFile(t, filepath.Join(modulesDir, "6.2.0", "vmlinuz"), "kernel")
+	// Module directory without a kernel image (e.g. extra modules only)
+	if err := os.MkdirAll(filepath.Join(modulesDir, "6.3.0-extra"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := KernelsMissingInitramfs(targetDir)
+	if err != nil {
+		t.Fatalf("KernelsMissingInitramfs() error = %v", err)
+	}
+	want := []string{"6.2.0"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("KernelsMissingInitramfs() = %v, want %v", got, want)
+	}
+}
+
+func TestGenerateMissingInitramfs(t *testing.T) {
+	targetDir := t.TempDir()
+	if err := GenerateMissingInitramfs(t.Context(), targetDir, "/dev/sda", false, false); err == nil {
+		t.Error("GenerateMissingInitramfs() expected error for a target without /usr/lib/modules")
+	}
+
+	writeFakeFile(t, filepath.Join(targetDir, "usr", "lib", "modules", "6.1.0", "vmlinuz"), "kernel")
+	writeFakeFile(t, filepath.Join(targetDir, "usr", "lib", "modules", "6.1.0", "initramfs.img"), "initrd")
+	if err := GenerateMissingInitramfs(t.Context(), targetDir, "/dev/sda", false, false); err != nil {
+		t.Errorf("GenerateMissingInitramfs() error = %v, want nil with every initramfs present", err)
+	}
+}
+
+func TestDetectInitramfsGenerator(t *testing.T) {
+	targetDir := t.TempDir()
+	if _, err := DetectInitramfsGenerator(targetDir); err == nil {
+		t.Error("DetectInitramfsGenerator() expected error for empty target")
+	}
+
+	writeFakeFile(t, filepath.Join(targetDir, "usr", "bin", "mkinitcpio"), "")
+	got, err := DetectInitramfsGenerator(targetDir)
+	if err != nil || got != InitramfsMkinitcpio {
+		t.Errorf("DetectInitramfsGenerator() = %v, %v, want %v", got, err, InitramfsMkinitcpio)
+	}
+
+	writeFakeFile(t, filepath.Join(targetDir, "usr", "bin", "dracut"), "")
+	got, err = DetectInitramfsGenerator(targetDir)
+	if err != nil || got != InitramfsDracut {
+		t.Errorf("DetectInitramfsGenerator() = %v, %v, want %v", got, err, InitramfsDracut)
+	}
+}
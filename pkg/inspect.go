@@ -0,0 +1,216 @@
+package pkg
+
+import (
+	"archive/tar"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+)
+
+// ImageInfo describes a container image, so it can be vetted before it is
+// installed
+type ImageInfo struct {
+	ImageRef     string            `json:"image_ref"`
+	Digest       string            `json:"digest"`
+	Created      *time.Time        `json:"created,omitempty"`
+	Platform     string            `json:"platform,omitempty"` // e.g. linux/amd64
+	Size         int64             `json:"size"`               // Compressed size of the layers
+	Layers       []ImageLayer      `json:"layers"`
+	Labels       map[string]string `json:"labels,omitempty"`
+	Annotations  map[string]string `json:"annotations,omitempty"`
+	OSRelease    map[string]string `json:"os_release,omitempty"`
+	Kernels      []string          `json:"kernels,omitempty"`       // Versions in /usr/lib/modules with a vmlinuz
+	Initramfs    []string          `json:"initramfs,omitempty"`     // Kernel versions that ship an initramfs
+	Bootloader   BootloaderType    `json:"bootloader"`              // What install would set up
+	BootBinaries []string          `json:"boot_binaries,omitempty"` // Bootloader tools and EFI binaries found
+
+	etc                imageEtc
+	initramfsGenerator InitramfsGenerator // "" if the image has none
+}
+
+// imageEtc is what lint checks in /etc of an image
+type imageEtc struct {
+	passwd, group bool
+	machineID     string
+	sshHostKeys   []string
+}
+
+// ImageLayer is one layer of an image
+type ImageLayer struct {
+	Digest    string `json:"digest"`
+	Size      int64  `json:"size"`
+	MediaType string `json:"media_type,omitempty"`
+}
+
+// bootBinaryPaths are the bootloader tools and EFI binaries install looks
+// for, relative to the image root
+var bootBinaryPaths = []string{
+	"usr/bin/bootctl",
+	"usr/sbin/grub2-install",
+	"usr/bin/grub2-install",
+	"usr/sbin/grub-install",
+	"usr/bin/grub-install",
+	"usr/lib/systemd/boot/efi/systemd-bootx64.efi",
+	"usr/lib/systemd/boot/efi/systemd-bootx64.efi.signed",
+	"usr/lib64/systemd/boot/efi/systemd-bootx64.efi",
+	"usr/lib64/systemd/boot/efi/systemd-bootx64.efi.signed",
+	"usr/lib/shim/shimx64.efi",
+	"usr/lib/shim/shimx64.efi.signed",
+	"usr/lib64/shim/shimx64.efi",
+	"usr/lib64/shim/shimx64.efi.signed",
+	"usr/share/shim/shimx64.efi.signed",
+}
+
+// InspectImage fetches the manifest and configuration of imageRef and reads
+// its filesystem from the registry without writing anything to disk. The
+// layers are downloaded, so this takes as long as a pull.
+func InspectImage(ctx context.Context, imageRef string) (*ImageInfo, error) {
+	ref, err := name.ParseReference(imageRef)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidImageReference, err)
+	}
+	img, err := remoteImage(ctx, ref)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch image: %w", registryError(err))
+	}
+
+	info := &ImageInfo{ImageRef: imageRef}
+	digest, err := img.Digest()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get image digest: %w", err)
+	}
+	info.Digest = digest.String()
+
+	manifest, err := img.Manifest()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get image manifest: %w", registryError(err))
+	}
+	info.Annotations = manifest.Annotations
+	for _, layer := range manifest.Layers {
+		info.Layers = append(info.Layers, ImageLayer{Digest: layer.Digest.String(), Size: layer.Size, MediaType: string(layer.MediaType)})
+		info.Size += layer.Size
+	}
+
+	config, err := img.ConfigFile()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get image config: %w", registryError(err))
+	}
+	info.Labels = config.Config.Labels
+	if !config.Created.IsZero() {
+		info.Created = &config.Created.Time
+	}
+	info.Platform = imagePlatform(config)
+
+	// Flattened, with whiteouts of later layers applied
+	rc := mutate.Extract(img)
+	defer func() { _ = rc.Close() }()
+	if err := info.scanFilesystem(ctx, tar.NewReader(rc)); err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+// imagePlatform formats the platform of an image config, e.g. linux/arm64/v8
+func imagePlatform(config *v1.ConfigFile) string {
+	parts := []string{config.OS, config.Architecture}
+	if config.Variant != "" {
+		parts = append(parts, config.Variant)
+	}
+	return strings.Trim(strings.Join(parts, "/"), "/")
+}
+
+// scanFilesystem fills in the os-release, kernels, boot binaries and /etc
+// details from the flattened image filesystem in tr
+func (info *ImageInfo) scanFilesystem(ctx context.Context, tr *tar.Reader) error {
+	osRelease := map[string][]byte{}
+	moduleFiles := map[string]map[string]bool{} // Files in /usr/lib/modules/<version>
+	hasBootctl := false
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return fmt.Errorf("failed to read image filesystem: %w", registryError(err))
+		}
+		p := path.Clean(strings.TrimPrefix(hdr.Name, "/"))
+		dir, base := path.Dir(p), path.Base(p)
+
+		switch {
+		case (p == "etc/os-release" || p == "usr/lib/os-release") && hdr.Typeflag == tar.TypeReg:
+			data, err := io.ReadAll(io.LimitReader(tr, 64*1024))
+			if err != nil {
+				return fmt.Errorf("failed to read %s: %w", p, err)
+			}
+			osRelease[p] = data
+		case path.Dir(dir) == "usr/lib/modules" && hdr.Typeflag != tar.TypeDir:
+			version := path.Base(dir)
+			if moduleFiles[version] == nil {
+				moduleFiles[version] = map[string]bool{}
+			}
+			moduleFiles[version][base] = true
+		case slices.Contains(bootBinaryPaths, p):
+			info.BootBinaries = append(info.BootBinaries, "/"+p)
+			hasBootctl = hasBootctl || p == "usr/bin/bootctl"
+		case slices.Contains([]string{"usr/bin", "usr/sbin", "bin", "sbin"}, dir) &&
+			slices.Contains([]InitramfsGenerator{InitramfsDracut, InitramfsMkinitcpio}, InitramfsGenerator(base)):
+			info.initramfsGenerator = InitramfsGenerator(base)
+		case p == "etc/passwd":
+			info.etc.passwd = true
+		case p == "etc/group":
+			info.etc.group = true
+		case p == "etc/machine-id" && hdr.Typeflag == tar.TypeReg:
+			data, err := io.ReadAll(io.LimitReader(tr, 256))
+			if err != nil {
+				return fmt.Errorf("failed to read %s: %w", p, err)
+			}
+			info.etc.machineID = strings.TrimSpace(string(data))
+		case dir == "etc/ssh" && strings.HasPrefix(base, "ssh_host_") && strings.HasSuffix(base, "_key"):
+			info.etc.sshHostKeys = append(info.etc.sshHostKeys, "/"+p)
+		}
+	}
+
+	// Like readOSRelease: /etc/os-release first, often a symlink to the other
+	data, ok := osRelease["etc/os-release"]
+	if !ok {
+		data = osRelease["usr/lib/os-release"]
+	}
+	if len(data) > 0 {
+		info.OSRelease = parseOSRelease(data)
+	}
+
+	// Like KernelsMissingInitramfs
+	for version, files := range moduleFiles {
+		if !files["vmlinuz"] && !files["vmlinuz-"+version] {
+			continue
+		}
+		info.Kernels = append(info.Kernels, version)
+		for _, pattern := range initramfsPatterns("", version) {
+			if files[pattern] {
+				info.Initramfs = append(info.Initramfs, version)
+				break
+			}
+		}
+	}
+	slices.Sort(info.Kernels)
+	slices.Sort(info.Initramfs)
+	slices.Sort(info.BootBinaries)
+	slices.Sort(info.etc.sshHostKeys)
+	// Same choice as DetectBootloader
+	info.Bootloader = BootloaderGRUB2
+	if hasBootctl {
+		info.Bootloader = BootloaderSystemdBoot
+	}
+	return nil
+}
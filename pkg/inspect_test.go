@@ -0,0 +1,143 @@
+package pkg
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"log"
+	"net/http/httptest"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+)
+
+// testLayer builds an image layer from path/content pairs, owned by the
+// current user. Paths ending in a slash are directories.
+func testLayer(t *testing.T, files ...string) v1.Layer {
+	t.Helper()
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for i := 0; i < len(files); i += 2 {
+		p, content := files[i], files[i+1]
+		hdr := &tar.Header{Name: p, Mode: 0644, Size: int64(len(content)), Typeflag: tar.TypeReg, Uid: os.Getuid(), Gid: os.Getgid()}
+		if strings.HasSuffix(p, "/") {
+			hdr.Mode, hdr.Typeflag = 0755, tar.TypeDir
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	layer, err := tarball.LayerFromOpener(func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(buf.Bytes())), nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return layer
+}
+
+// pushTestImage serves img from an in-memory registry and returns its reference
+func pushTestImage(t *testing.T, img v1.Image) string {
+	t.Helper()
+	server := httptest.NewServer(registry.New(registry.Logger(log.New(io.Discard, "", 0))))
+	t.Cleanup(server.Close)
+	imageRef := strings.TrimPrefix(server.URL, "http://") + "/example/os:latest"
+	ref, err := name.ParseReference(imageRef)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := remote.Write(ref, img); err != nil {
+		t.Fatalf("failed to push test image: %v", err)
+	}
+	return imageRef
+}
+
+func TestInspectImage(t *testing.T) {
+	base := testLayer(t,
+		"usr/lib/os-release", "NAME=\"Example OS\"\nPRETTY_NAME=\"Example OS 42\"\nVERSION_ID=42\n",
+		"usr/lib/modules/6.1.0/vmlinuz", "old kernel",
+		"usr/sbin/grub2-install", "#!/bin/sh",
+	)
+	update := testLayer(t,
+		"usr/lib/modules/6.1.0/.wh.vmlinuz", "",
+		"usr/lib/modules/6.2.0/vmlinuz", "new kernel",
+		"usr/lib/modules/6.2.0/modules.dep", "",
+		"usr/bin/bootctl", "bootctl",
+		"usr/lib/systemd/boot/efi/systemd-bootx64.efi", "MZ",
+	)
+	img, err := mutate.AppendLayers(empty.Image, base, update)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := img.ConfigFile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg = cfg.DeepCopy()
+	cfg.OS, cfg.Architecture = "linux", "amd64"
+	cfg.Config.Labels = map[string]string{"containers.bootc": "1"}
+	if img, err = mutate.ConfigFile(img, cfg); err != nil {
+		t.Fatal(err)
+	}
+	img = mutate.Annotations(img, map[string]string{"org.opencontainers.image.version": "42.1"}).(v1.Image)
+	imageRef := pushTestImage(t, img)
+
+	info, err := InspectImage(t.Context(), imageRef)
+	if err != nil {
+		t.Fatalf("InspectImage() error = %v", err)
+	}
+	wantDigest, _ := img.Digest()
+	if info.Digest != wantDigest.String() {
+		t.Errorf("Digest = %s, want %s", info.Digest, wantDigest)
+	}
+	if len(info.Layers) != 2 || info.Size != info.Layers[0].Size+info.Layers[1].Size || info.Size == 0 {
+		t.Errorf("Layers = %+v, Size = %d", info.Layers, info.Size)
+	}
+	if info.Platform != "linux/amd64" {
+		t.Errorf("Platform = %q, want linux/amd64", info.Platform)
+	}
+	if info.Labels["containers.bootc"] != "1" || info.Annotations["org.opencontainers.image.version"] != "42.1" {
+		t.Errorf("Labels = %v, Annotations = %v", info.Labels, info.Annotations)
+	}
+	if info.OSRelease["PRETTY_NAME"] != "Example OS 42" || info.OSRelease["VERSION_ID"] != "42" {
+		t.Errorf("OSRelease = %v", info.OSRelease)
+	}
+	// The kernel removed by the second layer is gone
+	if !slices.Equal(info.Kernels, []string{"6.2.0"}) {
+		t.Errorf("Kernels = %v, want [6.2.0]", info.Kernels)
+	}
+	if info.Bootloader != BootloaderSystemdBoot {
+		t.Errorf("Bootloader = %s, want systemd-boot", info.Bootloader)
+	}
+	wantBinaries := []string{"/usr/bin/bootctl", "/usr/lib/systemd/boot/efi/systemd-bootx64.efi", "/usr/sbin/grub2-install"}
+	if !slices.Equal(info.BootBinaries, wantBinaries) {
+		t.Errorf("BootBinaries = %v, want %v", info.BootBinaries, wantBinaries)
+	}
+}
+
+func TestInspectImageErrors(t *testing.T) {
+	if _, err := InspectImage(t.Context(), "Invalid Reference"); ErrorCode(err) != CodeInvalidImageReference {
+		t.Errorf("InspectImage(invalid) error = %v, want %s", err, CodeInvalidImageReference)
+	}
+
+	imageRef := pushTestImage(t, empty.Image)
+	missing := strings.Replace(imageRef, "example/os", "example/missing", 1)
+	if _, err := InspectImage(t.Context(), missing); ErrorCode(err) != CodeImageNotFound {
+		t.Errorf("InspectImage(missing) error = %v, want %s", err, CodeImageNotFound)
+	}
+}
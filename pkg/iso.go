@@ -0,0 +1,297 @@
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ISOVolumeLabel is the volume label of installer ISOs; the live initramfs
+// finds its root by it
+const ISOVolumeLabel = "PHUKIT_INSTALL"
+
+// Paths in the live root of an installer ISO
+const (
+	isoInstallScript  = "/usr/libexec/phukit-iso-install"
+	isoInstallService = "phukit-iso-install.service"
+)
+
+// ISOBuilder assembles a bootable installer ISO. The live system is the
+// target image itself, with phukit added and a service that installs the
+// image on boot. The image is pulled at install time, so the installer needs
+// network access.
+type ISOBuilder struct {
+	ImageRef    string
+	Output      string
+	Device      string   // Install to this disk without asking; empty to ask on the console
+	InstallArgs []string // Extra arguments for 'phukit install'
+	WorkDir     string   // Where the live root and ISO tree are assembled
+	Verbose     bool
+	DryRun      bool
+}
+
+// NewISOBuilder creates an ISOBuilder writing an interactive installer for
+// imageRef to output
+func NewISOBuilder(imageRef, output string) *ISOBuilder {
+	return &ISOBuilder{
+		ImageRef: imageRef,
+		Output:   output,
+		WorkDir:  "/var/tmp",
+	}
+}
+
+// CheckISOTools checks that the tools to build an installer ISO are installed
+func CheckISOTools() error {
+	for _, tool := range []struct{ name, pkg string }{
+		{"mksquashfs", "squashfs-tools"},
+		{"xorriso", "xorriso"},
+		{"mformat", "mtools"},
+	} {
+		if _, err := executor.LookPath(tool.name); err != nil {
+			return fmt.Errorf("%w: %s - install the %s package", ErrMissingTool, tool.name, tool.pkg)
+		}
+	}
+	if _, err := grubMkrescue(); err != nil {
+		return err
+	}
+	return nil
+}
+
+// grubMkrescue returns the name of grub-mkrescue on this host
+func grubMkrescue() (string, error) {
+	for _, name := range []string{"grub2-mkrescue", "grub-mkrescue"} {
+		if _, err := executor.LookPath(name); err == nil {
+			return name, nil
+		}
+	}
+	return "", fmt.Errorf("%w: grub-mkrescue or grub2-mkrescue - install GRUB with its EFI and BIOS modules", ErrMissingTool)
+}
+
+// Build assembles the installer ISO at b.Output
+func (b *ISOBuilder) Build(ctx context.Context, skipPull bool) error {
+	if err := CheckISOTools(); err != nil {
+		return fmt.Errorf("missing required tools: %w", err)
+	}
+	if b.DryRun {
+		fmt.Printf("[DRY RUN] Would build an installer ISO for %s at %s\n", b.ImageRef, b.Output)
+		return nil
+	}
+
+	dir, err := os.MkdirTemp(b.WorkDir, "phukit-iso-")
+	if err != nil {
+		return fmt.Errorf("failed to create work directory: %w", err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+	rootfs := filepath.Join(dir, "rootfs")
+	isoDir := filepath.Join(dir, "iso")
+
+	printStep("Step 1/4: Extracting live root...")
+	if !skipPull {
+		if err := pullImage(ctx, b.ImageRef, b.Verbose); err != nil {
+			return err
+		}
+	}
+	extractor := NewContainerExtractor(b.ImageRef, rootfs)
+	extractor.SetVerbose(b.Verbose)
+	if err := extractor.Extract(ctx); err != nil {
+		return fmt.Errorf("failed to extract container: %w", err)
+	}
+	versions, err := listKernelVersions(rootfs)
+	if err != nil || len(versions) == 0 {
+		return fmt.Errorf("the image has no kernel in /usr/lib/modules")
+	}
+	kernelVersion := versions[len(versions)-1]
+	if !targetHasCommand(rootfs, "dracut") {
+		return fmt.Errorf("the image has no dracut to build a live initramfs with")
+	}
+
+	printStep("\nStep 2/4: Adding the installer...")
+	if err := b.addInstaller(rootfs); err != nil {
+		return err
+	}
+
+	printStep("\nStep 3/4: Building live initramfs and root...")
+	if err := b.buildLiveBoot(ctx, rootfs, isoDir, kernelVersion); err != nil {
+		return err
+	}
+
+	printStep("\nStep 4/4: Writing ISO...")
+	mkrescue, err := grubMkrescue()
+	if err != nil {
+		return err
+	}
+	if out, err := runCommand(ctx, mkrescue, "-o", b.Output, isoDir, "--", "-volid", ISOVolumeLabel); err != nil {
+		_ = os.Remove(b.Output)
+		return fmt.Errorf("%s failed: %w\nOutput: %s", mkrescue, err, strings.TrimSpace(string(out)))
+	}
+	fmt.Printf("Wrote installer ISO to %s\n", b.Output)
+	return nil
+}
+
+// addInstaller copies this phukit binary into the live root and installs a
+// service that runs the install on the console at boot
+func (b *ISOBuilder) addInstaller(rootfs string) error {
+	self, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("failed to find the phukit binary: %w", err)
+	}
+	files := []struct {
+		path    string
+		content string
+		mode    os.FileMode
+	}{
+		{isoInstallScript, isoInstallScriptContent(b.ImageRef, b.Device, b.InstallArgs), 0755},
+		{"/etc/systemd/system/" + isoInstallService, isoInstallServiceContent, 0644},
+	}
+	for _, f := range files {
+		path := filepath.Join(rootfs, f.path)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return fmt.Errorf("failed to create %s: %w", filepath.Dir(f.path), err)
+		}
+		if err := os.WriteFile(path, []byte(f.content), f.mode); err != nil {
+			return fmt.Errorf("failed to write %s: %w", f.path, err)
+		}
+	}
+	if err := copyFile(self, filepath.Join(rootfs, "usr", "bin", "phukit")); err != nil {
+		return fmt.Errorf("failed to copy phukit into the live root: %w", err)
+	}
+
+	wants := filepath.Join(rootfs, "etc", "systemd", "system", "multi-user.target.wants")
+	if err := os.MkdirAll(wants, 0755); err != nil {
+		return fmt.Errorf("failed to enable %s: %w", isoInstallService, err)
+	}
+	link := filepath.Join(wants, isoInstallService)
+	_ = os.Remove(link)
+	if err := os.Symlink("../"+isoInstallService, link); err != nil {
+		return fmt.Errorf("failed to enable %s: %w", isoInstallService, err)
+	}
+	fmt.Printf("  Added phukit and %s\n", isoInstallService)
+	return nil
+}
+
+// buildLiveBoot builds an initramfs that boots from the squashfs on the ISO
+// and writes the kernel, initramfs, squashfs and GRUB configuration to isoDir
+func (b *ISOBuilder) buildLiveBoot(ctx context.Context, rootfs, isoDir, kernelVersion string) error {
+	images := filepath.Join(isoDir, "images")
+	for _, d := range []string{images, filepath.Join(isoDir, "LiveOS"), filepath.Join(isoDir, "boot", "grub"), filepath.Join(rootfs, "var", "tmp")} {
+		if err := os.MkdirAll(d, 0755); err != nil {
+			return fmt.Errorf("failed to create %s: %w", d, err)
+		}
+	}
+
+	kernel := filepath.Join(rootfs, "usr", "lib", "modules", kernelVersion, "vmlinuz")
+	if err := copyFile(kernel, filepath.Join(images, "vmlinuz")); err != nil {
+		return fmt.Errorf("failed to copy kernel %s: %w", kernelVersion, err)
+	}
+
+	const liveInitramfs = "/var/tmp/phukit-live-initramfs.img"
+	fmt.Printf("  Building live initramfs for kernel %s...\n", kernelVersion)
+	if err := chrootCommandWithProgress(ctx, rootfs, PhaseInitramfs, &initramfsProgressParser{}, "dracut",
+		"--force", "--no-hostonly", "--add", "dmsquash-live", liveInitramfs, kernelVersion); err != nil {
+		return fmt.Errorf("failed to build live initramfs: %w", err)
+	}
+	if err := os.Rename(filepath.Join(rootfs, liveInitramfs), filepath.Join(images, "initrd.img")); err != nil {
+		return fmt.Errorf("failed to move live initramfs: %w", err)
+	}
+
+	fmt.Println("  Compressing live root...")
+	if out, err := runCommand(ctx, "mksquashfs", rootfs, filepath.Join(isoDir, "LiveOS", "squashfs.img"), "-noappend", "-comp", "xz"); err != nil {
+		return fmt.Errorf("mksquashfs failed: %w\nOutput: %s", err, strings.TrimSpace(string(out)))
+	}
+
+	grubCfg := isoGrubConfig(ParseOSRelease(rootfs))
+	if err := os.WriteFile(filepath.Join(isoDir, "boot", "grub", "grub.cfg"), []byte(grubCfg), 0644); err != nil {
+		return fmt.Errorf("failed to write GRUB configuration: %w", err)
+	}
+	return nil
+}
+
+// isoGrubConfig returns the GRUB menu of an installer ISO for osName
+func isoGrubConfig(osName string) string {
+	return fmt.Sprintf(`set timeout=5
+set default=0
+
+menuentry 'Install %[1]s' {
+    linux /images/vmlinuz root=live:CDLABEL=%[2]s rd.live.image quiet
+    initrd /images/initrd.img
+}
+
+menuentry 'Install %[1]s (serial console)' {
+    linux /images/vmlinuz root=live:CDLABEL=%[2]s rd.live.image console=tty0 console=ttyS0,115200
+    initrd /images/initrd.img
+}
+`, osName, ISOVolumeLabel)
+}
+
+// isoInstallServiceContent runs the installer on the first console instead
+// of a login prompt
+const isoInstallServiceContent = `[Unit]
+Description=phukit installer
+After=network-online.target systemd-user-sessions.service
+Wants=network-online.target
+Conflicts=getty@tty1.service
+Before=getty@tty1.service
+
+[Service]
+Type=oneshot
+ExecStart=` + isoInstallScript + `
+StandardInput=tty
+StandardOutput=tty
+StandardError=tty
+TTYPath=/dev/tty1
+TTYReset=yes
+TTYVHangup=yes
+
+[Install]
+WantedBy=multi-user.target
+`
+
+// isoInstallScriptContent returns the installer script of the live system.
+// With a device it installs to it unattended and powers off; otherwise it
+// asks for the disk and whether to go ahead.
+func isoInstallScriptContent(imageRef, device string, installArgs []string) string {
+	install := Command{Name: "phukit", Args: append([]string{"install", "--image", imageRef}, installArgs...)}
+	quotedDevice := Command{Name: device}.String()
+	if device == "" {
+		quotedDevice = ""
+	}
+
+	var s strings.Builder
+	s.WriteString("#!/bin/sh\n")
+	s.WriteString("# Written by phukit build-iso\n\n")
+	fmt.Fprintf(&s, "device=%s\n", quotedDevice)
+	s.WriteString(`unattended=
+if [ -n "$device" ]; then
+	unattended=1
+else
+	phukit list
+	printf '\nInstall to which disk (for example /dev/sda)? '
+	read -r device
+fi
+
+`)
+	fmt.Fprintf(&s, "run_install() {\n\t%s --device \"$device\" \"$@\"\n}\n\n", install.String())
+	s.WriteString(`# Skip the confirmation prompt when unattended
+if [ -n "$unattended" ]; then
+	run_install --yes
+else
+	run_install
+fi
+if [ $? -eq 0 ]; then
+	echo "Installation complete."
+	if [ -n "$unattended" ]; then
+		systemctl poweroff
+	else
+		printf 'Remove the installation media and press Enter to reboot. '
+		read -r _
+		systemctl reboot
+	fi
+else
+	echo "Installation failed. Starting a shell."
+	exec /bin/sh -l
+fi
+`)
+	return s.String()
+}
@@ -0,0 +1,59 @@
+package pkg
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestISOInstallScriptContent(t *testing.T) {
+	tests := []struct {
+		name     string
+		device   string
+		contains []string
+	}{
+		{
+			name:   "interactive",
+			device: "",
+			contains: []string{
+				"device=\n",
+				"phukit list",
+				"\tphukit install --image quay.io/example/os:latest --karg 'console=ttyS0 quiet' --device \"$device\" \"$@\"\n",
+			},
+		},
+		{
+			name:   "unattended",
+			device: "/dev/sda",
+			contains: []string{
+				"device=/dev/sda\n",
+				"run_install --yes\n",
+				"systemctl poweroff",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := isoInstallScriptContent("quay.io/example/os:latest", tt.device, []string{"--karg", "console=ttyS0 quiet"})
+			if !strings.HasPrefix(got, "#!/bin/sh\n") {
+				t.Errorf("script does not start with a shebang:\n%s", got)
+			}
+			for _, want := range tt.contains {
+				if !strings.Contains(got, want) {
+					t.Errorf("script does not contain %q:\n%s", want, got)
+				}
+			}
+		})
+	}
+}
+
+func TestISOGrubConfig(t *testing.T) {
+	got := isoGrubConfig("Fedora Linux 42")
+	for _, want := range []string{
+		"menuentry 'Install Fedora Linux 42' {",
+		"linux /images/vmlinuz root=live:CDLABEL=" + ISOVolumeLabel + " rd.live.image",
+		"initrd /images/initrd.img",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("grub.cfg does not contain %q:\n%s", want, got)
+		}
+	}
+}
@@ -0,0 +1,229 @@
+package pkg
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// JournalSocket is the systemd-journald native protocol socket
+const JournalSocket = "/run/systemd/journal/socket"
+
+// JournalIdentifier is the SYSLOG_IDENTIFIER of records sent to the journal
+const JournalIdentifier = "phukit"
+
+// Journal logging modes accepted by ParseJournalMode
+const (
+	JournalAuto   = "auto"   // Log to the journal when running as a systemd service
+	JournalAlways = "always" // Log to the journal if it is available
+	JournalNever  = "never"
+)
+
+// ParseJournalMode validates a --log-journal value
+func ParseJournalMode(mode string) (string, error) {
+	switch mode {
+	case "", JournalAuto:
+		return JournalAuto, nil
+	case JournalAlways, JournalNever:
+		return mode, nil
+	}
+	return "", fmt.Errorf("invalid journal mode %q (supported: auto, always, never)", mode)
+}
+
+// RunningUnderSystemd reports whether phukit was started by systemd as part
+// of a unit, such as an update timer
+func RunningUnderSystemd() bool {
+	return os.Getenv("INVOCATION_ID") != "" || os.Getenv("JOURNAL_STREAM") != ""
+}
+
+// JournalAvailable reports whether systemd-journald is accepting native
+// protocol messages
+func JournalAvailable() bool {
+	info, err := os.Stat(JournalSocket)
+	return err == nil && info.Mode()&os.ModeSocket != 0
+}
+
+// JournalHandler is a slog.Handler that sends records to systemd-journald.
+// Attributes become journal fields with upper-cased names, so "step" and
+// "image" can be queried as STEP= and IMAGE= with journalctl.
+type JournalHandler struct {
+	conn   *journalConn
+	level  slog.Leveler
+	prefix string // Field name prefix from WithGroup
+	fields []byte // Fields pre-encoded by WithAttrs
+}
+
+// journalConn is the socket shared by a handler and its derived handlers
+type journalConn struct {
+	mu   sync.Mutex
+	conn *net.UnixConn
+	addr *net.UnixAddr
+}
+
+// NewJournalHandler connects to the journal and returns a handler for records
+// at level and above
+func NewJournalHandler(level slog.Leveler) (*JournalHandler, error) {
+	return newJournalHandler(JournalSocket, level)
+}
+
+// newJournalHandler returns a handler sending to the socket at path
+func newJournalHandler(path string, level slog.Leveler) (*JournalHandler, error) {
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Net: "unixgram"})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open journal socket: %w", err)
+	}
+	addr := &net.UnixAddr{Name: path, Net: "unixgram"}
+	h := &JournalHandler{conn: &journalConn{conn: conn, addr: addr}, level: level}
+	h.fields = appendJournalField(h.fields, "SYSLOG_IDENTIFIER", JournalIdentifier)
+	return h, nil
+}
+
+// Enabled implements slog.Handler
+func (h *JournalHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.level.Level()
+}
+
+// Handle implements slog.Handler
+func (h *JournalHandler) Handle(_ context.Context, r slog.Record) error {
+	msg := make([]byte, 0, 256+len(h.fields))
+	msg = appendJournalField(msg, "MESSAGE", r.Message)
+	msg = appendJournalField(msg, "PRIORITY", journalPriority(r.Level))
+	msg = append(msg, h.fields...)
+	r.Attrs(func(a slog.Attr) bool {
+		msg = appendJournalAttr(msg, h.prefix, a)
+		return true
+	})
+	return h.conn.send(msg)
+}
+
+// WithAttrs implements slog.Handler
+func (h *JournalHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	h2 := *h
+	h2.fields = append([]byte{}, h.fields...)
+	for _, a := range attrs {
+		h2.fields = appendJournalAttr(h2.fields, h.prefix, a)
+	}
+	return &h2
+}
+
+// WithGroup implements slog.Handler
+func (h *JournalHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	h2 := *h
+	h2.prefix = h.prefix + name + "_"
+	return &h2
+}
+
+// Close closes the journal socket
+func (h *JournalHandler) Close() error {
+	return h.conn.conn.Close()
+}
+
+// send writes one entry. Entries too large for a datagram are passed to
+// journald in a sealed memfd, as sd_journal_send does.
+func (c *journalConn) send(msg []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	_, err := c.conn.WriteToUnix(msg, c.addr)
+	if err == nil || !errors.Is(err, syscall.EMSGSIZE) && !errors.Is(err, syscall.ENOBUFS) {
+		return err
+	}
+
+	fd, err := unix.MemfdCreate("journal-message", unix.MFD_CLOEXEC|unix.MFD_ALLOW_SEALING)
+	if err != nil {
+		return fmt.Errorf("failed to create memfd for journal entry: %w", err)
+	}
+	f := os.NewFile(uintptr(fd), "journal-message")
+	defer func() { _ = f.Close() }()
+	if _, err := f.Write(msg); err != nil {
+		return fmt.Errorf("failed to write journal entry: %w", err)
+	}
+	if _, err := unix.FcntlInt(f.Fd(), unix.F_ADD_SEALS, unix.F_SEAL_SHRINK|unix.F_SEAL_GROW|unix.F_SEAL_WRITE|unix.F_SEAL_SEAL); err != nil {
+		return fmt.Errorf("failed to seal journal entry: %w", err)
+	}
+	_, _, err = c.conn.WriteMsgUnix(nil, unix.UnixRights(int(f.Fd())), c.addr)
+	return err
+}
+
+// journalPriority maps a slog level to a syslog priority
+func journalPriority(level slog.Level) string {
+	switch {
+	case level >= slog.LevelError:
+		return "3"
+	case level >= slog.LevelWarn:
+		return "4"
+	case level >= slog.LevelInfo:
+		return "6"
+	default:
+		return "7"
+	}
+}
+
+// appendJournalAttr encodes a as one field, or one field per member of a group
+func appendJournalAttr(b []byte, prefix string, a slog.Attr) []byte {
+	a.Value = a.Value.Resolve()
+	if a.Equal(slog.Attr{}) {
+		return b
+	}
+	if a.Value.Kind() == slog.KindGroup {
+		if a.Key != "" {
+			prefix += a.Key + "_"
+		}
+		for _, ga := range a.Value.Group() {
+			b = appendJournalAttr(b, prefix, ga)
+		}
+		return b
+	}
+	var value string
+	switch v := a.Value.Any().(type) {
+	case []string:
+		value = strings.Join(v, " ")
+	default:
+		value = a.Value.String()
+	}
+	return appendJournalField(b, journalFieldName(prefix+a.Key), value)
+}
+
+// journalFieldName converts key to a valid journal field name: upper-case
+// letters, digits and underscores, not starting with an underscore or digit
+func journalFieldName(key string) string {
+	name := []byte(strings.ToUpper(key))
+	for i, c := range name {
+		if (c < 'A' || c > 'Z') && (c < '0' || c > '9') {
+			name[i] = '_'
+		}
+	}
+	s := strings.TrimLeft(string(name), "_0123456789")
+	if s == "" {
+		return "FIELD"
+	}
+	return s
+}
+
+// appendJournalField encodes one field in the native protocol. Values with a
+// newline use the binary form with an explicit length.
+func appendJournalField(b []byte, name, value string) []byte {
+	if !strings.Contains(value, "\n") {
+		b = append(b, name...)
+		b = append(b, '=')
+		b = append(b, value...)
+		return append(b, '\n')
+	}
+	b = append(b, name...)
+	b = append(b, '\n')
+	b = binary.LittleEndian.AppendUint64(b, uint64(len(value)))
+	b = append(b, value...)
+	return append(b, '\n')
+}
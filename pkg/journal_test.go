@@ -0,0 +1,115 @@
+package pkg
+
+import (
+	"bytes"
+	"encoding/binary"
+	"log/slog"
+	"net"
+	"path/filepath"
+	"testing"
+)
+
+// decodeJournalFields decodes a native protocol entry
+func decodeJournalFields(t *testing.T, msg []byte) map[string]string {
+	t.Helper()
+	fields := map[string]string{}
+	for len(msg) > 0 {
+		nl := bytes.IndexByte(msg, '\n')
+		if nl < 0 {
+			t.Fatalf("unterminated field %q", msg)
+		}
+		line := msg[:nl]
+		if name, value, ok := bytes.Cut(line, []byte("=")); ok {
+			fields[string(name)] = string(value)
+			msg = msg[nl+1:]
+			continue
+		}
+		n := int(binary.LittleEndian.Uint64(msg[nl+1 : nl+9]))
+		fields[string(line)] = string(msg[nl+9 : nl+9+n])
+		msg = msg[nl+9+n+1:]
+	}
+	return fields
+}
+
+func TestJournalHandler(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "socket")
+	server, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = server.Close() }()
+
+	h, err := newJournalHandler(path, slog.LevelInfo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = h.Close() }()
+
+	SetLogger(slog.New(h).With("phase", "update"))
+	t.Cleanup(func() { SetLogger(nil) })
+	restore := withLogAttrs("image", "quay.io/example/os:latest", "target", "/dev/sda3")
+	printStep("\nStep 2/7: Clearing old content from target partition...")
+	logger.Debug("not sent")
+	restore()
+	logger.Warn("line one\nline two", "strict", false)
+
+	buf := make([]byte, 4096)
+	n, err := server.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fields := decodeJournalFields(t, buf[:n])
+	want := map[string]string{
+		"MESSAGE":           "Step 2/7: Clearing old content from target partition...",
+		"PRIORITY":          "6",
+		"SYSLOG_IDENTIFIER": "phukit",
+		"PHASE":             "update",
+		"IMAGE":             "quay.io/example/os:latest",
+		"TARGET":            "/dev/sda3",
+		"STEP":              "2/7",
+	}
+	for k, v := range want {
+		if fields[k] != v {
+			t.Errorf("%s = %q, want %q", k, fields[k], v)
+		}
+	}
+
+	n, err = server.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fields = decodeJournalFields(t, buf[:n])
+	if fields["MESSAGE"] != "line one\nline two" || fields["PRIORITY"] != "4" || fields["STRICT"] != "false" {
+		t.Errorf("unexpected warning entry: %v", fields)
+	}
+	if _, ok := fields["IMAGE"]; ok {
+		t.Errorf("IMAGE still set after restore: %v", fields)
+	}
+}
+
+func TestJournalFieldName(t *testing.T) {
+	tests := map[string]string{
+		"step":       "STEP",
+		"error_code": "ERROR_CODE",
+		"dry-run":    "DRY_RUN",
+		"_private":   "PRIVATE",
+		"1st":        "ST",
+		"__":         "FIELD",
+	}
+	for key, want := range tests {
+		if got := journalFieldName(key); got != want {
+			t.Errorf("journalFieldName(%q) = %q, want %q", key, got, want)
+		}
+	}
+}
+
+func TestParseJournalMode(t *testing.T) {
+	for _, mode := range []string{"", "auto", "always", "never"} {
+		if _, err := ParseJournalMode(mode); err != nil {
+			t.Errorf("ParseJournalMode(%q) failed: %v", mode, err)
+		}
+	}
+	if _, err := ParseJournalMode("sometimes"); err == nil {
+		t.Error("expected an error for an unknown mode")
+	}
+}
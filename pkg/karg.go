@@ -0,0 +1,201 @@
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// Persistent kernel arguments
+//
+// The kernel arguments given at install are recorded in the system config,
+// and every update and rollback puts them on the boot entries of both roots.
+// EditKernelArgs changes them on an installed system: the new list is
+// recorded in the config of both roots, so later updates keep it, and both
+// boot entries are rewritten right away, keeping the default one, so the
+// change applies on the next boot without an update. The arguments phukit
+// generates itself, such as root=, the /var mount and those that open the
+// storage of the roots, are not recorded, as every entry gets them anew, and
+// cannot be edited.
+
+// managedKernelArgs are the keys of the arguments phukit generates for every
+// boot entry
+var managedKernelArgs = []string{"root", "rw", "ro", "resume", "rd.lvm.vg", "rd.luks.uuid", "systemd.mount-extra", "rd.systemd.mount-extra", "netroot", "ip", "rd.neednet"}
+
+// managedKernelArgPrefixes are the key prefixes of the dm-verity and network
+// root arguments phukit generates
+var managedKernelArgPrefixes = []string{"systemd.verity", "rd.iscsi.", "rd.nvmf."}
+
+// isManagedKernelArg reports whether arg, or a key without =value, is
+// generated by phukit
+func isManagedKernelArg(arg string) bool {
+	key, _, _ := strings.Cut(arg, "=")
+	return slices.Contains(managedKernelArgs, key) ||
+		slices.ContainsFunc(managedKernelArgPrefixes, func(prefix string) bool { return strings.HasPrefix(key, prefix) })
+}
+
+// UserKernelArgs returns args without the arguments phukit generates
+func UserKernelArgs(args []string) []string {
+	kept := []string{}
+	for _, arg := range args {
+		if !isManagedKernelArg(arg) {
+			kept = append(kept, arg)
+		}
+	}
+	return kept
+}
+
+// KernelArgEdit adds and removes persistent kernel arguments
+type KernelArgEdit struct {
+	Add    []string // Arguments to append, unless already present
+	Remove []string // Arguments to remove; a key without =value removes every value of it
+}
+
+// apply returns args with the removals and then the additions of e applied
+func (e KernelArgEdit) apply(args []string) []string {
+	kept := []string{}
+	for _, arg := range args {
+		if !slices.ContainsFunc(e.Remove, func(pattern string) bool { return kernelArgMatches(arg, pattern) }) {
+			kept = append(kept, arg)
+		}
+	}
+	return mergeKernelArgs(kept, e.Add)
+}
+
+// kernelArgMatches reports whether arg is pattern or, if pattern has no
+// value, whether it has the key pattern
+func kernelArgMatches(arg, pattern string) bool {
+	if arg == pattern {
+		return true
+	}
+	key, _, _ := strings.Cut(arg, "=")
+	return !strings.Contains(pattern, "=") && key == pattern
+}
+
+// mergeKernelArgs returns args followed by the arguments of extra that args
+// does not have yet
+func mergeKernelArgs(args, extra []string) []string {
+	merged := slices.Clone(args)
+	for _, arg := range extra {
+		if !slices.Contains(merged, arg) {
+			merged = append(merged, arg)
+		}
+	}
+	return merged
+}
+
+// checkKernelArg checks that arg is a single argument that phukit does not
+// generate itself
+func checkKernelArg(arg string) error {
+	if arg == "" || strings.ContainsAny(arg, " \t\n\"'") {
+		return fmt.Errorf("invalid kernel argument %q: must be a single unquoted argument", arg)
+	}
+	if isManagedKernelArg(arg) {
+		key, _, _ := strings.Cut(arg, "=")
+		return fmt.Errorf("kernel argument %s is set by phukit and cannot be changed", key)
+	}
+	return nil
+}
+
+// EditKernelArgs applies edit to the persistent kernel arguments, records
+// them in the system config of both roots and rewrites both boot entries. It
+// returns the new arguments and whether they changed.
+func (u *SystemUpdater) EditKernelArgs(ctx context.Context, edit KernelArgEdit) ([]string, bool, error) {
+	for _, arg := range slices.Concat(edit.Add, edit.Remove) {
+		if err := checkKernelArg(arg); err != nil {
+			return nil, false, err
+		}
+	}
+	if err := u.PrepareUpdate(); err != nil {
+		return nil, false, err
+	}
+	config, err := ReadSystemConfig()
+	if err != nil {
+		return nil, false, err
+	}
+	if u.Config.Verity {
+		return nil, false, fmt.Errorf("the kernel arguments of a dm-verity root are sealed with it; change them with an update")
+	}
+	u.Config.FilesystemType = config.FilesystemType
+
+	// Older installs recorded the generated arguments as well, which are
+	// dropped with the first edit
+	current := UserKernelArgs(config.KernelArgs)
+	args := edit.apply(current)
+	if slices.Equal(args, current) {
+		fmt.Println("Kernel arguments unchanged")
+		return args, false, nil
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, false, err
+	}
+	defer u.runCommands()()
+	if u.Config.DryRun {
+		fmt.Printf("[DRY RUN] Would set kernel arguments to: %s\n", strings.Join(args, " "))
+		return args, true, nil
+	}
+
+	defaultUUID, err := u.defaultRootUUID()
+	if err != nil {
+		return nil, false, err
+	}
+	targetUUID, err := GetPartitionUUID(u.Target)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to get UUID of %s: %w", u.Target, err)
+	}
+	staged := defaultUUID == targetUUID
+
+	if err := u.setInactiveRootKernelArgs(args); err != nil {
+		if werr := warnf("%v; %s will boot with the new arguments but an update from it drops them", err, u.Target); werr != nil {
+			return nil, false, werr
+		}
+	}
+	if err := SetSystemConfigKernelArgs(args, false); err != nil {
+		return nil, false, err
+	}
+	u.Config.SystemKernelArgs = args
+
+	// The boot entries are named after the OS of the default root
+	defaultRoot := u.activeRoot()
+	if staged {
+		defaultRoot = u.Target
+	}
+	if err := mountDevice(defaultRoot, u.Config.MountPoint, true); err != nil {
+		return nil, false, fmt.Errorf("failed to mount %s: %w", defaultRoot, err)
+	}
+	defer func() { _ = unmount(u.Config.MountPoint) }()
+
+	fmt.Println("Updating bootloader...")
+	if staged {
+		err = u.UpdateBootloader()
+	} else {
+		err = u.bootloaderForActiveRoot()
+	}
+	return args, err == nil, err
+}
+
+// setInactiveRootKernelArgs records args in the system config of the
+// inactive root, if it has a deployment
+func (u *SystemUpdater) setInactiveRootKernelArgs(args []string) error {
+	if err := os.MkdirAll(u.Config.MountPoint, 0755); err != nil {
+		return fmt.Errorf("failed to create mount point: %w", err)
+	}
+	if err := mountDevice(u.Target, u.Config.MountPoint, false); err != nil {
+		return fmt.Errorf("failed to mount %s: %w", u.Target, err)
+	}
+	defer func() { _ = unmount(u.Config.MountPoint) }()
+
+	path := filepath.Join(u.Config.MountPoint, SystemConfigFile)
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return nil
+	}
+	config, err := readSystemConfigFile(path)
+	if err != nil {
+		return err
+	}
+	config.KernelArgs = args
+	return WriteSystemConfigToTarget(u.Config.MountPoint, config, false)
+}
@@ -0,0 +1,133 @@
+package pkg
+
+import (
+	"reflect"
+	"slices"
+	"testing"
+)
+
+func TestKernelArgEditApply(t *testing.T) {
+	args := []string{"console=tty0", "console=ttyS0,115200n8", "quiet", "systemd.unified_cgroup_hierarchy=0"}
+
+	tests := []struct {
+		name string
+		edit KernelArgEdit
+		want []string
+	}{
+		{
+			name: "add",
+			edit: KernelArgEdit{Add: []string{"splash"}},
+			want: []string{"console=tty0", "console=ttyS0,115200n8", "quiet", "systemd.unified_cgroup_hierarchy=0", "splash"},
+		},
+		{
+			name: "add present",
+			edit: KernelArgEdit{Add: []string{"quiet"}},
+			want: args,
+		},
+		{
+			name: "remove exact",
+			edit: KernelArgEdit{Remove: []string{"console=tty0"}},
+			want: []string{"console=ttyS0,115200n8", "quiet", "systemd.unified_cgroup_hierarchy=0"},
+		},
+		{
+			name: "remove key",
+			edit: KernelArgEdit{Remove: []string{"console"}},
+			want: []string{"quiet", "systemd.unified_cgroup_hierarchy=0"},
+		},
+		{
+			name: "replace value",
+			edit: KernelArgEdit{Remove: []string{"systemd.unified_cgroup_hierarchy"}, Add: []string{"systemd.unified_cgroup_hierarchy=1"}},
+			want: []string{"console=tty0", "console=ttyS0,115200n8", "quiet", "systemd.unified_cgroup_hierarchy=1"},
+		},
+		{
+			name: "remove missing",
+			edit: KernelArgEdit{Remove: []string{"nomodeset", "console=ttyS1"}},
+			want: args,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.edit.apply(args); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("apply() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	if got := (KernelArgEdit{Remove: []string{"quiet"}}).apply([]string{"quiet"}); got == nil || len(got) != 0 {
+		t.Errorf("apply() removing every argument = %#v, want an empty list", got)
+	}
+}
+
+func TestMergeKernelArgs(t *testing.T) {
+	base := []string{"console=ttyS0", "quiet"}
+	got := mergeKernelArgs(base, []string{"quiet", "splash"})
+	want := []string{"console=ttyS0", "quiet", "splash"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("mergeKernelArgs() = %q, want %q", got, want)
+	}
+	if len(base) != 2 {
+		t.Errorf("mergeKernelArgs() modified its input: %q", base)
+	}
+}
+
+func TestCheckKernelArg(t *testing.T) {
+	for _, arg := range []string{"console=ttyS0,115200n8", "quiet", "cgroup_no_v1=all", "rd.break"} {
+		if err := checkKernelArg(arg); err != nil {
+			t.Errorf("checkKernelArg(%q) error = %v", arg, err)
+		}
+	}
+	for _, arg := range []string{"", "a b", `init="/bin/sh"`, "root=/dev/sda2", "rw", "resume=UUID=x", "rd.lvm.vg=vg0", "systemd.mount-extra=x", "systemd.verity_root_data=x", "rd.luks.uuid=x", "netroot=iscsi:x", "ip=dhcp", "rd.neednet=1", "rd.iscsi.initiator=x", "rd.nvmf.hostnqn=x", "rd.iscsi.firmware"} {
+		if err := checkKernelArg(arg); err == nil {
+			t.Errorf("checkKernelArg(%q) should fail", arg)
+		}
+	}
+}
+
+func TestUserKernelArgs(t *testing.T) {
+	args := []string{"root=UUID=aaaa", "rw", "quiet", "resume=UUID=bbbb", "rd.lvm.vg=phukit", "rd.luks.uuid=cccc", "netroot=iscsi:192.0.2.1::::iqn.2024-01.com.example:root", "ip=dhcp", "rd.neednet=1", "rd.iscsi.initiator=iqn.2024-01.com.example:host", "console=ttyS0"}
+	want := []string{"quiet", "console=ttyS0"}
+	if got := UserKernelArgs(args); !reflect.DeepEqual(got, want) {
+		t.Errorf("UserKernelArgs() = %q, want %q", got, want)
+	}
+	if got := UserKernelArgs(nil); got == nil || len(got) != 0 {
+		t.Errorf("UserKernelArgs(nil) = %#v, want an empty list", got)
+	}
+}
+
+func TestUpdateEntryKernelArgs(t *testing.T) {
+	// The kernel arguments of an install that hibernates to swap on LVM,
+	// with an encrypted root
+	generated := slices.Concat(resumeKernelArgs("bbbb"), lvmKernelArgs("phukit"), luksKernelArgs([]LUKSVolume{{UUID: "cccc", Role: "root1"}}))
+	installArgs := append([]string{"quiet", "console=ttyS0"}, generated...)
+
+	for name, recorded := range map[string][]string{
+		"recorded":    UserKernelArgs(installArgs),
+		"old install": installArgs,
+	} {
+		t.Run(name, func(t *testing.T) {
+			u := NewSystemUpdater("/dev/sda", "quay.io/example/os:latest")
+			u.Config.MountPoint = t.TempDir()
+			u.Config.VolumeGroup = "phukit"
+			u.Config.LUKSArgs = luksKernelArgs([]LUKSVolume{{UUID: "cccc", Role: "root1"}})
+			u.applySystemConfig(&SystemConfig{KernelArgs: recorded, ResumeUUID: "bbbb"})
+			u.AddKernelArg("quiet")
+
+			got := u.entryKernelArgs("dddd", nil, "UUID=eeee", "ext4", "", []string{"quiet"}, u.Config.KernelArgs)
+			for _, arg := range append(generated, "root=UUID=dddd", "quiet", "console=ttyS0") {
+				if n := countKernelArg(got, arg); n != 1 {
+					t.Errorf("entry has %s %d times, want once: %q", arg, n, got)
+				}
+			}
+		})
+	}
+}
+
+func countKernelArg(args []string, arg string) int {
+	n := 0
+	for _, a := range args {
+		if a == arg {
+			n++
+		}
+	}
+	return n
+}
@@ -0,0 +1,488 @@
+package pkg
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Kickstart is an install described by a kickstart file. phukit reads the
+// subset of kickstart that applies to image-based installs, so provisioning
+// content written for Anaconda can be reused:
+//
+//	ostreecontainer --url=quay.io/example/image:latest
+//	ignoredisk --only-use=nvme0n1
+//	targetdisk --min-size=100G --model="Samsung*"   # phukit extension
+//	bootloader --append="console=ttyS0"
+//	rootpw --iscrypted $6$...
+//	user --name=admin --groups=wheel --iscrypted --password=$6$...
+//	sshkey --username=admin "ssh-ed25519 AAAA..."
+//	network --bootproto=static --device=eth0 --ip=10.0.0.5 --netmask=255.255.255.0 --gateway=10.0.0.1 --nameserver=10.0.0.1 --hostname=web1
+//	timezone Europe/Berlin --utc
+//	lang en_US.UTF-8
+//	services --enabled=sshd
+//	part swap --size=16384 (or --hibernation)
+//	part /var --grow
+//	autopart --type=lvm
+//	reboot
+//
+// Partitioning commands other than a swap partition, growing /var and the
+// choice of the LVM layout are ignored, as phukit always installs its own A/B layout, and
+// so are %pre, %post and %packages sections.
+type Kickstart struct {
+	ImageRef     string
+	Disk         DiskSelector
+	KernelArgs   []string
+	EnableUnits  []string
+	Provisioning Provisioning
+	SwapSize     uint64   // Size of the swap partition in bytes, 0 for none
+	Hibernate    bool     // Size the swap partition to hold all of RAM
+	LVM          bool     // Install the roots, /var and swap on logical volumes
+	GrowVar      bool     // Grow /var into the rest of the disk on boot
+	Reboot       bool     // Reboot when the install is done
+	Ignored      []string // Commands and sections that have no effect
+}
+
+// kickstartIgnored are commands that do not apply to phukit installs
+var kickstartIgnored = map[string]bool{
+	"clearpart": true, "zerombr": true,
+	"volgroup": true, "logvol": true, "raid": true, "reqpart": true,
+	"text": true, "graphical": true, "cmdline": true, "install": true,
+	"keyboard": true, "firstboot": true,
+	"selinux": true, "firewall": true, "eula": true, "skipx": true,
+	"poweroff": true, "shutdown": true, "halt": true,
+}
+
+// LoadKickstart reads and parses the kickstart file at src, a path or an
+// http(s) URL
+func LoadKickstart(ctx context.Context, src string) (*Kickstart, error) {
+	data, err := readConfigSource(ctx, src)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read kickstart: %w", err)
+	}
+	ks, err := ParseKickstart(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("invalid kickstart %s: %w", src, err)
+	}
+	return ks, nil
+}
+
+// readConfigSource returns the contents of src, a path or an http(s) URL
+func readConfigSource(ctx context.Context, src string) ([]byte, error) {
+	if !strings.HasPrefix(src, "http://") && !strings.HasPrefix(src, "https://") {
+		return os.ReadFile(src)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, src, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET %s: %s", src, resp.Status)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+// ParseKickstart parses a kickstart file
+func ParseKickstart(r io.Reader) (*Kickstart, error) {
+	ks := &Kickstart{}
+	scanner := bufio.NewScanner(r)
+	section := ""
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if section != "" {
+			if line == "%end" {
+				section = ""
+			}
+			continue
+		}
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if strings.HasPrefix(line, "%") {
+			section = strings.Fields(line)[0]
+			ks.Ignored = append(ks.Ignored, section)
+			continue
+		}
+
+		args, err := splitKickstartLine(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", n, err)
+		}
+		if err := ks.apply(args[0], args[1:]); err != nil {
+			return nil, fmt.Errorf("line %d: %s: %w", n, args[0], err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if section != "" {
+		return nil, fmt.Errorf("%s section has no %%end", section)
+	}
+	return ks, nil
+}
+
+// apply applies one kickstart command
+func (ks *Kickstart) apply(command string, args []string) error {
+	switch command {
+	case "ostreecontainer":
+		opts, _, err := parseKickstartOptions(args, "no-signature-verification")
+		if err != nil {
+			return err
+		}
+		if t := opts["transport"]; t != "" && t != "registry" {
+			return fmt.Errorf("unsupported transport %q (supported: registry)", t)
+		}
+		if ks.ImageRef = opts["url"]; ks.ImageRef == "" {
+			return fmt.Errorf("--url is required")
+		}
+
+	case "ignoredisk":
+		opts, _, err := parseKickstartOptions(args)
+		if err != nil {
+			return err
+		}
+		if opts["only-use"] == "" {
+			return fmt.Errorf("only --only-use is supported")
+		}
+		ks.Disk.Names = append(ks.Disk.Names, strings.Split(opts["only-use"], ",")...)
+
+	case "targetdisk":
+		opts, _, err := parseKickstartOptions(args)
+		if err != nil {
+			return err
+		}
+		for name, value := range opts {
+			switch name {
+			case "min-size", "max-size":
+				size, err := ParseSize(value)
+				if err != nil {
+					return err
+				}
+				if name == "min-size" {
+					ks.Disk.MinSize = uint64(size)
+				} else {
+					ks.Disk.MaxSize = uint64(size)
+				}
+			case "model":
+				ks.Disk.Model = value
+			case "serial":
+				ks.Disk.Serial = value
+			default:
+				return fmt.Errorf("unknown option --%s", name)
+			}
+		}
+
+	case "bootloader":
+		opts, _, err := parseKickstartOptions(args)
+		if err != nil {
+			return err
+		}
+		ks.KernelArgs = append(ks.KernelArgs, strings.Fields(opts["append"])...)
+
+	case "rootpw":
+		opts, pos, err := parseKickstartOptions(args, "iscrypted", "plaintext", "lock", "allow-ssh")
+		if err != nil {
+			return err
+		}
+		root := ks.Provisioning.User("root")
+		switch {
+		case opts["lock"] != "" && len(pos) == 0:
+			root.PasswordHash = "!"
+		case len(pos) != 1:
+			return fmt.Errorf("expected one password")
+		case opts["iscrypted"] == "":
+			return fmt.Errorf("only --iscrypted passwords are supported")
+		default:
+			root.PasswordHash = pos[0]
+		}
+
+	case "user":
+		opts, _, err := parseKickstartOptions(args, "iscrypted", "plaintext", "lock")
+		if err != nil {
+			return err
+		}
+		if opts["name"] == "" {
+			return fmt.Errorf("--name is required")
+		}
+		if opts["password"] != "" && opts["iscrypted"] == "" {
+			return fmt.Errorf("only --iscrypted passwords are supported")
+		}
+		u := ks.Provisioning.User(opts["name"])
+		u.PasswordHash = opts["password"]
+		if opts["lock"] != "" {
+			u.PasswordHash = "!"
+		}
+		if opts["groups"] != "" {
+			u.Groups = append(u.Groups, strings.Split(opts["groups"], ",")...)
+		}
+
+	case "sshkey":
+		opts, pos, err := parseKickstartOptions(args)
+		if err != nil {
+			return err
+		}
+		if opts["username"] == "" || len(pos) != 1 {
+			return fmt.Errorf("expected --username and one key")
+		}
+		u := ks.Provisioning.User(opts["username"])
+		u.SSHKeys = append(u.SSHKeys, pos[0])
+
+	case "network":
+		return ks.applyNetwork(args)
+
+	case "services":
+		opts, _, err := parseKickstartOptions(args)
+		if err != nil {
+			return err
+		}
+		for _, service := range strings.Split(opts["enabled"], ",") {
+			if service == "" {
+				continue
+			}
+			if !strings.Contains(service, ".") {
+				service += ".service"
+			}
+			ks.EnableUnits = append(ks.EnableUnits, service)
+		}
+		if opts["disabled"] != "" {
+			ks.Ignored = append(ks.Ignored, "services --disabled")
+		}
+
+	case "timezone":
+		_, pos, err := parseKickstartOptions(args, "utc", "isUtc", "nontp")
+		if err != nil {
+			return err
+		}
+		if len(pos) != 1 {
+			return fmt.Errorf("expected one timezone")
+		}
+		return ks.Provisioning.SetTimezone(pos[0])
+
+	case "lang":
+		_, pos, err := parseKickstartOptions(args)
+		if err != nil {
+			return err
+		}
+		if len(pos) != 1 {
+			return fmt.Errorf("expected one language")
+		}
+		return ks.Provisioning.SetLocale(pos[0])
+
+	case "part", "partition":
+		// Only a swap partition can be added to the phukit layout, and /var
+		// can be grown into the rest of the disk
+		if len(args) > 0 && args[0] == "/var" {
+			opts, _, err := parseKickstartOptions(args[1:], "grow", "asprimary")
+			if err != nil {
+				return err
+			}
+			if opts["grow"] == "" {
+				ks.Ignored = append(ks.Ignored, command)
+				return nil
+			}
+			ks.GrowVar = true
+			return nil
+		}
+		if len(args) == 0 || args[0] != "swap" {
+			ks.Ignored = append(ks.Ignored, command)
+			return nil
+		}
+		opts, _, err := parseKickstartOptions(args[1:], "hibernation", "recommended", "grow", "asprimary")
+		if err != nil {
+			return err
+		}
+		switch {
+		case opts["hibernation"] != "":
+			ks.Hibernate = true
+		case opts["size"] != "":
+			mib, err := strconv.ParseUint(opts["size"], 10, 64)
+			if err != nil || mib == 0 {
+				return fmt.Errorf("invalid swap size %q", opts["size"])
+			}
+			ks.SwapSize = mib * 1024 * 1024
+		default:
+			return fmt.Errorf("swap needs --size or --hibernation")
+		}
+
+	case "autopart":
+		// Only the LVM layout can be chosen
+		opts, _, err := parseKickstartOptions(args, "encrypted", "nohome", "noboot", "noswap", "nolvm")
+		if err != nil {
+			return err
+		}
+		if opts["type"] != "lvm" {
+			ks.Ignored = append(ks.Ignored, command)
+			return nil
+		}
+		ks.LVM = true
+
+	case "reboot":
+		ks.Reboot = true
+
+	default:
+		if !kickstartIgnored[command] {
+			return fmt.Errorf("unsupported command")
+		}
+		ks.Ignored = append(ks.Ignored, command)
+	}
+	return nil
+}
+
+// applyNetwork applies a network command
+func (ks *Kickstart) applyNetwork(args []string) error {
+	opts, _, err := parseKickstartOptions(args, "activate", "onboot", "noipv6", "nodefroute")
+	if err != nil {
+		return err
+	}
+	if opts["hostname"] != "" {
+		if err := ks.Provisioning.SetHostname(opts["hostname"]); err != nil {
+			return err
+		}
+	}
+	device, proto := opts["device"], opts["bootproto"]
+	if proto == "" && opts["ip"] == "" {
+		// Only sets the hostname
+		return nil
+	}
+
+	c := NetworkConnection{Name: device, Interface: device}
+	if device == "" || device == "link" {
+		c.Name, c.Interface = "phukit-default", ""
+	}
+	if proto != "" && proto != "dhcp" && proto != "static" {
+		return fmt.Errorf("unsupported --bootproto %q (supported: dhcp, static)", proto)
+	}
+	if proto == "static" || (proto == "" && opts["ip"] != "") {
+		if opts["ip"] == "" || opts["netmask"] == "" {
+			return fmt.Errorf("--bootproto=static needs --ip and --netmask")
+		}
+		prefix, err := netmaskPrefix(opts["netmask"])
+		if err != nil {
+			return err
+		}
+		c.Addresses = []string{fmt.Sprintf("%s/%d", opts["ip"], prefix)}
+		c.Gateway = opts["gateway"]
+	}
+	if opts["nameserver"] != "" {
+		c.DNS = strings.Split(opts["nameserver"], ",")
+	}
+	ks.Provisioning.Connections = append(ks.Provisioning.Connections, c)
+	return nil
+}
+
+// netmaskPrefix returns the prefix length of a dotted netmask or a bare
+// prefix length
+func netmaskPrefix(mask string) (int, error) {
+	var n int
+	if _, err := fmt.Sscanf(mask, "%d", &n); err == nil && !strings.Contains(mask, ".") && n >= 0 && n <= 32 {
+		return n, nil
+	}
+	var a, b, c, d uint32
+	if _, err := fmt.Sscanf(mask, "%d.%d.%d.%d", &a, &b, &c, &d); err != nil || a > 255 || b > 255 || c > 255 || d > 255 {
+		return 0, fmt.Errorf("invalid netmask %q", mask)
+	}
+	bits := a<<24 | b<<16 | c<<8 | d
+	prefix := 0
+	for bits&(1<<31) != 0 {
+		prefix++
+		bits <<= 1
+	}
+	if bits != 0 {
+		return 0, fmt.Errorf("invalid netmask %q", mask)
+	}
+	return prefix, nil
+}
+
+// parseKickstartOptions splits args into --options and positional
+// arguments. Options named in flags take no value; others take the value
+// after = or the next argument.
+func parseKickstartOptions(args []string, flags ...string) (map[string]string, []string, error) {
+	isFlag := map[string]bool{}
+	for _, f := range flags {
+		isFlag[f] = true
+	}
+	opts := map[string]string{}
+	var pos []string
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if !strings.HasPrefix(arg, "--") {
+			pos = append(pos, arg)
+			continue
+		}
+		name, value, hasValue := strings.Cut(strings.TrimPrefix(arg, "--"), "=")
+		switch {
+		case isFlag[name]:
+			value = "true"
+		case !hasValue:
+			if i+1 >= len(args) {
+				return nil, nil, fmt.Errorf("--%s needs a value", name)
+			}
+			i++
+			value = args[i]
+		}
+		opts[name] = value
+	}
+	return opts, pos, nil
+}
+
+// splitKickstartLine splits a kickstart line into words like a shell:
+// quotes group words and a backslash escapes the next character
+func splitKickstartLine(line string) ([]string, error) {
+	var words []string
+	var word strings.Builder
+	inWord := false
+	var quote rune
+	escaped := false
+	for _, r := range line {
+		switch {
+		case escaped:
+			word.WriteRune(r)
+			escaped = false
+		case r == '\\' && quote != '\'':
+			escaped = true
+			inWord = true
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			} else {
+				word.WriteRune(r)
+			}
+		case r == '"' || r == '\'':
+			quote = r
+			inWord = true
+		case r == ' ' || r == '\t':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		case r == '#' && !inWord:
+			// Comment to the end of the line
+			return words, nil
+		default:
+			word.WriteRune(r)
+			inWord = true
+		}
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated quote")
+	}
+	if escaped {
+		return nil, fmt.Errorf("line ends with a backslash")
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	return words, nil
+}
@@ -0,0 +1,158 @@
+package pkg
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testKickstart = `# Web servers
+text
+zerombr
+clearpart --all --initlabel
+part /boot --size=1024
+part swap --size=16384
+ostreecontainer --url=quay.io/example/web:latest --no-signature-verification
+ignoredisk --only-use=nvme0n1,nvme1n1
+targetdisk --min-size=100G --model "Samsung*"
+bootloader --append="console=ttyS0,115200 quiet"
+rootpw --iscrypted $6$root$hash
+user --name=admin --groups=wheel,adm --iscrypted --password=$6$admin$hash
+sshkey --username=admin "ssh-ed25519 AAAA admin@example"
+network --bootproto=static --device=eth0 --ip=10.0.0.5 --netmask=255.255.255.0 --gateway=10.0.0.1 --nameserver=10.0.0.1,1.1.1.1 --activate
+network --hostname=web1
+timezone Europe/Berlin --utc
+lang en_US.UTF-8
+services --enabled=sshd,chronyd.service
+reboot
+
+%packages
+@core
+%end
+
+%post
+echo done
+%end
+`
+
+func TestParseKickstart(t *testing.T) {
+	ks, err := ParseKickstart(strings.NewReader(testKickstart))
+	if err != nil {
+		t.Fatalf("ParseKickstart() error = %v", err)
+	}
+
+	if ks.ImageRef != "quay.io/example/web:latest" {
+		t.Errorf("ImageRef = %q", ks.ImageRef)
+	}
+	wantDisk := DiskSelector{Names: []string{"nvme0n1", "nvme1n1"}, MinSize: 100 << 30, Model: "Samsung*"}
+	if !reflect.DeepEqual(ks.Disk, wantDisk) {
+		t.Errorf("Disk = %+v, want %+v", ks.Disk, wantDisk)
+	}
+	if want := []string{"console=ttyS0,115200", "quiet"}; !reflect.DeepEqual(ks.KernelArgs, want) {
+		t.Errorf("KernelArgs = %v, want %v", ks.KernelArgs, want)
+	}
+	if want := []string{"sshd.service", "chronyd.service"}; !reflect.DeepEqual(ks.EnableUnits, want) {
+		t.Errorf("EnableUnits = %v, want %v", ks.EnableUnits, want)
+	}
+	if !ks.Reboot {
+		t.Error("Reboot not set")
+	}
+	if ks.SwapSize != 16<<30 || ks.Hibernate {
+		t.Errorf("SwapSize, Hibernate = %d, %v", ks.SwapSize, ks.Hibernate)
+	}
+	if want := []string{"text", "zerombr", "clearpart", "part", "%packages", "%post"}; !reflect.DeepEqual(ks.Ignored, want) {
+		t.Errorf("Ignored = %v, want %v", ks.Ignored, want)
+	}
+
+	p := ks.Provisioning
+	if p.Hostname != "web1" || p.Timezone != "Europe/Berlin" || p.Locale != "en_US.UTF-8" {
+		t.Errorf("Hostname, Timezone, Locale = %q, %q, %q", p.Hostname, p.Timezone, p.Locale)
+	}
+	wantUsers := []User{
+		{Name: "root", PasswordHash: "$6$root$hash"},
+		{Name: "admin", Groups: []string{"wheel", "adm"}, PasswordHash: "$6$admin$hash", SSHKeys: []string{"ssh-ed25519 AAAA admin@example"}},
+	}
+	if !reflect.DeepEqual(p.Users, wantUsers) {
+		t.Errorf("Users = %+v, want %+v", p.Users, wantUsers)
+	}
+	wantConn := []NetworkConnection{{Name: "eth0", Interface: "eth0", Addresses: []string{"10.0.0.5/24"}, Gateway: "10.0.0.1", DNS: []string{"10.0.0.1", "1.1.1.1"}}}
+	if !reflect.DeepEqual(p.Connections, wantConn) {
+		t.Errorf("Connections = %+v, want %+v", p.Connections, wantConn)
+	}
+}
+
+func TestParseKickstartErrors(t *testing.T) {
+	tests := map[string]string{
+		"plaintext root password": "rootpw secret",
+		"plaintext user password": "user --name=a --password=secret",
+		"unknown command":         "liveimg --url=http://example/image.tar",
+		"unsupported transport":   "ostreecontainer --url=/srv/image --transport=oci",
+		"unterminated quote":      `bootloader --append="quiet`,
+		"missing value":           "user --name",
+		"unclosed section":        "%post\necho",
+		"bad hostname":            "network --hostname=web_1",
+		"bad netmask":             "network --bootproto=static --ip=10.0.0.5 --netmask=255.0.255.0",
+		"swap without size":       "part swap --recommended",
+	}
+	for name, content := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, err := ParseKickstart(strings.NewReader(content)); err == nil {
+				t.Errorf("ParseKickstart(%q) expected error", content)
+			}
+		})
+	}
+}
+
+func TestParseKickstartHibernation(t *testing.T) {
+	ks, err := ParseKickstart(strings.NewReader("part swap --hibernation\n"))
+	if err != nil {
+		t.Fatalf("ParseKickstart() error = %v", err)
+	}
+	if !ks.Hibernate || ks.SwapSize != 0 {
+		t.Errorf("Hibernate, SwapSize = %v, %d, want true, 0", ks.Hibernate, ks.SwapSize)
+	}
+}
+
+func TestNetmaskPrefix(t *testing.T) {
+	tests := map[string]int{"255.255.255.0": 24, "255.255.240.0": 20, "0.0.0.0": 0, "16": 16}
+	for mask, want := range tests {
+		if got, err := netmaskPrefix(mask); err != nil || got != want {
+			t.Errorf("netmaskPrefix(%q) = %d, %v, want %d", mask, got, err, want)
+		}
+	}
+}
+
+func TestParseKickstartGrowVar(t *testing.T) {
+	tests := map[string]bool{
+		"part /var --fstype=ext4 --size=1024 --grow\n": true,
+		"part /var --size=1024\n":                      false,
+		"part /home --grow\n":                          false,
+	}
+	for content, want := range tests {
+		ks, err := ParseKickstart(strings.NewReader(content))
+		if err != nil {
+			t.Fatalf("ParseKickstart(%q) error = %v", content, err)
+		}
+		if ks.GrowVar != want {
+			t.Errorf("ParseKickstart(%q).GrowVar = %v, want %v", content, ks.GrowVar, want)
+		}
+	}
+}
+
+func TestParseKickstartAutopart(t *testing.T) {
+	tests := map[string]bool{
+		"autopart --type=lvm\n":   true,
+		"autopart --type lvm\n":   true,
+		"autopart --type=plain\n": false,
+		"autopart --nolvm\n":      false,
+	}
+	for content, want := range tests {
+		ks, err := ParseKickstart(strings.NewReader(content))
+		if err != nil {
+			t.Fatalf("ParseKickstart(%q) error = %v", content, err)
+		}
+		if ks.LVM != want {
+			t.Errorf("ParseKickstart(%q).LVM = %v, want %v", content, ks.LVM, want)
+		}
+	}
+}
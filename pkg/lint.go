@@ -0,0 +1,170 @@
+package pkg
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Lint check statuses
+const (
+	LintPass = "pass"
+	LintWarn = "warn"
+	LintFail = "fail"
+)
+
+// LintCheck is the result of one bootc-compatibility check of an image
+type LintCheck struct {
+	Name    string `json:"name"`
+	Status  string `json:"status"` // pass, warn or fail
+	Message string `json:"message"`
+}
+
+// Lint checks that the image can be installed and booted by phukit: a kernel
+// and initramfs in /usr/lib/modules, bootloader binaries, an os-release and
+// sane /etc contents. Warnings are failures in strict mode.
+func (info *ImageInfo) Lint() []LintCheck {
+	checks := []LintCheck{
+		info.lintKernel(),
+		info.lintInitramfs(),
+		info.lintBootloader(),
+		info.lintOSRelease(),
+		info.lintAccounts(),
+		info.lintMachineID(),
+		info.lintSSHHostKeys(),
+	}
+	if StrictMode() {
+		for i := range checks {
+			if checks[i].Status == LintWarn {
+				checks[i].Status = LintFail
+			}
+		}
+	}
+	return checks
+}
+
+// LintFailed returns an error wrapping ErrImageLintFailed if any check
+// failed, or nil
+func LintFailed(checks []LintCheck) error {
+	var failed []string
+	for _, c := range checks {
+		if c.Status == LintFail {
+			failed = append(failed, c.Name)
+		}
+	}
+	if len(failed) == 0 {
+		return nil
+	}
+	return fmt.Errorf("%w: %s", ErrImageLintFailed, strings.Join(failed, ", "))
+}
+
+func (info *ImageInfo) lintKernel() LintCheck {
+	c := LintCheck{Name: "kernel"}
+	if len(info.Kernels) == 0 {
+		c.Status, c.Message = LintFail, "no vmlinuz in /usr/lib/modules/<version>"
+	} else {
+		c.Status, c.Message = LintPass, strings.Join(info.Kernels, ", ")
+	}
+	return c
+}
+
+func (info *ImageInfo) lintInitramfs() LintCheck {
+	c := LintCheck{Name: "initramfs"}
+	var missing []string
+	for _, version := range info.Kernels {
+		if !slices.Contains(info.Initramfs, version) {
+			missing = append(missing, version)
+		}
+	}
+	switch {
+	case len(info.Kernels) == 0:
+		c.Status, c.Message = LintFail, "no kernel to boot"
+	case len(missing) == 0:
+		c.Status, c.Message = LintPass, "present for every kernel"
+	case info.initramfsGenerator != "":
+		// Install generates it, as GenerateMissingInitramfs
+		c.Status = LintWarn
+		c.Message = fmt.Sprintf("missing for %s; install will generate it with %s", strings.Join(missing, ", "), info.initramfsGenerator)
+	default:
+		c.Status = LintFail
+		c.Message = fmt.Sprintf("missing for %s and neither dracut nor mkinitcpio is installed", strings.Join(missing, ", "))
+	}
+	return c
+}
+
+func (info *ImageInfo) lintBootloader() LintCheck {
+	c := LintCheck{Name: "bootloader"}
+	has := func(suffixes ...string) bool {
+		return slices.ContainsFunc(info.BootBinaries, func(p string) bool {
+			return slices.ContainsFunc(suffixes, func(s string) bool { return strings.HasSuffix(p, s) })
+		})
+	}
+	systemdBoot := has("/bootctl") && has("systemd-bootx64.efi", "systemd-bootx64.efi.signed")
+	grub := has("/grub-install", "/grub2-install")
+	switch {
+	case systemdBoot && grub:
+		c.Status, c.Message = LintPass, "systemd-boot and GRUB"
+	case systemdBoot:
+		c.Status, c.Message = LintPass, "systemd-boot"
+	case grub:
+		c.Status, c.Message = LintPass, "GRUB"
+	case has("/bootctl"):
+		c.Status, c.Message = LintFail, "bootctl without the systemd-boot EFI binary"
+	default:
+		c.Status, c.Message = LintFail, "neither grub-install nor bootctl found"
+	}
+	return c
+}
+
+func (info *ImageInfo) lintOSRelease() LintCheck {
+	c := LintCheck{Name: "os-release"}
+	switch {
+	case len(info.OSRelease) == 0:
+		c.Status, c.Message = LintFail, "no /etc/os-release or /usr/lib/os-release"
+	case info.OSRelease["ID"] == "":
+		c.Status, c.Message = LintWarn, "os-release has no ID"
+	default:
+		c.Status, c.Message = LintPass, cmp.Or(info.OSRelease["PRETTY_NAME"], info.OSRelease["ID"])
+	}
+	return c
+}
+
+func (info *ImageInfo) lintAccounts() LintCheck {
+	c := LintCheck{Name: "etc-accounts"}
+	var missing []string
+	if !info.etc.passwd {
+		missing = append(missing, "/etc/passwd")
+	}
+	if !info.etc.group {
+		missing = append(missing, "/etc/group")
+	}
+	if len(missing) > 0 {
+		c.Status, c.Message = LintFail, "missing "+strings.Join(missing, " and ")
+	} else {
+		c.Status, c.Message = LintPass, "/etc/passwd and /etc/group present"
+	}
+	return c
+}
+
+func (info *ImageInfo) lintMachineID() LintCheck {
+	// Empty or "uninitialized" lets systemd generate one on first boot
+	c := LintCheck{Name: "machine-id"}
+	if info.etc.machineID == "" || info.etc.machineID == "uninitialized" {
+		c.Status, c.Message = LintPass, "not set; generated on first boot"
+	} else {
+		c.Status, c.Message = LintFail, "/etc/machine-id is set; every installed system would share it"
+	}
+	return c
+}
+
+func (info *ImageInfo) lintSSHHostKeys() LintCheck {
+	c := LintCheck{Name: "ssh-host-keys"}
+	if len(info.etc.sshHostKeys) > 0 {
+		c.Status = LintWarn
+		c.Message = fmt.Sprintf("%s baked in; every installed system would share them", strings.Join(info.etc.sshHostKeys, ", "))
+	} else {
+		c.Status, c.Message = LintPass, "none baked in"
+	}
+	return c
+}
@@ -0,0 +1,104 @@
+package pkg
+
+import (
+	"cmp"
+	"errors"
+	"maps"
+	"slices"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+)
+
+// lintableImage returns an image that passes every check
+func lintableImage() *ImageInfo {
+	return &ImageInfo{
+		OSRelease:    map[string]string{"ID": "fedora", "PRETTY_NAME": "Fedora Linux 42"},
+		Kernels:      []string{"6.2.0"},
+		Initramfs:    []string{"6.2.0"},
+		BootBinaries: []string{"/usr/sbin/grub2-install"},
+		etc:          imageEtc{passwd: true, group: true},
+	}
+}
+
+func TestImageLint(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(info *ImageInfo)
+		strict bool
+		want   map[string]string // Status of the checks that do not pass
+	}{
+		{"lintable", func(*ImageInfo) {}, false, nil},
+		{"no kernel", func(i *ImageInfo) { i.Kernels, i.Initramfs = nil, nil }, false, map[string]string{"kernel": LintFail, "initramfs": LintFail}},
+		{"no initramfs, generator", func(i *ImageInfo) { i.Initramfs, i.initramfsGenerator = nil, InitramfsDracut }, false, map[string]string{"initramfs": LintWarn}},
+		{"no initramfs, generator, strict", func(i *ImageInfo) { i.Initramfs, i.initramfsGenerator = nil, InitramfsDracut }, true, map[string]string{"initramfs": LintFail}},
+		{"no initramfs", func(i *ImageInfo) { i.Initramfs = nil }, false, map[string]string{"initramfs": LintFail}},
+		{"systemd-boot", func(i *ImageInfo) {
+			i.BootBinaries = []string{"/usr/bin/bootctl", "/usr/lib/systemd/boot/efi/systemd-bootx64.efi"}
+		}, false, map[string]string{"bootloader": LintPass}},
+		{"bootctl only", func(i *ImageInfo) { i.BootBinaries = []string{"/usr/bin/bootctl"} }, false, map[string]string{"bootloader": LintFail}},
+		{"no bootloader", func(i *ImageInfo) { i.BootBinaries = []string{"/usr/lib/shim/shimx64.efi"} }, false, map[string]string{"bootloader": LintFail}},
+		{"no os-release", func(i *ImageInfo) { i.OSRelease = nil }, false, map[string]string{"os-release": LintFail}},
+		{"os-release without ID", func(i *ImageInfo) { i.OSRelease = map[string]string{"NAME": "Linux"} }, false, map[string]string{"os-release": LintWarn}},
+		{"no group", func(i *ImageInfo) { i.etc.group = false }, false, map[string]string{"etc-accounts": LintFail}},
+		{"uninitialized machine-id", func(i *ImageInfo) { i.etc.machineID = "uninitialized" }, false, map[string]string{"machine-id": LintPass}},
+		{"machine-id set", func(i *ImageInfo) { i.etc.machineID = "0123456789abcdef0123456789abcdef" }, false, map[string]string{"machine-id": LintFail}},
+		{"ssh host keys", func(i *ImageInfo) { i.etc.sshHostKeys = []string{"/etc/ssh/ssh_host_ed25519_key"} }, false, map[string]string{"ssh-host-keys": LintWarn}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			SetStrictMode(tt.strict)
+			t.Cleanup(func() { SetStrictMode(false) })
+			info := lintableImage()
+			tt.modify(info)
+
+			checks := info.Lint()
+			for _, c := range checks {
+				want := cmp.Or(tt.want[c.Name], LintPass)
+				if c.Status != want {
+					t.Errorf("check %s = %s (%s), want %s", c.Name, c.Status, c.Message, want)
+				}
+			}
+			err := LintFailed(checks)
+			if wantErr := slices.Contains(slices.Collect(maps.Values(tt.want)), LintFail); wantErr != errors.Is(err, ErrImageLintFailed) {
+				t.Errorf("LintFailed() = %v, want error %v", err, wantErr)
+			}
+		})
+	}
+}
+
+func TestInspectImageLintFacts(t *testing.T) {
+	layer := testLayer(t,
+		"usr/lib/os-release", "ID=example\n",
+		"usr/lib/modules/6.2.0/vmlinuz", "kernel",
+		"usr/lib/modules/6.2.0/initramfs.img", "initramfs",
+		"usr/lib/modules/6.3.0/vmlinuz-6.3.0", "kernel",
+		"usr/bin/dracut", "#!/bin/sh",
+		"etc/passwd", "root:x:0:0::/root:/bin/sh\n",
+		"etc/group", "root:x:0:\n",
+		"etc/machine-id", "uninitialized\n",
+		"etc/ssh/ssh_host_rsa_key", "key",
+		"etc/ssh/ssh_host_rsa_key.pub", "key",
+	)
+	img, err := mutate.AppendLayers(empty.Image, layer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := InspectImage(t.Context(), pushTestImage(t, img))
+	if err != nil {
+		t.Fatalf("InspectImage() error = %v", err)
+	}
+	if len(info.Kernels) != 2 || len(info.Initramfs) != 1 || info.Initramfs[0] != "6.2.0" {
+		t.Errorf("Kernels = %v, Initramfs = %v", info.Kernels, info.Initramfs)
+	}
+	if info.initramfsGenerator != InitramfsDracut {
+		t.Errorf("initramfsGenerator = %q, want dracut", info.initramfsGenerator)
+	}
+	if !info.etc.passwd || !info.etc.group || info.etc.machineID != "uninitialized" {
+		t.Errorf("etc = %+v", info.etc)
+	}
+	if len(info.etc.sshHostKeys) != 1 || info.etc.sshHostKeys[0] != "/etc/ssh/ssh_host_rsa_key" {
+		t.Errorf("sshHostKeys = %v", info.etc.sshHostKeys)
+	}
+}
@@ -0,0 +1,114 @@
+package pkg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// OperationLockPath is the lock file held for the duration of an install or
+// update, so two phukit runs (e.g. a timer and a manual update) never touch
+// the same partitions and bootloader files at once
+var OperationLockPath = "/run/phukit.lock"
+
+// ErrOperationInProgress is returned when another phukit operation holds the lock
+var ErrOperationInProgress = errors.New("another phukit operation is in progress")
+
+// errLockBusy is returned by flockWait when the lock is still held after the timeout
+var errLockBusy = errors.New("lock is busy")
+
+// flockWait takes an exclusive flock on f, retrying every varLockPollInterval
+// until timeout expires or ctx is done. A negative timeout waits indefinitely.
+func flockWait(ctx context.Context, f *os.File, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		err := unix.Flock(int(f.Fd()), unix.LOCK_EX|unix.LOCK_NB)
+		if err == nil {
+			return nil
+		}
+		if !errors.Is(err, unix.EWOULDBLOCK) {
+			return err
+		}
+		if timeout >= 0 && !time.Now().Before(deadline) {
+			return errLockBusy
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(varLockPollInterval):
+		}
+	}
+}
+
+// OperationLock is a held global operation lock
+type OperationLock struct {
+	f *os.File
+}
+
+// AcquireOperationLock takes the global operation lock. If wait is false it
+// fails with ErrOperationInProgress when another operation holds the lock,
+// otherwise it waits until the lock is released or ctx is done.
+func AcquireOperationLock(ctx context.Context, wait bool) (*OperationLock, error) {
+	if err := os.MkdirAll(filepath.Dir(OperationLockPath), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create lock directory: %w", err)
+	}
+
+	// Not O_TRUNC: the holder's pid must stay readable until we own the lock
+	f, err := os.OpenFile(OperationLockPath, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", OperationLockPath, err)
+	}
+
+	timeout := time.Duration(0)
+	if wait {
+		timeout = -1
+	}
+	if err := unix.Flock(int(f.Fd()), unix.LOCK_EX|unix.LOCK_NB); errors.Is(err, unix.EWOULDBLOCK) && wait {
+		fmt.Printf("Waiting for %s to finish...\n", lockHolder(f))
+	}
+
+	if err := flockWait(ctx, f, timeout); err != nil {
+		holder := lockHolder(f)
+		_ = f.Close()
+		if errors.Is(err, errLockBusy) {
+			return nil, fmt.Errorf("%w: %s holds %s (use --wait to wait for it)", ErrOperationInProgress, holder, OperationLockPath)
+		}
+		return nil, fmt.Errorf("failed to lock %s: %w", OperationLockPath, err)
+	}
+
+	// Record our pid so a contending run can say who holds the lock
+	if err := f.Truncate(0); err == nil {
+		_, _ = f.WriteAt([]byte(strconv.Itoa(os.Getpid())+"\n"), 0)
+	}
+
+	return &OperationLock{f: f}, nil
+}
+
+// lockHolder describes the process recorded in a lock file
+func lockHolder(f *os.File) string {
+	buf := make([]byte, 32)
+	n, _ := f.ReadAt(buf, 0)
+	if pid := strings.TrimSpace(string(buf[:n])); pid != "" {
+		return "phukit (pid " + pid + ")"
+	}
+	return "another phukit process"
+}
+
+// Unlock releases the global operation lock
+func (l *OperationLock) Unlock() error {
+	if l == nil || l.f == nil {
+		return nil
+	}
+	_ = l.f.Truncate(0)
+	_ = unix.Flock(int(l.f.Fd()), unix.LOCK_UN)
+	err := l.f.Close()
+	l.f = nil
+	return err
+}
@@ -0,0 +1,73 @@
+package pkg
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func setupOperationLockPath(t *testing.T) string {
+	t.Helper()
+	oldPath, oldInterval := OperationLockPath, varLockPollInterval
+	OperationLockPath = filepath.Join(t.TempDir(), "run", "phukit.lock")
+	varLockPollInterval = 10 * time.Millisecond
+	t.Cleanup(func() { OperationLockPath, varLockPollInterval = oldPath, oldInterval })
+	return OperationLockPath
+}
+
+func TestAcquireOperationLock(t *testing.T) {
+	lockPath := setupOperationLockPath(t)
+
+	lock, err := AcquireOperationLock(context.Background(), false)
+	if err != nil {
+		t.Fatalf("AcquireOperationLock() error = %v", err)
+	}
+
+	data, err := os.ReadFile(lockPath)
+	if err != nil || strings.TrimSpace(string(data)) != strconv.Itoa(os.Getpid()) {
+		t.Errorf("lock file = %q, %v; want our pid", data, err)
+	}
+
+	// A second run fails immediately and names the holder
+	_, err = AcquireOperationLock(context.Background(), false)
+	if !errors.Is(err, ErrOperationInProgress) {
+		t.Fatalf("expected ErrOperationInProgress, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "pid "+strconv.Itoa(os.Getpid())) {
+		t.Errorf("error does not name the holder: %v", err)
+	}
+
+	// With wait it blocks until the holder is done
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		_ = lock.Unlock()
+	}()
+	second, err := AcquireOperationLock(context.Background(), true)
+	if err != nil {
+		t.Fatalf("AcquireOperationLock(wait) error = %v", err)
+	}
+	if err := second.Unlock(); err != nil {
+		t.Errorf("Unlock() error = %v", err)
+	}
+}
+
+func TestAcquireOperationLockWaitCancelled(t *testing.T) {
+	setupOperationLockPath(t)
+
+	lock, err := AcquireOperationLock(context.Background(), false)
+	if err != nil {
+		t.Fatalf("AcquireOperationLock() error = %v", err)
+	}
+	defer func() { _ = lock.Unlock() }()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := AcquireOperationLock(ctx, true); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected context.DeadlineExceeded, got %v", err)
+	}
+}
@@ -0,0 +1,247 @@
+package pkg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultLogFile is where the persistent operation log is written by default
+const DefaultLogFile = "/var/log/phukit/phukit.log"
+
+// Log rotation defaults: rotate at 10 MiB and keep 5 old files
+const (
+	DefaultLogMaxSize = 10 * 1024 * 1024
+	DefaultLogBackups = 5
+)
+
+// logger receives the persistent operation log. It is independent of the
+// text and JSON progress written for the user and discards everything unless
+// SetLogger is called.
+var logger = slog.New(slog.DiscardHandler)
+
+// SetLogger sets the logger for the persistent operation log
+// Pass nil to disable logging
+func SetLogger(l *slog.Logger) {
+	if l == nil {
+		l = slog.New(slog.DiscardHandler)
+	}
+	logger = l
+}
+
+// Logger returns the logger for the persistent operation log
+func Logger() *slog.Logger {
+	return logger
+}
+
+// ParseLogLevel parses debug, info, warn or error
+func ParseLogLevel(s string) (slog.Level, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(s)); err != nil {
+		return 0, fmt.Errorf("invalid log level %q (supported: debug, info, warn, error)", s)
+	}
+	return level, nil
+}
+
+// withLogAttrs adds attributes to every record logged until the returned
+// function restores the previous logger
+func withLogAttrs(args ...any) (restore func()) {
+	prev := logger
+	logger = logger.With(args...)
+	return func() { logger = prev }
+}
+
+// printStep prints a workflow step heading ("Step N/M: ...") and records it in
+// the log with the step number as the "step" attribute, and as a phase of the
+// result document
+func printStep(line string) {
+	heading := strings.TrimLeft(line, "\n")
+	fmt.Println(line[:len(line)-len(heading)] + colorize(ansiBold, heading))
+	line = strings.TrimSpace(line)
+	if step, name, ok := stepHeading(line); ok {
+		recordPhase(step, name)
+		logger.Info(line, "step", step)
+		return
+	}
+	logger.Info(line)
+}
+
+// RotatingFile is an append-only log file that is rotated to path.1,
+// path.2, ... when it grows beyond MaxSize
+type RotatingFile struct {
+	Path    string
+	MaxSize int64
+	Backups int // Rotated files to keep
+
+	mu   sync.Mutex
+	file *os.File
+	size int64
+}
+
+// OpenRotatingFile opens path for appending, creating its directory, and
+// rotates it first if it is already over maxSize
+func OpenRotatingFile(path string, maxSize int64, backups int) (*RotatingFile, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0750); err != nil {
+		return nil, fmt.Errorf("failed to create log directory: %w", err)
+	}
+	r := &RotatingFile{Path: path, MaxSize: maxSize, Backups: backups}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	if r.size >= maxSize {
+		if err := r.rotate(); err != nil {
+			_ = r.file.Close()
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+// open opens the current log file for appending
+func (r *RotatingFile) open() error {
+	f, err := os.OpenFile(r.Path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0640)
+	if err != nil {
+		return fmt.Errorf("failed to open log file: %w", err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return fmt.Errorf("failed to stat log file: %w", err)
+	}
+	r.file = f
+	r.size = info.Size()
+	return nil
+}
+
+// rotate shifts path.N-1 to path.N, ..., path to path.1 and starts a new file
+func (r *RotatingFile) rotate() error {
+	if err := r.file.Close(); err != nil {
+		return fmt.Errorf("failed to close log file: %w", err)
+	}
+	if r.Backups > 0 {
+		for i := r.Backups - 1; i >= 1; i-- {
+			_ = os.Rename(fmt.Sprintf("%s.%d", r.Path, i), fmt.Sprintf("%s.%d", r.Path, i+1))
+		}
+		if err := os.Rename(r.Path, r.Path+".1"); err != nil {
+			return fmt.Errorf("failed to rotate log file: %w", err)
+		}
+	} else if err := os.Truncate(r.Path, 0); err != nil {
+		return fmt.Errorf("failed to truncate log file: %w", err)
+	}
+	return r.open()
+}
+
+// Write implements io.Writer, rotating before a write that would exceed MaxSize
+func (r *RotatingFile) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.MaxSize > 0 && r.size > 0 && r.size+int64(len(p)) > r.MaxSize {
+		if err := r.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := r.file.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+// Close closes the log file
+func (r *RotatingFile) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.file.Close()
+}
+
+// NewFileLogger creates a text logger writing records at level and above to w
+func NewFileLogger(w io.Writer, level slog.Level) *slog.Logger {
+	return slog.New(NewFileHandler(w, level)).With("pid", os.Getpid())
+}
+
+// NewFileHandler creates the text handler used by NewFileLogger, for
+// combining with other handlers
+func NewFileHandler(w io.Writer, level slog.Level) slog.Handler {
+	return slog.NewTextHandler(w, &slog.HandlerOptions{Level: level})
+}
+
+// LogExecutor wraps another executor and logs every command it runs and its
+// output at debug level, and failures at warn level
+type LogExecutor struct {
+	Next Executor
+}
+
+// Run implements Executor. At debug level it also logs every line the
+// command writes.
+func (l LogExecutor) Run(ctx context.Context, c Command) error {
+	if logger.Enabled(ctx, slog.LevelDebug) {
+		stdout, stderr := &outputLogger{command: c.Name}, &outputLogger{command: c.Name}
+		defer stdout.flush()
+		defer stderr.flush()
+		c = teeOutput(c, stdout, stderr)
+	}
+	start := time.Now()
+	err := l.Next.Run(ctx, c)
+	if err != nil {
+		logger.Warn("command failed", "command", c.String(), "duration", time.Since(start), "error", err)
+	} else {
+		logger.Debug("command", "command", c.String(), "duration", time.Since(start))
+	}
+	return err
+}
+
+// LookPath implements Executor
+func (l LogExecutor) LookPath(name string) (string, error) {
+	return l.Next.LookPath(name)
+}
+
+// multiHandler sends each record to all of its handlers
+type multiHandler []slog.Handler
+
+// NewMultiHandler returns a handler that sends records to every handler
+func NewMultiHandler(handlers ...slog.Handler) slog.Handler {
+	if len(handlers) == 1 {
+		return handlers[0]
+	}
+	return multiHandler(handlers)
+}
+
+func (m multiHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	for _, h := range m {
+		if h.Enabled(ctx, level) {
+			return true
+		}
+	}
+	return false
+}
+
+func (m multiHandler) Handle(ctx context.Context, r slog.Record) error {
+	var errs []error
+	for _, h := range m {
+		if h.Enabled(ctx, r.Level) {
+			errs = append(errs, h.Handle(ctx, r.Clone()))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (m multiHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	out := make(multiHandler, len(m))
+	for i, h := range m {
+		out[i] = h.WithAttrs(attrs)
+	}
+	return out
+}
+
+func (m multiHandler) WithGroup(name string) slog.Handler {
+	out := make(multiHandler, len(m))
+	for i, h := range m {
+		out[i] = h.WithGroup(name)
+	}
+	return out
+}
@@ -0,0 +1,111 @@
+package pkg
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRotatingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "log", "phukit.log")
+
+	r, err := OpenRotatingFile(path, 10, 2)
+	if err != nil {
+		t.Fatalf("OpenRotatingFile failed: %v", err)
+	}
+	for _, line := range []string{"first\n", "second\n", "third\n", "fourth\n"} {
+		if _, err := r.Write([]byte(line)); err != nil {
+			t.Fatalf("Write failed: %v", err)
+		}
+	}
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]string{
+		path:        "fourth\n",
+		path + ".1": "third\n",
+		path + ".2": "second\n",
+	}
+	for p, content := range want {
+		data, err := os.ReadFile(p)
+		if err != nil || string(data) != content {
+			t.Errorf("%s = %q, %v; want %q", filepath.Base(p), data, err, content)
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Error("expected only 2 rotated files to be kept")
+	}
+
+	// Reopening a file that is already over the limit rotates it first
+	if err := os.WriteFile(path, []byte("0123456789abc"), 0640); err != nil {
+		t.Fatal(err)
+	}
+	r, err = OpenRotatingFile(path, 10, 2)
+	if err != nil {
+		t.Fatalf("OpenRotatingFile failed: %v", err)
+	}
+	defer func() { _ = r.Close() }()
+	if info, err := os.Stat(path); err != nil || info.Size() != 0 {
+		t.Errorf("expected a fresh log file after reopening, got %v, %v", info, err)
+	}
+}
+
+func TestParseLogLevel(t *testing.T) {
+	tests := map[string]slog.Level{
+		"debug": slog.LevelDebug,
+		"info":  slog.LevelInfo,
+		"WARN":  slog.LevelWarn,
+		"error": slog.LevelError,
+	}
+	for in, want := range tests {
+		got, err := ParseLogLevel(in)
+		if err != nil || got != want {
+			t.Errorf("ParseLogLevel(%q) = %v, %v; want %v", in, got, err, want)
+		}
+	}
+	if _, err := ParseLogLevel("verbose"); err == nil {
+		t.Error("expected an error for an unknown level")
+	}
+}
+
+func TestLogging(t *testing.T) {
+	var buf bytes.Buffer
+	SetLogger(NewFileLogger(&buf, slog.LevelInfo))
+	t.Cleanup(func() { SetLogger(nil) })
+
+	fake, _ := setupDryRunExecutor(t)
+	exec := LogExecutor{Next: fake}
+	if err := exec.Run(context.Background(), Command{Name: "mkfs.ext4", Args: []string{"/dev/sda3"}}); err != nil {
+		t.Fatal(err)
+	}
+	_ = warnf("  could not get UUID for %s", "/dev/sda3")
+	ReportComplete("install", StatusFailed, ErrAborted)
+
+	out := buf.String()
+	if strings.Contains(out, "mkfs.ext4") {
+		t.Errorf("debug command record written at info level:\n%s", out)
+	}
+	for _, want := range []string{
+		`level=WARN msg="could not get UUID for /dev/sda3"`,
+		`level=ERROR msg="install failed"`,
+		"error_code=aborted",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("log missing %q:\n%s", want, out)
+		}
+	}
+
+	buf.Reset()
+	SetLogger(NewFileLogger(&buf, slog.LevelDebug))
+	if err := exec.Run(context.Background(), Command{Name: "mkfs.ext4", Args: []string{"/dev/sda3"}}); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), `command="mkfs.ext4 /dev/sda3"`) {
+		t.Errorf("debug log missing command record:\n%s", buf.String())
+	}
+}
@@ -0,0 +1,113 @@
+package pkg
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// sizeUnits are the binary multipliers of the size suffixes ParseSize accepts
+var sizeUnits = map[string]int64{
+	"":  1,
+	"K": 1 << 10,
+	"M": 1 << 20,
+	"G": 1 << 30,
+	"T": 1 << 40,
+}
+
+// ParseSize parses a size like "40G", "512M" or "1TiB" into bytes. Suffixes
+// are binary (K is 1024) and a bare number is bytes.
+func ParseSize(s string) (int64, error) {
+	t := strings.ToUpper(strings.TrimSpace(s))
+	t = strings.TrimSuffix(strings.TrimSuffix(t, "B"), "I")
+	i := strings.IndexFunc(t, func(r rune) bool { return r < '0' || r > '9' })
+	if i < 0 {
+		i = len(t)
+	}
+	unit, ok := sizeUnits[t[i:]]
+	n, err := strconv.ParseInt(t[:i], 10, 64)
+	if !ok || err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid size %q (use a number with an optional K, M, G or T suffix)", s)
+	}
+	if n > (1<<62)/unit {
+		return 0, fmt.Errorf("size %q is too large", s)
+	}
+	return n * unit, nil
+}
+
+// CreateDiskImage creates a sparse disk image of size bytes at path. It
+// refuses to overwrite an existing file.
+func CreateDiskImage(path string, size int64) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to create disk image: %w", err)
+	}
+	if err := f.Truncate(size); err != nil {
+		_ = f.Close()
+		_ = os.Remove(path)
+		return fmt.Errorf("failed to size disk image: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("failed to create disk image: %w", err)
+	}
+	return nil
+}
+
+// AttachLoopDevice attaches the disk image at path to a free loop device with
+// partition scanning, so its partitions appear as /dev/loopNpM, and returns
+// the device
+func AttachLoopDevice(ctx context.Context, path string) (string, error) {
+	var out, stderr bytes.Buffer
+	if err := executor.Run(ctx, Command{Name: "losetup", Args: []string{"--find", "--show", "--partscan", path}, Stdout: &out, Stderr: &stderr}); err != nil {
+		return "", fmt.Errorf("failed to attach loop device: %w\nOutput: %s", err, stderr.String())
+	}
+	return strings.TrimSpace(out.String()), nil
+}
+
+// loopDevicesOf returns the loop devices the disk image at path is attached to
+func loopDevicesOf(ctx context.Context, path string) ([]string, error) {
+	var out, stderr bytes.Buffer
+	if err := executor.Run(ctx, Command{Name: "losetup", Args: []string{"--noheadings", "--output", "NAME", "--associated", path}, Stdout: &out, Stderr: &stderr}); err != nil {
+		return nil, fmt.Errorf("failed to list loop devices of %s: %w\nOutput: %s", path, err, stderr.String())
+	}
+	var devices []string
+	scanner := bufio.NewScanner(&out)
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" {
+			devices = append(devices, line)
+		}
+	}
+	return devices, nil
+}
+
+// DetachLoopDevice detaches target, which is either a loop device or a disk
+// image whose loop devices are all detached. It returns the devices detached.
+func DetachLoopDevice(ctx context.Context, target string) ([]string, error) {
+	devices := []string{target}
+	if !IsBlockDevice(target) {
+		if _, err := os.Stat(target); err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrDeviceNotFound, target)
+		}
+		var err error
+		if devices, err = loopDevicesOf(ctx, target); err != nil {
+			return nil, err
+		}
+		if len(devices) == 0 {
+			return nil, fmt.Errorf("%s is not attached to a loop device", target)
+		}
+	} else if !strings.HasPrefix(filepath.Base(target), "loop") {
+		return nil, fmt.Errorf("%s is not a loop device", target)
+	}
+
+	for _, device := range devices {
+		if out, err := runCommand(ctx, "losetup", "--detach", device); err != nil {
+			return nil, fmt.Errorf("failed to detach %s: %w\nOutput: %s", device, err, strings.TrimSpace(string(out)))
+		}
+	}
+	return devices, nil
+}
@@ -0,0 +1,88 @@
+package pkg
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		in   string
+		want int64
+	}{
+		{"4096", 4096},
+		{"512M", 512 << 20},
+		{"40G", 40 << 30},
+		{"40g", 40 << 30},
+		{"1TiB", 1 << 40},
+		{"8GB", 8 << 30},
+	}
+	for _, tt := range tests {
+		got, err := ParseSize(tt.in)
+		if err != nil || got != tt.want {
+			t.Errorf("ParseSize(%q) = %d, %v, want %d", tt.in, got, err, tt.want)
+		}
+	}
+	for _, in := range []string{"", "G", "0", "-1G", "40X", "1.5G", "99999999T"} {
+		if _, err := ParseSize(in); err == nil {
+			t.Errorf("ParseSize(%q) expected error", in)
+		}
+	}
+}
+
+func TestCreateDiskImage(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "disk.img")
+	if err := CreateDiskImage(path, 40<<30); err != nil {
+		t.Fatalf("CreateDiskImage() error = %v", err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() != 40<<30 {
+		t.Errorf("size = %d, want %d", info.Size(), int64(40<<30))
+	}
+	if err := CreateDiskImage(path, 1<<30); !errors.Is(err, os.ErrExist) {
+		t.Errorf("CreateDiskImage() over an existing file error = %v, want os.ErrExist", err)
+	}
+}
+
+func TestDetachLoopDevice(t *testing.T) {
+	fake := setupScriptedExecutor(t)
+	fake.answer("losetup --noheadings", "/dev/loop3\n/dev/loop7\n")
+
+	image := filepath.Join(t.TempDir(), "disk.img")
+	writeFakeFile(t, image, "")
+
+	devices, err := DetachLoopDevice(context.Background(), image)
+	if err != nil {
+		t.Fatalf("DetachLoopDevice() error = %v", err)
+	}
+	if len(devices) != 2 {
+		t.Fatalf("devices = %v, want 2", devices)
+	}
+	want := []string{
+		"losetup --noheadings --output NAME --associated " + image,
+		"losetup --detach /dev/loop3",
+		"losetup --detach /dev/loop7",
+	}
+	if len(fake.Commands) != len(want) {
+		t.Fatalf("commands = %v, want %v", fake.Commands, want)
+	}
+	for i, c := range fake.Commands {
+		if c.String() != want[i] {
+			t.Errorf("command %d = %q, want %q", i, c.String(), want[i])
+		}
+	}
+
+	fake.answer("losetup --noheadings", "")
+	if _, err := DetachLoopDevice(context.Background(), image); err == nil {
+		t.Error("DetachLoopDevice() expected error for an image that is not attached")
+	}
+	if _, err := DetachLoopDevice(context.Background(), filepath.Join(t.TempDir(), "missing.img")); !errors.Is(err, ErrDeviceNotFound) {
+		t.Errorf("DetachLoopDevice() error = %v, want ErrDeviceNotFound", err)
+	}
+}
@@ -0,0 +1,275 @@
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/diskfs/go-diskfs/partition/gpt"
+)
+
+// LVM layout
+//
+// With LVM the disk holds the boot partition and a single LVM physical
+// volume. root1, root2, /var and swap are logical volumes of one volume
+// group, so they can be resized and snapshotted after the install. /var gets
+// 90% of the space left after the roots and swap; the rest of the volume
+// group stays free for snapshots and for growing volumes. The kernel command
+// line activates the volume group with rd.lvm.vg= and the initramfs is
+// rebuilt with LVM support. Updates find the logical volumes through the
+// device-mapper holders of the physical volume instead of by partition
+// number.
+
+// DefaultVolumeGroup is the volume group of an LVM install
+const DefaultVolumeGroup = "phukit"
+
+// volumeGroupNamePattern matches the volume group names LVM accepts
+var volumeGroupNamePattern = regexp.MustCompile(`^[a-zA-Z0-9+_.][a-zA-Z0-9+_.-]*$`)
+
+// lvmVarExtents is the share of the free space of the volume group that
+// /var is created with
+const lvmVarExtents = "90%FREE"
+
+// lvmGPTLayout returns the partition layout of an LVM install: the boot
+// partition and a physical volume over the rest of the disk
+func lvmGPTLayout() []GPTPartitionSpec {
+	return []GPTPartitionSpec{
+		defaultGPTLayout()[0],
+		{Name: "lvm", Type: gpt.LinuxLVM, Size: 0},
+	}
+}
+
+// lvmVolumePath returns the device path of logical volume lv of vg
+func lvmVolumePath(vg, lv string) string {
+	return "/dev/" + vg + "/" + lv
+}
+
+// lvmPartitionScheme returns the partition scheme of an LVM install with
+// the boot partition boot and the logical volumes of vg
+func lvmPartitionScheme(boot, vg string, swap bool) *PartitionScheme {
+	scheme := &PartitionScheme{
+		BootPartition:  boot,
+		Root1Partition: lvmVolumePath(vg, "root1"),
+		Root2Partition: lvmVolumePath(vg, "root2"),
+		VarPartition:   lvmVolumePath(vg, "var"),
+		VolumeGroup:    vg,
+	}
+	if swap {
+		scheme.SwapPartition = lvmVolumePath(vg, "swap")
+	}
+	return scheme
+}
+
+// lvmCreateCommands returns the commands that set up the volume group vg on
+// the physical volume pv and create its logical volumes, with a swap volume
+// of swapSize bytes if swapSize is not 0
+func lvmCreateCommands(pv, vg string, swapSize uint64) []Command {
+	// The roots are as large as the root partitions of the default layout
+	rootSize := defaultGPTLayout()[1].Size
+	lvcreate := func(lv string, size ...string) Command {
+		args := append([]string{"--yes", "--wipesignatures", "y", "--name", lv}, size...)
+		return Command{Name: "lvcreate", Args: append(args, vg)}
+	}
+
+	cmds := []Command{
+		// The partition may still carry the label of an earlier install
+		{Name: "wipefs", Args: []string{"--all", pv}},
+		{Name: "pvcreate", Args: []string{"--yes", pv}},
+		{Name: "vgcreate", Args: []string{"--yes", vg, pv}},
+		lvcreate("root1", "--size", fmt.Sprintf("%db", rootSize)),
+		lvcreate("root2", "--size", fmt.Sprintf("%db", rootSize)),
+	}
+	if swapSize > 0 {
+		cmds = append(cmds, lvcreate("swap", "--size", fmt.Sprintf("%db", swapSize)))
+	}
+	return append(cmds, lvcreate("var", "--extents", lvmVarExtents))
+}
+
+// CreateLVMPartitions creates a GPT partition table with the boot partition
+// and an LVM physical volume, and the volume group vg on it with the root,
+// /var and, if swapSize is not 0, swap logical volumes
+func CreateLVMPartitions(ctx context.Context, device, vg string, swapSize uint64, dryRun bool) (*PartitionScheme, error) {
+	if dryRun {
+		fmt.Printf("[DRY RUN] Would create partitions and LVM volume group %s on %s\n", vg, device)
+		if tracing() {
+			for _, cmd := range lvmCreateCommands("/dev/"+filepath.Base(device)+"2", vg, swapSize) {
+				if _, err := runCommand(ctx, cmd.Name, cmd.Args...); err != nil {
+					return nil, err
+				}
+			}
+		}
+		return lvmPartitionScheme("/dev/"+filepath.Base(device)+"1", vg, swapSize > 0), nil
+	}
+
+	fmt.Println("Creating GPT partition table...")
+	if _, err := writeGPTPartitionTable(ctx, device, lvmGPTLayout()); err != nil {
+		return nil, err
+	}
+	if err := settleDevices(ctx); err != nil {
+		return nil, err
+	}
+
+	pv := partitionDevicePath(device, 2)
+	fmt.Printf("Creating LVM volume group %s on %s...\n", vg, pv)
+	for _, cmd := range lvmCreateCommands(pv, vg, swapSize) {
+		if output, err := runCommand(ctx, cmd.Name, cmd.Args...); err != nil {
+			return nil, fmt.Errorf("failed to create LVM volume group: %s: %w\nOutput: %s", cmd, err, string(output))
+		}
+	}
+	// Wait for the logical volume device nodes
+	if err := settleDevices(ctx); err != nil {
+		return nil, err
+	}
+
+	scheme := lvmPartitionScheme(partitionDevicePath(device, 1), vg, swapSize > 0)
+	fmt.Printf("Created partitions:\n")
+	fmt.Printf("  Boot:  %s\n", scheme.BootPartition)
+	fmt.Printf("  Root1: %s\n", scheme.Root1Partition)
+	fmt.Printf("  Root2: %s\n", scheme.Root2Partition)
+	fmt.Printf("  Var:   %s\n", scheme.VarPartition)
+	if scheme.SwapPartition != "" {
+		fmt.Printf("  Swap:  %s\n", scheme.SwapPartition)
+	}
+	return scheme, nil
+}
+
+// checkVolumeGroupName checks that vg is a valid volume group name
+func checkVolumeGroupName(vg string) error {
+	if !volumeGroupNamePattern.MatchString(vg) || vg == "." || vg == ".." || len(vg) > 127 {
+		return fmt.Errorf("invalid volume group name %q", vg)
+	}
+	return nil
+}
+
+// CheckLVMTools checks that the LVM tools are available
+func CheckLVMTools() error {
+	for _, tool := range []string{"pvcreate", "vgcreate", "lvcreate", "vgchange", "vgs"} {
+		if _, err := executor.LookPath(tool); err != nil {
+			return fmt.Errorf("%w: %s - install the lvm2 package", ErrMissingTool, tool)
+		}
+	}
+	return nil
+}
+
+// checkVolumeGroupAvailable checks that no volume group vg exists outside
+// device, which the install would otherwise clash with
+func checkVolumeGroupAvailable(ctx context.Context, vg, device string) error {
+	output, err := runCommand(ctx, "vgs", "--noheadings", "--options", "pv_name", vg)
+	if err != nil {
+		// vgs fails for a volume group that does not exist
+		return nil
+	}
+	for _, pv := range strings.Fields(string(output)) {
+		if disk, err := GetBootDeviceFromPartition(pv); err != nil || disk != device {
+			return fmt.Errorf("volume group %s already exists on %s; choose another name with --volume-group", vg, pv)
+		}
+	}
+	return nil
+}
+
+// deactivateVolumeGroup deactivates the logical volumes of vg, so the disk
+// holding it can be wiped
+func deactivateVolumeGroup(ctx context.Context, vg string) error {
+	if output, err := runCommand(ctx, "vgchange", "--activate", "n", vg); err != nil {
+		return fmt.Errorf("failed to deactivate volume group %s: %w\nOutput: %s", vg, err, string(output))
+	}
+	return nil
+}
+
+// lvmKernelArgs returns the kernel arguments that activate the volume group
+// vg in the initramfs, or none if vg is ""
+func lvmKernelArgs(vg string) []string {
+	if vg == "" {
+		return nil
+	}
+	return []string{"rd.lvm.vg=" + vg}
+}
+
+// splitLVMName splits the device-mapper name of a logical volume into its
+// volume group and logical volume; device-mapper doubles the dashes within
+// either
+func splitLVMName(name string) (vg, lv string, ok bool) {
+	for i := 0; i < len(name); i++ {
+		if name[i] != '-' {
+			continue
+		}
+		if i+1 < len(name) && name[i+1] == '-' {
+			i++
+			continue
+		}
+		unescape := func(s string) string { return strings.ReplaceAll(s, "--", "-") }
+		return unescape(name[:i]), unescape(name[i+1:]), i > 0 && i < len(name)-1
+	}
+	return "", "", false
+}
+
+// detectLVMPartitionScheme returns the partition scheme of an LVM install on
+// device, found through the logical volumes on its second partition, or nil
+// if device has none
+func detectLVMPartitionScheme(device string) *PartitionScheme {
+	pv := filepath.Base(partitionDevicePath(device, 2))
+	var vg string
+	volumes := map[string]bool{}
+	for _, h := range collectHolders(pv, map[string]bool{}) {
+		if holderKind(h.uuid) != UsageLVM {
+			continue
+		}
+		if v, lv, ok := splitLVMName(h.name); ok {
+			vg, volumes[lv] = v, true
+		}
+	}
+	if !volumes["root1"] || !volumes["root2"] || !volumes["var"] {
+		return nil
+	}
+	return lvmPartitionScheme(partitionDevicePath(device, 1), vg, volumes["swap"])
+}
+
+// logicalVolumeNode returns the kernel name of device if it is an LVM
+// logical volume, or "" otherwise
+func logicalVolumeNode(device string) string {
+	node := filepath.Base(device)
+	if resolved, err := filepath.EvalSymlinks(device); err == nil {
+		node = filepath.Base(resolved)
+	}
+	uuid, err := os.ReadFile(filepath.Join(sysClassBlockPath, node, "dm", "uuid"))
+	if err != nil || holderKind(strings.TrimSpace(string(uuid))) != UsageLVM {
+		return ""
+	}
+	return node
+}
+
+// logicalVolumePath returns the /dev/<vg>/<lv> path of device if it is an
+// LVM logical volume, such as /dev/dm-1, so it compares equal to the paths of
+// a partition scheme, and device otherwise
+func logicalVolumePath(device string) string {
+	node := logicalVolumeNode(device)
+	if node == "" {
+		return device
+	}
+	name, err := os.ReadFile(filepath.Join(sysClassBlockPath, node, "dm", "name"))
+	if err != nil {
+		return device
+	}
+	vg, lv, ok := splitLVMName(strings.TrimSpace(string(name)))
+	if !ok {
+		return device
+	}
+	return lvmVolumePath(vg, lv)
+}
+
+// logicalVolumePartition returns the physical volume below device if it is
+// an LVM logical volume, or "" otherwise
+func logicalVolumePartition(device string) string {
+	node := logicalVolumeNode(device)
+	if node == "" {
+		return ""
+	}
+	slaves, err := os.ReadDir(filepath.Join(sysClassBlockPath, node, "slaves"))
+	if err != nil || len(slaves) == 0 {
+		return ""
+	}
+	return filepath.Join(devPath, slaves[0].Name())
+}
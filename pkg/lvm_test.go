@@ -0,0 +1,120 @@
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"testing"
+)
+
+func TestSplitLVMName(t *testing.T) {
+	tests := []struct {
+		name   string
+		vg, lv string
+		ok     bool
+	}{
+		{"phukit-root1", "phukit", "root1", true},
+		{"my--vg-var", "my-vg", "var", true},
+		{"vg-lv--data", "vg", "lv-data", true},
+		{"phukit-root1-real", "phukit", "root1-real", true},
+		{"novolume", "", "", false},
+		{"-root1", "", "root1", false},
+	}
+	for _, tt := range tests {
+		vg, lv, ok := splitLVMName(tt.name)
+		if vg != tt.vg || lv != tt.lv || ok != tt.ok {
+			t.Errorf("splitLVMName(%q) = %q, %q, %v, want %q, %q, %v", tt.name, vg, lv, ok, tt.vg, tt.lv, tt.ok)
+		}
+	}
+}
+
+func TestLVMCreateCommands(t *testing.T) {
+	got := lvmCreateCommands("/dev/sda2", "phukit", 8*1024*1024*1024)
+	want := []string{
+		"wipefs --all /dev/sda2",
+		"pvcreate --yes /dev/sda2",
+		"vgcreate --yes phukit /dev/sda2",
+		"lvcreate --yes --wipesignatures y --name root1 --size 12884901888b phukit",
+		"lvcreate --yes --wipesignatures y --name root2 --size 12884901888b phukit",
+		"lvcreate --yes --wipesignatures y --name swap --size 8589934592b phukit",
+		"lvcreate --yes --wipesignatures y --name var --extents 90%FREE phukit",
+	}
+	if len(got) != len(want) {
+		t.Fatalf("lvmCreateCommands() = %v, want %v", got, want)
+	}
+	for i, w := range want {
+		if got[i].String() != w {
+			t.Errorf("command %d = %q, want %q", i, got[i], w)
+		}
+	}
+}
+
+func TestDetectLVMPartitionScheme(t *testing.T) {
+	oldClass := sysClassBlockPath
+	sysClassBlockPath = t.TempDir()
+	t.Cleanup(func() { sysClassBlockPath = oldClass })
+
+	for i, lv := range []string{"root1", "root2", "var", "swap"} {
+		dm := fmt.Sprintf("dm-%d", i)
+		writeFakeFile(t, filepath.Join(sysClassBlockPath, "nvme0n1p2", "holders", dm), "")
+		writeFakeFile(t, filepath.Join(sysClassBlockPath, dm, "dm", "name"), "my--vg-"+lv+"\n")
+		writeFakeFile(t, filepath.Join(sysClassBlockPath, dm, "dm", "uuid"), "LVM-abc"+lv+"\n")
+	}
+	writeFakeFile(t, filepath.Join(sysClassBlockPath, "dm-0", "slaves", "nvme0n1p2"), "")
+
+	scheme := detectLVMPartitionScheme("/dev/nvme0n1")
+	if scheme == nil {
+		t.Fatal("detectLVMPartitionScheme() = nil")
+	}
+	want := PartitionScheme{
+		BootPartition:  "/dev/nvme0n1p1",
+		Root1Partition: "/dev/my-vg/root1",
+		Root2Partition: "/dev/my-vg/root2",
+		VarPartition:   "/dev/my-vg/var",
+		SwapPartition:  "/dev/my-vg/swap",
+		VolumeGroup:    "my-vg",
+	}
+	if *scheme != want {
+		t.Errorf("detectLVMPartitionScheme() = %+v, want %+v", *scheme, want)
+	}
+
+	if got := logicalVolumePath("/dev/dm-1"); got != "/dev/my-vg/root2" {
+		t.Errorf("logicalVolumePath(/dev/dm-1) = %q, want /dev/my-vg/root2", got)
+	}
+	if got := logicalVolumePath("/dev/sda2"); got != "/dev/sda2" {
+		t.Errorf("logicalVolumePath(/dev/sda2) = %q, want /dev/sda2", got)
+	}
+	if got := logicalVolumePartition("/dev/dm-0"); got != filepath.Join(devPath, "nvme0n1p2") {
+		t.Errorf("logicalVolumePartition(/dev/dm-0) = %q, want %s", got, filepath.Join(devPath, "nvme0n1p2"))
+	}
+
+	if scheme := detectLVMPartitionScheme("/dev/sda"); scheme != nil {
+		t.Errorf("detectLVMPartitionScheme(/dev/sda) = %+v, want nil", *scheme)
+	}
+}
+
+func TestCheckVolumeGroupName(t *testing.T) {
+	for _, vg := range []string{"phukit", "vg_data", "vg.1", "my-vg"} {
+		if err := checkVolumeGroupName(vg); err != nil {
+			t.Errorf("checkVolumeGroupName(%q) error = %v", vg, err)
+		}
+	}
+	for _, vg := range []string{"", "-vg", "vg/data", "..", "vg data"} {
+		if err := checkVolumeGroupName(vg); err == nil {
+			t.Errorf("checkVolumeGroupName(%q) error = nil, want error", vg)
+		}
+	}
+}
+
+func TestCheckVolumeGroupAvailable(t *testing.T) {
+	fake := setupScriptedExecutor(t)
+	fake.answer("vgs", "  /dev/sda2\n")
+
+	ctx := context.Background()
+	if err := checkVolumeGroupAvailable(ctx, "phukit", "/dev/sda"); err != nil {
+		t.Errorf("volume group on the target disk: error = %v", err)
+	}
+	if err := checkVolumeGroupAvailable(ctx, "phukit", "/dev/nvme0n1"); err == nil {
+		t.Error("volume group on another disk: error = nil, want error")
+	}
+}
@@ -0,0 +1,318 @@
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Maintenance windows
+//
+// Automatic updates (phukit daemon --auto-update, or phukit update
+// --scheduled from a timer) only stage updates inside the configured
+// maintenance windows, weekly time spans such as
+//
+//	Sat 02:00-05:00
+//	Mon-Fri 01:00-03:00 UTC
+//	Sat,Sun 23:00-01:00 Europe/Berlin
+//	daily 03:00-04:00
+//
+// in local time unless a time zone is given. A window whose end is before its
+// start ends the next day. Without windows updates may run at any time. The
+// reboot policy decides when a staged update is booted into: never (the
+// default, an operator reboots), immediate (right after staging, or as soon
+// as a staged update is found) or within-window (only inside a window).
+
+// Reboot policies of automatic updates
+const (
+	RebootNever        = "never"
+	RebootImmediate    = "immediate"
+	RebootWithinWindow = "within-window"
+)
+
+// MaintenanceWindow is a weekly time span automatic updates may run in
+type MaintenanceWindow struct {
+	Days     [7]bool       // Days the window starts on, by time.Weekday
+	Start    time.Duration // Since midnight
+	End      time.Duration // Since midnight; before Start if the window ends the next day
+	Location *time.Location
+
+	spec string
+}
+
+// ParseMaintenanceWindow parses a window such as "Sat 02:00-05:00 local"
+func ParseMaintenanceWindow(spec string) (MaintenanceWindow, error) {
+	w := MaintenanceWindow{Location: time.Local, spec: spec}
+	fields := strings.Fields(spec)
+	if len(fields) == 0 || len(fields) > 3 {
+		return w, fmt.Errorf("invalid maintenance window %q (want e.g. \"Sat 02:00-05:00\")", spec)
+	}
+
+	// An optional day list comes first, an optional time zone last
+	if !strings.Contains(fields[0], ":") {
+		if err := w.parseDays(fields[0]); err != nil {
+			return w, fmt.Errorf("invalid maintenance window %q: %w", spec, err)
+		}
+		fields = fields[1:]
+	} else {
+		w.Days = [7]bool{true, true, true, true, true, true, true}
+	}
+	if len(fields) == 0 {
+		return w, fmt.Errorf("invalid maintenance window %q: no time span", spec)
+	}
+	if len(fields) == 2 {
+		if !strings.EqualFold(fields[1], "local") {
+			loc, err := time.LoadLocation(fields[1])
+			if err != nil {
+				return w, fmt.Errorf("invalid maintenance window %q: %w", spec, err)
+			}
+			w.Location = loc
+		}
+	} else if len(fields) > 2 {
+		return w, fmt.Errorf("invalid maintenance window %q", spec)
+	}
+
+	start, end, ok := strings.Cut(fields[0], "-")
+	if !ok {
+		return w, fmt.Errorf("invalid maintenance window %q: time span must be HH:MM-HH:MM", spec)
+	}
+	var err error
+	if w.Start, err = parseTimeOfDay(start); err != nil {
+		return w, fmt.Errorf("invalid maintenance window %q: %w", spec, err)
+	}
+	if w.End, err = parseTimeOfDay(end); err != nil {
+		return w, fmt.Errorf("invalid maintenance window %q: %w", spec, err)
+	}
+	if w.Start == w.End {
+		return w, fmt.Errorf("invalid maintenance window %q: empty time span", spec)
+	}
+	return w, nil
+}
+
+// parseDays parses "daily", a day ("Sat"), a range ("Mon-Fri") or a list of
+// those ("Sat,Sun")
+func (w *MaintenanceWindow) parseDays(s string) error {
+	if strings.EqualFold(s, "daily") {
+		w.Days = [7]bool{true, true, true, true, true, true, true}
+		return nil
+	}
+	for _, part := range strings.Split(s, ",") {
+		first, last, isRange := strings.Cut(part, "-")
+		from, err := parseWeekday(first)
+		if err != nil {
+			return err
+		}
+		to := from
+		if isRange {
+			if to, err = parseWeekday(last); err != nil {
+				return err
+			}
+		}
+		for d := from; ; d = (d + 1) % 7 {
+			w.Days[d] = true
+			if d == to {
+				break
+			}
+		}
+	}
+	return nil
+}
+
+// parseWeekday parses a day name such as Sat or saturday
+func parseWeekday(s string) (int, error) {
+	for d := time.Sunday; d <= time.Saturday; d++ {
+		if strings.EqualFold(s, d.String()) || strings.EqualFold(s, d.String()[:3]) {
+			return int(d), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown day %q", s)
+}
+
+// parseTimeOfDay parses HH:MM as the time since midnight; 24:00 is the end
+// of the day
+func parseTimeOfDay(s string) (time.Duration, error) {
+	var h, m int
+	if _, err := fmt.Sscanf(s, "%d:%d", &h, &m); err != nil || len(s) != 5 || h < 0 || h > 24 || m < 0 || m > 59 || (h == 24 && m != 0) {
+		return 0, fmt.Errorf("invalid time %q (want HH:MM)", s)
+	}
+	return time.Duration(h)*time.Hour + time.Duration(m)*time.Minute, nil
+}
+
+// String returns the window as it was written
+func (w MaintenanceWindow) String() string {
+	return w.spec
+}
+
+// midnight returns the start of t's day in the window's time zone
+func (w MaintenanceWindow) midnight(t time.Time) time.Time {
+	t = t.In(w.Location)
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, w.Location)
+}
+
+// Contains reports whether t is inside the window
+func (w MaintenanceWindow) Contains(t time.Time) bool {
+	day := w.midnight(t)
+	// A window that ends the next day may have started yesterday
+	for _, start := range []time.Time{day, day.AddDate(0, 0, -1)} {
+		if !w.Days[start.Weekday()] {
+			continue
+		}
+		from, to := start.Add(w.Start), start.Add(w.End)
+		if w.End < w.Start {
+			to = start.AddDate(0, 0, 1).Add(w.End)
+		}
+		if !t.Before(from) && t.Before(to) {
+			return true
+		}
+	}
+	return false
+}
+
+// NextStart returns when the window next opens after t
+func (w MaintenanceWindow) NextStart(t time.Time) time.Time {
+	day := w.midnight(t)
+	for i := 0; i <= 7; i++ {
+		start := day.AddDate(0, 0, i)
+		if w.Days[start.Weekday()] && start.Add(w.Start).After(t) {
+			return start.Add(w.Start)
+		}
+	}
+	return time.Time{}
+}
+
+// MaintenanceWindows are the windows automatic updates may run in; none
+// allows any time
+type MaintenanceWindows []MaintenanceWindow
+
+// ParseMaintenanceWindows parses each of specs
+func ParseMaintenanceWindows(specs []string) (MaintenanceWindows, error) {
+	var windows MaintenanceWindows
+	for _, spec := range specs {
+		w, err := ParseMaintenanceWindow(spec)
+		if err != nil {
+			return nil, err
+		}
+		windows = append(windows, w)
+	}
+	return windows, nil
+}
+
+// Contains reports whether t is inside a window, or true if there are none
+func (ws MaintenanceWindows) Contains(t time.Time) bool {
+	if len(ws) == 0 {
+		return true
+	}
+	for _, w := range ws {
+		if w.Contains(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// NextStart returns when the next window opens after t, or the zero time if
+// there are no windows
+func (ws MaintenanceWindows) NextStart(t time.Time) time.Time {
+	var next time.Time
+	for _, w := range ws {
+		if start := w.NextStart(t); !start.IsZero() && (next.IsZero() || start.Before(next)) {
+			next = start
+		}
+	}
+	return next
+}
+
+// UpdateSchedule is when automatic updates stage updates and reboot into them
+type UpdateSchedule struct {
+	Windows      MaintenanceWindows
+	RebootPolicy string // RebootNever (default), RebootImmediate or RebootWithinWindow
+}
+
+// ParseRebootPolicy checks a reboot policy, defaulting to RebootNever
+func ParseRebootPolicy(s string) (string, error) {
+	switch s {
+	case "":
+		return RebootNever, nil
+	case RebootNever, RebootImmediate, RebootWithinWindow:
+		return s, nil
+	}
+	return "", fmt.Errorf("unsupported reboot policy: %s (supported: %s, %s, %s)", s, RebootNever, RebootImmediate, RebootWithinWindow)
+}
+
+// AllowsUpdate reports whether an update may be staged at t
+func (s UpdateSchedule) AllowsUpdate(t time.Time) bool {
+	return s.Windows.Contains(t)
+}
+
+// AllowsReboot reports whether a staged update may be booted into at t
+func (s UpdateSchedule) AllowsReboot(t time.Time) bool {
+	switch s.RebootPolicy {
+	case RebootImmediate:
+		return true
+	case RebootWithinWindow:
+		return s.Windows.Contains(t)
+	}
+	return false
+}
+
+// DeferredUntil describes when something deferred by the schedule at t can
+// happen
+func (s UpdateSchedule) DeferredUntil(t time.Time) string {
+	if next := s.Windows.NextStart(t); !next.IsZero() {
+		return next.Format("Mon 2006-01-02 15:04 MST")
+	}
+	return "the next maintenance window"
+}
+
+// UpdateStaged reports whether an update is staged: the system config names
+// another image than the booted root was deployed from
+func UpdateStaged() bool {
+	config, err := ReadSystemConfig()
+	if err != nil || config.ImageDigest == "" {
+		return false
+	}
+	booted, err := ReadDeployment(bootedRoot)
+	if err != nil || booted.ImageDigest == "" {
+		return false
+	}
+	return booted.ImageDigest != config.ImageDigest
+}
+
+// ScheduledUpdate checks for an update, stages it if the schedule allows it
+// now and reboots into a staged update if the reboot policy allows it. It
+// returns whether it rebooted.
+func (m *Manager) ScheduledUpdate(ctx context.Context, schedule UpdateSchedule) (bool, error) {
+	needed, err := m.checkForUpdate(ctx)
+	if err != nil {
+		return false, err
+	}
+	now := time.Now()
+	if needed {
+		if !schedule.AllowsUpdate(now) {
+			logger.Info("update deferred to maintenance window", "next_window", schedule.DeferredUntil(now))
+		} else if err := m.Run(ctx, OperationUpdate, updateFunc(UpdateRequest{})); err != nil {
+			return false, err
+		}
+	}
+	return RebootIfScheduled(ctx, schedule, false)
+}
+
+// RebootIfScheduled reboots into a staged update if the reboot policy of
+// schedule allows it now, and returns whether it did
+func RebootIfScheduled(ctx context.Context, schedule UpdateSchedule, dryRun bool) (bool, error) {
+	if schedule.RebootPolicy == RebootNever || schedule.RebootPolicy == "" || !UpdateStaged() {
+		return false, nil
+	}
+	now := time.Now()
+	if !schedule.AllowsReboot(now) {
+		fmt.Printf("Reboot into the staged update deferred until %s\n", schedule.DeferredUntil(now))
+		logger.Info("reboot deferred to maintenance window", "next_window", schedule.DeferredUntil(now))
+		return false, nil
+	}
+	logger.Info("rebooting into the staged update", "reboot_policy", schedule.RebootPolicy)
+	if err := Reboot(ctx, dryRun); err != nil {
+		return false, err
+	}
+	return true, nil
+}
@@ -0,0 +1,156 @@
+package pkg
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseMaintenanceWindow(t *testing.T) {
+	for _, tt := range []struct {
+		spec       string
+		days       string // Days by time.Weekday, Sunday first
+		start, end time.Duration
+		zone       string
+	}{
+		{"Sat 02:00-05:00", "0000001", 2 * time.Hour, 5 * time.Hour, "Local"},
+		{"sat 02:00-05:00 local", "0000001", 2 * time.Hour, 5 * time.Hour, "Local"},
+		{"Mon-Fri 01:30-03:00 UTC", "0111110", 90 * time.Minute, 3 * time.Hour, "UTC"},
+		{"Fri-Mon 22:00-02:00", "1100011", 22 * time.Hour, 2 * time.Hour, "Local"},
+		{"Saturday,Sun 23:00-24:00", "1000001", 23 * time.Hour, 24 * time.Hour, "Local"},
+		{"daily 03:00-04:00", "1111111", 3 * time.Hour, 4 * time.Hour, "Local"},
+		{"03:00-04:00 UTC", "1111111", 3 * time.Hour, 4 * time.Hour, "UTC"},
+	} {
+		w, err := ParseMaintenanceWindow(tt.spec)
+		if err != nil {
+			t.Errorf("ParseMaintenanceWindow(%q) error = %v", tt.spec, err)
+			continue
+		}
+		days := ""
+		for _, on := range w.Days {
+			if on {
+				days += "1"
+			} else {
+				days += "0"
+			}
+		}
+		if days != tt.days || w.Start != tt.start || w.End != tt.end || w.Location.String() != tt.zone {
+			t.Errorf("ParseMaintenanceWindow(%q) = days %s, %v-%v %s, want days %s, %v-%v %s",
+				tt.spec, days, w.Start, w.End, w.Location, tt.days, tt.start, tt.end, tt.zone)
+		}
+	}
+
+	for _, spec := range []string{"", "Sat", "Sat 02:00", "Sat 2:00-5:00", "Sat 02:00-02:00", "Sat 02:00-25:00", "Caturday 02:00-05:00", "Sat 02:00-05:00 Mars/Olympus", "Sat 02:00-05:00 UTC extra"} {
+		if _, err := ParseMaintenanceWindow(spec); err == nil {
+			t.Errorf("ParseMaintenanceWindow(%q) succeeded", spec)
+		}
+	}
+}
+
+func TestMaintenanceWindowContains(t *testing.T) {
+	// Saturday, 2026-03-07
+	sat := func(hour, min int) time.Time { return time.Date(2026, 3, 7, hour, min, 0, 0, time.UTC) }
+
+	w, err := ParseMaintenanceWindow("Sat 02:00-05:00 UTC")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		t    time.Time
+		want bool
+	}{
+		{sat(1, 59), false},
+		{sat(2, 0), true},
+		{sat(4, 59), true},
+		{sat(5, 0), false},
+		{sat(3, 0).AddDate(0, 0, 1), false},
+		{sat(3, 0).AddDate(0, 0, 7), true},
+		// 03:00 in UTC is 04:00 in Berlin
+		{sat(4, 0).In(mustLoadLocation(t, "Europe/Berlin")), true},
+	} {
+		if got := w.Contains(tt.t); got != tt.want {
+			t.Errorf("Contains(%s) = %v, want %v", tt.t, got, tt.want)
+		}
+	}
+
+	// A window across midnight belongs to the day it starts on
+	w, err = ParseMaintenanceWindow("Sat 23:00-01:00 UTC")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		t    time.Time
+		want bool
+	}{
+		{sat(0, 30), false},
+		{sat(23, 30), true},
+		{sat(0, 30).AddDate(0, 0, 1), true},
+		{sat(1, 0).AddDate(0, 0, 1), false},
+	} {
+		if got := w.Contains(tt.t); got != tt.want {
+			t.Errorf("Contains(%s) across midnight = %v, want %v", tt.t, got, tt.want)
+		}
+	}
+}
+
+func TestMaintenanceWindowsNextStart(t *testing.T) {
+	windows, err := ParseMaintenanceWindows([]string{"Sat 02:00-05:00 UTC", "Wed 01:00-02:00 UTC"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Thursday, 2026-03-05
+	now := time.Date(2026, 3, 5, 12, 0, 0, 0, time.UTC)
+	if got, want := windows.NextStart(now), time.Date(2026, 3, 7, 2, 0, 0, 0, time.UTC); !got.Equal(want) {
+		t.Errorf("NextStart(Thu) = %s, want %s", got, want)
+	}
+	// A window that has opened already opens next week
+	if got, want := windows.NextStart(time.Date(2026, 3, 7, 3, 0, 0, 0, time.UTC)), time.Date(2026, 3, 11, 1, 0, 0, 0, time.UTC); !got.Equal(want) {
+		t.Errorf("NextStart(Sat 03:00) = %s, want %s", got, want)
+	}
+	if got := MaintenanceWindows(nil).NextStart(now); !got.IsZero() {
+		t.Errorf("NextStart() without windows = %s, want the zero time", got)
+	}
+	if !MaintenanceWindows(nil).Contains(now) {
+		t.Error("Contains() without windows = false, want any time allowed")
+	}
+}
+
+func TestUpdateScheduleAllowsReboot(t *testing.T) {
+	windows, err := ParseMaintenanceWindows([]string{"Sat 02:00-05:00 UTC"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	inside := time.Date(2026, 3, 7, 3, 0, 0, 0, time.UTC)
+	outside := inside.Add(12 * time.Hour)
+	for _, tt := range []struct {
+		policy          string
+		inside, outside bool
+	}{
+		{RebootNever, false, false},
+		{RebootImmediate, true, true},
+		{RebootWithinWindow, true, false},
+	} {
+		s := UpdateSchedule{Windows: windows, RebootPolicy: tt.policy}
+		if got := s.AllowsReboot(inside); got != tt.inside {
+			t.Errorf("%s: AllowsReboot(inside) = %v", tt.policy, got)
+		}
+		if got := s.AllowsReboot(outside); got != tt.outside {
+			t.Errorf("%s: AllowsReboot(outside) = %v", tt.policy, got)
+		}
+	}
+
+	if policy, err := ParseRebootPolicy(""); err != nil || policy != RebootNever {
+		t.Errorf("ParseRebootPolicy(\"\") = %s, %v, want %s", policy, err, RebootNever)
+	}
+	if _, err := ParseRebootPolicy("sometimes"); err == nil {
+		t.Error("ParseRebootPolicy(sometimes) succeeded")
+	}
+}
+
+func mustLoadLocation(t *testing.T, name string) *time.Location {
+	t.Helper()
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		t.Skipf("time zone %s not available: %v", name, err)
+	}
+	return loc
+}
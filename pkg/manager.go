@@ -0,0 +1,332 @@
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// Operations run by a Manager, also used as the phase of their complete and
+// result events
+const (
+	OperationInstall  = "install"
+	OperationUpdate   = "update"
+	OperationRollback = "rollback"
+)
+
+// UpdateRequest describes an update started through a Manager
+type UpdateRequest struct {
+	ImageRef string // Empty to use the installed image
+	Force    bool   // Reinstall even if the system is up to date
+}
+
+// InstallRequest describes an install started through a Manager
+type InstallRequest struct {
+	ImageRef       string
+	Device         string
+	KernelArgs     []string
+	FilesystemType string // ext4 (default) or btrfs
+}
+
+// Manager runs operations for management services such as the D-Bus
+// interface, independent of how requests arrive. One operation runs at a
+// time, also across processes through the global operation lock.
+//
+// A Manager is a ProgressReporter: install it with SetProgressReporter and
+// every progress, complete and result event is passed on to its subscribers.
+type Manager struct {
+	mu      sync.Mutex
+	running string // Operation in progress, "" when idle
+	subs    map[int]ProgressReporter
+	nextSub int
+	wg      sync.WaitGroup
+}
+
+// NewManager creates an idle Manager
+func NewManager() *Manager {
+	return &Manager{subs: map[int]ProgressReporter{}}
+}
+
+// Subscribe passes all future events to r until the returned function is called
+func (m *Manager) Subscribe(r ProgressReporter) (unsubscribe func()) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	id := m.nextSub
+	m.nextSub++
+	m.subs[id] = r
+	return func() {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		delete(m.subs, id)
+	}
+}
+
+// Report implements ProgressReporter
+func (m *Manager) Report(event ProgressEvent) {
+	m.mu.Lock()
+	subs := make([]ProgressReporter, 0, len(m.subs))
+	for _, r := range m.subs {
+		subs = append(subs, r)
+	}
+	m.mu.Unlock()
+
+	for _, r := range subs {
+		r.Report(event)
+	}
+}
+
+// Running returns the operation in progress, or "" if the Manager is idle
+func (m *Manager) Running() string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.running
+}
+
+// Status returns the installed system and the operation in progress
+func (m *Manager) Status() (*SystemStatus, error) {
+	status, err := GetSystemStatus()
+	if err != nil {
+		return nil, err
+	}
+	status.Operation = m.Running()
+	return status, nil
+}
+
+// Update starts an update of the running system and returns without waiting
+// for it. ctx bounds the update, not the call.
+func (m *Manager) Update(ctx context.Context, req UpdateRequest) error {
+	return m.Start(ctx, OperationUpdate, updateFunc(req))
+}
+
+// updateFunc returns the operation updating the running system as req asks
+func updateFunc(req UpdateRequest) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		device, err := GetCurrentBootDeviceInfo(false)
+		if err != nil {
+			return fmt.Errorf("failed to auto-detect boot device: %w", err)
+		}
+		imageRef := req.ImageRef
+		if imageRef == "" {
+			config, err := ReadSystemConfig()
+			if err != nil {
+				return fmt.Errorf("no image specified and failed to read system config: %w", err)
+			}
+			if imageRef, err = config.ChannelImageRef(); err != nil {
+				return err
+			}
+		}
+
+		updater := NewSystemUpdater(device, imageRef)
+		updater.SetForce(req.Force)
+		updater.SetAssumeYes(true)
+		return updater.PerformUpdate(ctx, false)
+	}
+}
+
+// Rollback makes the other root the default boot entry and waits for it.
+// Unlike 'phukit rollback', it keeps /var as it is.
+func (m *Manager) Rollback(ctx context.Context) error {
+	return m.Run(ctx, OperationRollback, func(ctx context.Context) error {
+		device, err := GetCurrentBootDeviceInfo(false)
+		if err != nil {
+			return fmt.Errorf("failed to auto-detect boot device: %w", err)
+		}
+		updater := NewSystemUpdater(device, "")
+		updater.SetAssumeYes(true)
+		// Nobody is asked, so the changes to /var since the update are kept
+		updater.SetRestoreVar(false)
+		return updater.Rollback(ctx)
+	})
+}
+
+// CheckForUpdate checks the registry for a newer image of the running system
+// and refreshes the update notice. It skips the check while an operation runs.
+func (m *Manager) CheckForUpdate(ctx context.Context) error {
+	_, err := m.checkForUpdate(ctx)
+	return err
+}
+
+// checkForUpdate is CheckForUpdate, also reporting whether an update is
+// available
+func (m *Manager) checkForUpdate(ctx context.Context) (bool, error) {
+	if op := m.Running(); op != "" {
+		return false, fmt.Errorf("%w: %s is running", ErrOperationInProgress, op)
+	}
+	config, err := ReadSystemConfig()
+	if err != nil {
+		return false, err
+	}
+	imageRef, err := config.ChannelImageRef()
+	if err != nil {
+		return false, err
+	}
+	updater := NewSystemUpdater(config.Device, imageRef)
+	needed, digest, err := updater.IsUpdateNeeded(ctx)
+	if err != nil {
+		return false, fmt.Errorf("failed to check for updates: %w", err)
+	}
+	return needed, RefreshUpdateNotice(imageRef, digest, needed)
+}
+
+// Install starts an install to another disk and returns without waiting for
+// it. ctx bounds the install, not the call.
+func (m *Manager) Install(ctx context.Context, req InstallRequest) error {
+	if req.ImageRef == "" || req.Device == "" {
+		return fmt.Errorf("install needs an image and a device")
+	}
+	fsType := req.FilesystemType
+	if fsType == "" {
+		fsType = string(FilesystemExt4)
+	}
+	if fsType != string(FilesystemExt4) && fsType != string(FilesystemBtrfs) {
+		return fmt.Errorf("unsupported filesystem type: %s (supported: ext4, btrfs)", fsType)
+	}
+	device, err := GetDiskByPath(req.Device)
+	if err != nil {
+		return fmt.Errorf("invalid device: %w", err)
+	}
+
+	return m.Start(ctx, OperationInstall, func(ctx context.Context) error {
+		installer := NewBootcInstaller(req.ImageRef, device)
+		installer.SetFilesystemType(fsType)
+		installer.SetAssumeYes(true)
+		for _, arg := range req.KernelArgs {
+			installer.AddKernelArg(arg)
+		}
+		return installer.InstallComplete(ctx, false)
+	})
+}
+
+// Run runs fn as operation and returns its error, or ErrOperationInProgress
+// if another operation is running
+func (m *Manager) Run(ctx context.Context, operation string, fn func(ctx context.Context) error) error {
+	if err := m.begin(operation); err != nil {
+		return err
+	}
+	defer m.end()
+	return m.run(ctx, operation, fn)
+}
+
+// Start runs fn as operation in the background, or returns
+// ErrOperationInProgress if another operation is running. The outcome is
+// reported to subscribers as complete and result events.
+func (m *Manager) Start(ctx context.Context, operation string, fn func(ctx context.Context) error) error {
+	if err := m.begin(operation); err != nil {
+		return err
+	}
+	m.wg.Add(1)
+	go func() {
+		defer m.wg.Done()
+		defer m.end()
+		_ = m.run(ctx, operation, fn)
+	}()
+	return nil
+}
+
+// Wait waits for operations started with Start to finish
+func (m *Manager) Wait() {
+	m.wg.Wait()
+}
+
+// begin marks operation as running
+func (m *Manager) begin(operation string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.running != "" {
+		return fmt.Errorf("%w: %s is running", ErrOperationInProgress, m.running)
+	}
+	m.running = operation
+	return nil
+}
+
+// end marks the Manager idle
+func (m *Manager) end() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.running = ""
+}
+
+// run takes the operation lock and runs fn, reporting the outcome like the
+// command line does
+func (m *Manager) run(ctx context.Context, operation string, fn func(ctx context.Context) error) error {
+	oplog, lerr := StartOperationLog(operation)
+	if lerr != nil {
+		logger.Warn("operation log disabled", "error", lerr)
+	}
+	logger.Info(operation+" started", "phase", operation)
+	StartResult(operation)
+	initiator := initiatorFrom(ctx)
+	if initiator == "" {
+		initiator = "daemon"
+	}
+	SetResultInitiator(initiator)
+	err := func() error {
+		lock, err := AcquireOperationLock(ctx, false)
+		if err != nil {
+			return err
+		}
+		defer func() { _ = lock.Unlock() }()
+		return fn(ctx)
+	}()
+	status := CompletionStatus(ctx, err)
+	ReportComplete(operation, status, err)
+	result := FinishResult(status, err)
+	ReportResult(result)
+	if merr := RecordMetrics(result); merr != nil {
+		logger.Warn("failed to record metrics", "error", merr)
+	}
+	if werr := NotifyWebhooks(result); werr != nil {
+		logger.Warn("failed to notify webhooks", "error", werr)
+	}
+	if herr := RecordHistory(result); herr != nil {
+		logger.Warn("failed to record history", "error", herr)
+	}
+	if lerr := oplog.Close(result); lerr != nil {
+		logger.Warn("failed to write operation log", "error", lerr)
+	}
+	return err
+}
+
+// eventBufferSize is the number of events buffered for each watcher. A
+// watcher that falls further behind is dropped rather than stalling the
+// operation.
+const eventBufferSize = 1024
+
+// watch buffers the events of m for one streaming client until the returned
+// function is called
+func (m *Manager) watch() (*eventBuffer, func()) {
+	events := &eventBuffer{
+		events:   make(chan ProgressEvent, eventBufferSize),
+		overflow: make(chan struct{}),
+	}
+	return events, m.Subscribe(events)
+}
+
+// eventBuffer is a ProgressReporter that queues events for one stream
+type eventBuffer struct {
+	events   chan ProgressEvent
+	once     sync.Once
+	overflow chan struct{} // Closed when an event was dropped
+}
+
+// Report implements ProgressReporter without ever blocking the operation
+func (b *eventBuffer) Report(event ProgressEvent) {
+	select {
+	case b.events <- event:
+	default:
+		b.once.Do(func() { close(b.overflow) })
+	}
+}
+
+// result returns the buffered result document of operation, discarding the
+// events before it. Use it after a synchronous operation, when the result
+// event has already been reported.
+func (b *eventBuffer) result(operation string) *OperationResult {
+	for len(b.events) > 0 {
+		event := <-b.events
+		if event.Type == EventResult && event.Phase == operation {
+			return event.Result
+		}
+	}
+	return nil
+}
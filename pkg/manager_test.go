@@ -0,0 +1,104 @@
+package pkg
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestManagerOneOperationAtATime(t *testing.T) {
+	setupOperationLockPath(t)
+	m := NewManager()
+
+	release := make(chan struct{})
+	started := make(chan struct{})
+	err := m.Start(context.Background(), OperationUpdate, func(ctx context.Context) error {
+		close(started)
+		<-release
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	<-started
+
+	if got := m.Running(); got != OperationUpdate {
+		t.Errorf("Running() = %q, want %q", got, OperationUpdate)
+	}
+	err = m.Run(context.Background(), OperationRollback, func(ctx context.Context) error { return nil })
+	if !errors.Is(err, ErrOperationInProgress) {
+		t.Errorf("Run() while busy error = %v, want ErrOperationInProgress", err)
+	}
+
+	close(release)
+	m.Wait()
+	if got := m.Running(); got != "" {
+		t.Errorf("Running() after Wait = %q, want idle", got)
+	}
+}
+
+func TestManagerReportsToSubscribers(t *testing.T) {
+	setupOperationLockPath(t)
+	m := NewManager()
+	SetProgressReporter(m)
+	t.Cleanup(func() { SetProgressReporter(nil) })
+
+	first, second := &recordingReporter{}, &recordingReporter{}
+	m.Subscribe(first)
+	unsubscribe := m.Subscribe(second)
+
+	failure := errors.New("boom")
+	err := m.Run(context.Background(), OperationRollback, func(ctx context.Context) error {
+		reportPhaseProgress(PhaseBootloader, 1, 2, "writing")
+		return failure
+	})
+	if !errors.Is(err, failure) {
+		t.Fatalf("Run() error = %v, want %v", err, failure)
+	}
+
+	wantTypes := []string{EventPhaseProgress, EventComplete, EventResult}
+	for _, r := range []*recordingReporter{first, second} {
+		if len(r.events) != len(wantTypes) {
+			t.Fatalf("got %d events, want %d", len(r.events), len(wantTypes))
+		}
+		for i, want := range wantTypes {
+			if r.events[i].Type != want {
+				t.Errorf("event %d type = %q, want %q", i, r.events[i].Type, want)
+			}
+		}
+	}
+	result := first.events[2].Result
+	if result == nil || result.Operation != OperationRollback || result.Status != StatusFailed {
+		t.Errorf("result = %+v, want failed rollback", result)
+	}
+
+	// The operation lock was released
+	lock, err := AcquireOperationLock(context.Background(), false)
+	if err != nil {
+		t.Fatalf("AcquireOperationLock() after Run error = %v", err)
+	}
+	_ = lock.Unlock()
+
+	unsubscribe()
+	m.Report(ProgressEvent{Type: EventPhaseProgress})
+	if len(first.events) != 4 || len(second.events) != 3 {
+		t.Errorf("after unsubscribe got %d and %d events, want 4 and 3", len(first.events), len(second.events))
+	}
+}
+
+func TestManagerInstallValidatesRequest(t *testing.T) {
+	m := NewManager()
+	tests := []InstallRequest{
+		{Device: "/dev/sda"},
+		{ImageRef: "quay.io/example/os:latest"},
+		{ImageRef: "quay.io/example/os:latest", Device: "/dev/sda", FilesystemType: "xfs"},
+	}
+	for _, req := range tests {
+		if err := m.Install(context.Background(), req); err == nil {
+			t.Errorf("Install(%+v) succeeded, want error", req)
+		}
+	}
+	if got := m.Running(); got != "" {
+		t.Errorf("Running() = %q, want idle after rejected requests", got)
+	}
+}
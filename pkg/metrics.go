@@ -0,0 +1,264 @@
+package pkg
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// MetricsStatePath keeps the outcome of updates and rollbacks for the metrics
+// endpoint. It lives in /var so it survives the switch to the other root.
+var MetricsStatePath = "/var/lib/phukit/metrics.json"
+
+// MetricsState is the persisted part of the metrics
+type MetricsState struct {
+	LastUpdate      *UpdateOutcome `json:"last_update,omitempty"`
+	Updates         map[string]int `json:"updates"`   // Update attempts by status
+	Rollbacks       int            `json:"rollbacks"` // Successful rollbacks
+	DownloadedBytes int64          `json:"downloaded_bytes"`
+	// ExpectedSlot is the root the last successful update or rollback made the
+	// default, since ExpectedSince
+	ExpectedSlot  string    `json:"expected_slot,omitempty"`
+	ExpectedSince time.Time `json:"expected_since,omitzero"`
+}
+
+// UpdateOutcome describes one update attempt
+type UpdateOutcome struct {
+	Time            time.Time `json:"time"`
+	Status          string    `json:"status"`
+	ImageRef        string    `json:"image_ref,omitempty"`
+	DurationSeconds float64   `json:"duration_seconds"`
+	DownloadedBytes int64     `json:"downloaded_bytes"`
+}
+
+// RecordMetrics adds the outcome of an update or rollback to the metrics
+// state. Other operations, dry runs and declined confirmations are ignored.
+func RecordMetrics(r *OperationResult) error {
+	if r == nil || !r.changesSystem || r.ErrorCode == CodeAborted {
+		return nil
+	}
+	state, err := ReadMetricsState()
+	if err != nil {
+		return err
+	}
+	state.apply(r)
+
+	if err := os.MkdirAll(filepath.Dir(MetricsStatePath), 0755); err != nil {
+		return fmt.Errorf("failed to create metrics state directory: %w", err)
+	}
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal metrics state: %w", err)
+	}
+	if err := writeFileAtomic(MetricsStatePath, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to write metrics state: %w", err)
+	}
+	return nil
+}
+
+// ReadMetricsState reads the metrics state, which is empty before the first
+// update
+func ReadMetricsState() (*MetricsState, error) {
+	state := &MetricsState{Updates: map[string]int{}}
+	data, err := os.ReadFile(MetricsStatePath)
+	if os.IsNotExist(err) {
+		return state, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read metrics state: %w", err)
+	}
+	if err := json.Unmarshal(data, state); err != nil {
+		return nil, fmt.Errorf("failed to parse metrics state: %w", err)
+	}
+	if state.Updates == nil {
+		state.Updates = map[string]int{}
+	}
+	return state, nil
+}
+
+// apply adds the outcome of r
+func (s *MetricsState) apply(r *OperationResult) {
+	switch r.Operation {
+	case OperationUpdate:
+		var downloaded int64
+		if r.Extract != nil {
+			downloaded = r.Extract.DownloadedBytes
+		}
+		s.LastUpdate = &UpdateOutcome{
+			Time:            r.EndTime,
+			Status:          r.Status,
+			ImageRef:        r.ImageRef,
+			DurationSeconds: r.DurationSeconds,
+			DownloadedBytes: downloaded,
+		}
+		s.Updates[r.Status]++
+		s.DownloadedBytes += downloaded
+	case OperationRollback:
+		if r.Status == StatusSuccess {
+			s.Rollbacks++
+		}
+	default:
+		return
+	}
+	// An update that found nothing to do leaves the boot entries alone
+	if r.Status == StatusSuccess && r.TargetSlot != "" {
+		s.ExpectedSlot = r.TargetSlot
+		s.ExpectedSince = r.EndTime
+	}
+}
+
+// BootSucceeded reports whether the machine runs the root the last update or
+// rollback selected. It is false only when the machine rebooted since then
+// and came up on the other root, e.g. because the new root failed to boot.
+func (s *MetricsState) BootSucceeded(activeSlot string, bootTime time.Time) bool {
+	if s.ExpectedSlot == "" || activeSlot == "" || bootTime.Before(s.ExpectedSince) {
+		return true
+	}
+	return activeSlot == s.ExpectedSlot
+}
+
+// NewMetricsHandler serves metrics in the Prometheus text format. The metrics
+// describe this machine and the outcome of updates and rollbacks run by any
+// phukit process.
+func NewMetricsHandler(manager *Manager) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		WriteMetrics(w, manager, time.Now())
+	})
+}
+
+// WriteMetrics writes the metrics in the Prometheus text format. Values that
+// cannot be determined are left out.
+func WriteMetrics(w io.Writer, manager *Manager, now time.Time) {
+	var activeSlot string
+	if status, err := GetSystemStatus(); err == nil {
+		activeSlot = status.ActiveSlot
+	}
+	if booted, err := ReadDeployment(bootedRoot); err == nil {
+		writeMetric(w, "phukit_installed_image_info", "gauge", "Image of the booted root.",
+			metricSample{labels: []string{"image_ref", booted.ImageRef, "image_digest", booted.ImageDigest, "version", booted.DisplayVersion(), "slot", activeSlot}, value: 1})
+		if !booted.Timestamp.IsZero() {
+			writeMetric(w, "phukit_installed_image_timestamp_seconds", "gauge", "When the booted root was written, as a Unix timestamp.",
+				metricSample{value: unixSeconds(booted.Timestamp)})
+			writeMetric(w, "phukit_installed_image_age_seconds", "gauge", "Seconds since the booted root was written.",
+				metricSample{value: now.Sub(booted.Timestamp).Seconds()})
+		}
+	}
+
+	state, err := ReadMetricsState()
+	if err != nil {
+		state = &MetricsState{Updates: map[string]int{}}
+	}
+	if u := state.LastUpdate; u != nil {
+		success := 0.0
+		if u.Status == StatusSuccess {
+			success = 1
+		}
+		writeMetric(w, "phukit_last_update_timestamp_seconds", "gauge", "When the last update attempt finished, as a Unix timestamp.",
+			metricSample{value: unixSeconds(u.Time)})
+		writeMetric(w, "phukit_last_update_success", "gauge", "1 if the last update attempt succeeded.",
+			metricSample{value: success})
+		writeMetric(w, "phukit_last_update_duration_seconds", "gauge", "Duration of the last update attempt.",
+			metricSample{value: u.DurationSeconds})
+		writeMetric(w, "phukit_last_update_downloaded_bytes", "gauge", "Compressed image bytes downloaded by the last update attempt.",
+			metricSample{value: float64(u.DownloadedBytes)})
+	}
+
+	var updates []metricSample
+	for _, status := range sortedKeys(state.Updates) {
+		updates = append(updates, metricSample{labels: []string{"status", status}, value: float64(state.Updates[status])})
+	}
+	writeMetric(w, "phukit_updates_total", "counter", "Update attempts by outcome.", updates...)
+	writeMetric(w, "phukit_rollbacks_total", "counter", "Successful rollbacks.",
+		metricSample{value: float64(state.Rollbacks)})
+	writeMetric(w, "phukit_downloaded_bytes_total", "counter", "Compressed image bytes downloaded by updates.",
+		metricSample{value: float64(state.DownloadedBytes)})
+
+	if bootTime, err := readBootTime(); err == nil {
+		success := 0.0
+		if state.BootSucceeded(activeSlot, bootTime) {
+			success = 1
+		}
+		writeMetric(w, "phukit_boot_success", "gauge", "0 if the machine rebooted after an update or rollback and came up on the other root.",
+			metricSample{value: success})
+	}
+
+	if manager != nil {
+		var running []metricSample
+		for _, op := range []string{OperationInstall, OperationUpdate, OperationRollback} {
+			value := 0.0
+			if manager.Running() == op {
+				value = 1
+			}
+			running = append(running, metricSample{labels: []string{"operation", op}, value: value})
+		}
+		writeMetric(w, "phukit_operation_in_progress", "gauge", "1 while the operation runs.", running...)
+	}
+}
+
+// metricSample is one value of a metric; labels alternate names and values
+type metricSample struct {
+	labels []string
+	value  float64
+}
+
+// writeMetric writes a metric family in the Prometheus text format
+func writeMetric(w io.Writer, name, kind, help string, samples ...metricSample) {
+	_, _ = fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+	for _, s := range samples {
+		var labels []string
+		for i := 0; i+1 < len(s.labels); i += 2 {
+			labels = append(labels, fmt.Sprintf(`%s="%s"`, s.labels[i], labelEscaper.Replace(s.labels[i+1])))
+		}
+		series := name
+		if len(labels) > 0 {
+			series += "{" + strings.Join(labels, ",") + "}"
+		}
+		_, _ = fmt.Fprintf(w, "%s %s\n", series, strconv.FormatFloat(s.value, 'g', -1, 64))
+	}
+}
+
+// labelEscaper escapes label values for the text format
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// unixSeconds converts t to fractional Unix seconds
+func unixSeconds(t time.Time) float64 {
+	return float64(t.UnixNano()) / 1e9
+}
+
+// sortedKeys returns the keys of m in order
+func sortedKeys(m map[string]int) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// procStatPath is read for the boot time, a variable for tests
+var procStatPath = "/proc/stat"
+
+// readBootTime returns when the machine booted
+func readBootTime() (time.Time, error) {
+	data, err := os.ReadFile(procStatPath)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to read boot time: %w", err)
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if value, ok := strings.CutPrefix(line, "btime "); ok {
+			secs, err := strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+			if err != nil {
+				return time.Time{}, fmt.Errorf("failed to parse boot time: %w", err)
+			}
+			return time.Unix(secs, 0), nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("boot time not found in %s", procStatPath)
+}
@@ -0,0 +1,145 @@
+package pkg
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func setupMetricsStatePath(t *testing.T) {
+	t.Helper()
+	old := MetricsStatePath
+	MetricsStatePath = filepath.Join(t.TempDir(), "var", "lib", "phukit", "metrics.json")
+	t.Cleanup(func() { MetricsStatePath = old })
+}
+
+func TestRecordMetrics(t *testing.T) {
+	setupMetricsStatePath(t)
+	end := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	results := []*OperationResult{
+		// Not marked: dry runs and other operations
+		{Operation: OperationUpdate, Status: StatusSuccess, TargetSlot: "root1"},
+		{Operation: OperationUpdate, Status: StatusFailed, ErrorCode: CodeImageNotFound, EndTime: end, changesSystem: true},
+		{Operation: OperationUpdate, Status: StatusFailed, ErrorCode: CodeAborted, EndTime: end, changesSystem: true},
+		{
+			Operation: OperationUpdate, Status: StatusSuccess, ImageRef: "quay.io/example/os:latest",
+			TargetSlot: "root2", EndTime: end.Add(time.Hour), DurationSeconds: 90,
+			Extract: &ExtractStats{DownloadedBytes: 1000}, changesSystem: true,
+		},
+		{Operation: OperationRollback, Status: StatusSuccess, TargetSlot: "root1", EndTime: end.Add(2 * time.Hour), changesSystem: true},
+	}
+	for _, r := range results {
+		if err := RecordMetrics(r); err != nil {
+			t.Fatalf("RecordMetrics() error = %v", err)
+		}
+	}
+
+	state, err := ReadMetricsState()
+	if err != nil {
+		t.Fatalf("ReadMetricsState() error = %v", err)
+	}
+	if state.Updates[StatusSuccess] != 1 || state.Updates[StatusFailed] != 1 {
+		t.Errorf("Updates = %v, want one success and one failure", state.Updates)
+	}
+	if state.Rollbacks != 1 {
+		t.Errorf("Rollbacks = %d, want 1", state.Rollbacks)
+	}
+	if state.DownloadedBytes != 1000 {
+		t.Errorf("DownloadedBytes = %d, want 1000", state.DownloadedBytes)
+	}
+	last := state.LastUpdate
+	if last == nil || last.Status != StatusSuccess || last.DurationSeconds != 90 || !last.Time.Equal(end.Add(time.Hour)) {
+		t.Errorf("LastUpdate = %+v, want the successful update", last)
+	}
+	if state.ExpectedSlot != "root1" || !state.ExpectedSince.Equal(end.Add(2*time.Hour)) {
+		t.Errorf("expected slot = %s since %s, want root1 after the rollback", state.ExpectedSlot, state.ExpectedSince)
+	}
+}
+
+func TestBootSucceeded(t *testing.T) {
+	since := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	state := &MetricsState{ExpectedSlot: "root2", ExpectedSince: since}
+
+	tests := []struct {
+		name       string
+		state      *MetricsState
+		activeSlot string
+		bootTime   time.Time
+		want       bool
+	}{
+		{"no update yet", &MetricsState{}, "root1", since, true},
+		{"not rebooted since the update", state, "root1", since.Add(-time.Hour), true},
+		{"booted the new root", state, "root2", since.Add(time.Hour), true},
+		{"fell back to the old root", state, "root1", since.Add(time.Hour), false},
+		{"unknown active root", state, "", since.Add(time.Hour), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.state.BootSucceeded(tt.activeSlot, tt.bootTime); got != tt.want {
+				t.Errorf("BootSucceeded() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWriteMetric(t *testing.T) {
+	var buf bytes.Buffer
+	writeMetric(&buf, "phukit_test", "counter", "A test.",
+		metricSample{labels: []string{"image", `a"b\c` + "\n"}, value: 2},
+		metricSample{value: 0.5})
+
+	want := "# HELP phukit_test A test.\n" +
+		"# TYPE phukit_test counter\n" +
+		`phukit_test{image="a\"b\\c\n"} 2` + "\n" +
+		"phukit_test 0.5\n"
+	if got := buf.String(); got != want {
+		t.Errorf("writeMetric() =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestWriteMetricsFromState(t *testing.T) {
+	setupMetricsStatePath(t)
+	err := RecordMetrics(&OperationResult{
+		Operation: OperationUpdate, Status: StatusFailed, EndTime: time.Unix(1700000000, 0),
+		DurationSeconds: 12, changesSystem: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	WriteMetrics(&buf, NewManager(), time.Now())
+	for _, want := range []string{
+		"phukit_last_update_timestamp_seconds 1.7e+09\n",
+		"phukit_last_update_success 0\n",
+		"phukit_last_update_duration_seconds 12\n",
+		`phukit_updates_total{status="failed"} 1` + "\n",
+		"phukit_rollbacks_total 0\n",
+		`phukit_operation_in_progress{operation="update"} 0` + "\n",
+	} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("metrics missing %q:\n%s", want, buf.String())
+		}
+	}
+}
+
+func TestReadBootTime(t *testing.T) {
+	old := procStatPath
+	procStatPath = filepath.Join(t.TempDir(), "stat")
+	t.Cleanup(func() { procStatPath = old })
+
+	if err := os.WriteFile(procStatPath, []byte("cpu  1 2 3\nbtime 1700000000\nprocesses 42\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	got, err := readBootTime()
+	if err != nil {
+		t.Fatalf("readBootTime() error = %v", err)
+	}
+	if !got.Equal(time.Unix(1700000000, 0)) {
+		t.Errorf("readBootTime() = %v, want 1700000000", got)
+	}
+}
@@ -0,0 +1,531 @@
+package pkg
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/layout"
+	"github.com/google/go-containerregistry/pkg/v1/match"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+)
+
+// Image mirroring
+//
+// MirrorImage copies an image from a registry for air-gapped updates, to
+//
+//	oci:DIR[:TAG]           an OCI image layout directory, added to what it holds
+//	oci-archive:FILE[:TAG]  a tar file of an OCI image layout, for a USB stick
+//	[docker://]REFERENCE    another registry
+//
+// with all platforms of a multi-platform image or one. TAG names the image
+// in the layout and defaults to the source's tag. Machines without registry
+// access install or update from the copies with --container-source skopeo,
+// e.g. --image oci-archive:/media/usb/os.tar:latest, or pull from the
+// registry it was copied to.
+
+// Destination transports of MirrorImage
+const (
+	MirrorTransportOCI        = "oci"
+	MirrorTransportOCIArchive = "oci-archive"
+	MirrorTransportRegistry   = "docker"
+)
+
+// MirrorPlatformAll copies every platform of a multi-platform image
+const MirrorPlatformAll = "all"
+
+// ociRefNameAnnotation names an image in an OCI layout
+const ociRefNameAnnotation = "org.opencontainers.image.ref.name"
+
+// MirrorOptions are the settings of a MirrorImage run
+type MirrorOptions struct {
+	Source      string // Image reference in a registry
+	Destination string // oci:DIR[:TAG], oci-archive:FILE[:TAG] or [docker://]REFERENCE
+	Platform    string // MirrorPlatformAll, os/arch[/variant], or "" for the running machine's
+	DryRun      bool
+}
+
+// MirrorResult describes a copied image
+type MirrorResult struct {
+	Source      string   `json:"source"`
+	Destination string   `json:"destination"`
+	Digest      string   `json:"digest"`
+	Platforms   []string `json:"platforms,omitempty"`
+	Blobs       int      `json:"blobs"`
+	Size        int64    `json:"size"` // Bytes of all blobs, layers compressed
+}
+
+// mirrorDestination is a parsed MirrorOptions.Destination
+type mirrorDestination struct {
+	Transport string
+	Path      string         // oci and oci-archive
+	Tag       string         // oci and oci-archive
+	Ref       name.Reference // docker
+}
+
+// parseMirrorDestination parses dst, naming the image tag in a layout
+// without one
+func parseMirrorDestination(dst, tag string) (mirrorDestination, error) {
+	for _, transport := range []string{MirrorTransportOCI, MirrorTransportOCIArchive} {
+		rest, ok := strings.CutPrefix(dst, transport+":")
+		if !ok {
+			continue
+		}
+		path := rest
+		if i := strings.LastIndex(rest, ":"); i >= 0 && !strings.Contains(rest[i+1:], "/") {
+			path, tag = rest[:i], rest[i+1:]
+			if _, err := name.NewTag("example.com/image:" + tag); err != nil {
+				return mirrorDestination{}, fmt.Errorf("%w: invalid tag %q", ErrInvalidImageReference, tag)
+			}
+		}
+		if path == "" {
+			return mirrorDestination{}, fmt.Errorf("%w: %s destination without a path", ErrInvalidImageReference, transport)
+		}
+		return mirrorDestination{Transport: transport, Path: path, Tag: tag}, nil
+	}
+
+	ref, err := name.ParseReference(strings.TrimPrefix(dst, MirrorTransportRegistry+"://"))
+	if err != nil {
+		return mirrorDestination{}, fmt.Errorf("%w: %w", ErrInvalidImageReference, err)
+	}
+	return mirrorDestination{Transport: MirrorTransportRegistry, Ref: ref}, nil
+}
+
+// mirrorPlatform parses the platform of MirrorOptions, returning nil for all
+func mirrorPlatform(platform string) (*v1.Platform, error) {
+	switch platform {
+	case MirrorPlatformAll:
+		return nil, nil
+	case "":
+		return &v1.Platform{OS: "linux", Architecture: runtime.GOARCH}, nil
+	}
+	p, err := v1.ParsePlatform(platform)
+	if err != nil {
+		return nil, fmt.Errorf("invalid platform %q: %w", platform, err)
+	}
+	return p, nil
+}
+
+// MirrorImage copies the image opts.Source to opts.Destination
+func MirrorImage(ctx context.Context, opts MirrorOptions) (*MirrorResult, error) {
+	ref, err := name.ParseReference(opts.Source)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidImageReference, err)
+	}
+	tag := ""
+	if t, ok := ref.(name.Tag); ok {
+		tag = t.TagStr()
+	}
+	dst, err := parseMirrorDestination(opts.Destination, tag)
+	if err != nil {
+		return nil, err
+	}
+	platform, err := mirrorPlatform(opts.Platform)
+	if err != nil {
+		return nil, err
+	}
+
+	fmt.Printf("Reading %s...\n", ref)
+	var extra []remote.Option
+	if platform != nil {
+		extra = append(extra, remote.WithPlatform(*platform))
+	}
+	desc, err := remoteGet(ctx, ref, extra...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read image: %w", registryError(err))
+	}
+
+	// The copied manifest: the whole index, or one image of it
+	var content mirrorContent
+	if platform == nil && desc.MediaType.IsIndex() {
+		if content.index, err = desc.ImageIndex(); err != nil {
+			return nil, fmt.Errorf("failed to read image index: %w", registryError(err))
+		}
+	} else {
+		if content.image, err = desc.Image(); err != nil {
+			return nil, fmt.Errorf("failed to read image: %w", registryError(err))
+		}
+		if platform != nil {
+			if err := checkImagePlatform(content.image, *platform); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	blobs, err := content.blobs()
+	if err != nil {
+		return nil, err
+	}
+	result := &MirrorResult{Source: ref.String(), Destination: opts.Destination, Blobs: len(blobs)}
+	if result.Digest, err = content.digest(); err != nil {
+		return nil, err
+	}
+	if result.Platforms, err = content.platforms(); err != nil {
+		return nil, err
+	}
+	for _, b := range blobs {
+		result.Size += b.size
+	}
+
+	fmt.Printf("  Digest:    %s\n", result.Digest)
+	if len(result.Platforms) > 0 {
+		fmt.Printf("  Platforms: %s\n", strings.Join(result.Platforms, ", "))
+	}
+	fmt.Printf("  Size:      %s in %d blobs\n", FormatSize(uint64(result.Size)), len(blobs))
+	if opts.DryRun {
+		fmt.Printf("[DRY RUN] Would copy the image to %s\n", opts.Destination)
+		return result, nil
+	}
+
+	fmt.Printf("Copying to %s...\n", opts.Destination)
+	if err := content.write(ctx, dst, blobs, PhaseMirror); err != nil {
+		return nil, err
+	}
+	logger.Info("image mirrored", "source", result.Source, "destination", result.Destination, "digest", result.Digest, "size", result.Size)
+	return result, nil
+}
+
+// checkImagePlatform fails if img is not for platform, which happens when
+// a single-platform image is asked for another one
+func checkImagePlatform(img v1.Image, platform v1.Platform) error {
+	cfg, err := img.ConfigFile()
+	if err != nil {
+		return fmt.Errorf("failed to read image config: %w", registryError(err))
+	}
+	if cfg.OS != platform.OS || cfg.Architecture != platform.Architecture {
+		return fmt.Errorf("%w: image is for %s/%s, not %s", ErrImageNotFound, cfg.OS, cfg.Architecture, platform)
+	}
+	return nil
+}
+
+// mirrorContent is a copied image or image index
+type mirrorContent struct {
+	image v1.Image
+	index v1.ImageIndex
+}
+
+// digest returns the manifest digest of c
+func (c mirrorContent) digest() (string, error) {
+	var h v1.Hash
+	var err error
+	if c.index != nil {
+		h, err = c.index.Digest()
+	} else {
+		h, err = c.image.Digest()
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to compute digest: %w", err)
+	}
+	return h.String(), nil
+}
+
+// platforms returns the platforms of an index's images
+func (c mirrorContent) platforms() ([]string, error) {
+	if c.index == nil {
+		cfg, err := c.image.ConfigFile()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read image config: %w", registryError(err))
+		}
+		return []string{cfg.Platform().String()}, nil
+	}
+	manifest, err := c.index.IndexManifest()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read image index: %w", registryError(err))
+	}
+	var platforms []string
+	for _, d := range manifest.Manifests {
+		// Build attestations are stored as images of an unknown platform
+		if d.Platform != nil && d.Platform.OS != "unknown" {
+			platforms = append(platforms, d.Platform.String())
+		}
+	}
+	return platforms, nil
+}
+
+// ociBlob is a blob of an OCI image layout
+type ociBlob struct {
+	digest v1.Hash
+	size   int64
+	open   func() (io.ReadCloser, error)
+}
+
+// blobs returns every blob of c once: manifests, configs and layers
+func (c mirrorContent) blobs() ([]ociBlob, error) {
+	seen := map[v1.Hash]bool{}
+	var blobs []ociBlob
+	add := func(digest v1.Hash, size int64, open func() (io.ReadCloser, error)) {
+		if !seen[digest] {
+			seen[digest] = true
+			blobs = append(blobs, ociBlob{digest: digest, size: size, open: open})
+		}
+	}
+	raw := func(data []byte) func() (io.ReadCloser, error) {
+		return func() (io.ReadCloser, error) { return io.NopCloser(bytes.NewReader(data)), nil }
+	}
+
+	var addImage func(img v1.Image) error
+	addImage = func(img v1.Image) error {
+		manifest, err := img.RawManifest()
+		if err != nil {
+			return err
+		}
+		digest, err := img.Digest()
+		if err != nil {
+			return err
+		}
+		add(digest, int64(len(manifest)), raw(manifest))
+		config, err := img.RawConfigFile()
+		if err != nil {
+			return err
+		}
+		configName, err := img.ConfigName()
+		if err != nil {
+			return err
+		}
+		add(configName, int64(len(config)), raw(config))
+		layers, err := img.Layers()
+		if err != nil {
+			return err
+		}
+		for _, layer := range layers {
+			digest, err := layer.Digest()
+			if err != nil {
+				return err
+			}
+			size, err := layer.Size()
+			if err != nil {
+				return err
+			}
+			add(digest, size, layer.Compressed)
+		}
+		return nil
+	}
+	var addIndex func(idx v1.ImageIndex) error
+	addIndex = func(idx v1.ImageIndex) error {
+		manifest, err := idx.RawManifest()
+		if err != nil {
+			return err
+		}
+		digest, err := idx.Digest()
+		if err != nil {
+			return err
+		}
+		add(digest, int64(len(manifest)), raw(manifest))
+		index, err := idx.IndexManifest()
+		if err != nil {
+			return err
+		}
+		for _, d := range index.Manifests {
+			if d.MediaType.IsIndex() {
+				child, err := idx.ImageIndex(d.Digest)
+				if err != nil {
+					return err
+				}
+				if err := addIndex(child); err != nil {
+					return err
+				}
+			} else if d.MediaType.IsImage() {
+				img, err := idx.Image(d.Digest)
+				if err != nil {
+					return err
+				}
+				if err := addImage(img); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+
+	var err error
+	if c.index != nil {
+		err = addIndex(c.index)
+	} else {
+		err = addImage(c.image)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read image manifests: %w", registryError(err))
+	}
+	return blobs, nil
+}
+
+// descriptor returns the descriptor of c in the index.json of a layout
+func (c mirrorContent) descriptor(tag string) (v1.Descriptor, error) {
+	var desc v1.Descriptor
+	var err error
+	if c.index != nil {
+		desc.MediaType, err = c.index.MediaType()
+		if err == nil {
+			desc.Digest, err = c.index.Digest()
+		}
+		if err == nil {
+			desc.Size, err = c.index.Size()
+		}
+	} else {
+		desc.MediaType, err = c.image.MediaType()
+		if err == nil {
+			desc.Digest, err = c.image.Digest()
+		}
+		if err == nil {
+			desc.Size, err = c.image.Size()
+		}
+	}
+	if err != nil {
+		return desc, fmt.Errorf("failed to describe image: %w", err)
+	}
+	if tag != "" {
+		desc.Annotations = map[string]string{ociRefNameAnnotation: tag}
+	}
+	return desc, nil
+}
+
+// write copies c with its blobs to dst, reporting the progress of an
+// archive as phase
+func (c mirrorContent) write(ctx context.Context, dst mirrorDestination, blobs []ociBlob, phase string) error {
+	switch dst.Transport {
+	case MirrorTransportOCI:
+		return c.writeLayout(dst.Path, dst.Tag)
+	case MirrorTransportOCIArchive:
+		return c.writeArchive(ctx, dst.Path, dst.Tag, blobs, phase)
+	default:
+		return c.push(ctx, dst.Ref)
+	}
+}
+
+// writeLayout adds c to the OCI layout at dir, replacing an image of the
+// same tag
+func (c mirrorContent) writeLayout(dir, tag string) error {
+	p, err := layout.FromPath(dir)
+	if err != nil {
+		if p, err = layout.Write(dir, empty.Index); err != nil {
+			return fmt.Errorf("failed to create OCI layout %s: %w", dir, err)
+		}
+	}
+	var opts []layout.Option
+	matcher := match.Annotation(ociRefNameAnnotation, tag)
+	if tag != "" {
+		opts = append(opts, layout.WithAnnotations(map[string]string{ociRefNameAnnotation: tag}))
+	} else {
+		digest, err := c.digest()
+		if err != nil {
+			return err
+		}
+		h, _ := v1.NewHash(digest)
+		matcher = match.Digests(h)
+	}
+	if c.index != nil {
+		err = p.ReplaceIndex(c.index, matcher, opts...)
+	} else {
+		err = p.ReplaceImage(c.image, matcher, opts...)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write OCI layout %s: %w", dir, registryError(err))
+	}
+	return nil
+}
+
+// writeArchive writes c with blobs as a tar file of an OCI layout to path
+func (c mirrorContent) writeArchive(ctx context.Context, path, tag string, blobs []ociBlob, phase string) error {
+	desc, err := c.descriptor(tag)
+	if err != nil {
+		return err
+	}
+	index, err := json.Marshal(v1.IndexManifest{
+		SchemaVersion: 2,
+		MediaType:     types.OCIImageIndex,
+		Manifests:     []v1.Descriptor{desc},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to marshal index.json: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+"-")
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %w", path, err)
+	}
+	defer func() { _ = os.Remove(tmp.Name()) }()
+	defer func() { _ = tmp.Close() }()
+
+	tw := tar.NewWriter(tmp)
+	writeFile := func(name string, data []byte) error {
+		if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(data)), Typeflag: tar.TypeReg}); err != nil {
+			return err
+		}
+		_, err := tw.Write(data)
+		return err
+	}
+	if err := writeFile("oci-layout", []byte(`{"imageLayoutVersion":"1.0.0"}`)); err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	if err := writeFile("index.json", index); err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	if err := tw.WriteHeader(&tar.Header{Name: "blobs/", Mode: 0755, Typeflag: tar.TypeDir}); err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	if err := tw.WriteHeader(&tar.Header{Name: "blobs/sha256/", Mode: 0755, Typeflag: tar.TypeDir}); err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	for i, b := range blobs {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		reportPhaseProgress(phase, i+1, len(blobs), b.digest.String())
+		if err := writeArchiveBlob(tw, b); err != nil {
+			return fmt.Errorf("failed to copy blob %s: %w", b.digest, registryError(err))
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	if err := tmp.Sync(); err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	return nil
+}
+
+// writeArchiveBlob copies b into tw below blobs/
+func writeArchiveBlob(tw *tar.Writer, b ociBlob) error {
+	rc, err := b.open()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = rc.Close() }()
+	hdr := &tar.Header{Name: "blobs/" + b.digest.Algorithm + "/" + b.digest.Hex, Mode: 0644, Size: b.size, Typeflag: tar.TypeReg}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	_, err = io.Copy(tw, rc)
+	return err
+}
+
+// push copies c to the registry reference dst
+func (c mirrorContent) push(ctx context.Context, dst name.Reference) error {
+	var err error
+	if c.index != nil {
+		err = remote.WriteIndex(dst, c.index, registryOptions(ctx)...)
+	} else {
+		err = remote.Write(dst, c.image, registryOptions(ctx)...)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to push to %s: %w", dst, registryError(err))
+	}
+	return nil
+}
@@ -0,0 +1,229 @@
+package pkg
+
+import (
+	"archive/tar"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/layout"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+)
+
+// pushTestIndex serves a two-platform image index from an in-memory registry
+// and returns its reference
+func pushTestIndex(t *testing.T) (string, v1.ImageIndex) {
+	t.Helper()
+	var idx v1.ImageIndex = empty.Index
+	for _, arch := range []string{"amd64", "arm64"} {
+		img, err := mutate.AppendLayers(empty.Image, testLayer(t, "usr/lib/os-release", "ID=example\nARCH="+arch+"\n"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		cfg, err := img.ConfigFile()
+		if err != nil {
+			t.Fatal(err)
+		}
+		cfg.OS, cfg.Architecture = "linux", arch
+		if img, err = mutate.ConfigFile(img, cfg); err != nil {
+			t.Fatal(err)
+		}
+		idx = mutate.AppendManifests(idx, mutate.IndexAddendum{
+			Add:        img,
+			Descriptor: v1.Descriptor{Platform: &v1.Platform{OS: "linux", Architecture: arch}},
+		})
+	}
+
+	img, err := mutate.AppendLayers(empty.Image, testLayer(t, "placeholder", ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	imageRef := pushTestImage(t, img)
+	ref, err := name.ParseReference(imageRef)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := remote.WriteIndex(ref, idx); err != nil {
+		t.Fatalf("failed to push test index: %v", err)
+	}
+	return imageRef, idx
+}
+
+func TestParseMirrorDestination(t *testing.T) {
+	for _, tt := range []struct {
+		dst, transport, path, tag string
+	}{
+		{"oci:/srv/images", MirrorTransportOCI, "/srv/images", "latest"},
+		{"oci:/srv/images:42", MirrorTransportOCI, "/srv/images", "42"},
+		{"oci-archive:/media/usb/os.tar", MirrorTransportOCIArchive, "/media/usb/os.tar", "latest"},
+		{"oci-archive:os.tar:v2", MirrorTransportOCIArchive, "os.tar", "v2"},
+		{"docker://registry.internal:5000/os:42", MirrorTransportRegistry, "", ""},
+		{"registry.internal:5000/os:42", MirrorTransportRegistry, "", ""},
+	} {
+		got, err := parseMirrorDestination(tt.dst, "latest")
+		if err != nil {
+			t.Errorf("parseMirrorDestination(%s) error = %v", tt.dst, err)
+			continue
+		}
+		if got.Transport != tt.transport || got.Path != tt.path || got.Tag != tt.tag {
+			t.Errorf("parseMirrorDestination(%s) = %+v", tt.dst, got)
+		}
+		if tt.transport == MirrorTransportRegistry && got.Ref.Name() != "registry.internal:5000/os:42" {
+			t.Errorf("parseMirrorDestination(%s) ref = %s", tt.dst, got.Ref)
+		}
+	}
+	for _, dst := range []string{"oci:", "oci-archive::42", "oci:/srv:bad tag", "not a reference"} {
+		if _, err := parseMirrorDestination(dst, ""); err == nil {
+			t.Errorf("parseMirrorDestination(%q) succeeded", dst)
+		}
+	}
+}
+
+func TestMirrorImageToLayout(t *testing.T) {
+	imageRef, idx := pushTestIndex(t)
+	want, err := idx.Digest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := filepath.Join(t.TempDir(), "images")
+
+	result, err := MirrorImage(t.Context(), MirrorOptions{Source: imageRef, Destination: "oci:" + dir, Platform: MirrorPlatformAll})
+	if err != nil {
+		t.Fatalf("MirrorImage() error = %v", err)
+	}
+	if result.Digest != want.String() || strings.Join(result.Platforms, " ") != "linux/amd64 linux/arm64" {
+		t.Errorf("MirrorImage() = %+v, want digest %s and both platforms", result, want)
+	}
+
+	// Copying again under the same tag replaces the image
+	if _, err := MirrorImage(t.Context(), MirrorOptions{Source: imageRef, Destination: "oci:" + dir, Platform: MirrorPlatformAll}); err != nil {
+		t.Fatal(err)
+	}
+	p, err := layout.FromPath(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	top, err := p.ImageIndex()
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifest, err := top.IndexManifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(manifest.Manifests) != 1 || manifest.Manifests[0].Digest != want || manifest.Manifests[0].Annotations[ociRefNameAnnotation] != "latest" {
+		t.Fatalf("index.json = %+v, want the index tagged latest", manifest.Manifests)
+	}
+	copied, err := top.ImageIndex(want)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := copied.Image(mustIndexDigest(t, idx, 1)); err != nil {
+		t.Errorf("copied layout lacks the arm64 image: %v", err)
+	}
+}
+
+func TestMirrorImageToArchive(t *testing.T) {
+	imageRef, idx := pushTestIndex(t)
+	path := filepath.Join(t.TempDir(), "os.tar")
+
+	result, err := MirrorImage(t.Context(), MirrorOptions{Source: imageRef, Destination: "oci-archive:" + path + ":42", Platform: "linux/arm64"})
+	if err != nil {
+		t.Fatalf("MirrorImage() error = %v", err)
+	}
+	if want := mustIndexDigest(t, idx, 1); result.Digest != want.String() {
+		t.Errorf("MirrorImage() digest = %s, want the arm64 image %s", result.Digest, want)
+	}
+
+	// The archive is an OCI layout holding every blob once
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = f.Close() }()
+	files := map[string][]byte{}
+	tr := tar.NewReader(f)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		data, err := io.ReadAll(tr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, dup := files[hdr.Name]; dup {
+			t.Errorf("archive holds %s twice", hdr.Name)
+		}
+		files[hdr.Name] = data
+	}
+	var index v1.IndexManifest
+	if err := json.Unmarshal(files["index.json"], &index); err != nil {
+		t.Fatal(err)
+	}
+	if len(index.Manifests) != 1 || index.Manifests[0].Digest.String() != result.Digest || index.Manifests[0].Annotations[ociRefNameAnnotation] != "42" {
+		t.Errorf("index.json = %s", files["index.json"])
+	}
+	if _, ok := files["oci-layout"]; !ok {
+		t.Error("archive lacks oci-layout")
+	}
+	blobs := 0
+	for name := range files {
+		if strings.HasPrefix(name, "blobs/sha256/") && name != "blobs/sha256/" {
+			blobs++
+		}
+	}
+	if blobs != result.Blobs || blobs != 3 {
+		t.Errorf("archive holds %d blobs, result says %d, want manifest, config and layer", blobs, result.Blobs)
+	}
+
+	// A platform the image doesn't have fails
+	if _, err := MirrorImage(t.Context(), MirrorOptions{Source: imageRef, Destination: "oci-archive:" + path, Platform: "linux/s390x"}); err == nil {
+		t.Error("MirrorImage() of a missing platform succeeded")
+	}
+}
+
+func TestMirrorImageToRegistry(t *testing.T) {
+	imageRef, idx := pushTestIndex(t)
+	want, err := idx.Digest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dst := strings.Replace(imageRef, "/example/os:latest", "/mirror/os:42", 1)
+
+	if _, err := MirrorImage(t.Context(), MirrorOptions{Source: imageRef, Destination: "docker://" + dst, Platform: MirrorPlatformAll}); err != nil {
+		t.Fatalf("MirrorImage() error = %v", err)
+	}
+	got, err := GetRemoteImageDigest(t.Context(), dst)
+	if err != nil || got != want.String() {
+		t.Errorf("mirrored image digest = %s, %v, want %s", got, err, want)
+	}
+
+	// Dry runs copy nothing
+	dry := strings.TrimSuffix(dst, ":42") + ":dry"
+	if _, err := MirrorImage(t.Context(), MirrorOptions{Source: imageRef, Destination: dry, Platform: MirrorPlatformAll, DryRun: true}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := GetRemoteImageDigest(t.Context(), dry); err == nil {
+		t.Error("dry run pushed the image")
+	}
+}
+
+// mustIndexDigest returns the digest of the i-th manifest of idx
+func mustIndexDigest(t *testing.T, idx v1.ImageIndex, i int) v1.Hash {
+	t.Helper()
+	manifest, err := idx.IndexManifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return manifest.Manifests[i].Digest
+}
@@ -0,0 +1,68 @@
+package pkg
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Update notices shown at login, like the update notices of dnf and apt.
+// pam_motd prints the files in /run/motd.d and agetty the files in
+// /run/issue.d; both live in /run, so a reboot clears them.
+var (
+	MOTDNoticePath  = "/run/motd.d/phukit"
+	IssueNoticePath = "/run/issue.d/phukit.issue"
+)
+
+// RefreshUpdateNotice writes the update notice after a check found
+// remoteDigest for imageRef, or removes it if the system runs that image.
+// needed is the result of SystemUpdater.IsUpdateNeeded; an image that is not
+// needed but differs from the booted root is staged for the next boot.
+func RefreshUpdateNotice(imageRef, remoteDigest string, needed bool) error {
+	staged := false
+	if !needed {
+		booted, err := ReadDeployment(bootedRoot)
+		if err != nil || booted.ImageDigest == "" || booted.ImageDigest == remoteDigest {
+			return ClearUpdateNotice()
+		}
+		staged = true
+	}
+	return writeUpdateNotice(updateNotice(imageRef, remoteDigest, staged))
+}
+
+// ClearUpdateNotice removes the update notice
+func ClearUpdateNotice() error {
+	for _, path := range []string{MOTDNoticePath, IssueNoticePath} {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove update notice: %w", err)
+		}
+	}
+	return nil
+}
+
+// updateNotice formats the update notice
+func updateNotice(imageRef, digest string, staged bool) string {
+	image := imageRef
+	if len(digest) > 19 {
+		image += " (" + digest[:19] + ")"
+	} else if digest != "" {
+		image += " (" + digest + ")"
+	}
+	if staged {
+		return fmt.Sprintf("phukit: Update to %s available, staged: yes\n  Reboot to boot into it.\n", image)
+	}
+	return fmt.Sprintf("phukit: Update to %s available, staged: no\n  Run 'phukit update' to install it.\n", image)
+}
+
+// writeUpdateNotice writes notice to the motd and issue snippets
+func writeUpdateNotice(notice string) error {
+	for _, path := range []string{MOTDNoticePath, IssueNoticePath} {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return fmt.Errorf("failed to create update notice directory: %w", err)
+		}
+		if err := writeFileAtomic(path, []byte(notice), 0644); err != nil {
+			return fmt.Errorf("failed to write update notice: %w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,86 @@
+package pkg
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func setupUpdateNoticePaths(t *testing.T) {
+	t.Helper()
+	oldMOTD, oldIssue := MOTDNoticePath, IssueNoticePath
+	dir := t.TempDir()
+	MOTDNoticePath = filepath.Join(dir, "motd.d", "phukit")
+	IssueNoticePath = filepath.Join(dir, "issue.d", "phukit.issue")
+	t.Cleanup(func() { MOTDNoticePath, IssueNoticePath = oldMOTD, oldIssue })
+}
+
+func TestUpdateNotice(t *testing.T) {
+	digest := "sha256:0123456789abcdef0123456789abcdef"
+	tests := []struct {
+		name   string
+		digest string
+		staged bool
+		want   string
+	}{
+		{"available", digest, false, "Update to quay.io/example/os:latest (sha256:0123456789ab) available, staged: no"},
+		{"staged", digest, true, "available, staged: yes"},
+		{"no digest", "", false, "Update to quay.io/example/os:latest available"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := updateNotice("quay.io/example/os:latest", tt.digest, tt.staged)
+			if !strings.Contains(got, tt.want) {
+				t.Errorf("updateNotice() = %q, want it to contain %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRefreshUpdateNotice(t *testing.T) {
+	setupUpdateNoticePaths(t)
+	oldRoot := bootedRoot
+	bootedRoot = t.TempDir()
+	t.Cleanup(func() { bootedRoot = oldRoot })
+	booted := &Deployment{ImageRef: "quay.io/example/os:latest", ImageDigest: "sha256:old"}
+	if err := WriteDeployment(bootedRoot, booted, false); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := RefreshUpdateNotice("quay.io/example/os:latest", "sha256:abc", true); err != nil {
+		t.Fatalf("RefreshUpdateNotice() error = %v", err)
+	}
+	for _, path := range []string{MOTDNoticePath, IssueNoticePath} {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatalf("notice not written: %v", err)
+		}
+		if !strings.Contains(string(data), "staged: no") {
+			t.Errorf("%s = %q, want an available update", path, data)
+		}
+	}
+
+	// Updated but not rebooted: the new image is staged
+	if err := RefreshUpdateNotice("quay.io/example/os:latest", "sha256:abc", false); err != nil {
+		t.Fatalf("RefreshUpdateNotice() error = %v", err)
+	}
+	if data, _ := os.ReadFile(MOTDNoticePath); !strings.Contains(string(data), "staged: yes") {
+		t.Errorf("notice = %q, want a staged update", data)
+	}
+
+	// Running the latest image: the notice goes away
+	if err := RefreshUpdateNotice("quay.io/example/os:latest", "sha256:old", false); err != nil {
+		t.Fatalf("RefreshUpdateNotice() error = %v", err)
+	}
+	for _, path := range []string{MOTDNoticePath, IssueNoticePath} {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("%s still exists after the system is up to date", path)
+		}
+	}
+
+	// Clearing twice is fine
+	if err := ClearUpdateNotice(); err != nil {
+		t.Errorf("ClearUpdateNotice() error = %v", err)
+	}
+}
@@ -0,0 +1,175 @@
+package pkg
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// Mounter mounts and unmounts filesystems
+type Mounter interface {
+	// Mount attaches source at target, see mount(2)
+	Mount(source, target, fstype string, flags uintptr, data string) error
+	// Unmount detaches the filesystem at target, see umount2(2)
+	Unmount(target string, flags int) error
+}
+
+// syscallMounter implements Mounter with the mount(2) and umount2(2) syscalls
+type syscallMounter struct{}
+
+// Mount implements Mounter
+func (syscallMounter) Mount(source, target, fstype string, flags uintptr, data string) error {
+	return unix.Mount(source, target, fstype, flags, data)
+}
+
+// Unmount implements Mounter
+func (syscallMounter) Unmount(target string, flags int) error {
+	return unix.Unmount(target, flags)
+}
+
+// mounter performs all mounts, overridable in tests
+var mounter Mounter = syscallMounter{}
+
+// procFilesystemsPath lists the filesystem types supported by the kernel
+var procFilesystemsPath = "/proc/filesystems"
+
+// preferredFilesystems are tried first when probing a device's filesystem type
+var preferredFilesystems = []string{"ext4", "btrfs", "xfs", "vfat"}
+
+// filesystemTypeOf reads the filesystem type of a device, overridable in tests
+var filesystemTypeOf = readFilesystemType
+
+// MountError describes a failed mount or unmount
+type MountError struct {
+	Op     string // "mount" or "unmount"
+	Source string
+	Target string
+	FSType string
+	Err    error
+}
+
+func (e *MountError) Error() string {
+	switch {
+	case e.Op == "unmount":
+		return fmt.Sprintf("unmount %s: %v", e.Target, e.Err)
+	case e.FSType != "":
+		return fmt.Sprintf("mount %s on %s (%s): %v", e.Source, e.Target, e.FSType, e.Err)
+	default:
+		return fmt.Sprintf("mount %s on %s: %v", e.Source, e.Target, e.Err)
+	}
+}
+
+func (e *MountError) Unwrap() error {
+	return e.Err
+}
+
+// mountDevice mounts a block device at target, probing the filesystem type
+// the way mount(8) does when none is given: from the superblock, so the
+// kernel loads the module of a filesystem it has not used yet, or by trying
+// the types the kernel supports if the superblock is not one phukit knows
+func mountDevice(source, target string, readOnly bool) error {
+	var flags uintptr
+	if readOnly {
+		flags |= unix.MS_RDONLY
+	}
+
+	fsType, err := filesystemTypeOf(source)
+	if err == nil {
+		if err := mounter.Mount(source, target, fsType, flags, ""); err != nil {
+			return &MountError{Op: "mount", Source: source, Target: target, FSType: fsType, Err: err}
+		}
+		return nil
+	}
+	if !errors.Is(err, ErrUnknownFilesystem) {
+		return &MountError{Op: "mount", Source: source, Target: target, Err: err}
+	}
+
+	fsTypes, err := kernelFilesystems()
+	if err != nil {
+		return &MountError{Op: "mount", Source: source, Target: target, Err: err}
+	}
+
+	var lastErr error = unix.ENODEV
+	for _, fsType := range fsTypes {
+		err := mounter.Mount(source, target, fsType, flags, "")
+		if err == nil {
+			return nil
+		}
+		// EINVAL means "not this filesystem", anything else is a real failure
+		if !errors.Is(err, unix.EINVAL) {
+			return &MountError{Op: "mount", Source: source, Target: target, FSType: fsType, Err: err}
+		}
+		lastErr = err
+	}
+
+	return &MountError{Op: "mount", Source: source, Target: target, Err: fmt.Errorf("no supported filesystem found: %w", lastErr)}
+}
+
+// bindMount bind-mounts source at target
+func bindMount(source, target string) error {
+	if err := mounter.Mount(source, target, "", unix.MS_BIND, ""); err != nil {
+		return &MountError{Op: "mount", Source: source, Target: target, FSType: "bind", Err: err}
+	}
+	return nil
+}
+
+// remountReadOnly makes the filesystem mounted at target read-only, which
+// also flushes its journal
+func remountReadOnly(target string) error {
+	if err := mounter.Mount("", target, "", unix.MS_REMOUNT|unix.MS_RDONLY, ""); err != nil {
+		return &MountError{Op: "mount", Target: target, FSType: "remount", Err: err}
+	}
+	return nil
+}
+
+// unmount detaches the filesystem mounted at target
+func unmount(target string) error {
+	if err := mounter.Unmount(target, 0); err != nil {
+		return &MountError{Op: "unmount", Target: target, Err: err}
+	}
+	return nil
+}
+
+// kernelFilesystems returns the block-device filesystem types the kernel
+// supports, with common phukit filesystems first
+func kernelFilesystems() ([]string, error) {
+	f, err := os.Open(procFilesystemsPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read supported filesystems: %w", err)
+	}
+	defer func() { _ = f.Close() }()
+
+	supported := map[string]bool{}
+	var others []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		// Skip "nodev" filesystems such as proc and tmpfs
+		if len(fields) != 1 {
+			continue
+		}
+		supported[fields[0]] = true
+		others = append(others, fields[0])
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read supported filesystems: %w", err)
+	}
+
+	var fsTypes []string
+	for _, fsType := range preferredFilesystems {
+		if supported[fsType] {
+			fsTypes = append(fsTypes, fsType)
+		}
+	}
+	for _, fsType := range others {
+		if !slices.Contains(preferredFilesystems, fsType) {
+			fsTypes = append(fsTypes, fsType)
+		}
+	}
+	return fsTypes, nil
+}
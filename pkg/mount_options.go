@@ -0,0 +1,79 @@
+package pkg
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Mount options
+//
+// The root and /var filesystems can be mounted with options of their own,
+// such as noatime, compress=zstd:3 or discard=async for both, or nodev for
+// /var. The options are recorded in /etc/phukit/config.json so updates
+// write them to the new boot entries as well. The root is mounted by the
+// initramfs with rootflags=, /var with systemd.mount-extra or its fstab
+// entry or mount unit (see mount_style.go), and the alternate root entry in
+// /etc/fstab carries the root's options. /etc is part of the root
+// filesystem rather than a mount of its own, so it is mounted with the
+// root's options.
+
+// MountOptions are the mount options of the root ("/") and /var
+// filesystems by mount point; a mount point without options uses defaults
+type MountOptions map[string]string
+
+// mountOptionTargets are the mount points options can be set for
+var mountOptionTargets = []string{"/", "/var"}
+
+// ParseMountOptions parses mount options given as mountpoint=options, such
+// as /var=noatime,nodev
+func ParseMountOptions(specs []string) (MountOptions, error) {
+	options := MountOptions{}
+	for _, spec := range specs {
+		target, opts, ok := strings.Cut(spec, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid mount options %q (expected mountpoint=options)", spec)
+		}
+		if _, ok := options[target]; ok {
+			return nil, fmt.Errorf("mount options for %s are given more than once", target)
+		}
+		if err := options.Set(target, opts); err != nil {
+			return nil, err
+		}
+	}
+	return options, nil
+}
+
+// Set sets the mount options of the filesystem mounted at target
+func (o MountOptions) Set(target, options string) error {
+	if !slices.Contains(mountOptionTargets, target) {
+		return fmt.Errorf("mount options cannot be set for %s (supported: %s)", target, strings.Join(mountOptionTargets, ", "))
+	}
+	if options == "" || strings.ContainsAny(options, " \t\n\"'") {
+		return fmt.Errorf("invalid mount options %q for %s", options, target)
+	}
+	for _, opt := range strings.Split(options, ",") {
+		if opt == "" {
+			return fmt.Errorf("invalid mount options %q for %s (empty option)", options, target)
+		}
+	}
+	o[target] = options
+	return nil
+}
+
+// get returns the mount options of the filesystem mounted at target
+func (o MountOptions) get(target string) string {
+	if options := o[target]; options != "" {
+		return options
+	}
+	return "defaults"
+}
+
+// rootKernelArgs returns the kernel arguments that pass the root's mount
+// options to the initramfs, or none if it uses defaults
+func (o MountOptions) rootKernelArgs() []string {
+	if o["/"] == "" {
+		return nil
+	}
+	return []string{"rootflags=" + o["/"]}
+}
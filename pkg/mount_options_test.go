@@ -0,0 +1,57 @@
+package pkg
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestParseMountOptions(t *testing.T) {
+	got, err := ParseMountOptions([]string{"/=noatime,compress=zstd:3", "/var=noatime,nodev,discard=async"})
+	if err != nil {
+		t.Fatalf("ParseMountOptions() error = %v", err)
+	}
+	want := MountOptions{"/": "noatime,compress=zstd:3", "/var": "noatime,nodev,discard=async"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ParseMountOptions() = %v, want %v", got, want)
+	}
+
+	invalid := map[string][]string{
+		"no mount point":     {"noatime"},
+		"unsupported target": {"/home=noatime"},
+		"no options":         {"/var="},
+		"empty option":       {"/var=noatime,,nodev"},
+		"whitespace":         {"/var=noatime nodev"},
+		"duplicate":          {"/var=noatime", "/var=nodev"},
+	}
+	for name, specs := range invalid {
+		t.Run(name, func(t *testing.T) {
+			if _, err := ParseMountOptions(specs); err == nil {
+				t.Errorf("ParseMountOptions(%q) error = nil, want error", specs)
+			}
+		})
+	}
+}
+
+func TestMountOptionsDefaults(t *testing.T) {
+	var options MountOptions
+	if got := options.get("/var"); got != "defaults" {
+		t.Errorf("get(/var) = %q, want defaults", got)
+	}
+	if got := options.rootKernelArgs(); got != nil {
+		t.Errorf("rootKernelArgs() = %q, want none", got)
+	}
+
+	// Options are recorded in the system configuration for updates
+	data, err := json.Marshal(SystemConfig{MountOptions: MountOptions{"/var": "nodev"}})
+	if err != nil {
+		t.Fatalf("json.Marshal() error = %v", err)
+	}
+	var config SystemConfig
+	if err := json.Unmarshal(data, &config); err != nil {
+		t.Fatalf("json.Unmarshal() error = %v", err)
+	}
+	if got := config.MountOptions.get("/var"); got != "nodev" {
+		t.Errorf("get(/var) after round trip = %q, want nodev", got)
+	}
+}
@@ -0,0 +1,170 @@
+package pkg
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Mount style
+//
+// The mounts phukit generates name their filesystems by UUID by default,
+// which never collides with another disk; labels (root1, root2, var, swap)
+// survive reformatting a partition and are easier to read. The root= kernel
+// argument always uses the UUID, as updates find the booted root by it.
+//
+// /var is mounted from the kernel command line with systemd.mount-extra by
+// default, so it follows the boot entry. It can be mounted by an /etc/fstab
+// entry or a native var.mount unit instead, which administrators can change
+// without touching boot entries; the boot entries then leave it out, so /var
+// is never mounted twice. Both live in /etc, which updates carry over. A
+// composefs root needs /var in the initramfs and always mounts it from the
+// kernel command line.
+
+// MountIdentifier is how generated mounts name their filesystems
+type MountIdentifier string
+
+const (
+	MountByUUID  MountIdentifier = "uuid"  // UUID=... (default)
+	MountByLabel MountIdentifier = "label" // LABEL=...
+)
+
+// VarMount is where /var is mounted from
+type VarMount string
+
+const (
+	VarMountCmdline VarMount = "cmdline" // systemd.mount-extra on the kernel command line (default)
+	VarMountFstab   VarMount = "fstab"   // An /etc/fstab entry
+	VarMountUnit    VarMount = "systemd" // A var.mount unit in /etc/systemd/system
+)
+
+// varMountUnit is the systemd mount unit of /var
+const varMountUnit = "var.mount"
+
+// ParseMountIdentifier parses a mount identifier; "" is UUID
+func ParseMountIdentifier(s string) (MountIdentifier, error) {
+	switch id := MountIdentifier(s); id {
+	case "":
+		return MountByUUID, nil
+	case MountByUUID, MountByLabel:
+		return id, nil
+	}
+	return "", fmt.Errorf("invalid mount identifier %q (supported: uuid, label)", s)
+}
+
+// ParseVarMount parses where /var is mounted from; "" is the kernel command line
+func ParseVarMount(s string) (VarMount, error) {
+	switch m := VarMount(s); m {
+	case "":
+		return VarMountCmdline, nil
+	case VarMountCmdline, VarMountFstab, VarMountUnit:
+		return m, nil
+	}
+	return "", fmt.Errorf("invalid /var mount %q (supported: cmdline, fstab, systemd)", s)
+}
+
+// fromCmdline reports whether /var is mounted from the kernel command line
+func (m VarMount) fromCmdline() bool {
+	return m == "" || m == VarMountCmdline
+}
+
+// mountSource returns the fstab-style source of the filesystem on
+// partition, such as UUID=... or LABEL=var
+func mountSource(partition string, id MountIdentifier) (string, error) {
+	if id == MountByLabel {
+		label, err := readFilesystemLabel(partition)
+		if err != nil {
+			return "", err
+		}
+		if label == "" {
+			return "", fmt.Errorf("filesystem on %s has no label to mount it by", partition)
+		}
+		return "LABEL=" + label, nil
+	}
+	uuid, err := GetPartitionUUID(partition)
+	if err != nil {
+		return "", err
+	}
+	return "UUID=" + uuid, nil
+}
+
+// varKernelSource returns the source of /var for the kernel command line,
+// or "" if /var is mounted from /etc instead
+func varKernelSource(partition string, id MountIdentifier, varMount VarMount, composefs bool) (string, error) {
+	if !varMount.fromCmdline() && !composefs {
+		return "", nil
+	}
+	source, err := mountSource(partition, id)
+	if err != nil {
+		return "", fmt.Errorf("failed to get /var source: %w", err)
+	}
+	return source, nil
+}
+
+// sourceDevicePath returns the udev link of an fstab-style source, as a
+// systemd mount unit needs a device path
+func sourceDevicePath(source string) string {
+	if uuid, ok := strings.CutPrefix(source, "UUID="); ok {
+		return "/dev/disk/by-uuid/" + uuid
+	}
+	if label, ok := strings.CutPrefix(source, "LABEL="); ok {
+		return "/dev/disk/by-label/" + label
+	}
+	return source
+}
+
+// varMountUnitContent returns the var.mount unit for the filesystem source
+func varMountUnitContent(source, fsType, options string) string {
+	return fmt.Sprintf(`# Created by phukit
+[Unit]
+Description=Variable Data (/var)
+Before=local-fs.target
+
+[Mount]
+What=%s
+Where=/var
+Type=%s
+Options=%s
+
+[Install]
+WantedBy=local-fs.target
+`, sourceDevicePath(source), fsType, options)
+}
+
+// writeVarMountUnit writes and enables the var.mount unit of the root at root
+func writeVarMountUnit(root, source, fsType, options string) error {
+	unitDir := filepath.Join(root, "etc", "systemd", "system")
+	if err := os.MkdirAll(filepath.Join(unitDir, "local-fs.target.wants"), 0755); err != nil {
+		return fmt.Errorf("failed to create %s: %w", unitDir, err)
+	}
+	if err := os.WriteFile(filepath.Join(unitDir, varMountUnit), []byte(varMountUnitContent(source, fsType, options)), 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", varMountUnit, err)
+	}
+	link := filepath.Join(unitDir, "local-fs.target.wants", varMountUnit)
+	_ = os.Remove(link)
+	if err := os.Symlink(filepath.Join("..", varMountUnit), link); err != nil {
+		return fmt.Errorf("failed to enable %s: %w", varMountUnit, err)
+	}
+	fmt.Printf("  Created /etc/systemd/system/%s\n", varMountUnit)
+	return nil
+}
+
+// mountsVarFromEtc reports whether the root at root mounts /var itself, with
+// an /etc/fstab entry or a var.mount unit in /etc/systemd/system
+func mountsVarFromEtc(root string) bool {
+	if _, err := os.Stat(filepath.Join(root, "etc", "systemd", "system", varMountUnit)); err == nil {
+		return true
+	}
+	data, err := os.ReadFile(filepath.Join(root, "etc", "fstab"))
+	if err != nil {
+		return false
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) >= 2 && !strings.HasPrefix(fields[0], "#") && filepath.Clean(fields[1]) == "/var" {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,157 @@
+package pkg
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeFakeExt4 writes an image with an ext4 superblock carrying the test
+// UUID and label to a file in dir, and returns its path
+func writeFakeExt4(t *testing.T, dir, name, label string) string {
+	t.Helper()
+	b := make([]byte, 4096)
+	binary.LittleEndian.PutUint16(b[1024+0x38:], 0xEF53)
+	copy(b[1024+0x68:], testUUIDBytes)
+	copy(b[1024+0x78:], label)
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, b, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestParseMountStyle(t *testing.T) {
+	if id, err := ParseMountIdentifier(""); err != nil || id != MountByUUID {
+		t.Errorf("ParseMountIdentifier(\"\") = %q, %v, want uuid", id, err)
+	}
+	if id, err := ParseMountIdentifier("label"); err != nil || id != MountByLabel {
+		t.Errorf("ParseMountIdentifier(label) = %q, %v, want label", id, err)
+	}
+	if _, err := ParseMountIdentifier("partuuid"); err == nil {
+		t.Error("ParseMountIdentifier(partuuid) should fail")
+	}
+
+	if m, err := ParseVarMount(""); err != nil || m != VarMountCmdline {
+		t.Errorf("ParseVarMount(\"\") = %q, %v, want cmdline", m, err)
+	}
+	if m, err := ParseVarMount("systemd"); err != nil || m != VarMountUnit {
+		t.Errorf("ParseVarMount(systemd) = %q, %v, want systemd", m, err)
+	}
+	if _, err := ParseVarMount("automount"); err == nil {
+		t.Error("ParseVarMount(automount) should fail")
+	}
+}
+
+func TestMountSource(t *testing.T) {
+	dir := t.TempDir()
+	labeled := writeFakeExt4(t, dir, "var", "var")
+	unlabeled := writeFakeExt4(t, dir, "unlabeled", "")
+
+	if got, err := mountSource(labeled, MountByUUID); err != nil || got != "UUID="+testUUID {
+		t.Errorf("mountSource(uuid) = %q, %v, want UUID=%s", got, err, testUUID)
+	}
+	if got, err := mountSource(labeled, MountByLabel); err != nil || got != "LABEL=var" {
+		t.Errorf("mountSource(label) = %q, %v, want LABEL=var", got, err)
+	}
+	if _, err := mountSource(unlabeled, MountByLabel); err == nil {
+		t.Error("mountSource(label) of a filesystem without label should fail")
+	}
+
+	if got, err := varKernelSource(labeled, MountByLabel, VarMountFstab, false); err != nil || got != "" {
+		t.Errorf("varKernelSource() with an fstab /var = %q, %v, want none", got, err)
+	}
+	if got, err := varKernelSource(labeled, MountByLabel, VarMountFstab, true); err != nil || got != "LABEL=var" {
+		t.Errorf("varKernelSource() of a composefs root = %q, %v, want LABEL=var", got, err)
+	}
+}
+
+func TestCreateFstab(t *testing.T) {
+	dir := t.TempDir()
+	scheme := &PartitionScheme{
+		Root2Partition: writeFakeExt4(t, dir, "root2", "root2"),
+		VarPartition:   writeFakeExt4(t, dir, "var", "var"),
+		FilesystemType: "ext4",
+	}
+	target := filepath.Join(dir, "root")
+	if err := os.MkdirAll(filepath.Join(target, "etc"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	options := MountOptions{"/var": "noatime,nodev"}
+
+	if err := CreateFstab(target, scheme, options, MountByLabel, VarMountFstab); err != nil {
+		t.Fatalf("CreateFstab() error = %v", err)
+	}
+	data, err := os.ReadFile(filepath.Join(target, "etc", "fstab"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"# LABEL=root2\t/\t\text4", "LABEL=var\t/var\t\text4\tnoatime,nodev\t0 2"} {
+		if !strings.Contains(string(data), want) {
+			t.Errorf("fstab does not contain %q:\n%s", want, data)
+		}
+	}
+	if !mountsVarFromEtc(target) {
+		t.Error("mountsVarFromEtc() with an fstab /var = false, want true")
+	}
+
+	if err := CreateFstab(target, scheme, options, MountByUUID, VarMountUnit); err != nil {
+		t.Fatalf("CreateFstab() error = %v", err)
+	}
+	data, err = os.ReadFile(filepath.Join(target, "etc", "fstab"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "\t/var\t") {
+		t.Errorf("fstab mounts /var next to var.mount:\n%s", data)
+	}
+	unit, err := os.ReadFile(filepath.Join(target, "etc", "systemd", "system", varMountUnit))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(unit), "What=/dev/disk/by-uuid/"+testUUID+"\n") || !strings.Contains(string(unit), "Options=noatime,nodev\n") {
+		t.Errorf("var.mount = %s", unit)
+	}
+	if _, err := os.Lstat(filepath.Join(target, "etc", "systemd", "system", "local-fs.target.wants", varMountUnit)); err != nil {
+		t.Errorf("var.mount is not enabled: %v", err)
+	}
+}
+
+func TestMountsVarFromEtc(t *testing.T) {
+	root := t.TempDir()
+	if mountsVarFromEtc(root) {
+		t.Error("mountsVarFromEtc() without /etc = true, want false")
+	}
+	writeFakeFile(t, filepath.Join(root, "etc", "fstab"), "# UUID=aaaa /var ext4 defaults 0 2\nUUID=bbbb none swap defaults 0 0\n")
+	if mountsVarFromEtc(root) {
+		t.Error("mountsVarFromEtc() with a commented-out /var = true, want false")
+	}
+	writeFakeFile(t, filepath.Join(root, "etc", "fstab"), "UUID=aaaa /var/ ext4 defaults 0 2\n")
+	if !mountsVarFromEtc(root) {
+		t.Error("mountsVarFromEtc() with an fstab /var = false, want true")
+	}
+}
+
+func TestCmdlineVarSource(t *testing.T) {
+	root := t.TempDir()
+	u := &SystemUpdater{Config: UpdaterConfig{MountPoint: root}}
+	if got := u.cmdlineVarSource("UUID=aaaa", ""); got != "UUID=aaaa" {
+		t.Errorf("cmdlineVarSource() = %q, want UUID=aaaa", got)
+	}
+
+	// An fstab entry added since the install takes over /var
+	writeFakeFile(t, filepath.Join(root, "etc", "fstab"), "UUID=aaaa /var ext4 defaults 0 2\n")
+	if got := u.cmdlineVarSource("UUID=aaaa", ""); got != "" {
+		t.Errorf("cmdlineVarSource() with an fstab /var = %q, want none", got)
+	}
+	if got := u.cmdlineVarSource("UUID=aaaa", "a1b2c3"); got != "UUID=aaaa" {
+		t.Errorf("cmdlineVarSource() of a composefs root = %q, want UUID=aaaa", got)
+	}
+
+	u = &SystemUpdater{Config: UpdaterConfig{MountPoint: t.TempDir(), VarMount: VarMountUnit}}
+	if got := u.cmdlineVarSource("UUID=aaaa", ""); got != "" {
+		t.Errorf("cmdlineVarSource() with var.mount = %q, want none", got)
+	}
+}
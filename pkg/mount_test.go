@@ -0,0 +1,123 @@
+package pkg
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+// fakeMounter records mount calls and accepts only one filesystem type
+type fakeMounter struct {
+	acceptFS string
+	// probedFS is the filesystem type found on every device; with "" no
+	// superblock is recognized
+	probedFS string
+	tried    []string
+	flags    uintptr
+	unmounts []string
+	// unmountErr fails every unmount, as a busy filesystem does
+	unmountErr error
+}
+
+func (f *fakeMounter) Mount(source, target, fstype string, flags uintptr, data string) error {
+	f.tried = append(f.tried, fstype)
+	if fstype != f.acceptFS {
+		return unix.EINVAL
+	}
+	f.flags = flags
+	return nil
+}
+
+func (f *fakeMounter) Unmount(target string, flags int) error {
+	f.unmounts = append(f.unmounts, target)
+	return f.unmountErr
+}
+
+func setupFakeMounter(t *testing.T, acceptFS string) *fakeMounter {
+	t.Helper()
+
+	fsPath := filepath.Join(t.TempDir(), "filesystems")
+	writeFakeFile(t, fsPath, "nodev\tsysfs\nnodev\tproc\n\txfs\n\tvfat\n\text4\n\tsquashfs\n")
+
+	fake := &fakeMounter{acceptFS: acceptFS}
+	oldMounter, oldPath, oldTypeOf := mounter, procFilesystemsPath, filesystemTypeOf
+	mounter, procFilesystemsPath = fake, fsPath
+	filesystemTypeOf = func(string) (string, error) {
+		if fake.probedFS == "" {
+			return "", ErrUnknownFilesystem
+		}
+		return fake.probedFS, nil
+	}
+	t.Cleanup(func() { mounter, procFilesystemsPath, filesystemTypeOf = oldMounter, oldPath, oldTypeOf })
+	return fake
+}
+
+func TestKernelFilesystems(t *testing.T) {
+	setupFakeMounter(t, "")
+
+	got, err := kernelFilesystems()
+	if err != nil {
+		t.Fatalf("kernelFilesystems() error = %v", err)
+	}
+	want := []string{"ext4", "xfs", "vfat", "squashfs"}
+	if len(got) != len(want) {
+		t.Fatalf("kernelFilesystems() = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("kernelFilesystems()[%d] = %q, want %q", i, got[i], want[i])
+		}
+	}
+}
+
+func TestMountDevice(t *testing.T) {
+	t.Run("probes filesystem type", func(t *testing.T) {
+		fake := setupFakeMounter(t, "vfat")
+		if err := mountDevice("/dev/sda1", "/mnt", true); err != nil {
+			t.Fatalf("mountDevice() error = %v", err)
+		}
+		if fake.tried[len(fake.tried)-1] != "vfat" {
+			t.Errorf("last tried type = %q, want vfat", fake.tried[len(fake.tried)-1])
+		}
+		if fake.flags&unix.MS_RDONLY == 0 {
+			t.Error("read-only mount did not set MS_RDONLY")
+		}
+	})
+
+	t.Run("superblock type", func(t *testing.T) {
+		// btrfs is not in /proc/filesystems until its module is loaded
+		fake := setupFakeMounter(t, "btrfs")
+		fake.probedFS = "btrfs"
+		if err := mountDevice("/dev/sda1", "/mnt", false); err != nil {
+			t.Fatalf("mountDevice() error = %v", err)
+		}
+		if len(fake.tried) != 1 || fake.tried[0] != "btrfs" {
+			t.Errorf("tried types = %v, want only btrfs", fake.tried)
+		}
+	})
+
+	t.Run("unreadable device", func(t *testing.T) {
+		fake := setupFakeMounter(t, "ext4")
+		filesystemTypeOf = readFilesystemType
+		if err := mountDevice(filepath.Join(t.TempDir(), "missing"), "/mnt", false); err == nil {
+			t.Error("mountDevice() of a missing device succeeded")
+		}
+		if len(fake.tried) != 0 {
+			t.Errorf("tried types = %v, want none", fake.tried)
+		}
+	})
+
+	t.Run("no matching filesystem", func(t *testing.T) {
+		setupFakeMounter(t, "zfs")
+		err := mountDevice("/dev/sda1", "/mnt", false)
+		var mountErr *MountError
+		if !errors.As(err, &mountErr) {
+			t.Fatalf("mountDevice() error = %v, want *MountError", err)
+		}
+		if !errors.Is(err, unix.EINVAL) {
+			t.Errorf("mountDevice() error %v does not wrap EINVAL", err)
+		}
+	})
+}
@@ -0,0 +1,190 @@
+package pkg
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// sysClassISCSIPath is the sysfs directory holding iSCSI sessions and connections (overridable in tests)
+var sysClassISCSIPath = "/sys/class"
+
+// sysFirmwarePath is the sysfs directory holding firmware tables such as iBFT and NBFT (overridable in tests)
+var sysFirmwarePath = "/sys/firmware"
+
+// hostNetrootFiles are host identity files the initramfs needs to log in to the SAN
+// with the same identity the target was provisioned for
+var hostNetrootFiles = []string{
+	"etc/iscsi/initiatorname.iscsi",
+	"etc/nvme/hostnqn",
+	"etc/nvme/hostid",
+}
+
+// readSysfsValue reads and trims a single-value sysfs attribute
+func readSysfsValue(path string) string {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
+
+// iscsiKernelArgs builds the dracut netroot arguments for an iSCSI disk
+func iscsiKernelArgs(name string) ([]string, error) {
+	// iBFT-configured hosts let the initramfs read the target from firmware
+	if _, err := os.Stat(filepath.Join(sysFirmwarePath, "ibft")); err == nil {
+		return []string{"rd.iscsi.firmware=1"}, nil
+	}
+
+	resolved, err := filepath.EvalSymlinks(filepath.Join(sysBlockPath, name))
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve sysfs path for %s: %w", name, err)
+	}
+
+	// Path looks like .../hostN/sessionM/targetN:0:0/N:0:0:LUN/block/sdX
+	var session, lun string
+	for _, component := range strings.Split(resolved, string(filepath.Separator)) {
+		if strings.HasPrefix(component, "session") {
+			session = component
+		}
+		if parts := strings.Split(component, ":"); len(parts) == 4 {
+			lun = parts[3]
+		}
+	}
+	if session == "" {
+		return nil, fmt.Errorf("device %s is not attached through an iSCSI session", name)
+	}
+
+	sessionDir := filepath.Join(sysClassISCSIPath, "iscsi_session", session)
+	targetName := readSysfsValue(filepath.Join(sessionDir, "targetname"))
+	initiator := readSysfsValue(filepath.Join(sessionDir, "initiatorname"))
+
+	connection := "connection" + strings.TrimPrefix(session, "session") + ":0"
+	connectionDir := filepath.Join(sysClassISCSIPath, "iscsi_connection", connection)
+	address := readSysfsValue(filepath.Join(connectionDir, "persistent_address"))
+	port := readSysfsValue(filepath.Join(connectionDir, "persistent_port"))
+
+	if targetName == "" || address == "" {
+		return nil, fmt.Errorf("could not read iSCSI target details for %s", session)
+	}
+
+	// netroot=iscsi:<server>:<protocol>:<port>:<lun>:<targetname>
+	args := []string{
+		fmt.Sprintf("netroot=iscsi:%s::%s:%s:%s", address, port, lun, targetName),
+	}
+	if initiator != "" {
+		args = append(args, "rd.iscsi.initiator="+initiator)
+	}
+	return args, nil
+}
+
+// nvmfKernelArgs builds the dracut nvmf arguments for an NVMe-oF namespace
+func nvmfKernelArgs(name string) ([]string, error) {
+	// NBFT-configured hosts let the initramfs read the subsystem from firmware
+	if _, err := os.Stat(filepath.Join(sysFirmwarePath, "acpi", "tables", "NBFT")); err == nil {
+		return []string{}, nil
+	}
+
+	idx := strings.Index(name[len("nvme"):], "n")
+	if idx <= 0 {
+		return nil, fmt.Errorf("unrecognized NVMe namespace name: %s", name)
+	}
+	controllerDir := filepath.Join(sysClassNVMePath, name[:len("nvme")+idx])
+
+	transport := readSysfsValue(filepath.Join(controllerDir, "transport"))
+	address := readSysfsValue(filepath.Join(controllerDir, "address"))
+	if transport == "" || address == "" {
+		return nil, fmt.Errorf("could not read NVMe-oF controller details for %s", name)
+	}
+
+	// address is formatted like "traddr=10.0.0.1,trsvcid=4420"
+	var traddr, trsvcid string
+	for _, field := range strings.Split(address, ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(field), "=")
+		switch key {
+		case "traddr":
+			traddr = value
+		case "trsvcid":
+			trsvcid = value
+		}
+	}
+
+	// rd.nvmf.discover=<transport>,<traddr>,<host_traddr>,<trsvcid>
+	args := []string{
+		fmt.Sprintf("rd.nvmf.discover=%s,%s,,%s", transport, traddr, trsvcid),
+	}
+	if hostNQN := readSysfsValue(filepath.Join(controllerDir, "hostnqn")); hostNQN != "" {
+		args = append(args, "rd.nvmf.hostnqn="+hostNQN)
+	}
+	return args, nil
+}
+
+// NetworkRootKernelArgs returns the kernel arguments needed to find a root device
+// on an iSCSI or NVMe-oF SAN from the initramfs, including network bring-up.
+// Returns an empty slice for local storage.
+func NetworkRootKernelArgs(device string) ([]string, error) {
+	args := []string{}
+	isNetwork := false
+	var walkErr error
+
+	// Multipath and RAID maps are backed by the actual SAN disks, so walk the stack
+	walkBlockDevices(blockDeviceName(device), func(name string) {
+		if walkErr != nil {
+			return
+		}
+		for _, f := range deviceStorageFeatures(name) {
+			var featureArgs []string
+			var err error
+			switch f {
+			case StorageISCSI:
+				featureArgs, err = iscsiKernelArgs(name)
+			case StorageNVMeoF:
+				featureArgs, err = nvmfKernelArgs(name)
+			default:
+				continue
+			}
+			if err != nil {
+				walkErr = err
+				return
+			}
+			isNetwork = true
+			args = append(args, featureArgs...)
+		}
+	})
+	if walkErr != nil {
+		return nil, walkErr
+	}
+	if !isNetwork {
+		return args, nil
+	}
+
+	// Bring up networking in the initramfs before looking for the root device
+	args = append(args, "ip=dhcp", "rd.neednet=1")
+	return uniqueStrings(args), nil
+}
+
+// CopyNetrootHostConfig copies the host's iSCSI initiator name and NVMe host
+// identity into the target so the installed system logs in with the same identity
+func CopyNetrootHostConfig(targetDir string, dryRun bool) error {
+	if dryRun {
+		fmt.Println("[DRY RUN] Would copy iSCSI/NVMe-oF host identity to target")
+		return nil
+	}
+
+	for _, rel := range hostNetrootFiles {
+		src := filepath.Join("/", rel)
+		if _, err := os.Stat(src); err != nil {
+			continue
+		}
+		dst := filepath.Join(targetDir, rel)
+		if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+			return fmt.Errorf("failed to create directory for %s: %w", rel, err)
+		}
+		if err := copyFile(src, dst); err != nil {
+			return fmt.Errorf("failed to copy %s: %w", rel, err)
+		}
+		fmt.Printf("  Copied host %s to target\n", "/"+rel)
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+package pkg
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestNetworkRootKernelArgs(t *testing.T) {
+	t.Run("local disk", func(t *testing.T) {
+		setupFakeSysfs(t)
+		if err := os.MkdirAll(filepath.Join(sysBlockPath, "sda"), 0755); err != nil {
+			t.Fatal(err)
+		}
+		got, err := NetworkRootKernelArgs("/dev/sda")
+		if err != nil {
+			t.Fatalf("NetworkRootKernelArgs() error = %v", err)
+		}
+		if len(got) != 0 {
+			t.Errorf("NetworkRootKernelArgs() = %v, want none", got)
+		}
+	})
+
+	t.Run("iscsi", func(t *testing.T) {
+		root := setupFakeSysfs(t)
+		oldClass, oldFirmware := sysClassISCSIPath, sysFirmwarePath
+		sysClassISCSIPath = filepath.Join(root, "class")
+		sysFirmwarePath = filepath.Join(root, "firmware")
+		t.Cleanup(func() { sysClassISCSIPath, sysFirmwarePath = oldClass, oldFirmware })
+
+		sessionDev := filepath.Join(root, "devices", "platform", "host2", "session3", "target2:0:0", "2:0:0:1", "block", "sdb")
+		if err := os.MkdirAll(sessionDev, 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Symlink(sessionDev, filepath.Join(sysBlockPath, "sdb")); err != nil {
+			t.Fatal(err)
+		}
+		sessionDir := filepath.Join(sysClassISCSIPath, "iscsi_session", "session3")
+		writeFakeFile(t, filepath.Join(sessionDir, "targetname"), "iqn.2024-01.com.example:storage\n")
+		writeFakeFile(t, filepath.Join(sessionDir, "initiatorname"), "iqn.2024-01.com.example:host1\n")
+		connDir := filepath.Join(sysClassISCSIPath, "iscsi_connection", "connection3:0")
+		writeFakeFile(t, filepath.Join(connDir, "persistent_address"), "192.168.1.10\n")
+		writeFakeFile(t, filepath.Join(connDir, "persistent_port"), "3260\n")
+
+		got, err := NetworkRootKernelArgs("/dev/sdb")
+		if err != nil {
+			t.Fatalf("NetworkRootKernelArgs() error = %v", err)
+		}
+		want := []string{
+			"netroot=iscsi:192.168.1.10::3260:1:iqn.2024-01.com.example:storage",
+			"rd.iscsi.initiator=iqn.2024-01.com.example:host1",
+			"ip=dhcp",
+			"rd.neednet=1",
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("NetworkRootKernelArgs() = %v, want %v", got, want)
+		}
+	})
+
+	t.Run("nvme over tcp", func(t *testing.T) {
+		root := setupFakeSysfs(t)
+		oldFirmware := sysFirmwarePath
+		sysFirmwarePath = filepath.Join(root, "firmware")
+		t.Cleanup(func() { sysFirmwarePath = oldFirmware })
+
+		if err := os.MkdirAll(filepath.Join(sysBlockPath, "nvme1n1"), 0755); err != nil {
+			t.Fatal(err)
+		}
+		controllerDir := filepath.Join(sysClassNVMePath, "nvme1")
+		writeFakeFile(t, filepath.Join(controllerDir, "transport"), "tcp\n")
+		writeFakeFile(t, filepath.Join(controllerDir, "address"), "traddr=10.0.0.5,trsvcid=4420,src_addr=10.0.0.2\n")
+		writeFakeFile(t, filepath.Join(controllerDir, "hostnqn"), "nqn.2014-08.org.nvmexpress:uuid:1234\n")
+
+		got, err := NetworkRootKernelArgs("/dev/nvme1n1")
+		if err != nil {
+			t.Fatalf("NetworkRootKernelArgs() error = %v", err)
+		}
+		want := []string{
+			"rd.nvmf.discover=tcp,10.0.0.5,,4420",
+			"rd.nvmf.hostnqn=nqn.2014-08.org.nvmexpress:uuid:1234",
+			"ip=dhcp",
+			"rd.neednet=1",
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("NetworkRootKernelArgs() = %v, want %v", got, want)
+		}
+	})
+}
@@ -0,0 +1,252 @@
+package pkg
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Operation logs: every operation gets a directory named after its start
+// time in the log directory, holding a debug level log with the output of
+// every command and, once it finishes, the result document. Unattended
+// updates that fail can be diagnosed from them after the fact.
+const (
+	OperationLogFile    = "operation.log"
+	OperationResultFile = "result.json"
+
+	// DefaultOperationLogs is how many operation logs are kept
+	DefaultOperationLogs = 10
+
+	operationLogTimeFormat = "20060102-150405"
+)
+
+// operationLogDir is where operation logs are kept, "" disables them
+var operationLogDir string
+
+// SetOperationLogDir sets the directory operation logs are kept in, usually
+// /var/log/phukit. Pass "" to disable them.
+func SetOperationLogDir(dir string) {
+	operationLogDir = dir
+}
+
+// OperationLogDir returns the directory operation logs are kept in
+func OperationLogDir() string {
+	return operationLogDir
+}
+
+// OperationLog is the log of a running operation
+type OperationLog struct {
+	Dir string
+
+	file    *os.File
+	restore func()
+}
+
+// StartOperationLog creates the log directory of operation and sends every
+// log record, at debug level, to it until Close. It returns nil if operation
+// logs are disabled.
+func StartOperationLog(operation string) (*OperationLog, error) {
+	if operationLogDir == "" {
+		return nil, nil
+	}
+	if err := os.MkdirAll(operationLogDir, 0750); err != nil {
+		return nil, fmt.Errorf("failed to create log directory: %w", err)
+	}
+	dir, err := newOperationLogDir(operationLogDir, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(filepath.Join(dir, OperationLogFile), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0640)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open operation log: %w", err)
+	}
+	if err := pruneOperationLogs(operationLogDir, DefaultOperationLogs); err != nil {
+		logger.Warn("failed to remove old operation logs", "error", err)
+	}
+
+	prev := logger
+	h := NewFileHandler(f, slog.LevelDebug).WithAttrs([]slog.Attr{slog.String("phase", operation)})
+	logger = slog.New(NewMultiHandler(prev.Handler(), h))
+	return &OperationLog{Dir: dir, file: f, restore: func() { logger = prev }}, nil
+}
+
+// Close writes the result document r next to the log, closes the log and
+// restores the previous logger. It does nothing on a nil OperationLog.
+func (l *OperationLog) Close(r *OperationResult) error {
+	if l == nil {
+		return nil
+	}
+	l.restore()
+	err := l.file.Close()
+	if r != nil {
+		if werr := r.WriteFile(filepath.Join(l.Dir, OperationResultFile)); werr != nil && err == nil {
+			err = werr
+		}
+	}
+	return err
+}
+
+// newOperationLogDir creates the directory of an operation started at start
+func newOperationLogDir(parent string, start time.Time) (string, error) {
+	name := start.UTC().Format(operationLogTimeFormat)
+	for i := 1; ; i++ {
+		dir := filepath.Join(parent, name)
+		if i > 1 {
+			dir = fmt.Sprintf("%s-%d", dir, i)
+		}
+		err := os.Mkdir(dir, 0750)
+		if err == nil {
+			return dir, nil
+		}
+		if !os.IsExist(err) {
+			return "", fmt.Errorf("failed to create operation log directory: %w", err)
+		}
+	}
+}
+
+// OperationLogs returns the directories of the kept operation logs, oldest
+// first
+func OperationLogs() ([]string, error) {
+	if operationLogDir == "" {
+		return nil, fmt.Errorf("operation logs are disabled")
+	}
+	return operationLogs(operationLogDir)
+}
+
+// operationLogs lists the operation log directories in parent
+func operationLogs(parent string) ([]string, error) {
+	entries, err := os.ReadDir(parent)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read log directory: %w", err)
+	}
+	var dirs []string
+	for _, e := range entries {
+		if e.IsDir() && isOperationLogName(e.Name()) {
+			dirs = append(dirs, filepath.Join(parent, e.Name()))
+		}
+	}
+	// The names sort by start time, "-N" suffixes after the first
+	sort.Strings(dirs)
+	return dirs, nil
+}
+
+// isOperationLogName reports whether name is the name of an operation log
+// directory
+func isOperationLogName(name string) bool {
+	if len(name) < len(operationLogTimeFormat) {
+		return false
+	}
+	_, err := time.Parse(operationLogTimeFormat, name[:len(operationLogTimeFormat)])
+	return err == nil
+}
+
+// OperationLogTime returns the start time of the operation logged in dir
+func OperationLogTime(dir string) time.Time {
+	name := filepath.Base(dir)
+	if !isOperationLogName(name) {
+		return time.Time{}
+	}
+	t, _ := time.Parse(operationLogTimeFormat, name[:len(operationLogTimeFormat)])
+	return t
+}
+
+// ReadOperationLogResult returns the result document of the operation logged
+// in dir, or nil if it has not finished
+func ReadOperationLogResult(dir string) (*OperationResult, error) {
+	data, err := os.ReadFile(filepath.Join(dir, OperationResultFile))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read result: %w", err)
+	}
+	var r OperationResult
+	if err := json.Unmarshal(data, &r); err != nil {
+		return nil, fmt.Errorf("failed to parse result in %s: %w", dir, err)
+	}
+	return &r, nil
+}
+
+// pruneOperationLogs removes all but the newest keep operation logs
+func pruneOperationLogs(parent string, keep int) error {
+	dirs, err := operationLogs(parent)
+	if err != nil || len(dirs) <= keep {
+		return err
+	}
+	for _, dir := range dirs[:len(dirs)-keep] {
+		if err := os.RemoveAll(dir); err != nil {
+			return fmt.Errorf("failed to remove %s: %w", dir, err)
+		}
+	}
+	return nil
+}
+
+// outputLogger is a writer that logs each line written to it as a debug
+// record of command
+type outputLogger struct {
+	command string
+
+	mu  sync.Mutex
+	buf []byte
+}
+
+func (o *outputLogger) Write(p []byte) (int, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.buf = append(o.buf, p...)
+	for {
+		i := bytes.IndexByte(o.buf, '\n')
+		if i < 0 {
+			break
+		}
+		o.log(string(o.buf[:i]))
+		o.buf = o.buf[i+1:]
+	}
+	return len(p), nil
+}
+
+// flush logs a final line without a newline
+func (o *outputLogger) flush() {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if len(o.buf) > 0 {
+		o.log(string(o.buf))
+		o.buf = nil
+	}
+}
+
+func (o *outputLogger) log(line string) {
+	// Progress bars redraw with carriage returns; keep the last state
+	if i := strings.LastIndexByte(strings.TrimRight(line, "\r"), '\r'); i >= 0 {
+		line = line[i+1:]
+	}
+	logger.Debug("output", "command", o.command, "line", strings.TrimRight(line, "\r"))
+}
+
+// teeOutput returns c with its standard output also sent to stdout and its
+// standard error to stderr
+func teeOutput(c Command, stdout, stderr io.Writer) Command {
+	tee := func(w, out io.Writer) io.Writer {
+		if w == nil {
+			return out
+		}
+		return io.MultiWriter(w, out)
+	}
+	if c.Stdout != nil && c.Stdout == c.Stderr {
+		// os/exec only serializes writes to one writer shared by both
+		c.Stdout = tee(c.Stdout, stdout)
+		c.Stderr = c.Stdout
+		return c
+	}
+	c.Stdout, c.Stderr = tee(c.Stdout, stdout), tee(c.Stderr, stderr)
+	return c
+}
@@ -0,0 +1,147 @@
+package pkg
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func setupOperationLogDir(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	SetOperationLogDir(dir)
+	t.Cleanup(func() { SetOperationLogDir("") })
+	return dir
+}
+
+func TestOperationLog(t *testing.T) {
+	setupOperationLogDir(t)
+	var main bytes.Buffer
+	SetLogger(NewFileLogger(&main, slog.LevelInfo))
+	t.Cleanup(func() { SetLogger(nil) })
+
+	oplog, err := StartOperationLog(OperationUpdate)
+	if err != nil || oplog == nil {
+		t.Fatalf("StartOperationLog() = %v, %v", oplog, err)
+	}
+	exec := LogExecutor{Next: OSExecutor{}}
+	var out bytes.Buffer
+	err = exec.Run(context.Background(), Command{
+		Name: "sh", Args: []string{"-c", "echo extracting; printf '10%%\\r50%%\\r100%%\\n'; echo oops >&2; printf partial"},
+		Stdout: &out,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != "extracting\n10%\r50%\r100%\npartial" {
+		t.Errorf("command stdout = %q, want it unchanged", out.String())
+	}
+	logger.Warn("could not get image digest")
+	if err := oplog.Close(&OperationResult{Operation: OperationUpdate, Status: StatusSuccess}); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(oplog.Dir, OperationLogFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		`level=DEBUG msg=command phase=update command="sh -c`,
+		`msg=output phase=update command=sh line=extracting`,
+		`line=100%`,
+		`line=oops`,
+		`line=partial`,
+		`level=WARN msg="could not get image digest" phase=update`,
+	} {
+		if !strings.Contains(string(data), want) {
+			t.Errorf("operation log missing %q:\n%s", want, data)
+		}
+	}
+	if strings.Contains(main.String(), "msg=output") {
+		t.Errorf("command output reached the info level log:\n%s", main.String())
+	}
+
+	r, err := ReadOperationLogResult(oplog.Dir)
+	if err != nil || r == nil || r.Status != StatusSuccess {
+		t.Errorf("ReadOperationLogResult() = %+v, %v; want the result document", r, err)
+	}
+
+	// The logger is restored
+	logger.Info("after")
+	if data, _ := os.ReadFile(filepath.Join(oplog.Dir, OperationLogFile)); strings.Contains(string(data), "after") {
+		t.Error("record logged after Close reached the operation log")
+	}
+}
+
+func TestOperationLogDisabled(t *testing.T) {
+	SetOperationLogDir("")
+	oplog, err := StartOperationLog(OperationUpdate)
+	if oplog != nil || err != nil {
+		t.Fatalf("StartOperationLog() = %v, %v; want nil, nil when disabled", oplog, err)
+	}
+	if err := oplog.Close(nil); err != nil {
+		t.Errorf("Close() on nil = %v", err)
+	}
+}
+
+func TestOperationLogs(t *testing.T) {
+	dir := setupOperationLogDir(t)
+	start := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	var created []string
+	for i := range DefaultOperationLogs + 2 {
+		d, err := newOperationLogDir(dir, start.Add(time.Duration(i)*time.Minute))
+		if err != nil {
+			t.Fatal(err)
+		}
+		created = append(created, d)
+	}
+	// Two operations in the same second
+	same, err := newOperationLogDir(dir, start.Add(time.Duration(DefaultOperationLogs+1)*time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := created[len(created)-1] + "-2"; same != want {
+		t.Errorf("newOperationLogDir() = %s, want %s", same, want)
+	}
+	// Other files in the log directory are not operation logs
+	if err := os.WriteFile(filepath.Join(dir, "phukit.log"), nil, 0640); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := pruneOperationLogs(dir, DefaultOperationLogs); err != nil {
+		t.Fatalf("pruneOperationLogs() error = %v", err)
+	}
+	dirs, err := OperationLogs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dirs) != DefaultOperationLogs {
+		t.Fatalf("OperationLogs() returned %d logs, want %d", len(dirs), DefaultOperationLogs)
+	}
+	if dirs[len(dirs)-1] != same || dirs[0] != created[3] {
+		t.Errorf("OperationLogs() = %v, want the newest logs oldest first", dirs)
+	}
+	if got := OperationLogTime(same); !got.Equal(start.Add(time.Duration(DefaultOperationLogs+1) * time.Minute)) {
+		t.Errorf("OperationLogTime() = %v", got)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "phukit.log")); err != nil {
+		t.Errorf("pruning removed the main log: %v", err)
+	}
+
+	r, err := ReadOperationLogResult(same)
+	if r != nil || err != nil {
+		t.Errorf("ReadOperationLogResult() of a running operation = %v, %v; want nil, nil", r, err)
+	}
+	if err := os.WriteFile(filepath.Join(same, OperationResultFile), []byte("{"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadOperationLogResult(same); err == nil {
+		t.Error("ReadOperationLogResult() of a corrupt result succeeded, want error")
+	}
+}
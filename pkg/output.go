@@ -0,0 +1,92 @@
+package pkg
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// stdout is the real standard output. Quiet mode points os.Stdout at
+// /dev/null and keeps this for prompts and final results.
+var stdout = os.Stdout
+
+// quiet suppresses step and progress output
+var quiet bool
+
+// SetQuiet enables or disables quiet mode. In quiet mode everything written to
+// os.Stdout is discarded; warnings and errors still go to stderr, and prompts
+// and final results are written to Stdout().
+func SetQuiet(q bool) error {
+	if !q {
+		if quiet && os.Stdout != stdout {
+			_ = os.Stdout.Close()
+		}
+		os.Stdout = stdout
+		quiet = false
+		return nil
+	}
+	if quiet {
+		return nil
+	}
+	devNull, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", os.DevNull, err)
+	}
+	os.Stdout = devNull
+	quiet = true
+	return nil
+}
+
+// Quiet reports whether quiet mode is enabled
+func Quiet() bool {
+	return quiet
+}
+
+// Stdout returns the writer for output that must be shown even in quiet
+// mode, such as final results
+func Stdout() io.Writer {
+	return stdout
+}
+
+// confirm shows banner framed by a rule and asks the user to type "yes". It
+// writes to the real standard output so prompts are shown in quiet mode.
+func confirm(banner ...string) bool {
+	return confirmAnswer("yes", banner...)
+}
+
+// confirmAnswer is confirm with the answer the user has to type
+func confirmAnswer(answer string, banner ...string) bool {
+	rule := strings.Repeat("=", 60)
+	_, _ = fmt.Fprintf(stdout, "\n%s\n", rule)
+	for _, line := range banner {
+		_, _ = fmt.Fprintln(stdout, line)
+	}
+	_, _ = fmt.Fprintf(stdout, "%s\n", rule)
+	_, _ = fmt.Fprintf(stdout, "Type '%s' to continue: ", answer)
+	var response string
+	_, _ = fmt.Scanln(&response)
+	if response != answer {
+		return false
+	}
+	_, _ = fmt.Fprintln(stdout)
+	return true
+}
+
+// TypedConfirmationSize is the disk size from which the device path has to be
+// typed to confirm wiping a disk, instead of "yes"
+const TypedConfirmationSize = uint64(1 << 40) // 1 TiB
+
+// confirmWipe asks before destroying data on device. The device path has to
+// be typed instead of "yes" if typeDevice is set or the disk is huge, as a
+// huge disk is more likely to hold data than to be a fresh install target.
+func confirmWipe(device string, typeDevice bool, banner ...string) bool {
+	if !typeDevice {
+		size, _, _, err := diskGeometry(device)
+		typeDevice = err == nil && size >= TypedConfirmationSize
+	}
+	if typeDevice {
+		return confirmAnswer(device, banner...)
+	}
+	return confirm(banner...)
+}
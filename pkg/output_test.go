@@ -0,0 +1,140 @@
+package pkg
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// captureStdout points os.Stdout and the saved real stdout at a pipe and
+// returns a function that restores them and returns what was written
+func captureStdout(t *testing.T) func() string {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	origOS, origSaved := os.Stdout, stdout
+	os.Stdout, stdout = w, w
+	return func() string {
+		_ = w.Close()
+		os.Stdout, stdout = origOS, origSaved
+		out, _ := io.ReadAll(r)
+		return string(out)
+	}
+}
+
+func TestQuietMode(t *testing.T) {
+	done := captureStdout(t)
+
+	if err := SetQuiet(true); err != nil {
+		t.Fatal(err)
+	}
+	if !Quiet() {
+		t.Error("Quiet() = false after SetQuiet(true)")
+	}
+	fmt.Println("Step 1/6: Creating partitions...")
+	_, _ = fmt.Fprintln(Stdout(), "install: success")
+	if err := SetQuiet(false); err != nil {
+		t.Fatal(err)
+	}
+	fmt.Println("shown again")
+
+	out := done()
+	if strings.Contains(out, "Creating partitions") {
+		t.Errorf("step output not suppressed: %q", out)
+	}
+	for _, want := range []string{"install: success", "shown again"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output missing %q: %q", want, out)
+		}
+	}
+}
+
+func TestConfirm(t *testing.T) {
+	tests := []struct {
+		input string
+		want  bool
+	}{
+		{"yes\n", true},
+		{"y\n", false},
+		{"\n", false},
+	}
+	for _, tt := range tests {
+		t.Run(strings.TrimSpace(tt.input), func(t *testing.T) {
+			r, w, err := os.Pipe()
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, _ = w.WriteString(tt.input)
+			_ = w.Close()
+			origStdin := os.Stdin
+			os.Stdin = r
+			defer func() { os.Stdin = origStdin }()
+
+			done := captureStdout(t)
+			_ = SetQuiet(true)
+			got := confirm("WARNING: This will DESTROY ALL DATA on /dev/sda!")
+			_ = SetQuiet(false)
+			out := done()
+
+			if got != tt.want {
+				t.Errorf("confirm() = %v, want %v", got, tt.want)
+			}
+			if !strings.Contains(out, "DESTROY ALL DATA on /dev/sda") || !strings.Contains(out, "Type 'yes' to continue") {
+				t.Errorf("prompt not shown in quiet mode: %q", out)
+			}
+		})
+	}
+}
+
+func TestConfirmWipe(t *testing.T) {
+	old := sysClassBlockPath
+	sysClassBlockPath = t.TempDir()
+	t.Cleanup(func() { sysClassBlockPath = old })
+	writeFakeFile(t, filepath.Join(sysClassBlockPath, "vdb", "size"), "134217728\n")  // 64 GiB
+	writeFakeFile(t, filepath.Join(sysClassBlockPath, "vdc", "size"), "4294967296\n") // 2 TiB
+
+	tests := []struct {
+		name       string
+		device     string
+		typeDevice bool
+		input      string
+		want       bool
+		prompt     string
+	}{
+		{"small disk takes yes", "/dev/vdb", false, "yes\n", true, "Type 'yes'"},
+		{"typed device requested", "/dev/vdb", true, "yes\n", false, "Type '/dev/vdb'"},
+		{"typed device matches", "/dev/vdb", true, "/dev/vdb\n", true, "Type '/dev/vdb'"},
+		{"huge disk needs its path", "/dev/vdc", false, "yes\n", false, "Type '/dev/vdc'"},
+		{"huge disk with its path", "/dev/vdc", false, "/dev/vdc\n", true, "Type '/dev/vdc'"},
+		{"wrong disk", "/dev/vdc", false, "/dev/vdb\n", false, "Type '/dev/vdc'"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, w, err := os.Pipe()
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, _ = w.WriteString(tt.input)
+			_ = w.Close()
+			origStdin := os.Stdin
+			os.Stdin = r
+			defer func() { os.Stdin = origStdin }()
+
+			done := captureStdout(t)
+			got := confirmWipe(tt.device, tt.typeDevice, "WARNING")
+			out := done()
+
+			if got != tt.want {
+				t.Errorf("confirmWipe() = %v, want %v", got, tt.want)
+			}
+			if !strings.Contains(out, tt.prompt) {
+				t.Errorf("prompt %q not in %q", tt.prompt, out)
+			}
+		})
+	}
+}
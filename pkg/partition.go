@@ -1,105 +1,94 @@
 package pkg
 
 import (
+	"context"
 	"fmt"
 	"os"
-	"os/exec"
 	"path/filepath"
-	"strings"
 )
 
 // PartitionScheme defines the disk partitioning layout
 type PartitionScheme struct {
-	BootPartition  string // Boot partition (EFI System Partition, FAT32, 2GB) - holds EFI binaries + kernel/initramfs
-	Root1Partition string // First root filesystem partition (12GB)
-	Root2Partition string // Second root filesystem partition (12GB)
-	VarPartition   string // /var partition (remaining space)
-	FilesystemType string // Filesystem type for root/var partitions (ext4, btrfs)
+	BootPartition  string `json:"boot"`            // Boot partition (EFI System Partition, FAT32, 2GB) - holds EFI binaries + kernel/initramfs
+	Root1Partition string `json:"root1"`           // First root filesystem partition (12GB)
+	Root2Partition string `json:"root2"`           // Second root filesystem partition (12GB)
+	VarPartition   string `json:"var"`             // /var partition (remaining space)
+	FilesystemType string `json:"filesystem_type"` // Filesystem type for root/var partitions (ext4, btrfs)
+
+	// dm-verity hash tree partitions, only with a verity-protected root
+	Root1HashPartition string `json:"root1_hash,omitempty"`
+	Root2HashPartition string `json:"root2_hash,omitempty"`
+
+	// Swap partition, only if swap was requested
+	SwapPartition string `json:"swap,omitempty"`
+
+	// Volume group holding the roots, /var and swap as logical volumes, only
+	// with the LVM layout
+	VolumeGroup string `json:"volume_group,omitempty"`
 }
 
-// CreatePartitions creates a GPT partition table with EFI, boot, and root partitions
-func CreatePartitions(device string, dryRun bool) (*PartitionScheme, error) {
+// hashPartition returns the verity hash partition of the root partition, or
+// "" if it has none
+func (s *PartitionScheme) hashPartition(root string) string {
+	switch root {
+	case s.Root1Partition:
+		return s.Root1HashPartition
+	case s.Root2Partition:
+		return s.Root2HashPartition
+	}
+	return ""
+}
+
+// CreatePartitions creates a GPT partition table with EFI, boot, and root
+// partitions, with verity a hash tree partition for each root, and a swap
+// partition of swapSize bytes if swapSize is not 0
+func CreatePartitions(ctx context.Context, device string, verity bool, swapSize uint64, dryRun bool) (*PartitionScheme, error) {
+	layout := installGPTLayout(verity, swapSize)
+
 	if dryRun {
 		fmt.Printf("[DRY RUN] Would create partitions on %s\n", device)
 		deviceBase := filepath.Base(device)
-		return &PartitionScheme{
+		scheme := &PartitionScheme{
 			BootPartition:  "/dev/" + deviceBase + "1",
 			Root1Partition: "/dev/" + deviceBase + "2",
 			Root2Partition: "/dev/" + deviceBase + "3",
 			VarPartition:   "/dev/" + deviceBase + "4",
-		}, nil
+		}
+		if verity {
+			scheme.Root1HashPartition = "/dev/" + deviceBase + "5"
+			scheme.Root2HashPartition = "/dev/" + deviceBase + "6"
+		}
+		if swapSize > 0 {
+			scheme.SwapPartition = fmt.Sprintf("/dev/%s%d", deviceBase, len(layout))
+		}
+		return scheme, nil
 	}
 
 	fmt.Println("Creating GPT partition table...")
 
-	// Use sgdisk to create partitions
-	// Partition 1: Boot/EFI System Partition (2GB, FAT32) - holds EFI binaries + kernel/initramfs
-	// Partition 2: First root filesystem (12GB)
-	// Partition 3: Second root filesystem (12GB)
-	// Partition 4: /var partition (remaining space)
-
-	commands := [][]string{
-		// Create GPT partition table
-		{"sgdisk", "--clear", device},
-		// Create boot/EFI partition (2GB, type EF00 = EFI System Partition)
-		// This single partition serves as both ESP and boot - holds EFI binaries + kernel/initramfs
-		{"sgdisk", "--new=1:0:+2G", "--typecode=1:EF00", "--change-name=1:boot", device},
-		// Create first root partition (12GB, type 8300 = generic Linux data)
-		// NOT using discoverable root partition type - root specified via kernel cmdline
-		{"sgdisk", "--new=2:0:+12G", "--typecode=2:8300", "--change-name=2:root1", device},
-		// Create second root partition (12GB, type 8300 = generic Linux data)
-		// NOT using discoverable root partition type - allows A/B updates with explicit control
-		{"sgdisk", "--new=3:0:+12G", "--typecode=3:8300", "--change-name=3:root2", device},
-		// Create /var partition (remaining space, type 8300 = generic Linux data)
-		// NOT using auto-discoverable var type (4d21b016...) - would require machine-id binding
-		{"sgdisk", "--new=4:0:0", "--typecode=4:8300", "--change-name=4:var", device},
+	// Write the partition table directly (no sgdisk/partprobe needed, so this
+	// works from minimal initrd environments). The kernel is told to re-read
+	// the table with BLKRRPART, falling back to per-partition BLKPG ioctls.
+	if _, err := writeGPTPartitionTable(ctx, device, layout); err != nil {
+		return nil, err
 	}
 
-	for _, cmdArgs := range commands {
-		cmd := exec.Command(cmdArgs[0], cmdArgs[1:]...)
-		if output, err := cmd.CombinedOutput(); err != nil {
-			return nil, fmt.Errorf("failed to run %s: %w\nOutput: %s", cmdArgs[0], err, string(output))
-		}
+	if err := settleDevices(ctx); err != nil {
+		return nil, err
 	}
 
-	// Inform kernel of partition changes
-	deviceBase := filepath.Base(device)
-	if strings.HasPrefix(deviceBase, "loop") {
-		// For loop devices, use losetup --partscan to force partition re-read
-		if err := exec.Command("losetup", "--partscan", device).Run(); err != nil {
-			fmt.Fprintf(os.Stderr, "Warning: losetup --partscan failed: %v\n", err)
-		}
+	scheme := &PartitionScheme{
+		BootPartition:  partitionDevicePath(device, 1),
+		Root1Partition: partitionDevicePath(device, 2),
+		Root2Partition: partitionDevicePath(device, 3),
+		VarPartition:   partitionDevicePath(device, 4),
 	}
-	if err := exec.Command("partprobe", device).Run(); err != nil {
-		fmt.Fprintf(os.Stderr, "Warning: partprobe failed: %v\n", err)
+	if verity {
+		scheme.Root1HashPartition = partitionDevicePath(device, 5)
+		scheme.Root2HashPartition = partitionDevicePath(device, 6)
 	}
-
-	// Wait for device nodes to appear
-	if err := exec.Command("udevadm", "settle").Run(); err != nil {
-		fmt.Fprintf(os.Stderr, "Warning: udevadm settle failed: %v\n", err)
-	}
-
-	// Determine partition device names
-	// Handle different device naming conventions
-	// nvme, mmcblk, and loop devices use "p" prefix for partitions
-	var part1, part2, part3, part4 string
-	if strings.HasPrefix(deviceBase, "nvme") || strings.HasPrefix(deviceBase, "mmcblk") || strings.HasPrefix(deviceBase, "loop") {
-		part1 = device + "p1"
-		part2 = device + "p2"
-		part3 = device + "p3"
-		part4 = device + "p4"
-	} else {
-		part1 = device + "1"
-		part2 = device + "2"
-		part3 = device + "3"
-		part4 = device + "4"
-	}
-
-	scheme := &PartitionScheme{
-		BootPartition:  part1,
-		Root1Partition: part2,
-		Root2Partition: part3,
-		VarPartition:   part4,
+	if swapSize > 0 {
+		scheme.SwapPartition = partitionDevicePath(device, len(layout))
 	}
 
 	fmt.Printf("Created partitions:\n")
@@ -107,13 +96,39 @@ func CreatePartitions(device string, dryRun bool) (*PartitionScheme, error) {
 	fmt.Printf("  Root1: %s\n", scheme.Root1Partition)
 	fmt.Printf("  Root2: %s\n", scheme.Root2Partition)
 	fmt.Printf("  Var:   %s\n", scheme.VarPartition)
+	if verity {
+		fmt.Printf("  Root1 hash tree: %s\n", scheme.Root1HashPartition)
+		fmt.Printf("  Root2 hash tree: %s\n", scheme.Root2HashPartition)
+	}
+	if scheme.SwapPartition != "" {
+		fmt.Printf("  Swap:  %s\n", scheme.SwapPartition)
+	}
 
 	return scheme, nil
 }
 
+// settleDevices waits for new device nodes to appear (udev may not be
+// running in an initrd)
+func settleDevices(ctx context.Context) error {
+	if _, err := executor.LookPath("udevadm"); err != nil {
+		return nil
+	}
+	if _, err := runCommand(ctx, "udevadm", "settle"); err != nil {
+		return warnf("udevadm settle failed: %v", err)
+	}
+	return nil
+}
+
 // FormatPartitions formats the partitions with appropriate filesystems
-func FormatPartitions(scheme *PartitionScheme, dryRun bool) error {
-	if dryRun {
+func FormatPartitions(ctx context.Context, scheme *PartitionScheme, dryRun bool) error {
+	return formatPartitions(ctx, scheme, keptPartitions{}, false, dryRun)
+}
+
+// formatPartitions formats the partitions of scheme, leaving the ones in
+// keep as they are, and discards the blocks of the root and /var partitions
+// if discard is set
+func formatPartitions(ctx context.Context, scheme *PartitionScheme, keep keptPartitions, discard, dryRun bool) error {
+	if dryRun && !tracing() {
 		fmt.Println("[DRY RUN] Would format partitions")
 		return nil
 	}
@@ -127,52 +142,97 @@ func FormatPartitions(scheme *PartitionScheme, dryRun bool) error {
 	fmt.Printf("Formatting partitions (filesystem: %s)...\n", fsType)
 
 	// Format boot partition as FAT32 (EFI System Partition)
-	fmt.Printf("  Formatting %s as FAT32 (boot/EFI)...\n", scheme.BootPartition)
-	cmd := exec.Command("mkfs.vfat", "-F", "32", "-n", "UEFI", scheme.BootPartition)
-	if output, err := cmd.CombinedOutput(); err != nil {
-		return fmt.Errorf("failed to format boot partition: %w\nOutput: %s", err, string(output))
+	if keep.boot {
+		fmt.Printf("  Keeping %s (shared EFI system partition)\n", scheme.BootPartition)
+	} else {
+		fmt.Printf("  Formatting %s as FAT32 (boot/EFI)...\n", scheme.BootPartition)
+		espCmd := espFormatCommand(scheme.BootPartition)
+		if output, err := runCommand(ctx, espCmd.Name, espCmd.Args...); err != nil {
+			return fmt.Errorf("failed to format boot partition: %w\nOutput: %s", err, string(output))
+		}
 	}
 
 	// Format first root partition
 	fmt.Printf("  Formatting %s as %s...\n", scheme.Root1Partition, fsType)
-	if err := formatPartition(scheme.Root1Partition, fsType, "root1"); err != nil {
+	if err := formatPartition(ctx, scheme.Root1Partition, fsType, "root1", discard); err != nil {
 		return fmt.Errorf("failed to format root1 partition: %w", err)
 	}
 
 	// Format second root partition
 	fmt.Printf("  Formatting %s as %s...\n", scheme.Root2Partition, fsType)
-	if err := formatPartition(scheme.Root2Partition, fsType, "root2"); err != nil {
+	if err := formatPartition(ctx, scheme.Root2Partition, fsType, "root2", discard); err != nil {
 		return fmt.Errorf("failed to format root2 partition: %w", err)
 	}
 
-	// Format /var partition
-	fmt.Printf("  Formatting %s as %s...\n", scheme.VarPartition, fsType)
-	if err := formatPartition(scheme.VarPartition, fsType, "var"); err != nil {
-		return fmt.Errorf("failed to format var partition: %w", err)
+	if keep.data {
+		fmt.Printf("  Keeping %s (/var)\n", scheme.VarPartition)
+		if scheme.SwapPartition != "" {
+			fmt.Printf("  Keeping %s (swap)\n", scheme.SwapPartition)
+		}
+	} else {
+		// Format /var partition
+		fmt.Printf("  Formatting %s as %s...\n", scheme.VarPartition, fsType)
+		if err := formatPartition(ctx, scheme.VarPartition, fsType, "var", discard); err != nil {
+			return fmt.Errorf("failed to format var partition: %w", err)
+		}
+
+		// Format swap partition
+		if scheme.SwapPartition != "" {
+			fmt.Printf("  Formatting %s as swap...\n", scheme.SwapPartition)
+			swapCmd := swapFormatCommand(scheme.SwapPartition)
+			if output, err := runCommand(ctx, swapCmd.Name, swapCmd.Args...); err != nil {
+				return fmt.Errorf("failed to format swap partition: %w\nOutput: %s", err, string(output))
+			}
+		}
 	}
 
 	fmt.Println("Formatting complete")
 	return nil
 }
 
-// formatPartition formats a single partition with the specified filesystem type
-func formatPartition(partition, fsType, label string) error {
-	var cmd *exec.Cmd
+// espFormatCommand returns the command that formats the boot/EFI partition
+func espFormatCommand(partition string) Command {
+	return Command{Name: "mkfs.vfat", Args: []string{"-F", "32", "-n", "UEFI", partition}}
+}
+
+// swapFormatCommand returns the command that formats the swap partition
+func swapFormatCommand(partition string) Command {
+	return Command{Name: "mkswap", Args: []string{"-L", "swap", partition}}
+}
 
+// formatCommand returns the mkfs command for a root or var partition.
+// discard has mkfs.ext4 discard the partition's blocks even if mke2fs.conf
+// turns that off; mkfs.btrfs always discards them.
+func formatCommand(partition, fsType, label string, discard bool) (Command, error) {
 	switch fsType {
 	case "ext4":
-		cmd = exec.Command("mkfs.ext4", "-F", "-L", label, partition)
-	case "btrfs":
-		// Check if mkfs.btrfs is available
-		if _, err := exec.LookPath("mkfs.btrfs"); err != nil {
-			return fmt.Errorf("mkfs.btrfs not found - install btrfs-progs package")
+		args := []string{"-F", "-L", label}
+		if discard {
+			args = append(args, "-E", "discard")
 		}
-		cmd = exec.Command("mkfs.btrfs", "-f", "-L", label, partition)
+		return Command{Name: "mkfs.ext4", Args: append(args, partition)}, nil
+	case "btrfs":
+		return Command{Name: "mkfs.btrfs", Args: []string{"-f", "-L", label, partition}}, nil
 	default:
-		return fmt.Errorf("unsupported filesystem type: %s (supported: ext4, btrfs)", fsType)
+		return Command{}, fmt.Errorf("unsupported filesystem type: %s (supported: ext4, btrfs)", fsType)
+	}
+}
+
+// formatPartition formats a single partition with the specified filesystem type
+func formatPartition(ctx context.Context, partition, fsType, label string, discard bool) error {
+	cmd, err := formatCommand(partition, fsType, label, discard)
+	if err != nil {
+		return err
 	}
 
-	if output, err := cmd.CombinedOutput(); err != nil {
+	// Check if mkfs.btrfs is available
+	if fsType == "btrfs" {
+		if _, err := executor.LookPath("mkfs.btrfs"); err != nil {
+			return fmt.Errorf("%w: mkfs.btrfs - install btrfs-progs package", ErrMissingTool)
+		}
+	}
+
+	if output, err := runWithToolProgress(ctx, cmd, PhaseFormat, &mkfsProgressParser{}, nil); err != nil {
 		return fmt.Errorf("mkfs failed: %w\nOutput: %s", err, string(output))
 	}
 	return nil
@@ -185,6 +245,12 @@ func MountPartitions(scheme *PartitionScheme, mountPoint string, dryRun bool) er
 		return nil
 	}
 
+	return mountPartitions(scheme, mountPoint, true)
+}
+
+// mountPartitions mounts the partitions of scheme at mountPoint, leaving
+// out the /var partition unless withVar is set
+func mountPartitions(scheme *PartitionScheme, mountPoint string, withVar bool) error {
 	fmt.Printf("Mounting partitions at %s...\n", mountPoint)
 
 	// Create mount point if it doesn't exist
@@ -193,9 +259,8 @@ func MountPartitions(scheme *PartitionScheme, mountPoint string, dryRun bool) er
 	}
 
 	// Mount first root partition
-	cmd := exec.Command("mount", scheme.Root1Partition, mountPoint)
-	if output, err := cmd.CombinedOutput(); err != nil {
-		return fmt.Errorf("failed to mount root1 partition: %w\nOutput: %s", err, string(output))
+	if err := mountDevice(scheme.Root1Partition, mountPoint, false); err != nil {
+		return fmt.Errorf("failed to mount root1 partition: %w", err)
 	}
 
 	// Create boot and var subdirectories
@@ -209,15 +274,15 @@ func MountPartitions(scheme *PartitionScheme, mountPoint string, dryRun bool) er
 	}
 
 	// Mount boot partition (FAT32 EFI System Partition)
-	cmd = exec.Command("mount", scheme.BootPartition, bootDir)
-	if output, err := cmd.CombinedOutput(); err != nil {
-		return fmt.Errorf("failed to mount boot partition: %w\nOutput: %s", err, string(output))
+	if err := mountDevice(scheme.BootPartition, bootDir, false); err != nil {
+		return fmt.Errorf("failed to mount boot partition: %w", err)
 	}
 
 	// Mount /var partition
-	cmd = exec.Command("mount", scheme.VarPartition, varDir)
-	if output, err := cmd.CombinedOutput(); err != nil {
-		return fmt.Errorf("failed to mount var partition: %w\nOutput: %s", err, string(output))
+	if withVar {
+		if err := mountDevice(scheme.VarPartition, varDir, false); err != nil {
+			return fmt.Errorf("failed to mount var partition: %w", err)
+		}
 	}
 
 	fmt.Println("Partitions mounted successfully")
@@ -237,30 +302,29 @@ func UnmountPartitions(mountPoint string, dryRun bool) error {
 	bootDir := filepath.Join(mountPoint, "boot")
 	varDir := filepath.Join(mountPoint, "var")
 
-	// Unmount boot
-	if err := exec.Command("umount", bootDir).Run(); err != nil {
-		fmt.Fprintf(os.Stderr, "Warning: failed to unmount boot: %v\n", err)
-	}
-
-	// Unmount /var
-	if err := exec.Command("umount", varDir).Run(); err != nil {
-		fmt.Fprintf(os.Stderr, "Warning: failed to unmount var: %v\n", err)
+	// Keep unmounting after a failure, but report the first one in strict mode
+	var firstErr error
+	unmount := func(name, dir string) {
+		if err := unmount(dir); err != nil {
+			if werr := warnf("failed to unmount %s: %v", name, err); werr != nil && firstErr == nil {
+				firstErr = werr
+			}
+		}
 	}
 
-	// Unmount root
-	if err := exec.Command("umount", mountPoint).Run(); err != nil {
-		fmt.Fprintf(os.Stderr, "Warning: failed to unmount root: %v\n", err)
-	}
+	unmount("boot", bootDir)
+	unmount("var", varDir)
+	unmount("root", mountPoint)
 
-	return nil
+	return firstErr
 }
 
-// GetPartitionUUID returns the UUID of a partition
+// GetPartitionUUID returns the filesystem UUID of a partition
+// Errors wrap *UUIDError (and ErrUnknownFilesystem if no superblock matched)
 func GetPartitionUUID(partition string) (string, error) {
-	cmd := exec.Command("blkid", "-s", "UUID", "-o", "value", partition)
-	output, err := cmd.Output()
+	uuid, err := readFilesystemUUID(partition)
 	if err != nil {
 		return "", fmt.Errorf("failed to get UUID: %w", err)
 	}
-	return strings.TrimSpace(string(output)), nil
+	return uuid, nil
 }
@@ -1,6 +1,7 @@
 package pkg
 
 import (
+	"context"
 	"os"
 	"path/filepath"
 	"testing"
@@ -10,7 +11,7 @@ import (
 
 func TestCreatePartitions(t *testing.T) {
 	testutil.RequireRoot(t)
-	testutil.RequireTools(t, "losetup", "sgdisk", "mkfs.vfat", "mkfs.ext4")
+	testutil.RequireTools(t, "losetup", "mkfs.vfat", "mkfs.ext4")
 
 	// Create a test disk
 	disk, err := testutil.CreateTestDisk(t, 50) // 50GB test disk
@@ -20,7 +21,7 @@ func TestCreatePartitions(t *testing.T) {
 
 	// Create partitions
 	t.Log("Creating partitions on test disk")
-	scheme, err := CreatePartitions(disk.GetDevice(), false)
+	scheme, err := CreatePartitions(context.Background(), disk.GetDevice(), false, 0, false)
 	if err != nil {
 		t.Fatalf("CreatePartitions failed: %v", err)
 	}
@@ -57,7 +58,7 @@ func TestCreatePartitions(t *testing.T) {
 
 func TestFormatPartitions(t *testing.T) {
 	testutil.RequireRoot(t)
-	testutil.RequireTools(t, "losetup", "sgdisk", "mkfs.vfat", "mkfs.ext4", "blkid")
+	testutil.RequireTools(t, "losetup", "mkfs.vfat", "mkfs.ext4")
 
 	// Create and partition test disk
 	disk, err := testutil.CreateTestDisk(t, 50)
@@ -65,7 +66,7 @@ func TestFormatPartitions(t *testing.T) {
 		t.Fatalf("Failed to create test disk: %v", err)
 	}
 
-	scheme, err := CreatePartitions(disk.GetDevice(), false)
+	scheme, err := CreatePartitions(context.Background(), disk.GetDevice(), false, 0, false)
 	if err != nil {
 		t.Fatalf("CreatePartitions failed: %v", err)
 	}
@@ -74,7 +75,7 @@ func TestFormatPartitions(t *testing.T) {
 
 	// Format partitions
 	t.Log("Formatting partitions")
-	if err := FormatPartitions(scheme, false); err != nil {
+	if err := FormatPartitions(context.Background(), scheme, false); err != nil {
 		t.Fatalf("FormatPartitions failed: %v", err)
 	}
 
@@ -98,7 +99,7 @@ func TestFormatPartitions(t *testing.T) {
 
 func TestMountPartitions(t *testing.T) {
 	testutil.RequireRoot(t)
-	testutil.RequireTools(t, "losetup", "sgdisk", "mkfs.vfat", "mkfs.ext4", "mount", "umount")
+	testutil.RequireTools(t, "losetup", "mkfs.vfat", "mkfs.ext4", "mount", "umount")
 
 	// Create and partition test disk
 	disk, err := testutil.CreateTestDisk(t, 50)
@@ -106,14 +107,14 @@ func TestMountPartitions(t *testing.T) {
 		t.Fatalf("Failed to create test disk: %v", err)
 	}
 
-	scheme, err := CreatePartitions(disk.GetDevice(), false)
+	scheme, err := CreatePartitions(context.Background(), disk.GetDevice(), false, 0, false)
 	if err != nil {
 		t.Fatalf("CreatePartitions failed: %v", err)
 	}
 
 	_ = testutil.WaitForDevice(disk.GetDevice())
 
-	if err := FormatPartitions(scheme, false); err != nil {
+	if err := FormatPartitions(context.Background(), scheme, false); err != nil {
 		t.Fatalf("FormatPartitions failed: %v", err)
 	}
 
@@ -160,7 +161,7 @@ func TestMountPartitions(t *testing.T) {
 
 func TestDetectExistingPartitionScheme(t *testing.T) {
 	testutil.RequireRoot(t)
-	testutil.RequireTools(t, "losetup", "sgdisk", "mkfs.vfat", "mkfs.ext4")
+	testutil.RequireTools(t, "losetup", "mkfs.vfat", "mkfs.ext4")
 
 	// Create and partition test disk
 	disk, err := testutil.CreateTestDisk(t, 50)
@@ -168,7 +169,7 @@ func TestDetectExistingPartitionScheme(t *testing.T) {
 		t.Fatalf("Failed to create test disk: %v", err)
 	}
 
-	originalScheme, err := CreatePartitions(disk.GetDevice(), false)
+	originalScheme, err := CreatePartitions(context.Background(), disk.GetDevice(), false, 0, false)
 	if err != nil {
 		t.Fatalf("CreatePartitions failed: %v", err)
 	}
@@ -0,0 +1,125 @@
+package pkg
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/diskfs/go-diskfs/partition/gpt"
+)
+
+// Finding partitions by GPT name
+//
+// CreatePartitions names every partition it creates: boot, root1, root2,
+// var, root1-verity, root2-verity and swap. Updates and reinstalls find the
+// partitions by these names rather than by number, so tables that were
+// reordered, extended with other partitions or laid out by hand with the
+// same names still work. The kernel reports the names of the partitions it
+// knows as PARTNAME in their sysfs uevent; the partitions of device-mapper
+// disks are kpartx mappings without one, and partitions added with BLKPG
+// rather than by a rescan have none either, so for those the names are read
+// from the partition table itself.
+
+// partitionsByLabel returns the partitions of device by their GPT names
+func partitionsByLabel(device string) (map[string]string, error) {
+	if parts, err := sysfsPartitionLabels(device); err != nil || len(parts) > 0 {
+		return parts, err
+	}
+
+	table, err := readGPTPartitionTable(device)
+	if err != nil {
+		return nil, err
+	}
+	return tablePartitionLabels(device, table)
+}
+
+// tablePartitionLabels returns the partitions of table on device by their
+// GPT names
+func tablePartitionLabels(device string, table *gpt.Table) (map[string]string, error) {
+	parts := map[string]string{}
+	for i, p := range table.Partitions {
+		if err := addPartitionLabel(parts, device, p.Name, partitionDevicePath(device, i+1)); err != nil {
+			return nil, err
+		}
+	}
+	return parts, nil
+}
+
+// sysfsPartitionLabels returns the kernel partitions of device by the GPT
+// names the kernel reports for them, or none if it does not report
+// a name for every partition
+func sysfsPartitionLabels(device string) (map[string]string, error) {
+	diskDir := filepath.Join(sysClassBlockPath, blockDeviceName(device))
+	entries, err := os.ReadDir(diskDir)
+	if err != nil {
+		return nil, nil
+	}
+
+	parts := map[string]string{}
+	for _, entry := range entries {
+		if _, err := os.Stat(filepath.Join(diskDir, entry.Name(), "partition")); err != nil {
+			continue
+		}
+		name := ueventValue(filepath.Join(diskDir, entry.Name(), "uevent"), "PARTNAME")
+		if name == "" {
+			return nil, nil
+		}
+		if err := addPartitionLabel(parts, device, name, filepath.Join(devPath, entry.Name())); err != nil {
+			return nil, err
+		}
+	}
+	return parts, nil
+}
+
+// ueventValue returns the value of key in the sysfs uevent file path, or ""
+// if it has none
+func ueventValue(path, key string) string {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	for line := range strings.Lines(string(data)) {
+		if value, ok := strings.CutPrefix(strings.TrimSpace(line), key+"="); ok {
+			return value
+		}
+	}
+	return ""
+}
+
+// addPartitionLabel records partition under its GPT name, which must be
+// unique on device to tell which partition is meant
+func addPartitionLabel(parts map[string]string, device, name, partition string) error {
+	if name == "" {
+		return nil
+	}
+	if other, ok := parts[name]; ok {
+		return fmt.Errorf("%w: %s has more than one partition named %q (%s and %s)", ErrPartitionSchemeMismatch, device, name, other, partition)
+	}
+	parts[name] = partition
+	return nil
+}
+
+// labeledPartitionScheme returns the partition scheme of the phukit install
+// on device from its partitions by GPT name. boot, root1, root2 and var are
+// required; the verity hash partitions are used if both exist, and swap if
+// it exists.
+func labeledPartitionScheme(device string, parts map[string]string) (*PartitionScheme, error) {
+	for _, name := range partitionRoles[:4] {
+		if parts[name] == "" {
+			return nil, fmt.Errorf("%w: %s was not partitioned by phukit (no partition named %q)", ErrPartitionSchemeMismatch, device, name)
+		}
+	}
+
+	scheme := &PartitionScheme{
+		BootPartition:  parts["boot"],
+		Root1Partition: parts["root1"],
+		Root2Partition: parts["root2"],
+		VarPartition:   parts["var"],
+		SwapPartition:  parts["swap"],
+	}
+	if hash1, hash2 := parts["root1-verity"], parts["root2-verity"]; hash1 != "" && hash2 != "" {
+		scheme.Root1HashPartition, scheme.Root2HashPartition = hash1, hash2
+	}
+	return scheme, nil
+}
@@ -0,0 +1,97 @@
+package pkg
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/diskfs/go-diskfs/partition/gpt"
+)
+
+func TestTablePartitionLabels(t *testing.T) {
+	const gib = 1024 * 1024 * 1024
+
+	t.Run("reordered table", func(t *testing.T) {
+		table, err := buildGPTTable(64*gib, 512, 512, []GPTPartitionSpec{
+			{Name: "boot", Type: gpt.EFISystemPartition, Size: 1 * gib},
+			{Name: "home", Type: gpt.LinuxFilesystem, Size: 8 * gib},
+			{Name: "var", Type: gpt.LinuxFilesystem, Size: 8 * gib},
+			{Name: "root2", Type: gpt.LinuxFilesystem, Size: 12 * gib},
+			{Name: "root1", Type: gpt.LinuxFilesystem, Size: 12 * gib},
+			{Name: "swap", Type: gpt.LinuxSwap, Size: 0},
+		})
+		if err != nil {
+			t.Fatalf("buildGPTTable() error = %v", err)
+		}
+		parts, err := tablePartitionLabels("/dev/vda", table)
+		if err != nil {
+			t.Fatalf("tablePartitionLabels() error = %v", err)
+		}
+		scheme, err := labeledPartitionScheme("/dev/vda", parts)
+		if err != nil {
+			t.Fatalf("labeledPartitionScheme() error = %v", err)
+		}
+		want := PartitionScheme{
+			BootPartition:  "/dev/vda1",
+			Root1Partition: "/dev/vda5",
+			Root2Partition: "/dev/vda4",
+			VarPartition:   "/dev/vda3",
+			SwapPartition:  "/dev/vda6",
+		}
+		if *scheme != want {
+			t.Errorf("labeledPartitionScheme() = %+v, want %+v", *scheme, want)
+		}
+	})
+
+	t.Run("duplicate names", func(t *testing.T) {
+		layout := defaultGPTLayout()
+		layout[2].Name = "root1"
+		table, err := buildGPTTable(64*gib, 512, 512, layout)
+		if err != nil {
+			t.Fatalf("buildGPTTable() error = %v", err)
+		}
+		if _, err := tablePartitionLabels("/dev/vda", table); !errors.Is(err, ErrPartitionSchemeMismatch) {
+			t.Errorf("tablePartitionLabels() error = %v, want ErrPartitionSchemeMismatch", err)
+		}
+	})
+}
+
+func TestSysfsPartitionLabels(t *testing.T) {
+	oldClass, oldDev := sysClassBlockPath, devPath
+	sysClassBlockPath = t.TempDir()
+	devPath = "/dev"
+	t.Cleanup(func() { sysClassBlockPath, devPath = oldClass, oldDev })
+
+	disk := filepath.Join(sysClassBlockPath, "sda")
+	for part, name := range map[string]string{"sda1": "boot", "sda2": "var", "sda3": "root1", "sda5": "root2"} {
+		writeFakeFile(t, filepath.Join(disk, part, "partition"), part[3:]+"\n")
+		writeFakeFile(t, filepath.Join(disk, part, "uevent"), "MAJOR=8\nDEVNAME="+part+"\nDEVTYPE=partition\nPARTNAME="+name+"\n")
+	}
+	writeFakeFile(t, filepath.Join(disk, "queue", "rotational"), "0\n")
+
+	parts, err := sysfsPartitionLabels("/dev/sda")
+	if err != nil {
+		t.Fatalf("sysfsPartitionLabels() error = %v", err)
+	}
+	scheme, err := labeledPartitionScheme("/dev/sda", parts)
+	if err != nil {
+		t.Fatalf("labeledPartitionScheme() error = %v", err)
+	}
+	want := PartitionScheme{
+		BootPartition:  "/dev/sda1",
+		Root1Partition: "/dev/sda3",
+		Root2Partition: "/dev/sda5",
+		VarPartition:   "/dev/sda2",
+	}
+	if *scheme != want {
+		t.Errorf("labeledPartitionScheme() = %+v, want %+v", *scheme, want)
+	}
+
+	// A partition the kernel knows no name for, such as one added with
+	// BLKPG, leaves the names to the partition table
+	writeFakeFile(t, filepath.Join(disk, "sda6", "partition"), "6\n")
+	writeFakeFile(t, filepath.Join(disk, "sda6", "uevent"), "MAJOR=8\nDEVNAME=sda6\nDEVTYPE=partition\n")
+	if parts, err := sysfsPartitionLabels("/dev/sda"); err != nil || parts != nil {
+		t.Errorf("sysfsPartitionLabels() = %v, %v, want none", parts, err)
+	}
+}
@@ -0,0 +1,81 @@
+package pkg
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestApplyPin(t *testing.T) {
+	t.Cleanup(func() { SetStrictMode(false) })
+
+	const (
+		image  = "quay.io/example/os:stable"
+		pinned = "sha256:1111111111111111111111111111111111111111111111111111111111111111"
+		moved  = "sha256:2222222222222222222222222222222222222222222222222222222222222222"
+	)
+
+	tests := []struct {
+		name       string
+		config     SystemConfig
+		imageRef   string
+		remote     string
+		repin      bool
+		strict     bool
+		wantDigest string
+		wantPin    string
+		wantErr    error
+	}{
+		{"not pinned", SystemConfig{ImageRef: image}, image, moved, false, false, moved, "", nil},
+		{"pin matches", SystemConfig{ImageRef: image, PinnedDigest: pinned}, image, pinned, false, false, pinned, pinned, nil},
+		{"tag moved, warn", SystemConfig{ImageRef: image, PinnedDigest: pinned, PinPolicy: PinPolicyWarn}, image, moved, false, false, pinned, pinned, nil},
+		{"tag moved, warn, strict", SystemConfig{ImageRef: image, PinnedDigest: pinned, PinPolicy: PinPolicyWarn}, image, moved, false, true, "", "", ErrStrict},
+		{"tag moved, fail", SystemConfig{ImageRef: image, PinnedDigest: pinned, PinPolicy: PinPolicyFail}, image, moved, false, false, "", "", ErrPinMismatch},
+		{"repin", SystemConfig{ImageRef: image, PinnedDigest: pinned, PinPolicy: PinPolicyFail}, image, moved, true, false, moved, moved, nil},
+		{"repin unpinned", SystemConfig{ImageRef: image}, image, moved, true, false, moved, moved, nil},
+		{"other image", SystemConfig{ImageRef: image, PinnedDigest: pinned, PinPolicy: PinPolicyFail}, "quay.io/example/os:next", moved, false, false, moved, "", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			SetStrictMode(tt.strict)
+			u := NewSystemUpdater("/dev/sda", tt.imageRef)
+			u.SetRepin(tt.repin)
+
+			digest, err := u.applyPin(&tt.config, tt.remote)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("applyPin error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("applyPin failed: %v", err)
+			}
+			if digest != tt.wantDigest {
+				t.Errorf("digest = %s, want %s", digest, tt.wantDigest)
+			}
+			if u.Config.PinnedDigest != tt.wantPin {
+				t.Errorf("PinnedDigest = %s, want %s", u.Config.PinnedDigest, tt.wantPin)
+			}
+			if tt.repin && u.Config.PinPolicy == "" {
+				t.Error("repin left the pin policy empty")
+			}
+		})
+	}
+}
+
+func TestPinnedReference(t *testing.T) {
+	const digest = "sha256:1111111111111111111111111111111111111111111111111111111111111111"
+
+	got, err := pinnedReference("quay.io/example/os:stable", digest)
+	if err != nil {
+		t.Fatalf("pinnedReference failed: %v", err)
+	}
+	if want := "quay.io/example/os@" + digest; got != want {
+		t.Errorf("pinnedReference = %s, want %s", got, want)
+	}
+
+	if _, err := pinnedReference("not a valid ref", digest); !errors.Is(err, ErrInvalidImageReference) {
+		t.Errorf("expected ErrInvalidImageReference, got %v", err)
+	}
+}
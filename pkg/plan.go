@@ -0,0 +1,366 @@
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Plan describes exactly what an install will do to a disk, without changing
+// anything. Frontends can render it as a preview and then pass it to Apply.
+type Plan struct {
+	ImageRef       string             `json:"image_ref"`
+	ImageDigest    string             `json:"image_digest,omitempty"` // Empty if the registry could not be reached
+	Device         string             `json:"device"`
+	DiskSize       uint64             `json:"disk_size"`
+	SectorSize     int                `json:"sector_size"`
+	FilesystemType string             `json:"filesystem_type"`
+	Verity         bool               `json:"verity,omitempty"`    // Root sealed with dm-verity
+	Composefs      bool               `json:"composefs,omitempty"` // /usr stored as composefs
+	KernelArgs     []string           `json:"kernel_args,omitempty"`
+	Partitions     []PlannedPartition `json:"partitions"`
+	Steps          []PlanStep         `json:"steps"`
+	Warnings       []string           `json:"warnings,omitempty"`
+}
+
+// PlannedPartition is a partition the install will create
+type PlannedPartition struct {
+	Number     int    `json:"number"`
+	Device     string `json:"device"`
+	Name       string `json:"name"` // GPT partition name
+	TypeGUID   string `json:"type_guid"`
+	Start      uint64 `json:"start"` // Offset in bytes
+	Size       uint64 `json:"size"`  // Size in bytes
+	Filesystem string `json:"filesystem"`
+	Label      string `json:"label"`
+	MountPoint string `json:"mount_point,omitempty"` // Empty for the standby root
+}
+
+// PlanStep is one step of the install with the external commands it runs and
+// the files it writes
+type PlanStep struct {
+	Description string   `json:"description"`
+	Commands    []string `json:"commands,omitempty"`
+	Actions     []string `json:"actions,omitempty"`
+}
+
+// diskGeometry returns the size and sector sizes of a disk from sysfs
+func diskGeometry(device string) (size uint64, logical, physical int, err error) {
+	if resolved, rerr := filepath.EvalSymlinks(device); rerr == nil {
+		device = resolved
+	}
+	dir := filepath.Join(sysClassBlockPath, filepath.Base(device))
+
+	readInt := func(name string) (uint64, error) {
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			return 0, err
+		}
+		return strconv.ParseUint(strings.TrimSpace(string(data)), 10, 64)
+	}
+
+	// sysfs always reports the size in 512-byte units
+	sectors, err := readInt("size")
+	if err != nil {
+		return 0, 0, 0, fmt.Errorf("failed to read size of %s: %w", device, err)
+	}
+	logical, physical = 512, 512
+	if v, err := readInt("queue/logical_block_size"); err == nil && v > 0 {
+		logical = int(v)
+	}
+	if v, err := readInt("queue/physical_block_size"); err == nil && v > 0 {
+		physical = int(v)
+	}
+	return sectors * 512, logical, physical, nil
+}
+
+// Plan computes the partition layout, commands and file operations of an
+// install without touching the disk
+func (b *BootcInstaller) Plan(ctx context.Context) (*Plan, error) {
+	fsType := b.FilesystemType
+	if fsType == "" {
+		fsType = "ext4"
+	}
+	if _, err := formatCommand(b.Device, fsType, "", false); err != nil {
+		return nil, err
+	}
+	if b.Verity && b.Composefs {
+		return nil, fmt.Errorf("a root cannot use both dm-verity and composefs")
+	}
+	if b.Verity && fsType != "ext4" {
+		return nil, fmt.Errorf("a dm-verity root requires ext4, not %s", fsType)
+	}
+	if b.VolumeGroup != "" {
+		return nil, fmt.Errorf("install plans do not cover the LVM layout")
+	}
+	layout := installGPTLayout(b.Verity, b.SwapSize)
+
+	size, logical, physical, err := diskGeometry(b.Device)
+	if err != nil {
+		return nil, err
+	}
+	if size < MinimumDiskSize {
+		return nil, &InsufficientSpaceError{Device: b.Device, Size: size, Required: MinimumDiskSize}
+	}
+
+	table, err := buildGPTTable(int64(size), logical, physical, layout)
+	if err != nil {
+		return nil, err
+	}
+
+	plan := &Plan{
+		ImageRef:       b.ImageRef,
+		Device:         b.Device,
+		DiskSize:       size,
+		SectorSize:     logical,
+		FilesystemType: fsType,
+		Verity:         b.Verity,
+		Composefs:      b.Composefs,
+		KernelArgs:     append([]string{}, b.KernelArgs...),
+	}
+
+	if digest, err := GetRemoteImageDigest(ctx, b.ImageRef); err == nil {
+		plan.ImageDigest = digest
+	} else {
+		plan.Warnings = append(plan.Warnings, fmt.Sprintf("could not resolve image digest: %v", err))
+	}
+
+	mountPoints := map[string]string{"boot": "/boot", "root1": "/", "var": "/var"}
+	for i, p := range table.Partitions {
+		part := PlannedPartition{
+			Number:     i + 1,
+			Device:     partitionDevicePath(b.Device, i+1),
+			Name:       p.Name,
+			TypeGUID:   strings.ToLower(string(p.Type)),
+			Start:      p.Start * uint64(logical),
+			Size:       (p.End - p.Start + 1) * uint64(logical),
+			Filesystem: fsType,
+			Label:      p.Name,
+			MountPoint: mountPoints[p.Name],
+		}
+		if p.Name == "boot" {
+			part.Filesystem = "vfat"
+			part.Label = "UEFI"
+		}
+		if strings.HasSuffix(p.Name, "-verity") {
+			part.Filesystem = "verity"
+			part.Label = ""
+		}
+		if p.Name == "swap" {
+			part.Filesystem = "swap"
+		}
+		plan.Partitions = append(plan.Partitions, part)
+	}
+
+	// Anything using the disk is either released first or stops the install
+	usages, err := FindDeviceUsage(b.Device)
+	if err != nil {
+		plan.Warnings = append(plan.Warnings, fmt.Sprintf("could not check whether %s is in use: %v", b.Device, err))
+	}
+	if len(usages) > 0 {
+		step := PlanStep{Description: "Release " + b.Device}
+		for _, u := range usages {
+			step.Actions = append(step.Actions, u.String())
+		}
+		if b.ForceUnmount {
+			plan.Steps = append(plan.Steps, step)
+		} else {
+			plan.Warnings = append(plan.Warnings, fmt.Sprintf("%s is in use and the install will fail (use --force-unmount):\n  %s",
+				b.Device, strings.Join(step.Actions, "\n  ")))
+		}
+	}
+
+	plan.Steps = append(plan.Steps, b.planSteps(plan)...)
+	return plan, nil
+}
+
+// planSteps lists the install steps for plan, following Install
+func (b *BootcInstaller) planSteps(plan *Plan) []PlanStep {
+	boot, root1, root2, varPart := plan.Partitions[0], plan.Partitions[1], plan.Partitions[2], plan.Partitions[3]
+	mp := b.MountPoint
+
+	format := PlanStep{
+		Description: "Format partitions",
+		Commands:    []string{espFormatCommand(boot.Device).String()},
+	}
+	for _, p := range []PlannedPartition{root1, root2, varPart} {
+		cmd, _ := formatCommand(p.Device, plan.FilesystemType, p.Label, b.discard())
+		format.Commands = append(format.Commands, cmd.String())
+	}
+	for _, p := range plan.Partitions {
+		if p.Name == "swap" {
+			format.Commands = append(format.Commands, swapFormatCommand(p.Device).String())
+		}
+	}
+
+	wipe := PlanStep{
+		Description: "Wipe disk",
+		Actions:     []string{"zero primary and backup GPT headers on " + plan.Device},
+	}
+	if cmd, ok := eraseCommand(plan.Device, b.WipeMode); ok {
+		wipe.Commands = append(wipe.Commands, cmd.String())
+	}
+	wipe.Commands = append(wipe.Commands, Command{Name: "wipefs", Args: []string{"--all", plan.Device}}.String())
+
+	steps := []PlanStep{
+		wipe,
+		{
+			Description: "Create GPT partition table",
+			Actions:     []string{fmt.Sprintf("write %d partitions to %s", len(plan.Partitions), plan.Device)},
+		},
+		format,
+		{
+			Description: "Mount partitions",
+			Actions: []string{
+				fmt.Sprintf("mount %s at %s", root1.Device, mp),
+				fmt.Sprintf("mount %s at %s", boot.Device, filepath.Join(mp, "boot")),
+				fmt.Sprintf("mount %s at %s", varPart.Device, filepath.Join(mp, "var")),
+			},
+		},
+		{
+			Description: "Extract container filesystem",
+			Actions:     []string{fmt.Sprintf("extract %s into %s", plan.ImageRef, mp)},
+		},
+		{
+			Description: "Configure system",
+			Commands:    []string{"chroot " + mp + " depmod -a <kernel>", "chroot " + mp + " systemd-sysusers", "chroot " + mp + " systemd-tmpfiles --create"},
+			Actions: []string{
+				"write " + filepath.Join(mp, "etc/fstab"),
+				"copy /etc to " + filepath.Join(mp, PristineEtcPath),
+				"write " + filepath.Join(mp, SystemConfigFile),
+				"generate an initramfs for kernels that ship without one",
+			},
+		},
+	}
+	if plan.Verity {
+		root1Hash := plan.Partitions[4]
+		steps = append(steps, PlanStep{
+			Description: "Seal root with dm-verity",
+			Commands:    []string{Command{Name: "veritysetup", Args: []string{"format", root1.Device, root1Hash.Device}}.String()},
+			Actions:     []string{"remount " + mp + " read-only", "record the root hash in " + filepath.Join(mp, "boot", VerityRootsFile)},
+		})
+	}
+	if plan.Composefs {
+		steps = append(steps, PlanStep{
+			Description: "Build composefs image",
+			Commands: []string{Command{Name: "mkcomposefs", Args: []string{
+				"--digest-store=" + filepath.Join(mp, ComposefsObjectsPath), "--print-digest", filepath.Join(mp, "usr"), filepath.Join(mp, composefsImagePath),
+			}}.String()},
+			Actions: []string{
+				"record the image digest in " + filepath.Join(mp, "boot", ComposefsImagesFile),
+				"empty " + filepath.Join(mp, "usr") + " after installing the bootloader",
+			},
+		})
+	}
+	return append(steps,
+		PlanStep{
+			Description: "Install bootloader",
+			Actions: []string{
+				"install GRUB2 or systemd-boot (detected from the image) to " + filepath.Join(mp, "boot"),
+				fmt.Sprintf("boot entry with root=UUID of %s and kernel arguments: %s", root1.Device, strings.Join(plan.KernelArgs, " ")),
+			},
+		},
+		PlanStep{
+			Description: "Verify installation",
+			Actions:     []string{fmt.Sprintf("check the partitions on %s (%s is kept empty for updates)", plan.Device, root2.Device)},
+		},
+	)
+}
+
+// Apply performs the install described by plan without prompting. It fails if
+// the plan was made for another image, device or disk size.
+func (b *BootcInstaller) Apply(ctx context.Context, plan *Plan) error {
+	if plan == nil {
+		return fmt.Errorf("no plan given")
+	}
+	if plan.Device != b.Device || plan.ImageRef != b.ImageRef {
+		return fmt.Errorf("plan is for %s on %s, not %s on %s", plan.ImageRef, plan.Device, b.ImageRef, b.Device)
+	}
+	if size, _, _, err := diskGeometry(b.Device); err != nil {
+		return err
+	} else if size != plan.DiskSize {
+		return fmt.Errorf("disk %s changed since the plan was made (%s, planned %s)", b.Device, FormatSize(size), FormatSize(plan.DiskSize))
+	}
+	if plan.FilesystemType != "" {
+		b.FilesystemType = plan.FilesystemType
+	}
+	b.Verity = plan.Verity
+	b.Composefs = plan.Composefs
+
+	fmt.Println("Checking prerequisites...")
+	if err := CheckRequiredTools(); err != nil {
+		return fmt.Errorf("missing required tools: %w", err)
+	}
+	if err := b.checkRootOptions(); err != nil {
+		return err
+	}
+	fmt.Printf("Validating disk %s...\n", b.Device)
+	if err := validateDisk(b.Device, MinimumDiskSize, !b.ForceUnmount); err != nil {
+		return err
+	}
+	if b.ForceUnmount {
+		if err := ensureNotSystemDisk(b.Device); err != nil {
+			return err
+		}
+	}
+	if err := checkWipeMode(ctx, b.Device, b.WipeMode); err != nil {
+		return err
+	}
+	if err := checkImageFits(ctx, b.ImageRef, "root1", defaultGPTLayout()[1].Size, nil); err != nil {
+		return err
+	}
+
+	defer b.runCommands()()
+	return b.wipeAndInstall(ctx)
+}
+
+// Print writes a human-readable summary of the plan
+func (p *Plan) Print(w io.Writer) {
+	_, _ = fmt.Fprintf(w, "Install plan for %s\n", p.Device)
+	_, _ = fmt.Fprintf(w, "  Image:      %s\n", p.ImageRef)
+	if p.ImageDigest != "" {
+		_, _ = fmt.Fprintf(w, "  Digest:     %s\n", p.ImageDigest)
+	}
+	_, _ = fmt.Fprintf(w, "  Disk size:  %s (%d-byte sectors)\n", FormatSize(p.DiskSize), p.SectorSize)
+	_, _ = fmt.Fprintf(w, "  Filesystem: %s\n", p.FilesystemType)
+	if p.Verity {
+		_, _ = fmt.Fprintln(w, "  Root:       read-only, dm-verity")
+	}
+	if p.Composefs {
+		_, _ = fmt.Fprintln(w, "  /usr:       composefs")
+	}
+	if len(p.KernelArgs) > 0 {
+		_, _ = fmt.Fprintf(w, "  Kernel args: %s\n", strings.Join(p.KernelArgs, " "))
+	}
+
+	_, _ = fmt.Fprintln(w, "\nPartitions:")
+	for _, part := range p.Partitions {
+		mount := part.MountPoint
+		if part.Filesystem == "verity" {
+			mount = "(hash tree)"
+		} else if mount == "" {
+			mount = "(standby root)"
+		}
+		_, _ = fmt.Fprintf(w, "  %d  %-14s %-6s %-6s %10s  %s\n",
+			part.Number, part.Device, part.Name, part.Filesystem, FormatSize(part.Size), mount)
+	}
+
+	_, _ = fmt.Fprintln(w, "\nSteps:")
+	for i, step := range p.Steps {
+		_, _ = fmt.Fprintf(w, "  %d. %s\n", i+1, step.Description)
+		for _, c := range step.Commands {
+			_, _ = fmt.Fprintf(w, "       $ %s\n", c)
+		}
+		for _, a := range step.Actions {
+			_, _ = fmt.Fprintf(w, "       - %s\n", a)
+		}
+	}
+
+	for _, warning := range p.Warnings {
+		_, _ = fmt.Fprintf(w, "\nWarning: %s\n", warning)
+	}
+}
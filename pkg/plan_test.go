@@ -0,0 +1,112 @@
+package pkg
+
+import (
+	"bytes"
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestInstallPlan(t *testing.T) {
+	setupFakeBlockDevices(t)
+	// 64 GiB disk with 4K physical sectors
+	writeFakeFile(t, filepath.Join(sysClassBlockPath, "vdb", "size"), "134217728\n")
+	writeFakeFile(t, filepath.Join(sysClassBlockPath, "vdb", "queue", "logical_block_size"), "512\n")
+	writeFakeFile(t, filepath.Join(sysClassBlockPath, "vdb", "queue", "physical_block_size"), "4096\n")
+
+	// An unparseable reference keeps the test off the network
+	installer := NewBootcInstaller("not a valid ref", "/dev/vdb")
+	installer.SetFilesystemType("btrfs")
+
+	plan, err := installer.Plan(context.Background())
+	if err != nil {
+		t.Fatalf("Plan failed: %v", err)
+	}
+
+	if plan.DiskSize != 64*1024*1024*1024 || plan.SectorSize != 512 {
+		t.Errorf("geometry = %d/%d, want 64GiB/512", plan.DiskSize, plan.SectorSize)
+	}
+
+	want := []struct{ device, name, fs, mount string }{
+		{"/dev/vdb1", "boot", "vfat", "/boot"},
+		{"/dev/vdb2", "root1", "btrfs", "/"},
+		{"/dev/vdb3", "root2", "btrfs", ""},
+		{"/dev/vdb4", "var", "btrfs", "/var"},
+	}
+	if len(plan.Partitions) != len(want) {
+		t.Fatalf("got %d partitions, want %d", len(plan.Partitions), len(want))
+	}
+	var end uint64
+	for i, w := range want {
+		p := plan.Partitions[i]
+		if p.Device != w.device || p.Name != w.name || p.Filesystem != w.fs || p.MountPoint != w.mount {
+			t.Errorf("partition %d = %+v, want %+v", i+1, p, w)
+		}
+		if p.Start%4096 != 0 {
+			t.Errorf("partition %d starts at %d, not aligned to physical sectors", i+1, p.Start)
+		}
+		if p.Start < end {
+			t.Errorf("partition %d overlaps the previous one", i+1)
+		}
+		end = p.Start + p.Size
+	}
+	if end > plan.DiskSize {
+		t.Errorf("partitions end at %d, beyond the disk size %d", end, plan.DiskSize)
+	}
+
+	var out bytes.Buffer
+	plan.Print(&out)
+	for _, s := range []string{"mkfs.vfat -F 32 -n UEFI /dev/vdb1", "mkfs.btrfs -f -L var /dev/vdb4", "wipefs --all /dev/vdb"} {
+		if !strings.Contains(out.String(), s) {
+			t.Errorf("plan output missing %q:\n%s", s, out.String())
+		}
+	}
+
+	// vdb1 is mounted in the fake sysfs, so the plan warns instead of releasing it
+	if !strings.Contains(strings.Join(plan.Warnings, "\n"), "--force-unmount") {
+		t.Errorf("expected an in-use warning, got %v", plan.Warnings)
+	}
+	if plan.Steps[0].Description != "Wipe disk" {
+		t.Errorf("first step = %q, want Wipe disk", plan.Steps[0].Description)
+	}
+
+	installer.SetForceUnmount(true)
+	plan, err = installer.Plan(context.Background())
+	if err != nil {
+		t.Fatalf("Plan failed: %v", err)
+	}
+	if plan.Steps[0].Description != "Release /dev/vdb" || len(plan.Steps[0].Actions) == 0 {
+		t.Errorf("first step = %+v, want the release step", plan.Steps[0])
+	}
+}
+
+func TestApplyRejectsStalePlan(t *testing.T) {
+	setupFakeBlockDevices(t)
+	writeFakeFile(t, filepath.Join(sysClassBlockPath, "vdb", "size"), "134217728\n")
+
+	installer := NewBootcInstaller("not a valid ref", "/dev/vdb")
+	plan, err := installer.Plan(context.Background())
+	if err != nil {
+		t.Fatalf("Plan failed: %v", err)
+	}
+
+	other := NewBootcInstaller("not a valid ref", "/dev/vdc")
+	if err := other.Apply(context.Background(), plan); err == nil {
+		t.Error("expected Apply to reject a plan for another device")
+	}
+
+	writeFakeFile(t, filepath.Join(sysClassBlockPath, "vdb", "size"), "67108864\n")
+	if err := installer.Apply(context.Background(), plan); err == nil || !strings.Contains(err.Error(), "changed since") {
+		t.Errorf("expected Apply to reject a plan for a resized disk, got %v", err)
+	}
+}
+
+func TestPlanDiskTooSmall(t *testing.T) {
+	setupFakeBlockDevices(t)
+	writeFakeFile(t, filepath.Join(sysClassBlockPath, "vdb", "size"), "2097152\n")
+
+	if _, err := NewBootcInstaller("not a valid ref", "/dev/vdb").Plan(context.Background()); err == nil {
+		t.Error("expected Plan to fail for a 1GiB disk")
+	}
+}
@@ -0,0 +1,278 @@
+package pkg
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/name"
+)
+
+// Image policy
+//
+// /etc/phukit/policy.json restricts which images this machine installs and
+// updates to, like containers-policy.json does for podman:
+//
+//	{
+//	  "allowed_registries": ["quay.io/my-org", "registry.example.com"],
+//	  "require_digest": false,
+//	  "signatures": [
+//	    {"scope": "quay.io/my-org", "key_path": "/etc/phukit/keys/cosign.pub"},
+//	    {"scope": "registry.example.com", "certificate_identity": "builder@example.com",
+//	     "certificate_oidc_issuer": "https://accounts.google.com", "certificate_roots": "/etc/phukit/keys/fulcio.pem",
+//	     "rekor_public_key": "/etc/phukit/keys/rekor.pub", "rekor_url": "https://rekor.sigstore.dev"},
+//	    {"scope": "registry.redhat.io", "type": "simple_signing", "key_path": "/etc/pki/rpm-gpg/RPM-GPG-KEY-redhat-release",
+//	     "lookaside": "https://registry.redhat.io/containers/sigstore"}
+//	  ]
+//	}
+//
+// A scope is a registry, a repository namespace or a repository, and matches
+// the references below it on path boundaries. An image must be in one of the
+// allowed registries (any if none are listed), referenced by digest if
+// require_digest is set, and carry a valid cosign or simple signing
+// signature for each signature requirement of its most specific scope. The policy is evaluated
+// before anything is extracted; without a policy file every image is
+// allowed, and an unreadable or invalid one rejects every image.
+
+// PolicyPath is the image policy of this machine, a variable for tests
+var PolicyPath = "/etc/phukit/policy.json"
+
+// ImagePolicy is the content of the policy file
+type ImagePolicy struct {
+	AllowedRegistries []string               `json:"allowed_registries,omitempty"`
+	RequireDigest     bool                   `json:"require_digest,omitempty"`
+	Signatures        []SignatureRequirement `json:"signatures,omitempty"`
+}
+
+// Signature types of a SignatureRequirement
+const (
+	SignatureTypeCosign        = "cosign"
+	SignatureTypeSimpleSigning = "simple_signing"
+)
+
+// SignatureRequirement is a signature the images of Scope must carry. A
+// cosign signature is made with the public key at KeyPath, or with a
+// certificate for CertificateIdentity from CertificateOIDCIssuer issued by a
+// CA of CertificateRoots (keyless signing). A simple signing signature is
+// made with a GPG key of the keyring at KeyPath and published below
+// Lookaside. With RekorPublicKey, cosign signatures must also be in that
+// Rekor transparency log, checked with the log at RekorURL if set and with
+// the bundle of the signature otherwise.
+type SignatureRequirement struct {
+	Scope                 string `json:"scope"`
+	Type                  string `json:"type,omitempty"` // SignatureTypeCosign (default) or SignatureTypeSimpleSigning
+	KeyPath               string `json:"key_path,omitempty"`
+	Lookaside             string `json:"lookaside,omitempty"`               // Base URL of simple signing signatures
+	CertificateIdentity   string `json:"certificate_identity,omitempty"`    // Email or URI of the signer
+	CertificateOIDCIssuer string `json:"certificate_oidc_issuer,omitempty"` // OIDC issuer that vouched for it
+	CertificateRoots      string `json:"certificate_roots,omitempty"`       // PEM bundle of the trusted CAs
+	RekorPublicKey        string `json:"rekor_public_key,omitempty"`        // PEM public key of the transparency log
+	RekorURL              string `json:"rekor_url,omitempty"`               // Rekor server for online verification
+}
+
+// SignatureVerification is a signature requirement an image satisfied,
+// recorded in the result document and the history
+type SignatureVerification struct {
+	Scope           string                `json:"scope"`
+	Type            string                `json:"type"`
+	Signer          string                `json:"signer"` // Key or certificate identity
+	TransparencyLog *TransparencyLogEntry `json:"transparency_log,omitempty"`
+}
+
+// ReadImagePolicy reads the policy file, returning nil if there is none
+func ReadImagePolicy() (*ImagePolicy, error) {
+	return readImagePolicy(PolicyPath)
+}
+
+// readImagePolicy reads and validates the policy file at path
+func readImagePolicy(path string) (*ImagePolicy, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read image policy: %w", err)
+	}
+	var policy ImagePolicy
+	if err := json.Unmarshal(data, &policy); err != nil {
+		return nil, fmt.Errorf("failed to parse image policy %s: %w", path, err)
+	}
+	if err := policy.validate(); err != nil {
+		return nil, fmt.Errorf("invalid image policy %s: %w", path, err)
+	}
+	return &policy, nil
+}
+
+// validate checks that every requirement can be evaluated
+func (p *ImagePolicy) validate() error {
+	for _, scope := range p.AllowedRegistries {
+		if scope == "" {
+			return fmt.Errorf("empty allowed registry")
+		}
+	}
+	for _, req := range p.Signatures {
+		if req.Scope == "" {
+			return fmt.Errorf("signature requirement without scope")
+		}
+		switch req.Type {
+		case "", SignatureTypeCosign:
+		case SignatureTypeSimpleSigning:
+			if req.KeyPath == "" || req.Lookaside == "" {
+				return fmt.Errorf("simple signing requirement for %s needs key_path and lookaside", req.Scope)
+			}
+			if req.CertificateIdentity != "" || req.CertificateOIDCIssuer != "" || req.CertificateRoots != "" {
+				return fmt.Errorf("simple signing requirement for %s cannot have a certificate identity", req.Scope)
+			}
+			if req.RekorPublicKey != "" || req.RekorURL != "" {
+				return fmt.Errorf("simple signing requirement for %s cannot use a transparency log", req.Scope)
+			}
+			if err := checkLookaside(req.Lookaside); err != nil {
+				return fmt.Errorf("simple signing requirement for %s: %w", req.Scope, err)
+			}
+			continue
+		default:
+			return fmt.Errorf("signature requirement for %s has unknown type %q", req.Scope, req.Type)
+		}
+		if req.Lookaside != "" {
+			return fmt.Errorf("signature requirement for %s has a lookaside but is not simple_signing", req.Scope)
+		}
+		if req.RekorURL != "" && req.RekorPublicKey == "" {
+			return fmt.Errorf("signature requirement for %s needs rekor_public_key to use rekor_url", req.Scope)
+		}
+		keyless := req.CertificateIdentity != "" || req.CertificateOIDCIssuer != "" || req.CertificateRoots != ""
+		switch {
+		case req.KeyPath != "" && keyless:
+			return fmt.Errorf("signature requirement for %s has both a key and a certificate identity", req.Scope)
+		case req.KeyPath == "" && !keyless:
+			return fmt.Errorf("signature requirement for %s needs key_path or certificate_identity", req.Scope)
+		case keyless && (req.CertificateIdentity == "" || req.CertificateOIDCIssuer == "" || req.CertificateRoots == ""):
+			return fmt.Errorf("signature requirement for %s needs certificate_identity, certificate_oidc_issuer and certificate_roots", req.Scope)
+		}
+	}
+	return nil
+}
+
+// scopeMatches reports whether the repository repo (registry/path) is scope
+// or below it
+func scopeMatches(scope, repo string) bool {
+	scope = strings.TrimSuffix(scope, "/")
+	return repo == scope || strings.HasPrefix(repo, scope+"/")
+}
+
+// repositoryName returns the registry/path of ref as written in policies,
+// such as quay.io/example/os or docker.io/library/fedora
+func repositoryName(ref name.Reference) string {
+	repo := ref.Context()
+	registry := repo.RegistryStr()
+	if registry == name.DefaultRegistry {
+		registry = "docker.io"
+	}
+	return registry + "/" + repo.RepositoryStr()
+}
+
+// signatureRequirements returns the requirements of the most specific scope
+// matching repo
+func (p *ImagePolicy) signatureRequirements(repo string) []SignatureRequirement {
+	var reqs []SignatureRequirement
+	longest := -1
+	for _, req := range p.Signatures {
+		scope := strings.TrimSuffix(req.Scope, "/")
+		if !scopeMatches(scope, repo) || len(scope) < longest {
+			continue
+		}
+		if len(scope) > longest {
+			reqs, longest = nil, len(scope)
+		}
+		reqs = append(reqs, req)
+	}
+	return reqs
+}
+
+// checkReference checks the parts of the policy that need no registry
+// access: the allowed registries and the digest requirement
+func (p *ImagePolicy) checkReference(ref name.Reference) error {
+	repo := repositoryName(ref)
+	if len(p.AllowedRegistries) > 0 {
+		allowed := false
+		for _, scope := range p.AllowedRegistries {
+			if scopeMatches(scope, repo) {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return fmt.Errorf("%w: %s is not in an allowed registry (%s)", ErrPolicyViolation, repo, strings.Join(p.AllowedRegistries, ", "))
+		}
+	}
+	if _, ok := ref.(name.Digest); p.RequireDigest && !ok {
+		return fmt.Errorf("%w: %s must be referenced by digest (repository@sha256:...)", ErrPolicyViolation, ref)
+	}
+	return nil
+}
+
+// CheckImagePolicy evaluates the policy of this machine for imageRef and
+// returns the reference to pull and extract, as Check does. It returns an
+// error wrapping ErrPolicyViolation if the policy rejects the image.
+func CheckImagePolicy(ctx context.Context, imageRef string) (string, error) {
+	policy, err := ReadImagePolicy()
+	if err != nil {
+		return "", fmt.Errorf("%w: %w", ErrPolicyViolation, err)
+	}
+	return policy.Check(ctx, imageRef)
+}
+
+// Check evaluates the policy for imageRef; a nil policy allows every image.
+// It returns the reference to pull and extract: when signatures are required,
+// the digest they were verified for in the repository of imageRef, so a tag
+// pushed again since, or a stale image in the local store, is not used
+// unchecked; imageRef otherwise.
+func (p *ImagePolicy) Check(ctx context.Context, imageRef string) (string, error) {
+	if p == nil {
+		return imageRef, nil
+	}
+	ref, err := name.ParseReference(imageRef)
+	if err != nil {
+		return "", fmt.Errorf("%w: %w", ErrInvalidImageReference, err)
+	}
+	if err := p.checkReference(ref); err != nil {
+		return "", err
+	}
+
+	reqs := p.signatureRequirements(repositoryName(ref))
+	if len(reqs) == 0 {
+		return imageRef, nil
+	}
+	digest, err := imageDigest(ctx, ref)
+	if err != nil {
+		return "", fmt.Errorf("%w: %s: %w", ErrPolicyViolation, imageRef, err)
+	}
+	var cosignSignatures []cosignSignature
+	var verified []SignatureVerification
+	for _, req := range reqs {
+		var v *SignatureVerification
+		if req.Type == SignatureTypeSimpleSigning {
+			v, err = req.verifySimpleSigning(ctx, ref, digest)
+		} else {
+			if cosignSignatures == nil {
+				if cosignSignatures, err = fetchCosignSignatures(ctx, ref, digest); err != nil {
+					return "", fmt.Errorf("%w: %s: %w", ErrPolicyViolation, imageRef, err)
+				}
+			}
+			v, err = req.verify(ctx, ref, digest, cosignSignatures)
+		}
+		if err != nil {
+			return "", fmt.Errorf("%w: %s: %w", ErrPolicyViolation, imageRef, err)
+		}
+		verified = append(verified, *v)
+	}
+	recordResult(func(r *OperationResult) { r.Signatures = verified })
+	fmt.Printf("  Image policy: %s (%s) carries the required signatures\n", imageRef, digest)
+	for _, v := range verified {
+		if tlog := v.TransparencyLog; tlog != nil {
+			fmt.Printf("  Transparency log: entry %d, integrated %s (%s)\n", tlog.LogIndex, tlog.IntegratedTime.Format(time.RFC3339), tlog.Verification)
+		}
+	}
+	return ref.Context().Digest(digest).String(), nil
+}
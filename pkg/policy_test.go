@@ -0,0 +1,122 @@
+package pkg
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/name"
+)
+
+// setupPolicyPath points the image policy at content in a temporary
+// directory, or at a missing file if content is empty
+func setupPolicyPath(t *testing.T, content string) {
+	t.Helper()
+	old := PolicyPath
+	PolicyPath = filepath.Join(t.TempDir(), "policy.json")
+	t.Cleanup(func() { PolicyPath = old })
+	if content != "" {
+		writeFakeFile(t, PolicyPath, content)
+	}
+}
+
+func TestReadImagePolicy(t *testing.T) {
+	setupPolicyPath(t, "")
+	if policy, err := ReadImagePolicy(); err != nil || policy != nil {
+		t.Errorf("ReadImagePolicy() without a file = %+v, %v, want nil", policy, err)
+	}
+	if got, err := CheckImagePolicy(t.Context(), "quay.io/example/os:latest"); err != nil || got != "quay.io/example/os:latest" {
+		t.Errorf("CheckImagePolicy() without a policy = %q, %v, want the image", got, err)
+	}
+
+	for _, content := range []string{
+		`{"allowed_registries": [""]}`,
+		`{"signatures": [{"key_path": "/etc/phukit/keys/cosign.pub"}]}`,
+		`{"signatures": [{"scope": "quay.io"}]}`,
+		`{"signatures": [{"scope": "quay.io", "key_path": "/k.pub", "certificate_identity": "a@example.com"}]}`,
+		`{"signatures": [{"scope": "quay.io", "certificate_identity": "a@example.com"}]}`,
+		`{"allowed_registries": "quay.io"}`,
+	} {
+		setupPolicyPath(t, content)
+		if _, err := ReadImagePolicy(); err == nil {
+			t.Errorf("ReadImagePolicy(%s) succeeded, want error", content)
+		}
+		// An invalid policy rejects every image
+		if _, err := CheckImagePolicy(t.Context(), "quay.io/example/os:latest"); ErrorCode(err) != CodePolicyViolation {
+			t.Errorf("CheckImagePolicy() with policy %s error = %v, want %s", content, err, CodePolicyViolation)
+		}
+	}
+}
+
+func TestImagePolicyReference(t *testing.T) {
+	policy := &ImagePolicy{AllowedRegistries: []string{"quay.io/my-org", "docker.io/library/"}, RequireDigest: true}
+	digest := "@sha256:" + strings.Repeat("a", 64)
+
+	for imageRef, allowed := range map[string]bool{
+		"quay.io/my-org/os" + digest:          true,
+		"quay.io/my-org/team/os" + digest:     true,
+		"quay.io/my-org/os:latest":            false, // not by digest
+		"quay.io/my-organization/os" + digest: false,
+		"quay.io/other/os" + digest:           false,
+		"fedora" + digest:                     true,
+	} {
+		ref, err := name.ParseReference(imageRef)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = policy.checkReference(ref)
+		if allowed && err != nil {
+			t.Errorf("checkReference(%s) error = %v", imageRef, err)
+		} else if !allowed && ErrorCode(err) != CodePolicyViolation {
+			t.Errorf("checkReference(%s) error = %v, want %s", imageRef, err, CodePolicyViolation)
+		}
+	}
+}
+
+func TestSignatureRequirements(t *testing.T) {
+	policy := &ImagePolicy{Signatures: []SignatureRequirement{
+		{Scope: "quay.io", KeyPath: "/registry.pub"},
+		{Scope: "quay.io/my-org/", KeyPath: "/org.pub"},
+		{Scope: "quay.io/my-org", KeyPath: "/release.pub"},
+		{Scope: "quay.io/my-org/os/extra", KeyPath: "/other.pub"},
+	}}
+
+	var keys []string
+	for _, req := range policy.signatureRequirements("quay.io/my-org/os") {
+		keys = append(keys, req.KeyPath)
+	}
+	if strings.Join(keys, " ") != "/org.pub /release.pub" {
+		t.Errorf("signatureRequirements() = %v, want the most specific scope's /org.pub /release.pub", keys)
+	}
+	if got := policy.signatureRequirements("docker.io/library/fedora"); len(got) != 0 {
+		t.Errorf("signatureRequirements() outside every scope = %v, want none", got)
+	}
+}
+
+func TestCheckImagePolicySignatures(t *testing.T) {
+	imageRef, repo, digest := pushSignableImage(t)
+	signer := newTestSigner(t)
+	keyPath := signer.writePublicKey(t, t.TempDir())
+	policy := `{"signatures": [{"scope": "` + repo + `", "key_path": "` + keyPath + `"}]}`
+	setupPolicyPath(t, policy)
+
+	if _, err := CheckImagePolicy(t.Context(), imageRef); ErrorCode(err) != CodePolicyViolation {
+		t.Errorf("CheckImagePolicy() of an unsigned image error = %v, want %s", err, CodePolicyViolation)
+	}
+
+	pushTestSignatures(t, imageRef, signer.sign(t, repo, digest))
+	// The verified digest is extracted, not whatever the tag points at then
+	if got, err := CheckImagePolicy(t.Context(), imageRef); err != nil || got != repo+"@"+digest {
+		t.Errorf("CheckImagePolicy() of a signed image = %q, %v, want %s@%s", got, err, repo, digest)
+	}
+
+	// Extraction is refused before anything is written
+	target := t.TempDir()
+	setupPolicyPath(t, `{"allowed_registries": ["quay.io/my-org"]}`)
+	if err := NewContainerExtractor(imageRef, target).Extract(t.Context()); ErrorCode(err) != CodePolicyViolation {
+		t.Errorf("Extract() of a rejected image error = %v, want %s", err, CodePolicyViolation)
+	}
+	if entries, _ := filepath.Glob(filepath.Join(target, "*")); len(entries) != 0 {
+		t.Errorf("Extract() of a rejected image wrote %v", entries)
+	}
+}
@@ -0,0 +1,236 @@
+package pkg
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// EventSchemaVersion is the version of the JSON event format written by
+// JSONProgressReporter. It only changes when a field is removed or its meaning
+// changes; new fields and event types are added without a bump. See
+// docs/EVENTS.md.
+const EventSchemaVersion = 1
+
+// Event types emitted to a ProgressReporter
+const (
+	EventPhaseProgress = "phase_progress"
+	EventComplete      = "complete"
+)
+
+// Completion statuses reported in complete events
+const (
+	StatusSuccess   = "success"
+	StatusFailed    = "failed"
+	StatusTimeout   = "timeout"
+	StatusCancelled = "cancelled"
+)
+
+// Phases reported by long-running external tools
+const (
+	PhaseFormat     = "format"
+	PhaseInitramfs  = "initramfs"
+	PhaseBootloader = "bootloader"
+	PhaseFlash      = "flash"
+	PhaseVerify     = "verify"
+	PhaseMirror     = "mirror"
+	PhaseExport     = "export"
+	PhaseBackup     = "backup"
+	PhaseRestore    = "restore"
+)
+
+// ProgressEvent is a machine-readable progress update. The JSON encoding is a
+// stable interface for frontends, see EventSchemaVersion.
+type ProgressEvent struct {
+	// SchemaVersion is EventSchemaVersion, set on every event written as JSON
+	SchemaVersion int       `json:"schema_version"`
+	Type          string    `json:"type"`
+	Time          time.Time `json:"time"`
+	// Phase is the tool phase of phase_progress events and the operation
+	// (install, update, ...) of complete and result events
+	Phase   string `json:"phase"`
+	Current int    `json:"current,omitempty"` // Sub-steps completed so far
+	Total   int    `json:"total,omitempty"`   // Total sub-steps, 0 if unknown
+	Message string `json:"message,omitempty"`
+	Status  string `json:"status,omitempty"` // Set on complete and result events
+	Error   string `json:"error,omitempty"`
+	// ErrorCode is the machine-readable code of Error, see ErrorCode
+	ErrorCode string `json:"error_code,omitempty"`
+	// Result is the final result document, set on result events
+	Result *OperationResult `json:"result,omitempty"`
+}
+
+// ProgressReporter receives progress events
+type ProgressReporter interface {
+	Report(event ProgressEvent)
+}
+
+// progressReporter receives all events, nil disables reporting
+var progressReporter ProgressReporter
+
+// SetProgressReporter sets the reporter that receives progress events
+// Pass nil to disable progress reporting
+func SetProgressReporter(r ProgressReporter) {
+	progressReporter = r
+}
+
+// multiProgressReporter sends each event to several reporters
+type multiProgressReporter []ProgressReporter
+
+// NewMultiProgressReporter returns a reporter that sends events to every
+// non-nil reporter, or nil if there are none
+func NewMultiProgressReporter(reporters ...ProgressReporter) ProgressReporter {
+	var m multiProgressReporter
+	for _, r := range reporters {
+		if r != nil {
+			m = append(m, r)
+		}
+	}
+	switch len(m) {
+	case 0:
+		return nil
+	case 1:
+		return m[0]
+	}
+	return m
+}
+
+// Report implements ProgressReporter
+func (m multiProgressReporter) Report(event ProgressEvent) {
+	for _, r := range m {
+		r.Report(event)
+	}
+}
+
+// EndPhase forwards the end of a phase to reporters that track it
+func (m multiProgressReporter) EndPhase(phase string) {
+	for _, r := range m {
+		if e, ok := r.(phaseEnder); ok {
+			e.EndPhase(phase)
+		}
+	}
+}
+
+// reportPhaseProgress emits a phase_progress event if a reporter is set
+func reportPhaseProgress(phase string, current, total int, message string) {
+	if progressReporter == nil {
+		return
+	}
+	progressReporter.Report(ProgressEvent{
+		Type:    EventPhaseProgress,
+		Time:    time.Now(),
+		Phase:   phase,
+		Current: current,
+		Total:   total,
+		Message: message,
+	})
+}
+
+// CompletionStatus classifies the outcome of an operation run under ctx.
+// Commands killed by a deadline often fail with an unrelated error, so for
+// failures the context state takes precedence over err.
+func CompletionStatus(ctx context.Context, err error) string {
+	switch {
+	case err == nil:
+		return StatusSuccess
+	case errors.Is(ctx.Err(), context.DeadlineExceeded) || errors.Is(err, context.DeadlineExceeded):
+		return StatusTimeout
+	case errors.Is(ctx.Err(), context.Canceled) || errors.Is(err, context.Canceled):
+		return StatusCancelled
+	default:
+		return StatusFailed
+	}
+}
+
+// ReportComplete logs the outcome of operation and emits a complete event if
+// a reporter is set
+func ReportComplete(operation, status string, err error) {
+	if err != nil {
+		logger.Error(operation+" "+status, "status", status, "error", err, "error_code", ErrorCode(err))
+	} else {
+		logger.Info(operation+" "+status, "status", status)
+	}
+
+	if progressReporter == nil {
+		return
+	}
+	event := ProgressEvent{
+		Type:   EventComplete,
+		Time:   time.Now(),
+		Phase:  operation,
+		Status: status,
+	}
+	if err != nil {
+		event.Error = err.Error()
+		event.ErrorCode = ErrorCode(err)
+	}
+	progressReporter.Report(event)
+}
+
+// JSONProgressReporter writes each event as a single line of JSON
+type JSONProgressReporter struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+// NewJSONProgressReporter creates a reporter writing JSON lines to w
+func NewJSONProgressReporter(w io.Writer) *JSONProgressReporter {
+	return &JSONProgressReporter{enc: json.NewEncoder(w)}
+}
+
+// Report writes event to the underlying writer
+func (r *JSONProgressReporter) Report(event ProgressEvent) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	event.SchemaVersion = EventSchemaVersion
+	_ = r.enc.Encode(event)
+}
+
+// ErrUnsupportedSchema is returned by EventDecoder for events written with a
+// newer, incompatible schema version
+var ErrUnsupportedSchema = errors.New("unsupported event schema version")
+
+// EventDecoder reads the event stream written with --json-progress. Lines
+// that are not JSON objects, such as warnings printed to the same stderr,
+// are skipped.
+type EventDecoder struct {
+	scanner *bufio.Scanner
+}
+
+// NewEventDecoder creates a decoder reading events from r
+func NewEventDecoder(r io.Reader) *EventDecoder {
+	scanner := bufio.NewScanner(r)
+	// Result events carry the whole result document
+	scanner.Buffer(make([]byte, 0, 64*1024), 4*1024*1024)
+	return &EventDecoder{scanner: scanner}
+}
+
+// Decode returns the next event, or io.EOF at the end of the stream. Events
+// from phukit versions before schema versioning have SchemaVersion 0 and are
+// accepted.
+func (d *EventDecoder) Decode() (ProgressEvent, error) {
+	for d.scanner.Scan() {
+		line := bytes.TrimSpace(d.scanner.Bytes())
+		if len(line) == 0 || line[0] != '{' {
+			continue
+		}
+		var event ProgressEvent
+		if err := json.Unmarshal(line, &event); err != nil {
+			return ProgressEvent{}, fmt.Errorf("failed to decode event: %w", err)
+		}
+		if event.SchemaVersion > EventSchemaVersion {
+			return event, fmt.Errorf("%w: %d (supported: %d)", ErrUnsupportedSchema, event.SchemaVersion, EventSchemaVersion)
+		}
+		return event, nil
+	}
+	if err := d.scanner.Err(); err != nil {
+		return ProgressEvent{}, fmt.Errorf("failed to read events: %w", err)
+	}
+	return ProgressEvent{}, io.EOF
+}
@@ -0,0 +1,162 @@
+package pkg
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// DefaultProgressSocket is the suggested path for --progress-socket
+const DefaultProgressSocket = "/run/phukit/progress.sock"
+
+// progressSocketWriteTimeout is how long a client may block an event before
+// it is disconnected, so a stuck frontend cannot stall an install
+const progressSocketWriteTimeout = time.Second
+
+// SocketProgressReporter serves the JSON event stream on a unix socket. Every
+// client receives the events sent so far when it connects, then each new
+// event as it happens. The stream ends when the reporter is closed.
+type SocketProgressReporter struct {
+	path     string
+	listener *net.UnixListener
+
+	mu      sync.Mutex
+	clients map[net.Conn]struct{}
+	history [][]byte // Encoded events, replayed to new clients
+	closed  bool
+	done    chan struct{} // Closed when the accept loop exits
+}
+
+// ListenProgressSocket creates the socket at path, readable and writable by
+// its owner and group, and starts accepting clients. A stale socket left by a
+// crashed run is replaced; a socket another process is serving is not.
+func ListenProgressSocket(path string) (*SocketProgressReporter, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create progress socket directory: %w", err)
+	}
+	if info, err := os.Lstat(path); err == nil {
+		if info.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("progress socket %s exists and is not a socket", path)
+		}
+		if conn, err := net.Dial("unix", path); err == nil {
+			_ = conn.Close()
+			return nil, fmt.Errorf("progress socket %s is in use by another process", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, fmt.Errorf("failed to remove stale progress socket: %w", err)
+		}
+	}
+
+	listener, err := net.ListenUnix("unix", &net.UnixAddr{Name: path, Net: "unix"})
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on progress socket: %w", err)
+	}
+	if err := os.Chmod(path, 0660); err != nil {
+		_ = listener.Close()
+		return nil, fmt.Errorf("failed to set progress socket permissions: %w", err)
+	}
+
+	s := &SocketProgressReporter{
+		path:     path,
+		listener: listener,
+		clients:  map[net.Conn]struct{}{},
+		done:     make(chan struct{}),
+	}
+	go s.accept()
+	return s, nil
+}
+
+// Path returns the socket path
+func (s *SocketProgressReporter) Path() string {
+	return s.path
+}
+
+// accept adds clients until the listener is closed
+func (s *SocketProgressReporter) accept() {
+	defer close(s.done)
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			_ = conn.Close()
+			return
+		}
+		ok := true
+		for _, line := range s.history {
+			if !s.write(conn, line) {
+				ok = false
+				break
+			}
+		}
+		if ok {
+			s.clients[conn] = struct{}{}
+		}
+		s.mu.Unlock()
+	}
+}
+
+// write sends one encoded event to conn, closing it on failure. Must be
+// called with s.mu held.
+func (s *SocketProgressReporter) write(conn net.Conn, line []byte) bool {
+	_ = conn.SetWriteDeadline(time.Now().Add(progressSocketWriteTimeout))
+	if _, err := conn.Write(line); err != nil {
+		_ = conn.Close()
+		return false
+	}
+	return true
+}
+
+// Report implements ProgressReporter
+func (s *SocketProgressReporter) Report(event ProgressEvent) {
+	event.SchemaVersion = EventSchemaVersion
+	data, err := json.Marshal(event)
+	if err != nil {
+		return
+	}
+	line := append(data, '\n')
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return
+	}
+	s.history = append(s.history, line)
+	for conn := range s.clients {
+		if !s.write(conn, line) {
+			delete(s.clients, conn)
+		}
+	}
+}
+
+// Close stops accepting clients, ends the stream for connected clients and
+// removes the socket
+func (s *SocketProgressReporter) Close() error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+	s.closed = true
+	err := s.listener.Close()
+	for conn := range s.clients {
+		_ = conn.Close()
+	}
+	s.clients = nil
+	s.mu.Unlock()
+
+	<-s.done
+	if rerr := os.Remove(s.path); rerr != nil && !errors.Is(rerr, os.ErrNotExist) && err == nil {
+		err = rerr
+	}
+	return err
+}
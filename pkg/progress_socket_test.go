@@ -0,0 +1,135 @@
+package pkg
+
+import (
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// readEvents decodes events from conn until the stream ends
+func readEvents(t *testing.T, conn net.Conn) []ProgressEvent {
+	t.Helper()
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	d := NewEventDecoder(conn)
+	var events []ProgressEvent
+	for {
+		event, err := d.Decode()
+		if err == io.EOF {
+			return events
+		}
+		if err != nil {
+			t.Fatalf("Decode() error = %v", err)
+		}
+		events = append(events, event)
+	}
+}
+
+// waitForClients waits until the reporter has accepted n clients
+func waitForClients(t *testing.T, s *SocketProgressReporter, n int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		s.mu.Lock()
+		count := len(s.clients)
+		s.mu.Unlock()
+		if count == n {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("timed out waiting for %d clients", n)
+}
+
+func TestSocketProgressReporter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "run", "progress.sock")
+	s, err := ListenProgressSocket(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	early, err := net.Dial("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = early.Close() }()
+	waitForClients(t, s, 1)
+
+	s.Report(ProgressEvent{Type: EventPhaseProgress, Phase: PhaseFormat, Current: 1, Total: 2})
+
+	// A client connecting later gets the events it missed
+	late, err := net.Dial("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = late.Close() }()
+	waitForClients(t, s, 2)
+
+	s.Report(ProgressEvent{Type: EventComplete, Phase: "install", Status: StatusSuccess})
+	if err := s.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	for name, conn := range map[string]net.Conn{"early": early, "late": late} {
+		events := readEvents(t, conn)
+		if len(events) != 2 {
+			t.Fatalf("%s client got %d events, want 2: %+v", name, len(events), events)
+		}
+		if events[0].Phase != PhaseFormat || events[1].Type != EventComplete || events[1].SchemaVersion != EventSchemaVersion {
+			t.Errorf("%s client got %+v", name, events)
+		}
+	}
+
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("socket not removed after Close: %v", err)
+	}
+}
+
+func TestListenProgressSocketStale(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "progress.sock")
+
+	first, err := ListenProgressSocket(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ListenProgressSocket(path); err == nil {
+		t.Error("expected an error for a socket in use")
+	}
+	_ = first.Close()
+
+	// Leave a socket file behind as a crashed run would
+	l, err := net.ListenUnix("unix", &net.UnixAddr{Name: path, Net: "unix"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.SetUnlinkOnClose(false)
+	_ = l.Close()
+
+	s, err := ListenProgressSocket(path)
+	if err != nil {
+		t.Fatalf("stale socket not replaced: %v", err)
+	}
+	_ = s.Close()
+}
+
+func TestMultiProgressReporter(t *testing.T) {
+	if NewMultiProgressReporter(nil, nil) != nil {
+		t.Error("expected nil for no reporters")
+	}
+	a, b := &recordingReporter{}, &recordingReporter{}
+	if r := NewMultiProgressReporter(nil, a); r != a {
+		t.Error("a single reporter should be returned as is")
+	}
+
+	SetProgressReporter(NewMultiProgressReporter(a, NewTerminalProgressReporter(io.Discard, false), b))
+	t.Cleanup(func() { SetProgressReporter(nil) })
+	if !terminalProgress() {
+		t.Error("terminalProgress() = false with a terminal reporter in the set")
+	}
+	reportPhaseProgress(PhaseVerify, 1, 2, "")
+	if len(a.events) != 1 || len(b.events) != 1 {
+		t.Errorf("events not sent to all reporters: %d, %d", len(a.events), len(b.events))
+	}
+}
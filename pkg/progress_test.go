@@ -0,0 +1,113 @@
+package pkg
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCompletionStatus(t *testing.T) {
+	expired, cancelExpired := context.WithTimeout(context.Background(), time.Nanosecond)
+	defer cancelExpired()
+	<-expired.Done()
+
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	failure := errors.New("signal: killed")
+
+	tests := []struct {
+		name string
+		ctx  context.Context
+		err  error
+		want string
+	}{
+		{"success", context.Background(), nil, StatusSuccess},
+		{"success despite expired deadline", expired, nil, StatusSuccess},
+		{"failure", context.Background(), failure, StatusFailed},
+		{"killed by deadline", expired, failure, StatusTimeout},
+		{"wrapped deadline", context.Background(), context.DeadlineExceeded, StatusTimeout},
+		{"cancelled", cancelled, failure, StatusCancelled},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := CompletionStatus(tt.ctx, tt.err); got != tt.want {
+				t.Errorf("CompletionStatus() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReportComplete(t *testing.T) {
+	var buf bytes.Buffer
+	SetProgressReporter(NewJSONProgressReporter(&buf))
+	t.Cleanup(func() { SetProgressReporter(nil) })
+
+	ReportComplete("install", StatusTimeout, context.DeadlineExceeded)
+
+	var event ProgressEvent
+	if err := json.Unmarshal(buf.Bytes(), &event); err != nil {
+		t.Fatalf("invalid event %q: %v", buf.String(), err)
+	}
+	if event.Type != EventComplete || event.Phase != "install" || event.Status != StatusTimeout {
+		t.Errorf("unexpected event: %+v", event)
+	}
+	if event.Error != context.DeadlineExceeded.Error() {
+		t.Errorf("Error = %q, want %q", event.Error, context.DeadlineExceeded.Error())
+	}
+	if event.ErrorCode != CodeTimeout {
+		t.Errorf("ErrorCode = %q, want %q", event.ErrorCode, CodeTimeout)
+	}
+}
+
+func TestEventDecoder(t *testing.T) {
+	var buf bytes.Buffer
+	SetProgressReporter(NewJSONProgressReporter(&buf))
+	t.Cleanup(func() { SetProgressReporter(nil) })
+
+	reportPhaseProgress(PhaseFormat, 1, 4, "Writing inode tables")
+	buf.WriteString("Warning: could not get UUID for /dev/sda3\n\n")
+	ReportComplete("install", StatusSuccess, nil)
+
+	d := NewEventDecoder(&buf)
+	first, err := d.Decode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.SchemaVersion != EventSchemaVersion || first.Type != EventPhaseProgress || first.Total != 4 {
+		t.Errorf("unexpected first event: %+v", first)
+	}
+	second, err := d.Decode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if second.Type != EventComplete || second.Status != StatusSuccess {
+		t.Errorf("unexpected second event: %+v", second)
+	}
+	if _, err := d.Decode(); err != io.EOF {
+		t.Errorf("Decode() at end = %v, want io.EOF", err)
+	}
+}
+
+func TestEventDecoderSchemaVersion(t *testing.T) {
+	stream := `{"type":"complete","phase":"install","status":"success"}
+{"schema_version":99,"type":"complete","phase":"update"}
+{"schema_version":1,"type":"complete"
+`
+	d := NewEventDecoder(strings.NewReader(stream))
+	if event, err := d.Decode(); err != nil || event.SchemaVersion != 0 {
+		t.Errorf("unversioned event = %+v, %v; want accepted", event, err)
+	}
+	if _, err := d.Decode(); !errors.Is(err, ErrUnsupportedSchema) {
+		t.Errorf("newer schema error = %v, want ErrUnsupportedSchema", err)
+	}
+	if _, err := d.Decode(); err == nil || err == io.EOF {
+		t.Errorf("truncated event error = %v, want decode error", err)
+	}
+}
@@ -0,0 +1,605 @@
+package pkg
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// userNamePattern matches the user names useradd accepts by default
+var userNamePattern = regexp.MustCompile(`^[a-z_][a-z0-9_.-]*$`)
+
+// hostnameLabelPattern matches one dot-separated label of a hostname
+var hostnameLabelPattern = regexp.MustCompile(`^[a-zA-Z0-9]([a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?$`)
+
+// localePattern matches locale names such as en_US.UTF-8, de_DE@euro or C.UTF-8
+var localePattern = regexp.MustCompile(`^([a-z]{2,3}(_[A-Z]{2})?|C|POSIX)(\.[A-Za-z0-9-]+)?(@[a-z]+)?$`)
+
+// sshKeyUserPattern matches the "user:" prefix of an SSH key spec
+var sshKeyUserPattern = regexp.MustCompile(`^([a-z_][a-z0-9_.-]*):(.*)$`)
+
+// User is an account created on the installed system, or updated if the
+// image already has it
+type User struct {
+	Name         string
+	Groups       []string // Supplementary groups, which must exist in the image
+	PasswordHash string   // crypt(3) hash; empty leaves the password unset
+	SSHKeys      []string // Lines for ~/.ssh/authorized_keys
+}
+
+// NetworkConnection is a NetworkManager connection written to the installed
+// system
+type NetworkConnection struct {
+	Name      string   // Connection ID and keyfile name
+	Interface string   // Interface to bind to, or any ethernet interface if empty
+	Addresses []string // Static IPv4 addresses in CIDR notation; DHCP if empty
+	Gateway   string
+	DNS       []string
+}
+
+// File is a file, directory or symlink written to the installed system
+type File struct {
+	Path      string
+	Mode      os.FileMode // Permissions; 0644 for files and 0755 for directories if zero
+	Contents  []byte
+	Target    string // Symlink target; the file is a link if set
+	Dir       bool
+	Overwrite bool   // Replace an existing file instead of failing
+	Owner     string // User name or ID, root if empty
+	Group     string // Group name or ID, the owner's group if empty
+}
+
+// Unit is a systemd unit written or configured on the installed system
+type Unit struct {
+	Name     string
+	Contents string       // Unit file for /etc/systemd/system; the image's unit if empty
+	Dropins  []UnitDropin // Drop-in files for /etc/systemd/system/<name>.d
+	Enabled  *bool        // Enable or disable the unit; left alone if nil
+	Mask     bool
+}
+
+// UnitDropin is a drop-in file of a systemd unit
+type UnitDropin struct {
+	Name     string
+	Contents string
+}
+
+// Provisioning is host-specific configuration written to the new root at
+// install time, so the machine is reachable without console access
+type Provisioning struct {
+	Hostname    string
+	Timezone    string // Name under /usr/share/zoneinfo, such as Europe/Berlin
+	Locale      string // LANG for /etc/locale.conf, such as en_US.UTF-8
+	Users       []User
+	Connections []NetworkConnection
+	Files       []File
+	Units       []Unit
+}
+
+// Merge adds the configuration in o. Users listed in both are combined, and
+// o's hostname and password hashes win.
+func (p *Provisioning) Merge(o *Provisioning) {
+	if o.Hostname != "" {
+		p.Hostname = o.Hostname
+	}
+	if o.Timezone != "" {
+		p.Timezone = o.Timezone
+	}
+	if o.Locale != "" {
+		p.Locale = o.Locale
+	}
+	for _, ou := range o.Users {
+		u := p.User(ou.Name)
+		u.Groups = append(u.Groups, ou.Groups...)
+		u.SSHKeys = append(u.SSHKeys, ou.SSHKeys...)
+		if ou.PasswordHash != "" {
+			u.PasswordHash = ou.PasswordHash
+		}
+	}
+	p.Connections = append(p.Connections, o.Connections...)
+	p.Files = append(p.Files, o.Files...)
+	p.Units = append(p.Units, o.Units...)
+}
+
+// User returns the user named name, adding it if it is not listed yet
+func (p *Provisioning) User(name string) *User {
+	for i := range p.Users {
+		if p.Users[i].Name == name {
+			return &p.Users[i]
+		}
+	}
+	p.Users = append(p.Users, User{Name: name})
+	return &p.Users[len(p.Users)-1]
+}
+
+// SetHostname sets the static hostname after checking it is a valid DNS
+// name of at most 64 characters
+func (p *Provisioning) SetHostname(hostname string) error {
+	if len(hostname) > 64 {
+		return fmt.Errorf("invalid hostname %q: longer than 64 characters", hostname)
+	}
+	for _, label := range strings.Split(hostname, ".") {
+		if !hostnameLabelPattern.MatchString(label) {
+			return fmt.Errorf("invalid hostname %q", hostname)
+		}
+	}
+	p.Hostname = hostname
+	return nil
+}
+
+// SetTimezone sets the timezone, a name under /usr/share/zoneinfo. Whether
+// the image has it is checked at install time.
+func (p *Provisioning) SetTimezone(tz string) error {
+	if tz == "" || strings.HasPrefix(tz, "/") || slices.Contains(strings.Split(tz, "/"), "..") {
+		return fmt.Errorf("invalid timezone %q (use a name such as Europe/Berlin or UTC)", tz)
+	}
+	p.Timezone = tz
+	return nil
+}
+
+// SetLocale sets the system locale written to /etc/locale.conf
+func (p *Provisioning) SetLocale(locale string) error {
+	if !localePattern.MatchString(locale) {
+		return fmt.Errorf("invalid locale %q (use a name such as en_US.UTF-8)", locale)
+	}
+	p.Locale = locale
+	return nil
+}
+
+// AddUserSpec adds a user given as "name" or "name:group,group"
+func (p *Provisioning) AddUserSpec(spec string) error {
+	name, groups, _ := strings.Cut(spec, ":")
+	if !userNamePattern.MatchString(name) {
+		return fmt.Errorf("invalid user name %q", name)
+	}
+	u := p.User(name)
+	for _, g := range strings.Split(groups, ",") {
+		if g = strings.TrimSpace(g); g != "" {
+			u.Groups = append(u.Groups, g)
+		}
+	}
+	return nil
+}
+
+// SetPasswordHash sets the crypt(3) password hash of user
+func (p *Provisioning) SetPasswordHash(user, hash string) error {
+	if !strings.HasPrefix(hash, "$") {
+		return fmt.Errorf("password for %s must be a crypt(3) hash, such as from 'openssl passwd -6'", user)
+	}
+	p.User(user).PasswordHash = hash
+	return nil
+}
+
+// AddSSHKeySpec adds SSH keys given as "[user:]key" or "[user:]file", where
+// file holds one key per line. Keys without a user are for root.
+func (p *Provisioning) AddSSHKeySpec(spec string) error {
+	user, key := "root", spec
+	if m := sshKeyUserPattern.FindStringSubmatch(spec); m != nil {
+		user, key = m[1], m[2]
+	}
+
+	var keys []string
+	if data, err := os.ReadFile(key); err == nil {
+		for _, line := range strings.Split(string(data), "\n") {
+			if line = strings.TrimSpace(line); line != "" && !strings.HasPrefix(line, "#") {
+				keys = append(keys, line)
+			}
+		}
+		if len(keys) == 0 {
+			return fmt.Errorf("no SSH keys in %s", key)
+		}
+	} else if len(strings.Fields(key)) >= 2 {
+		keys = []string{strings.TrimSpace(key)}
+	} else {
+		return fmt.Errorf("%q is neither an SSH public key nor a readable key file", key)
+	}
+	u := p.User(user)
+	u.SSHKeys = append(u.SSHKeys, keys...)
+	return nil
+}
+
+// Empty reports whether there is nothing to provision
+func (p *Provisioning) Empty() bool {
+	return p == nil || (p.Hostname == "" && p.Timezone == "" && p.Locale == "" && len(p.Users) == 0 && len(p.Connections) == 0 && len(p.Files) == 0 && len(p.Units) == 0)
+}
+
+// apply writes the provisioning to the root at root
+func (p *Provisioning) apply(ctx context.Context, root string, dryRun bool) error {
+	if p.Empty() {
+		return nil
+	}
+	if dryRun {
+		fmt.Printf("[DRY RUN] Would provision %d users, %d network connections, %d files and %d units\n", len(p.Users), len(p.Connections), len(p.Files), len(p.Units))
+		return nil
+	}
+
+	fmt.Println("  Provisioning host configuration...")
+	if p.Hostname != "" {
+		if err := os.WriteFile(filepath.Join(root, "etc", "hostname"), []byte(p.Hostname+"\n"), 0644); err != nil {
+			return fmt.Errorf("failed to write hostname: %w", err)
+		}
+		fmt.Printf("  Set hostname to %s\n", p.Hostname)
+	}
+	if p.Timezone != "" {
+		if err := writeTimezone(root, p.Timezone); err != nil {
+			return err
+		}
+	}
+	if p.Locale != "" {
+		if err := os.WriteFile(filepath.Join(root, "etc", "locale.conf"), []byte("LANG="+p.Locale+"\n"), 0644); err != nil {
+			return fmt.Errorf("failed to write locale.conf: %w", err)
+		}
+		fmt.Printf("  Set locale to %s\n", p.Locale)
+	}
+	for _, u := range p.Users {
+		if err := provisionUser(ctx, root, u); err != nil {
+			return err
+		}
+	}
+	for _, f := range p.Files {
+		if err := writeProvisionedFile(root, f); err != nil {
+			return err
+		}
+	}
+	for _, u := range p.Units {
+		if err := configureUnit(ctx, root, u); err != nil {
+			return err
+		}
+	}
+	for _, c := range p.Connections {
+		if err := writeConnection(root, c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeTimezone points /etc/localtime of the root at the zoneinfo file of tz,
+// which the image must ship
+func writeTimezone(root, tz string) error {
+	if _, err := os.Stat(filepath.Join(root, "usr", "share", "zoneinfo", tz)); err != nil {
+		return fmt.Errorf("timezone %s is not in the image (is tzdata installed?)", tz)
+	}
+	localtime := filepath.Join(root, "etc", "localtime")
+	if err := os.Remove(localtime); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to replace /etc/localtime: %w", err)
+	}
+	if err := os.Symlink(filepath.Join("..", "usr", "share", "zoneinfo", tz), localtime); err != nil {
+		return fmt.Errorf("failed to set timezone: %w", err)
+	}
+	fmt.Printf("  Set timezone to %s\n", tz)
+	return nil
+}
+
+// targetUser is an entry of the installed system's passwd database
+type targetUser struct {
+	UID, GID int
+	Home     string
+}
+
+// lookupTargetUser finds name in the root's /etc/passwd, or in
+// /usr/lib/passwd for images using nss-altfiles
+func lookupTargetUser(root, name string) (targetUser, bool) {
+	for _, file := range []string{"etc/passwd", "usr/lib/passwd"} {
+		data, err := os.ReadFile(filepath.Join(root, file))
+		if err != nil {
+			continue
+		}
+		scanner := bufio.NewScanner(bytes.NewReader(data))
+		for scanner.Scan() {
+			fields := strings.Split(scanner.Text(), ":")
+			if len(fields) < 7 || fields[0] != name {
+				continue
+			}
+			uid, uerr := strconv.Atoi(fields[2])
+			gid, gerr := strconv.Atoi(fields[3])
+			if uerr != nil || gerr != nil {
+				continue
+			}
+			return targetUser{UID: uid, GID: gid, Home: fields[5]}, true
+		}
+	}
+	return targetUser{}, false
+}
+
+// provisionUser creates u in the root, or adds it to its groups if the image
+// has it, and sets its password and SSH keys
+func provisionUser(ctx context.Context, root string, u User) error {
+	if _, exists := lookupTargetUser(root, u.Name); exists {
+		if len(u.Groups) > 0 {
+			if out, err := runCommand(ctx, "chroot", root, "usermod", "-a", "-G", strings.Join(u.Groups, ","), u.Name); err != nil {
+				return fmt.Errorf("failed to add user %s to groups: %w\nOutput: %s", u.Name, err, strings.TrimSpace(string(out)))
+			}
+		}
+	} else {
+		args := []string{root, "useradd", "-m"}
+		if len(u.Groups) > 0 {
+			args = append(args, "-G", strings.Join(u.Groups, ","))
+		}
+		if out, err := runCommand(ctx, "chroot", append(args, u.Name)...); err != nil {
+			return fmt.Errorf("failed to create user %s: %w\nOutput: %s", u.Name, err, strings.TrimSpace(string(out)))
+		}
+		fmt.Printf("  Created user %s\n", u.Name)
+	}
+
+	if u.PasswordHash != "" {
+		// The hash goes through stdin to keep it out of the process list
+		var out bytes.Buffer
+		err := executor.Run(ctx, Command{
+			Name:   "chroot",
+			Args:   []string{root, "chpasswd", "-e"},
+			Stdin:  strings.NewReader(u.Name + ":" + u.PasswordHash + "\n"),
+			Stdout: &out,
+			Stderr: &out,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to set password of %s: %w\nOutput: %s", u.Name, err, strings.TrimSpace(out.String()))
+		}
+	}
+
+	if len(u.SSHKeys) > 0 {
+		return authorizeSSHKeys(root, u.Name, u.SSHKeys)
+	}
+	return nil
+}
+
+// authorizeSSHKeys appends keys to the authorized_keys of user in the root
+func authorizeSSHKeys(root, user string, keys []string) error {
+	tu, ok := lookupTargetUser(root, user)
+	if !ok {
+		return fmt.Errorf("failed to add SSH keys: user %s not found in the image", user)
+	}
+	home, err := resolveInRoot(root, tu.Home)
+	if err != nil {
+		return fmt.Errorf("failed to find home directory of %s: %w", user, err)
+	}
+	sshDir := filepath.Join(home, ".ssh")
+	if err := os.MkdirAll(sshDir, 0700); err != nil {
+		return fmt.Errorf("failed to create %s: %w", sshDir, err)
+	}
+
+	path := filepath.Join(sshDir, "authorized_keys")
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open authorized_keys of %s: %w", user, err)
+	}
+	_, err = f.WriteString(strings.Join(keys, "\n") + "\n")
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write authorized_keys of %s: %w", user, err)
+	}
+
+	for _, p := range []string{home, sshDir, path} {
+		if err := os.Lchown(p, tu.UID, tu.GID); err != nil {
+			return fmt.Errorf("failed to set owner of %s: %w", p, err)
+		}
+	}
+	fmt.Printf("  Added %d SSH keys for %s\n", len(keys), user)
+	return nil
+}
+
+// resolveInRoot returns the host path of path inside the root at root,
+// following symlinks as if root were /. bootc images link /home and /root
+// into /var with links that would escape a mounted root.
+func resolveInRoot(root, path string) (string, error) {
+	parts := strings.Split(strings.Trim(filepath.Clean("/"+path), "/"), "/")
+	cur := "/"
+	for links := 0; len(parts) > 0; {
+		part := parts[0]
+		parts = parts[1:]
+		if part == "" || part == "." {
+			continue
+		}
+		if part == ".." {
+			cur = filepath.Dir(cur)
+			continue
+		}
+		next := filepath.Join(cur, part)
+		target, err := os.Readlink(filepath.Join(root, next))
+		if err != nil {
+			// Not a symlink, or not created yet
+			cur = next
+			continue
+		}
+		if links++; links > 40 {
+			return "", fmt.Errorf("too many levels of symbolic links in %s", path)
+		}
+		if !filepath.IsAbs(target) {
+			target = filepath.Join(cur, target)
+		}
+		parts = append(strings.Split(strings.Trim(filepath.Clean(target), "/"), "/"), parts...)
+		cur = "/"
+	}
+	return filepath.Join(root, cur), nil
+}
+
+// lookupTargetGroup finds the ID of group name in the root's /etc/group, or
+// in /usr/lib/group for images using nss-altfiles
+func lookupTargetGroup(root, name string) (int, bool) {
+	for _, file := range []string{"etc/group", "usr/lib/group"} {
+		data, err := os.ReadFile(filepath.Join(root, file))
+		if err != nil {
+			continue
+		}
+		scanner := bufio.NewScanner(bytes.NewReader(data))
+		for scanner.Scan() {
+			fields := strings.Split(scanner.Text(), ":")
+			if len(fields) < 3 || fields[0] != name {
+				continue
+			}
+			if gid, err := strconv.Atoi(fields[2]); err == nil {
+				return gid, true
+			}
+		}
+	}
+	return 0, false
+}
+
+// fileOwner returns the user and group IDs of f in the root
+func fileOwner(root string, f File) (int, int, error) {
+	uid, gid := 0, 0
+	if f.Owner != "" {
+		if id, err := strconv.Atoi(f.Owner); err == nil {
+			uid = id
+		} else if u, ok := lookupTargetUser(root, f.Owner); ok {
+			uid, gid = u.UID, u.GID
+		} else {
+			return 0, 0, fmt.Errorf("user %s of %s not found in the image", f.Owner, f.Path)
+		}
+	}
+	if f.Group != "" {
+		if id, err := strconv.Atoi(f.Group); err == nil {
+			gid = id
+		} else if id, ok := lookupTargetGroup(root, f.Group); ok {
+			gid = id
+		} else {
+			return 0, 0, fmt.Errorf("group %s of %s not found in the image", f.Group, f.Path)
+		}
+	}
+	return uid, gid, nil
+}
+
+// writeProvisionedFile writes f into the root
+func writeProvisionedFile(root string, f File) error {
+	if !filepath.IsAbs(f.Path) {
+		return fmt.Errorf("file path %s is not absolute", f.Path)
+	}
+	path, err := resolveInRoot(root, filepath.Dir(f.Path))
+	if err != nil {
+		return fmt.Errorf("failed to resolve %s: %w", f.Path, err)
+	}
+	path = filepath.Join(path, filepath.Base(f.Path))
+	uid, gid, err := fileOwner(root, f)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create directory of %s: %w", f.Path, err)
+	}
+
+	if info, err := os.Lstat(path); err == nil {
+		switch {
+		case f.Dir && info.IsDir():
+			// Existing directories only get the mode and owner
+		case !f.Overwrite:
+			return fmt.Errorf("%s already exists in the image (set overwrite to replace it)", f.Path)
+		default:
+			if err := os.RemoveAll(path); err != nil {
+				return fmt.Errorf("failed to replace %s: %w", f.Path, err)
+			}
+		}
+	}
+
+	mode := f.Mode
+	switch {
+	case f.Target != "":
+		err = os.Symlink(f.Target, path)
+	case f.Dir:
+		if mode == 0 {
+			mode = 0755
+		}
+		if err = os.MkdirAll(path, mode); err == nil {
+			err = os.Chmod(path, mode)
+		}
+	default:
+		if mode == 0 {
+			mode = 0644
+		}
+		if err = os.WriteFile(path, f.Contents, mode); err == nil {
+			// WriteFile applies the umask
+			err = os.Chmod(path, mode)
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write %s: %w", f.Path, err)
+	}
+	if err := os.Lchown(path, uid, gid); err != nil {
+		return fmt.Errorf("failed to set owner of %s: %w", f.Path, err)
+	}
+	return nil
+}
+
+// configureUnit writes u's unit file and drop-ins into the root and enables,
+// disables or masks it
+func configureUnit(ctx context.Context, root string, u Unit) error {
+	dir := filepath.Join(root, "etc", "systemd", "system")
+	if u.Contents != "" {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return fmt.Errorf("failed to create %s: %w", dir, err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, u.Name), []byte(u.Contents), 0644); err != nil {
+			return fmt.Errorf("failed to write unit %s: %w", u.Name, err)
+		}
+	}
+	for _, d := range u.Dropins {
+		dropinDir := filepath.Join(dir, u.Name+".d")
+		if err := os.MkdirAll(dropinDir, 0755); err != nil {
+			return fmt.Errorf("failed to create %s: %w", dropinDir, err)
+		}
+		if err := os.WriteFile(filepath.Join(dropinDir, d.Name), []byte(d.Contents), 0644); err != nil {
+			return fmt.Errorf("failed to write drop-in %s of %s: %w", d.Name, u.Name, err)
+		}
+	}
+
+	switch {
+	case u.Mask:
+		return systemctlInRoot(ctx, root, "mask", u.Name)
+	case u.Enabled == nil:
+		return nil
+	case *u.Enabled:
+		return systemctlInRoot(ctx, root, "enable", u.Name)
+	default:
+		return systemctlInRoot(ctx, root, "disable", u.Name)
+	}
+}
+
+// connectionKeyfile returns the NetworkManager keyfile of c
+func connectionKeyfile(c NetworkConnection) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "[connection]\nid=%s\ntype=ethernet\n", c.Name)
+	if c.Interface != "" {
+		fmt.Fprintf(&b, "interface-name=%s\n", c.Interface)
+	}
+	b.WriteString("autoconnect=true\n\n[ipv4]\n")
+	if len(c.Addresses) == 0 {
+		b.WriteString("method=auto\n")
+	} else {
+		b.WriteString("method=manual\n")
+		for i, addr := range c.Addresses {
+			fmt.Fprintf(&b, "address%d=%s", i+1, addr)
+			if i == 0 && c.Gateway != "" {
+				fmt.Fprintf(&b, ",%s", c.Gateway)
+			}
+			b.WriteString("\n")
+		}
+	}
+	if len(c.DNS) > 0 {
+		fmt.Fprintf(&b, "dns=%s;\n", strings.Join(c.DNS, ";"))
+	}
+	b.WriteString("\n[ipv6]\nmethod=auto\n")
+	return b.String()
+}
+
+// writeConnection writes c as a NetworkManager keyfile in the root
+func writeConnection(root string, c NetworkConnection) error {
+	dir := filepath.Join(root, "etc", "NetworkManager", "system-connections")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create %s: %w", dir, err)
+	}
+	// NetworkManager ignores keyfiles other users can read
+	path := filepath.Join(dir, c.Name+".nmconnection")
+	if err := os.WriteFile(path, []byte(connectionKeyfile(c)), 0600); err != nil {
+		return fmt.Errorf("failed to write network connection %s: %w", c.Name, err)
+	}
+	fmt.Printf("  Added network connection %s\n", c.Name)
+	return nil
+}
@@ -0,0 +1,273 @@
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestResolveInRoot(t *testing.T) {
+	root := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(root, "var", "home"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("var/home", filepath.Join(root, "home")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("/var/roothome", filepath.Join(root, "root")); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string]string{
+		"/home/alice":    "var/home/alice",
+		"/root":          "var/roothome",
+		"/root/.ssh":     "var/roothome/.ssh",
+		"/etc/../home/x": "var/home/x",
+		"/usr/bin":       "usr/bin",
+	}
+	for path, want := range tests {
+		got, err := resolveInRoot(root, path)
+		if err != nil {
+			t.Errorf("resolveInRoot(%q) error = %v", path, err)
+			continue
+		}
+		if got != filepath.Join(root, want) {
+			t.Errorf("resolveInRoot(%q) = %q, want %q", path, got, filepath.Join(root, want))
+		}
+	}
+
+	if err := os.Symlink("loop", filepath.Join(root, "loop")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := resolveInRoot(root, "/loop/x"); err == nil {
+		t.Error("resolveInRoot() expected error for a symlink loop")
+	}
+}
+
+func TestConnectionKeyfile(t *testing.T) {
+	got := connectionKeyfile(NetworkConnection{
+		Name:      "eth0",
+		Interface: "eth0",
+		Addresses: []string{"10.0.0.5/24"},
+		Gateway:   "10.0.0.1",
+		DNS:       []string{"10.0.0.1", "1.1.1.1"},
+	})
+	for _, want := range []string{"id=eth0\n", "interface-name=eth0\n", "method=manual\n", "address1=10.0.0.5/24,10.0.0.1\n", "dns=10.0.0.1;1.1.1.1;\n"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("keyfile missing %q:\n%s", want, got)
+		}
+	}
+	if dhcp := connectionKeyfile(NetworkConnection{Name: "any"}); !strings.Contains(dhcp, "[ipv4]\nmethod=auto\n") || strings.Contains(dhcp, "interface-name") {
+		t.Errorf("DHCP keyfile:\n%s", dhcp)
+	}
+}
+
+func TestProvisioningApply(t *testing.T) {
+	fake, _ := setupDryRunExecutor(t)
+
+	root := t.TempDir()
+	writeFakeFile(t, filepath.Join(root, "etc", "passwd"), fmt.Sprintf("root:x:0:0:root:/root:/bin/bash\nadmin:x:%d:%d::/var/home/admin:/bin/bash\n", os.Getuid(), os.Getgid()))
+
+	p := &Provisioning{Hostname: "web1"}
+	admin := p.User("admin")
+	admin.Groups = []string{"wheel"}
+	admin.PasswordHash = "$6$salt$hash"
+	admin.SSHKeys = []string{"ssh-ed25519 AAAA admin@example"}
+	p.Connections = []NetworkConnection{{Name: "eth0", Interface: "eth0"}}
+
+	if err := p.apply(context.Background(), root, false); err != nil {
+		t.Fatalf("apply() error = %v", err)
+	}
+
+	if data, _ := os.ReadFile(filepath.Join(root, "etc", "hostname")); string(data) != "web1\n" {
+		t.Errorf("hostname = %q", data)
+	}
+	want := []string{
+		"chroot " + root + " usermod -a -G wheel admin",
+		"chroot " + root + " chpasswd -e",
+	}
+	if len(fake.Commands) != len(want) {
+		t.Fatalf("commands = %v, want %v", fake.Commands, want)
+	}
+	for i, c := range fake.Commands {
+		if c.String() != want[i] {
+			t.Errorf("command %d = %q, want %q", i, c.String(), want[i])
+		}
+	}
+
+	keys := filepath.Join(root, "var", "home", "admin", ".ssh", "authorized_keys")
+	info, err := os.Stat(keys)
+	if err != nil {
+		t.Fatalf("authorized_keys not written: %v", err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("authorized_keys mode = %v, want 0600", info.Mode().Perm())
+	}
+	if data, _ := os.ReadFile(keys); string(data) != "ssh-ed25519 AAAA admin@example\n" {
+		t.Errorf("authorized_keys = %q", data)
+	}
+	if _, err := os.Stat(filepath.Join(root, "etc", "NetworkManager", "system-connections", "eth0.nmconnection")); err != nil {
+		t.Errorf("connection not written: %v", err)
+	}
+}
+
+func TestWriteProvisionedFile(t *testing.T) {
+	root := t.TempDir()
+	writeFakeFile(t, filepath.Join(root, "etc", "passwd"), fmt.Sprintf("core:x:%d:%d::/var/home/core:/bin/bash\n", os.Getuid(), os.Getgid()))
+	writeFakeFile(t, filepath.Join(root, "etc", "hostname"), "localhost\n")
+
+	if err := writeProvisionedFile(root, File{Path: "/etc/hostname", Contents: []byte("web1\n")}); err == nil {
+		t.Error("writeProvisionedFile() expected error for an existing file without overwrite")
+	}
+	files := []File{
+		{Path: "/etc/hostname", Contents: []byte("web1\n"), Overwrite: true},
+		{Path: "/etc/app/secret", Contents: []byte("s3cret"), Mode: 0600, Owner: "core"},
+		{Path: "/var/lib/app", Dir: true, Mode: 0700},
+		{Path: "/etc/localtime", Target: "../usr/share/zoneinfo/UTC"},
+	}
+	for _, f := range files {
+		if err := writeProvisionedFile(root, f); err != nil {
+			t.Fatalf("writeProvisionedFile(%s) error = %v", f.Path, err)
+		}
+	}
+
+	if data, _ := os.ReadFile(filepath.Join(root, "etc", "hostname")); string(data) != "web1\n" {
+		t.Errorf("hostname = %q", data)
+	}
+	if info, err := os.Stat(filepath.Join(root, "etc", "app", "secret")); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("secret = %v, %v, want mode 0600", info, err)
+	}
+	if info, err := os.Stat(filepath.Join(root, "var", "lib", "app")); err != nil || !info.IsDir() || info.Mode().Perm() != 0700 {
+		t.Errorf("directory = %v, %v, want mode 0700", info, err)
+	}
+	if target, err := os.Readlink(filepath.Join(root, "etc", "localtime")); err != nil || target != "../usr/share/zoneinfo/UTC" {
+		t.Errorf("link = %q, %v", target, err)
+	}
+	if err := writeProvisionedFile(root, File{Path: "/etc/x", Owner: "nobody"}); err == nil {
+		t.Error("writeProvisionedFile() expected error for an unknown owner")
+	}
+}
+
+func TestConfigureUnit(t *testing.T) {
+	fake, _ := setupDryRunExecutor(t)
+
+	root := t.TempDir()
+	enabled := true
+	units := []Unit{
+		{Name: "app.service", Contents: "[Service]\n", Enabled: &enabled},
+		{Name: "sshd.service", Dropins: []UnitDropin{{Name: "10-port.conf", Contents: "[Service]\n"}}},
+		{Name: "cups.service", Mask: true},
+	}
+	for _, u := range units {
+		if err := configureUnit(context.Background(), root, u); err != nil {
+			t.Fatalf("configureUnit(%s) error = %v", u.Name, err)
+		}
+	}
+
+	for _, path := range []string{"etc/systemd/system/app.service", "etc/systemd/system/sshd.service.d/10-port.conf"} {
+		if _, err := os.Stat(filepath.Join(root, path)); err != nil {
+			t.Errorf("%s not written: %v", path, err)
+		}
+	}
+	want := []string{
+		"systemctl --root=" + root + " enable app.service",
+		"systemctl --root=" + root + " mask cups.service",
+	}
+	if len(fake.Commands) != len(want) {
+		t.Fatalf("commands = %v, want %v", fake.Commands, want)
+	}
+	for i, c := range fake.Commands {
+		if c.String() != want[i] {
+			t.Errorf("command %d = %q, want %q", i, c.String(), want[i])
+		}
+	}
+}
+
+func TestProvisioningSpecs(t *testing.T) {
+	keyFile := filepath.Join(t.TempDir(), "keys.pub")
+	writeFakeFile(t, keyFile, "# team keys\nssh-ed25519 AAAA one@example\n\nssh-rsa BBBB two@example\n")
+
+	p := &Provisioning{}
+	for _, spec := range []string{"admin:wheel, adm", "deploy"} {
+		if err := p.AddUserSpec(spec); err != nil {
+			t.Fatalf("AddUserSpec(%q) error = %v", spec, err)
+		}
+	}
+	for _, spec := range []string{"ssh-ed25519 AAAA root@example", "admin:" + keyFile, `deploy:from="10.0.0.0/8" ssh-ed25519 CCCC ci@example`} {
+		if err := p.AddSSHKeySpec(spec); err != nil {
+			t.Fatalf("AddSSHKeySpec(%q) error = %v", spec, err)
+		}
+	}
+	if err := p.SetPasswordHash("root", "$6$salt$hash"); err != nil {
+		t.Fatalf("SetPasswordHash() error = %v", err)
+	}
+
+	want := []User{
+		{Name: "admin", Groups: []string{"wheel", "adm"}, SSHKeys: []string{"ssh-ed25519 AAAA one@example", "ssh-rsa BBBB two@example"}},
+		{Name: "deploy", SSHKeys: []string{`from="10.0.0.0/8" ssh-ed25519 CCCC ci@example`}},
+		{Name: "root", PasswordHash: "$6$salt$hash", SSHKeys: []string{"ssh-ed25519 AAAA root@example"}},
+	}
+	if !reflect.DeepEqual(p.Users, want) {
+		t.Errorf("Users = %+v, want %+v", p.Users, want)
+	}
+
+	if err := p.AddUserSpec("Bad Name"); err == nil {
+		t.Error("AddUserSpec() expected error for an invalid name")
+	}
+	if err := p.SetPasswordHash("root", "hunter2"); err == nil {
+		t.Error("SetPasswordHash() expected error for a plaintext password")
+	}
+	if err := p.AddSSHKeySpec("admin:/nonexistent/key.pub"); err == nil {
+		t.Error("AddSSHKeySpec() expected error for a missing key file")
+	}
+}
+
+func TestProvisioningSystemSettings(t *testing.T) {
+	p := &Provisioning{}
+	for _, h := range []string{"web1", "web-1.example.com"} {
+		if err := p.SetHostname(h); err != nil {
+			t.Errorf("SetHostname(%q) error = %v", h, err)
+		}
+	}
+	for _, h := range []string{"", "web_1", "-web", "web..example", strings.Repeat("a", 65)} {
+		if err := p.SetHostname(h); err == nil {
+			t.Errorf("SetHostname(%q) expected error", h)
+		}
+	}
+	for _, tz := range []string{"", "/etc/passwd", "../../etc/shadow", "Europe/../../x"} {
+		if err := p.SetTimezone(tz); err == nil {
+			t.Errorf("SetTimezone(%q) expected error", tz)
+		}
+	}
+	for _, l := range []string{"en_US.UTF-8", "C.UTF-8", "de_DE@euro", "POSIX"} {
+		if err := p.SetLocale(l); err != nil {
+			t.Errorf("SetLocale(%q) error = %v", l, err)
+		}
+	}
+	if err := p.SetLocale("en_US.UTF-8; rm -rf /"); err == nil {
+		t.Error("SetLocale() expected error for an invalid locale")
+	}
+
+	root := t.TempDir()
+	writeFakeFile(t, filepath.Join(root, "usr", "share", "zoneinfo", "Europe", "Berlin"), "TZif")
+	writeFakeFile(t, filepath.Join(root, "etc", "localtime"), "TZif")
+	p = &Provisioning{Hostname: "web1", Timezone: "Europe/Berlin", Locale: "en_US.UTF-8"}
+	if err := p.apply(context.Background(), root, false); err != nil {
+		t.Fatalf("apply() error = %v", err)
+	}
+	if target, _ := os.Readlink(filepath.Join(root, "etc", "localtime")); target != "../usr/share/zoneinfo/Europe/Berlin" {
+		t.Errorf("localtime -> %q", target)
+	}
+	if data, _ := os.ReadFile(filepath.Join(root, "etc", "locale.conf")); string(data) != "LANG=en_US.UTF-8\n" {
+		t.Errorf("locale.conf = %q", data)
+	}
+
+	p = &Provisioning{Timezone: "Mars/Olympus_Mons"}
+	if err := p.apply(context.Background(), root, false); err == nil {
+		t.Error("apply() expected error for a timezone missing from the image")
+	}
+}
@@ -0,0 +1,93 @@
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// Reboot asks systemd to reboot into the new default boot entry
+func Reboot(ctx context.Context, dryRun bool) error {
+	if dryRun {
+		fmt.Println("[DRY RUN] Would reboot")
+		return nil
+	}
+	fmt.Println("Rebooting...")
+	if out, err := runCommand(ctx, "systemctl", "reboot"); err != nil {
+		return fmt.Errorf("failed to reboot: %w: %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// CheckKexecTools checks that kexec is installed
+func CheckKexecTools() error {
+	if _, err := executor.LookPath("kexec"); err != nil {
+		return fmt.Errorf("%w: kexec - install the kexec-tools package", ErrMissingTool)
+	}
+	return nil
+}
+
+// KexecReboot loads the kernel, initramfs and command line of the default
+// boot entry on device with kexec and asks systemd to reboot into it,
+// skipping the firmware and bootloader. After an update the default entry is
+// the new root.
+func KexecReboot(ctx context.Context, device string, dryRun bool) error {
+	if err := CheckKexecTools(); err != nil {
+		return err
+	}
+	scheme, err := DetectExistingPartitionScheme(device)
+	if err != nil {
+		return fmt.Errorf("failed to detect partition scheme: %w", err)
+	}
+
+	// kexec reads the files when loading, so the boot partition is only
+	// needed until then
+	if err := withReadOnlyMount(scheme.BootPartition, func(boot string) error {
+		entry, err := readDefaultBootEntry(boot)
+		if err != nil {
+			return err
+		}
+		return kexecLoad(ctx, boot, entry, dryRun)
+	}); err != nil {
+		return err
+	}
+
+	if dryRun {
+		fmt.Println("[DRY RUN] Would reboot with kexec")
+		return nil
+	}
+	fmt.Println("Rebooting with kexec...")
+	if out, err := runCommand(ctx, "systemctl", "kexec"); err != nil {
+		_, _ = runCommand(ctx, "kexec", "-u")
+		return fmt.Errorf("failed to reboot with kexec: %w: %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// kexecLoad loads entry from the boot partition mounted at bootMount as the
+// kernel to run on the next kexec reboot
+func kexecLoad(ctx context.Context, bootMount string, entry *bootEntry, dryRun bool) error {
+	if entry.Kernel == "" {
+		return fmt.Errorf("the default boot entry has no kernel")
+	}
+	args := []string{"-l", filepath.Join(bootMount, entry.Kernel)}
+	if entry.Initrd != "" {
+		args = append(args, "--initrd="+filepath.Join(bootMount, entry.Initrd))
+	}
+	args = append(args, "--command-line="+entry.Options)
+
+	fmt.Printf("Loading %s", entry.Kernel)
+	if uuid := entry.rootUUID(); uuid != "" {
+		fmt.Printf(" for root UUID=%s", uuid)
+	}
+	fmt.Println()
+	if dryRun {
+		fmt.Printf("[DRY RUN] Would run: kexec %s\n", strings.Join(args, " "))
+		return nil
+	}
+	if out, err := runCommand(ctx, "kexec", args...); err != nil {
+		return fmt.Errorf("failed to load kernel with kexec: %w: %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
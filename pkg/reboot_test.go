@@ -0,0 +1,27 @@
+package pkg
+
+import (
+	"context"
+	"testing"
+)
+
+func TestKexecLoad(t *testing.T) {
+	t.Run("loads kernel, initramfs and command line", func(t *testing.T) {
+		fake, _ := setupDryRunExecutor(t)
+
+		entry := &bootEntry{Kernel: "/vmlinuz-6.2", Initrd: "/initramfs-6.2.img", Options: "root=UUID=cccc rw"}
+		if err := kexecLoad(context.Background(), "/boot", entry, false); err != nil {
+			t.Fatalf("kexecLoad() error = %v", err)
+		}
+		want := "kexec -l /boot/vmlinuz-6.2 --initrd=/boot/initramfs-6.2.img '--command-line=root=UUID=cccc rw'"
+		if len(fake.Commands) != 1 || fake.Commands[0].String() != want {
+			t.Errorf("commands = %v, want %q", fake.Commands, want)
+		}
+	})
+
+	t.Run("no kernel", func(t *testing.T) {
+		if err := kexecLoad(context.Background(), "/boot", &bootEntry{Options: "root=UUID=cccc"}, false); err == nil {
+			t.Error("kexecLoad() expected error without a kernel")
+		}
+	})
+}
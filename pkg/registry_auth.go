@@ -0,0 +1,88 @@
+package pkg
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/docker/cli/cli/config"
+	"github.com/docker/cli/cli/config/types"
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+)
+
+// Registry credentials
+//
+// Images are pulled with the credentials of the docker config
+// (~/.docker/config.json or $DOCKER_CONFIG) or, without one, of the podman
+// auth file ($REGISTRY_AUTH_FILE or $XDG_RUNTIME_DIR/containers/auth.json).
+// An auth file set with SetRegistryAuthFile replaces all of them, so a system
+// service without a home directory finds the credentials of a private
+// registry. podman and skopeo get the file through REGISTRY_AUTH_FILE.
+
+// registryAuthFile is the auth file registry requests use, "" for the
+// default locations
+var registryAuthFile string
+
+// SetRegistryAuthFile makes registry requests use the credentials in the
+// auth file at path, in the format of containers-auth.json or the docker
+// config. An empty path restores the default locations.
+func SetRegistryAuthFile(path string) error {
+	if path == "" {
+		registryAuthFile = ""
+		return nil
+	}
+	if _, err := os.Stat(path); err != nil {
+		return fmt.Errorf("failed to read registry auth file: %w", err)
+	}
+	registryAuthFile = path
+	if err := os.Setenv("REGISTRY_AUTH_FILE", path); err != nil {
+		return fmt.Errorf("failed to set REGISTRY_AUTH_FILE: %w", err)
+	}
+	return nil
+}
+
+// registryKeychain returns the keychain registry requests authenticate with
+func registryKeychain() authn.Keychain {
+	if registryAuthFile == "" {
+		return authn.DefaultKeychain
+	}
+	return authFileKeychain{path: registryAuthFile}
+}
+
+// authFileKeychain resolves credentials from one auth file
+type authFileKeychain struct {
+	path string
+}
+
+// Resolve implements authn.Keychain, looking the credentials up by
+// repository first and registry second like the docker config does
+func (k authFileKeychain) Resolve(target authn.Resource) (authn.Authenticator, error) {
+	f, err := os.Open(k.path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open registry auth file: %w", err)
+	}
+	defer func() { _ = f.Close() }()
+	cf, err := config.LoadFromReader(f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse registry auth file %s: %w", k.path, err)
+	}
+
+	var empty types.AuthConfig
+	for _, key := range []string{target.String(), target.RegistryStr()} {
+		if key == name.DefaultRegistry {
+			key = authn.DefaultAuthKey
+		}
+		cfg, ok := cf.AuthConfigs[key]
+		if !ok || cfg == empty {
+			continue
+		}
+		return authn.FromConfig(authn.AuthConfig{
+			Username:      cfg.Username,
+			Password:      cfg.Password,
+			Auth:          cfg.Auth,
+			IdentityToken: cfg.IdentityToken,
+			RegistryToken: cfg.RegistryToken,
+		}), nil
+	}
+	return authn.Anonymous, nil
+}
@@ -0,0 +1,64 @@
+package pkg
+
+import (
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+)
+
+func TestRegistryAuthFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "auth.json")
+	auth := base64.StdEncoding.EncodeToString([]byte("robot:secret"))
+	writeFakeFile(t, path, `{"auths": {"registry.example.com": {"auth": "`+auth+`"}}}`)
+	t.Setenv("REGISTRY_AUTH_FILE", "")
+
+	if err := SetRegistryAuthFile(path); err != nil {
+		t.Fatalf("SetRegistryAuthFile() error = %v", err)
+	}
+	t.Cleanup(func() { _ = SetRegistryAuthFile("") })
+
+	tests := []struct {
+		image    string
+		username string
+	}{
+		{"registry.example.com/team/os:latest", "robot"},
+		{"quay.io/team/os:latest", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.image, func(t *testing.T) {
+			ref, err := name.ParseReference(tt.image)
+			if err != nil {
+				t.Fatal(err)
+			}
+			authenticator, err := registryKeychain().Resolve(ref.Context())
+			if err != nil {
+				t.Fatalf("Resolve() error = %v", err)
+			}
+			if tt.username == "" {
+				if authenticator != authn.Anonymous {
+					t.Errorf("Resolve() = %v, want anonymous", authenticator)
+				}
+				return
+			}
+			cfg, err := authenticator.Authorization()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cfg.Username != tt.username {
+				t.Errorf("Resolve() = %+v, want user %s", cfg, tt.username)
+			}
+		})
+	}
+
+	if got := os.Getenv("REGISTRY_AUTH_FILE"); got != path {
+		t.Errorf("REGISTRY_AUTH_FILE = %q, want %q", got, path)
+	}
+	if err := SetRegistryAuthFile(filepath.Join(dir, "missing.json")); err == nil {
+		t.Error("SetRegistryAuthFile() of a missing file should fail")
+	}
+}
@@ -0,0 +1,292 @@
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/pelletier/go-toml/v2"
+)
+
+// Registry mirrors
+//
+// The builtin registry client reads the [[registry]] tables of
+// containers-registries.conf(5), the file podman and skopeo use, so pulls
+// try a nearby mirror or caching proxy first and fall back to the registry
+// itself:
+//
+//	[[registry]]
+//	prefix = "quay.io/my-org"
+//	location = "quay.io/my-org"
+//
+//	[[registry.mirror]]
+//	location = "mirror.example.com:5000/my-org"
+//	insecure = true
+//
+// The most specific prefix matching an image's repository applies. Mirrors
+// are tried in order, then the location. pull-from-mirror (all,
+// digest-only or tag-only) and the registry's mirror-by-digest-only limit
+// which references a mirror serves, and blocked = true refuses the
+// registry. Wildcard prefixes ("*.example.com") and the other settings of the
+// file are ignored. Signatures and layers come from the source that served
+// the manifest; digests and image policy checks don't depend on the source.
+
+// DefaultRegistriesConf is the registries.conf read without
+// SetRegistriesConf, with the drop-ins in its .d directory
+const DefaultRegistriesConf = "/etc/containers/registries.conf"
+
+// Values of pull-from-mirror
+const (
+	pullFromMirrorAll        = "all"
+	pullFromMirrorDigestOnly = "digest-only"
+	pullFromMirrorTagOnly    = "tag-only"
+)
+
+// RegistryMirror is a [[registry.mirror]] table of registries.conf
+type RegistryMirror struct {
+	Location       string `toml:"location"`
+	Insecure       bool   `toml:"insecure"`
+	PullFromMirror string `toml:"pull-from-mirror"` // all (default), digest-only or tag-only
+}
+
+// RegistryConfig is a [[registry]] table of registries.conf
+type RegistryConfig struct {
+	Prefix             string           `toml:"prefix"` // Defaults to Location
+	Location           string           `toml:"location"`
+	Insecure           bool             `toml:"insecure"`
+	Blocked            bool             `toml:"blocked"`
+	MirrorByDigestOnly bool             `toml:"mirror-by-digest-only"`
+	Mirrors            []RegistryMirror `toml:"mirror"`
+}
+
+// registriesConf is the part of registries.conf phukit reads
+type registriesConf struct {
+	Registries []RegistryConfig `toml:"registry"`
+}
+
+// registryConfigs are the registries of the loaded registries.conf
+var registryConfigs []RegistryConfig
+
+// SetRegistriesConf makes pulls use the mirrors of the registries.conf at
+// path. An empty path reads DefaultRegistriesConf and its drop-ins if they
+// exist. An explicit path is also passed to podman and skopeo through
+// CONTAINERS_REGISTRIES_CONF.
+func SetRegistriesConf(path string) error {
+	var files []string
+	if path == "" {
+		if _, err := os.Stat(DefaultRegistriesConf); err == nil {
+			files = append(files, DefaultRegistriesConf)
+		}
+		dropIns, err := filepath.Glob(DefaultRegistriesConf + ".d/*.conf")
+		if err != nil {
+			return err
+		}
+		files = append(files, dropIns...)
+	} else {
+		if _, err := os.Stat(path); err != nil {
+			return fmt.Errorf("failed to read registries.conf: %w", err)
+		}
+		files = []string{path}
+		if err := os.Setenv("CONTAINERS_REGISTRIES_CONF", path); err != nil {
+			return fmt.Errorf("failed to set CONTAINERS_REGISTRIES_CONF: %w", err)
+		}
+	}
+
+	var registries []RegistryConfig
+	for _, file := range files {
+		loaded, err := loadRegistriesConf(file)
+		if err != nil {
+			return err
+		}
+		registries = mergeRegistryConfigs(registries, loaded)
+	}
+	registryConfigs = registries
+	return nil
+}
+
+// loadRegistriesConf reads the [[registry]] tables of the file at path
+func loadRegistriesConf(path string) ([]RegistryConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read registries.conf: %w", err)
+	}
+	var conf registriesConf
+	if err := toml.Unmarshal(data, &conf); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	for i := range conf.Registries {
+		reg := &conf.Registries[i]
+		if reg.Prefix == "" {
+			reg.Prefix = reg.Location
+		}
+		if reg.Location == "" && !reg.Blocked {
+			reg.Location = reg.Prefix
+		}
+		if reg.Prefix == "" {
+			return nil, fmt.Errorf("%s: [[registry]] without prefix or location", path)
+		}
+		for _, mirror := range reg.Mirrors {
+			if mirror.Location == "" {
+				return nil, fmt.Errorf("%s: mirror of %s without location", path, reg.Prefix)
+			}
+			switch mirror.PullFromMirror {
+			case "", pullFromMirrorAll, pullFromMirrorDigestOnly, pullFromMirrorTagOnly:
+			default:
+				return nil, fmt.Errorf("%s: mirror %s: unsupported pull-from-mirror %q", path, mirror.Location, mirror.PullFromMirror)
+			}
+		}
+	}
+	return conf.Registries, nil
+}
+
+// mergeRegistryConfigs returns base with the registries of a later file:
+// those with the prefix of an earlier one replace it
+func mergeRegistryConfigs(base, later []RegistryConfig) []RegistryConfig {
+	for _, reg := range later {
+		replaced := false
+		for i := range base {
+			if base[i].Prefix == reg.Prefix {
+				base[i], replaced = reg, true
+			}
+		}
+		if !replaced {
+			base = append(base, reg)
+		}
+	}
+	return base
+}
+
+// prefixMatches reports whether prefix covers the repository repo
+func prefixMatches(prefix, repo string) bool {
+	prefix = strings.TrimSuffix(prefix, "/")
+	return repo == prefix || strings.HasPrefix(repo, prefix+"/")
+}
+
+// registryConfigFor returns the registry of registries with the longest
+// prefix covering repo, or nil
+func registryConfigFor(registries []RegistryConfig, repo string) *RegistryConfig {
+	var match *RegistryConfig
+	for i, reg := range registries {
+		if strings.Contains(reg.Prefix, "*") || !prefixMatches(reg.Prefix, repo) {
+			continue
+		}
+		if match == nil || len(reg.Prefix) > len(match.Prefix) {
+			match = &registries[i]
+		}
+	}
+	return match
+}
+
+// pullSource is a place to pull an image from
+type pullSource struct {
+	Ref    name.Reference
+	Mirror bool
+}
+
+// pullSources returns where to pull ref from, in order: the mirrors that
+// serve it, then the registry's location
+func pullSources(registries []RegistryConfig, ref name.Reference) ([]pullSource, error) {
+	repo := repositoryName(ref)
+	reg := registryConfigFor(registries, repo)
+	if reg == nil {
+		return []pullSource{{Ref: ref}}, nil
+	}
+	if reg.Blocked {
+		return nil, fmt.Errorf("%w: registry %s is blocked in registries.conf", ErrPolicyViolation, reg.Prefix)
+	}
+
+	_, byDigest := ref.(name.Digest)
+	rest := strings.TrimPrefix(repo, strings.TrimSuffix(reg.Prefix, "/"))
+	var sources []pullSource
+	for _, mirror := range reg.Mirrors {
+		pull := mirror.PullFromMirror
+		if reg.MirrorByDigestOnly {
+			pull = pullFromMirrorDigestOnly
+		}
+		if (pull == pullFromMirrorDigestOnly && !byDigest) || (pull == pullFromMirrorTagOnly && byDigest) {
+			continue
+		}
+		mirrorRef, err := rewriteReference(ref, strings.TrimSuffix(mirror.Location, "/")+rest, mirror.Insecure)
+		if err != nil {
+			return nil, fmt.Errorf("mirror %s of %s: %w", mirror.Location, reg.Prefix, err)
+		}
+		sources = append(sources, pullSource{Ref: mirrorRef, Mirror: true})
+	}
+	location, err := rewriteReference(ref, strings.TrimSuffix(reg.Location, "/")+rest, reg.Insecure)
+	if err != nil {
+		return nil, fmt.Errorf("location %s of %s: %w", reg.Location, reg.Prefix, err)
+	}
+	return append(sources, pullSource{Ref: location}), nil
+}
+
+// rewriteReference returns ref with its repository replaced by repo
+func rewriteReference(ref name.Reference, repo string, insecure bool) (name.Reference, error) {
+	var opts []name.Option
+	if insecure {
+		opts = append(opts, name.Insecure)
+	}
+	sep := ":"
+	if _, ok := ref.(name.Digest); ok {
+		sep = "@"
+	}
+	rewritten, err := name.ParseReference(repo+sep+ref.Identifier(), opts...)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidImageReference, err)
+	}
+	return rewritten, nil
+}
+
+// fromMirrors calls fetch with each source of ref until one succeeds, and
+// returns its result or the error of the registry itself
+func fromMirrors[T any](ctx context.Context, ref name.Reference, fetch func(name.Reference) (T, error)) (T, error) {
+	var zero T
+	sources, err := pullSources(registryConfigs, ref)
+	if err != nil {
+		return zero, err
+	}
+	for _, src := range sources {
+		v, err := fetch(src.Ref)
+		if err == nil {
+			if src.Mirror {
+				logger.Debug("pulled from mirror", "image", ref.String(), "mirror", src.Ref.String())
+			}
+			return v, nil
+		}
+		if !src.Mirror || ctx.Err() != nil {
+			return zero, err
+		}
+		msg := fmt.Sprintf("mirror %s failed (%v), trying the next source", src.Ref.Context().String(), registryError(err))
+		logger.Warn(msg, "image", ref.String())
+		printWarning("  ", msg)
+	}
+	return zero, fmt.Errorf("no source for %s", ref)
+}
+
+// remoteHead returns the descriptor of ref from its first source that has it
+func remoteHead(ctx context.Context, ref name.Reference) (*v1.Descriptor, error) {
+	return fromMirrors(ctx, ref, func(r name.Reference) (*v1.Descriptor, error) {
+		return remote.Head(r, registryOptions(ctx)...)
+	})
+}
+
+// remoteImage returns the image ref from its first source that has it;
+// its layers are fetched from the same source
+func remoteImage(ctx context.Context, ref name.Reference) (v1.Image, error) {
+	return fromMirrors(ctx, ref, func(r name.Reference) (v1.Image, error) {
+		return remote.Image(r, registryOptions(ctx)...)
+	})
+}
+
+// remoteGet returns the manifest descriptor of ref from its first source
+// that has it; the images and indexes it resolves to come from the same
+// source
+func remoteGet(ctx context.Context, ref name.Reference, opts ...remote.Option) (*remote.Descriptor, error) {
+	return fromMirrors(ctx, ref, func(r name.Reference) (*remote.Descriptor, error) {
+		return remote.Get(r, append(registryOptions(ctx), opts...)...)
+	})
+}
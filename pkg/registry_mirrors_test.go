@@ -0,0 +1,159 @@
+package pkg
+
+import (
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+)
+
+func TestLoadRegistriesConf(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "registries.conf")
+	conf := `unqualified-search-registries = ["quay.io"]
+
+[[registry]]
+prefix = "quay.io/my-org"
+location = "quay.io/my-org"
+
+[[registry.mirror]]
+location = "mirror.example.com/my-org"
+
+[[registry]]
+location = "docker.io"
+blocked = true
+`
+	if err := os.WriteFile(path, []byte(conf), 0644); err != nil {
+		t.Fatal(err)
+	}
+	registries, err := loadRegistriesConf(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(registries) != 2 || registries[1].Prefix != "docker.io" || len(registries[0].Mirrors) != 1 {
+		t.Fatalf("loadRegistriesConf() = %+v", registries)
+	}
+
+	// A drop-in replaces the registry of the same prefix
+	merged := mergeRegistryConfigs(registries, []RegistryConfig{{Prefix: "docker.io", Location: "docker.io"}})
+	if len(merged) != 2 || merged[1].Blocked {
+		t.Errorf("mergeRegistryConfigs() = %+v, want docker.io unblocked", merged)
+	}
+
+	if err := os.WriteFile(path, []byte("[[registry]]\nprefix = \"quay.io\"\n[[registry.mirror]]\nlocation = \"m\"\npull-from-mirror = \"sometimes\"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadRegistriesConf(path); err == nil {
+		t.Error("loadRegistriesConf() with an unsupported pull-from-mirror succeeded")
+	}
+}
+
+func TestPullSources(t *testing.T) {
+	registries := []RegistryConfig{
+		{Prefix: "quay.io", Location: "quay.io", Mirrors: []RegistryMirror{{Location: "cache.example.com/quay"}}},
+		{Prefix: "quay.io/my-org", Location: "registry.example.com/my-org", Mirrors: []RegistryMirror{
+			{Location: "mirror.example.com:5000/my-org", Insecure: true},
+			{Location: "digests.example.com/my-org", PullFromMirror: "digest-only"},
+		}},
+		{Prefix: "docker.io/library", Location: "docker.io/library", MirrorByDigestOnly: true, Mirrors: []RegistryMirror{{Location: "hub-cache.example.com/library"}}},
+		{Prefix: "ghcr.io", Blocked: true},
+	}
+	digest := "@sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+	for _, tt := range []struct {
+		ref  string
+		want []string
+	}{
+		{"quay.io/my-org/os:42", []string{"mirror.example.com:5000/my-org/os:42", "registry.example.com/my-org/os:42"}},
+		{"quay.io/my-org/os" + digest, []string{"mirror.example.com:5000/my-org/os" + digest, "digests.example.com/my-org/os" + digest, "registry.example.com/my-org/os" + digest}},
+		{"quay.io/other/os:latest", []string{"cache.example.com/quay/other/os:latest", "quay.io/other/os:latest"}},
+		{"quay.io/my-org-2/os:latest", []string{"cache.example.com/quay/my-org-2/os:latest", "quay.io/my-org-2/os:latest"}},
+		{"fedora:42", []string{"index.docker.io/library/fedora:42"}},
+		{"fedora" + digest, []string{"hub-cache.example.com/library/fedora" + digest, "index.docker.io/library/fedora" + digest}},
+		{"registry.example.com/os:latest", []string{"registry.example.com/os:latest"}},
+	} {
+		ref, err := name.ParseReference(tt.ref)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sources, err := pullSources(registries, ref)
+		if err != nil {
+			t.Errorf("pullSources(%s) error = %v", tt.ref, err)
+			continue
+		}
+		var got []string
+		for _, src := range sources {
+			got = append(got, src.Ref.Name())
+		}
+		if strings.Join(got, " ") != strings.Join(tt.want, " ") {
+			t.Errorf("pullSources(%s) = %v, want %v", tt.ref, got, tt.want)
+		}
+		if sources[len(sources)-1].Mirror {
+			t.Errorf("pullSources(%s) ends with a mirror", tt.ref)
+		}
+	}
+
+	if sources, _ := pullSources(registries, name.MustParseReference("quay.io/my-org/os:42")); sources[0].Ref.Context().Scheme() != "http" {
+		t.Error("insecure mirror does not use plain HTTP")
+	}
+	if _, err := pullSources(registries, name.MustParseReference("ghcr.io/example/os:latest")); ErrorCode(err) != CodePolicyViolation {
+		t.Errorf("pullSources() of a blocked registry error = %v, want %s", err, CodePolicyViolation)
+	}
+}
+
+func TestPullFallsBackFromMirror(t *testing.T) {
+	img, err := mutate.AppendLayers(empty.Image, testLayer(t, "usr/lib/os-release", "ID=example\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	imageRef := pushTestImage(t, img)
+	want, err := img.Digest()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// An empty mirror makes the pull fall back to the registry
+	var mirrorRequests atomic.Int32
+	mirrorReg := registry.New(registry.Logger(log.New(io.Discard, "", 0)))
+	mirror := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mirrorRequests.Add(1)
+		mirrorReg.ServeHTTP(w, r)
+	}))
+	defer mirror.Close()
+
+	ref, err := name.ParseReference(imageRef)
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo := ref.Context().Name()
+	old := registryConfigs
+	t.Cleanup(func() { registryConfigs = old })
+	registryConfigs = []RegistryConfig{{
+		Prefix:   repo,
+		Location: repo,
+		Mirrors:  []RegistryMirror{{Location: strings.TrimPrefix(mirror.URL, "http://") + "/cache/os", Insecure: true}},
+	}}
+
+	got, err := GetRemoteImageDigest(t.Context(), imageRef)
+	if err != nil {
+		t.Fatalf("GetRemoteImageDigest() error = %v", err)
+	}
+	if got != want.String() || mirrorRequests.Load() == 0 {
+		t.Errorf("GetRemoteImageDigest() = %s after %d mirror requests, want %s from the registry", got, mirrorRequests.Load(), want)
+	}
+
+	// A blocked registry is refused
+	registryConfigs = []RegistryConfig{{Prefix: repo, Blocked: true}}
+	if _, err := GetRemoteImageDigest(t.Context(), imageRef); ErrorCode(err) != CodePolicyViolation {
+		t.Errorf("GetRemoteImageDigest() of a blocked registry error = %v", err)
+	}
+}
@@ -0,0 +1,201 @@
+package pkg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+)
+
+// Registry retries
+//
+// Every request of the builtin registry client (manifest lookups, layer
+// downloads, signature fetches) is retried with exponential backoff when the
+// connection fails or the registry answers 408, 429 or 5xx, so a flaky
+// network or an overloaded registry doesn't abort an otherwise healthy
+// update. Each retry prints a warning. A request whose body can't be replayed
+// is not retried. Downloads that break off in the middle of a layer are not
+// retried either; the update fails and can be run again.
+
+// RegistryRetryPolicy is how registry requests are retried
+type RegistryRetryPolicy struct {
+	Attempts       int           // Tries per request, including the first
+	InitialBackoff time.Duration // Wait before the first retry, doubled on each further retry
+	MaxBackoff     time.Duration // Longest wait between tries
+	Timeout        time.Duration // How long to wait for the registry to answer a try (0 = no limit)
+}
+
+// DefaultRegistryRetryPolicy tries every request five times over about 15
+// seconds
+var DefaultRegistryRetryPolicy = RegistryRetryPolicy{
+	Attempts:       5,
+	InitialBackoff: time.Second,
+	MaxBackoff:     30 * time.Second,
+	Timeout:        time.Minute,
+}
+
+// registryTransport carries the requests of the builtin registry client
+var registryTransport http.RoundTripper = newRetryTransport(DefaultRegistryRetryPolicy)
+
+// SetRegistryRetryPolicy makes registry requests retry as p says
+func SetRegistryRetryPolicy(p RegistryRetryPolicy) error {
+	if p.Attempts < 1 {
+		return fmt.Errorf("registry retry attempts must be at least 1, got %d", p.Attempts)
+	}
+	if p.InitialBackoff < 0 || p.MaxBackoff < 0 || p.Timeout < 0 {
+		return fmt.Errorf("registry retry backoff and timeout must not be negative")
+	}
+	if p.MaxBackoff < p.InitialBackoff {
+		p.MaxBackoff = p.InitialBackoff
+	}
+	registryTransport = newRetryTransport(p)
+	return nil
+}
+
+// registryOptions are the options of every builtin registry request
+func registryOptions(ctx context.Context) []remote.Option {
+	return []remote.Option{
+		remote.WithContext(ctx),
+		remote.WithAuthFromKeychain(registryKeychain()),
+		remote.WithTransport(registryTransport),
+		// registryTransport retries these, with warnings
+		remote.WithRetryStatusCodes(),
+	}
+}
+
+// retryTransport retries the requests of inner as policy says
+type retryTransport struct {
+	inner  http.RoundTripper
+	policy RegistryRetryPolicy
+}
+
+// newRetryTransport returns a transport retrying as p says over a copy of
+// the default transport of go-containerregistry
+func newRetryTransport(p RegistryRetryPolicy) *retryTransport {
+	inner := remote.DefaultTransport
+	if t, ok := remote.DefaultTransport.(*http.Transport); ok {
+		t = t.Clone()
+		t.ResponseHeaderTimeout = p.Timeout
+		inner = t
+	}
+	return &retryTransport{inner: inner, policy: p}
+}
+
+// retryError reports whether a failed request is worth retrying: timeouts,
+// dropped connections and temporary DNS failures are, certificate and
+// handshake errors are not
+func retryError(err error) bool {
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return dnsErr.IsTemporary || dnsErr.IsTimeout
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	return errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.EPIPE) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF)
+}
+
+// retryStatus reports whether a response with code is worth retrying
+func retryStatus(code int) bool {
+	switch code {
+	case http.StatusRequestTimeout, http.StatusTooManyRequests,
+		http.StatusInternalServerError, http.StatusBadGateway,
+		http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+	attempts := t.policy.Attempts
+	if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+		attempts = 1
+	}
+
+	for attempt := 1; ; attempt++ {
+		try := req
+		if attempt > 1 && req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			try = req.Clone(ctx)
+			try.Body = body
+		}
+
+		resp, err := t.inner.RoundTrip(try)
+		var problem string
+		switch {
+		case err != nil:
+			if ctx.Err() != nil || !retryError(err) {
+				return nil, err
+			}
+			problem = err.Error()
+		case retryStatus(resp.StatusCode):
+			problem = resp.Status
+		default:
+			return resp, nil
+		}
+
+		if attempt >= attempts {
+			if err != nil {
+				// Flattened, so go-containerregistry doesn't retry it again
+				return nil, fmt.Errorf("%s %s: %v (gave up after %d attempts)", req.Method, redactedURL(req), err, attempt)
+			}
+			return resp, nil
+		}
+
+		wait := t.backoff(attempt, resp)
+		if resp != nil {
+			_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+			_ = resp.Body.Close()
+		}
+		msg := fmt.Sprintf("registry request %s %s failed (%s), retrying in %s (attempt %d/%d)",
+			req.Method, redactedURL(req), problem, wait, attempt+1, attempts)
+		logger.Warn(msg, "url", redactedURL(req), "attempt", attempt+1)
+		printWarning("  ", msg)
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// backoff returns how long to wait after the failed try number attempt,
+// honoring a Retry-After of resp up to the maximum backoff
+func (t *retryTransport) backoff(attempt int, resp *http.Response) time.Duration {
+	wait := t.policy.InitialBackoff
+	for i := 1; i < attempt && wait < t.policy.MaxBackoff; i++ {
+		wait *= 2
+	}
+	if resp != nil {
+		if secs, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && secs > 0 {
+			wait = time.Duration(secs) * time.Second
+		}
+	}
+	return min(wait, t.policy.MaxBackoff)
+}
+
+// redactedURL returns the URL of req without its query, which holds the
+// signatures of pre-signed blob URLs
+func redactedURL(req *http.Request) string {
+	u := *req.URL
+	u.RawQuery = ""
+	u.User = nil
+	return u.String()
+}
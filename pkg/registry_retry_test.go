@@ -0,0 +1,126 @@
+package pkg
+
+import (
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+)
+
+// fastRetries makes registry requests retry without waiting during a test
+func fastRetries(t *testing.T, attempts int) {
+	t.Helper()
+	old := registryTransport
+	t.Cleanup(func() { registryTransport = old })
+	if err := SetRegistryRetryPolicy(RegistryRetryPolicy{Attempts: attempts, InitialBackoff: time.Millisecond, MaxBackoff: time.Millisecond}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRegistryRetriesTransientErrors(t *testing.T) {
+	fastRetries(t, 4)
+	var failures atomic.Int32
+	reg := registry.New(registry.Logger(log.New(io.Discard, "", 0)))
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodHead && failures.Add(-1) >= 0 {
+			http.Error(w, "overloaded", http.StatusServiceUnavailable)
+			return
+		}
+		reg.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+
+	imageRef := strings.TrimPrefix(server.URL, "http://") + "/example/os:latest"
+	ref, err := name.ParseReference(imageRef)
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := mutate.AppendLayers(empty.Image, testLayer(t, "etc/os-release", "ID=test\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := remote.Write(ref, img); err != nil {
+		t.Fatalf("failed to push test image: %v", err)
+	}
+
+	failures.Store(2)
+	if _, err := GetRemoteImageDigest(t.Context(), imageRef); err != nil {
+		t.Fatalf("GetRemoteImageDigest() after two 503s error = %v", err)
+	}
+
+	// Errors beyond the attempts reach the caller
+	failures.Store(10)
+	if _, err := GetRemoteImageDigest(t.Context(), imageRef); err == nil {
+		t.Error("GetRemoteImageDigest() with a failing registry succeeded")
+	}
+}
+
+func TestRetryTransportStops(t *testing.T) {
+	fastRetries(t, 3)
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		http.Error(w, "no such thing", http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	// Client errors are final
+	resp, err := registryTransport.RoundTrip(httptest.NewRequest(http.MethodGet, server.URL+"/v2/", nil).WithContext(t.Context()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound || requests.Load() != 1 {
+		t.Errorf("404: status %d after %d requests, want one request", resp.StatusCode, requests.Load())
+	}
+
+	// A body that can't be replayed is sent once
+	requests.Store(0)
+	server.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.WriteHeader(http.StatusBadGateway)
+	})
+	req, _ := http.NewRequestWithContext(t.Context(), http.MethodPost, server.URL, io.NopCloser(strings.NewReader("blob")))
+	if resp, err := registryTransport.RoundTrip(req); err == nil {
+		_ = resp.Body.Close()
+	}
+	if requests.Load() != 1 {
+		t.Errorf("POST without GetBody sent %d times, want 1", requests.Load())
+	}
+
+	// A replayable body is retried
+	requests.Store(0)
+	req, _ = http.NewRequestWithContext(t.Context(), http.MethodPost, server.URL, strings.NewReader("blob"))
+	if resp, err := registryTransport.RoundTrip(req); err == nil {
+		_ = resp.Body.Close()
+	}
+	if requests.Load() != 3 {
+		t.Errorf("POST with GetBody sent %d times, want 3", requests.Load())
+	}
+}
+
+func TestRetryBackoff(t *testing.T) {
+	tr := &retryTransport{policy: RegistryRetryPolicy{InitialBackoff: time.Second, MaxBackoff: 5 * time.Second}}
+	for i, want := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second} {
+		if got := tr.backoff(i+1, nil); got != want {
+			t.Errorf("backoff(%d) = %s, want %s", i+1, got, want)
+		}
+	}
+	resp := &http.Response{Header: http.Header{"Retry-After": []string{"3"}}}
+	if got := tr.backoff(1, resp); got != 3*time.Second {
+		t.Errorf("backoff() with Retry-After: 3 = %s", got)
+	}
+	if err := SetRegistryRetryPolicy(RegistryRetryPolicy{Attempts: 0}); err == nil {
+		t.Error("SetRegistryRetryPolicy() with no attempts succeeded")
+	}
+}
@@ -0,0 +1,423 @@
+package pkg
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Rekor transparency log
+//
+// cosign records signatures in the Rekor transparency log, and attaches the
+// log's promise to include the entry (a signed entry timestamp, SET) to the
+// signature as a bundle. A requirement with rekor_public_key accepts only
+// signatures whose entry is signed by that log: offline from the bundle, or,
+// with rekor_url, online, where the log also proves that the entry is in its
+// Merkle tree with an inclusion proof against a signed checkpoint. Keyless
+// certificates are then checked at the time the log integrated the entry
+// instead of when they were issued.
+
+// cosignBundleAnnotation holds the Rekor bundle of a cosign signature layer
+const cosignBundleAnnotation = "dev.sigstore.cosign/bundle"
+
+// Ways a transparency log entry is verified
+const (
+	TransparencyLogBundle = "bundle" // Signed entry timestamp of the bundle
+	TransparencyLogOnline = "online" // Entry, timestamp and inclusion proof fetched from the log
+)
+
+// rekorClient queries Rekor servers
+var rekorClient = &http.Client{Timeout: 30 * time.Second}
+
+// TransparencyLogEntry is the verified Rekor entry of a signature
+type TransparencyLogEntry struct {
+	LogIndex       int64     `json:"log_index"`
+	LogID          string    `json:"log_id"`
+	IntegratedTime time.Time `json:"integrated_time"`
+	Verification   string    `json:"verification"`        // TransparencyLogBundle or TransparencyLogOnline
+	TreeSize       int64     `json:"tree_size,omitempty"` // Tree the inclusion proof is for, online only
+}
+
+// rekorBundle is the bundle cosign attaches to a signature
+type rekorBundle struct {
+	SignedEntryTimestamp []byte          `json:"SignedEntryTimestamp"`
+	Payload              rekorSETPayload `json:"Payload"`
+}
+
+// rekorSETPayload is what a signed entry timestamp signs, with its fields in
+// the order of canonical JSON
+type rekorSETPayload struct {
+	Body           string `json:"body"` // Base64 entry
+	IntegratedTime int64  `json:"integratedTime"`
+	LogID          string `json:"logID"`
+	LogIndex       int64  `json:"logIndex"`
+}
+
+// rekorLogEntry is an entry returned by the Rekor API
+type rekorLogEntry struct {
+	Body           string `json:"body"`
+	IntegratedTime int64  `json:"integratedTime"`
+	LogID          string `json:"logID"`
+	LogIndex       int64  `json:"logIndex"`
+	Verification   struct {
+		SignedEntryTimestamp []byte `json:"signedEntryTimestamp"`
+		InclusionProof       *struct {
+			LogIndex   int64    `json:"logIndex"`
+			RootHash   string   `json:"rootHash"` // Hex
+			TreeSize   int64    `json:"treeSize"`
+			Hashes     []string `json:"hashes"` // Hex
+			Checkpoint string   `json:"checkpoint"`
+		} `json:"inclusionProof"`
+	} `json:"verification"`
+}
+
+// hashedRekord is the body of a Rekor entry for a signed artifact
+type hashedRekord struct {
+	Kind       string `json:"kind"`
+	APIVersion string `json:"apiVersion"`
+	Spec       struct {
+		Data struct {
+			Hash struct {
+				Algorithm string `json:"algorithm"`
+				Value     string `json:"value"`
+			} `json:"hash"`
+		} `json:"data"`
+		Signature struct {
+			Content   []byte `json:"content"`
+			PublicKey struct {
+				Content []byte `json:"content"` // PEM public key or certificate
+			} `json:"publicKey"`
+		} `json:"signature"`
+	} `json:"spec"`
+}
+
+// verifyTransparencyLog checks that sig, made with key, is in the Rekor log
+// of req
+func (req SignatureRequirement) verifyTransparencyLog(ctx context.Context, sig cosignSignature, key crypto.PublicKey) (*TransparencyLogEntry, error) {
+	logKey, err := readPublicKey(req.RekorPublicKey)
+	if err != nil {
+		return nil, fmt.Errorf("rekor: %w", err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(logKey)
+	if err != nil {
+		return nil, fmt.Errorf("rekor: %w", err)
+	}
+	logID := sha256.Sum256(der)
+
+	var bundle *rekorBundle
+	if sig.Bundle != "" {
+		bundle = &rekorBundle{}
+		if err := json.Unmarshal([]byte(sig.Bundle), bundle); err != nil {
+			return nil, fmt.Errorf("invalid rekor bundle: %w", err)
+		}
+	}
+
+	var entry *TransparencyLogEntry
+	switch {
+	case req.RekorURL != "":
+		entry, err = fetchRekorEntry(ctx, req.RekorURL, bundle, sig, key, logKey)
+	case bundle != nil:
+		err = verifyRekorSET(logKey, bundle.Payload, bundle.SignedEntryTimestamp)
+		if err == nil {
+			err = checkRekorBody(bundle.Payload.Body, sig, key)
+		}
+		entry = &TransparencyLogEntry{Verification: TransparencyLogBundle}
+		entry.LogIndex, entry.LogID = bundle.Payload.LogIndex, bundle.Payload.LogID
+		entry.IntegratedTime = time.Unix(bundle.Payload.IntegratedTime, 0).UTC()
+	default:
+		return nil, fmt.Errorf("signature has no rekor bundle")
+	}
+	if err != nil {
+		return nil, err
+	}
+	if entry.LogID != hex.EncodeToString(logID[:]) {
+		return nil, fmt.Errorf("rekor entry is from log %s, not %s", entry.LogID, hex.EncodeToString(logID[:]))
+	}
+	return entry, nil
+}
+
+// verifyRekorSET checks the signed entry timestamp set of payload with the
+// log's key
+func verifyRekorSET(logKey crypto.PublicKey, payload rekorSETPayload, set []byte) error {
+	canonical, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	if err := verifySignature(logKey, canonical, set); err != nil {
+		return fmt.Errorf("rekor signed entry timestamp: %w", err)
+	}
+	return nil
+}
+
+// checkRekorBody checks that the base64 entry body records sig made with key
+func checkRekorBody(body string, sig cosignSignature, key crypto.PublicKey) error {
+	data, err := base64.StdEncoding.DecodeString(body)
+	if err != nil {
+		return fmt.Errorf("invalid rekor entry: %w", err)
+	}
+	var rekord hashedRekord
+	if err := json.Unmarshal(data, &rekord); err != nil {
+		return fmt.Errorf("invalid rekor entry: %w", err)
+	}
+	if rekord.Kind != "hashedrekord" {
+		return fmt.Errorf("unsupported rekor entry kind %q", rekord.Kind)
+	}
+	hash := sha256.Sum256(sig.Payload)
+	if rekord.Spec.Data.Hash.Algorithm != "sha256" || rekord.Spec.Data.Hash.Value != hex.EncodeToString(hash[:]) {
+		return fmt.Errorf("rekor entry is for another payload")
+	}
+	if !bytes.Equal(rekord.Spec.Signature.Content, sig.Signature) {
+		return fmt.Errorf("rekor entry is for another signature")
+	}
+	entryKey, err := rekordPublicKey(rekord.Spec.Signature.PublicKey.Content)
+	if err != nil {
+		return err
+	}
+	want, err := x509.MarshalPKIXPublicKey(key)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(entryKey, want) {
+		return fmt.Errorf("rekor entry is for another key")
+	}
+	return nil
+}
+
+// rekordPublicKey returns the PKIX public key of the PEM public key or
+// certificate of a rekor entry
+func rekordPublicKey(data []byte) ([]byte, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("invalid public key in rekor entry")
+	}
+	if block.Type != "CERTIFICATE" {
+		return block.Bytes, nil
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("invalid certificate in rekor entry: %w", err)
+	}
+	return cert.RawSubjectPublicKeyInfo, nil
+}
+
+// fetchRekorEntry looks up the entry of sig in the log at baseURL, by the
+// index of its bundle or by its payload hash, and verifies its timestamp and
+// inclusion proof
+func fetchRekorEntry(ctx context.Context, baseURL string, bundle *rekorBundle, sig cosignSignature, key, logKey crypto.PublicKey) (*TransparencyLogEntry, error) {
+	baseURL = strings.TrimSuffix(baseURL, "/")
+	var entries map[string]rekorLogEntry
+	if bundle != nil {
+		if err := rekorRequest(ctx, http.MethodGet, baseURL+"/api/v1/log/entries?logIndex="+strconv.FormatInt(bundle.Payload.LogIndex, 10), nil, &entries); err != nil {
+			return nil, err
+		}
+	} else {
+		hash := sha256.Sum256(sig.Payload)
+		var uuids []string
+		query := map[string]string{"hash": "sha256:" + hex.EncodeToString(hash[:])}
+		if err := rekorRequest(ctx, http.MethodPost, baseURL+"/api/v1/index/retrieve", query, &uuids); err != nil {
+			return nil, err
+		}
+		entries = make(map[string]rekorLogEntry)
+		for _, uuid := range uuids {
+			var found map[string]rekorLogEntry
+			if err := rekorRequest(ctx, http.MethodGet, baseURL+"/api/v1/log/entries/"+uuid, nil, &found); err != nil {
+				return nil, err
+			}
+			for k, v := range found {
+				entries[k] = v
+			}
+		}
+	}
+
+	var errs []error
+	for _, e := range entries {
+		entry, err := verifyRekorEntry(e, sig, key, logKey)
+		if err == nil {
+			return entry, nil
+		}
+		errs = append(errs, err)
+	}
+	if len(errs) == 0 {
+		return nil, fmt.Errorf("signature is not in the rekor log %s", baseURL)
+	}
+	return nil, errors.Join(errs...)
+}
+
+// rekorRequest sends a request with the JSON body in to the Rekor API and
+// decodes the JSON response into out
+func rekorRequest(ctx context.Context, method, url string, in, out any) error {
+	var body io.Reader
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, url, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := rekorClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("rekor: %w", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode == http.StatusNotFound {
+		return fmt.Errorf("signature is not in the rekor log")
+	} else if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("rekor: %s %s: %s", method, url, resp.Status)
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 16<<20)).Decode(out); err != nil {
+		return fmt.Errorf("rekor: invalid response: %w", err)
+	}
+	return nil
+}
+
+// verifyRekorEntry checks an entry returned by the log: that it records sig,
+// that the log signed it and that it is in the tree of a checkpoint the log
+// signed
+func verifyRekorEntry(e rekorLogEntry, sig cosignSignature, key, logKey crypto.PublicKey) (*TransparencyLogEntry, error) {
+	if err := checkRekorBody(e.Body, sig, key); err != nil {
+		return nil, err
+	}
+	payload := rekorSETPayload{Body: e.Body, IntegratedTime: e.IntegratedTime, LogID: e.LogID, LogIndex: e.LogIndex}
+	if err := verifyRekorSET(logKey, payload, e.Verification.SignedEntryTimestamp); err != nil {
+		return nil, err
+	}
+
+	proof := e.Verification.InclusionProof
+	if proof == nil {
+		return nil, fmt.Errorf("rekor entry %d has no inclusion proof", e.LogIndex)
+	}
+	body, err := base64.StdEncoding.DecodeString(e.Body)
+	if err != nil {
+		return nil, fmt.Errorf("invalid rekor entry: %w", err)
+	}
+	var hashes [][]byte
+	for _, h := range proof.Hashes {
+		b, err := hex.DecodeString(h)
+		if err != nil {
+			return nil, fmt.Errorf("invalid rekor inclusion proof: %w", err)
+		}
+		hashes = append(hashes, b)
+	}
+	root, err := merkleRootFromProof(proof.LogIndex, proof.TreeSize, merkleLeafHash(body), hashes)
+	if err != nil {
+		return nil, err
+	}
+	if hex.EncodeToString(root) != proof.RootHash {
+		return nil, fmt.Errorf("rekor inclusion proof does not lead to root %s", proof.RootHash)
+	}
+	size, checkpointRoot, err := verifyCheckpoint(proof.Checkpoint, logKey)
+	if err != nil {
+		return nil, err
+	}
+	if size != proof.TreeSize || !bytes.Equal(checkpointRoot, root) {
+		return nil, fmt.Errorf("rekor inclusion proof is not for the signed checkpoint")
+	}
+	return &TransparencyLogEntry{
+		LogIndex:       e.LogIndex,
+		LogID:          e.LogID,
+		IntegratedTime: time.Unix(e.IntegratedTime, 0).UTC(),
+		Verification:   TransparencyLogOnline,
+		TreeSize:       proof.TreeSize,
+	}, nil
+}
+
+// merkleLeafHash returns the RFC 6962 hash of a leaf
+func merkleLeafHash(leaf []byte) []byte {
+	h := sha256.Sum256(append([]byte{0}, leaf...))
+	return h[:]
+}
+
+// merkleNodeHash returns the RFC 6962 hash of an interior node
+func merkleNodeHash(left, right []byte) []byte {
+	h := sha256.Sum256(append(append([]byte{1}, left...), right...))
+	return h[:]
+}
+
+// merkleRootFromProof computes the root of a tree of size leaves from the
+// hash of the leaf at index and its inclusion proof (RFC 9162 2.1.3.2)
+func merkleRootFromProof(index, size int64, leaf []byte, proof [][]byte) ([]byte, error) {
+	if index < 0 || index >= size {
+		return nil, fmt.Errorf("rekor inclusion proof index %d is outside a tree of %d", index, size)
+	}
+	fn, sn := index, size-1
+	r := leaf
+	for _, p := range proof {
+		if sn == 0 {
+			return nil, fmt.Errorf("rekor inclusion proof is too long")
+		}
+		if fn&1 == 1 || fn == sn {
+			r = merkleNodeHash(p, r)
+			for fn&1 == 0 && fn != 0 {
+				fn >>= 1
+				sn >>= 1
+			}
+		} else {
+			r = merkleNodeHash(r, p)
+		}
+		fn >>= 1
+		sn >>= 1
+	}
+	if sn != 0 {
+		return nil, fmt.Errorf("rekor inclusion proof is too short")
+	}
+	return r, nil
+}
+
+// verifyCheckpoint checks the signed note of a log checkpoint with the log's
+// key and returns the tree size and root hash it commits to
+func verifyCheckpoint(checkpoint string, logKey crypto.PublicKey) (int64, []byte, error) {
+	text, signatures, ok := strings.Cut(checkpoint, "\n\n")
+	if !ok {
+		return 0, nil, fmt.Errorf("invalid rekor checkpoint")
+	}
+	text += "\n"
+	lines := strings.Split(text, "\n")
+	if len(lines) < 3 {
+		return 0, nil, fmt.Errorf("invalid rekor checkpoint")
+	}
+	size, err := strconv.ParseInt(lines[1], 10, 64)
+	if err != nil {
+		return 0, nil, fmt.Errorf("invalid rekor checkpoint size: %w", err)
+	}
+	root, err := base64.StdEncoding.DecodeString(lines[2])
+	if err != nil {
+		return 0, nil, fmt.Errorf("invalid rekor checkpoint root: %w", err)
+	}
+
+	// Signature lines are "— <name> <base64 of key hint and signature>"
+	for _, line := range strings.Split(signatures, "\n") {
+		fields := strings.Fields(strings.TrimPrefix(line, "— "))
+		if len(fields) != 2 {
+			continue
+		}
+		sig, err := base64.StdEncoding.DecodeString(fields[1])
+		if err != nil || len(sig) <= 4 {
+			continue
+		}
+		if verifySignature(logKey, []byte(text), sig[4:]) == nil {
+			return size, root, nil
+		}
+	}
+	return 0, nil, fmt.Errorf("rekor checkpoint is not signed by the log")
+}
@@ -0,0 +1,355 @@
+package pkg
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/name"
+)
+
+// testRekor is a transparency log keeping its entries in memory
+type testRekor struct {
+	key     *ecdsa.PrivateKey
+	keyPath string
+	logID   string
+	bodies  []string // Base64 entries by index
+	times   []int64
+	now     time.Time // Integration time of new entries
+}
+
+func newTestRekor(t *testing.T) *testRekor {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := sha256.Sum256(der)
+	r := &testRekor{key: key, keyPath: filepath.Join(t.TempDir(), "rekor.pub"), logID: hex.EncodeToString(id[:]), now: time.Now().Add(-55 * time.Minute)}
+	if err := os.WriteFile(r.keyPath, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+// signData signs data with the log's key
+func (r *testRekor) signData(t *testing.T, data []byte) []byte {
+	t.Helper()
+	hash := sha256.Sum256(data)
+	sig, err := r.key.Sign(rand.Reader, hash[:], crypto.SHA256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sig
+}
+
+// add logs sig, made by signer, and returns its index
+func (r *testRekor) add(t *testing.T, signer *testSigner, sig cosignSignature) int {
+	t.Helper()
+	publicKey := []byte(signer.cert)
+	if signer.cert == "" {
+		der, err := x509.MarshalPKIXPublicKey(&signer.key.PublicKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		publicKey = pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+	}
+	hash := sha256.Sum256(sig.Payload)
+	body := fmt.Sprintf(`{"apiVersion":"0.0.1","kind":"hashedrekord","spec":{"data":{"hash":{"algorithm":"sha256","value":%q}},"signature":{"content":%q,"publicKey":{"content":%q}}}}`,
+		hex.EncodeToString(hash[:]), base64.StdEncoding.EncodeToString(sig.Signature), base64.StdEncoding.EncodeToString(publicKey))
+	r.bodies = append(r.bodies, base64.StdEncoding.EncodeToString([]byte(body)))
+	r.times = append(r.times, r.now.Unix())
+	return len(r.bodies) - 1
+}
+
+// setPayload returns what the signed entry timestamp of entry index signs
+func (r *testRekor) setPayload(index int) rekorSETPayload {
+	return rekorSETPayload{Body: r.bodies[index], IntegratedTime: r.times[index], LogID: r.logID, LogIndex: int64(index)}
+}
+
+// bundle logs sig and returns it with the bundle cosign would attach
+func (r *testRekor) bundle(t *testing.T, signer *testSigner, sig cosignSignature) cosignSignature {
+	t.Helper()
+	payload := r.setPayload(r.add(t, signer, sig))
+	canonical, _ := json.Marshal(payload)
+	data, err := json.Marshal(rekorBundle{SignedEntryTimestamp: r.signData(t, canonical), Payload: payload})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig.Bundle = string(data)
+	return sig
+}
+
+// leafHashes returns the Merkle leaf hashes of the first n entries
+func (r *testRekor) leafHashes(n int) [][]byte {
+	var leaves [][]byte
+	for _, body := range r.bodies[:n] {
+		data, _ := base64.StdEncoding.DecodeString(body)
+		leaves = append(leaves, merkleLeafHash(data))
+	}
+	return leaves
+}
+
+// testMerkleRoot is the RFC 6962 Merkle tree hash of leaves
+func testMerkleRoot(leaves [][]byte) []byte {
+	if len(leaves) == 1 {
+		return leaves[0]
+	}
+	k := 1
+	for k*2 < len(leaves) {
+		k *= 2
+	}
+	return merkleNodeHash(testMerkleRoot(leaves[:k]), testMerkleRoot(leaves[k:]))
+}
+
+// testMerkleProof is the RFC 6962 audit path of leaf index in leaves
+func testMerkleProof(index int, leaves [][]byte) [][]byte {
+	if len(leaves) == 1 {
+		return nil
+	}
+	k := 1
+	for k*2 < len(leaves) {
+		k *= 2
+	}
+	if index < k {
+		return append(testMerkleProof(index, leaves[:k]), testMerkleRoot(leaves[k:]))
+	}
+	return append(testMerkleProof(index-k, leaves[k:]), testMerkleRoot(leaves[:k]))
+}
+
+// entry returns entry index the way the Rekor API does, with an inclusion
+// proof against a checkpoint of the whole log
+func (r *testRekor) entry(t *testing.T, index int) map[string]any {
+	t.Helper()
+	size := len(r.bodies)
+	leaves := r.leafHashes(size)
+	root := testMerkleRoot(leaves)
+	var hashes []string
+	for _, h := range testMerkleProof(index, leaves) {
+		hashes = append(hashes, hex.EncodeToString(h))
+	}
+	note := fmt.Sprintf("test.rekor - 1\n%d\n%s\n", size, base64.StdEncoding.EncodeToString(root))
+	noteSig := append([]byte{1, 2, 3, 4}, r.signData(t, []byte(note))...)
+	canonical, _ := json.Marshal(r.setPayload(index))
+	return map[string]any{
+		"body":           r.bodies[index],
+		"integratedTime": r.times[index],
+		"logID":          r.logID,
+		"logIndex":       index,
+		"verification": map[string]any{
+			"signedEntryTimestamp": r.signData(t, canonical),
+			"inclusionProof": map[string]any{
+				"logIndex":   index,
+				"rootHash":   hex.EncodeToString(root),
+				"treeSize":   size,
+				"hashes":     hashes,
+				"checkpoint": note + "\n— test.rekor " + base64.StdEncoding.EncodeToString(noteSig) + "\n",
+			},
+		},
+	}
+}
+
+// serve serves the log's API
+func (r *testRekor) serve(t *testing.T) string {
+	t.Helper()
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/v1/log/entries", func(w http.ResponseWriter, req *http.Request) {
+		index, err := strconv.Atoi(req.URL.Query().Get("logIndex"))
+		if err != nil || index < 0 || index >= len(r.bodies) {
+			http.NotFound(w, req)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]any{fmt.Sprint(index): r.entry(t, index)})
+	})
+	mux.HandleFunc("GET /api/v1/log/entries/{uuid}", func(w http.ResponseWriter, req *http.Request) {
+		index, err := strconv.Atoi(req.PathValue("uuid"))
+		if err != nil || index < 0 || index >= len(r.bodies) {
+			http.NotFound(w, req)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]any{req.PathValue("uuid"): r.entry(t, index)})
+	})
+	mux.HandleFunc("POST /api/v1/index/retrieve", func(w http.ResponseWriter, req *http.Request) {
+		var query struct {
+			Hash string `json:"hash"`
+		}
+		if err := json.NewDecoder(req.Body).Decode(&query); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		uuids := []string{}
+		for i, body := range r.bodies {
+			data, _ := base64.StdEncoding.DecodeString(body)
+			if strings.Contains(string(data), strings.TrimPrefix(query.Hash, "sha256:")) {
+				uuids = append(uuids, fmt.Sprint(i))
+			}
+		}
+		_ = json.NewEncoder(w).Encode(uuids)
+	})
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server.URL
+}
+
+func TestMerkleRootFromProof(t *testing.T) {
+	for size := 1; size <= 12; size++ {
+		var leaves [][]byte
+		for i := range size {
+			leaves = append(leaves, merkleLeafHash([]byte{byte(i)}))
+		}
+		root := testMerkleRoot(leaves)
+		for index := range size {
+			proof := testMerkleProof(index, leaves)
+			got, err := merkleRootFromProof(int64(index), int64(size), leaves[index], proof)
+			if err != nil || hex.EncodeToString(got) != hex.EncodeToString(root) {
+				t.Errorf("merkleRootFromProof(%d, %d) = %x, %v, want %x", index, size, got, err, root)
+			}
+			if size > 1 {
+				other := leaves[(index+1)%size]
+				if got, _ := merkleRootFromProof(int64(index), int64(size), other, proof); hex.EncodeToString(got) == hex.EncodeToString(root) {
+					t.Errorf("merkleRootFromProof(%d, %d) of another leaf leads to the root", index, size)
+				}
+				if _, err := merkleRootFromProof(int64(index), int64(size), leaves[index], proof[1:]); err == nil {
+					t.Errorf("merkleRootFromProof(%d, %d) with a short proof succeeded", index, size)
+				}
+			}
+		}
+	}
+	if _, err := merkleRootFromProof(3, 3, merkleLeafHash(nil), nil); err == nil {
+		t.Error("merkleRootFromProof() outside the tree succeeded")
+	}
+}
+
+func TestRekorBundle(t *testing.T) {
+	imageRef, repo, digest := pushSignableImage(t)
+	ref, _ := name.ParseReference(imageRef)
+	signer := newKeylessTestSigner(t, t.TempDir(), "builder@example.com", "https://accounts.example.com")
+	log := newTestRekor(t)
+	req := SignatureRequirement{Scope: repo, CertificateIdentity: "builder@example.com", CertificateOIDCIssuer: "https://accounts.example.com",
+		CertificateRoots: signer.rootPath, RekorPublicKey: log.keyPath}
+
+	sig := signer.sign(t, repo, digest)
+	if _, err := req.verify(t.Context(), ref, digest, []cosignSignature{sig}); err == nil || !strings.Contains(err.Error(), "no rekor bundle") {
+		t.Errorf("verify() of an unlogged signature error = %v, want no rekor bundle", err)
+	}
+
+	logged := log.bundle(t, signer, sig)
+	v, err := req.verify(t.Context(), ref, digest, []cosignSignature{logged})
+	if err != nil {
+		t.Fatalf("verify() error = %v", err)
+	}
+	if tlog := v.TransparencyLog; tlog == nil || tlog.Verification != TransparencyLogBundle || tlog.LogIndex != 0 || tlog.LogID != log.logID {
+		t.Errorf("verify() transparency log = %+v", tlog)
+	}
+
+	// A bundle of another log, of another signature, or from after the
+	// certificate expired does not count
+	otherLog := newTestRekor(t)
+	otherSig := signer.sign(t, repo, digest)
+	otherSig.Bundle = log.bundle(t, signer, signer.sign(t, repo, digest)).Bundle
+	log.now = time.Now()
+	for name, s := range map[string]cosignSignature{
+		"another log":       otherLog.bundle(t, signer, sig),
+		"another signature": otherSig,
+		"an expired cert":   log.bundle(t, signer, sig),
+	} {
+		if _, err := req.verify(t.Context(), ref, digest, []cosignSignature{s}); err == nil {
+			t.Errorf("verify() with a bundle of %s succeeded", name)
+		}
+	}
+}
+
+func TestRekorOnline(t *testing.T) {
+	imageRef, repo, digest := pushSignableImage(t)
+	ref, _ := name.ParseReference(imageRef)
+	signer := newTestSigner(t)
+	log := newTestRekor(t)
+	keyPath := signer.writePublicKey(t, t.TempDir())
+	setupPolicyPath(t, `{"signatures": [{"scope": "`+repo+`", "key_path": "`+keyPath+`", "rekor_public_key": "`+log.keyPath+`", "rekor_url": "`+log.serve(t)+`"}]}`)
+
+	// Other entries make the inclusion proof non-trivial
+	for range 4 {
+		log.add(t, signer, signer.sign(t, repo, "sha256:"+strings.Repeat("0", 64)))
+	}
+	sig := signer.sign(t, repo, digest)
+	pushTestSignatures(t, imageRef, sig)
+	if _, err := CheckImagePolicy(t.Context(), imageRef); ErrorCode(err) != CodePolicyViolation {
+		t.Errorf("CheckImagePolicy() of an unlogged signature error = %v, want %s", err, CodePolicyViolation)
+	}
+
+	// Found by payload hash without a bundle
+	log.add(t, signer, sig)
+	for range 2 {
+		log.add(t, signer, signer.sign(t, repo, "sha256:"+strings.Repeat("1", 64)))
+	}
+	StartResult(OperationUpdate)
+	_, err := CheckImagePolicy(t.Context(), imageRef)
+	r := FinishResult("success", err)
+	if err != nil {
+		t.Fatalf("CheckImagePolicy() error = %v", err)
+	}
+	if len(r.Signatures) != 1 || r.Signatures[0].TransparencyLog == nil {
+		t.Fatalf("result signatures = %+v, want a transparency log entry", r.Signatures)
+	}
+	if tlog := r.Signatures[0].TransparencyLog; tlog.Verification != TransparencyLogOnline || tlog.LogIndex != 4 || tlog.TreeSize != 7 {
+		t.Errorf("result transparency log = %+v, want entry 4 of 7 verified online", tlog)
+	}
+	if e := historyEntry(r); len(e.Signatures) != 1 || e.Signatures[0].Signer != keyPath {
+		t.Errorf("history signatures = %+v", e.Signatures)
+	}
+
+	// Found by the index of its bundle
+	pushTestSignatures(t, imageRef, log.bundle(t, signer, sig))
+	signatures, err := fetchCosignSignatures(t.Context(), ref, digest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := SignatureRequirement{Scope: repo, KeyPath: keyPath, RekorPublicKey: log.keyPath, RekorURL: log.serve(t)}
+	if v, err := req.verify(t.Context(), ref, digest, signatures); err != nil || v.TransparencyLog.LogIndex != 7 {
+		t.Errorf("verify() with a bundle = %+v, %v, want entry 7", v, err)
+	}
+
+	// A checkpoint signed by another key does not count
+	req.RekorPublicKey = newTestRekor(t).keyPath
+	if _, err := req.verify(t.Context(), ref, digest, signatures); err == nil {
+		t.Error("verify() against another log's key succeeded")
+	}
+}
+
+func TestVerifyCheckpoint(t *testing.T) {
+	log := newTestRekor(t)
+	note := "test.rekor - 1\n5\n" + base64.StdEncoding.EncodeToString([]byte("root")) + "\n"
+	sig := base64.StdEncoding.EncodeToString(append([]byte{1, 2, 3, 4}, log.signData(t, []byte(note))...))
+	key, err := readPublicKey(log.keyPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	size, root, err := verifyCheckpoint(note+"\n— test.rekor "+sig+"\n", key)
+	if err != nil || size != 5 || string(root) != "root" {
+		t.Errorf("verifyCheckpoint() = %d, %q, %v", size, root, err)
+	}
+	tampered := strings.Replace(note, "\n5\n", "\n6\n", 1)
+	if _, _, err := verifyCheckpoint(tampered+"\n— test.rekor "+sig+"\n", key); err == nil {
+		t.Error("verifyCheckpoint() of a tampered checkpoint succeeded")
+	}
+}
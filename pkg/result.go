@@ -0,0 +1,170 @@
+package pkg
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// EventResult is the type of the event carrying the final result document
+const EventResult = "result"
+
+// OperationResult summarizes a finished install or update for provisioning
+// systems to archive
+type OperationResult struct {
+	Operation            string                  `json:"operation"`
+	Status               string                  `json:"status"`
+	Error                string                  `json:"error,omitempty"`
+	ErrorCode            string                  `json:"error_code,omitempty"`
+	ImageRef             string                  `json:"image_ref,omitempty"`
+	ImageDigest          string                  `json:"image_digest,omitempty"`
+	PreviousImageDigest  string                  `json:"previous_image_digest,omitempty"` // Default image before the operation
+	ImageVersion         string                  `json:"image_version,omitempty"`
+	PreviousImageVersion string                  `json:"previous_image_version,omitempty"`
+	Device               string                  `json:"device,omitempty"`
+	Partitions           *PartitionScheme        `json:"partitions,omitempty"`
+	TargetSlot           string                  `json:"target_slot,omitempty"` // root1 or root2
+	TargetPartition      string                  `json:"target_partition,omitempty"`
+	KernelVersion        string                  `json:"kernel_version,omitempty"`
+	Extract              *ExtractStats           `json:"extract,omitempty"`
+	Signatures           []SignatureVerification `json:"signatures,omitempty"`   // Image policy signatures that were verified
+	VarSnapshot          string                  `json:"var_snapshot,omitempty"` // /var snapshot an update took or a rollback restores: lvm or btrfs
+	StartTime            time.Time               `json:"start_time"`
+	EndTime              time.Time               `json:"end_time"`
+	DurationSeconds      float64                 `json:"duration_seconds"`
+	Phases               []PhaseResult           `json:"phases,omitempty"`
+	Initiator            string                  `json:"initiator,omitempty"` // Who asked for the operation
+
+	changesSystem bool // Set by markChangesSystem
+}
+
+// PhaseResult is the duration of one workflow step
+type PhaseResult struct {
+	Step            string  `json:"step,omitempty"` // "N/M"
+	Name            string  `json:"name"`
+	DurationSeconds float64 `json:"duration_seconds"`
+
+	start time.Time
+}
+
+// currentResult collects the document of the running operation, nil when none
+// was started with StartResult
+var currentResult *OperationResult
+
+// StartResult starts collecting the result document of operation
+func StartResult(operation string) {
+	currentResult = &OperationResult{Operation: operation, StartTime: time.Now()}
+}
+
+// recordResult updates the result document if one is being collected
+func recordResult(update func(r *OperationResult)) {
+	if currentResult != nil {
+		update(currentResult)
+	}
+}
+
+// markChangesSystem marks the running operation as one that changes this
+// machine, so RecordMetrics and NotifyWebhooks report its outcome. Updates
+// and rollbacks call it unless they are dry runs.
+func markChangesSystem() {
+	recordResult(func(r *OperationResult) { r.changesSystem = true })
+}
+
+// recordPhase ends the current phase and starts a new one
+func recordPhase(step, name string) {
+	recordResult(func(r *OperationResult) {
+		now := time.Now()
+		r.endPhase(now)
+		r.Phases = append(r.Phases, PhaseResult{Step: step, Name: name, start: now})
+	})
+}
+
+// endPhase sets the duration of the last phase if it is still running
+func (r *OperationResult) endPhase(now time.Time) {
+	if n := len(r.Phases); n > 0 && !r.Phases[n-1].start.IsZero() {
+		r.Phases[n-1].DurationSeconds = now.Sub(r.Phases[n-1].start).Seconds()
+		r.Phases[n-1].start = time.Time{}
+	}
+}
+
+// recordKernelVersion records the newest kernel in /usr/lib/modules of root
+func recordKernelVersion(root string) {
+	if versions, err := listKernelVersions(root); err == nil && len(versions) > 0 {
+		recordResult(func(r *OperationResult) { r.KernelVersion = versions[len(versions)-1] })
+	}
+}
+
+// FinishResult completes the result document with the outcome and returns
+// it, or nil if StartResult was not called
+func FinishResult(status string, err error) *OperationResult {
+	r := currentResult
+	currentResult = nil
+	if r == nil {
+		return nil
+	}
+	r.EndTime = time.Now()
+	r.DurationSeconds = r.EndTime.Sub(r.StartTime).Seconds()
+	r.endPhase(r.EndTime)
+	r.Status = status
+	if err != nil {
+		r.Error = err.Error()
+		r.ErrorCode = ErrorCode(err)
+	}
+	return r
+}
+
+// ReportResult emits the result document as a result event if a reporter is set
+func ReportResult(r *OperationResult) {
+	if progressReporter == nil || r == nil {
+		return
+	}
+	progressReporter.Report(ProgressEvent{
+		Type:   EventResult,
+		Time:   r.EndTime,
+		Phase:  r.Operation,
+		Status: r.Status,
+		Result: r,
+	})
+}
+
+// WriteFile writes the result document as indented JSON to path, replacing it
+// atomically
+func (r *OperationResult) WriteFile(path string) error {
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode result: %w", err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return fmt.Errorf("failed to write result file: %w", err)
+	}
+	defer func() { _ = os.Remove(tmp.Name()) }()
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		_ = tmp.Close()
+		return fmt.Errorf("failed to write result file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write result file: %w", err)
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return fmt.Errorf("failed to write result file: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to write result file: %w", err)
+	}
+	return nil
+}
+
+// stepHeading splits a "Step N/M: Doing something..." heading into "N/M" and
+// "Doing something"
+func stepHeading(line string) (step, name string, ok bool) {
+	var n, total int
+	if _, err := fmt.Sscanf(line, "Step %d/%d:", &n, &total); err != nil {
+		return "", "", false
+	}
+	_, name, _ = strings.Cut(line, ":")
+	return fmt.Sprintf("%d/%d", n, total), strings.TrimSuffix(strings.TrimSpace(name), "..."), true
+}
@@ -0,0 +1,94 @@
+package pkg
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestStepHeading(t *testing.T) {
+	tests := []struct {
+		line, step, name string
+		ok               bool
+	}{
+		{"Step 1/6: Creating partitions...", "1/6", "Creating partitions", true},
+		{"Step 7/7: Updating bootloader configuration...", "7/7", "Updating bootloader configuration", true},
+		{"Cleaning up...", "", "", false},
+	}
+	for _, tt := range tests {
+		step, name, ok := stepHeading(tt.line)
+		if step != tt.step || name != tt.name || ok != tt.ok {
+			t.Errorf("stepHeading(%q) = (%q, %q, %v), want (%q, %q, %v)", tt.line, step, name, ok, tt.step, tt.name, tt.ok)
+		}
+	}
+}
+
+func TestOperationResult(t *testing.T) {
+	root := t.TempDir()
+	for _, v := range []string{"6.11.0-1.fc41.x86_64", "6.12.4-200.fc41.x86_64"} {
+		if err := os.MkdirAll(filepath.Join(root, "usr/lib/modules", v), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Nothing is recorded before StartResult
+	recordResult(func(r *OperationResult) { t.Error("recorded without a result document") })
+	if FinishResult(StatusSuccess, nil) != nil {
+		t.Error("FinishResult without StartResult should return nil")
+	}
+
+	StartResult("install")
+	recordResult(func(r *OperationResult) { r.ImageRef = "quay.io/example/os:latest" })
+	printStep("Step 1/6: Creating partitions...")
+	printStep("\nStep 2/6: Formatting partitions...")
+	recordKernelVersion(root)
+	r := FinishResult(StatusFailed, ErrAborted)
+
+	if r.Operation != "install" || r.Status != StatusFailed || r.ErrorCode != CodeAborted {
+		t.Errorf("unexpected outcome: %+v", r)
+	}
+	if r.ImageRef != "quay.io/example/os:latest" || r.KernelVersion != "6.12.4-200.fc41.x86_64" {
+		t.Errorf("unexpected details: %+v", r)
+	}
+	if len(r.Phases) != 2 || r.Phases[1].Step != "2/6" || r.Phases[1].Name != "Formatting partitions" {
+		t.Fatalf("unexpected phases: %+v", r.Phases)
+	}
+	for _, p := range r.Phases {
+		if !p.start.IsZero() {
+			t.Errorf("phase %q not finished", p.Name)
+		}
+	}
+	if r.EndTime.Before(r.StartTime) {
+		t.Errorf("end time %v before start time %v", r.EndTime, r.StartTime)
+	}
+
+	path := filepath.Join(t.TempDir(), "result.json")
+	if err := r.WriteFile(path); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded OperationResult
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatalf("result file is not valid JSON: %v\n%s", err, data)
+	}
+	if decoded.KernelVersion != r.KernelVersion || len(decoded.Phases) != 2 {
+		t.Errorf("decoded result = %+v", decoded)
+	}
+
+	var buf bytes.Buffer
+	SetProgressReporter(NewJSONProgressReporter(&buf))
+	t.Cleanup(func() { SetProgressReporter(nil) })
+	ReportResult(r)
+	var event ProgressEvent
+	if err := json.Unmarshal(buf.Bytes(), &event); err != nil {
+		t.Fatal(err)
+	}
+	if event.Type != EventResult || event.Result == nil || event.Result.Operation != "install" {
+		t.Errorf("unexpected result event: %s", buf.String())
+	}
+}
@@ -0,0 +1,151 @@
+package pkg
+
+import (
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// Installing into existing partitions
+//
+// --reuse-partitions and --preserve-data install without writing a new
+// partition table. The partitions are either the ones phukit created before,
+// found by the GPT names it gave them, or partitions the user maps to phukit's
+// roles, for dual-boot disks and partitioning managed by other tools. Only the
+// partitions phukit owns are formatted: an EFI system partition mapped from
+// another system is shared rather than formatted, and --preserve-data also
+// keeps /var, which holds /home, and swap. The disk itself is never wiped.
+
+// partitionRoles are the roles of the partitions of a phukit install
+var partitionRoles = []string{"boot", "root1", "root2", "var", "swap"}
+
+// keptPartitions are the partitions of a scheme an install leaves as they are
+type keptPartitions struct {
+	boot bool // EFI system partition shared with other systems
+	data bool // /var and swap
+}
+
+// NamedPartitionScheme returns the partitions of the phukit install on
+// device, found by their GPT names. verity requires the hash tree partitions
+// of a verity install. It also returns the size of the swap partition, or 0
+// if there is none.
+func NamedPartitionScheme(device string, verity bool) (*PartitionScheme, uint64, error) {
+	parts, err := partitionsByLabel(device)
+	if err != nil {
+		return nil, 0, err
+	}
+	scheme, err := namedPartitionScheme(device, parts, verity)
+	if err != nil {
+		return nil, 0, err
+	}
+	var swapSize uint64
+	if scheme.SwapPartition != "" {
+		if swapSize, _, _, err = diskGeometry(scheme.SwapPartition); err != nil {
+			return nil, 0, err
+		}
+	}
+	return scheme, swapSize, nil
+}
+
+// namedPartitionScheme returns the partition scheme of the partitions of
+// device by GPT name, with the hash tree partitions only for verity
+func namedPartitionScheme(device string, parts map[string]string, verity bool) (*PartitionScheme, error) {
+	scheme, err := labeledPartitionScheme(device, parts)
+	if err != nil {
+		return nil, err
+	}
+	if !verity {
+		scheme.Root1HashPartition, scheme.Root2HashPartition = "", ""
+	} else if scheme.Root1HashPartition == "" {
+		return nil, fmt.Errorf("%w: %s has no dm-verity hash partitions; install without --verity", ErrPartitionSchemeMismatch, device)
+	}
+	return scheme, nil
+}
+
+// MappedPartitionScheme returns the partition scheme of the partitions of
+// device given as role=partition, such as root1=/dev/sda5. boot, root1, root2
+// and var are required and swap is optional. It also returns the size of the
+// swap partition, or 0 if there is none.
+func MappedPartitionScheme(device string, mapping []string) (*PartitionScheme, uint64, error) {
+	parts, err := parsePartitionMap(mapping)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	seen := map[string]string{}
+	for _, role := range partitionRoles {
+		part, ok := parts[role]
+		if !ok {
+			continue
+		}
+		resolved, err := filepath.EvalSymlinks(part)
+		if err != nil {
+			return nil, 0, fmt.Errorf("%w: %s partition %s: %v", ErrDeviceNotFound, role, part, err)
+		}
+		if other, ok := seen[resolved]; ok {
+			return nil, 0, fmt.Errorf("%s is mapped as both %s and %s", part, other, role)
+		}
+		seen[resolved] = role
+		if disk, err := GetBootDeviceFromPartition(resolved); err != nil || disk != device || resolved == device {
+			return nil, 0, fmt.Errorf("%s partition %s is not a partition of %s", role, part, device)
+		}
+		parts[role] = canonicalDevicePath(resolved)
+	}
+
+	scheme := &PartitionScheme{
+		BootPartition:  parts["boot"],
+		Root1Partition: parts["root1"],
+		Root2Partition: parts["root2"],
+		VarPartition:   parts["var"],
+		SwapPartition:  parts["swap"],
+	}
+	var swapSize uint64
+	if scheme.SwapPartition != "" {
+		if swapSize, _, _, err = diskGeometry(scheme.SwapPartition); err != nil {
+			return nil, 0, err
+		}
+	}
+	return scheme, swapSize, nil
+}
+
+// parsePartitionMap parses role=partition mappings, checking that every
+// required role is mapped once
+func parsePartitionMap(mapping []string) (map[string]string, error) {
+	parts := map[string]string{}
+	for _, m := range mapping {
+		role, part, ok := strings.Cut(m, "=")
+		if !ok || part == "" {
+			return nil, fmt.Errorf("invalid partition mapping %q (expected role=partition)", m)
+		}
+		if !slices.Contains(partitionRoles, role) {
+			return nil, fmt.Errorf("unknown partition role %q (supported: %s)", role, strings.Join(partitionRoles, ", "))
+		}
+		if _, ok := parts[role]; ok {
+			return nil, fmt.Errorf("%s partition is mapped more than once", role)
+		}
+		parts[role] = part
+	}
+	for _, role := range partitionRoles[:4] {
+		if parts[role] == "" {
+			return nil, fmt.Errorf("no %s partition mapped (use %s=<partition>)", role, role)
+		}
+	}
+	return parts, nil
+}
+
+// formattedPartitions returns the partitions of scheme an install formats
+func (s *PartitionScheme) formattedPartitions(keep keptPartitions) []string {
+	var parts []string
+	if !keep.boot {
+		parts = append(parts, s.BootPartition)
+	}
+	parts = append(parts, s.Root1Partition, s.Root2Partition)
+	if !keep.data {
+		parts = append(parts, s.VarPartition)
+		if s.SwapPartition != "" {
+			parts = append(parts, s.SwapPartition)
+		}
+	}
+	return parts
+}
@@ -0,0 +1,132 @@
+package pkg
+
+import (
+	"errors"
+	"slices"
+	"testing"
+)
+
+func TestNamedPartitionScheme(t *testing.T) {
+	const gib = 1024 * 1024 * 1024
+	layoutParts := func(t *testing.T, device string, verity bool, swapSize uint64) map[string]string {
+		t.Helper()
+		table, err := buildGPTTable(64*gib, 512, 512, installGPTLayout(verity, swapSize))
+		if err != nil {
+			t.Fatalf("buildGPTTable() error = %v", err)
+		}
+		parts, err := tablePartitionLabels(device, table)
+		if err != nil {
+			t.Fatalf("tablePartitionLabels() error = %v", err)
+		}
+		return parts
+	}
+
+	t.Run("default layout", func(t *testing.T) {
+		scheme, err := namedPartitionScheme("/dev/nvme0n1", layoutParts(t, "/dev/nvme0n1", false, 0), false)
+		if err != nil {
+			t.Fatalf("namedPartitionScheme() error = %v", err)
+		}
+		want := PartitionScheme{
+			BootPartition:  "/dev/nvme0n1p1",
+			Root1Partition: "/dev/nvme0n1p2",
+			Root2Partition: "/dev/nvme0n1p3",
+			VarPartition:   "/dev/nvme0n1p4",
+		}
+		if *scheme != want {
+			t.Errorf("namedPartitionScheme() = %+v, want %+v", *scheme, want)
+		}
+	})
+
+	t.Run("verity and swap", func(t *testing.T) {
+		scheme, err := namedPartitionScheme("/dev/sda", layoutParts(t, "/dev/sda", true, 8*gib), true)
+		if err != nil {
+			t.Fatalf("namedPartitionScheme() error = %v", err)
+		}
+		if scheme.Root1HashPartition != "/dev/sda5" || scheme.Root2HashPartition != "/dev/sda6" {
+			t.Errorf("hash partitions = %s, %s", scheme.Root1HashPartition, scheme.Root2HashPartition)
+		}
+		if scheme.SwapPartition != "/dev/sda7" {
+			t.Errorf("swap = %s, want /dev/sda7", scheme.SwapPartition)
+		}
+	})
+
+	t.Run("verity layout reinstalled without verity", func(t *testing.T) {
+		scheme, err := namedPartitionScheme("/dev/sda", layoutParts(t, "/dev/sda", true, 0), false)
+		if err != nil {
+			t.Fatalf("namedPartitionScheme() error = %v", err)
+		}
+		if scheme.Root1HashPartition != "" || scheme.Root2HashPartition != "" {
+			t.Errorf("hash partitions = %s, %s, want none", scheme.Root1HashPartition, scheme.Root2HashPartition)
+		}
+	})
+
+	t.Run("verity without hash partitions", func(t *testing.T) {
+		_, err := namedPartitionScheme("/dev/sda", layoutParts(t, "/dev/sda", false, 0), true)
+		if !errors.Is(err, ErrPartitionSchemeMismatch) {
+			t.Errorf("namedPartitionScheme() error = %v, want ErrPartitionSchemeMismatch", err)
+		}
+	})
+
+	t.Run("foreign layout", func(t *testing.T) {
+		parts := layoutParts(t, "/dev/sda", false, 0)
+		parts["home"] = parts["var"]
+		delete(parts, "var")
+		_, err := namedPartitionScheme("/dev/sda", parts, false)
+		if !errors.Is(err, ErrPartitionSchemeMismatch) {
+			t.Errorf("namedPartitionScheme() error = %v, want ErrPartitionSchemeMismatch", err)
+		}
+	})
+}
+
+func TestParsePartitionMap(t *testing.T) {
+	required := []string{"boot=/dev/sda1", "root1=/dev/sda5", "root2=/dev/sda6", "var=/dev/sda7"}
+
+	parts, err := parsePartitionMap(append(required, "swap=/dev/sda8"))
+	if err != nil {
+		t.Fatalf("parsePartitionMap() error = %v", err)
+	}
+	if parts["root1"] != "/dev/sda5" || parts["swap"] != "/dev/sda8" {
+		t.Errorf("parsePartitionMap() = %v", parts)
+	}
+
+	invalid := map[string][]string{
+		"missing var":  required[:3],
+		"unknown role": append(required, "home=/dev/sda9"),
+		"no partition": append(required, "swap="),
+		"no role":      append(required, "/dev/sda8"),
+		"duplicate":    append(required, "root1=/dev/sda9"),
+	}
+	for name, mapping := range invalid {
+		t.Run(name, func(t *testing.T) {
+			if _, err := parsePartitionMap(mapping); err == nil {
+				t.Errorf("parsePartitionMap(%v) error = nil, want error", mapping)
+			}
+		})
+	}
+}
+
+func TestFormattedPartitions(t *testing.T) {
+	scheme := &PartitionScheme{
+		BootPartition:  "/dev/sda1",
+		Root1Partition: "/dev/sda2",
+		Root2Partition: "/dev/sda3",
+		VarPartition:   "/dev/sda4",
+		SwapPartition:  "/dev/sda5",
+	}
+	tests := []struct {
+		name string
+		keep keptPartitions
+		want []string
+	}{
+		{"all", keptPartitions{}, []string{"/dev/sda1", "/dev/sda2", "/dev/sda3", "/dev/sda4", "/dev/sda5"}},
+		{"shared boot", keptPartitions{boot: true}, []string{"/dev/sda2", "/dev/sda3", "/dev/sda4", "/dev/sda5"}},
+		{"preserved data", keptPartitions{data: true}, []string{"/dev/sda1", "/dev/sda2", "/dev/sda3"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := scheme.formattedPartitions(tt.keep); !slices.Equal(got, tt.want) {
+				t.Errorf("formattedPartitions() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,159 @@
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Rollback makes the inactive root the default boot entry again. If an update
+// was staged but not booted yet, the staged update and its /var snapshot are
+// discarded; otherwise the system goes back to the deployment it ran before
+// the last update, with /var restored from the snapshot that update took.
+// Either way the change takes effect on the next boot.
+func (u *SystemUpdater) Rollback(ctx context.Context) error {
+	if err := u.PrepareUpdate(); err != nil {
+		return err
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	// Boot entries keep the /var filesystem; PrepareUpdate read the kernel
+	// arguments
+	previousDigest := ""
+	if config, err := ReadSystemConfig(); err == nil {
+		previousDigest = config.ImageDigest
+		u.Config.FilesystemType = config.FilesystemType
+	}
+
+	defaultUUID, err := u.defaultRootUUID()
+	if err != nil {
+		return err
+	}
+	targetUUID, err := GetPartitionUUID(u.Target)
+	if err != nil {
+		return fmt.Errorf("failed to get UUID of %s: %w", u.Target, err)
+	}
+
+	// The root that becomes the default boot entry
+	newDefault := u.Target
+	staged := defaultUUID == targetUUID
+	if staged {
+		newDefault = u.Scheme.Root1Partition
+		if !u.Active {
+			newDefault = u.Scheme.Root2Partition
+		}
+		fmt.Printf("An update is staged on %s but has not been booted; discarding it\n", u.Target)
+	} else {
+		fmt.Printf("Rolling back to the previous deployment on %s\n", u.Target)
+	}
+
+	var varSnapshot *VarSnapshot
+	if !staged {
+		if varSnapshot, err = u.pendingVarRestore(ctx); err != nil {
+			return err
+		}
+	}
+
+	defer u.runCommands()()
+	if u.Config.DryRun {
+		fmt.Printf("[DRY RUN] Would make %s the default boot entry\n", newDefault)
+		if varSnapshot != nil {
+			return u.RestoreVar(ctx, varSnapshot)
+		}
+		return nil
+	}
+	markChangesSystem()
+	recordResult(func(r *OperationResult) {
+		r.Device, r.Partitions, r.TargetPartition = u.Config.Device, u.Scheme, newDefault
+		r.TargetSlot = rootSlot(u.Scheme, newDefault)
+		r.PreviousImageDigest = previousDigest
+		if !staged {
+			r.PreviousImageVersion = deploymentVersion(bootedRoot)
+		}
+	})
+
+	banner := []string{"This will change the default boot entry.", "New default root: " + newDefault}
+	if varSnapshot != nil {
+		banner = append(banner, fmt.Sprintf("/var will be restored to its snapshot of %s; later changes to it are lost (--keep-var keeps them)",
+			varSnapshot.Created.Local().Format(time.DateTime)))
+	}
+	if !u.Config.Force && !u.Config.AssumeYes && !confirm(banner...) {
+		return fmt.Errorf("rollback %w", ErrAborted)
+	}
+
+	// The boot entries are named after the OS of the new default root
+	if err := os.MkdirAll(u.Config.MountPoint, 0755); err != nil {
+		return fmt.Errorf("failed to create mount point: %w", err)
+	}
+	// dm-verity holds the booted root's partition, so it is reached through /
+	mount := func() error { return mountDevice(newDefault, u.Config.MountPoint, true) }
+	if staged && u.Config.Verity {
+		mount = func() error { return bindMount(bootedRoot, u.Config.MountPoint) }
+	}
+	if err := mount(); err != nil {
+		return fmt.Errorf("failed to mount %s: %w", newDefault, err)
+	}
+	defer func() { _ = unmount(u.Config.MountPoint) }()
+
+	if _, err := os.Stat(filepath.Join(u.Config.MountPoint, "usr")); err != nil {
+		return fmt.Errorf("no previous deployment on %s", newDefault)
+	}
+	if deployment, err := ReadDeployment(u.Config.MountPoint); err == nil {
+		recordResult(func(r *OperationResult) {
+			r.ImageRef, r.ImageDigest, r.ImageVersion = deployment.ImageRef, deployment.ImageDigest, deployment.DisplayVersion()
+		})
+	}
+
+	if staged {
+		if err := u.rollbackBootloader(); err != nil {
+			return err
+		}
+		if err := u.DiscardVarSnapshot(ctx); err != nil {
+			return warnf("failed to drop the /var snapshot of the discarded update: %v", err)
+		}
+		return nil
+	}
+	fmt.Println("Updating bootloader...")
+	if err := u.UpdateBootloader(); err != nil {
+		return err
+	}
+	// Only once the previous deployment boots next, so a failed restore
+	// leaves the system as it would be without snapshots
+	if varSnapshot != nil {
+		if err := u.RestoreVar(ctx, varSnapshot); err != nil {
+			return warnf("failed to restore /var, the previous deployment boots with /var as it is: %v", err)
+		}
+	}
+	return nil
+}
+
+// defaultRootUUID returns the root filesystem UUID of the default boot entry
+func (u *SystemUpdater) defaultRootUUID() (string, error) {
+	if err := os.MkdirAll(u.Config.BootMountPoint, 0755); err != nil {
+		return "", fmt.Errorf("failed to create boot mount point: %w", err)
+	}
+	if err := mountDevice(u.Scheme.BootPartition, u.Config.BootMountPoint, true); err != nil {
+		return "", fmt.Errorf("failed to mount boot partition: %w", err)
+	}
+	defer func() { _ = unmount(u.Config.BootMountPoint) }()
+
+	return readDefaultRootUUID(u.Config.BootMountPoint)
+}
+
+// readDefaultRootUUID finds root=UUID= of the default entry written by
+// UpdateBootloader in the boot partition mounted at bootMount
+func readDefaultRootUUID(bootMount string) (string, error) {
+	entry, err := readDefaultBootEntry(bootMount)
+	if err != nil {
+		return "", err
+	}
+	uuid := entry.rootUUID()
+	if uuid == "" {
+		return "", fmt.Errorf("could not find the default root in the boot configuration")
+	}
+	return uuid, nil
+}
@@ -0,0 +1,68 @@
+package pkg
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReadDefaultRootUUID(t *testing.T) {
+	tests := []struct {
+		name    string
+		files   map[string]string
+		want    string
+		wantErr bool
+	}{
+		{
+			name: "systemd-boot",
+			files: map[string]string{
+				"loader/entries/bootc.conf":          "title   Fedora\nlinux   /vmlinuz-6.1\noptions root=UUID=aaaa rw\n",
+				"loader/entries/bootc-previous.conf": "title   Fedora (Previous)\noptions root=UUID=bbbb rw\n",
+			},
+			want: "aaaa",
+		},
+		{
+			name: "grub first menu entry",
+			files: map[string]string{
+				"grub2/grub.cfg": "set default=0\nmenuentry 'Fedora' {\n    linux /vmlinuz-6.1 root=UUID=cccc rw\n}\n" +
+					"menuentry 'Fedora (Previous)' {\n    linux /vmlinuz-6.1 root=UUID=dddd rw\n}\n",
+			},
+			want: "cccc",
+		},
+		{
+			name: "verity-protected root",
+			files: map[string]string{
+				"loader/entries/bootc.conf": "title   Fedora\noptions root=/dev/mapper/root ro roothash=1234 " +
+					"systemd.verity_root_data=UUID=eeee systemd.verity_root_hash=UUID=ffff\n",
+			},
+			want: "eeee",
+		},
+		{
+			name:    "no boot configuration",
+			files:   map[string]string{"loader/loader.conf": "timeout 3\n"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			for name, content := range tt.files {
+				path := filepath.Join(dir, name)
+				if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			got, err := readDefaultRootUUID(dir)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("readDefaultRootUUID() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("readDefaultRootUUID() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
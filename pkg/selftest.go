@@ -0,0 +1,303 @@
+package pkg
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultSelfTestImage is the public bootc image installed by the self-test
+const DefaultSelfTestImage = "quay.io/centos-bootc/centos-bootc:stream9"
+
+// DefaultSelfTestDiskSize fits the default partition layout (2 GiB boot, two
+// 12 GiB roots) with room for /var. The loop image is sparse.
+const DefaultSelfTestDiskSize = 32 * 1024 * 1024 * 1024
+
+// DefaultSelfTestBootTimeout is how long the installed system may take to boot
+const DefaultSelfTestBootTimeout = 5 * time.Minute
+
+// Self-test step outcomes
+const (
+	SelfTestPass = "pass"
+	SelfTestFail = "fail"
+	SelfTestSkip = "skip"
+)
+
+// bootMarkers are serial console lines that show the installed system booted
+var bootMarkers = []string{
+	"login:",
+	"Reached target multi-user.target",
+	"Reached target Multi-User System",
+}
+
+// ovmfPaths are common locations of x86_64 UEFI firmware for QEMU
+var ovmfPaths = []string{
+	"/usr/share/OVMF/OVMF_CODE.fd",
+	"/usr/share/OVMF/OVMF_CODE_4M.fd",
+	"/usr/share/edk2/ovmf/OVMF_CODE.fd",
+	"/usr/share/edk2/x64/OVMF_CODE.fd",
+	"/usr/share/qemu/OVMF.fd",
+}
+
+// SelfTestStep is the outcome of one self-test step
+type SelfTestStep struct {
+	Name     string        `json:"name"`
+	Result   string        `json:"result"` // pass, fail or skip
+	Duration time.Duration `json:"duration"`
+	Detail   string        `json:"detail,omitempty"` // Error or reason for skipping
+}
+
+// SelfTestResult is the outcome of a self-test run
+type SelfTestResult struct {
+	ImageRef string         `json:"image_ref"`
+	Device   string         `json:"device"`
+	Steps    []SelfTestStep `json:"steps"`
+}
+
+// Passed reports whether no step failed
+func (r *SelfTestResult) Passed() bool {
+	for _, s := range r.Steps {
+		if s.Result == SelfTestFail {
+			return false
+		}
+	}
+	return true
+}
+
+// Print writes a summary of the self-test
+func (r *SelfTestResult) Print(w io.Writer) {
+	_, _ = fmt.Fprintf(w, "\n%s\n", strings.Repeat("=", 60))
+	_, _ = fmt.Fprintln(w, "Self-test results")
+	_, _ = fmt.Fprintf(w, "  Image:  %s\n", r.ImageRef)
+	_, _ = fmt.Fprintf(w, "  Device: %s\n", r.Device)
+	for _, s := range r.Steps {
+		line := fmt.Sprintf("  %-8s %s", s.Name+":", strings.ToUpper(s.Result))
+		if s.Result != SelfTestSkip {
+			line += fmt.Sprintf(" (%s)", s.Duration.Round(time.Second))
+		}
+		if s.Detail != "" {
+			line += " - " + s.Detail
+		}
+		_, _ = fmt.Fprintln(w, line)
+	}
+	result := "PASS"
+	if !r.Passed() {
+		result = "FAIL"
+	}
+	_, _ = fmt.Fprintf(w, "Result: %s\n", result)
+	_, _ = fmt.Fprintf(w, "%s\n", strings.Repeat("=", 60))
+}
+
+// SelfTest installs a known-good image to a loop device and optionally boots
+// it under QEMU, to validate phukit and its tools on a distribution
+type SelfTest struct {
+	ImageRef    string
+	DiskSize    int64  // Size of the loop device image
+	WorkDir     string // Where the loop image is created
+	Boot        bool   // Boot the result under QEMU if available
+	BootTimeout time.Duration
+	Verbose     bool
+}
+
+// NewSelfTest creates a SelfTest using a loop device and booting under QEMU
+func NewSelfTest(imageRef string) *SelfTest {
+	if imageRef == "" {
+		imageRef = DefaultSelfTestImage
+	}
+	return &SelfTest{
+		ImageRef:    imageRef,
+		DiskSize:    DefaultSelfTestDiskSize,
+		WorkDir:     "/var/tmp",
+		Boot:        true,
+		BootTimeout: DefaultSelfTestBootTimeout,
+	}
+}
+
+// Run runs the self-test. The returned error is only set when the test could
+// not run at all; failed steps are reported in the result.
+func (s *SelfTest) Run(ctx context.Context) (*SelfTestResult, error) {
+	if os.Geteuid() != 0 {
+		return nil, fmt.Errorf("selftest must be run as root")
+	}
+
+	dir, err := os.MkdirTemp(s.WorkDir, "phukit-selftest-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create work directory: %w", err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	imagePath := filepath.Join(dir, "disk.img")
+	device, err := attachLoopImage(ctx, imagePath, s.DiskSize)
+	if err != nil {
+		return nil, err
+	}
+	detach := func() { _, _ = runCommand(context.WithoutCancel(ctx), "losetup", "-d", device) }
+	defer detach()
+	fmt.Printf("Created %s loop device %s\n", FormatSize(uint64(s.DiskSize)), device)
+
+	result := &SelfTestResult{ImageRef: s.ImageRef, Device: device}
+	step := s.install(ctx, device)
+	result.Steps = append(result.Steps, step)
+	if step.Result != SelfTestPass {
+		result.Steps = append(result.Steps, SelfTestStep{Name: "boot", Result: SelfTestSkip, Detail: "install failed"})
+		return result, nil
+	}
+
+	// QEMU boots the image file, so let go of the loop device first
+	detach()
+	result.Steps = append(result.Steps, s.boot(ctx, imagePath))
+	return result, nil
+}
+
+// attachLoopImage creates a sparse image of size bytes and attaches it to a
+// free loop device with partition scanning
+func attachLoopImage(ctx context.Context, path string, size int64) (string, error) {
+	if err := CreateDiskImage(path, size); err != nil {
+		return "", err
+	}
+	return AttachLoopDevice(ctx, path)
+}
+
+// install runs a full install to device without prompting
+func (s *SelfTest) install(ctx context.Context, device string) SelfTestStep {
+	start := time.Now()
+	step := SelfTestStep{Name: "install"}
+
+	err := func() error {
+		if err := CheckRequiredTools(); err != nil {
+			return fmt.Errorf("missing required tools: %w", err)
+		}
+
+		installer := NewBootcInstaller(s.ImageRef, device)
+		installer.SetVerbose(s.Verbose)
+		installer.SetMountPoint(filepath.Join(os.TempDir(), "phukit-selftest-mnt"))
+		// The boot check watches the serial console
+		installer.AddKernelArg("console=ttyS0")
+
+		if err := installer.PullImage(ctx); err != nil {
+			return err
+		}
+		fmt.Printf("Wiping disk %s...\n", device)
+		if err := WipeDisk(ctx, device, WipeSignatures, false); err != nil {
+			return err
+		}
+		if err := installer.Install(ctx); err != nil {
+			return err
+		}
+		return installer.Verify()
+	}()
+
+	step.Duration = time.Since(start)
+	if err != nil {
+		step.Result = SelfTestFail
+		step.Detail = err.Error()
+		return step
+	}
+	step.Result = SelfTestPass
+	return step
+}
+
+// findOVMF returns the first UEFI firmware image found for QEMU
+func findOVMF() string {
+	for _, p := range ovmfPaths {
+		if _, err := os.Stat(p); err == nil {
+			return p
+		}
+	}
+	return ""
+}
+
+// boot starts the installed disk under QEMU and waits for a login prompt or
+// multi-user target on the serial console
+func (s *SelfTest) boot(ctx context.Context, diskPath string) SelfTestStep {
+	step := SelfTestStep{Name: "boot", Result: SelfTestSkip}
+	if !s.Boot {
+		step.Detail = "disabled"
+		return step
+	}
+	if _, err := executor.LookPath("qemu-system-x86_64"); err != nil {
+		step.Detail = "qemu-system-x86_64 not found"
+		return step
+	}
+	if findOVMF() == "" {
+		step.Detail = "UEFI firmware (OVMF) not found"
+		return step
+	}
+
+	vm := NewVMBoot(diskPath)
+	vm.Format = "raw"
+	vm.Timeout = s.BootTimeout
+	vm.Verbose = s.Verbose
+	result, err := vm.Run(ctx)
+	step.Result = SelfTestFail
+	switch {
+	case err != nil:
+		step.Detail = err.Error()
+	case result.Booted:
+		step.Result = SelfTestPass
+		step.Duration = result.Duration
+		step.Detail = fmt.Sprintf("saw %q", result.Marker)
+	default:
+		step.Duration = result.Duration
+		step.Detail = fmt.Sprintf("%s; last output: %s", result.Detail, result.Console)
+	}
+	return step
+}
+
+// markerWriter scans console output for any of markers and calls onMatch on
+// the first one found
+type markerWriter struct {
+	markers []string
+	onMatch func()
+	echo    bool // Copy console output to stdout
+
+	mu      sync.Mutex
+	buf     []byte
+	matched string
+}
+
+// markerTailSize is how much console output is kept for error reports
+const markerTailSize = 512
+
+func (m *markerWriter) Write(p []byte) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.echo {
+		_, _ = os.Stdout.Write(p)
+	}
+	m.buf = append(m.buf, p...)
+	if m.matched == "" {
+		for _, marker := range m.markers {
+			if bytes.Contains(m.buf, []byte(marker)) {
+				m.matched = marker
+				m.onMatch()
+				break
+			}
+		}
+	}
+	// Keep enough to match markers split across writes and for Tail
+	if len(m.buf) > markerTailSize {
+		m.buf = m.buf[len(m.buf)-markerTailSize:]
+	}
+	return len(p), nil
+}
+
+// Matched returns the marker that was found, or ""
+func (m *markerWriter) Matched() string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.matched
+}
+
+// Tail returns the last console output, trimmed to one line of text
+func (m *markerWriter) Tail() string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return strings.Join(strings.Fields(string(m.buf)), " ")
+}
@@ -0,0 +1,85 @@
+package pkg
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestMarkerWriterSplitWrites(t *testing.T) {
+	called := 0
+	w := &markerWriter{markers: bootMarkers, onMatch: func() { called++ }}
+
+	for _, chunk := range []string{"Fedora Linux 42\r\n", "localhost lo", "gin: ", "more output\n"} {
+		if _, err := w.Write([]byte(chunk)); err != nil {
+			t.Fatalf("Write() error = %v", err)
+		}
+	}
+
+	if got := w.Matched(); got != "login:" {
+		t.Errorf("Matched() = %q, want %q", got, "login:")
+	}
+	if called != 1 {
+		t.Errorf("onMatch called %d times, want 1", called)
+	}
+}
+
+func TestMarkerWriterTail(t *testing.T) {
+	w := &markerWriter{markers: bootMarkers, onMatch: func() {}}
+	for range 100 {
+		_, _ = w.Write([]byte("Booting kernel...\n"))
+	}
+	_, _ = w.Write([]byte("Kernel panic - not syncing\n"))
+
+	tail := w.Tail()
+	if len(tail) > markerTailSize {
+		t.Errorf("Tail() length = %d, want at most %d", len(tail), markerTailSize)
+	}
+	if want := "Kernel panic - not syncing"; tail[len(tail)-len(want):] != want {
+		t.Errorf("Tail() = %q, want suffix %q", tail, want)
+	}
+	if w.Matched() != "" {
+		t.Errorf("Matched() = %q, want none", w.Matched())
+	}
+}
+
+func TestSelfTestResultPassed(t *testing.T) {
+	tests := []struct {
+		name  string
+		steps []SelfTestStep
+		want  bool
+	}{
+		{"all pass", []SelfTestStep{{Name: "install", Result: SelfTestPass}, {Name: "boot", Result: SelfTestPass}}, true},
+		{"boot skipped", []SelfTestStep{{Name: "install", Result: SelfTestPass}, {Name: "boot", Result: SelfTestSkip}}, true},
+		{"install failed", []SelfTestStep{{Name: "install", Result: SelfTestFail}, {Name: "boot", Result: SelfTestSkip}}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &SelfTestResult{Steps: tt.steps}
+			if got := r.Passed(); got != tt.want {
+				t.Errorf("Passed() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSelfTestBootSkipped(t *testing.T) {
+	setupFakeRuntime(t) // No qemu installed
+
+	s := NewSelfTest("")
+	if s.ImageRef != DefaultSelfTestImage {
+		t.Errorf("ImageRef = %q, want %q", s.ImageRef, DefaultSelfTestImage)
+	}
+
+	step := s.boot(context.Background(), "/tmp/disk.img")
+	if step.Result != SelfTestSkip || step.Detail != "qemu-system-x86_64 not found" {
+		t.Errorf("boot() = %+v, want skip for missing qemu", step)
+	}
+
+	s.Boot = false
+	s.BootTimeout = time.Second
+	step = s.boot(context.Background(), "/tmp/disk.img")
+	if step.Result != SelfTestSkip || step.Detail != "disabled" {
+		t.Errorf("boot() = %+v, want skip when disabled", step)
+	}
+}
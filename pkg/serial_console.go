@@ -0,0 +1,208 @@
+package pkg
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Serial console
+//
+// Headless servers are managed over a serial port, often redirected by the
+// BMC (Serial-over-LAN). Install finds the port the installer itself was
+// booted with on the kernel command line, or the one the firmware describes
+// in the ACPI SPCR table, and sets it up on the installed system: console=
+// arguments for the kernel and a serial terminal for GRUB. systemd-boot has
+// no serial terminal of its own and relies on the firmware's console
+// redirection, which keeps working as long as it does not change the
+// console mode.
+
+// procCmdlinePath is the kernel command line of the running system (overridable in tests)
+var procCmdlinePath = "/proc/cmdline"
+
+// defaultSerialOptions are the speed, parity and bits of a console given without them
+const defaultSerialOptions = "115200n8"
+
+// SerialConsole is a serial port the kernel and bootloader print to
+type SerialConsole struct {
+	Device  string `json:"device"`            // Kernel device name, such as ttyS0
+	Options string `json:"options,omitempty"` // Speed, parity and bits, such as 115200n8
+}
+
+// serialDevice is the form of the kernel name of a serial port
+var serialDevice = regexp.MustCompile(`^tty[A-Za-z]+[0-9]+$`)
+
+// serialOptions is the form of the kernel's serial console options
+var serialOptions = regexp.MustCompile(`^([0-9]+)([noe]?)([5-8]?)$`)
+
+// ParseSerialConsole parses a serial console in the form of the kernel's
+// console= argument, such as "ttyS1" or "ttyS1,115200n8". Without options,
+// 115200n8 is used.
+func ParseSerialConsole(spec string) (*SerialConsole, error) {
+	device, options, _ := strings.Cut(strings.TrimPrefix(spec, "/dev/"), ",")
+	if !serialDevice.MatchString(device) || isVirtualTerminal(device) {
+		return nil, fmt.Errorf("invalid serial console %q: expected a serial port such as ttyS0 or ttyS0,115200n8", spec)
+	}
+	if options == "" {
+		options = defaultSerialOptions
+	}
+	if !serialOptions.MatchString(options) {
+		return nil, fmt.Errorf("invalid serial console options %q: expected speed, parity and bits such as 115200n8", options)
+	}
+	return &SerialConsole{Device: device, Options: options}, nil
+}
+
+// isVirtualTerminal reports whether device is a virtual terminal (tty0,
+// tty1, ...) rather than a serial port
+func isVirtualTerminal(device string) bool {
+	n := strings.TrimPrefix(device, "tty")
+	_, err := strconv.Atoi(n)
+	return n != device && err == nil
+}
+
+// String returns the console in the form of the kernel's console= argument
+func (c *SerialConsole) String() string {
+	if c.Options == "" {
+		return c.Device
+	}
+	return c.Device + "," + c.Options
+}
+
+// KernelArgs returns the console= arguments that print to the screen and the
+// serial port, with the serial port last so that it becomes /dev/console
+func (c *SerialConsole) KernelArgs() []string {
+	return []string{"console=tty0", "console=" + c.String()}
+}
+
+// grubConfig returns the GRUB commands that add the serial port as a
+// terminal next to the screen
+func (c *SerialConsole) grubConfig() string {
+	serial := []string{"serial"}
+	if n, ok := strings.CutPrefix(c.Device, "ttyS"); ok {
+		serial = append(serial, "--unit="+n)
+	}
+	if m := serialOptions.FindStringSubmatch(c.Options); m != nil {
+		serial = append(serial, "--speed="+m[1])
+		switch m[2] {
+		case "n":
+			serial = append(serial, "--parity=no")
+		case "o":
+			serial = append(serial, "--parity=odd")
+		case "e":
+			serial = append(serial, "--parity=even")
+		}
+		if m[3] != "" {
+			serial = append(serial, "--word="+m[3])
+		}
+	}
+	return strings.Join(serial, " ") + `
+terminal_input console serial
+terminal_output console serial
+`
+}
+
+// grubSerialConfig returns the GRUB commands of console, if it is set
+func grubSerialConfig(console *SerialConsole) string {
+	if console == nil {
+		return ""
+	}
+	return console.grubConfig()
+}
+
+// HasSerialConsoleArg reports whether args already have a console= argument
+// for a serial port
+func HasSerialConsoleArg(args []string) bool {
+	for _, arg := range args {
+		if value, ok := strings.CutPrefix(arg, "console="); ok {
+			device, _, _ := strings.Cut(value, ",")
+			if serialDevice.MatchString(device) && !isVirtualTerminal(device) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// DetectSerialConsole returns the serial console of this machine and where it
+// was found: the last serial console= argument of the running kernel, or
+// the ACPI SPCR table. It returns nil if the machine has none.
+func DetectSerialConsole() (*SerialConsole, string) {
+	if console := cmdlineSerialConsole(readSysfsValue(procCmdlinePath)); console != nil {
+		return console, "kernel command line"
+	}
+	data, err := os.ReadFile(filepath.Join(sysFirmwarePath, "acpi", "tables", "SPCR"))
+	if err == nil {
+		if console := parseSPCR(data); console != nil {
+			return console, "ACPI SPCR table"
+		}
+	}
+	return nil, ""
+}
+
+// cmdlineSerialConsole returns the last serial console of a kernel command
+// line, with the options it has there
+func cmdlineSerialConsole(cmdline string) *SerialConsole {
+	var console *SerialConsole
+	for _, arg := range strings.Fields(cmdline) {
+		value, ok := strings.CutPrefix(arg, "console=")
+		if !ok {
+			continue
+		}
+		device, options, _ := strings.Cut(value, ",")
+		if serialDevice.MatchString(device) && !isVirtualTerminal(device) {
+			console = &SerialConsole{Device: device, Options: options}
+		}
+	}
+	return console
+}
+
+// SPCR interface types that are not 16550-compatible UARTs
+const (
+	spcrPL011       = 0x03
+	spcrSBSA32      = 0x0d
+	spcrSBSAGeneric = 0x0e
+)
+
+// spcrBaudRates are the speeds of the SPCR baud rate codes
+var spcrBaudRates = map[byte]string{3: "9600", 4: "19200", 6: "57600", 7: "115200"}
+
+// spcrIOPorts are the kernel names of the legacy PC serial ports
+var spcrIOPorts = map[uint64]string{0x3f8: "ttyS0", 0x2f8: "ttyS1", 0x3e8: "ttyS2", 0x2e8: "ttyS3"}
+
+// parseSPCR returns the console of an ACPI Serial Port Console Redirection
+// table, or nil if it describes none this can name
+func parseSPCR(data []byte) *SerialConsole {
+	if len(data) < 80 || string(data[:4]) != "SPCR" {
+		return nil
+	}
+	interfaceType := data[36]
+	addressSpace := data[40] // Generic address structure: 0 memory, 1 I/O ports
+	address := binary.LittleEndian.Uint64(data[44:52])
+	if address == 0 {
+		return nil // Redirection disabled
+	}
+
+	var device string
+	switch {
+	case interfaceType == spcrPL011 || interfaceType == spcrSBSA32 || interfaceType == spcrSBSAGeneric:
+		device = "ttyAMA0"
+	case addressSpace == 1:
+		if device = spcrIOPorts[address]; device == "" {
+			return nil
+		}
+	default:
+		// A memory-mapped 16550 is the first serial port the kernel finds
+		device = "ttyS0"
+	}
+
+	// Without a baud rate the port is used as the firmware left it
+	speed, ok := spcrBaudRates[data[58]]
+	if !ok {
+		speed = "115200"
+	}
+	return &SerialConsole{Device: device, Options: speed + "n8"}
+}
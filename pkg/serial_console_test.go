@@ -0,0 +1,140 @@
+package pkg
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParseSerialConsole(t *testing.T) {
+	tests := []struct {
+		spec string
+		want SerialConsole
+	}{
+		{"ttyS0", SerialConsole{Device: "ttyS0", Options: "115200n8"}},
+		{"/dev/ttyS1,9600", SerialConsole{Device: "ttyS1", Options: "9600"}},
+		{"ttyAMA0,115200n8", SerialConsole{Device: "ttyAMA0", Options: "115200n8"}},
+	}
+	for _, tt := range tests {
+		got, err := ParseSerialConsole(tt.spec)
+		if err != nil {
+			t.Fatalf("ParseSerialConsole(%q) error = %v", tt.spec, err)
+		}
+		if *got != tt.want {
+			t.Errorf("ParseSerialConsole(%q) = %+v, want %+v", tt.spec, *got, tt.want)
+		}
+	}
+
+	for _, spec := range []string{"", "tty0", "tty1,115200", "sda", "ttyS0,fast", "ttyS0,115200x8"} {
+		if _, err := ParseSerialConsole(spec); err == nil {
+			t.Errorf("ParseSerialConsole(%q) should fail", spec)
+		}
+	}
+}
+
+func TestSerialConsoleKernelArgs(t *testing.T) {
+	console := &SerialConsole{Device: "ttyS1", Options: "57600n8"}
+	want := []string{"console=tty0", "console=ttyS1,57600n8"}
+	if got := console.KernelArgs(); !reflect.DeepEqual(got, want) {
+		t.Errorf("KernelArgs() = %v, want %v", got, want)
+	}
+}
+
+func TestGRUBSerialConfig(t *testing.T) {
+	got := grubSerialConfig(&SerialConsole{Device: "ttyS1", Options: "115200n8"})
+	want := `serial --unit=1 --speed=115200 --parity=no --word=8
+terminal_input console serial
+terminal_output console serial
+`
+	if got != want {
+		t.Errorf("grubSerialConfig() = %q, want %q", got, want)
+	}
+	if got := grubSerialConfig(nil); got != "" {
+		t.Errorf("grubSerialConfig(nil) = %q, want none", got)
+	}
+}
+
+func TestHasSerialConsoleArg(t *testing.T) {
+	if HasSerialConsoleArg([]string{"quiet", "console=tty0"}) {
+		t.Error("HasSerialConsoleArg() with a virtual terminal only = true, want false")
+	}
+	if !HasSerialConsoleArg([]string{"console=tty0", "console=ttyS0,115200"}) {
+		t.Error("HasSerialConsoleArg() with ttyS0 = false, want true")
+	}
+}
+
+func TestCmdlineSerialConsole(t *testing.T) {
+	got := cmdlineSerialConsole("BOOT_IMAGE=/vmlinuz console=ttyS0,9600 console=tty0 console=ttyS1,115200n8 quiet")
+	if got == nil || *got != (SerialConsole{Device: "ttyS1", Options: "115200n8"}) {
+		t.Errorf("cmdlineSerialConsole() = %+v, want ttyS1,115200n8", got)
+	}
+	if got := cmdlineSerialConsole("root=/dev/sda2 console=tty0"); got != nil {
+		t.Errorf("cmdlineSerialConsole() without a serial console = %+v, want nil", got)
+	}
+}
+
+// spcrTable returns an SPCR table of a port at address in addressSpace
+func spcrTable(interfaceType, addressSpace byte, address uint64, baud byte) []byte {
+	data := make([]byte, 80)
+	copy(data, "SPCR")
+	data[36] = interfaceType
+	data[40] = addressSpace
+	binary.LittleEndian.PutUint64(data[44:52], address)
+	data[58] = baud
+	return data
+}
+
+func TestParseSPCR(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		want *SerialConsole
+	}{
+		{"io port", spcrTable(0, 1, 0x2f8, 7), &SerialConsole{Device: "ttyS1", Options: "115200n8"}},
+		{"mmio 16550", spcrTable(0x12, 0, 0xfe215040, 6), &SerialConsole{Device: "ttyS0", Options: "57600n8"}},
+		{"pl011", spcrTable(spcrPL011, 0, 0x9000000, 0), &SerialConsole{Device: "ttyAMA0", Options: "115200n8"}},
+		{"disabled", spcrTable(0, 1, 0, 7), nil},
+		{"unknown io port", spcrTable(0, 1, 0x1000, 7), nil},
+		{"short", []byte("SPCR"), nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseSPCR(tt.data); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseSPCR() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDetectSerialConsole(t *testing.T) {
+	root := t.TempDir()
+	oldCmdline, oldFirmware := procCmdlinePath, sysFirmwarePath
+	procCmdlinePath = filepath.Join(root, "cmdline")
+	sysFirmwarePath = filepath.Join(root, "firmware")
+	t.Cleanup(func() { procCmdlinePath, sysFirmwarePath = oldCmdline, oldFirmware })
+
+	writeFakeFile(t, procCmdlinePath, "root=/dev/sda2 quiet\n")
+	if console, _ := DetectSerialConsole(); console != nil {
+		t.Fatalf("DetectSerialConsole() without a serial console = %+v, want nil", console)
+	}
+
+	tables := filepath.Join(sysFirmwarePath, "acpi", "tables")
+	if err := os.MkdirAll(tables, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(tables, "SPCR"), spcrTable(0, 1, 0x3f8, 7), 0444); err != nil {
+		t.Fatal(err)
+	}
+	console, source := DetectSerialConsole()
+	if console == nil || console.String() != "ttyS0,115200n8" || source != "ACPI SPCR table" {
+		t.Errorf("DetectSerialConsole() = %v, %q, want ttyS0,115200n8 from the SPCR table", console, source)
+	}
+
+	writeFakeFile(t, procCmdlinePath, "root=/dev/sda2 console=ttyS2,9600\n")
+	console, source = DetectSerialConsole()
+	if console == nil || console.String() != "ttyS2,9600" || source != "kernel command line" {
+		t.Errorf("DetectSerialConsole() = %v, %q, want ttyS2,9600 from the command line", console, source)
+	}
+}
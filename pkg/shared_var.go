@@ -0,0 +1,121 @@
+package pkg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// Shared /var safety
+//
+// /var is a single partition shared by both root slots. While an update is
+// staged into the inactive slot, the running workload keeps writing /var, so
+// updates follow these rules:
+//
+//   - The staged root never sees the shared /var. It is not mounted under the
+//     staging mount point (this is checked before the target is cleared), and
+//     chroot commands run against the staged root's own /var directory, which
+//     is hidden by the /var partition at boot.
+//   - phukit writes the shared /var only below SharedVarWritablePaths, and
+//     only while holding the shared /var lock.
+//   - The lock is an flock(2) on /var/lib/phukit/var.lock, so concurrent phukit
+//     operations that touch /var are serialized. How long to wait for it is
+//     configurable; a timeout of 0 fails immediately if /var is busy.
+const (
+	// SharedVarLockPath is the lock file serializing writes to the shared /var
+	SharedVarLockPath = "/var/lib/phukit/var.lock"
+	// DefaultVarLockTimeout is how long an update waits for the shared /var lock
+	DefaultVarLockTimeout = 2 * time.Minute
+)
+
+// SharedVarWritablePaths are the only locations phukit writes in the shared /var
+var SharedVarWritablePaths = []string{
+	"/var/lib/phukit",
+	"/var/etc.backup",
+}
+
+// varLockPollInterval is how often a busy shared /var lock is retried
+var varLockPollInterval = 250 * time.Millisecond
+
+// SharedVarLock is a held lock on the shared /var
+type SharedVarLock struct {
+	f *os.File
+}
+
+// LockSharedVar takes the shared /var lock below varRoot (the mount point of
+// the /var partition, "/var" on a running system), waiting up to timeout or
+// until ctx is cancelled
+func LockSharedVar(ctx context.Context, varRoot string, timeout time.Duration) (*SharedVarLock, error) {
+	lockPath := filepath.Join(varRoot, strings.TrimPrefix(SharedVarLockPath, "/var/"))
+	if err := os.MkdirAll(filepath.Dir(lockPath), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create lock directory: %w", err)
+	}
+
+	f, err := os.OpenFile(lockPath, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", lockPath, err)
+	}
+
+	if err := flockWait(ctx, f, timeout); err != nil {
+		_ = f.Close()
+		switch {
+		case errors.Is(err, errLockBusy):
+			return nil, fmt.Errorf("shared /var is busy: another phukit operation holds %s (waited %s)", lockPath, timeout)
+		case ctx.Err() != nil:
+			return nil, fmt.Errorf("waiting for shared /var lock: %w", err)
+		default:
+			return nil, fmt.Errorf("failed to lock %s: %w", lockPath, err)
+		}
+	}
+	return &SharedVarLock{f: f}, nil
+}
+
+// Unlock releases the shared /var lock
+func (l *SharedVarLock) Unlock() error {
+	if l == nil || l.f == nil {
+		return nil
+	}
+	_ = unix.Flock(int(l.f.Fd()), unix.LOCK_UN)
+	err := l.f.Close()
+	l.f = nil
+	return err
+}
+
+// checkSharedVarPath returns an error unless path (an absolute path as seen by
+// the running system, e.g. /var/etc.backup) is one phukit may write
+func checkSharedVarPath(path string) error {
+	clean := filepath.Clean(path)
+	for _, allowed := range SharedVarWritablePaths {
+		if clean == allowed || strings.HasPrefix(clean, allowed+"/") {
+			return nil
+		}
+	}
+	return fmt.Errorf("refusing to write %s: outside the paths phukit may touch in shared /var (%s)",
+		path, strings.Join(SharedVarWritablePaths, ", "))
+}
+
+// ensureStagedVarIsolated checks that the staged root at targetDir does not have
+// a separate filesystem (such as the shared /var) mounted on its /var
+func ensureStagedVarIsolated(targetDir string) error {
+	var rootStat, varStat syscall.Stat_t
+	if err := syscall.Stat(targetDir, &rootStat); err != nil {
+		return fmt.Errorf("failed to stat %s: %w", targetDir, err)
+	}
+	if err := syscall.Stat(filepath.Join(targetDir, "var"), &varStat); err != nil {
+		if errors.Is(err, syscall.ENOENT) {
+			return nil
+		}
+		return fmt.Errorf("failed to stat %s/var: %w", targetDir, err)
+	}
+	if rootStat.Dev != varStat.Dev {
+		return fmt.Errorf("%s/var is a separate mount; the staged root must not touch the shared /var", targetDir)
+	}
+	return nil
+}
@@ -0,0 +1,131 @@
+package pkg
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestLockSharedVar(t *testing.T) {
+	varRoot := t.TempDir()
+
+	lock, err := LockSharedVar(context.Background(), varRoot, 0)
+	if err != nil {
+		t.Fatalf("LockSharedVar() error = %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(varRoot, "lib", "phukit", "var.lock")); err != nil {
+		t.Errorf("lock file not created: %v", err)
+	}
+
+	// flock locks are per open file description, so a second open contends
+	if _, err := LockSharedVar(context.Background(), varRoot, 0); err == nil {
+		t.Fatal("LockSharedVar() succeeded while lock was held")
+	}
+
+	oldInterval := varLockPollInterval
+	varLockPollInterval = 10 * time.Millisecond
+	t.Cleanup(func() { varLockPollInterval = oldInterval })
+
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		_ = lock.Unlock()
+	}()
+
+	second, err := LockSharedVar(context.Background(), varRoot, 5*time.Second)
+	if err != nil {
+		t.Fatalf("LockSharedVar() after release error = %v", err)
+	}
+	if err := second.Unlock(); err != nil {
+		t.Errorf("Unlock() error = %v", err)
+	}
+}
+
+func TestLockSharedVarCancelled(t *testing.T) {
+	varRoot := t.TempDir()
+
+	lock, err := LockSharedVar(context.Background(), varRoot, 0)
+	if err != nil {
+		t.Fatalf("LockSharedVar() error = %v", err)
+	}
+	defer func() { _ = lock.Unlock() }()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	if _, err := LockSharedVar(ctx, varRoot, time.Minute); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("LockSharedVar() error = %v, want context.DeadlineExceeded", err)
+	}
+	if waited := time.Since(start); waited > 10*time.Second {
+		t.Errorf("LockSharedVar() ignored cancellation, waited %s", waited)
+	}
+}
+
+func TestCheckSharedVarPath(t *testing.T) {
+	tests := []struct {
+		path    string
+		wantErr bool
+	}{
+		{"/var/etc.backup", false},
+		{"/var/etc.backup/passwd", false},
+		{"/var/lib/phukit/etc.pristine", false},
+		{"/var/lib/phukit/../containers", true},
+		{"/var/lib/phukitx", true},
+		{"/var/log", true},
+		{"/var", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			if err := checkSharedVarPath(tt.path); (err != nil) != tt.wantErr {
+				t.Errorf("checkSharedVarPath(%q) error = %v, wantErr %v", tt.path, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestEnsureStagedVarIsolated(t *testing.T) {
+	targetDir := t.TempDir()
+
+	// No /var at all is fine
+	if err := ensureStagedVarIsolated(targetDir); err != nil {
+		t.Errorf("ensureStagedVarIsolated() without /var error = %v", err)
+	}
+
+	// A plain directory on the same filesystem is fine
+	if err := os.MkdirAll(filepath.Join(targetDir, "var"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ensureStagedVarIsolated(targetDir); err != nil {
+		t.Errorf("ensureStagedVarIsolated() error = %v", err)
+	}
+}
+
+func TestWithSharedVarBusy(t *testing.T) {
+	fake := setupFakeMounter(t, "ext4")
+	fake.unmountErr = unix.EBUSY
+
+	u := NewSystemUpdater("/dev/sda", "quay.io/example/os:latest")
+	u.Scheme = &PartitionScheme{Root1Partition: "/dev/sda2", Root2Partition: "/dev/sda3", VarPartition: "/dev/sda4"}
+	u.Active = true
+	u.Config.MountPoint = filepath.Join(t.TempDir(), "mnt")
+
+	var data string
+	err := u.withSharedVar(context.Background(), func(varRoot string) error {
+		// A file of the mounted /var partition
+		data = filepath.Join(varRoot, "lib", "app", "data")
+		writeFakeFile(t, data, "rows")
+		return nil
+	})
+	if !errors.Is(err, unix.EBUSY) {
+		t.Errorf("withSharedVar() error = %v, want the unmount error", err)
+	}
+	if _, err := os.Stat(data); err != nil {
+		t.Errorf("withSharedVar() removed the still mounted /var: %v", err)
+	}
+}
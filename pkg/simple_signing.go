@@ -0,0 +1,265 @@
+package pkg
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/name"
+)
+
+// Simple signing signatures
+//
+// Registries that predate cosign, such as registry.redhat.io, publish GPG
+// signatures in the containers/image "simple signing" format on a separate
+// web server, the lookaside: the signatures of digest sha256:<hex> of
+// registry/path are at <lookaside>/path@sha256=<hex>/signature-1,
+// signature-2, ... Each is an OpenPGP signed message whose content is the
+// same critical JSON document cosign signs. phukit checks them with gpgv
+// against the keyring of the requirement.
+
+// lookasideClient fetches signatures from lookaside servers
+var lookasideClient = &http.Client{Timeout: 30 * time.Second}
+
+const (
+	// maxLookasideSignatures bounds the signatures read for one image
+	maxLookasideSignatures = 64
+	// maxSignatureSize bounds the size of one signature
+	maxSignatureSize = 4 << 20
+)
+
+// checkLookaside checks that base is a lookaside URL phukit can read
+func checkLookaside(base string) error {
+	u, err := url.Parse(base)
+	if err != nil {
+		return fmt.Errorf("invalid lookaside %q: %w", base, err)
+	}
+	switch u.Scheme {
+	case "http", "https", "file":
+		return nil
+	}
+	return fmt.Errorf("lookaside %q must be an http, https or file URL", base)
+}
+
+// lookasideURL returns the URL of the index'th signature (from 1) of digest
+// in ref's repository below base
+func lookasideURL(base string, ref name.Reference, digest string, index int) string {
+	algorithm, hex, _ := strings.Cut(digest, ":")
+	return fmt.Sprintf("%s/%s@%s=%s/signature-%d", strings.TrimSuffix(base, "/"), ref.Context().RepositoryStr(), algorithm, hex, index)
+}
+
+// fetchLookasideSignature returns the signature at rawURL, or nil if there is
+// none
+func fetchLookasideSignature(ctx context.Context, rawURL string) ([]byte, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme == "file" {
+		f, err := os.Open(u.Path)
+		if os.IsNotExist(err) {
+			return nil, nil
+		} else if err != nil {
+			return nil, fmt.Errorf("failed to read signature: %w", err)
+		}
+		defer func() { _ = f.Close() }()
+		return io.ReadAll(io.LimitReader(f, maxSignatureSize))
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := lookasideClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch signature: %w", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return nil, nil
+	case resp.StatusCode != http.StatusOK:
+		return nil, fmt.Errorf("failed to fetch signature %s: %s", rawURL, resp.Status)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxSignatureSize))
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch signature: %w", err)
+	}
+	return data, nil
+}
+
+// fetchSimpleSignatures returns the signatures of digest in ref's repository
+// published below base, reading signature-1, signature-2, ... up to the first
+// missing one
+func fetchSimpleSignatures(ctx context.Context, base string, ref name.Reference, digest string) ([][]byte, error) {
+	var signatures [][]byte
+	for i := 1; i <= maxLookasideSignatures; i++ {
+		sig, err := fetchLookasideSignature(ctx, lookasideURL(base, ref, digest, i))
+		if err != nil {
+			return nil, err
+		}
+		if sig == nil {
+			break
+		}
+		signatures = append(signatures, sig)
+	}
+	if len(signatures) == 0 {
+		return nil, fmt.Errorf("image %s has no signatures at %s", digest, base)
+	}
+	return signatures, nil
+}
+
+// verifySimpleSigning checks that one of the lookaside signatures of digest
+// is a valid simple signing signature of ref by a key of req.KeyPath
+func (req SignatureRequirement) verifySimpleSigning(ctx context.Context, ref name.Reference, digest string) (*SignatureVerification, error) {
+	if _, err := executor.LookPath("gpgv"); err != nil {
+		return nil, fmt.Errorf("%w: gpgv - install the gnupg package", ErrMissingTool)
+	}
+	keyring, err := os.ReadFile(req.KeyPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read GPG keyring: %w", err)
+	}
+	if keyring, err = dearmorKeyring(keyring); err != nil {
+		return nil, fmt.Errorf("invalid GPG keyring %s: %w", req.KeyPath, err)
+	}
+	signatures, err := fetchSimpleSignatures(ctx, req.Lookaside, ref, digest)
+	if err != nil {
+		return nil, err
+	}
+
+	var errs []error
+	for _, sig := range signatures {
+		payload, err := gpgVerify(ctx, keyring, sig)
+		if err == nil {
+			err = checkCosignPayload(payload, ref, digest)
+		}
+		if err == nil {
+			return &SignatureVerification{Scope: req.Scope, Type: SignatureTypeSimpleSigning, Signer: req.KeyPath}, nil
+		}
+		errs = append(errs, err)
+	}
+	return nil, fmt.Errorf("no valid signature by %s: %w", req.KeyPath, errors.Join(errs...))
+}
+
+// gpgVerify checks the OpenPGP signed message sig with gpgv against the
+// binary keyring and returns its content
+func gpgVerify(ctx context.Context, keyring, sig []byte) ([]byte, error) {
+	dir, err := os.MkdirTemp("", "phukit-gpgv-")
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+	keyringPath := filepath.Join(dir, "keyring.gpg")
+	sigPath := filepath.Join(dir, "signature")
+	if err := os.WriteFile(keyringPath, keyring, 0600); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(sigPath, sig, 0600); err != nil {
+		return nil, err
+	}
+
+	var payload, status bytes.Buffer
+	err = executor.Run(ctx, Command{
+		Name:   "gpgv",
+		Args:   []string{"--homedir", dir, "--keyring", keyringPath, "--status-fd", "2", "--output", "-", sigPath},
+		Stdout: &payload,
+		Stderr: &status,
+	})
+	if err != nil || gpgValidSignature(status.String()) == "" {
+		return nil, fmt.Errorf("signature does not verify: %s", gpgStatusError(status.String()))
+	}
+	return payload.Bytes(), nil
+}
+
+// gpgValidSignature returns the fingerprint of the VALIDSIG line of gpgv
+// status output, or "" if there is none
+func gpgValidSignature(status string) string {
+	scanner := bufio.NewScanner(strings.NewReader(status))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) >= 3 && fields[0] == "[GNUPG:]" && fields[1] == "VALIDSIG" {
+			return fields[2]
+		}
+	}
+	return ""
+}
+
+// gpgStatusError summarizes why gpgv rejected a signature from its status
+// output
+func gpgStatusError(status string) string {
+	scanner := bufio.NewScanner(strings.NewReader(status))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 || fields[0] != "[GNUPG:]" {
+			continue
+		}
+		switch fields[1] {
+		case "BADSIG":
+			return "bad signature"
+		case "NO_PUBKEY":
+			return "made with a key not in the keyring"
+		case "EXPKEYSIG":
+			return "made with an expired key"
+		case "REVKEYSIG":
+			return "made with a revoked key"
+		case "NODATA":
+			return "not an OpenPGP signature"
+		}
+	}
+	return "gpgv failed"
+}
+
+// dearmorKeyring returns the binary form of an ASCII-armored keyring, which
+// gpgv needs, and a binary keyring as it is
+func dearmorKeyring(data []byte) ([]byte, error) {
+	const begin = "-----BEGIN PGP PUBLIC KEY BLOCK-----"
+	if !bytes.Contains(data, []byte(begin)) {
+		return data, nil
+	}
+	var keyring []byte
+	var body strings.Builder
+	inBlock, inHeaders := false, false
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == begin:
+			inBlock, inHeaders = true, true
+			body.Reset()
+		case !inBlock:
+		case strings.HasPrefix(line, "-----END PGP"):
+			key, err := base64.StdEncoding.DecodeString(body.String())
+			if err != nil {
+				return nil, fmt.Errorf("invalid armored key: %w", err)
+			}
+			keyring = append(keyring, key...)
+			inBlock = false
+		case inHeaders:
+			// Armor headers such as Version: end at an empty line
+			if line == "" {
+				inHeaders = false
+			} else if !strings.Contains(line, ":") {
+				inHeaders = false
+				body.WriteString(line)
+			}
+		case strings.HasPrefix(line, "="):
+			// CRC-24 checksum
+		default:
+			body.WriteString(line)
+		}
+	}
+	if inBlock || len(keyring) == 0 {
+		return nil, fmt.Errorf("truncated armored key")
+	}
+	return keyring, nil
+}
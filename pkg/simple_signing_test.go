@@ -0,0 +1,185 @@
+package pkg
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/name"
+)
+
+// gpgTestKey is a GPG signing key in a temporary home directory
+type gpgTestKey struct {
+	home string
+}
+
+// newGPGTestKey generates a signing key, skipping the test without gpg
+func newGPGTestKey(t *testing.T) *gpgTestKey {
+	t.Helper()
+	for _, tool := range []string{"gpg", "gpgv", "gpgconf"} {
+		if _, err := exec.LookPath(tool); err != nil {
+			t.Skipf("%s not available", tool)
+		}
+	}
+	// gpg-agent's socket path must stay short
+	home, err := os.MkdirTemp("", "gpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	k := &gpgTestKey{home: home}
+	t.Cleanup(func() {
+		_ = exec.Command("gpgconf", "--homedir", home, "--kill", "gpg-agent").Run()
+		_ = os.RemoveAll(home)
+	})
+	k.gpg(t, nil, "--quick-gen-key", "Release <release@example.com>", "ed25519", "sign", "never")
+	return k
+}
+
+// gpg runs gpg on the key's home directory and returns its output
+func (k *gpgTestKey) gpg(t *testing.T, stdin []byte, args ...string) []byte {
+	t.Helper()
+	cmd := exec.Command("gpg", append([]string{"--homedir", k.home, "--batch", "--passphrase", ""}, args...)...)
+	cmd.Stdin = bytes.NewReader(stdin)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("gpg %v: %v: %s", args, err, stderr.String())
+	}
+	return out
+}
+
+// writeKeyring exports the public key to dir, armored or binary
+func (k *gpgTestKey) writeKeyring(t *testing.T, dir string, armor bool) string {
+	t.Helper()
+	args := []string{"--export"}
+	if armor {
+		args = append(args, "--armor")
+	}
+	path := filepath.Join(dir, "keyring")
+	if err := os.WriteFile(path, k.gpg(t, nil, args...), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// sign returns the simple signing signature of digest in repository repo
+func (k *gpgTestKey) sign(t *testing.T, repo, digest string) []byte {
+	t.Helper()
+	payload := fmt.Sprintf(`{"critical":{"identity":{"docker-reference":%q},"image":{"docker-manifest-digest":%q},"type":"atomic container signature"},"optional":{}}`, repo, digest)
+	return k.gpg(t, []byte(payload), "--sign")
+}
+
+// writeLookaside stores signatures of digest in ref's repository below the
+// lookaside directory dir
+func writeLookaside(t *testing.T, dir string, ref name.Reference, digest string, signatures ...[]byte) {
+	t.Helper()
+	for i, sig := range signatures {
+		path := strings.TrimPrefix(lookasideURL("file://"+dir, ref, digest, i+1), "file://")
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, sig, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestLookasideURL(t *testing.T) {
+	ref, _ := name.ParseReference("registry.redhat.io/ubi9/ubi:latest")
+	digest := "sha256:" + strings.Repeat("a", 64)
+	want := "https://registry.redhat.io/containers/sigstore/ubi9/ubi@sha256=" + strings.Repeat("a", 64) + "/signature-2"
+	if got := lookasideURL("https://registry.redhat.io/containers/sigstore/", ref, digest, 2); got != want {
+		t.Errorf("lookasideURL() = %s, want %s", got, want)
+	}
+}
+
+func TestGPGStatus(t *testing.T) {
+	status := "gpgv: Signature made Fri Oct 16 2026\n[GNUPG:] NEWSIG\n[GNUPG:] GOODSIG 0123456789ABCDEF Release <release@example.com>\n[GNUPG:] VALIDSIG 0123456789ABCDEF0123456789ABCDEF01234567 2026-10-16 1792108800 0 4 0 22 10 00 0123456789ABCDEF0123456789ABCDEF01234567\n"
+	if got := gpgValidSignature(status); got != "0123456789ABCDEF0123456789ABCDEF01234567" {
+		t.Errorf("gpgValidSignature() = %q", got)
+	}
+	bad := "[GNUPG:] NEWSIG\n[GNUPG:] ERRSIG 0123456789ABCDEF 22 10 00 1792108800 9 -\n[GNUPG:] NO_PUBKEY 0123456789ABCDEF\n"
+	if got := gpgValidSignature(bad); got != "" {
+		t.Errorf("gpgValidSignature() of a failed check = %q, want none", got)
+	}
+	if got := gpgStatusError(bad); !strings.Contains(got, "not in the keyring") {
+		t.Errorf("gpgStatusError() = %q", got)
+	}
+}
+
+func TestDearmorKeyring(t *testing.T) {
+	binary := []byte{0x99, 0x00, 0x33, 0x04, 0x01, 0x02}
+	if got, err := dearmorKeyring(binary); err != nil || !bytes.Equal(got, binary) {
+		t.Errorf("dearmorKeyring() of a binary keyring = %x, %v", got, err)
+	}
+	armored := "-----BEGIN PGP PUBLIC KEY BLOCK-----\nComment: test\n\nmQAzBAEC\n=AAAA\n-----END PGP PUBLIC KEY BLOCK-----\n"
+	if got, err := dearmorKeyring([]byte(armored + armored)); err != nil || !bytes.Equal(got, append(binary, binary...)) {
+		t.Errorf("dearmorKeyring() = %x, %v, want %x twice", got, err, binary)
+	}
+	if _, err := dearmorKeyring([]byte("-----BEGIN PGP PUBLIC KEY BLOCK-----\n\nmQAzBAEC\n")); err == nil {
+		t.Error("dearmorKeyring() of a truncated key succeeded")
+	}
+}
+
+func TestSimpleSigningPolicy(t *testing.T) {
+	key := newGPGTestKey(t)
+	imageRef, repo, digest := pushSignableImage(t)
+	ref, _ := name.ParseReference(imageRef)
+	keys := t.TempDir()
+	lookaside := t.TempDir()
+	setupPolicyPath(t, `{"signatures": [{"scope": "`+repo+`", "type": "simple_signing", "key_path": "`+key.writeKeyring(t, keys, true)+`", "lookaside": "file://`+lookaside+`"}]}`)
+
+	if _, err := CheckImagePolicy(t.Context(), imageRef); ErrorCode(err) != CodePolicyViolation || !strings.Contains(err.Error(), "no signatures") {
+		t.Errorf("CheckImagePolicy() without signatures error = %v, want %s", err, CodePolicyViolation)
+	}
+
+	// Signatures by another key or of another image do not count
+	other := newGPGTestKey(t)
+	writeLookaside(t, lookaside, ref, digest, other.sign(t, repo, digest), key.sign(t, "quay.io/other/os", digest))
+	if _, err := CheckImagePolicy(t.Context(), imageRef); ErrorCode(err) != CodePolicyViolation {
+		t.Errorf("CheckImagePolicy() with invalid signatures error = %v, want %s", err, CodePolicyViolation)
+	}
+
+	writeLookaside(t, lookaside, ref, digest, other.sign(t, repo, digest), key.sign(t, "quay.io/other/os", digest), key.sign(t, repo, digest))
+	if _, err := CheckImagePolicy(t.Context(), imageRef); err != nil {
+		t.Errorf("CheckImagePolicy() of a signed image error = %v", err)
+	}
+
+	// The same signatures served over HTTP, checked with a binary keyring
+	server := httptest.NewServer(http.FileServer(http.Dir(lookaside)))
+	defer server.Close()
+	req := SignatureRequirement{Scope: repo, Type: SignatureTypeSimpleSigning, KeyPath: key.writeKeyring(t, keys, false), Lookaside: server.URL}
+	if _, err := req.verifySimpleSigning(t.Context(), ref, digest); err != nil {
+		t.Errorf("verifySimpleSigning() over HTTP error = %v", err)
+	}
+	req.KeyPath = other.writeKeyring(t, t.TempDir(), false)
+	req.Lookaside = server.URL + "/missing"
+	if _, err := req.verifySimpleSigning(t.Context(), ref, digest); err == nil {
+		t.Error("verifySimpleSigning() without signatures succeeded")
+	}
+}
+
+func TestSimpleSigningPolicyValidation(t *testing.T) {
+	for _, content := range []string{
+		`{"signatures": [{"scope": "quay.io", "type": "simple_signing", "key_path": "/k.gpg"}]}`,
+		`{"signatures": [{"scope": "quay.io", "type": "simple_signing", "key_path": "/k.gpg", "lookaside": "ftp://example.com"}]}`,
+		`{"signatures": [{"scope": "quay.io", "key_path": "/k.pub", "lookaside": "https://example.com"}]}`,
+		`{"signatures": [{"scope": "quay.io", "type": "pgp", "key_path": "/k.gpg"}]}`,
+	} {
+		setupPolicyPath(t, content)
+		if _, err := ReadImagePolicy(); err == nil {
+			t.Errorf("ReadImagePolicy(%s) succeeded, want error", content)
+		}
+	}
+	setupPolicyPath(t, `{"signatures": [{"scope": "quay.io", "type": "simple_signing", "key_path": "/k.gpg", "lookaside": "https://example.com/sigstore"}]}`)
+	if _, err := ReadImagePolicy(); err != nil {
+		t.Errorf("ReadImagePolicy() error = %v", err)
+	}
+}
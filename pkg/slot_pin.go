@@ -0,0 +1,129 @@
+package pkg
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// SlotPinPath records the root slot that updates must not overwrite. It
+// lives in /var so both roots see it.
+var SlotPinPath = "/var/lib/phukit/pinned-slot.json"
+
+// SlotPin protects the root slot holding a known-good deployment: updates
+// refuse to write to it until it is unpinned
+type SlotPin struct {
+	Slot        string    `json:"slot"` // root1 or root2
+	Partition   string    `json:"partition"`
+	UUID        string    `json:"uuid,omitempty"` // Filesystem UUID, which survives renamed devices
+	ImageRef    string    `json:"image_ref,omitempty"`
+	ImageDigest string    `json:"image_digest,omitempty"`
+	Time        time.Time `json:"time"` // When the slot was pinned
+}
+
+// ReadSlotPin returns the pinned slot, or nil if no slot is pinned
+func ReadSlotPin() (*SlotPin, error) {
+	data, err := os.ReadFile(SlotPinPath)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read slot pin: %w", err)
+	}
+	var pin SlotPin
+	if err := json.Unmarshal(data, &pin); err != nil {
+		return nil, fmt.Errorf("failed to parse slot pin %s: %w", SlotPinPath, err)
+	}
+	return &pin, nil
+}
+
+// writeSlotPin records pin as the pinned slot
+func writeSlotPin(pin *SlotPin) error {
+	if err := os.MkdirAll(filepath.Dir(SlotPinPath), 0755); err != nil {
+		return fmt.Errorf("failed to create slot pin directory: %w", err)
+	}
+	data, err := json.MarshalIndent(pin, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal slot pin: %w", err)
+	}
+	if err := writeFileAtomic(SlotPinPath, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to write slot pin: %w", err)
+	}
+	return nil
+}
+
+// PinRollbackSlot pins the inactive root slot on device, the one the next
+// update would overwrite, and returns the pin. The slot must hold a
+// deployment.
+func PinRollbackSlot(device string, dryRun bool) (*SlotPin, error) {
+	partition, slot, err := inactiveRootSlot(device)
+	if err != nil {
+		return nil, err
+	}
+	pin := &SlotPin{Partition: partition, Slot: slot, Time: time.Now().UTC()}
+	if pin.UUID, err = GetPartitionUUID(pin.Partition); err != nil {
+		return nil, fmt.Errorf("no deployment on %s to pin: %w", pin.Partition, err)
+	}
+	if err := withReadOnlyMount(pin.Partition, func(root string) error {
+		d, err := ReadDeployment(root)
+		if err != nil {
+			return err
+		}
+		pin.ImageRef, pin.ImageDigest = d.ImageRef, d.ImageDigest
+		return nil
+	}); err != nil {
+		return nil, fmt.Errorf("no deployment on %s to pin: %w", pin.Partition, err)
+	}
+
+	if dryRun {
+		fmt.Printf("[DRY RUN] Would pin %s (%s)\n", pin.Partition, pin.Slot)
+		return pin, nil
+	}
+	if err := writeSlotPin(pin); err != nil {
+		return nil, err
+	}
+	return pin, nil
+}
+
+// UnpinSlot removes the slot pin and returns it, or nil if no slot was pinned
+func UnpinSlot(dryRun bool) (*SlotPin, error) {
+	pin, err := ReadSlotPin()
+	if err != nil || pin == nil {
+		return nil, err
+	}
+	if dryRun {
+		fmt.Printf("[DRY RUN] Would unpin %s (%s)\n", pin.Partition, pin.Slot)
+		return pin, nil
+	}
+	if err := os.Remove(SlotPinPath); err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to remove slot pin: %w", err)
+	}
+	return pin, nil
+}
+
+// protects reports whether the pin covers partition, whose filesystem UUID
+// is uuid ("" if unreadable)
+func (p *SlotPin) protects(partition, uuid string) bool {
+	if p.UUID != "" && uuid != "" {
+		return p.UUID == uuid
+	}
+	return p.Partition == partition
+}
+
+// checkSlotPin fails if the update target is the pinned slot
+func (u *SystemUpdater) checkSlotPin() error {
+	pin, err := ReadSlotPin()
+	if err != nil || pin == nil {
+		return err
+	}
+	uuid, _ := GetPartitionUUID(u.Target)
+	if !pin.protects(u.Target, uuid) {
+		return nil
+	}
+	image := pin.ImageRef
+	if pin.ImageDigest != "" {
+		image += "@" + pin.ImageDigest
+	}
+	return fmt.Errorf("%w: %s (%s) holds %s; run 'phukit unpin' to allow updates to overwrite it", ErrSlotPinned, u.Target, pin.Slot, image)
+}
@@ -0,0 +1,95 @@
+package pkg
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func setupSlotPinPath(t *testing.T) {
+	t.Helper()
+	old := SlotPinPath
+	SlotPinPath = filepath.Join(t.TempDir(), "var", "lib", "phukit", "pinned-slot.json")
+	t.Cleanup(func() { SlotPinPath = old })
+}
+
+func TestSlotPinRoundTrip(t *testing.T) {
+	setupSlotPinPath(t)
+
+	pin, err := ReadSlotPin()
+	if pin != nil || err != nil {
+		t.Fatalf("ReadSlotPin() without pin = %v, %v; want nil, nil", pin, err)
+	}
+	if pin, err := UnpinSlot(false); pin != nil || err != nil {
+		t.Errorf("UnpinSlot() without pin = %v, %v; want nil, nil", pin, err)
+	}
+
+	want := &SlotPin{Slot: "root2", Partition: "/dev/sda4", UUID: "1234", ImageRef: "quay.io/example/os:latest"}
+	if err := writeSlotPin(want); err != nil {
+		t.Fatalf("writeSlotPin() error = %v", err)
+	}
+	got, err := ReadSlotPin()
+	if err != nil || got == nil || *got != *want {
+		t.Fatalf("ReadSlotPin() = %+v, %v; want %+v", got, err, want)
+	}
+
+	// A dry run keeps the pin
+	if pin, err := UnpinSlot(true); err != nil || pin == nil {
+		t.Fatalf("UnpinSlot(dry run) = %v, %v", pin, err)
+	}
+	if _, err := os.Stat(SlotPinPath); err != nil {
+		t.Errorf("dry run removed the pin: %v", err)
+	}
+	if pin, err := UnpinSlot(false); err != nil || pin == nil || pin.Slot != "root2" {
+		t.Fatalf("UnpinSlot() = %v, %v; want the removed pin", pin, err)
+	}
+	if pin, _ := ReadSlotPin(); pin != nil {
+		t.Errorf("pin still present after UnpinSlot(): %+v", pin)
+	}
+}
+
+func TestSlotPinProtects(t *testing.T) {
+	pin := &SlotPin{Slot: "root2", Partition: "/dev/sda4", UUID: "1234"}
+	tests := []struct {
+		name      string
+		pin       *SlotPin
+		partition string
+		uuid      string
+		want      bool
+	}{
+		{"same filesystem", pin, "/dev/sda4", "1234", true},
+		{"renamed device", pin, "/dev/sdb4", "1234", true},
+		{"other root", pin, "/dev/sda3", "5678", false},
+		{"reformatted", pin, "/dev/sda4", "5678", false},
+		{"unreadable UUID", pin, "/dev/sda4", "", true},
+		{"pin without UUID", &SlotPin{Partition: "/dev/sda4"}, "/dev/sda3", "5678", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.pin.protects(tt.partition, tt.uuid); got != tt.want {
+				t.Errorf("protects(%s, %q) = %v, want %v", tt.partition, tt.uuid, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckSlotPin(t *testing.T) {
+	setupSlotPinPath(t)
+	u := &SystemUpdater{Target: filepath.Join(t.TempDir(), "missing-partition")}
+
+	if err := u.checkSlotPin(); err != nil {
+		t.Errorf("checkSlotPin() without pin = %v", err)
+	}
+	if err := writeSlotPin(&SlotPin{Slot: "root2", Partition: u.Target}); err != nil {
+		t.Fatal(err)
+	}
+	err := u.checkSlotPin()
+	if !errors.Is(err, ErrSlotPinned) || ErrorCode(err) != CodeSlotPinned {
+		t.Errorf("checkSlotPin() on the pinned slot = %v, want ErrSlotPinned", err)
+	}
+	u.Target = "/dev/other"
+	if err := u.checkSlotPin(); err != nil {
+		t.Errorf("checkSlotPin() on another slot = %v", err)
+	}
+}
@@ -0,0 +1,96 @@
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// NextRootPath is where systemd soft-reboot looks for the root file system
+// to switch into
+var NextRootPath = "/run/nextroot"
+
+// SoftRebootRootFile records the root partition a soft reboot switched to.
+// /proc/cmdline still names the root the firmware booted, and /run survives
+// soft reboots but not real ones.
+var SoftRebootRootFile = "/run/phukit/soft-reboot-root"
+
+// SoftReboot switches the running system into the staged update with
+// systemd's soft-reboot: userspace restarts on the new root without going
+// through the firmware, bootloader or kernel. The running kernel is kept, so
+// the new root must ship modules for it.
+func (u *SystemUpdater) SoftReboot(ctx context.Context) error {
+	defer u.runCommands()()
+	if !u.Staged {
+		return fmt.Errorf("no update is staged to apply")
+	}
+	if u.Config.Verity {
+		return fmt.Errorf("a dm-verity root is opened by the initramfs and cannot be soft rebooted into; reboot instead")
+	}
+	var uts unix.Utsname
+	if err := unix.Uname(&uts); err != nil {
+		return fmt.Errorf("failed to get kernel version: %w", err)
+	}
+	release := unix.ByteSliceToString(uts.Release[:])
+
+	fmt.Printf("Preparing %s as the next root...\n", u.Target)
+	if err := os.MkdirAll(NextRootPath, 0755); err != nil {
+		return fmt.Errorf("failed to create %s: %w", NextRootPath, err)
+	}
+	if err := mountDevice(u.Target, NextRootPath, false); err != nil {
+		return fmt.Errorf("failed to mount %s: %w", u.Target, err)
+	}
+	mounted := []string{NextRootPath}
+	cleanup := func() {
+		for i := len(mounted) - 1; i >= 0; i-- {
+			_ = unmount(mounted[i])
+		}
+	}
+
+	// The initramfs normally mounts a composefs /usr
+	if u.Config.Composefs {
+		usr := filepath.Join(NextRootPath, "usr")
+		options := "ro,basedir=" + ComposefsObjectsPath + ",digest=" + u.targetComposefs
+		if out, err := runCommand(ctx, "mount", "-t", "composefs", "-o", options, filepath.Join(NextRootPath, composefsImagePath), usr); err != nil {
+			cleanup()
+			return fmt.Errorf("failed to mount composefs /usr: %w\nOutput: %s", err, strings.TrimSpace(string(out)))
+		}
+		mounted = append(mounted, usr)
+	}
+
+	if _, err := os.Stat(filepath.Join(NextRootPath, "usr", "lib", "modules", release)); err != nil {
+		cleanup()
+		return fmt.Errorf("the new root has no modules for the running kernel %s; reboot instead", release)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(SoftRebootRootFile), 0755); err != nil {
+		cleanup()
+		return fmt.Errorf("failed to create %s: %w", filepath.Dir(SoftRebootRootFile), err)
+	}
+	if err := writeFileAtomic(SoftRebootRootFile, []byte(u.Target+"\n"), 0644); err != nil {
+		cleanup()
+		return fmt.Errorf("failed to record the new root: %w", err)
+	}
+
+	fmt.Println("Soft rebooting into the new root...")
+	if out, err := runCommand(ctx, "systemctl", "soft-reboot"); err != nil {
+		_ = os.Remove(SoftRebootRootFile)
+		cleanup()
+		return fmt.Errorf("systemctl soft-reboot failed (systemd 254 or newer is required): %w\nOutput: %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// softRebootRoot returns the root partition the system soft rebooted into,
+// or "" if it has not soft rebooted since boot
+func softRebootRoot() string {
+	data, err := os.ReadFile(SoftRebootRootFile)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
@@ -0,0 +1,81 @@
+package pkg
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestSoftReboot(t *testing.T) {
+	var uts unix.Utsname
+	if err := unix.Uname(&uts); err != nil {
+		t.Fatal(err)
+	}
+	release := unix.ByteSliceToString(uts.Release[:])
+
+	setup := func(t *testing.T, modules bool) (*SystemUpdater, *DryRunExecutor, *fakeMounter) {
+		dir := t.TempDir()
+		oldNext, oldFile := NextRootPath, SoftRebootRootFile
+		NextRootPath = filepath.Join(dir, "nextroot")
+		SoftRebootRootFile = filepath.Join(dir, "phukit", "soft-reboot-root")
+		t.Cleanup(func() { NextRootPath, SoftRebootRootFile = oldNext, oldFile })
+		if modules {
+			writeFakeFile(t, filepath.Join(NextRootPath, "usr", "lib", "modules", release, "modules.dep"), "")
+		}
+
+		fake, _ := setupDryRunExecutor(t)
+
+		u := NewSystemUpdater("/dev/sda", "quay.io/example/os:latest")
+		u.Target = "/dev/sda3"
+		u.Staged = true
+		return u, fake, setupFakeMounter(t, "ext4")
+	}
+
+	t.Run("switches into the staged root", func(t *testing.T) {
+		u, fake, _ := setup(t, true)
+		if err := u.SoftReboot(context.Background()); err != nil {
+			t.Fatalf("SoftReboot() error = %v", err)
+		}
+		if len(fake.Commands) != 1 || fake.Commands[0].String() != "systemctl soft-reboot" {
+			t.Errorf("commands = %v, want systemctl soft-reboot", fake.Commands)
+		}
+		if got := softRebootRoot(); got != "/dev/sda3" {
+			t.Errorf("softRebootRoot() = %q, want /dev/sda3", got)
+		}
+	})
+
+	t.Run("new root lacks modules for the running kernel", func(t *testing.T) {
+		u, fake, mounts := setup(t, false)
+		if err := u.SoftReboot(context.Background()); err == nil {
+			t.Fatal("SoftReboot() expected error without modules")
+		}
+		if len(fake.Commands) != 0 {
+			t.Errorf("commands = %v, want none", fake.Commands)
+		}
+		if len(mounts.unmounts) != 1 || mounts.unmounts[0] != NextRootPath {
+			t.Errorf("unmounts = %v, want %s", mounts.unmounts, NextRootPath)
+		}
+		if _, err := os.Stat(SoftRebootRootFile); !os.IsNotExist(err) {
+			t.Error("soft reboot root recorded after failing")
+		}
+	})
+
+	t.Run("nothing staged", func(t *testing.T) {
+		u, _, _ := setup(t, true)
+		u.Staged = false
+		if err := u.SoftReboot(context.Background()); err == nil {
+			t.Error("SoftReboot() expected error without a staged update")
+		}
+	})
+
+	t.Run("verity root", func(t *testing.T) {
+		u, _, _ := setup(t, true)
+		u.Config.Verity = true
+		if err := u.SoftReboot(context.Background()); err == nil {
+			t.Error("SoftReboot() expected error for a dm-verity root")
+		}
+	})
+}
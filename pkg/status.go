@@ -0,0 +1,90 @@
+package pkg
+
+import (
+	"fmt"
+	"strings"
+)
+
+// SystemStatus describes the installed system, for status commands and the
+// management interfaces
+type SystemStatus struct {
+	Config     *SystemConfig `json:"config"`
+	Booted     *Deployment   `json:"booted,omitempty"`      // Image of the running root, if recorded
+	ActiveRoot string        `json:"active_root,omitempty"` // Empty if it could not be determined
+	ActiveSlot string        `json:"active_slot,omitempty"` // root1 or root2
+	Operation  string        `json:"operation,omitempty"`   // Operation a Manager is running
+	SlotPin    *SlotPin      `json:"slot_pin,omitempty"`    // Root slot updates must not overwrite
+	UsrOverlay bool          `json:"usr_overlay,omitempty"` // A transient writable overlay is mounted on /usr
+}
+
+// GetSystemStatus reads the system configuration and works out which root
+// slot is booted. Failing to find the active root is not an error.
+func GetSystemStatus() (*SystemStatus, error) {
+	config, err := ReadSystemConfig()
+	if err != nil {
+		return nil, err
+	}
+	status := &SystemStatus{Config: config}
+	// An unreadable pin still stops updates, which report the error
+	status.SlotPin, _ = ReadSlotPin()
+	status.UsrOverlay, _ = UsrOverlayActive()
+	status.Booted, _ = ReadDeployment(bootedRoot)
+
+	activeRoot, err := GetActiveRootPartition()
+	if err != nil {
+		return status, nil
+	}
+	status.ActiveRoot = activeRoot
+	if config.Device != "" {
+		if scheme, err := DetectExistingPartitionScheme(config.Device); err == nil {
+			status.ActiveSlot = rootSlot(scheme, activeRoot)
+		}
+	}
+	return status, nil
+}
+
+// rootSlot returns root1 or root2 for partition, or "" if it is neither
+func rootSlot(scheme *PartitionScheme, partition string) string {
+	matches := func(p string) bool {
+		return partition == p || strings.HasSuffix(partition, strings.TrimPrefix(p, "/dev/"))
+	}
+	switch {
+	case matches(scheme.Root1Partition):
+		return "root1"
+	case matches(scheme.Root2Partition):
+		return "root2"
+	}
+	return ""
+}
+
+// inactiveRootSlot returns the root partition on device that is not booted
+// and its slot. Unlike GetInactiveRootPartition it fails if the booted root
+// is not on device.
+func inactiveRootSlot(device string) (partition, slot string, err error) {
+	scheme, err := DetectExistingPartitionScheme(device)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to detect partition scheme: %w", err)
+	}
+	active, err := GetActiveRootPartition()
+	if err != nil {
+		return "", "", err
+	}
+	switch rootSlot(scheme, active) {
+	case "root1":
+		return scheme.Root2Partition, "root2", nil
+	case "root2":
+		return scheme.Root1Partition, "root1", nil
+	}
+	return "", "", fmt.Errorf("booted root %s is not on %s", active, device)
+}
+
+// SlotLabel returns the A/B label shown for a root slot, such as "A (root1)"
+func SlotLabel(slot string) string {
+	switch slot {
+	case "root1":
+		return "A (root1)"
+	case "root2":
+		return "B (root2)"
+	}
+	return fmt.Sprintf("unknown (%s)", slot)
+}
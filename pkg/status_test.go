@@ -0,0 +1,38 @@
+package pkg
+
+import "testing"
+
+func TestRootSlot(t *testing.T) {
+	scheme := &PartitionScheme{
+		Root1Partition: "/dev/nvme0n1p2",
+		Root2Partition: "/dev/nvme0n1p3",
+	}
+	tests := []struct {
+		partition string
+		want      string
+	}{
+		{"/dev/nvme0n1p2", "root1"},
+		{"/dev/nvme0n1p3", "root2"},
+		{"nvme0n1p3", "root2"},
+		{"/dev/nvme0n1p4", ""},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := rootSlot(scheme, tt.partition); got != tt.want {
+			t.Errorf("rootSlot(%q) = %q, want %q", tt.partition, got, tt.want)
+		}
+	}
+}
+
+func TestSlotLabel(t *testing.T) {
+	tests := map[string]string{
+		"root1": "A (root1)",
+		"root2": "B (root2)",
+		"":      "unknown ()",
+	}
+	for slot, want := range tests {
+		if got := SlotLabel(slot); got != want {
+			t.Errorf("SlotLabel(%q) = %q, want %q", slot, got, want)
+		}
+	}
+}
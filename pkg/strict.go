@@ -0,0 +1,50 @@
+package pkg
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// ErrStrict is wrapped by errors returned in place of warnings in strict mode
+var ErrStrict = errors.New("warning treated as error (strict mode)")
+
+// strictMode turns every warning into a hard error
+var strictMode bool
+
+// SetStrictMode enables or disables strict mode. In strict mode any condition
+// that would normally print a warning and continue fails the operation instead,
+// for CI pipelines where silent degradation is unacceptable.
+func SetStrictMode(strict bool) {
+	strictMode = strict
+}
+
+// StrictMode reports whether strict mode is enabled
+func StrictMode() bool {
+	return strictMode
+}
+
+// warnf reports a non-fatal problem. Leading spaces in format are kept as the
+// indentation of the printed warning. In strict mode nothing is printed and the
+// warning is returned as an error wrapping ErrStrict, so callers must propagate
+// a non-nil result.
+func warnf(format string, args ...any) error {
+	trimmed := strings.TrimLeft(format, " ")
+	indent := format[:len(format)-len(trimmed)]
+	msg := fmt.Sprintf(trimmed, args...)
+
+	logger.Warn(msg, "strict", strictMode)
+	if strictMode {
+		return fmt.Errorf("%s: %w", msg, ErrStrict)
+	}
+	printWarning(indent, msg)
+	return nil
+}
+
+// printWarning prints msg as a warning after indent. Unlike warnf it does not
+// fail in strict mode, for problems that are handled, such as a retried
+// request.
+func printWarning(indent, msg string) {
+	fmt.Fprintf(os.Stderr, "%s%s %s\n", indent, colorize(ansiYellow, "Warning:"), msg)
+}
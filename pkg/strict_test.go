@@ -0,0 +1,32 @@
+package pkg
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestWarnf(t *testing.T) {
+	t.Cleanup(func() { SetStrictMode(false) })
+
+	t.Run("non-strict returns nil", func(t *testing.T) {
+		SetStrictMode(false)
+		if err := warnf("  something degraded: %v", errors.New("boom")); err != nil {
+			t.Errorf("warnf() = %v, want nil", err)
+		}
+	})
+
+	t.Run("strict returns error", func(t *testing.T) {
+		SetStrictMode(true)
+		err := warnf("  something degraded: %v", errors.New("boom"))
+		if err == nil {
+			t.Fatal("warnf() = nil, want error in strict mode")
+		}
+		if !errors.Is(err, ErrStrict) {
+			t.Errorf("warnf() error %v does not wrap ErrStrict", err)
+		}
+		if !strings.HasPrefix(err.Error(), "something degraded: boom") {
+			t.Errorf("warnf() error = %q, want message without indentation", err.Error())
+		}
+	})
+}
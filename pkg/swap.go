@@ -0,0 +1,84 @@
+package pkg
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Swap and hibernation
+//
+// A swap partition is created after the other partitions, so the root and
+// /var partitions keep their numbers. If it can hold all of RAM, the system
+// is set up to hibernate: the kernel command line gets resume= with the UUID
+// of the swap partition and the initramfs is rebuilt with resume support, as
+// the image initramfs is generic and may lack it. The UUID is recorded in the
+// system config so updates keep both on the new root.
+
+// procMeminfoPath is read for the size of RAM, overridable in tests
+var procMeminfoPath = "/proc/meminfo"
+
+// MemoryTotal returns the size of RAM in bytes
+func MemoryTotal() (uint64, error) {
+	f, err := os.Open(procMeminfoPath)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read memory size: %w", err)
+	}
+	defer func() { _ = f.Close() }()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 || fields[0] != "MemTotal:" {
+			continue
+		}
+		kib, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("failed to parse MemTotal %q: %w", fields[1], err)
+		}
+		return kib * 1024, nil
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, fmt.Errorf("failed to read memory size: %w", err)
+	}
+	return 0, fmt.Errorf("no MemTotal in %s", procMeminfoPath)
+}
+
+// HibernationSwapSize returns the size of a swap partition that holds all
+// of RAM, rounded up to a whole GiB
+func HibernationSwapSize() (uint64, error) {
+	const gib = 1024 * 1024 * 1024
+	mem, err := MemoryTotal()
+	if err != nil {
+		return 0, err
+	}
+	return (mem + gib - 1) / gib * gib, nil
+}
+
+// swapCanHibernate reports whether a swap partition of swapSize bytes can
+// hold all of RAM, explaining why not otherwise
+func swapCanHibernate(swapSize uint64) (bool, error) {
+	mem, err := MemoryTotal()
+	if err != nil {
+		if werr := warnf("  cannot configure hibernation: %v", err); werr != nil {
+			return false, werr
+		}
+		return false, nil
+	}
+	if swapSize < mem {
+		fmt.Printf("  Swap (%s) is smaller than RAM (%s), hibernation is not configured\n", FormatSize(swapSize), FormatSize(mem))
+		return false, nil
+	}
+	return true, nil
+}
+
+// resumeKernelArgs returns the kernel arguments that resume from
+// hibernation on the swap partition with UUID uuid, or none if uuid is ""
+func resumeKernelArgs(uuid string) []string {
+	if uuid == "" {
+		return nil
+	}
+	return []string{"resume=UUID=" + uuid}
+}
@@ -0,0 +1,50 @@
+package pkg
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestMemoryTotal(t *testing.T) {
+	orig := procMeminfoPath
+	t.Cleanup(func() { procMeminfoPath = orig })
+	procMeminfoPath = filepath.Join(t.TempDir(), "meminfo")
+	writeFakeFile(t, procMeminfoPath, "MemTotal:        8048576 kB\nMemFree:         1234567 kB\n")
+
+	got, err := MemoryTotal()
+	if err != nil {
+		t.Fatalf("MemoryTotal() error = %v", err)
+	}
+	if got != 8048576*1024 {
+		t.Errorf("MemoryTotal() = %d, want %d", got, 8048576*1024)
+	}
+
+	for _, size := range []uint64{8048576 * 1024, 16 << 30} {
+		if ok, err := swapCanHibernate(size); err != nil || !ok {
+			t.Errorf("swapCanHibernate(%d) = %v, %v, want true", size, ok, err)
+		}
+	}
+	if ok, err := swapCanHibernate(4 << 30); err != nil || ok {
+		t.Errorf("swapCanHibernate(4GiB) = %v, %v, want false", ok, err)
+	}
+
+	if got, err := HibernationSwapSize(); err != nil || got != 8<<30 {
+		t.Errorf("HibernationSwapSize() = %d, %v, want %d", got, err, 8<<30)
+	}
+
+	writeFakeFile(t, procMeminfoPath, "MemFree: 1 kB\n")
+	if _, err := MemoryTotal(); err == nil {
+		t.Error("MemoryTotal() expected error without MemTotal")
+	}
+}
+
+func TestResumeKernelArgs(t *testing.T) {
+	if got := resumeKernelArgs(""); len(got) != 0 {
+		t.Errorf("resumeKernelArgs(\"\") = %v, want none", got)
+	}
+	want := []string{"resume=UUID=aaaa"}
+	if got := resumeKernelArgs("aaaa"); !reflect.DeepEqual(got, want) {
+		t.Errorf("resumeKernelArgs() = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,212 @@
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Takeover installs
+//
+// A takeover converts a conventionally installed machine in place. The image
+// is extracted to a spare partition, which becomes the first root, and the
+// running system's root becomes the second root, which the first update
+// overwrites. The existing EFI system partition becomes the boot partition,
+// and /etc and /var are carried over from the running system. Nothing the
+// running system boots from is changed until the bootloader is installed.
+
+// espMountPoints are where distributions mount the EFI system partition
+var espMountPoints = []string{"/boot/efi", "/efi", "/boot"}
+
+// TakeoverPartitionScheme maps the running system to an A/B layout: its EFI
+// system partition, the spare partition target for the new root and the
+// spare partition varPartition for /var. The booted root becomes the second
+// root. It also returns the disk holding the roots.
+func TakeoverPartitionScheme(target, varPartition string) (*PartitionScheme, string, error) {
+	mounts, err := readMounts()
+	if err != nil {
+		return nil, "", err
+	}
+	scheme, err := takeoverPartitionScheme(mounts, target, varPartition)
+	if err != nil {
+		return nil, "", err
+	}
+
+	device, err := GetBootDeviceFromPartition(scheme.Root2Partition)
+	if err != nil {
+		return nil, "", err
+	}
+	if disk, err := GetBootDeviceFromPartition(target); err != nil || disk != device {
+		return nil, "", fmt.Errorf("new root %s must be on %s with the running root", target, device)
+	}
+	for _, part := range []string{target, varPartition} {
+		if _, err := os.Stat(part); err != nil {
+			return nil, "", fmt.Errorf("%w: %s", ErrDeviceNotFound, part)
+		}
+		// Active swap, LVM, dm-crypt and RAID do not show up as mounts
+		if err := ensureDeviceNotInUse(part); err != nil {
+			return nil, "", err
+		}
+	}
+	return scheme, device, nil
+}
+
+// takeoverPartitionScheme maps the running system with mounts to an A/B
+// layout with the spare partitions target and varPartition
+func takeoverPartitionScheme(mounts []mountEntry, target, varPartition string) (*PartitionScheme, error) {
+	root, err := findPartitionMount(mounts, "/")
+	if err != nil {
+		return nil, fmt.Errorf("the running root must be a partition: %w", err)
+	}
+	if root.fsType != string(FilesystemExt4) && root.fsType != string(FilesystemBtrfs) {
+		return nil, fmt.Errorf("unsupported root filesystem: %s (supported: ext4, btrfs)", root.fsType)
+	}
+
+	var esp mountEntry
+	for _, path := range espMountPoints {
+		if m, err := findPartitionMount(mounts, path); err == nil && m.fsType == "vfat" {
+			esp = m
+			break
+		}
+	}
+	if esp.source == "" {
+		return nil, fmt.Errorf("no EFI system partition is mounted at %s", strings.Join(espMountPoints, ", "))
+	}
+
+	if sameDevice(target, varPartition) {
+		return nil, fmt.Errorf("the new root and /var need separate partitions")
+	}
+	for _, part := range []string{target, varPartition} {
+		if sameDevice(part, root.source) {
+			return nil, fmt.Errorf("%s is the running root; choose a spare partition", part)
+		}
+		if sameDevice(part, esp.source) {
+			return nil, fmt.Errorf("%s is the EFI system partition; choose a spare partition", part)
+		}
+	}
+	// The partitions may be given as links, such as /dev/disk/by-partlabel
+	// ones, which /proc/mounts never lists
+	for _, m := range mounts {
+		if filepath.IsAbs(m.source) && (sameDevice(m.source, target) || sameDevice(m.source, varPartition)) {
+			return nil, fmt.Errorf("%s is mounted at %s", m.source, m.target)
+		}
+		// Nested mounts would be copied as part of /var
+		if strings.HasPrefix(m.target, "/var/") {
+			return nil, fmt.Errorf("%s is mounted at %s; unmount it so /var can be copied", m.source, m.target)
+		}
+	}
+
+	return &PartitionScheme{
+		BootPartition:  esp.source,
+		Root1Partition: target,
+		Root2Partition: root.source,
+		VarPartition:   varPartition,
+		FilesystemType: root.fsType,
+	}, nil
+}
+
+// InstallToExistingRoot takes over the running system (see
+// TakeoverPartitionScheme): it formats the new root and var partitions of
+// scheme, installs the image to them, carries /etc and /var over and makes
+// the new root the default boot entry
+func (b *BootcInstaller) InstallToExistingRoot(ctx context.Context, scheme *PartitionScheme, skipPull bool) (err error) {
+	fmt.Println("Checking prerequisites...")
+	if err := CheckRequiredTools(); err != nil {
+		return fmt.Errorf("missing required tools: %w", err)
+	}
+	if b.Verity {
+		return fmt.Errorf("a dm-verity root needs the hash partitions of a full install; it is not supported with to-existing-root")
+	}
+	b.FilesystemType = scheme.FilesystemType
+	if err := b.checkRootOptions(); err != nil {
+		return err
+	}
+
+	if err := b.checkImagePolicy(ctx); err != nil {
+		return err
+	}
+	if !skipPull {
+		if err := b.PullImage(ctx); err != nil {
+			return err
+		}
+	}
+
+	if !b.DryRun && !b.AssumeYes && !confirm(
+		fmt.Sprintf("WARNING: This will DESTROY ALL DATA on %s and %s!", scheme.Root1Partition, scheme.VarPartition),
+		fmt.Sprintf("The running root %s becomes the second root and is overwritten by the first update.", scheme.Root2Partition),
+	) {
+		return fmt.Errorf("installation %w", ErrAborted)
+	}
+
+	defer b.runCommands()()
+	if b.DryRun {
+		fmt.Printf("[DRY RUN] Would take over the running system with %s on %s\n", b.ImageRef, scheme.Root1Partition)
+		if tracing() {
+			for _, part := range []struct{ device, label string }{{scheme.Root1Partition, "root1"}, {scheme.VarPartition, "var"}} {
+				if err := formatPartition(ctx, part.device, scheme.FilesystemType, part.label, b.discard()); err != nil {
+					return fmt.Errorf("failed to format %s partition: %w", part.label, err)
+				}
+			}
+		}
+		return nil
+	}
+
+	defer withLogAttrs("image", b.ImageRef, "target", scheme.Root1Partition)()
+	b.layout = scheme
+	b.takeover = true
+	recordResult(func(r *OperationResult) {
+		r.ImageRef, r.Device = b.ImageRef, b.Device
+		r.Partitions = scheme
+		r.TargetSlot, r.TargetPartition = "root1", scheme.Root1Partition
+	})
+
+	fmt.Printf("Taking over the running system...\n")
+	fmt.Printf("  Image:      %s\n", b.ImageRef)
+	fmt.Printf("  Filesystem: %s\n", scheme.FilesystemType)
+	fmt.Printf("  Boot:       %s\n", scheme.BootPartition)
+	fmt.Printf("  Root1:      %s (new)\n", scheme.Root1Partition)
+	fmt.Printf("  Root2:      %s (running)\n", scheme.Root2Partition)
+	fmt.Printf("  Var:        %s (new)\n", scheme.VarPartition)
+	fmt.Println()
+
+	// Step 1: Format the new partitions
+	printStep("Step 1/5: Formatting partitions...")
+	fmt.Printf("  Formatting %s as %s...\n", scheme.Root1Partition, scheme.FilesystemType)
+	if err := formatPartition(ctx, scheme.Root1Partition, scheme.FilesystemType, "root1", b.discard()); err != nil {
+		return fmt.Errorf("failed to format root1 partition: %w", err)
+	}
+	fmt.Printf("  Formatting %s as %s...\n", scheme.VarPartition, scheme.FilesystemType)
+	if err := formatPartition(ctx, scheme.VarPartition, scheme.FilesystemType, "var", b.discard()); err != nil {
+		return fmt.Errorf("failed to format var partition: %w", err)
+	}
+
+	// Step 2: Mount partitions
+	printStep("\nStep 2/5: Mounting partitions...")
+	if err := MountPartitions(scheme, b.MountPoint, b.DryRun); err != nil {
+		return fmt.Errorf("failed to mount partitions: %w", err)
+	}
+	defer func() {
+		fmt.Println("\nCleaning up...")
+		if uerr := UnmountPartitions(b.MountPoint, b.DryRun); uerr != nil && err == nil {
+			err = fmt.Errorf("failed to unmount partitions: %w", uerr)
+		}
+		_ = os.RemoveAll(b.MountPoint)
+	}()
+
+	if err := cancelled(ctx); err != nil {
+		return err
+	}
+	return b.installRoot(ctx, scheme, 3, 5)
+}
+
+// migrateRunningSystem copies the running system's /var into the var
+// partition mounted under targetDir and merges its /etc into the new root
+func migrateRunningSystem(targetDir string) error {
+	fmt.Println("  Copying /var from the running system...")
+	if err := copyTree("/var", filepath.Join(targetDir, "var"), false); err != nil {
+		return fmt.Errorf("failed to copy /var: %w", err)
+	}
+	return MergeEtc(targetDir, "/etc", false)
+}
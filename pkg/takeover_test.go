@@ -0,0 +1,106 @@
+package pkg
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestTakeoverPartitionScheme(t *testing.T) {
+	running := []mountEntry{
+		{source: "/dev/sda2", target: "/", fsType: "ext4"},
+		{source: "/dev/sda1", target: "/boot/efi", fsType: "vfat"},
+		{source: "tmpfs", target: "/run", fsType: "tmpfs"},
+	}
+	tests := []struct {
+		name    string
+		mounts  []mountEntry
+		want    PartitionScheme
+		wantErr bool
+	}{
+		{
+			name:   "running system",
+			mounts: running,
+			want: PartitionScheme{
+				BootPartition:  "/dev/sda1",
+				Root1Partition: "/dev/sda3",
+				Root2Partition: "/dev/sda2",
+				VarPartition:   "/dev/sda4",
+				FilesystemType: "ext4",
+			},
+		},
+		{
+			name:    "no EFI system partition",
+			mounts:  []mountEntry{running[0]},
+			wantErr: true,
+		},
+		{
+			name:    "new root is mounted",
+			mounts:  append([]mountEntry{{source: "/dev/sda3", target: "/home", fsType: "ext4"}}, running...),
+			wantErr: true,
+		},
+		{
+			name:    "mount nested in /var",
+			mounts:  append([]mountEntry{{source: "sunrpc", target: "/var/lib/nfs/rpc_pipefs", fsType: "rpc_pipefs"}}, running...),
+			wantErr: true,
+		},
+		{
+			name: "unsupported root filesystem",
+			mounts: []mountEntry{
+				{source: "/dev/sda2", target: "/", fsType: "xfs"},
+				running[1],
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := takeoverPartitionScheme(tt.mounts, "/dev/sda3", "/dev/sda4")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("takeoverPartitionScheme() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && *got != tt.want {
+				t.Errorf("takeoverPartitionScheme() = %+v, want %+v", *got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTakeoverPartitionSchemeRefusesInUse(t *testing.T) {
+	// Partitions named by links resolve to the devices /proc/mounts lists;
+	// character devices that always exist stand in for the partitions
+	dir := t.TempDir()
+	link := func(name, dev string) string {
+		path := filepath.Join(dir, name)
+		if err := os.Symlink(dev, path); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	mounts := []mountEntry{
+		{source: "/dev/null", target: "/", fsType: "ext4"},
+		{source: "/dev/zero", target: "/boot/efi", fsType: "vfat"},
+		{source: "/dev/full", target: "/srv", fsType: "ext4"},
+	}
+	spare := link("spare", "/dev/random")
+
+	tests := []struct {
+		name         string
+		target, data string
+		want         string
+	}{
+		{"running root", link("root", "/dev/null"), spare, "is the running root"},
+		{"EFI system partition", spare, link("esp", "/dev/zero"), "is the EFI system partition"},
+		{"mounted through a link", spare, link("srv", "/dev/full"), "is mounted at /srv"},
+		{"same partition through a link", spare, "/dev/random", "separate partitions"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := takeoverPartitionScheme(mounts, tt.target, tt.data)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("takeoverPartitionScheme() error = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
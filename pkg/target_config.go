@@ -0,0 +1,128 @@
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// targetHasCommand reports whether the target root filesystem provides the named command
+func targetHasCommand(targetDir, command string) bool {
+	for _, dir := range []string{"usr/bin", "usr/sbin", "bin", "sbin"} {
+		if _, err := os.Stat(filepath.Join(targetDir, dir, command)); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// ConfigureTarget runs post-extraction configuration tools inside the new root:
+// depmod for each kernel, systemd-tmpfiles --create and systemd-sysusers.
+// Images that do not bake these results in would otherwise boot with missing
+// module dependency data or missing system users and directories.
+// Failures are reported as warnings since most images already ship these files.
+func ConfigureTarget(ctx context.Context, targetDir string, verbose, dryRun bool) error {
+	if dryRun {
+		fmt.Println("[DRY RUN] Would run depmod, systemd-tmpfiles and systemd-sysusers in target")
+		return nil
+	}
+
+	fmt.Println("  Running post-extraction configuration...")
+
+	// Regenerate module dependency data for each kernel
+	if targetHasCommand(targetDir, "depmod") {
+		versions, err := listKernelVersions(targetDir)
+		if err != nil {
+			if werr := warnf("  could not list kernels for depmod: %v", err); werr != nil {
+				return werr
+			}
+		}
+		for _, kernelVersion := range versions {
+			if err := ChrootCommand(ctx, targetDir, "depmod", "-a", kernelVersion); err != nil {
+				if werr := warnf("  depmod failed for kernel %s: %v", kernelVersion, err); werr != nil {
+					return werr
+				}
+			} else if verbose {
+				fmt.Printf("  Ran depmod for kernel %s\n", kernelVersion)
+			}
+		}
+	} else if verbose {
+		fmt.Println("  depmod not found in target, skipping")
+	}
+
+	// Create system users and groups declared in sysusers.d before tmpfiles
+	// so that tmpfiles entries can reference them
+	if targetHasCommand(targetDir, "systemd-sysusers") {
+		if err := ChrootCommand(ctx, targetDir, "systemd-sysusers"); err != nil {
+			if werr := warnf("  systemd-sysusers failed: %v", err); werr != nil {
+				return werr
+			}
+		} else if verbose {
+			fmt.Println("  Ran systemd-sysusers")
+		}
+	} else if verbose {
+		fmt.Println("  systemd-sysusers not found in target, skipping")
+	}
+
+	// Create runtime directories and files declared in tmpfiles.d
+	// API filesystems are bind-mounted from the host by ChrootCommand, so exclude them
+	if targetHasCommand(targetDir, "systemd-tmpfiles") {
+		args := []string{
+			"--create",
+			"--exclude-prefix=/dev",
+			"--exclude-prefix=/proc",
+			"--exclude-prefix=/sys",
+			"--exclude-prefix=/run",
+		}
+		if err := ChrootCommand(ctx, targetDir, "systemd-tmpfiles", args...); err != nil {
+			if werr := warnf("  systemd-tmpfiles failed: %v", err); werr != nil {
+				return werr
+			}
+		} else if verbose {
+			fmt.Println("  Ran systemd-tmpfiles --create")
+		}
+	} else if verbose {
+		fmt.Println("  systemd-tmpfiles not found in target, skipping")
+	}
+
+	return nil
+}
+
+// enableUnits enables the systemd units in the root at root. Units the image
+// does not ship are skipped with a warning.
+func enableUnits(ctx context.Context, root string, units []string) error {
+	for _, unit := range units {
+		found := false
+		for _, dir := range []string{"usr/lib/systemd/system", "etc/systemd/system"} {
+			if _, err := os.Stat(filepath.Join(root, dir, unit)); err == nil {
+				found = true
+				break
+			}
+		}
+		if !found {
+			if err := warnf("  %s is not in the image, not enabling it", unit); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := systemctlInRoot(ctx, root, "enable", unit); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// systemctlDone describes a finished systemctl verb
+var systemctlDone = map[string]string{"enable": "Enabled", "disable": "Disabled", "mask": "Masked"}
+
+// systemctlInRoot runs systemctl verb (enable, disable or mask) on unit in
+// the root at root
+func systemctlInRoot(ctx context.Context, root, verb, unit string) error {
+	if out, err := runCommand(ctx, "systemctl", "--root="+root, verb, unit); err != nil {
+		return fmt.Errorf("failed to %s %s: %w\nOutput: %s", verb, unit, err, strings.TrimSpace(string(out)))
+	}
+	fmt.Printf("  %s %s\n", systemctlDone[verb], unit)
+	return nil
+}
@@ -0,0 +1,37 @@
+package pkg
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+)
+
+func TestTargetHasCommand(t *testing.T) {
+	targetDir := t.TempDir()
+	writeFakeFile(t, filepath.Join(targetDir, "usr", "sbin", "depmod"), "")
+	writeFakeFile(t, filepath.Join(targetDir, "usr", "bin", "systemd-tmpfiles"), "")
+
+	tests := []struct {
+		command string
+		want    bool
+	}{
+		{"depmod", true},
+		{"systemd-tmpfiles", true},
+		{"systemd-sysusers", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.command, func(t *testing.T) {
+			if got := targetHasCommand(targetDir, tt.command); got != tt.want {
+				t.Errorf("targetHasCommand(%q) = %v, want %v", tt.command, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConfigureTarget_MissingTools(t *testing.T) {
+	// A target without any of the tools should be skipped without error
+	if err := ConfigureTarget(context.Background(), t.TempDir(), true, false); err != nil {
+		t.Errorf("ConfigureTarget() error = %v", err)
+	}
+}
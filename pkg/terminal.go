@@ -0,0 +1,227 @@
+package pkg
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// ANSI escape sequences used for terminal output
+const (
+	ansiReset     = "\x1b[0m"
+	ansiBold      = "\x1b[1m"
+	ansiGreen     = "\x1b[32m"
+	ansiYellow    = "\x1b[33m"
+	ansiCyan      = "\x1b[36m"
+	ansiClearLine = "\r\x1b[2K"
+)
+
+// colorEnabled turns on ANSI colors for step headings, warnings and progress
+var colorEnabled bool
+
+// SetColor enables or disables colored output
+func SetColor(enabled bool) {
+	colorEnabled = enabled
+}
+
+// IsTerminal reports whether f is a terminal
+func IsTerminal(f *os.File) bool {
+	_, err := unix.IoctlGetTermios(int(f.Fd()), unix.TCGETS)
+	return err == nil
+}
+
+// ColorAllowed reports whether colors may be used on a terminal: not when
+// noColor is set, NO_COLOR is non-empty (https://no-color.org) or the
+// terminal is "dumb"
+func ColorAllowed(noColor bool) bool {
+	return !noColor && os.Getenv("NO_COLOR") == "" && os.Getenv("TERM") != "dumb"
+}
+
+// colorize wraps s in the ANSI color code if colors are enabled
+func colorize(code, s string) string {
+	if !colorEnabled {
+		return s
+	}
+	return code + s + ansiReset
+}
+
+// spinnerFrames are drawn in turn for phases without a known total
+var spinnerFrames = []string{"⠋", "⠙", "⠹", "⠸", "⠼", "⠴", "⠦", "⠧", "⠇", "⠏"}
+
+// progressBarWidth is the number of cells in a progress bar
+const progressBarWidth = 30
+
+// progressMessageWidth keeps progress lines from wrapping, which would break
+// redrawing them in place
+const progressMessageWidth = 32
+
+// spinnerInterval is how often an active spinner is redrawn
+const spinnerInterval = 100 * time.Millisecond
+
+// TerminalProgressReporter draws phase progress in place on a terminal: a
+// progress bar for phases with a known total and a spinner for the others.
+// The line is finished when a phase reaches its total or ends.
+type TerminalProgressReporter struct {
+	w     io.Writer
+	color bool
+
+	mu      sync.Mutex
+	event   ProgressEvent // Last event of the active phase
+	active  bool          // A progress line is drawn
+	frame   int
+	stopped chan struct{} // Closed to stop the spinner goroutine
+}
+
+// NewTerminalProgressReporter creates a reporter drawing on w, which should
+// be a terminal
+func NewTerminalProgressReporter(w io.Writer, color bool) *TerminalProgressReporter {
+	return &TerminalProgressReporter{w: w, color: color}
+}
+
+// Report implements ProgressReporter
+func (r *TerminalProgressReporter) Report(event ProgressEvent) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if event.Type != EventPhaseProgress {
+		r.finish(false)
+		return
+	}
+	if r.active && r.event.Phase != event.Phase {
+		r.finish(r.event.Total > 0)
+	}
+	r.event = event
+	r.active = true
+	r.draw()
+
+	if event.Total > 0 {
+		r.stopSpinner()
+		if event.Current >= event.Total {
+			r.finish(true)
+		}
+	} else if r.stopped == nil {
+		r.stopped = make(chan struct{})
+		go r.spin(r.stopped)
+	}
+}
+
+// EndPhase finishes the progress line of phase, if it is still drawn
+func (r *TerminalProgressReporter) EndPhase(phase string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.active && r.event.Phase == phase {
+		r.finish(r.event.Total > 0)
+	}
+}
+
+// spin redraws the spinner until stopped is closed
+func (r *TerminalProgressReporter) spin(stopped chan struct{}) {
+	ticker := time.NewTicker(spinnerInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stopped:
+			return
+		case <-ticker.C:
+			r.mu.Lock()
+			if r.active {
+				r.frame++
+				r.draw()
+			}
+			r.mu.Unlock()
+		}
+	}
+}
+
+// stopSpinner stops the spinner goroutine, if running
+func (r *TerminalProgressReporter) stopSpinner() {
+	if r.stopped != nil {
+		close(r.stopped)
+		r.stopped = nil
+	}
+}
+
+// finish ends the active line, keeping a completed bar on screen and erasing
+// a spinner
+func (r *TerminalProgressReporter) finish(keep bool) {
+	r.stopSpinner()
+	if !r.active {
+		return
+	}
+	r.active = false
+	if keep {
+		_, _ = fmt.Fprintln(r.w)
+	} else {
+		_, _ = fmt.Fprint(r.w, ansiClearLine)
+	}
+}
+
+// draw redraws the progress line for the current event
+func (r *TerminalProgressReporter) draw() {
+	_, _ = fmt.Fprint(r.w, ansiClearLine+r.line())
+}
+
+// line renders the current event without a trailing newline
+func (r *TerminalProgressReporter) line() string {
+	e := r.event
+	paint := func(code, s string) string {
+		if !r.color {
+			return s
+		}
+		return code + s + ansiReset
+	}
+
+	var b strings.Builder
+	b.WriteString("  ")
+	if e.Total > 0 {
+		current := min(max(e.Current, 0), e.Total)
+		filled := current * progressBarWidth / e.Total
+		bar := strings.Repeat("█", filled) + strings.Repeat("░", progressBarWidth-filled)
+		fmt.Fprintf(&b, "%-10s %s %3d%%", e.Phase, paint(ansiGreen, bar), current*100/e.Total)
+	} else {
+		fmt.Fprintf(&b, "%s %-10s", paint(ansiCyan, spinnerFrames[r.frame%len(spinnerFrames)]), e.Phase)
+		if e.Current > 0 {
+			fmt.Fprintf(&b, " (%d)", e.Current)
+		}
+	}
+	if msg := []rune(e.Message); len(msg) > progressMessageWidth {
+		b.WriteString(" " + string(msg[:progressMessageWidth-1]) + "…")
+	} else if len(msg) > 0 {
+		b.WriteString(" " + e.Message)
+	}
+	return b.String()
+}
+
+// phaseEnder is implemented by reporters that track the end of a phase
+type phaseEnder interface {
+	EndPhase(phase string)
+}
+
+// reportPhaseEnd tells the reporter that no more progress will be reported
+// for phase
+func reportPhaseEnd(phase string) {
+	if e, ok := progressReporter.(phaseEnder); ok {
+		e.EndPhase(phase)
+	}
+}
+
+// terminalProgress reports whether progress is drawn in place on a terminal,
+// in which case raw tool output and periodic progress lines are left out
+func terminalProgress() bool {
+	switch r := progressReporter.(type) {
+	case *TerminalProgressReporter:
+		return true
+	case multiProgressReporter:
+		for _, inner := range r {
+			if _, ok := inner.(*TerminalProgressReporter); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,110 @@
+package pkg
+
+import (
+	"bytes"
+	"context"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+func TestTerminalProgressBar(t *testing.T) {
+	var buf bytes.Buffer
+	r := NewTerminalProgressReporter(&buf, false)
+
+	r.Report(ProgressEvent{Type: EventPhaseProgress, Phase: PhaseFlash, Current: 50, Total: 100, Message: "1.0 GiB written"})
+	if got := buf.String(); !strings.Contains(got, ansiClearLine+"  flash      ███████████████░░░░░░░░░░░░░░░  50% 1.0 GiB written") {
+		t.Errorf("unexpected bar: %q", got)
+	}
+	if strings.HasSuffix(buf.String(), "\n") {
+		t.Error("bar finished before reaching its total")
+	}
+
+	r.Report(ProgressEvent{Type: EventPhaseProgress, Phase: PhaseFlash, Current: 100, Total: 100})
+	if !strings.HasSuffix(buf.String(), "100%\n") {
+		t.Errorf("completed bar not kept: %q", buf.String())
+	}
+
+	// Nothing is drawn after the line is finished
+	buf.Reset()
+	r.EndPhase(PhaseFlash)
+	if buf.Len() != 0 {
+		t.Errorf("EndPhase wrote %q after the bar was finished", buf.String())
+	}
+}
+
+func TestTerminalProgressSpinner(t *testing.T) {
+	var buf bytes.Buffer
+	r := NewTerminalProgressReporter(&buf, true)
+
+	r.Report(ProgressEvent{Type: EventPhaseProgress, Phase: PhaseInitramfs, Current: 3, Message: "Including module: systemd-initrd and a long tail"})
+	got := buf.String()
+	if !strings.Contains(got, ansiCyan+"⠋"+ansiReset+" initramfs  (3) Including module: systemd-initr…") {
+		t.Errorf("unexpected spinner: %q", got)
+	}
+
+	buf.Reset()
+	r.EndPhase(PhaseInitramfs)
+	if buf.String() != ansiClearLine {
+		t.Errorf("EndPhase = %q, want spinner erased", buf.String())
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.stopped != nil {
+		t.Error("spinner still running after EndPhase")
+	}
+}
+
+func TestToolOutputHiddenWithTerminalProgress(t *testing.T) {
+	if _, err := exec.LookPath("printf"); err != nil {
+		t.Skip("printf not available")
+	}
+
+	var screen bytes.Buffer
+	SetProgressReporter(NewTerminalProgressReporter(&screen, false))
+	t.Cleanup(func() { SetProgressReporter(nil) })
+
+	var echo bytes.Buffer
+	cmd := Command{Name: "printf", Args: []string{`*** Including module: bash ***\n`}}
+	output, err := runWithToolProgress(context.Background(), cmd, PhaseInitramfs, &initramfsProgressParser{}, &echo)
+	if err != nil {
+		t.Fatalf("runWithToolProgress() error = %v", err)
+	}
+	if echo.Len() != 0 {
+		t.Errorf("raw output echoed with terminal progress: %q", echo.String())
+	}
+	if !strings.Contains(string(output), "Including module: bash") {
+		t.Errorf("output not captured: %q", output)
+	}
+	if !strings.HasSuffix(screen.String(), ansiClearLine) {
+		t.Errorf("spinner not erased at the end of the phase: %q", screen.String())
+	}
+}
+
+func TestColorize(t *testing.T) {
+	t.Cleanup(func() { SetColor(false) })
+
+	if got := colorize(ansiYellow, "Warning:"); got != "Warning:" {
+		t.Errorf("colorize() without color = %q", got)
+	}
+	SetColor(true)
+	if got := colorize(ansiYellow, "Warning:"); got != "\x1b[33mWarning:\x1b[0m" {
+		t.Errorf("colorize() with color = %q", got)
+	}
+}
+
+func TestColorAllowed(t *testing.T) {
+	t.Setenv("TERM", "xterm-256color")
+	t.Setenv("NO_COLOR", "")
+	if !ColorAllowed(false) {
+		t.Error("an empty NO_COLOR should not disable colors")
+	}
+	t.Setenv("NO_COLOR", "1")
+	if ColorAllowed(false) {
+		t.Error("NO_COLOR should disable colors")
+	}
+	t.Setenv("NO_COLOR", "")
+	if ColorAllowed(true) {
+		t.Error("--no-color should disable colors")
+	}
+}
@@ -0,0 +1,144 @@
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// FilesystemPartitionScheme maps a target root the caller has partitioned,
+// formatted and mounted at mountPoint, with the EFI system partition mounted
+// on /boot and the var partition on /var, to a partition scheme. root2 is the
+// formatted, unmounted partition for the second root, on the same disk as the
+// first. It also returns that disk.
+func FilesystemPartitionScheme(mountPoint, root2 string) (*PartitionScheme, string, error) {
+	mounts, err := readMounts()
+	if err != nil {
+		return nil, "", err
+	}
+	scheme, err := mountedPartitionScheme(mounts, mountPoint)
+	if err != nil {
+		return nil, "", err
+	}
+
+	for _, m := range mounts {
+		if m.source == root2 {
+			return nil, "", fmt.Errorf("second root %s is mounted at %s", root2, m.target)
+		}
+	}
+	for _, part := range []string{scheme.Root1Partition, scheme.BootPartition, scheme.VarPartition} {
+		if part == root2 {
+			return nil, "", fmt.Errorf("second root %s is already used by the target", root2)
+		}
+	}
+	if _, err := GetPartitionUUID(root2); err != nil {
+		return nil, "", fmt.Errorf("second root %s has no filesystem (format it as %s): %w", root2, scheme.FilesystemType, err)
+	}
+	scheme.Root2Partition = root2
+
+	device, err := GetBootDeviceFromPartition(scheme.Root1Partition)
+	if err != nil {
+		return nil, "", err
+	}
+	if disk, err := GetBootDeviceFromPartition(root2); err != nil || disk != device {
+		return nil, "", fmt.Errorf("second root %s must be on %s with the first root", root2, device)
+	}
+	return scheme, device, nil
+}
+
+// mountedPartitionScheme finds the root, boot and var partitions of the target
+// mounted at mountPoint in mounts
+func mountedPartitionScheme(mounts []mountEntry, mountPoint string) (*PartitionScheme, error) {
+	mountPoint = filepath.Clean(mountPoint)
+	root, err := findPartitionMount(mounts, mountPoint)
+	if err != nil {
+		return nil, err
+	}
+	if root.fsType != string(FilesystemExt4) && root.fsType != string(FilesystemBtrfs) {
+		return nil, fmt.Errorf("unsupported root filesystem: %s (supported: ext4, btrfs)", root.fsType)
+	}
+	boot, err := findPartitionMount(mounts, filepath.Join(mountPoint, "boot"))
+	if err != nil {
+		return nil, err
+	}
+	if boot.fsType != "vfat" {
+		return nil, fmt.Errorf("%s must be the EFI system partition (vfat), not %s", boot.target, boot.fsType)
+	}
+	varMount, err := findPartitionMount(mounts, filepath.Join(mountPoint, "var"))
+	if err != nil {
+		return nil, err
+	}
+	return &PartitionScheme{
+		BootPartition:  boot.source,
+		Root1Partition: root.source,
+		VarPartition:   varMount.source,
+		FilesystemType: root.fsType,
+	}, nil
+}
+
+// findPartitionMount returns the partition mounted at path, the last of mounts
+// stacked there
+func findPartitionMount(mounts []mountEntry, path string) (mountEntry, error) {
+	var found mountEntry
+	for _, m := range mounts {
+		if m.target == path {
+			found = m
+		}
+	}
+	if found.target == "" {
+		return found, fmt.Errorf("nothing is mounted at %s", path)
+	}
+	if !strings.HasPrefix(found.source, "/dev/") {
+		return found, fmt.Errorf("%s is not a partition (mounted from %s)", path, found.source)
+	}
+	return found, nil
+}
+
+// InstallToFilesystem installs into the partitions of scheme, which the caller
+// has formatted and mounted at b.MountPoint (see FilesystemPartitionScheme).
+// Nothing is wiped, partitioned or formatted, and the partitions stay mounted.
+func (b *BootcInstaller) InstallToFilesystem(ctx context.Context, scheme *PartitionScheme, skipPull bool) error {
+	fmt.Println("Checking prerequisites...")
+	if b.Verity {
+		return fmt.Errorf("a dm-verity root needs the hash partitions of a full install; it is not supported with to-filesystem")
+	}
+	b.FilesystemType = scheme.FilesystemType
+	if err := b.checkRootOptions(); err != nil {
+		return err
+	}
+
+	if !skipPull {
+		if err := b.PullImage(ctx); err != nil {
+			return err
+		}
+	}
+
+	defer b.runCommands()()
+	if b.DryRun {
+		fmt.Printf("[DRY RUN] Would install %s to the filesystem at %s\n", b.ImageRef, b.MountPoint)
+		return nil
+	}
+
+	defer withLogAttrs("image", b.ImageRef, "target", b.MountPoint)()
+	b.layout = scheme
+	recordResult(func(r *OperationResult) {
+		r.ImageRef, r.Device = b.ImageRef, b.Device
+		r.Partitions = scheme
+		r.TargetSlot, r.TargetPartition = "root1", scheme.Root1Partition
+	})
+
+	fmt.Printf("Installing bootc image to filesystem...\n")
+	fmt.Printf("  Image:      %s\n", b.ImageRef)
+	fmt.Printf("  Target:     %s\n", b.MountPoint)
+	fmt.Printf("  Filesystem: %s\n", scheme.FilesystemType)
+	fmt.Printf("  Boot:       %s\n", scheme.BootPartition)
+	fmt.Printf("  Root1:      %s\n", scheme.Root1Partition)
+	fmt.Printf("  Root2:      %s\n", scheme.Root2Partition)
+	fmt.Printf("  Var:        %s\n", scheme.VarPartition)
+	if b.Composefs {
+		fmt.Println("  /usr:       composefs")
+	}
+
+	return b.installRoot(ctx, scheme, 1, 3)
+}
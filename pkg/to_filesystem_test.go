@@ -0,0 +1,62 @@
+package pkg
+
+import "testing"
+
+func TestMountedPartitionScheme(t *testing.T) {
+	target := []mountEntry{
+		{source: "/dev/sda3", target: "/mnt/target", fsType: "ext4"},
+		{source: "/dev/sda1", target: "/mnt/target/boot", fsType: "vfat"},
+		{source: "/dev/sdb1", target: "/mnt/target/var", fsType: "ext4"},
+	}
+	tests := []struct {
+		name    string
+		mounts  []mountEntry
+		want    PartitionScheme
+		wantErr bool
+	}{
+		{
+			name:   "mounted target",
+			mounts: append([]mountEntry{{source: "/dev/nvme0n1p2", target: "/", fsType: "ext4"}}, target...),
+			want: PartitionScheme{
+				BootPartition:  "/dev/sda1",
+				Root1Partition: "/dev/sda3",
+				VarPartition:   "/dev/sdb1",
+				FilesystemType: "ext4",
+			},
+		},
+		{
+			name:    "var not mounted",
+			mounts:  target[:2],
+			wantErr: true,
+		},
+		{
+			name: "boot is not the ESP",
+			mounts: []mountEntry{
+				target[0],
+				{source: "/dev/sda1", target: "/mnt/target/boot", fsType: "ext4"},
+				target[2],
+			},
+			wantErr: true,
+		},
+		{
+			name: "root is not a partition",
+			mounts: []mountEntry{
+				{source: "tmpfs", target: "/mnt/target", fsType: "tmpfs"},
+				target[1],
+				target[2],
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := mountedPartitionScheme(tt.mounts, "/mnt/target/")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("mountedPartitionScheme() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && *got != tt.want {
+				t.Errorf("mountedPartitionScheme() = %+v, want %+v", *got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,154 @@
+package pkg
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// toolProgressParser turns lines of an external tool's output into progress.
+// Parse returns false for lines that carry no progress information.
+type toolProgressParser interface {
+	Parse(line string) (current, total int, message string, ok bool)
+}
+
+var (
+	mkfsStageRe  = regexp.MustCompile(`^(.+?):\s*(\d+)/(\d+)$`)
+	mkfsCountRe  = regexp.MustCompile(`^(\d+)/(\d+)$`)
+	dracutStepRe = regexp.MustCompile(`\*\*\* (.+?) \*\*\*`)
+)
+
+// mkfsProgressParser parses mke2fs stage counters such as
+// "Writing inode tables: 3/64", which are updated in place with backspaces
+type mkfsProgressParser struct {
+	stage string
+}
+
+// Parse implements toolProgressParser
+func (p *mkfsProgressParser) Parse(line string) (int, int, string, bool) {
+	if m := mkfsStageRe.FindStringSubmatch(line); m != nil {
+		p.stage = m[1]
+		current, _ := strconv.Atoi(m[2])
+		total, _ := strconv.Atoi(m[3])
+		return current, total, p.stage, true
+	}
+	if m := mkfsCountRe.FindStringSubmatch(line); m != nil && p.stage != "" {
+		current, _ := strconv.Atoi(m[1])
+		total, _ := strconv.Atoi(m[2])
+		return current, total, p.stage, true
+	}
+	if line == "done" && p.stage != "" {
+		stage := p.stage
+		p.stage = ""
+		return 0, 0, stage + " done", true
+	}
+	return 0, 0, "", false
+}
+
+// initramfsProgressParser parses dracut "*** ... ***" steps and mkinitcpio
+// "==>" / "->" steps, counting included modules and build hooks
+type initramfsProgressParser struct {
+	count int
+}
+
+// Parse implements toolProgressParser
+func (p *initramfsProgressParser) Parse(line string) (int, int, string, bool) {
+	// dracut
+	if m := dracutStepRe.FindStringSubmatch(line); m != nil {
+		if strings.HasPrefix(m[1], "Including module:") {
+			p.count++
+		}
+		return p.count, 0, m[1], true
+	}
+
+	// mkinitcpio
+	trimmed := strings.TrimSpace(line)
+	switch {
+	case strings.HasPrefix(trimmed, "-> Running build hook:"):
+		p.count++
+		return p.count, 0, strings.TrimPrefix(trimmed, "-> "), true
+	case strings.HasPrefix(trimmed, "==> "):
+		return p.count, 0, strings.TrimPrefix(trimmed, "==> "), true
+	}
+	return 0, 0, "", false
+}
+
+// grubProgressParser parses grub-install output, counting files copied in
+// --verbose mode
+type grubProgressParser struct {
+	copied int
+}
+
+// Parse implements toolProgressParser
+func (p *grubProgressParser) Parse(line string) (int, int, string, bool) {
+	switch {
+	case strings.Contains(line, "info: copying"):
+		p.copied++
+		return p.copied, 0, "copying GRUB modules", true
+	case strings.HasPrefix(line, "Installing for"), strings.HasPrefix(line, "Installation finished"):
+		return p.copied, 0, strings.TrimSuffix(line, "."), true
+	}
+	return 0, 0, "", false
+}
+
+// scanProgressLines splits tool output on newlines, carriage returns and
+// backspaces, which tools use to redraw counters in place
+func scanProgressLines(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	if atEOF && len(data) == 0 {
+		return 0, nil, nil
+	}
+	if i := bytes.IndexAny(data, "\n\r\b"); i >= 0 {
+		return i + 1, data[:i], nil
+	}
+	if atEOF {
+		return len(data), data, nil
+	}
+	return 0, nil, nil
+}
+
+// runWithToolProgress runs cmd, mapping its combined output into phase_progress
+// events for phase. Output is echoed to echo if non-nil and is always returned
+// so callers can include it in error messages.
+func runWithToolProgress(ctx context.Context, cmd Command, phase string, parser toolProgressParser, echo io.Writer) ([]byte, error) {
+	pr, pw := io.Pipe()
+	cmd.Stdout = pw
+	cmd.Stderr = pw
+
+	var output bytes.Buffer
+	var src io.Reader = io.TeeReader(pr, &output)
+	// Progress drawn in place replaces the raw output
+	if echo != nil && !terminalProgress() {
+		src = io.TeeReader(src, echo)
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		scanner := bufio.NewScanner(src)
+		scanner.Split(scanProgressLines)
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if line == "" {
+				continue
+			}
+			if current, total, message, ok := parser.Parse(line); ok {
+				reportPhaseProgress(phase, current, total, message)
+			}
+		}
+		// Drain anything left if the scanner stopped early
+		_, _ = io.Copy(io.Discard, src)
+	}()
+
+	err := executor.Run(ctx, cmd)
+	_ = pw.Close()
+	wg.Wait()
+	reportPhaseEnd(phase)
+
+	return output.Bytes(), err
+}
@@ -0,0 +1,128 @@
+package pkg
+
+import (
+	"bufio"
+	"context"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+// recordingReporter collects reported events
+type recordingReporter struct {
+	events []ProgressEvent
+}
+
+func (r *recordingReporter) Report(event ProgressEvent) {
+	r.events = append(r.events, event)
+}
+
+func TestScanProgressLines(t *testing.T) {
+	input := "Writing inode tables: 0/4\b\b\b   \b\b\b1/4\b\b\b   \b\b\bdone\nCreating journal\rdone"
+	scanner := bufio.NewScanner(strings.NewReader(input))
+	scanner.Split(scanProgressLines)
+
+	var got []string
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" {
+			got = append(got, line)
+		}
+	}
+
+	want := []string{"Writing inode tables: 0/4", "1/4", "done", "Creating journal", "done"}
+	if strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("scanProgressLines() = %q, want %q", got, want)
+	}
+}
+
+func TestMkfsProgressParser(t *testing.T) {
+	p := &mkfsProgressParser{}
+	tests := []struct {
+		line    string
+		current int
+		total   int
+		message string
+		ok      bool
+	}{
+		{"mke2fs 1.47.0 (5-Feb-2023)", 0, 0, "", false},
+		{"Writing inode tables: 0/64", 0, 64, "Writing inode tables", true},
+		{"32/64", 32, 64, "Writing inode tables", true},
+		{"done", 0, 0, "Writing inode tables done", true},
+		{"7/9", 0, 0, "", false},
+	}
+
+	for _, tt := range tests {
+		current, total, message, ok := p.Parse(tt.line)
+		if current != tt.current || total != tt.total || message != tt.message || ok != tt.ok {
+			t.Errorf("Parse(%q) = (%d, %d, %q, %v), want (%d, %d, %q, %v)",
+				tt.line, current, total, message, ok, tt.current, tt.total, tt.message, tt.ok)
+		}
+	}
+}
+
+func TestInitramfsProgressParser(t *testing.T) {
+	p := &initramfsProgressParser{}
+	lines := []string{
+		"dracut: Executing: /usr/bin/dracut --force",
+		"dracut: *** Including module: bash ***",
+		"dracut: *** Including module: systemd ***",
+		"dracut: *** Including modules done ***",
+		"dracut: *** Creating initramfs image file '/boot/initramfs.img' done ***",
+	}
+
+	var messages []string
+	lastCount := 0
+	for _, line := range lines {
+		if current, _, message, ok := p.Parse(line); ok {
+			messages = append(messages, message)
+			lastCount = current
+		}
+	}
+
+	if len(messages) != 4 {
+		t.Fatalf("got %d events, want 4: %q", len(messages), messages)
+	}
+	if messages[0] != "Including module: bash" {
+		t.Errorf("first message = %q, want %q", messages[0], "Including module: bash")
+	}
+	if lastCount != 2 {
+		t.Errorf("module count = %d, want 2", lastCount)
+	}
+
+	// mkinitcpio
+	p = &initramfsProgressParser{}
+	if current, _, message, ok := p.Parse("  -> Running build hook: [base]"); !ok || current != 1 || message != "Running build hook: [base]" {
+		t.Errorf("Parse(mkinitcpio hook) = (%d, %q, %v)", current, message, ok)
+	}
+}
+
+func TestRunWithToolProgress(t *testing.T) {
+	if _, err := exec.LookPath("printf"); err != nil {
+		t.Skip("printf not available")
+	}
+
+	recorder := &recordingReporter{}
+	SetProgressReporter(recorder)
+	t.Cleanup(func() { SetProgressReporter(nil) })
+
+	cmd := Command{Name: "printf", Args: []string{`Writing inode tables: 0/2\b\b\b1/2\b\b\b2/2\b\b\bdone\n`}}
+	output, err := runWithToolProgress(context.Background(), cmd, PhaseFormat, &mkfsProgressParser{}, nil)
+	if err != nil {
+		t.Fatalf("runWithToolProgress() error = %v", err)
+	}
+	if !strings.Contains(string(output), "Writing inode tables") {
+		t.Errorf("output not captured: %q", output)
+	}
+
+	if len(recorder.events) != 4 {
+		t.Fatalf("got %d events, want 4: %+v", len(recorder.events), recorder.events)
+	}
+	for _, ev := range recorder.events {
+		if ev.Type != EventPhaseProgress || ev.Phase != PhaseFormat {
+			t.Errorf("unexpected event %+v", ev)
+		}
+	}
+	if ev := recorder.events[2]; ev.Current != 2 || ev.Total != 2 {
+		t.Errorf("third event = %d/%d, want 2/2", ev.Current, ev.Total)
+	}
+}
@@ -1,29 +1,29 @@
 package pkg
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"strings"
+	"time"
 
-	"github.com/google/go-containerregistry/pkg/authn"
 	"github.com/google/go-containerregistry/pkg/name"
-	"github.com/google/go-containerregistry/pkg/v1/remote"
 )
 
 // GetRemoteImageDigest fetches the digest of a remote container image without downloading layers.
 // Returns the digest in the format "sha256:..."
-func GetRemoteImageDigest(imageRef string) (string, error) {
+func GetRemoteImageDigest(ctx context.Context, imageRef string) (string, error) {
 	ref, err := name.ParseReference(imageRef)
 	if err != nil {
-		return "", fmt.Errorf("invalid image reference: %w", err)
+		return "", fmt.Errorf("%w: %w", ErrInvalidImageReference, err)
 	}
 
 	// Get the image descriptor (manifest digest) without downloading layers
-	desc, err := remote.Head(ref, remote.WithAuthFromKeychain(authn.DefaultKeychain))
+	desc, err := remoteHead(ctx, ref)
 	if err != nil {
-		return "", fmt.Errorf("failed to get image descriptor: %w", err)
+		return "", fmt.Errorf("failed to get image descriptor: %w", registryError(err))
 	}
 
 	return desc.Digest.String(), nil
@@ -41,6 +41,11 @@ func CheckUpdateNeeded(installedDigest, remoteDigest string) bool {
 
 // GetActiveRootPartition determines which root partition is currently active
 func GetActiveRootPartition() (string, error) {
+	// The kernel command line is stale after a soft reboot
+	if root := softRebootRoot(); root != "" {
+		return root, nil
+	}
+
 	// Read /proc/cmdline to see which root is being used
 	cmdline, err := os.ReadFile("/proc/cmdline")
 	if err != nil {
@@ -49,15 +54,25 @@ func GetActiveRootPartition() (string, error) {
 
 	cmdlineStr := string(cmdline)
 
-	// Look for root=UUID=XXX or root=/dev/XXX
+	// A verity-protected root is root=/dev/mapper/root; its partition is
+	// the verity data device
 	fields := strings.Fields(cmdlineStr)
+	for _, field := range fields {
+		if uuid, ok := strings.CutPrefix(field, "systemd.verity_root_data=UUID="); ok {
+			return findPartitionByUUID(uuid)
+		} else if dev, ok := strings.CutPrefix(field, "systemd.verity_root_data="); ok && strings.HasPrefix(dev, "/dev/") {
+			return dev, nil
+		}
+	}
+
+	// Look for root=UUID=XXX or root=/dev/XXX
 	for _, field := range fields {
 		if strings.HasPrefix(field, "root=UUID=") {
 			uuid := strings.TrimPrefix(field, "root=UUID=")
 			// Find which partition has this UUID
 			return findPartitionByUUID(uuid)
 		} else if strings.HasPrefix(field, "root=/dev/") {
-			return strings.TrimPrefix(field, "root="), nil
+			return canonicalDevicePath(strings.TrimPrefix(field, "root=")), nil
 		}
 	}
 
@@ -66,12 +81,13 @@ func GetActiveRootPartition() (string, error) {
 
 // findPartitionByUUID finds a partition device path by its UUID
 func findPartitionByUUID(uuid string) (string, error) {
-	cmd := exec.Command("blkid", "-U", uuid)
-	output, err := cmd.Output()
+	device, err := lookupDeviceByUUID(uuid)
 	if err != nil {
 		return "", fmt.Errorf("failed to find partition with UUID %s: %w", uuid, err)
 	}
-	return strings.TrimSpace(string(output)), nil
+	// Logical volumes and multipath partitions are known by their names, not
+	// their dm nodes
+	return canonicalDevicePath(device), nil
 }
 
 // GetInactiveRootPartition returns the inactive root partition given a partition scheme
@@ -79,7 +95,9 @@ func GetInactiveRootPartition(scheme *PartitionScheme) (string, bool, error) {
 	active, err := GetActiveRootPartition()
 	if err != nil {
 		// If we can't determine active, default to root1 as active
-		fmt.Fprintf(os.Stderr, "Warning: could not determine active partition: %v\n", err)
+		if werr := warnf("could not determine active partition: %v", err); werr != nil {
+			return "", false, werr
+		}
 		fmt.Fprintf(os.Stderr, "Defaulting to root2 as target\n")
 		return scheme.Root2Partition, true, nil
 	}
@@ -99,35 +117,45 @@ func GetInactiveRootPartition(scheme *PartitionScheme) (string, bool, error) {
 	// Active partition doesn't match either root partition
 	// This can happen in test scenarios where we're not booted from the target disk
 	// Default to root1 as active, root2 as target
-	fmt.Fprintf(os.Stderr, "Warning: active partition %s does not match either root partition (%s or %s)\n",
-		active, scheme.Root1Partition, scheme.Root2Partition)
+	if werr := warnf("active partition %s does not match either root partition (%s or %s)",
+		active, scheme.Root1Partition, scheme.Root2Partition); werr != nil {
+		return "", false, werr
+	}
 	fmt.Fprintf(os.Stderr, "Defaulting to root2 as target\n")
 	return scheme.Root2Partition, true, nil
 }
 
 // DetectExistingPartitionScheme detects the partition scheme of an existing installation
 func DetectExistingPartitionScheme(device string) (*PartitionScheme, error) {
-	deviceBase := filepath.Base(device)
-	var part1, part2, part3, part4 string
-
-	// Handle different device naming conventions
-	// nvme, mmcblk, and loop devices use "p" prefix for partitions
-	if strings.HasPrefix(deviceBase, "nvme") || strings.HasPrefix(deviceBase, "mmcblk") || strings.HasPrefix(deviceBase, "loop") {
-		part1 = device + "p1"
-		part2 = device + "p2"
-		part3 = device + "p3"
-		part4 = device + "p4"
-	} else {
-		part1 = device + "1"
-		part2 = device + "2"
-		part3 = device + "3"
-		part4 = device + "4"
+	// A root installed with 'install to-filesystem' records its layout
+	if config, err := ReadSystemConfig(); err == nil && config.Partitions != nil && config.Device == device {
+		scheme := *config.Partitions
+		return &scheme, nil
+	}
+
+	// The roots and /var of an LVM install are logical volumes
+	if scheme := detectLVMPartitionScheme(device); scheme != nil {
+		return scheme, nil
+	}
+
+	// Partitions are found by the GPT names phukit gave them wherever they
+	// are in the table; tables without the names fall back to the numbers
+	// of the default layout
+	if parts, err := partitionsByLabel(device); err == nil {
+		if scheme, err := labeledPartitionScheme(device, parts); err == nil {
+			return scheme, nil
+		}
 	}
 
+	part1 := partitionDevicePath(device, 1)
+	part2 := partitionDevicePath(device, 2)
+	part3 := partitionDevicePath(device, 3)
+	part4 := partitionDevicePath(device, 4)
+
 	// Verify partitions exist
 	for _, part := range []string{part1, part2, part3, part4} {
 		if _, err := os.Stat(part); os.IsNotExist(err) {
-			return nil, fmt.Errorf("partition %s does not exist", part)
+			return nil, fmt.Errorf("%w: partition %s does not exist", ErrPartitionSchemeMismatch, part)
 		}
 	}
 
@@ -138,6 +166,14 @@ func DetectExistingPartitionScheme(device string) (*PartitionScheme, error) {
 		VarPartition:   part4,
 	}
 
+	// Hash tree partitions of a verity-protected install
+	hash1, hash2 := partitionDevicePath(device, 5), partitionDevicePath(device, 6)
+	if _, err := os.Stat(hash1); err == nil {
+		if _, err := os.Stat(hash2); err == nil {
+			scheme.Root1HashPartition, scheme.Root2HashPartition = hash1, hash2
+		}
+	}
+
 	return scheme, nil
 }
 
@@ -149,10 +185,38 @@ type UpdaterConfig struct {
 	FilesystemType string // Filesystem type (ext4, btrfs)
 	Verbose        bool
 	DryRun         bool
-	Force          bool // Skip interactive confirmation
-	KernelArgs     []string
+	Force          bool     // Skip interactive confirmation
+	AssumeYes      bool     // Skip interactive confirmation without implying a reinstall
+	KernelArgs     []string // Extra arguments of the new root's boot entry
+	NetrootArgs    []string // iSCSI/NVMe-oF root arguments (set by PrepareUpdate)
+	LUKSArgs       []string // Arguments that open the LUKS containers (set by PrepareUpdate)
 	MountPoint     string
 	BootMountPoint string
+	VarLockTimeout time.Duration // How long to wait for the shared /var lock
+	VarSnapshot    bool          // Snapshot /var before switching to the new root, if on LVM or btrfs
+	RestoreVar     bool          // Rollback restores the /var snapshot of the update it undoes
+	PinnedDigest   string        // Digest the update is held to (set by IsUpdateNeeded)
+	PinPolicy      string        // Pin policy to record with a new pin
+	Repin          bool          // Move the pin to the digest the tag currently points at
+	Verity         bool          // Roots are sealed with dm-verity (set by PrepareUpdate)
+	Composefs      bool          // /usr is composefs (set by PrepareUpdate)
+	WritableUsr    bool          // /usr is not mounted read-only (set by PrepareUpdate)
+	ResumeUUID     string        // Swap partition to resume from after hibernation (set by PrepareUpdate)
+	VolumeGroup    string        // Volume group of an LVM install (set by PrepareUpdate)
+	MountOptions   MountOptions  // Mount options of the root and /var (set by PrepareUpdate)
+	Executor       Executor      // Runs the external commands of the update (see SetExecutor)
+
+	// Persistent kernel arguments of both boot entries (set by PrepareUpdate)
+	SystemKernelArgs []string
+	// Extra boot entries of the new root (set by PrepareUpdate unless set)
+	BootProfiles []BootProfile
+	// How /var is named and where it is mounted from (set by PrepareUpdate)
+	MountIdentifier MountIdentifier
+	VarMount        VarMount
+	// Serial port of the GRUB terminal (set by PrepareUpdate)
+	SerialConsole *SerialConsole
+	// GRUB superuser that alone may edit entries (set by PrepareUpdate)
+	Lockdown *BootLockdown
 }
 
 // SystemUpdater handles A/B system updates
@@ -161,6 +225,12 @@ type SystemUpdater struct {
 	Scheme *PartitionScheme
 	Active bool // true if root1 is active, false if root2 is active
 	Target string
+	Staged bool // Set by PerformUpdate when Target was written and boots next
+
+	targetVerity      *VerityRoot // Set by Update when Target was sealed
+	targetComposefs   string      // Image digest, set by Update when Target's /usr was stored
+	previousNotBooted bool        // Set by bootloaderForActiveRoot: the previous entry is not the running root
+	verifiedRef       string      // Image digest the image policy verified (set by checkImagePolicy)
 }
 
 // NewSystemUpdater creates a new SystemUpdater
@@ -171,6 +241,9 @@ func NewSystemUpdater(device, imageRef string) *SystemUpdater {
 			ImageRef:       imageRef,
 			MountPoint:     "/tmp/phukit-update",
 			BootMountPoint: "/tmp/phukit-boot",
+			VarLockTimeout: DefaultVarLockTimeout,
+			VarSnapshot:    true,
+			RestoreVar:     true,
 		},
 	}
 }
@@ -185,16 +258,67 @@ func (u *SystemUpdater) SetDryRun(dryRun bool) {
 	u.Config.DryRun = dryRun
 }
 
+// SetExecutor has e run the external commands of updates and rollbacks. By
+// default they run on the host, and in dry-run mode a DryRunExecutor only
+// records them.
+func (u *SystemUpdater) SetExecutor(e Executor) {
+	u.Config.Executor = e
+}
+
+// runCommands has the executor of the updater run the external commands
+// until the returned function is called
+func (u *SystemUpdater) runCommands() (restore func()) {
+	if u.Config.Executor == nil && u.Config.DryRun {
+		u.Config.Executor = &DryRunExecutor{}
+	}
+	return useExecutor(u.Config.Executor)
+}
+
 // SetForce enables non-interactive mode (skips confirmation)
 func (u *SystemUpdater) SetForce(force bool) {
 	u.Config.Force = force
 }
 
+// SetAssumeYes skips the confirmation prompt, for callers that cannot answer it
+func (u *SystemUpdater) SetAssumeYes(yes bool) {
+	u.Config.AssumeYes = yes
+}
+
+// SetVarLockTimeout sets how long to wait for the shared /var lock
+// A timeout of 0 fails immediately if another operation holds it
+func (u *SystemUpdater) SetVarLockTimeout(timeout time.Duration) {
+	u.Config.VarLockTimeout = timeout
+}
+
+// SetVarSnapshot sets whether an update snapshots /var before switching to
+// the new root
+func (u *SystemUpdater) SetVarSnapshot(snapshot bool) {
+	u.Config.VarSnapshot = snapshot
+}
+
+// SetRestoreVar sets whether a rollback restores the /var snapshot of the
+// update it undoes
+func (u *SystemUpdater) SetRestoreVar(restore bool) {
+	u.Config.RestoreVar = restore
+}
+
+// SetRepin moves the pin to the current digest of the image tag, pinning the
+// image if it isn't pinned yet
+func (u *SystemUpdater) SetRepin(repin bool) {
+	u.Config.Repin = repin
+}
+
 // AddKernelArg adds a kernel argument
 func (u *SystemUpdater) AddKernelArg(arg string) {
 	u.Config.KernelArgs = append(u.Config.KernelArgs, arg)
 }
 
+// SetBootProfiles replaces the boot profiles recorded in the system config
+// for the new root
+func (u *SystemUpdater) SetBootProfiles(profiles []BootProfile) {
+	u.Config.BootProfiles = profiles
+}
+
 // PrepareUpdate prepares for an update by detecting partitions and determining target
 func (u *SystemUpdater) PrepareUpdate() error {
 	fmt.Println("Preparing for system update...")
@@ -205,6 +329,7 @@ func (u *SystemUpdater) PrepareUpdate() error {
 		return fmt.Errorf("failed to detect partition scheme: %w", err)
 	}
 	u.Scheme = scheme
+	u.Config.VolumeGroup = scheme.VolumeGroup
 
 	// Determine inactive partition
 	target, active, err := GetInactiveRootPartition(scheme)
@@ -214,6 +339,18 @@ func (u *SystemUpdater) PrepareUpdate() error {
 	u.Target = target
 	u.Active = active
 
+	// Network block devices need SAN login details on every boot entry
+	netrootArgs, err := NetworkRootKernelArgs(u.Config.Device)
+	if err != nil {
+		return fmt.Errorf("failed to determine network root configuration: %w", err)
+	}
+	u.Config.NetrootArgs = netrootArgs
+	u.Config.LUKSArgs = luksKernelArgs(SchemeLUKSVolumes(scheme))
+
+	if config, err := ReadSystemConfig(); err == nil {
+		u.applySystemConfig(config)
+	}
+
 	if u.Active {
 		fmt.Printf("Currently booted from: %s (root1)\n", scheme.Root1Partition)
 		fmt.Printf("Update target: %s (root2)\n", u.Target)
@@ -225,44 +362,99 @@ func (u *SystemUpdater) PrepareUpdate() error {
 	return nil
 }
 
-// PullImage validates the image reference and checks if it's accessible
-// The actual image pull happens during Extract() to avoid duplicate work
-func (u *SystemUpdater) PullImage() error {
-	if u.Config.DryRun {
-		fmt.Printf("[DRY RUN] Would pull image: %s\n", u.Config.ImageRef)
-		return nil
+// applySystemConfig takes the settings recorded at install from config
+func (u *SystemUpdater) applySystemConfig(config *SystemConfig) {
+	u.Config.Verity = config.Verity
+	u.Config.Composefs = config.Composefs
+	u.Config.WritableUsr = config.WritableUsr
+	u.Config.ResumeUUID = config.ResumeUUID
+	u.Config.MountOptions = config.MountOptions
+	// Older installs recorded the generated arguments as well, which
+	// every entry gets anew
+	u.Config.SystemKernelArgs = UserKernelArgs(config.KernelArgs)
+	u.Config.MountIdentifier = config.MountIdentifier
+	u.Config.VarMount = config.VarMount
+	u.Config.SerialConsole = config.SerialConsole
+	u.Config.Lockdown = config.Lockdown
+	if u.Config.BootProfiles == nil {
+		u.Config.BootProfiles = config.BootProfiles
 	}
+}
 
-	fmt.Printf("Validating image reference: %s\n", u.Config.ImageRef)
+// checkImageFits checks that the image fits in the target slot and, with
+// composefs, that the object store on /var has room for its /usr
+func (u *SystemUpdater) checkImageFits(ctx context.Context) error {
+	slotSize, _, _, err := diskGeometry(u.Target)
+	if err != nil {
+		return err
+	}
+	var extra func(imageSize) ([]spaceNeed, error)
+	if u.Config.Composefs {
+		extra = func(size imageSize) ([]spaceNeed, error) {
+			free, err := freeSpace(ComposefsObjectsPath)
+			if err != nil {
+				return nil, err
+			}
+			// Files shared with the running root are stored once, so this
+			// is the most the store can grow by
+			return []spaceNeed{{what: "the composefs object store on /var", need: size.uncompressed, available: free}}, nil
+		}
+	}
+	return checkImageFits(ctx, u.Config.ImageRef, u.Target, slotSize, extra)
+}
 
-	// Parse and validate the image reference
-	ref, err := name.ParseReference(u.Config.ImageRef)
+// checkImagePolicy evaluates the image policy for the image the update
+// extracts, before the target is touched, and holds the update to the digest
+// whose signatures it verified; extraction checks again
+func (u *SystemUpdater) checkImagePolicy(ctx context.Context) error {
+	imageRef, err := u.imageSource()
 	if err != nil {
-		return fmt.Errorf("invalid image reference: %w", err)
+		return err
+	}
+	if u.verifiedRef, err = CheckImagePolicy(ctx, imageRef); err != nil {
+		u.verifiedRef = ""
+		return err
 	}
+	return nil
+}
 
-	if u.Config.Verbose {
-		fmt.Printf("  Image: %s\n", ref.String())
+// imageSource returns the reference to pull and extract: the pinned digest,
+// which IsUpdateNeeded may only set after the policy check, the digest the
+// image policy verified, or ImageRef
+func (u *SystemUpdater) imageSource() (string, error) {
+	if u.Config.PinnedDigest != "" {
+		return pinnedReference(u.Config.ImageRef, u.Config.PinnedDigest)
 	}
+	if u.verifiedRef != "" {
+		return u.verifiedRef, nil
+	}
+	return u.Config.ImageRef, nil
+}
 
-	// Try to get image descriptor to verify it exists and is accessible
-	_, err = remote.Head(ref, remote.WithAuthFromKeychain(authn.DefaultKeychain))
-	if err != nil {
-		return fmt.Errorf("failed to access image: %w (check credentials if private registry)", err)
+// PullImage validates the image reference and checks if it's accessible
+// With the builtin source the actual image pull happens during Extract() to avoid duplicate work
+func (u *SystemUpdater) PullImage(ctx context.Context) error {
+	if u.Config.DryRun {
+		fmt.Printf("[DRY RUN] Would pull image: %s\n", u.Config.ImageRef)
+		return nil
 	}
 
-	fmt.Println("  Image reference is valid and accessible")
-	return nil
+	imageRef, err := u.imageSource()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Validating image reference: %s\n", u.Config.ImageRef)
+	return pullImage(ctx, imageRef, u.Config.Verbose)
 }
 
 // IsUpdateNeeded checks if the remote image differs from the currently installed image.
 // Returns true if an update is needed, false if the system is already up-to-date.
 // Also returns the remote digest for use during the update process.
-func (u *SystemUpdater) IsUpdateNeeded() (bool, string, error) {
+func (u *SystemUpdater) IsUpdateNeeded(ctx context.Context) (bool, string, error) {
 	fmt.Println("Checking if update is needed...")
 
 	// Get the remote image digest
-	remoteDigest, err := GetRemoteImageDigest(u.Config.ImageRef)
+	remoteDigest, err := GetRemoteImageDigest(ctx, u.Config.ImageRef)
 	if err != nil {
 		return false, "", fmt.Errorf("failed to get remote image digest: %w", err)
 	}
@@ -287,6 +479,11 @@ func (u *SystemUpdater) IsUpdateNeeded() (bool, string, error) {
 		u.Config.FilesystemType = "ext4" // Default for older installations
 	}
 
+	remoteDigest, err = u.applyPin(config, remoteDigest)
+	if err != nil {
+		return false, "", err
+	}
+
 	if u.Config.Verbose {
 		fmt.Printf("  Installed image: %s\n", config.ImageRef)
 		fmt.Printf("  Installed digest: %s\n", config.ImageDigest)
@@ -310,32 +507,52 @@ func (u *SystemUpdater) IsUpdateNeeded() (bool, string, error) {
 }
 
 // Update performs the system update
-func (u *SystemUpdater) Update() error {
+func (u *SystemUpdater) Update(ctx context.Context) error {
+	defer u.runCommands()()
 	if u.Config.DryRun {
 		fmt.Printf("[DRY RUN] Would update to partition: %s\n", u.Target)
 		return nil
 	}
 
+	defer withLogAttrs("image", u.Config.ImageRef, "target", u.Target)()
+	recordResult(func(r *OperationResult) {
+		r.ImageRef, r.ImageDigest, r.Device = u.Config.ImageRef, u.Config.ImageDigest, u.Config.Device
+		r.Partitions, r.TargetPartition = u.Scheme, u.Target
+		r.TargetSlot = "root2"
+		if !u.Active {
+			r.TargetSlot = "root1"
+		}
+	})
+
 	fmt.Println("\nStarting system update...")
 
 	// Step 1: Mount target partition
-	fmt.Println("\nStep 1/7: Mounting target partition...")
+	printStep("\nStep 1/7: Mounting target partition...")
 	if err := os.MkdirAll(u.Config.MountPoint, 0755); err != nil {
 		return fmt.Errorf("failed to create mount point: %w", err)
 	}
 
-	cmd := exec.Command("mount", u.Target, u.Config.MountPoint)
-	if output, err := cmd.CombinedOutput(); err != nil {
-		return fmt.Errorf("failed to mount target partition: %w\nOutput: %s", err, string(output))
+	if err := mountDevice(u.Target, u.Config.MountPoint, false); err != nil {
+		return fmt.Errorf("failed to mount target partition: %w", err)
 	}
 	defer func() {
 		fmt.Println("\nCleaning up...")
-		_ = exec.Command("umount", u.Config.MountPoint).Run()
+		_ = unmount(u.Config.MountPoint)
 		_ = os.RemoveAll(u.Config.MountPoint)
 	}()
 
+	// Everything below the mount point is about to be deleted, so make sure the
+	// shared /var is not reachable from the staged root
+	if err := ensureStagedVarIsolated(u.Config.MountPoint); err != nil {
+		return err
+	}
+
+	if err := cancelled(ctx); err != nil {
+		return err
+	}
+
 	// Step 2: Clear existing content
-	fmt.Println("\nStep 2/7: Clearing old content from target partition...")
+	printStep("\nStep 2/7: Clearing old content from target partition...")
 	entries, err := os.ReadDir(u.Config.MountPoint)
 	if err != nil {
 		return fmt.Errorf("failed to read target directory: %w", err)
@@ -347,16 +564,33 @@ func (u *SystemUpdater) Update() error {
 		}
 	}
 
+	if err := cancelled(ctx); err != nil {
+		return err
+	}
+
 	// Step 3: Extract new container filesystem
-	fmt.Println("\nStep 3/7: Extracting new container filesystem...")
-	extractor := NewContainerExtractor(u.Config.ImageRef, u.Config.MountPoint)
+	printStep("\nStep 3/7: Extracting new container filesystem...")
+	imageRef, err := u.imageSource()
+	if err != nil {
+		return err
+	}
+	if u.Config.PinnedDigest != "" {
+		fmt.Printf("  Using pinned image %s\n", imageRef)
+	}
+	extractor := NewContainerExtractor(imageRef, u.Config.MountPoint)
 	extractor.SetVerbose(u.Config.Verbose)
-	if err := extractor.Extract(); err != nil {
+	if err := extractor.Extract(ctx); err != nil {
 		return fmt.Errorf("failed to extract container: %w", err)
 	}
+	recordResult(func(r *OperationResult) { r.Extract = extractor.Stats })
+	recordKernelVersion(u.Config.MountPoint)
+
+	if err := cancelled(ctx); err != nil {
+		return err
+	}
 
 	// Step 4: Merge /etc configuration from active system
-	fmt.Println("\nStep 4/7: Preserving user configuration...")
+	printStep("\nStep 4/7: Preserving user configuration...")
 	activeRoot := u.Scheme.Root1Partition
 	if !u.Active {
 		activeRoot = u.Scheme.Root2Partition
@@ -364,24 +598,97 @@ func (u *SystemUpdater) Update() error {
 	if err := MergeEtcFromActive(u.Config.MountPoint, activeRoot, u.Config.DryRun); err != nil {
 		return fmt.Errorf("failed to merge /etc: %w", err)
 	}
+	if err := u.BackupEtcToSharedVar(ctx); err != nil {
+		return fmt.Errorf("failed to back up /etc: %w", err)
+	}
+
+	if err := cancelled(ctx); err != nil {
+		return err
+	}
 
 	// Step 5: Setup system directories
-	fmt.Println("\nStep 5/7: Setting up system directories...")
+	printStep("\nStep 5/7: Setting up system directories...")
 	if err := SetupSystemDirectories(u.Config.MountPoint); err != nil {
 		return fmt.Errorf("failed to setup directories: %w", err)
 	}
+	labels := imageLabels(ctx, u.Config.ImageRef, u.Config.Verbose)
+	deployment := newDeployment(u.Config.MountPoint, u.Config.ImageRef, u.Config.ImageDigest, labels, u.Config.PinnedDigest != "")
+	if deployment.KernelArgs, err = imageKernelArgs(u.Config.MountPoint, labels); err != nil {
+		return err
+	}
+	if err := WriteDeployment(u.Config.MountPoint, deployment, u.Config.DryRun); err != nil {
+		return err
+	}
+	recordResult(func(r *OperationResult) { r.ImageVersion = deployment.DisplayVersion() })
+	if err := ConfigureTarget(ctx, u.Config.MountPoint, u.Config.Verbose, u.Config.DryRun); err != nil {
+		return fmt.Errorf("failed to configure target: %w", err)
+	}
+	if err := GenerateMissingInitramfs(ctx, u.Config.MountPoint, u.Config.Device, u.Config.Verbose, u.Config.DryRun); err != nil {
+		return fmt.Errorf("failed to generate initramfs: %w", err)
+	}
+	var storageFeatures []StorageFeature
+	if u.Config.ResumeUUID != "" {
+		storageFeatures = append(storageFeatures, StorageResume)
+	}
+	if u.Config.VolumeGroup != "" {
+		storageFeatures = append(storageFeatures, StorageLVM)
+	}
+	if len(SchemeLUKSVolumes(u.Scheme)) > 0 {
+		storageFeatures = append(storageFeatures, StorageCrypt)
+	}
+	if len(storageFeatures) > 0 {
+		if err := RebuildInitramfsForStorage(ctx, u.Config.MountPoint, storageFeatures, u.Config.Verbose, u.Config.DryRun); err != nil {
+			return fmt.Errorf("failed to rebuild initramfs: %w", err)
+		}
+	}
+
+	if err := cancelled(ctx); err != nil {
+		return err
+	}
 
 	// Step 6: Install new kernel and initramfs if present
-	fmt.Println("\nStep 6/7: Checking for new kernel and initramfs...")
+	printStep("\nStep 6/7: Checking for new kernel and initramfs...")
 	if err := u.InstallKernelAndInitramfs(); err != nil {
 		return fmt.Errorf("failed to install kernel/initramfs: %w", err)
 	}
 
+	if err := cancelled(ctx); err != nil {
+		return err
+	}
+
+	// A sealed root cannot be written after this, so it carries its own
+	// system config instead of PerformUpdate updating the read-only active one
+	if u.Config.Verity {
+		if err := u.writeTargetConfig(); err != nil {
+			return err
+		}
+		v, err := sealRoot(ctx, u.Config.MountPoint, u.Target, u.Scheme.hashPartition(u.Target))
+		if err != nil {
+			return fmt.Errorf("failed to seal target root: %w", err)
+		}
+		u.targetVerity = v
+	}
+
+	if u.Config.Composefs {
+		if err := u.buildTargetComposefs(ctx); err != nil {
+			return fmt.Errorf("failed to build composefs image: %w", err)
+		}
+	}
+
 	// Step 7: Update bootloader configuration
-	fmt.Println("\nStep 7/7: Updating bootloader configuration...")
+	printStep("\nStep 7/7: Updating bootloader configuration...")
+	// What /var looks like to the software being replaced, for a rollback
+	if err := u.SnapshotVar(ctx); err != nil {
+		return err
+	}
 	if err := u.UpdateBootloader(); err != nil {
 		return fmt.Errorf("failed to update bootloader: %w", err)
 	}
+	if u.Config.Composefs {
+		if err := dropComposefsTree(u.Config.MountPoint); err != nil {
+			return err
+		}
+	}
 
 	fmt.Println("\n" + strings.Repeat("=", 60))
 	fmt.Println("System update completed successfully!")
@@ -392,6 +699,123 @@ func (u *SystemUpdater) Update() error {
 	return nil
 }
 
+// writeTargetConfig writes the system config the target will boot with: the
+// active config with the new image and, with Repin, the new pin. An update to
+// another image than the channel's leaves the channel.
+func (u *SystemUpdater) writeTargetConfig() error {
+	config, err := ReadSystemConfig()
+	if err != nil {
+		return err
+	}
+	if config.Channel != "" {
+		if channelRef, err := config.ChannelImageRef(); err != nil || !sameImageRef(channelRef, u.Config.ImageRef) {
+			fmt.Printf("  Leaving channel %s for %s\n", config.Channel, u.Config.ImageRef)
+			config.Channel = ""
+		}
+	}
+	config.ImageRef = u.Config.ImageRef
+	config.ImageDigest = u.Config.ImageDigest
+	config.BootProfiles = u.Config.BootProfiles
+	if u.Config.Repin {
+		config.PinnedDigest = u.Config.PinnedDigest
+		config.PinPolicy = u.Config.PinPolicy
+		if config.PinnedDigest == "" {
+			config.PinPolicy = ""
+		}
+	}
+	return WriteSystemConfigToTarget(u.Config.MountPoint, config, u.Config.DryRun)
+}
+
+// buildTargetComposefs adds /usr of the target to the shared composefs object
+// store and writes its image, then removes the objects that neither the
+// target nor the active root use any more
+func (u *SystemUpdater) buildTargetComposefs(ctx context.Context) error {
+	if err := checkSharedVarPath(ComposefsObjectsPath); err != nil {
+		return err
+	}
+	return u.withSharedVar(ctx, func(varRoot string) error {
+		objects := filepath.Join(varRoot, strings.TrimPrefix(ComposefsObjectsPath, "/var/"))
+		digest, err := buildComposefs(ctx, u.Config.MountPoint, objects)
+		if err != nil {
+			return err
+		}
+		u.targetComposefs = digest
+
+		// The active root's image is only reachable when it is booted
+		activeImage := filepath.Join(bootedRoot, composefsImagePath)
+		if _, err := os.Stat(activeImage); err != nil || varRoot != "/var" {
+			fmt.Println("  Skipping cleanup of unused composefs objects: active root image not available")
+			return nil
+		}
+		removed, err := pruneComposefsObjects(ctx, objects, activeImage, filepath.Join(u.Config.MountPoint, composefsImagePath))
+		if err != nil {
+			return err
+		}
+		fmt.Printf("  Removed %d unused composefs objects\n", removed)
+		return nil
+	})
+}
+
+// BackupEtcToSharedVar copies the staged /etc to /var/etc.backup on the shared
+// /var partition. The running workload may be writing /var at the same time,
+// so this only writes an allowed path and holds the shared /var lock.
+func (u *SystemUpdater) BackupEtcToSharedVar(ctx context.Context) error {
+	if u.Config.DryRun {
+		fmt.Printf("[DRY RUN] Would back up /etc to %s\n", VarEtcPath)
+		return nil
+	}
+
+	if err := checkSharedVarPath(VarEtcPath); err != nil {
+		return err
+	}
+	return u.withSharedVar(ctx, func(varRoot string) error {
+		return backupEtc(filepath.Join(u.Config.MountPoint, "etc"), filepath.Join(varRoot, strings.TrimPrefix(VarEtcPath, "/var/")))
+	})
+}
+
+// withSharedVar calls fn with the shared /var of the disk being updated
+// while holding the shared /var lock
+func (u *SystemUpdater) withSharedVar(ctx context.Context, fn func(varRoot string) error) (err error) {
+	activeRoot := u.Scheme.Root1Partition
+	if !u.Active {
+		activeRoot = u.Scheme.Root2Partition
+	}
+
+	varRoot := "/var"
+	if current, _ := GetActiveRootPartition(); current != activeRoot {
+		// Not running from this disk, so its /var partition is not in use by a
+		// workload; mount it
+		varRoot = u.Config.MountPoint + "-var"
+		if err := os.MkdirAll(varRoot, 0755); err != nil {
+			return fmt.Errorf("failed to create var mount point: %w", err)
+		}
+		if err := mountDevice(u.Scheme.VarPartition, varRoot, false); err != nil {
+			_ = os.Remove(varRoot)
+			return fmt.Errorf("failed to mount var partition: %w", err)
+		}
+		// Only the empty mount point is removed: while the partition is
+		// still mounted, it holds all of /var
+		defer func() {
+			if uerr := unmount(varRoot); uerr != nil {
+				err = errors.Join(err, fmt.Errorf("failed to unmount var partition: %w", uerr))
+				return
+			}
+			_ = os.Remove(varRoot)
+		}()
+	}
+
+	if u.Config.Verbose {
+		fmt.Printf("  Waiting up to %s for shared /var lock...\n", u.Config.VarLockTimeout)
+	}
+	lock, err := LockSharedVar(ctx, varRoot, u.Config.VarLockTimeout)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = lock.Unlock() }()
+
+	return fn(varRoot)
+}
+
 // InstallKernelAndInitramfs checks for new kernel and initramfs in the updated root
 // and copies them to the boot partition (which is the combined EFI/boot partition)
 func (u *SystemUpdater) InstallKernelAndInitramfs() error {
@@ -412,11 +836,10 @@ func (u *SystemUpdater) InstallKernelAndInitramfs() error {
 	}
 	defer func() { _ = os.RemoveAll(bootMountPoint) }()
 
-	cmd := exec.Command("mount", u.Scheme.BootPartition, bootMountPoint)
-	if output, err := cmd.CombinedOutput(); err != nil {
-		return fmt.Errorf("failed to mount boot partition: %w\nOutput: %s", err, string(output))
+	if err := mountDevice(u.Scheme.BootPartition, bootMountPoint, false); err != nil {
+		return fmt.Errorf("failed to mount boot partition: %w", err)
 	}
-	defer func() { _ = exec.Command("umount", bootMountPoint).Run() }()
+	defer func() { _ = unmount(bootMountPoint) }()
 
 	// Detect bootloader type to determine where to copy kernels
 	bootloaderType := u.detectBootloaderTypeFromMount(bootMountPoint)
@@ -561,25 +984,171 @@ func (u *SystemUpdater) UpdateBootloader() error {
 		return fmt.Errorf("failed to create boot mount point: %w", err)
 	}
 
-	cmd := exec.Command("mount", u.Scheme.BootPartition, u.Config.BootMountPoint)
-	if output, err := cmd.CombinedOutput(); err != nil {
-		return fmt.Errorf("failed to mount boot partition: %w\nOutput: %s", err, string(output))
+	if err := mountDevice(u.Scheme.BootPartition, u.Config.BootMountPoint, false); err != nil {
+		return fmt.Errorf("failed to mount boot partition: %w", err)
 	}
-	defer func() { _ = exec.Command("umount", u.Config.BootMountPoint).Run() }()
+	defer func() { _ = unmount(u.Config.BootMountPoint) }()
 
 	// Detect bootloader type
 	bootloaderType := u.detectBootloaderType()
 	fmt.Printf("  Detected bootloader: %s\n", bootloaderType)
 
+	// Keep the current configuration so a failed update never leaves the
+	// system with a mix of old and new boot entries
+	backup, err := backupBootFiles(u.bootConfigFiles()...)
+	if err != nil {
+		return err
+	}
+
 	// Update based on bootloader type
 	switch bootloaderType {
 	case BootloaderGRUB2:
-		return u.updateGRUBBootloader()
+		err = u.updateGRUBBootloader()
 	case BootloaderSystemdBoot:
-		return u.updateSystemdBootBootloader()
+		err = u.updateSystemdBootBootloader()
 	default:
 		return fmt.Errorf("unsupported bootloader type: %s", bootloaderType)
 	}
+	if err != nil {
+		fmt.Println("  Restoring previous bootloader configuration...")
+		if rerr := backup.restore(); rerr != nil {
+			return fmt.Errorf("%w (restoring previous configuration also failed: %v)", err, rerr)
+		}
+	}
+	return err
+}
+
+// bootConfigFiles lists the boot configuration files an update may rewrite
+func (u *SystemUpdater) bootConfigFiles() []string {
+	entriesDir := filepath.Join(u.Config.BootMountPoint, "loader", "entries")
+	files := []string{
+		filepath.Join(u.Config.BootMountPoint, "grub", "grub.cfg"),
+		filepath.Join(u.Config.BootMountPoint, "grub2", "grub.cfg"),
+		filepath.Join(entriesDir, "bootc.conf"),
+		filepath.Join(entriesDir, "bootc-previous.conf"),
+		filepath.Join(u.Config.BootMountPoint, VerityRootsFile),
+		filepath.Join(u.Config.BootMountPoint, ComposefsImagesFile),
+	}
+	files = append(files, loaderProfileEntries(entriesDir)...)
+	for _, p := range u.Config.BootProfiles {
+		files = append(files, p.loaderProfileEntryPath(entriesDir))
+	}
+	return files
+}
+
+// verityRoots returns the dm-verity metadata of the target and active roots
+// when roots are sealed, recording the target's if Update just sealed it
+func (u *SystemUpdater) verityRoots(targetUUID, activeUUID string) (target, active *VerityRoot, err error) {
+	if !u.Config.Verity {
+		return nil, nil, nil
+	}
+	if u.targetVerity != nil {
+		if err := recordRootRecord(u.Config.BootMountPoint, VerityRootsFile, targetUUID, u.targetVerity); err != nil {
+			return nil, nil, err
+		}
+	}
+	roots, err := readRootRecords[*VerityRoot](u.Config.BootMountPoint, VerityRootsFile)
+	if err != nil {
+		return nil, nil, err
+	}
+	target = roots[targetUUID]
+	if target == nil {
+		return nil, nil, fmt.Errorf("no dm-verity root hash recorded for %s", u.Target)
+	}
+	active = roots[activeUUID]
+	if active == nil && activeUUID != "" {
+		if werr := warnf("  no dm-verity root hash recorded for the active root, rollback entry will boot it read-write"); werr != nil {
+			return nil, nil, werr
+		}
+	}
+	return target, active, nil
+}
+
+// composefsDigests returns the composefs image digests of the target and
+// active roots when /usr is composefs, recording the target's if Update just
+// built it
+func (u *SystemUpdater) composefsDigests(targetUUID, activeUUID string) (target, active string, err error) {
+	if !u.Config.Composefs {
+		return "", "", nil
+	}
+	if u.targetComposefs != "" {
+		if err := recordRootRecord(u.Config.BootMountPoint, ComposefsImagesFile, targetUUID, u.targetComposefs); err != nil {
+			return "", "", err
+		}
+	}
+	digests, err := readRootRecords[string](u.Config.BootMountPoint, ComposefsImagesFile)
+	if err != nil {
+		return "", "", err
+	}
+	target = digests[targetUUID]
+	if target == "" {
+		return "", "", fmt.Errorf("no composefs image digest recorded for %s", u.Target)
+	}
+	active = digests[activeUUID]
+	if active == "" && activeUUID != "" {
+		if werr := warnf("  no composefs image digest recorded for the active root, rollback entry will not boot"); werr != nil {
+			return "", "", werr
+		}
+	}
+	return target, active, nil
+}
+
+// rollbackBootloader points the bootloader back at the currently active root
+// after a failed update
+func (u *SystemUpdater) rollbackBootloader() error {
+	fmt.Printf("Rolling back bootloader to %s...\n", u.activeRoot())
+	return u.bootloaderForActiveRoot()
+}
+
+// activeRoot returns the root partition the system is running from
+func (u *SystemUpdater) activeRoot() string {
+	if u.Active {
+		return u.Scheme.Root1Partition
+	}
+	return u.Scheme.Root2Partition
+}
+
+// bootloaderForActiveRoot writes the boot entries with the active root as the
+// default, by treating it as the update target
+func (u *SystemUpdater) bootloaderForActiveRoot() error {
+	activeRoot := u.activeRoot()
+
+	target, active, targetVerity, targetComposefs := u.Target, u.Active, u.targetVerity, u.targetComposefs
+	defer func() {
+		u.Target, u.Active, u.targetVerity, u.targetComposefs = target, active, targetVerity, targetComposefs
+		u.previousNotBooted = false
+	}()
+	u.Target = activeRoot
+	u.Active = !active
+	u.targetVerity = nil
+	u.targetComposefs = ""
+	u.previousNotBooted = true
+
+	return u.UpdateBootloader()
+}
+
+// bootEntryNames returns the titles of the default entry, named after the
+// root mounted at the mount point, and of the previous entry. The previous
+// entry boots the running root unless bootloaderForActiveRoot swapped them;
+// the other root is not mounted then, so its entry goes without version.
+func (u *SystemUpdater) bootEntryNames() (target, previous string) {
+	target = bootEntryName(u.Config.MountPoint)
+	if u.previousNotBooted {
+		return target, ParseOSRelease(u.Config.MountPoint)
+	}
+	return target, bootEntryName(bootedRoot)
+}
+
+// imageKernelArgs returns the image kernel arguments of the default and the
+// previous entry, from the deployments of the same roots as bootEntryNames.
+// The other root is not mounted after bootloaderForActiveRoot; its image
+// arguments are assumed to be those of the default root.
+func (u *SystemUpdater) imageKernelArgs() (target, previous []string) {
+	target = deploymentKernelArgs(u.Config.MountPoint)
+	if u.previousNotBooted {
+		return target, target
+	}
+	return target, deploymentKernelArgs(bootedRoot)
 }
 
 // detectBootloaderType detects which bootloader is installed
@@ -605,6 +1174,35 @@ func (u *SystemUpdater) detectBootloaderType() BootloaderType {
 	return BootloaderGRUB2
 }
 
+// entryKernelArgs returns the command line of the boot entry of the root
+// with filesystem UUID rootUUID: the arguments phukit generates for it,
+// followed by the persistent arguments and extra over the image arguments
+// imageArgs
+func (u *SystemUpdater) entryKernelArgs(rootUUID string, verity *VerityRoot, varSource, fsType, composefsDigest string, imageArgs, extra []string) []string {
+	args := rootKernelArgs(rootUUID, verity)
+	// Mount /var via kernel command line (systemd.mount-extra)
+	args = append(args, mountKernelArgs(u.cmdlineVarSource(varSource, composefsDigest), fsType, composefsDigest, u.Config.WritableUsr, u.Config.MountOptions)...)
+	args = append(args, u.Config.NetrootArgs...)
+	args = append(args, resumeKernelArgs(u.Config.ResumeUUID)...)
+	args = append(args, lvmKernelArgs(u.Config.VolumeGroup)...)
+	args = append(args, u.Config.LUKSArgs...)
+	return append(args, mergeImageKernelArgs(imageArgs, mergeKernelArgs(u.Config.SystemKernelArgs, extra))...)
+}
+
+// cmdlineVarSource returns varSource for the boot entry of a root with
+// composefs image digest composefsDigest, or "" if /var is mounted from /etc:
+// as recorded at install, or by an fstab entry or var.mount unit added
+// since, which must not be mounted a second time
+func (u *SystemUpdater) cmdlineVarSource(varSource, composefsDigest string) string {
+	if composefsDigest != "" {
+		return varSource
+	}
+	if !u.Config.VarMount.fromCmdline() || mountsVarFromEtc(u.Config.MountPoint) {
+		return ""
+	}
+	return varSource
+}
+
 // updateGRUBBootloader updates GRUB configuration
 func (u *SystemUpdater) updateGRUBBootloader() error {
 	// Get UUID of new root partition
@@ -614,9 +1212,23 @@ func (u *SystemUpdater) updateGRUBBootloader() error {
 	}
 
 	// Get /var UUID for kernel command line mount
-	varUUID, err := GetPartitionUUID(u.Scheme.VarPartition)
+	varSource, err := mountSource(u.Scheme.VarPartition, u.Config.MountIdentifier)
+	if err != nil {
+		return fmt.Errorf("failed to get /var source: %w", err)
+	}
+
+	// The previous entry boots the active root
+	activeRoot := u.Scheme.Root1Partition
+	if !u.Active {
+		activeRoot = u.Scheme.Root2Partition
+	}
+
+	activeUUID, err := GetPartitionUUID(activeRoot)
 	if err != nil {
-		return fmt.Errorf("failed to get var UUID: %w", err)
+		// The rollback entry is still written, but will not find its root
+		if werr := warnf("  could not get UUID of active root %s, rollback entry will not boot: %v", activeRoot, err); werr != nil {
+			return werr
+		}
 	}
 
 	// Find kernel and initramfs
@@ -647,17 +1259,21 @@ func (u *SystemUpdater) updateGRUBBootloader() error {
 		fsType = "ext4"
 	}
 
-	// Build kernel command line
-	kernelCmdline := []string{
-		"root=UUID=" + targetUUID,
-		"rw",
-		// Mount /var via kernel command line (systemd.mount-extra)
-		"systemd.mount-extra=UUID=" + varUUID + ":/var:" + fsType + ":defaults",
+	targetVerity, activeVerity, err := u.verityRoots(targetUUID, activeUUID)
+	if err != nil {
+		return err
+	}
+	targetComposefs, activeComposefs, err := u.composefsDigests(targetUUID, activeUUID)
+	if err != nil {
+		return err
 	}
-	kernelCmdline = append(kernelCmdline, u.Config.KernelArgs...)
 
-	// Get OS name from the updated system
-	osName := ParseOSRelease(u.Config.MountPoint)
+	// Build kernel command line
+	targetImageArgs, previousImageArgs := u.imageKernelArgs()
+	kernelCmdline := u.entryKernelArgs(targetUUID, targetVerity, varSource, fsType, targetComposefs, targetImageArgs, u.Config.KernelArgs)
+
+	// Get the entry titles from the updated system
+	osName, previousName := u.bootEntryNames()
 
 	// Find GRUB directory
 	grubDirs := []string{
@@ -677,22 +1293,10 @@ func (u *SystemUpdater) updateGRUBBootloader() error {
 		return fmt.Errorf("could not find grub directory")
 	}
 
-	// Create new GRUB config with both boot options
-	activeRoot := u.Scheme.Root1Partition
-	if !u.Active {
-		activeRoot = u.Scheme.Root2Partition
-	}
-
-	activeUUID, _ := GetPartitionUUID(activeRoot)
-
 	// Build previous kernel command line
-	previousCmdline := []string{
-		"root=UUID=" + activeUUID,
-		"rw",
-		"systemd.mount-extra=UUID=" + varUUID + ":/var:" + fsType + ":defaults",
-	}
+	previousCmdline := u.entryKernelArgs(activeUUID, activeVerity, varSource, fsType, activeComposefs, previousImageArgs, nil)
 
-	grubCfg := fmt.Sprintf(`set timeout=5
+	grubCfg := grubSerialConfig(u.Config.SerialConsole) + fmt.Sprintf(`set timeout=5
 set default=0
 
 menuentry '%s' {
@@ -705,10 +1309,12 @@ menuentry '%s (Previous)' {
     initrd /%s
 }
 `, osName, kernelVersion, strings.Join(kernelCmdline, " "), initrd,
-		osName, kernelVersion, strings.Join(previousCmdline, " "), initrd)
+		previousName, kernelVersion, strings.Join(previousCmdline, " "), initrd)
+	grubCfg += grubProfileEntries(u.Config.BootProfiles, osName, kernelVersion, initrd, kernelCmdline)
+	grubCfg = grubLockdownConfig(u.Config.Lockdown, grubCfg)
 
 	grubCfgPath := filepath.Join(grubDir, "grub.cfg")
-	if err := os.WriteFile(grubCfgPath, []byte(grubCfg), 0644); err != nil {
+	if err := writeFileAtomic(grubCfgPath, []byte(grubCfg), 0644); err != nil {
 		return fmt.Errorf("failed to write grub.cfg: %w", err)
 	}
 
@@ -724,16 +1330,22 @@ func (u *SystemUpdater) updateSystemdBootBootloader() error {
 		return fmt.Errorf("failed to get target UUID: %w", err)
 	}
 
-	varUUID, err := GetPartitionUUID(u.Scheme.VarPartition)
+	varSource, err := mountSource(u.Scheme.VarPartition, u.Config.MountIdentifier)
 	if err != nil {
-		return fmt.Errorf("failed to get var UUID: %w", err)
+		return fmt.Errorf("failed to get /var source: %w", err)
 	}
 
 	activeRoot := u.Scheme.Root1Partition
 	if !u.Active {
 		activeRoot = u.Scheme.Root2Partition
 	}
-	activeUUID, _ := GetPartitionUUID(activeRoot)
+	activeUUID, err := GetPartitionUUID(activeRoot)
+	if err != nil {
+		// The rollback entry is still written, but will not find its root
+		if werr := warnf("  could not get UUID of active root %s, rollback entry will not boot: %v", activeRoot, err); werr != nil {
+			return werr
+		}
+	}
 
 	// Find kernel and initramfs on boot partition
 	kernels, err := filepath.Glob(filepath.Join(u.Config.BootMountPoint, "vmlinuz-*"))
@@ -763,17 +1375,21 @@ func (u *SystemUpdater) updateSystemdBootBootloader() error {
 		fsType = "ext4"
 	}
 
-	// Build kernel command line
-	kernelCmdline := []string{
-		"root=UUID=" + targetUUID,
-		"rw",
-		// Mount /var via kernel command line (systemd.mount-extra)
-		"systemd.mount-extra=UUID=" + varUUID + ":/var:" + fsType + ":defaults",
+	targetVerity, activeVerity, err := u.verityRoots(targetUUID, activeUUID)
+	if err != nil {
+		return err
 	}
-	kernelCmdline = append(kernelCmdline, u.Config.KernelArgs...)
+	targetComposefs, activeComposefs, err := u.composefsDigests(targetUUID, activeUUID)
+	if err != nil {
+		return err
+	}
+
+	// Build kernel command line
+	targetImageArgs, previousImageArgs := u.imageKernelArgs()
+	kernelCmdline := u.entryKernelArgs(targetUUID, targetVerity, varSource, fsType, targetComposefs, targetImageArgs, u.Config.KernelArgs)
 
-	// Get OS name from the updated system
-	osName := ParseOSRelease(u.Config.MountPoint)
+	// Get the entry titles from the updated system
+	osName, previousName := u.bootEntryNames()
 
 	// Update loader.conf to default to bootc entry
 	loaderDir := filepath.Join(u.Config.BootMountPoint, "loader")
@@ -791,53 +1407,72 @@ options %s
 `, osName, kernelVersion, initrd, strings.Join(kernelCmdline, " "))
 
 	mainEntryPath := filepath.Join(entriesDir, "bootc.conf")
-	if err := os.WriteFile(mainEntryPath, []byte(mainEntry), 0644); err != nil {
+	if err := writeFileAtomic(mainEntryPath, []byte(mainEntry), 0644); err != nil {
 		return fmt.Errorf("failed to write main boot entry: %w", err)
 	}
 
 	// Build previous kernel command line
-	previousCmdline := []string{
-		"root=UUID=" + activeUUID,
-		"rw",
-		"systemd.mount-extra=UUID=" + varUUID + ":/var:" + fsType + ":defaults",
-	}
+	previousCmdline := u.entryKernelArgs(activeUUID, activeVerity, varSource, fsType, activeComposefs, previousImageArgs, nil)
 
 	// Create/update rollback boot entry (points to previous system)
 	previousEntry := fmt.Sprintf(`title   %s (Previous)
 linux   /vmlinuz-%s
 initrd  /%s
 options %s
-`, osName, kernelVersion, initrd, strings.Join(previousCmdline, " "))
+`, previousName, kernelVersion, initrd, strings.Join(previousCmdline, " "))
 
 	previousEntryPath := filepath.Join(entriesDir, "bootc-previous.conf")
-	if err := os.WriteFile(previousEntryPath, []byte(previousEntry), 0644); err != nil {
+	if err := writeFileAtomic(previousEntryPath, []byte(previousEntry), 0644); err != nil {
 		return fmt.Errorf("failed to write rollback boot entry: %w", err)
 	}
+	if err := writeLoaderProfileEntries(entriesDir, u.Config.BootProfiles, osName, kernelVersion, initrd, kernelCmdline); err != nil {
+		return err
+	}
 
 	fmt.Printf("  Updated systemd-boot to boot from %s\n", u.Target)
 	return nil
 }
 
 // PerformUpdate performs the complete update workflow
-func (u *SystemUpdater) PerformUpdate(skipPull bool) error {
+func (u *SystemUpdater) PerformUpdate(ctx context.Context, skipPull bool) error {
+	if !u.Config.DryRun {
+		markChangesSystem()
+		if config, err := ReadSystemConfig(); err == nil {
+			recordResult(func(r *OperationResult) { r.PreviousImageDigest = config.ImageDigest })
+		}
+		recordResult(func(r *OperationResult) { r.PreviousImageVersion = deploymentVersion(bootedRoot) })
+	}
 
 	// Prepare update
 	if err := u.PrepareUpdate(); err != nil {
 		return err
 	}
+	if err := u.checkSlotPin(); err != nil {
+		return err
+	}
+	if err := u.checkImageFits(ctx); err != nil {
+		return err
+	}
+	if err := u.checkImagePolicy(ctx); err != nil {
+		return err
+	}
 
 	// Pull image if not skipped
 	if !skipPull {
-		if err := u.PullImage(); err != nil {
+		if err := u.PullImage(ctx); err != nil {
 			return err
 		}
 	}
 
 	// Check if update is actually needed (compare digests)
-	needed, digest, err := u.IsUpdateNeeded()
-	if err != nil {
-		fmt.Printf("Warning: could not check if update needed: %v\n", err)
+	needed, digest, err := u.IsUpdateNeeded(ctx)
+	if errors.Is(err, ErrPinMismatch) {
+		return err
+	} else if err != nil {
 		// Continue with update anyway
+		if werr := warnf("could not check if update needed: %v", err); werr != nil {
+			return werr
+		}
 	} else if !needed && !u.Config.Force {
 		fmt.Println("\nNo update needed - system is already running the latest version.")
 		fmt.Println("Use --force to reinstall anyway.")
@@ -850,31 +1485,108 @@ func (u *SystemUpdater) PerformUpdate(skipPull bool) error {
 	u.Config.ImageDigest = digest
 
 	// Confirm update
-	if !u.Config.DryRun && !u.Config.Force {
-		fmt.Printf("\n%s\n", strings.Repeat("=", 60))
-		fmt.Printf("This will update the system to a new root filesystem.\n")
-		fmt.Printf("Target partition: %s\n", u.Target)
-		fmt.Printf("%s\n", strings.Repeat("=", 60))
-		fmt.Print("Type 'yes' to continue: ")
-		var response string
-		_, _ = fmt.Scanln(&response)
-		if response != "yes" {
-			return fmt.Errorf("update cancelled by user")
-		}
-		fmt.Println()
+	if !u.Config.DryRun && !u.Config.Force && !u.Config.AssumeYes &&
+		!confirm("This will update the system to a new root filesystem.", "Target partition: "+u.Target) {
+		return fmt.Errorf("update %w", ErrAborted)
 	}
 
 	// Perform update
-	if err := u.Update(); err != nil {
+	if err := u.Update(ctx); err != nil {
 		return err
 	}
 
-	// Update system config with new image reference and digest
+	// Update system config with new image reference and digest. A sealed
+	// target already has it, and the active /etc is read-only.
 	if !u.Config.DryRun {
-		if err := UpdateSystemConfigImageRef(u.Config.ImageRef, u.Config.ImageDigest, u.Config.DryRun); err != nil {
-			fmt.Printf("Warning: failed to update system config: %v\n", err)
+		if err := u.recordActiveConfig(); err != nil {
+			return err
+		}
+		u.Staged = true
+		if err := ClearUpdateNotice(); err != nil {
+			if werr := warnf("%v", err); werr != nil {
+				return werr
+			}
 		}
 	}
 
 	return nil
 }
+
+// recordActiveConfig records the new image and pin in the active system
+// config, unless the target was sealed with its own
+func (u *SystemUpdater) recordActiveConfig() error {
+	if u.Config.Verity {
+		return nil
+	}
+	if err := UpdateSystemConfigImageRef(u.Config.ImageRef, u.Config.ImageDigest, u.Config.DryRun); err != nil {
+		if werr := warnf("failed to update system config: %v", err); werr != nil {
+			// The bootloader already points at the new root; switch it back so
+			// the recorded config and the next boot stay consistent
+			if rerr := u.rollbackBootloader(); rerr != nil {
+				return fmt.Errorf("%w (rollback also failed: %v)", werr, rerr)
+			}
+			return werr
+		}
+	}
+	if u.Config.Repin {
+		if err := SetSystemConfigPin(u.Config.PinnedDigest, u.Config.PinPolicy, u.Config.DryRun); err != nil {
+			if werr := warnf("failed to record new pin: %v", err); werr != nil {
+				return werr
+			}
+		}
+	}
+	return nil
+}
+
+// pinnedReference returns imageRef's repository with digest, e.g.
+// quay.io/example/os@sha256:...
+func pinnedReference(imageRef, digest string) (string, error) {
+	ref, err := name.ParseReference(imageRef)
+	if err != nil {
+		return "", fmt.Errorf("%w: %w", ErrInvalidImageReference, err)
+	}
+	return ref.Context().Digest(digest).String(), nil
+}
+
+// applyPin holds the update to the pinned digest when the installed image is
+// pinned, and returns the digest to update to. remoteDigest is where the tag
+// points now.
+func (u *SystemUpdater) applyPin(config *SystemConfig, remoteDigest string) (string, error) {
+	// A pin only applies to the image it was made for
+	if config.ImageRef != u.Config.ImageRef {
+		return remoteDigest, nil
+	}
+
+	if u.Config.Repin {
+		if config.PinnedDigest != remoteDigest {
+			fmt.Println("  Advancing pin:")
+			fmt.Printf("    From: %s\n", config.PinnedDigest)
+			fmt.Printf("    To:   %s\n", remoteDigest)
+		}
+		u.Config.PinnedDigest = remoteDigest
+		u.Config.PinPolicy = config.PinPolicy
+		if u.Config.PinPolicy == "" {
+			u.Config.PinPolicy = PinPolicyWarn
+		}
+		return remoteDigest, nil
+	}
+
+	if config.PinnedDigest == "" {
+		return remoteDigest, nil
+	}
+	u.Config.PinnedDigest = config.PinnedDigest
+
+	if remoteDigest != config.PinnedDigest {
+		msg := fmt.Sprintf("tag %s has moved to %s but the image is pinned to %s (use --repin to advance the pin)",
+			config.ImageRef, remoteDigest, config.PinnedDigest)
+		if config.PinPolicy == PinPolicyFail {
+			return "", fmt.Errorf("%w: %s", ErrPinMismatch, msg)
+		}
+		if werr := warnf("%s", msg); werr != nil {
+			return "", werr
+		}
+	} else if u.Config.Verbose {
+		fmt.Printf("  Pinned digest: %s\n", config.PinnedDigest)
+	}
+	return config.PinnedDigest, nil
+}
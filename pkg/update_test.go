@@ -1,6 +1,7 @@
 package pkg
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"os/exec"
@@ -13,7 +14,7 @@ import (
 
 func TestSystemUpdater_Update(t *testing.T) {
 	testutil.RequireRoot(t)
-	testutil.RequireTools(t, "losetup", "sgdisk", "mkfs.vfat", "mkfs.ext4", "podman", "mount", "umount", "rsync")
+	testutil.RequireTools(t, "losetup", "mkfs.vfat", "mkfs.ext4", "podman", "mount", "umount")
 
 	// Step 1: Install initial system
 	t.Log("Step 1: Installing initial system")
@@ -38,7 +39,7 @@ func TestSystemUpdater_Update(t *testing.T) {
 	updater.SetForce(true)
 
 	// Skip pull since we're using a local test image
-	if err := updater.PerformUpdate(true); err != nil {
+	if err := updater.PerformUpdate(context.Background(), true); err != nil {
 		t.Fatalf("Update failed: %v", err)
 	}
 
@@ -55,7 +56,7 @@ func TestSystemUpdater_Update(t *testing.T) {
 
 func TestSystemUpdater_EtcPersistence(t *testing.T) {
 	testutil.RequireRoot(t)
-	testutil.RequireTools(t, "losetup", "sgdisk", "mkfs.vfat", "mkfs.ext4", "podman", "mount", "umount", "rsync")
+	testutil.RequireTools(t, "losetup", "mkfs.vfat", "mkfs.ext4", "podman", "mount", "umount")
 
 	// Install initial system
 	t.Log("Installing initial system")
@@ -105,7 +106,7 @@ func TestSystemUpdater_EtcPersistence(t *testing.T) {
 	updater.SetForce(true)
 
 	// Skip pull since we're using a local test image
-	if err := updater.PerformUpdate(true); err != nil {
+	if err := updater.PerformUpdate(context.Background(), true); err != nil {
 		t.Fatalf("Update failed: %v", err)
 	}
 
@@ -173,7 +174,7 @@ func installTestSystem(t *testing.T, version string) (*testutil.TestDisk, *Parti
 
 	defer testutil.CleanupMounts(t, mountPoint)
 
-	if err := installer.Install(); err != nil {
+	if err := installer.Install(context.Background()); err != nil {
 		t.Fatalf("Install failed: %v", err)
 	}
 
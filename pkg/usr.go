@@ -0,0 +1,99 @@
+package pkg
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Read-only /usr
+//
+// /usr comes from the image and every update replaces it, so a change made
+// to it on a running system would silently make the root diverge from its
+// image. Unless installed with a writable /usr, each boot entry bind-mounts
+// /usr read-only. For debugging, MountUsrOverlay layers a writable overlay
+// over /usr that lives in memory and is gone after a reboot, like
+// ostree admin unlock.
+
+// UsrOverlayDir holds the writable layer of the /usr overlay. /run is a
+// tmpfs, so the overlay's changes never reach the root partition.
+var UsrOverlayDir = "/run/phukit/usr-overlay"
+
+// mountKernelArgs returns the arguments that mount the shared /var
+// partition from varSource (such as UUID=...; "" if /etc mounts it) and
+// /usr, and pass the root's mount options. For a composefs root with image
+// digest composefsDigest, the initramfs mounts both, because /usr has to be
+// in place before switching root. Otherwise /usr is bind-mounted read-only
+// unless writableUsr is set.
+func mountKernelArgs(varSource, fsType, composefsDigest string, writableUsr bool, mountOptions MountOptions) []string {
+	args := mountOptions.rootKernelArgs()
+	if composefsDigest != "" {
+		options := []string{
+			"ro",
+			"basedir=/sysroot" + ComposefsObjectsPath,
+			"digest=" + composefsDigest,
+			"x-systemd.requires-mounts-for=/sysroot/var",
+		}
+		return append(args,
+			"rd.systemd.mount-extra="+varSource+":/sysroot/var:"+fsType+":"+mountOptions.get("/var"),
+			"rd.systemd.mount-extra=/sysroot/"+composefsImagePath+":/sysroot/usr:composefs:"+strings.Join(options, ","),
+		)
+	}
+	if varSource != "" {
+		args = append(args, "systemd.mount-extra="+varSource+":/var:"+fsType+":"+mountOptions.get("/var"))
+	}
+	if !writableUsr {
+		args = append(args, "systemd.mount-extra=/usr:/usr:none:bind,ro")
+	}
+	return args
+}
+
+// UsrOverlayActive reports whether a writable overlay is mounted on /usr
+func UsrOverlayActive() (bool, error) {
+	mounts, err := readMounts()
+	if err != nil {
+		return false, err
+	}
+	usr := filepath.Join(bootedRoot, "usr")
+	active := false
+	// The last mount on /usr is the one in effect
+	for _, m := range mounts {
+		if m.target == usr {
+			active = m.fsType == "overlay"
+		}
+	}
+	return active, nil
+}
+
+// MountUsrOverlay makes /usr writable until the next reboot by mounting an
+// overlay over it with its writable layer in UsrOverlayDir. The root
+// partition is not changed, so it keeps matching its image.
+func MountUsrOverlay(dryRun bool) error {
+	active, err := UsrOverlayActive()
+	if err != nil {
+		return err
+	}
+	if active {
+		return fmt.Errorf("a writable overlay is already mounted on /usr")
+	}
+
+	upper := filepath.Join(UsrOverlayDir, "upper")
+	work := filepath.Join(UsrOverlayDir, "work")
+	usr := filepath.Join(bootedRoot, "usr")
+	options := "lowerdir=" + usr + ",upperdir=" + upper + ",workdir=" + work
+	if dryRun {
+		fmt.Printf("[DRY RUN] Would mount overlay on %s (%s)\n", usr, options)
+		return nil
+	}
+
+	for _, dir := range []string{upper, work} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return fmt.Errorf("failed to create %s: %w", dir, err)
+		}
+	}
+	if err := mounter.Mount("overlay", usr, "overlay", 0, options); err != nil {
+		return &MountError{Op: "mount", Source: "overlay", Target: usr, FSType: "overlay", Err: err}
+	}
+	return nil
+}
@@ -0,0 +1,105 @@
+package pkg
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMountKernelArgs(t *testing.T) {
+	tests := []struct {
+		name        string
+		varSource   string
+		digest      string
+		writableUsr bool
+		options     MountOptions
+		want        []string
+	}{
+		{
+			name:      "read-only /usr",
+			varSource: "UUID=vvvv",
+			want: []string{
+				"systemd.mount-extra=UUID=vvvv:/var:ext4:defaults",
+				"systemd.mount-extra=/usr:/usr:none:bind,ro",
+			},
+		},
+		{
+			name:        "writable /usr",
+			varSource:   "UUID=vvvv",
+			writableUsr: true,
+			want:        []string{"systemd.mount-extra=UUID=vvvv:/var:ext4:defaults"},
+		},
+		{
+			name:      "mount options",
+			varSource: "UUID=vvvv",
+			options:   MountOptions{"/": "noatime,compress=zstd:3", "/var": "noatime,nodev"},
+			want: []string{
+				"rootflags=noatime,compress=zstd:3",
+				"systemd.mount-extra=UUID=vvvv:/var:ext4:noatime,nodev",
+				"systemd.mount-extra=/usr:/usr:none:bind,ro",
+			},
+		},
+		{
+			name: "/var mounted from /etc",
+			want: []string{"systemd.mount-extra=/usr:/usr:none:bind,ro"},
+		},
+		{
+			name:      "/var by label",
+			varSource: "LABEL=var",
+			want: []string{
+				"systemd.mount-extra=LABEL=var:/var:ext4:defaults",
+				"systemd.mount-extra=/usr:/usr:none:bind,ro",
+			},
+		},
+		{
+			name:      "composefs /usr",
+			varSource: "UUID=vvvv",
+			digest:    "a1b2c3",
+			want: []string{
+				"rd.systemd.mount-extra=UUID=vvvv:/sysroot/var:ext4:defaults",
+				"rd.systemd.mount-extra=/sysroot/composefs/usr.cfs:/sysroot/usr:composefs:ro," +
+					"basedir=/sysroot/var/lib/phukit/composefs/objects,digest=a1b2c3,x-systemd.requires-mounts-for=/sysroot/var",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := mountKernelArgs(tt.varSource, "ext4", tt.digest, tt.writableUsr, tt.options)
+			if strings.Join(got, " ") != strings.Join(tt.want, " ") {
+				t.Errorf("mountKernelArgs() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMountUsrOverlay(t *testing.T) {
+	root := t.TempDir()
+	oldRoot, oldDir, oldMounts := bootedRoot, UsrOverlayDir, procMountsPath
+	bootedRoot = root
+	UsrOverlayDir = filepath.Join(root, "run", "phukit", "usr-overlay")
+	procMountsPath = filepath.Join(root, "mounts")
+	t.Cleanup(func() { bootedRoot, UsrOverlayDir, procMountsPath = oldRoot, oldDir, oldMounts })
+	usr := filepath.Join(root, "usr")
+
+	writeFakeFile(t, procMountsPath, "/dev/vda2 "+root+" ext4 rw 0 0\n/usr "+usr+" ext4 ro 0 0\n")
+	fake := setupFakeMounter(t, "overlay")
+
+	if active, err := UsrOverlayActive(); err != nil || active {
+		t.Fatalf("UsrOverlayActive() = %v, %v, want false", active, err)
+	}
+	if err := MountUsrOverlay(false); err != nil {
+		t.Fatalf("MountUsrOverlay() error = %v", err)
+	}
+	if len(fake.tried) != 1 || fake.tried[0] != "overlay" {
+		t.Errorf("mounted %v, want one overlay", fake.tried)
+	}
+
+	// Once the overlay shows up in the mount table it is not mounted twice
+	writeFakeFile(t, procMountsPath, "/dev/vda2 "+root+" ext4 rw 0 0\n/usr "+usr+" ext4 ro 0 0\noverlay "+usr+" overlay rw 0 0\n")
+	if active, err := UsrOverlayActive(); err != nil || !active {
+		t.Fatalf("UsrOverlayActive() = %v, %v, want true", active, err)
+	}
+	if err := MountUsrOverlay(false); err == nil {
+		t.Error("MountUsrOverlay() expected error when the overlay is already mounted")
+	}
+}
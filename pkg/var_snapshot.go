@@ -0,0 +1,372 @@
+package pkg
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// /var snapshots
+//
+// Newer software often migrates its state in /var (database schemas, cache
+// formats) in ways the older version cannot read. So before an update makes
+// the new root the default boot entry, the shared /var is snapshotted if its
+// storage can:
+//
+//   - LVM installs get a snapshot volume var-snapshot of the var volume, in
+//     the free space of the volume group.
+//   - A btrfs /var gets a read-only snapshot at VarSnapshotPath.
+//
+// A rollback to the previous deployment restores the snapshot on the next
+// boot, since /var is in use until then: LVM merges the snapshot into the var
+// volume when it is next activated, and for btrfs a writable copy of the
+// snapshot becomes the filesystem's default subvolume, which /var is mounted
+// from. Whatever was written to /var since the update is lost, so rollback
+// asks first and --keep-var skips the restore. Discarding an update that was
+// never booted drops its snapshot instead. Each update replaces the snapshot
+// of the one before, and a snapshot is only restored by a rollback from the
+// deployment it was taken for.
+const (
+	// VarSnapshotPath is the btrfs snapshot of /var, inside /var
+	VarSnapshotPath = "/var/lib/phukit/var-snapshot"
+	// VarSnapshotRecordPath describes the snapshot of /var, written after it
+	// was taken so a restored /var has none
+	VarSnapshotRecordPath = "/var/lib/phukit/var-snapshot.json"
+)
+
+// Kinds of /var snapshot
+const (
+	VarSnapshotLVM   = "lvm"
+	VarSnapshotBtrfs = "btrfs"
+)
+
+// varRestoredPath is the writable copy of the btrfs snapshot that becomes
+// the default subvolume, inside /var
+const varRestoredPath = "/var/lib/phukit/var-restored"
+
+// lvmVarSnapshotVolume is the logical volume of the /var snapshot
+const lvmVarSnapshotVolume = "var-snapshot"
+
+// lvmVarSnapshotExtents is the share of the free space of the volume group
+// the snapshot gets for the blocks of /var changed after it was taken; once
+// that is used up, the snapshot is invalid
+const lvmVarSnapshotExtents = "100%FREE"
+
+// btrfsSubvolumeInode is the inode number of every btrfs subvolume root
+const btrfsSubvolumeInode = 256
+
+// VarSnapshot describes the /var snapshot of an update
+type VarSnapshot struct {
+	Kind        string    `json:"kind"`                   // lvm or btrfs
+	Created     time.Time `json:"created"`                // When the update took it
+	ImageDigest string    `json:"image_digest,omitempty"` // Image the update installed
+	Restoring   bool      `json:"restoring,omitempty"`    // A rollback scheduled the restore
+}
+
+// varSnapshotKind returns how the shared /var mounted at varRoot can be
+// snapshotted, or "" if it can't
+func (u *SystemUpdater) varSnapshotKind(varRoot string) string {
+	if u.Scheme.VolumeGroup != "" && u.Scheme.VarPartition == lvmVolumePath(u.Scheme.VolumeGroup, "var") {
+		return VarSnapshotLVM
+	}
+	mounts, err := readMounts()
+	if err != nil {
+		return ""
+	}
+	fsType := ""
+	// The last mount on varRoot is the one in effect
+	for _, m := range mounts {
+		if m.target == varRoot {
+			fsType = m.fsType
+		}
+	}
+	if fsType != "btrfs" {
+		return ""
+	}
+	// A restore changes the default subvolume, which an explicit one overrides
+	for _, opt := range strings.Split(u.Config.MountOptions.get("/var"), ",") {
+		if strings.HasPrefix(opt, "subvol=") || strings.HasPrefix(opt, "subvolid=") {
+			return ""
+		}
+	}
+	return VarSnapshotBtrfs
+}
+
+// SnapshotVar snapshots the shared /var, replacing the snapshot of an earlier
+// update, if its storage supports snapshots. A failed snapshot is a warning:
+// the update goes on without one.
+func (u *SystemUpdater) SnapshotVar(ctx context.Context) error {
+	if !u.Config.VarSnapshot {
+		return nil
+	}
+	if u.Config.DryRun {
+		fmt.Println("[DRY RUN] Would snapshot /var if it is on LVM or btrfs")
+		return nil
+	}
+
+	err := u.withSharedVar(ctx, func(varRoot string) error {
+		kind := u.varSnapshotKind(varRoot)
+		if kind == "" {
+			if u.Config.Verbose {
+				fmt.Println("  /var is neither an LVM volume nor a btrfs filesystem without subvol= option; not taking a snapshot")
+			}
+			return nil
+		}
+		fmt.Printf("  Taking %s snapshot of /var...\n", kind)
+		// The record of an earlier snapshot must not outlive it
+		if err := os.Remove(sharedVarPath(varRoot, VarSnapshotRecordPath)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		var err error
+		switch kind {
+		case VarSnapshotLVM:
+			err = u.snapshotVarLVM(ctx)
+		case VarSnapshotBtrfs:
+			err = snapshotVarBtrfs(ctx, varRoot)
+		}
+		if err != nil {
+			return err
+		}
+		record := &VarSnapshot{Kind: kind, Created: time.Now().UTC(), ImageDigest: u.Config.ImageDigest}
+		if err := writeVarSnapshot(varRoot, record); err != nil {
+			return err
+		}
+		recordResult(func(r *OperationResult) { r.VarSnapshot = kind })
+		return nil
+	})
+	if err != nil {
+		return warnf("  failed to snapshot /var, a rollback will keep /var as it is: %v", err)
+	}
+	return nil
+}
+
+// snapshotVarLVM replaces the snapshot volume of /var
+func (u *SystemUpdater) snapshotVarLVM(ctx context.Context) error {
+	if err := u.removeVarSnapshotLVM(ctx); err != nil {
+		return err
+	}
+	origin := u.Scheme.VolumeGroup + "/var"
+	if output, err := runCommand(ctx, "lvcreate", "--yes", "--snapshot", "--extents", lvmVarSnapshotExtents, "--name", lvmVarSnapshotVolume, origin); err != nil {
+		return fmt.Errorf("lvcreate: %w\nOutput: %s", err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// removeVarSnapshotLVM removes the snapshot volume of /var if there is one
+func (u *SystemUpdater) removeVarSnapshotLVM(ctx context.Context) error {
+	snapshot := u.Scheme.VolumeGroup + "/" + lvmVarSnapshotVolume
+	// lvs fails for a volume that does not exist
+	if _, err := runCommand(ctx, "lvs", "--noheadings", snapshot); err != nil {
+		return nil
+	}
+	if output, err := runCommand(ctx, "lvremove", "--yes", snapshot); err != nil {
+		return fmt.Errorf("lvremove: %w\nOutput: %s", err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// snapshotVarBtrfs replaces the read-only snapshot of the /var subvolume
+// mounted at varRoot
+func snapshotVarBtrfs(ctx context.Context, varRoot string) error {
+	if err := checkSharedVarPath(VarSnapshotPath); err != nil {
+		return err
+	}
+	path := sharedVarPath(varRoot, VarSnapshotPath)
+	if err := removeBtrfsSubvolume(ctx, path); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	if output, err := runCommand(ctx, "btrfs", "subvolume", "snapshot", "-r", varRoot, path); err != nil {
+		return fmt.Errorf("btrfs subvolume snapshot: %w\nOutput: %s", err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// removeBtrfsSubvolume deletes the subvolume at path. A snapshot shows the
+// subvolumes nested in its source as empty directories, which are removed
+// as well.
+func removeBtrfsSubvolume(ctx context.Context, path string) error {
+	var st syscall.Stat_t
+	if err := syscall.Lstat(path, &st); errors.Is(err, syscall.ENOENT) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("failed to stat %s: %w", path, err)
+	}
+	if st.Ino != btrfsSubvolumeInode {
+		return os.Remove(path)
+	}
+	if output, err := runCommand(ctx, "btrfs", "subvolume", "delete", path); err != nil {
+		return fmt.Errorf("btrfs subvolume delete: %w\nOutput: %s", err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// sharedVarPath returns path, a path in the shared /var as seen by the
+// running system, below varRoot
+func sharedVarPath(varRoot, path string) string {
+	return filepath.Join(varRoot, strings.TrimPrefix(path, "/var/"))
+}
+
+// readVarSnapshot reads the snapshot record of the shared /var mounted at
+// varRoot, or returns nil if there is none
+func readVarSnapshot(varRoot string) (*VarSnapshot, error) {
+	data, err := os.ReadFile(sharedVarPath(varRoot, VarSnapshotRecordPath))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read /var snapshot record: %w", err)
+	}
+	var s VarSnapshot
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, fmt.Errorf("failed to parse /var snapshot record: %w", err)
+	}
+	return &s, nil
+}
+
+// writeVarSnapshot writes the snapshot record of the shared /var mounted at
+// varRoot
+func writeVarSnapshot(varRoot string, s *VarSnapshot) error {
+	if err := checkSharedVarPath(VarSnapshotRecordPath); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal /var snapshot record: %w", err)
+	}
+	path := sharedVarPath(varRoot, VarSnapshotRecordPath)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	if err := writeFileAtomic(path, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to write /var snapshot record: %w", err)
+	}
+	return nil
+}
+
+// pendingVarRestore returns the snapshot a rollback from the booted
+// deployment would restore, or nil if there is none or --keep-var was given
+func (u *SystemUpdater) pendingVarRestore(ctx context.Context) (*VarSnapshot, error) {
+	if !u.Config.RestoreVar {
+		return nil, nil
+	}
+	var snapshot *VarSnapshot
+	var err error
+	if u.Config.DryRun {
+		snapshot, err = readVarSnapshot("/var")
+	} else {
+		err = u.withSharedVar(ctx, func(varRoot string) error {
+			var err error
+			snapshot, err = readVarSnapshot(varRoot)
+			return err
+		})
+	}
+	if err != nil || snapshot == nil {
+		return nil, err
+	}
+	if snapshot.Restoring {
+		fmt.Println("A restore of /var is already scheduled for the next boot")
+		return nil, nil
+	}
+	// Only the update to the booted deployment took a snapshot it may restore
+	booted, err := ReadDeployment(bootedRoot)
+	if err != nil || snapshot.ImageDigest == "" || booted.ImageDigest != snapshot.ImageDigest {
+		if werr := warnf("the /var snapshot of %s was not taken by the update to the booted deployment; /var is kept", snapshot.Created.Local().Format(time.DateTime)); werr != nil {
+			return nil, werr
+		}
+		return nil, nil
+	}
+	return snapshot, nil
+}
+
+// RestoreVar schedules the restore of the /var snapshot for the next boot
+func (u *SystemUpdater) RestoreVar(ctx context.Context, snapshot *VarSnapshot) error {
+	if u.Config.DryRun {
+		fmt.Printf("[DRY RUN] Would restore /var from its %s snapshot\n", snapshot.Kind)
+		return nil
+	}
+	fmt.Printf("Restoring /var from its %s snapshot of %s on the next boot...\n", snapshot.Kind, snapshot.Created.Local().Format(time.DateTime))
+	return u.withSharedVar(ctx, func(varRoot string) error {
+		var err error
+		switch snapshot.Kind {
+		case VarSnapshotLVM:
+			// The var volume is in use, so LVM merges the snapshot when the
+			// volume is next activated
+			var output []byte
+			output, err = runCommand(ctx, "lvconvert", "--merge", u.Scheme.VolumeGroup+"/"+lvmVarSnapshotVolume)
+			if err != nil {
+				err = fmt.Errorf("lvconvert: %w\nOutput: %s", err, strings.TrimSpace(string(output)))
+			}
+		case VarSnapshotBtrfs:
+			err = restoreVarBtrfs(ctx, varRoot)
+		default:
+			err = fmt.Errorf("unknown /var snapshot kind %q", snapshot.Kind)
+		}
+		if err != nil {
+			return err
+		}
+		snapshot.Restoring = true
+		if err := writeVarSnapshot(varRoot, snapshot); err != nil {
+			return err
+		}
+		recordResult(func(r *OperationResult) { r.VarSnapshot = snapshot.Kind })
+		return nil
+	})
+}
+
+// restoreVarBtrfs makes a writable copy of the snapshot the default
+// subvolume of the /var filesystem mounted at varRoot. The /var it replaces
+// is left in the subvolume it was in.
+func restoreVarBtrfs(ctx context.Context, varRoot string) error {
+	if err := checkSharedVarPath(varRestoredPath); err != nil {
+		return err
+	}
+	restored := sharedVarPath(varRoot, varRestoredPath)
+	if err := removeBtrfsSubvolume(ctx, restored); err != nil {
+		return err
+	}
+	if output, err := runCommand(ctx, "btrfs", "subvolume", "snapshot", sharedVarPath(varRoot, VarSnapshotPath), restored); err != nil {
+		return fmt.Errorf("btrfs subvolume snapshot: %w\nOutput: %s", err, strings.TrimSpace(string(output)))
+	}
+	output, err := runCommand(ctx, "btrfs", "inspect-internal", "rootid", restored)
+	if err != nil {
+		return fmt.Errorf("btrfs inspect-internal rootid: %w\nOutput: %s", err, strings.TrimSpace(string(output)))
+	}
+	id := strings.TrimSpace(string(output))
+	if output, err := runCommand(ctx, "btrfs", "subvolume", "set-default", id, varRoot); err != nil {
+		return fmt.Errorf("btrfs subvolume set-default: %w\nOutput: %s", err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// DiscardVarSnapshot drops the /var snapshot of an update that was never
+// booted
+func (u *SystemUpdater) DiscardVarSnapshot(ctx context.Context) error {
+	if u.Config.DryRun {
+		return nil
+	}
+	return u.withSharedVar(ctx, func(varRoot string) error {
+		snapshot, err := readVarSnapshot(varRoot)
+		if err != nil || snapshot == nil || snapshot.Restoring {
+			return err
+		}
+		fmt.Printf("Dropping the %s snapshot of /var taken by the discarded update...\n", snapshot.Kind)
+		switch snapshot.Kind {
+		case VarSnapshotLVM:
+			err = u.removeVarSnapshotLVM(ctx)
+		case VarSnapshotBtrfs:
+			err = removeBtrfsSubvolume(ctx, sharedVarPath(varRoot, VarSnapshotPath))
+		}
+		if err != nil {
+			return err
+		}
+		return os.Remove(sharedVarPath(varRoot, VarSnapshotRecordPath))
+	})
+}
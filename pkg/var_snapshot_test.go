@@ -0,0 +1,167 @@
+package pkg
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// setupFakeVarSnapshot fails lvs unless the snapshot volume exists and
+// answers btrfs inspect-internal rootid with a subvolume ID
+func setupFakeVarSnapshot(t *testing.T, lvmSnapshot bool) *scriptedExecutor {
+	t.Helper()
+	fake := setupScriptedExecutor(t)
+	if !lvmSnapshot {
+		fake.fail("lvs", errors.New("exit status 5"))
+	}
+	fake.answer("btrfs inspect-internal rootid", "257\n")
+	return fake
+}
+
+func checkCommands(t *testing.T, got []Command, want []string) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("commands = %v, want %v", got, want)
+	}
+	for i, w := range want {
+		if got[i].String() != w {
+			t.Errorf("command %d = %q, want %q", i, got[i], w)
+		}
+	}
+}
+
+func TestVarSnapshotKind(t *testing.T) {
+	varRoot := t.TempDir()
+	oldMounts := procMountsPath
+	procMountsPath = filepath.Join(t.TempDir(), "mounts")
+	t.Cleanup(func() { procMountsPath = oldMounts })
+
+	tests := []struct {
+		name    string
+		mounts  string
+		lvm     bool
+		options map[string]string
+		want    string
+	}{
+		{"lvm", "/dev/mapper/phukit-var " + varRoot + " ext4 rw 0 0\n", true, nil, VarSnapshotLVM},
+		{"btrfs", "/dev/sda4 " + varRoot + " btrfs rw 0 0\n", false, nil, VarSnapshotBtrfs},
+		{"btrfs subvolume", "/dev/sda4 " + varRoot + " btrfs rw 0 0\n", false, map[string]string{"/var": "subvol=@var"}, ""},
+		{"later mount wins", "/dev/sda4 " + varRoot + " btrfs rw 0 0\ntmpfs " + varRoot + " tmpfs rw 0 0\n", false, nil, ""},
+		{"ext4", "/dev/sda4 " + varRoot + " ext4 rw 0 0\n", false, nil, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			writeFakeFile(t, procMountsPath, tt.mounts)
+			u := NewSystemUpdater("/dev/sda", "quay.io/example/os:latest")
+			u.Scheme = &PartitionScheme{VarPartition: "/dev/sda4"}
+			if tt.lvm {
+				u.Scheme = &PartitionScheme{VolumeGroup: "phukit", VarPartition: lvmVolumePath("phukit", "var")}
+			}
+			u.Config.MountOptions = tt.options
+			if got := u.varSnapshotKind(varRoot); got != tt.want {
+				t.Errorf("varSnapshotKind() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSnapshotVarLVM(t *testing.T) {
+	setup := func(t *testing.T, lvmSnapshot bool) (*SystemUpdater, *scriptedExecutor) {
+		fake := setupFakeVarSnapshot(t, lvmSnapshot)
+		setupFakeMounter(t, "ext4")
+		u := NewSystemUpdater("/dev/sda", "quay.io/example/os:latest")
+		u.Scheme = &PartitionScheme{
+			VolumeGroup:    "phukit",
+			Root1Partition: lvmVolumePath("phukit", "root1"),
+			Root2Partition: lvmVolumePath("phukit", "root2"),
+			VarPartition:   lvmVolumePath("phukit", "var"),
+		}
+		u.Config.MountPoint = filepath.Join(t.TempDir(), "mnt")
+		return u, fake
+	}
+
+	t.Run("first snapshot", func(t *testing.T) {
+		u, fake := setup(t, false)
+		if err := u.SnapshotVar(context.Background()); err != nil {
+			t.Fatalf("SnapshotVar() error = %v", err)
+		}
+		checkCommands(t, fake.Commands, []string{
+			"lvs --noheadings phukit/var-snapshot",
+			"lvcreate --yes --snapshot --extents 100%FREE --name var-snapshot phukit/var",
+		})
+	})
+
+	t.Run("replaces the earlier snapshot", func(t *testing.T) {
+		u, fake := setup(t, true)
+		if err := u.SnapshotVar(context.Background()); err != nil {
+			t.Fatalf("SnapshotVar() error = %v", err)
+		}
+		checkCommands(t, fake.Commands, []string{
+			"lvs --noheadings phukit/var-snapshot",
+			"lvremove --yes phukit/var-snapshot",
+			"lvcreate --yes --snapshot --extents 100%FREE --name var-snapshot phukit/var",
+		})
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		u, fake := setup(t, false)
+		u.SetVarSnapshot(false)
+		if err := u.SnapshotVar(context.Background()); err != nil {
+			t.Fatalf("SnapshotVar() error = %v", err)
+		}
+		checkCommands(t, fake.Commands, nil)
+	})
+}
+
+func TestVarSnapshotBtrfs(t *testing.T) {
+	fake := setupFakeVarSnapshot(t, false)
+	varRoot := t.TempDir()
+	snapshot := filepath.Join(varRoot, "lib", "phukit", "var-snapshot")
+	restored := filepath.Join(varRoot, "lib", "phukit", "var-restored")
+
+	// A snapshot shows nested subvolumes as empty directories, which are
+	// removed without btrfs
+	if err := os.MkdirAll(snapshot, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := snapshotVarBtrfs(context.Background(), varRoot); err != nil {
+		t.Fatalf("snapshotVarBtrfs() error = %v", err)
+	}
+	if _, err := os.Stat(snapshot); !os.IsNotExist(err) {
+		t.Errorf("snapshotVarBtrfs() kept the old %s", snapshot)
+	}
+	if err := restoreVarBtrfs(context.Background(), varRoot); err != nil {
+		t.Fatalf("restoreVarBtrfs() error = %v", err)
+	}
+	checkCommands(t, fake.Commands, []string{
+		"btrfs subvolume snapshot -r " + varRoot + " " + snapshot,
+		"btrfs subvolume snapshot " + snapshot + " " + restored,
+		"btrfs inspect-internal rootid " + restored,
+		"btrfs subvolume set-default 257 " + varRoot,
+	})
+}
+
+func TestVarSnapshotRecord(t *testing.T) {
+	varRoot := t.TempDir()
+	if s, err := readVarSnapshot(varRoot); err != nil || s != nil {
+		t.Fatalf("readVarSnapshot() = %v, %v, want no record", s, err)
+	}
+
+	want := &VarSnapshot{Kind: VarSnapshotBtrfs, Created: time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC), ImageDigest: "sha256:0123"}
+	if err := writeVarSnapshot(varRoot, want); err != nil {
+		t.Fatalf("writeVarSnapshot() error = %v", err)
+	}
+	got, err := readVarSnapshot(varRoot)
+	if err != nil {
+		t.Fatalf("readVarSnapshot() error = %v", err)
+	}
+	if *got != *want {
+		t.Errorf("readVarSnapshot() = %+v, want %+v", got, want)
+	}
+	if _, err := os.Stat(filepath.Join(varRoot, "lib", "phukit", "var-snapshot.json")); err != nil {
+		t.Errorf("record not in /var/lib/phukit: %v", err)
+	}
+}
@@ -0,0 +1,124 @@
+package pkg
+
+import (
+	"archive/tar"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+)
+
+// Integrity problems reported by VerifyRoot
+const (
+	IntegrityModified = "modified" // Differs from the image
+	IntegrityMissing  = "missing"  // In the image but not in the root
+	IntegrityExtra    = "extra"    // In the root but not in the image
+)
+
+// RootVerification is the result of checking /usr of an installed root
+// against the image it was installed from
+type RootVerification struct {
+	Root        string           `json:"root"`           // Partition, or / for the booted root
+	Slot        string           `json:"slot,omitempty"` // root1 or root2
+	ImageRef    string           `json:"image_ref"`
+	ImageDigest string           `json:"image_digest"`
+	Checked     int              `json:"checked"` // Files in the image compared
+	Issues      []IntegrityIssue `json:"issues"`
+}
+
+// IntegrityIssue is a file in /usr that does not match the image
+type IntegrityIssue struct {
+	Path    string `json:"path"`
+	Problem string `json:"problem"` // modified, missing or extra
+}
+
+// Err returns an error wrapping ErrVerificationFailed if any file does not
+// match the image, or nil
+func (v *RootVerification) Err() error {
+	if len(v.Issues) == 0 {
+		return nil
+	}
+	counts := map[string]int{}
+	for _, issue := range v.Issues {
+		counts[issue.Problem]++
+	}
+	return fmt.Errorf("%w: %s does not match %s: %d modified, %d missing, %d extra files",
+		ErrVerificationFailed, v.Root, v.ImageDigest, counts[IntegrityModified], counts[IntegrityMissing], counts[IntegrityExtra])
+}
+
+// VerifyRoot checks the files in /usr of the booted root, or with inactive
+// of the other root on device mounted read-only, against the image recorded
+// in its deployment. The image is fetched by digest, so the layer digests
+// vouch for what the files should be; every file is compared by type, mode,
+// owner, size, link target and content.
+func VerifyRoot(ctx context.Context, device string, inactive bool) (*RootVerification, error) {
+	if !inactive {
+		v := &RootVerification{Root: "/"}
+		return v, v.verify(ctx, bootedRoot)
+	}
+
+	partition, slot, err := inactiveRootSlot(device)
+	if err != nil {
+		return nil, err
+	}
+	v := &RootVerification{Root: partition, Slot: slot}
+	if err := withReadOnlyMount(partition, func(root string) error {
+		// Only the image's metadata is on the partition; the initramfs
+		// mounts it with its digest pinned on the kernel command line
+		if _, err := os.Stat(filepath.Join(root, composefsImagePath)); err == nil {
+			return fmt.Errorf("/usr of %s is a composefs image and can only be verified when booted", partition)
+		}
+		return v.verify(ctx, root)
+	}); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// verify compares the root mounted at root with the image of its deployment
+func (v *RootVerification) verify(ctx context.Context, root string) error {
+	d, err := ReadDeployment(root)
+	if err != nil {
+		return fmt.Errorf("failed to read deployment of %s: %w", v.Root, err)
+	}
+	v.ImageRef, v.ImageDigest = d.ImageRef, d.ImageDigest
+	if d.ImageDigest == "" {
+		return fmt.Errorf("no image digest recorded for %s; it cannot be verified", v.Root)
+	}
+
+	ref, err := name.ParseReference(d.ImageRef)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidImageReference, err)
+	}
+	digestRef := ref.Context().Digest(d.ImageDigest)
+	img, err := remoteImage(ctx, digestRef)
+	if err != nil {
+		return fmt.Errorf("failed to fetch image %s: %w", digestRef, registryError(err))
+	}
+
+	local, err := localFiles(root)
+	if err != nil {
+		return err
+	}
+	rc := mutate.Extract(img)
+	defer func() { _ = rc.Close() }()
+	scan, err := diffFilesystem(ctx, tar.NewReader(rc), root, local, "", true)
+	if err != nil {
+		return err
+	}
+
+	v.Checked = scan.checked
+	v.Issues = []IntegrityIssue{}
+	problems := map[string]string{
+		ChangeChanged: IntegrityModified,
+		ChangeAdded:   IntegrityMissing,
+		ChangeRemoved: IntegrityExtra,
+	}
+	for _, f := range scan.files {
+		v.Issues = append(v.Issues, IntegrityIssue{Path: f.Path, Problem: problems[f.Change]})
+	}
+	return nil
+}
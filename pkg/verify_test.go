@@ -0,0 +1,83 @@
+package pkg
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+)
+
+func TestVerifyRoot(t *testing.T) {
+	layer := testLayer(t,
+		"usr/", "",
+		"usr/bin/", "",
+		"usr/bin/intact", "intact",
+		"usr/bin/tampered", "original",
+		"usr/bin/deleted", "deleted",
+		"usr/lib/", "",
+		"usr/lib/phukit/", "",
+		"etc/hostname", "image",
+	)
+	img, err := mutate.AppendLayers(empty.Image, layer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	digest, err := img.Digest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	imageRef := pushTestImage(t, img)
+	setupBootedRoot(t, &Deployment{ImageRef: imageRef, ImageDigest: digest.String()},
+		"usr/", "",
+		"usr/bin/", "",
+		"usr/bin/intact", "intact",
+		"usr/bin/tampered", "backdoor",
+		"usr/bin/dropped", "dropped",
+		"usr/lib/", "",
+		"usr/lib/phukit/", "",
+	)
+
+	v, err := VerifyRoot(t.Context(), "", false)
+	if err != nil {
+		t.Fatalf("VerifyRoot() error = %v", err)
+	}
+	want := []IntegrityIssue{
+		{Path: "/usr/bin/deleted", Problem: IntegrityMissing},
+		{Path: "/usr/bin/dropped", Problem: IntegrityExtra},
+		{Path: "/usr/bin/tampered", Problem: IntegrityModified},
+	}
+	if !slices.Equal(v.Issues, want) {
+		t.Errorf("Issues = %+v, want %+v", v.Issues, want)
+	}
+	// /etc is not checked
+	if v.Checked != 7 || v.ImageDigest != digest.String() {
+		t.Errorf("Checked = %d, ImageDigest = %s", v.Checked, v.ImageDigest)
+	}
+	if err := v.Err(); !errors.Is(err, ErrVerificationFailed) {
+		t.Errorf("Err() = %v, want ErrVerificationFailed", err)
+	}
+
+	// Restored, the root matches its image
+	for p, content := range map[string]string{"usr/bin/deleted": "deleted", "usr/bin/tampered": "original"} {
+		if err := os.WriteFile(filepath.Join(bootedRoot, p), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Remove(filepath.Join(bootedRoot, "usr/bin/dropped")); err != nil {
+		t.Fatal(err)
+	}
+	if v, err = VerifyRoot(t.Context(), "", false); err != nil || v.Err() != nil {
+		t.Errorf("VerifyRoot() after restoring = %+v, %v", v, err)
+	}
+}
+
+func TestVerifyRootWithoutDigest(t *testing.T) {
+	setupBootedRoot(t, &Deployment{ImageRef: "localhost/example:latest"}, "usr/", "")
+	if _, err := VerifyRoot(t.Context(), "", false); err == nil {
+		t.Error("VerifyRoot() without a recorded digest succeeded")
+	}
+}
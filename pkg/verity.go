@@ -0,0 +1,109 @@
+package pkg
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// VerityHashPartitionSize is the size of each dm-verity hash tree partition.
+// A SHA-256 tree over a 12 GiB root takes about 97 MiB.
+const VerityHashPartitionSize = 128 * 1024 * 1024
+
+// VerityRootsFile records the root hash of each verity-protected root,
+// relative to the boot partition. The hash cannot live on the root it
+// protects, and the boot entries are rebuilt from it.
+const VerityRootsFile = "phukit/verity.json"
+
+// VerityRoot is the dm-verity metadata of a sealed root filesystem
+type VerityRoot struct {
+	RootHash string `json:"root_hash"`
+	HashUUID string `json:"hash_uuid"` // UUID of the hash tree superblock
+}
+
+// kernelArgs returns the kernel arguments that boot the root with filesystem
+// UUID rootUUID through dm-verity, replacing root=UUID= and rw. The initramfs
+// needs systemd-veritysetup to open /dev/mapper/root.
+func (v *VerityRoot) kernelArgs(rootUUID string) []string {
+	return []string{
+		"root=/dev/mapper/root",
+		"ro",
+		"roothash=" + v.RootHash,
+		"systemd.verity_root_data=UUID=" + rootUUID,
+		"systemd.verity_root_hash=UUID=" + v.HashUUID,
+	}
+}
+
+// rootKernelArgs returns the arguments that select the root with filesystem
+// UUID rootUUID: through dm-verity if v is set, read-write otherwise
+func rootKernelArgs(rootUUID string, v *VerityRoot) []string {
+	if v != nil {
+		return v.kernelArgs(rootUUID)
+	}
+	return []string{"root=UUID=" + rootUUID, "rw"}
+}
+
+// CheckVerityTools checks that veritysetup is installed
+func CheckVerityTools() error {
+	if _, err := executor.LookPath("veritysetup"); err != nil {
+		return fmt.Errorf("%w: veritysetup - install the cryptsetup package", ErrMissingTool)
+	}
+	return nil
+}
+
+// sealRoot makes the root filesystem mounted at mountPoint read-only and
+// writes the dm-verity hash tree of its partition to hashPartition. Nothing
+// may write to the root afterwards, or it will fail verification.
+func sealRoot(ctx context.Context, mountPoint, partition, hashPartition string) (*VerityRoot, error) {
+	if hashPartition == "" {
+		return nil, fmt.Errorf("%w: no verity hash partition for %s", ErrPartitionSchemeMismatch, partition)
+	}
+	fmt.Printf("  Sealing %s with dm-verity (hash tree on %s)...\n", partition, hashPartition)
+	unix.Sync()
+	if err := remountReadOnly(mountPoint); err != nil {
+		return nil, fmt.Errorf("failed to make root read-only: %w", err)
+	}
+	v, err := verityFormat(ctx, partition, hashPartition)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Printf("  Root hash: %s\n", v.RootHash)
+	return v, nil
+}
+
+// verityFormat writes the hash tree of dataDevice to hashDevice
+func verityFormat(ctx context.Context, dataDevice, hashDevice string) (*VerityRoot, error) {
+	var out bytes.Buffer
+	err := executor.Run(ctx, Command{Name: "veritysetup", Args: []string{"format", dataDevice, hashDevice}, Stdout: &out, Stderr: &out})
+	if err != nil {
+		return nil, fmt.Errorf("veritysetup format failed: %w\nOutput: %s", err, out.String())
+	}
+	v := parseVerityFormat(out.Bytes())
+	if v.RootHash == "" || v.HashUUID == "" {
+		return nil, fmt.Errorf("veritysetup format printed no root hash or UUID:\n%s", out.String())
+	}
+	return v, nil
+}
+
+// parseVerityFormat reads the root hash and UUID from veritysetup format output
+func parseVerityFormat(out []byte) *VerityRoot {
+	v := &VerityRoot{}
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(scanner.Text(), ":")
+		if !ok {
+			continue
+		}
+		switch strings.TrimSpace(key) {
+		case "Root hash":
+			v.RootHash = strings.TrimSpace(value)
+		case "UUID":
+			v.HashUUID = strings.TrimSpace(value)
+		}
+	}
+	return v
+}
@@ -0,0 +1,83 @@
+package pkg
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+const veritysetupFormatOutput = `VERITY header information for /dev/sda5
+UUID:            	6f3c2d1e-9a8b-4c7d-8e6f-5a4b3c2d1e0f
+Hash type:       	1
+Data blocks:     	3145728
+Data block size: 	4096
+Hash block size: 	4096
+Hash algorithm:  	sha256
+Salt:            	8d3b0e3c9b7d1f5a2e4c6b8a0d2f4e6c8a0b2d4f6e8c0a2b4d6f8e0c2a4b6d8f
+Root hash:      	4392712ba01368efdf14b05c76f9e4df0d53664630b5d48632ed17a137f39076
+`
+
+func TestParseVerityFormat(t *testing.T) {
+	v := parseVerityFormat([]byte(veritysetupFormatOutput))
+	if v.RootHash != "4392712ba01368efdf14b05c76f9e4df0d53664630b5d48632ed17a137f39076" {
+		t.Errorf("RootHash = %q", v.RootHash)
+	}
+	if v.HashUUID != "6f3c2d1e-9a8b-4c7d-8e6f-5a4b3c2d1e0f" {
+		t.Errorf("HashUUID = %q", v.HashUUID)
+	}
+}
+
+func TestRootKernelArgs(t *testing.T) {
+	tests := []struct {
+		name   string
+		verity *VerityRoot
+		want   string
+	}{
+		{
+			name: "read-write root",
+			want: "root=UUID=aaaa rw",
+		},
+		{
+			name:   "verity root",
+			verity: &VerityRoot{RootHash: "1234", HashUUID: "bbbb"},
+			want:   "root=/dev/mapper/root ro roothash=1234 systemd.verity_root_data=UUID=aaaa systemd.verity_root_hash=UUID=bbbb",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := strings.Join(rootKernelArgs("aaaa", tt.verity), " "); got != tt.want {
+				t.Errorf("rootKernelArgs() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSealRoot(t *testing.T) {
+	t.Run("remounts read-only and formats the hash partition", func(t *testing.T) {
+		mounts := setupFakeMounter(t, "")
+		fake := setupScriptedExecutor(t)
+		fake.answer("veritysetup format", veritysetupFormatOutput)
+
+		v, err := sealRoot(context.Background(), t.TempDir(), "/dev/sda2", "/dev/sda5")
+		if err != nil {
+			t.Fatalf("sealRoot() error = %v", err)
+		}
+		if mounts.flags&(unix.MS_REMOUNT|unix.MS_RDONLY) != unix.MS_REMOUNT|unix.MS_RDONLY {
+			t.Errorf("mount flags = %#x, want a read-only remount", mounts.flags)
+		}
+		if len(fake.Commands) != 1 || fake.Commands[0].String() != "veritysetup format /dev/sda2 /dev/sda5" {
+			t.Errorf("commands = %v", fake.Commands)
+		}
+		if v.RootHash == "" || v.HashUUID == "" {
+			t.Errorf("sealRoot() = %+v, want root hash and UUID", v)
+		}
+	})
+
+	t.Run("no hash partition", func(t *testing.T) {
+		if _, err := sealRoot(context.Background(), t.TempDir(), "/dev/sda2", ""); err == nil {
+			t.Error("sealRoot() expected error without a hash partition")
+		}
+	})
+}
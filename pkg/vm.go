@@ -0,0 +1,142 @@
+package pkg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// DefaultVMBootTimeout is how long a booted disk may take to reach a login
+// prompt
+const DefaultVMBootTimeout = 5 * time.Minute
+
+// DefaultVMMemory is the memory of the VM in MiB
+const DefaultVMMemory = 2048
+
+// VMBoot boots a disk image or block device headless under QEMU with UEFI
+// firmware and watches the serial console for a login prompt or systemd
+// target. The disk is opened with -snapshot, so it is never modified.
+type VMBoot struct {
+	Disk    string
+	Format  string // QEMU disk format, detected from the extension if empty
+	Timeout time.Duration
+	Memory  int      // MiB
+	Markers []string // Console output that counts as booted, bootMarkers if empty
+	Verbose bool     // Copy the console to stdout
+}
+
+// VMBootResult is the outcome of booting a disk
+type VMBootResult struct {
+	Disk     string        `json:"disk"`
+	Format   string        `json:"format"`
+	Booted   bool          `json:"booted"`
+	Marker   string        `json:"marker,omitempty"` // Console output that showed the system booted
+	Duration time.Duration `json:"duration"`
+	Detail   string        `json:"detail,omitempty"`  // Why the boot failed
+	Console  string        `json:"console,omitempty"` // Last console output of a failed boot
+}
+
+// Err returns an ErrBootFailed error if the disk did not boot
+func (r *VMBootResult) Err() error {
+	if r.Booted {
+		return nil
+	}
+	return fmt.Errorf("%w: %s", ErrBootFailed, r.Detail)
+}
+
+// NewVMBoot creates a VMBoot for disk with the default timeout and memory
+func NewVMBoot(disk string) *VMBoot {
+	return &VMBoot{
+		Disk:    disk,
+		Timeout: DefaultVMBootTimeout,
+		Memory:  DefaultVMMemory,
+	}
+}
+
+// qemuDiskFormat returns the QEMU format of the disk image at path by its
+// extension, raw for block devices and unknown extensions
+func qemuDiskFormat(path string) string {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".qcow2":
+		return "qcow2"
+	case ".vmdk":
+		return "vmdk"
+	case ".vhd":
+		return "vpc"
+	}
+	return "raw"
+}
+
+// CheckVMTools checks that QEMU and UEFI firmware for it are installed
+func CheckVMTools() error {
+	if _, err := executor.LookPath("qemu-system-x86_64"); err != nil {
+		return fmt.Errorf("%w: qemu-system-x86_64 - install the qemu-system-x86 package", ErrMissingTool)
+	}
+	if findOVMF() == "" {
+		return fmt.Errorf("%w: UEFI firmware for QEMU - install the ovmf or edk2-ovmf package", ErrMissingTool)
+	}
+	return nil
+}
+
+// qemuArgs returns the QEMU arguments that boot the disk with firmware
+func (v *VMBoot) qemuArgs(firmware, format string) []string {
+	args := []string{
+		"-m", fmt.Sprint(v.Memory),
+		"-nographic",
+		"-snapshot", // Leave the disk untouched
+		"-bios", firmware,
+		"-drive", "file=" + v.Disk + ",format=" + format + ",if=virtio",
+	}
+	if _, err := os.Stat("/dev/kvm"); err == nil {
+		args = append(args, "-enable-kvm", "-cpu", "host")
+	}
+	return args
+}
+
+// Run boots the disk and waits for a marker on the serial console until the
+// timeout. The returned error is only set when the disk could not be booted
+// at all; a failed boot is reported in the result.
+func (v *VMBoot) Run(ctx context.Context) (*VMBootResult, error) {
+	if _, err := os.Stat(v.Disk); err != nil {
+		return nil, fmt.Errorf("failed to open disk: %w", err)
+	}
+	if err := CheckVMTools(); err != nil {
+		return nil, err
+	}
+	format := v.Format
+	if format == "" {
+		format = qemuDiskFormat(v.Disk)
+	}
+	markers := v.Markers
+	if len(markers) == 0 {
+		markers = bootMarkers
+	}
+
+	fmt.Printf("Booting %s under QEMU (timeout %s)...\n", v.Disk, v.Timeout)
+	start := time.Now()
+	bootCtx, cancel := context.WithTimeout(ctx, v.Timeout)
+	defer cancel()
+
+	console := &markerWriter{markers: markers, onMatch: cancel, echo: v.Verbose}
+	err := executor.Run(bootCtx, Command{Name: "qemu-system-x86_64", Args: v.qemuArgs(findOVMF(), format), Stdout: console, Stderr: console})
+
+	result := &VMBootResult{Disk: v.Disk, Format: format, Duration: time.Since(start)}
+	switch {
+	case console.Matched() != "":
+		result.Booted = true
+		result.Marker = console.Matched()
+		return result, nil
+	case ctx.Err() != nil:
+		return nil, cancelled(ctx)
+	case errors.Is(bootCtx.Err(), context.DeadlineExceeded):
+		result.Detail = fmt.Sprintf("no login prompt within %s", v.Timeout)
+	default:
+		result.Detail = fmt.Sprintf("qemu exited before the system booted: %v", err)
+	}
+	result.Console = console.Tail()
+	return result, nil
+}
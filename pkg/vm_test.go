@@ -0,0 +1,43 @@
+package pkg
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestQemuDiskFormat(t *testing.T) {
+	tests := map[string]string{
+		"disk.raw":     "raw",
+		"disk.img":     "raw",
+		"/dev/loop0":   "raw",
+		"disk.QCOW2":   "qcow2",
+		"disk.vmdk":    "vmdk",
+		"azure/os.vhd": "vpc",
+	}
+	for path, want := range tests {
+		if got := qemuDiskFormat(path); got != want {
+			t.Errorf("qemuDiskFormat(%q) = %q, want %q", path, got, want)
+		}
+	}
+}
+
+func TestVMBootQemuArgs(t *testing.T) {
+	v := NewVMBoot("disk.qcow2")
+	args := strings.Join(v.qemuArgs("/usr/share/OVMF/OVMF_CODE.fd", "qcow2"), " ")
+	for _, want := range []string{"-m 2048", "-snapshot", "-bios /usr/share/OVMF/OVMF_CODE.fd", "-drive file=disk.qcow2,format=qcow2,if=virtio"} {
+		if !strings.Contains(args, want) {
+			t.Errorf("qemuArgs() = %q, missing %q", args, want)
+		}
+	}
+}
+
+func TestVMBootResultErr(t *testing.T) {
+	if err := (&VMBootResult{Booted: true}).Err(); err != nil {
+		t.Errorf("Err() = %v for a booted disk", err)
+	}
+	err := (&VMBootResult{Detail: "no login prompt within 5m0s"}).Err()
+	if !errors.Is(err, ErrBootFailed) || ErrorCode(err) != CodeBootFailed {
+		t.Errorf("Err() = %v, want ErrBootFailed", err)
+	}
+}
@@ -0,0 +1,193 @@
+package pkg
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Webhook formats
+const (
+	WebhookFormatJSON  = "json"  // WebhookPayload (default)
+	WebhookFormatSlack = "slack" // Slack incoming webhook message
+)
+
+// Webhook events, used to filter which outcomes a webhook receives
+const (
+	WebhookEventSuccess  = "success"  // An update wrote a new root
+	WebhookEventFailure  = "failure"  // An update failed, timed out or was cancelled
+	WebhookEventRollback = "rollback" // A rollback finished or failed
+)
+
+// webhookTimeout bounds each webhook request
+const webhookTimeout = 10 * time.Second
+
+// webhookClient sends webhook requests
+var webhookClient = &http.Client{Timeout: webhookTimeout}
+
+// WebhookConfig is a webhook configured in /etc/phukit/config.json
+type WebhookConfig struct {
+	URL     string            `json:"url"`
+	Format  string            `json:"format,omitempty"`  // json (default) or slack
+	Events  []string          `json:"events,omitempty"`  // success, failure, rollback; empty for all
+	Headers map[string]string `json:"headers,omitempty"` // e.g. Authorization
+}
+
+// WebhookPayload is the JSON body posted to json webhooks
+type WebhookPayload struct {
+	Event           string    `json:"event"` // success, failure or rollback
+	Host            string    `json:"host"`
+	Operation       string    `json:"operation"`
+	Status          string    `json:"status"`
+	ImageRef        string    `json:"image_ref,omitempty"`
+	ImageDigest     string    `json:"image_digest,omitempty"`
+	TargetSlot      string    `json:"target_slot,omitempty"`
+	DurationSeconds float64   `json:"duration_seconds"`
+	Error           string    `json:"error,omitempty"`
+	ErrorCode       string    `json:"error_code,omitempty"`
+	Time            time.Time `json:"time"`
+}
+
+// NotifyWebhooks posts the outcome of an update or rollback to the webhooks
+// in the system config. Like RecordMetrics it ignores dry runs, other
+// operations and declined confirmations, and also updates that found the
+// system up to date.
+func NotifyWebhooks(r *OperationResult) error {
+	if webhookEvent(r) == "" {
+		return nil
+	}
+	config, err := ReadSystemConfig()
+	if err != nil {
+		// Nothing to notify on a machine phukit did not install
+		return nil
+	}
+	return SendWebhooks(config.Webhooks, r)
+}
+
+// SendWebhooks posts the outcome of r to each webhook that wants its event.
+// Failing webhooks do not stop the others.
+func SendWebhooks(hooks []WebhookConfig, r *OperationResult) error {
+	event := webhookEvent(r)
+	if event == "" || len(hooks) == 0 {
+		return nil
+	}
+	host, _ := os.Hostname()
+	payload := WebhookPayload{
+		Event:           event,
+		Host:            host,
+		Operation:       r.Operation,
+		Status:          r.Status,
+		ImageRef:        r.ImageRef,
+		ImageDigest:     r.ImageDigest,
+		TargetSlot:      r.TargetSlot,
+		DurationSeconds: r.DurationSeconds,
+		Error:           r.Error,
+		ErrorCode:       r.ErrorCode,
+		Time:            r.EndTime,
+	}
+
+	var errs []error
+	for _, hook := range hooks {
+		if len(hook.Events) > 0 && !slices.Contains(hook.Events, event) {
+			continue
+		}
+		if err := sendWebhook(hook, payload); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// webhookEvent returns the webhook event of r, or "" if r is not notified
+func webhookEvent(r *OperationResult) string {
+	if r == nil || !r.changesSystem || r.ErrorCode == CodeAborted {
+		return ""
+	}
+	switch {
+	case r.Operation == OperationRollback:
+		return WebhookEventRollback
+	case r.Operation != OperationUpdate:
+		return ""
+	case r.Status != StatusSuccess:
+		return WebhookEventFailure
+	case r.TargetSlot != "":
+		return WebhookEventSuccess
+	}
+	return ""
+}
+
+// sendWebhook posts payload to hook in its format
+func sendWebhook(hook WebhookConfig, payload WebhookPayload) error {
+	u, err := url.Parse(hook.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return fmt.Errorf("invalid webhook URL %q", hook.URL)
+	}
+
+	var body any
+	switch hook.Format {
+	case "", WebhookFormatJSON:
+		body = payload
+	case WebhookFormatSlack:
+		body = map[string]string{"text": slackMessage(payload)}
+	default:
+		return fmt.Errorf("unsupported webhook format %q for %s (supported: json, slack)", hook.Format, u.Redacted())
+	}
+	data, err := json.Marshal(body)
+	if err != nil {
+		return fmt.Errorf("failed to marshal webhook payload: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), webhookTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, hook.URL, bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("failed to create webhook request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "phukit")
+	for k, v := range hook.Headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send webhook to %s: %w", u.Redacted(), err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook %s returned %s", u.Redacted(), resp.Status)
+	}
+	return nil
+}
+
+// slackMessage formats payload as the text of a Slack message
+func slackMessage(p WebhookPayload) string {
+	outcome := "succeeded"
+	if p.Status != StatusSuccess {
+		outcome = p.Status
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "*%s*: %s %s", p.Host, p.Operation, outcome)
+	if p.ImageRef != "" {
+		fmt.Fprintf(&b, "\nImage: `%s`", p.ImageRef)
+	}
+	if p.ImageDigest != "" {
+		fmt.Fprintf(&b, "\nDigest: `%s`", p.ImageDigest)
+	}
+	if p.TargetSlot != "" {
+		fmt.Fprintf(&b, "\nNext boot: %s", p.TargetSlot)
+	}
+	fmt.Fprintf(&b, "\nDuration: %s", (time.Duration(p.DurationSeconds * float64(time.Second))).Round(time.Second))
+	if p.Error != "" {
+		fmt.Fprintf(&b, "\nError (%s): %s", p.ErrorCode, p.Error)
+	}
+	return b.String()
+}
@@ -0,0 +1,131 @@
+package pkg
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// webhookRecorder is a test server that records webhook requests
+type webhookRecorder struct {
+	mu       sync.Mutex
+	bodies   []map[string]any
+	headers  []http.Header
+	failWith int
+}
+
+func newWebhookRecorder(t *testing.T) (*webhookRecorder, *httptest.Server) {
+	t.Helper()
+	rec := &webhookRecorder{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]any
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		rec.mu.Lock()
+		defer rec.mu.Unlock()
+		rec.bodies = append(rec.bodies, body)
+		rec.headers = append(rec.headers, r.Header.Clone())
+		if rec.failWith != 0 {
+			w.WriteHeader(rec.failWith)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return rec, server
+}
+
+func TestWebhookEvent(t *testing.T) {
+	tests := []struct {
+		name   string
+		result *OperationResult
+		want   string
+	}{
+		{"nil", nil, ""},
+		{"dry run", &OperationResult{Operation: OperationUpdate, Status: StatusSuccess, TargetSlot: "root2"}, ""},
+		{"update", &OperationResult{Operation: OperationUpdate, Status: StatusSuccess, TargetSlot: "root2", changesSystem: true}, WebhookEventSuccess},
+		{"up to date", &OperationResult{Operation: OperationUpdate, Status: StatusSuccess, changesSystem: true}, ""},
+		{"failed update", &OperationResult{Operation: OperationUpdate, Status: StatusTimeout, changesSystem: true}, WebhookEventFailure},
+		{"declined", &OperationResult{Operation: OperationUpdate, Status: StatusFailed, ErrorCode: CodeAborted, changesSystem: true}, ""},
+		{"rollback", &OperationResult{Operation: OperationRollback, Status: StatusFailed, changesSystem: true}, WebhookEventRollback},
+		{"install", &OperationResult{Operation: OperationInstall, Status: StatusSuccess, changesSystem: true}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := webhookEvent(tt.result); got != tt.want {
+				t.Errorf("webhookEvent() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSendWebhooks(t *testing.T) {
+	jsonHook, jsonServer := newWebhookRecorder(t)
+	slackHook, slackServer := newWebhookRecorder(t)
+	filtered, filteredServer := newWebhookRecorder(t)
+
+	hooks := []WebhookConfig{
+		{URL: jsonServer.URL, Headers: map[string]string{"Authorization": "Bearer abc"}},
+		{URL: slackServer.URL, Format: WebhookFormatSlack},
+		{URL: filteredServer.URL, Events: []string{WebhookEventFailure}},
+	}
+	result := &OperationResult{
+		Operation: OperationUpdate, Status: StatusSuccess,
+		ImageRef: "quay.io/example/os:latest", ImageDigest: "sha256:abc",
+		TargetSlot: "root2", DurationSeconds: 95, EndTime: time.Now(), changesSystem: true,
+	}
+	if err := SendWebhooks(hooks, result); err != nil {
+		t.Fatalf("SendWebhooks() error = %v", err)
+	}
+
+	if len(jsonHook.bodies) != 1 {
+		t.Fatalf("json webhook got %d requests, want 1", len(jsonHook.bodies))
+	}
+	body := jsonHook.bodies[0]
+	if body["event"] != WebhookEventSuccess || body["image_digest"] != "sha256:abc" || body["duration_seconds"] != 95.0 || body["host"] == "" {
+		t.Errorf("json payload = %v", body)
+	}
+	if got := jsonHook.headers[0].Get("Authorization"); got != "Bearer abc" {
+		t.Errorf("Authorization = %q, want the configured header", got)
+	}
+
+	if len(slackHook.bodies) != 1 {
+		t.Fatalf("slack webhook got %d requests, want 1", len(slackHook.bodies))
+	}
+	text, _ := slackHook.bodies[0]["text"].(string)
+	if !strings.Contains(text, "update succeeded") || !strings.Contains(text, "Duration: 1m35s") {
+		t.Errorf("slack text = %q", text)
+	}
+
+	if len(filtered.bodies) != 0 {
+		t.Errorf("failure-only webhook got %d requests for a success, want 0", len(filtered.bodies))
+	}
+}
+
+func TestSendWebhooksReportsFailures(t *testing.T) {
+	failing, failingServer := newWebhookRecorder(t)
+	failing.failWith = http.StatusInternalServerError
+	ok, okServer := newWebhookRecorder(t)
+
+	hooks := []WebhookConfig{
+		{URL: failingServer.URL},
+		{URL: "ftp://example.com/hook"},
+		{URL: okServer.URL, Format: "xml"},
+		{URL: okServer.URL},
+	}
+	result := &OperationResult{Operation: OperationRollback, Status: StatusSuccess, changesSystem: true}
+	err := SendWebhooks(hooks, result)
+	if err == nil {
+		t.Fatal("SendWebhooks() succeeded, want error")
+	}
+	for _, want := range []string{"500", "invalid webhook URL", "unsupported webhook format"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q does not mention %q", err, want)
+		}
+	}
+	// A failing webhook does not stop the others
+	if len(ok.bodies) != 1 {
+		t.Errorf("working webhook got %d requests, want 1", len(ok.bodies))
+	}
+}
@@ -0,0 +1,166 @@
+package pkg
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// Wipe modes
+//
+// By default an install only removes the signatures and partition tables on
+// the disk, which is fast but leaves the old data readable. For
+// decommissioning and reprovisioning, the disk can be erased before that:
+// discard tells the device that every block is free, which most SSDs turn
+// into reading zeroes but which is not guaranteed; zero writes zeroes over
+// the whole disk, offloaded to the device where it supports it; and
+// secure-erase has an NVMe controller erase the user data of the namespace,
+// including blocks the host cannot reach such as remapped ones. Controllers
+// that can only erase all of their namespaces at once are refused when they
+// have more than one.
+
+// WipeMode is how a disk is wiped before it is partitioned
+type WipeMode string
+
+const (
+	WipeSignatures  WipeMode = "signatures"   // Remove filesystem signatures and partition tables (default)
+	WipeDiscard     WipeMode = "discard"      // Discard every block
+	WipeZero        WipeMode = "zero"         // Write zeroes over the whole disk
+	WipeSecureErase WipeMode = "secure-erase" // NVMe secure erase of the user data
+)
+
+// wipeModes are the supported wipe modes
+var wipeModes = []WipeMode{WipeSignatures, WipeDiscard, WipeZero, WipeSecureErase}
+
+// ParseWipeMode parses a wipe mode name; "" is the default
+func ParseWipeMode(name string) (WipeMode, error) {
+	if name == "" {
+		return WipeSignatures, nil
+	}
+	for _, mode := range wipeModes {
+		if string(mode) == name {
+			return mode, nil
+		}
+	}
+	names := make([]string, len(wipeModes))
+	for i, mode := range wipeModes {
+		names[i] = string(mode)
+	}
+	return "", fmt.Errorf("unknown wipe mode %q (supported: %s)", name, strings.Join(names, ", "))
+}
+
+// eraseCommand returns the command that erases the data on device for mode,
+// and false for WipeSignatures, which erases nothing
+func eraseCommand(device string, mode WipeMode) (Command, bool) {
+	switch mode {
+	case WipeDiscard:
+		return Command{Name: "blkdiscard", Args: []string{"--force", device}}, true
+	case WipeZero:
+		return Command{Name: "blkdiscard", Args: []string{"--zeroout", "--force", device}}, true
+	case WipeSecureErase:
+		// Without a namespace ID nvme-cli may format every namespace
+		nsid, _ := nvmeNamespaceID(device)
+		return Command{Name: "nvme", Args: []string{"format", device, "--namespace-id=" + nsid, "--ses=1", "--force"}}, true
+	}
+	return Command{}, false
+}
+
+// nvmeNamespaceName matches the block device name of an NVMe namespace,
+// capturing the controller and namespace numbers
+var nvmeNamespaceName = regexp.MustCompile(`^nvme(\d+)n(\d+)$`)
+
+// nvmeFormatAllNamespaces are the FNA bits of the identify controller data
+// saying a format, or a secure erase, applies to all namespaces
+const nvmeFormatAllNamespaces = 0x3
+
+// nvmeNamespaceID returns the namespace ID of the NVMe namespace device
+func nvmeNamespaceID(device string) (string, error) {
+	name := blockDeviceName(device)
+	if nsid := readSysfsValue(filepath.Join(sysClassBlockPath, name, "nsid")); nsid != "" {
+		return nsid, nil
+	}
+	if m := nvmeNamespaceName.FindStringSubmatch(name); m != nil {
+		return m[2], nil
+	}
+	return "", fmt.Errorf("%s is not an NVMe namespace", device)
+}
+
+// nvmeControllerNamespaces returns the namespaces of the controller of the
+// NVMe namespace name
+func nvmeControllerNamespaces(name string) ([]string, error) {
+	m := nvmeNamespaceName.FindStringSubmatch(name)
+	if m == nil {
+		return nil, fmt.Errorf("%s is not an NVMe namespace", name)
+	}
+	entries, err := os.ReadDir(sysClassBlockPath)
+	if err != nil {
+		return nil, err
+	}
+	var namespaces []string
+	for _, e := range entries {
+		if n := nvmeNamespaceName.FindStringSubmatch(e.Name()); n != nil && n[1] == m[1] {
+			namespaces = append(namespaces, e.Name())
+		}
+	}
+	return namespaces, nil
+}
+
+// checkSecureEraseScope refuses to secure erase device if the controller
+// erases all of its namespaces at once and device is not the only one
+func checkSecureEraseScope(ctx context.Context, device string) error {
+	if _, err := nvmeNamespaceID(device); err != nil {
+		return err
+	}
+	var out, stderr bytes.Buffer
+	if err := executor.Run(ctx, Command{Name: "nvme", Args: []string{"id-ctrl", device, "--output-format=json"}, Stdout: &out, Stderr: &stderr}); err != nil {
+		return fmt.Errorf("failed to identify the controller of %s: %w\nOutput: %s", device, err, strings.TrimSpace(stderr.String()))
+	}
+	var ctrl struct {
+		FNA uint `json:"fna"`
+	}
+	if err := json.Unmarshal(out.Bytes(), &ctrl); err != nil {
+		return fmt.Errorf("failed to parse the controller data of %s: %w", device, err)
+	}
+	if ctrl.FNA&nvmeFormatAllNamespaces == 0 {
+		return nil
+	}
+	namespaces, err := nvmeControllerNamespaces(blockDeviceName(device))
+	if err != nil {
+		return err
+	}
+	if len(namespaces) > 1 {
+		return fmt.Errorf("the controller of %s secure erases all of its namespaces (%s) at once; use --wipe-mode zero",
+			device, strings.Join(namespaces, ", "))
+	}
+	return nil
+}
+
+// checkWipeMode checks that device can be wiped with mode, that its tools
+// are installed and, for a secure erase, that it erases no other namespace
+func checkWipeMode(ctx context.Context, device string, mode WipeMode) error {
+	switch mode {
+	case WipeSignatures:
+		return nil
+	case WipeDiscard:
+		if !supportsDiscard(device) {
+			return fmt.Errorf("%s does not support discard; use --wipe-mode zero", device)
+		}
+	case WipeSecureErase:
+		if !strings.HasPrefix(blockDeviceName(device), "nvme") {
+			return fmt.Errorf("secure erase is only supported on NVMe disks, not %s; use --wipe-mode zero", device)
+		}
+		if _, err := executor.LookPath("nvme"); err != nil {
+			return fmt.Errorf("%w: nvme - install the nvme-cli package", ErrMissingTool)
+		}
+		return checkSecureEraseScope(ctx, device)
+	}
+	if _, err := executor.LookPath("blkdiscard"); err != nil {
+		return fmt.Errorf("%w: blkdiscard - install the util-linux package", ErrMissingTool)
+	}
+	return nil
+}
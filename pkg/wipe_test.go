@@ -0,0 +1,104 @@
+package pkg
+
+import (
+	"errors"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestParseWipeMode(t *testing.T) {
+	for name, want := range map[string]WipeMode{
+		"":             WipeSignatures,
+		"signatures":   WipeSignatures,
+		"discard":      WipeDiscard,
+		"zero":         WipeZero,
+		"secure-erase": WipeSecureErase,
+	} {
+		if got, err := ParseWipeMode(name); err != nil || got != want {
+			t.Errorf("ParseWipeMode(%q) = %q, %v, want %q", name, got, err, want)
+		}
+	}
+	if _, err := ParseWipeMode("shred"); err == nil {
+		t.Error("ParseWipeMode(shred) error = nil, want error")
+	}
+}
+
+func TestEraseCommand(t *testing.T) {
+	oldClass := sysClassBlockPath
+	sysClassBlockPath = t.TempDir()
+	t.Cleanup(func() { sysClassBlockPath = oldClass })
+	writeFakeFile(t, filepath.Join(sysClassBlockPath, "nvme0n2", "nsid"), "2\n")
+
+	tests := map[WipeMode]string{
+		WipeDiscard:     "blkdiscard --force /dev/nvme0n2",
+		WipeZero:        "blkdiscard --zeroout --force /dev/nvme0n2",
+		WipeSecureErase: "nvme format /dev/nvme0n2 --namespace-id=2 --ses=1 --force",
+	}
+	for mode, want := range tests {
+		cmd, ok := eraseCommand("/dev/nvme0n2", mode)
+		if !ok || cmd.String() != want {
+			t.Errorf("eraseCommand(%s) = %q, %v, want %q", mode, cmd, ok, want)
+		}
+	}
+	for _, mode := range []WipeMode{"", WipeSignatures} {
+		if _, ok := eraseCommand("/dev/sda", mode); ok {
+			t.Errorf("eraseCommand(%q) erases, want nothing", mode)
+		}
+	}
+	// Without the sysfs attribute the ID comes from the device name
+	if cmd, _ := eraseCommand("/dev/nvme1n3", WipeSecureErase); !slices.Contains(cmd.Args, "--namespace-id=3") {
+		t.Errorf("eraseCommand(/dev/nvme1n3) = %q, want namespace 3", cmd)
+	}
+}
+
+func TestCheckWipeMode(t *testing.T) {
+	fake := setupScriptedExecutor(t)
+	fake.answer("nvme id-ctrl", `{"vid":5197,"fna":0}`)
+	oldClass := sysClassBlockPath
+	sysClassBlockPath = t.TempDir()
+	t.Cleanup(func() { sysClassBlockPath = oldClass })
+	writeFakeFile(t, filepath.Join(sysClassBlockPath, "nvme0n1", "queue", "discard_max_bytes"), "2199023255040\n")
+	writeFakeFile(t, filepath.Join(sysClassBlockPath, "sda", "queue", "discard_max_bytes"), "0\n")
+
+	valid := []struct {
+		device string
+		mode   WipeMode
+	}{
+		{"/dev/sda", WipeSignatures},
+		{"/dev/sda", WipeZero},
+		{"/dev/nvme0n1", WipeDiscard},
+		{"/dev/nvme0n1", WipeSecureErase},
+	}
+	for _, tt := range valid {
+		if err := checkWipeMode(t.Context(), tt.device, tt.mode); err != nil {
+			t.Errorf("checkWipeMode(%s, %s) error = %v", tt.device, tt.mode, err)
+		}
+	}
+	for _, mode := range []WipeMode{WipeDiscard, WipeSecureErase} {
+		if err := checkWipeMode(t.Context(), "/dev/sda", mode); err == nil {
+			t.Errorf("checkWipeMode(/dev/sda, %s) error = nil, want error", mode)
+		}
+	}
+
+	// A controller that erases all namespaces at once is only used for its
+	// only namespace
+	fake.answer("nvme id-ctrl", `{"vid":5197,"fna":4}`)
+	writeFakeFile(t, filepath.Join(sysClassBlockPath, "nvme0n2", "nsid"), "2\n")
+	writeFakeFile(t, filepath.Join(sysClassBlockPath, "nvme1n1", "nsid"), "1\n")
+	if err := checkWipeMode(t.Context(), "/dev/nvme0n1", WipeSecureErase); err != nil {
+		t.Errorf("checkWipeMode() error = %v with per-namespace erase", err)
+	}
+	fake.answer("nvme id-ctrl", `{"vid":5197,"fna":6}`)
+	if err := checkWipeMode(t.Context(), "/dev/nvme0n1", WipeSecureErase); err == nil || !strings.Contains(err.Error(), "nvme0n1, nvme0n2") {
+		t.Errorf("checkWipeMode() error = %v, want the namespaces of the controller", err)
+	}
+	if err := checkWipeMode(t.Context(), "/dev/nvme1n1", WipeSecureErase); err != nil {
+		t.Errorf("checkWipeMode() error = %v for the only namespace", err)
+	}
+	fake.fail("nvme id-ctrl", errors.New("exit status 1"))
+	if err := checkWipeMode(t.Context(), "/dev/nvme1n1", WipeSecureErase); err == nil {
+		t.Error("checkWipeMode() error = nil when the controller cannot be identified")
+	}
+}